@@ -17,6 +17,7 @@ package client
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
@@ -35,6 +36,7 @@ var (
 	ErrTooManyRedirects      = errors.New("client: too many redirects")
 	ErrClusterUnavailable    = errors.New("client: etcd cluster is unavailable or misconfigured")
 	errTooManyRedirectChecks = errors.New("client: too many redirect checks")
+	errStreamUnsupported     = errors.New("client: streaming is not supported")
 )
 
 var DefaultRequestTimeout = 5 * time.Second
@@ -186,6 +188,15 @@ type httpClient interface {
 	Do(context.Context, httpAction) (*http.Response, []byte, error)
 }
 
+// httpStreamClient is implemented by the httpClients which can return
+// the body of a response while it is still being received.
+type httpStreamClient interface {
+	// DoStream is like Do, but returns the body of the response unread.
+	// The caller must close the body. It is closed when the given
+	// context is done.
+	DoStream(context.Context, httpAction) (*http.Response, io.ReadCloser, error)
+}
+
 func newHTTPClientFactory(tr CancelableTransport, cr CheckRedirectFunc, headerTimeout time.Duration) httpClientFactory {
 	return func(ep url.URL) httpClient {
 		return &redirectFollowingHTTPClient{
@@ -241,6 +252,44 @@ func (c *httpClusterClient) reset(eps []string) error {
 }
 
 func (c *httpClusterClient) Do(ctx context.Context, act httpAction) (*http.Response, []byte, error) {
+	var body []byte
+	resp, err := c.do(ctx, act, func(hc httpClient, action httpAction) (resp *http.Response, err error) {
+		resp, body, err = hc.Do(ctx, action)
+		return resp, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
+func (c *httpClusterClient) DoStream(ctx context.Context, act httpAction) (*http.Response, io.ReadCloser, error) {
+	var body io.ReadCloser
+	resp, err := c.do(ctx, act, func(hc httpClient, action httpAction) (*http.Response, error) {
+		sc, ok := hc.(httpStreamClient)
+		if !ok {
+			return nil, errStreamUnsupported
+		}
+		resp, b, err := sc.DoStream(ctx, action)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode/100 == 5 {
+			// the next endpoint is tried
+			b.Close()
+		}
+		body = b
+		return resp, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
+// do sends the given action to the endpoints with the given function,
+// starting from the pinned one, until one of them does not fail.
+func (c *httpClusterClient) do(ctx context.Context, act httpAction, f func(httpClient, httpAction) (*http.Response, error)) (*http.Response, error) {
 	action := act
 	c.RLock()
 	leps := len(c.endpoints)
@@ -257,27 +306,26 @@ func (c *httpClusterClient) Do(ctx context.Context, act httpAction) (*http.Respo
 	c.RUnlock()
 
 	if leps == 0 {
-		return nil, nil, ErrNoEndpoints
+		return nil, ErrNoEndpoints
 	}
 
 	if leps != n {
-		return nil, nil, errors.New("unable to pick endpoint: copy failed")
+		return nil, errors.New("unable to pick endpoint: copy failed")
 	}
 
 	var resp *http.Response
-	var body []byte
 	var err error
 	cerr := &ClusterError{}
 
 	for i := pinned; i < leps+pinned; i++ {
 		k := i % leps
 		hc := c.clientFactory(eps[k])
-		resp, body, err = hc.Do(ctx, action)
+		resp, err = f(hc, action)
 		if err != nil {
 			cerr.Errors = append(cerr.Errors, err)
 			// mask previous errors with context error, which is controlled by user
 			if err == context.Canceled || err == context.DeadlineExceeded {
-				return nil, nil, err
+				return nil, err
 			}
 			continue
 		}
@@ -296,10 +344,10 @@ func (c *httpClusterClient) Do(ctx context.Context, act httpAction) (*http.Respo
 			c.pinned = k
 			c.Unlock()
 		}
-		return resp, body, nil
+		return resp, nil
 	}
 
-	return nil, nil, cerr
+	return nil, cerr
 }
 
 func (c *httpClusterClient) Endpoints() []string {
@@ -372,10 +420,46 @@ type simpleHTTPClient struct {
 }
 
 func (c *simpleHTTPClient) Do(ctx context.Context, act httpAction) (*http.Response, []byte, error) {
+	resp, err := c.roundTrip(ctx, act)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	var body []byte
+	done := make(chan struct{})
+	go func() {
+		body, err = ioutil.ReadAll(resp.Body)
+		done <- struct{}{}
+	}()
+
+	select {
+	case <-ctx.Done():
+		resp.Body.Close()
+		<-done
+		return nil, nil, ctx.Err()
+	case <-done:
+	}
+
+	return resp, body, err
+}
+
+func (c *simpleHTTPClient) DoStream(ctx context.Context, act httpAction) (*http.Response, io.ReadCloser, error) {
+	resp, err := c.roundTrip(ctx, act)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, newStreamBody(ctx, resp.Body), nil
+}
+
+// roundTrip sends the request of the given action and waits for the
+// headers of the response. The caller must close the body of the
+// returned response.
+func (c *simpleHTTPClient) roundTrip(ctx context.Context, act httpAction) (*http.Response, error) {
 	req := act.HTTPRequest(c.endpoint)
 
 	if err := printcURL(req); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	hctx, hcancel := context.WithCancel(ctx)
@@ -414,34 +498,44 @@ func (c *simpleHTTPClient) Do(ctx context.Context, act httpAction) (*http.Respon
 		}
 	}
 
-	// always check for resp nil-ness to deal with possible
-	// race conditions between channels above
-	defer func() {
+	if err != nil {
+		// always check for resp nil-ness to deal with possible
+		// race conditions between channels above
 		if resp != nil {
 			resp.Body.Close()
 		}
-	}()
-
-	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	return resp, nil
+}
 
-	var body []byte
-	done := make(chan struct{})
+// streamBody is the body of a streamed response, which is closed
+// when the context of the request is done.
+type streamBody struct {
+	io.ReadCloser
+	stopc chan struct{}
+	once  sync.Once
+	err   error
+}
+
+func newStreamBody(ctx context.Context, rc io.ReadCloser) *streamBody {
+	b := &streamBody{ReadCloser: rc, stopc: make(chan struct{})}
 	go func() {
-		body, err = ioutil.ReadAll(resp.Body)
-		done <- struct{}{}
+		select {
+		case <-ctx.Done():
+			b.Close()
+		case <-b.stopc:
+		}
 	}()
+	return b
+}
 
-	select {
-	case <-ctx.Done():
-		resp.Body.Close()
-		<-done
-		return nil, nil, ctx.Err()
-	case <-done:
-	}
-
-	return resp, body, err
+func (b *streamBody) Close() error {
+	b.once.Do(func() {
+		close(b.stopc)
+		b.err = b.ReadCloser.Close()
+	})
+	return b.err
 }
 
 type authedAction struct {
@@ -493,6 +587,44 @@ func (r *redirectFollowingHTTPClient) Do(ctx context.Context, act httpAction) (*
 	return nil, nil, errTooManyRedirectChecks
 }
 
+func (r *redirectFollowingHTTPClient) DoStream(ctx context.Context, act httpAction) (*http.Response, io.ReadCloser, error) {
+	sc, ok := r.client.(httpStreamClient)
+	if !ok {
+		return nil, nil, errStreamUnsupported
+	}
+	next := act
+	for i := 0; i < 100; i++ {
+		if i > 0 {
+			if err := r.checkRedirect(i); err != nil {
+				return nil, nil, err
+			}
+		}
+		resp, body, err := sc.DoStream(ctx, next)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode/100 == 3 {
+			body.Close()
+			hdr := resp.Header.Get("Location")
+			if hdr == "" {
+				return nil, nil, fmt.Errorf("Location header not set")
+			}
+			loc, err := url.Parse(hdr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Location header not valid URL: %s", hdr)
+			}
+			next = &redirectedHTTPAction{
+				action:   act,
+				location: *loc,
+			}
+			continue
+		}
+		return resp, body, nil
+	}
+
+	return nil, nil, errTooManyRedirectChecks
+}
+
 type redirectedHTTPAction struct {
 	action   httpAction
 	location url.URL
@@ -51,13 +51,13 @@ func (x *Response) CodecEncodeSelf(e *codec1978.Encoder) {
 		yysep1 := !z.EncBinary()
 		yy2arr1 := z.EncBasicHandle().StructToArray
 		var yyfirst1 bool
-		var yyq1 [3]bool
+		var yyq1 [4]bool
 		_, _, _, _ = yysep1, yyfirst1, yyq1, yy2arr1
 		const yyr1 bool = false
 		if yyr1 || yy2arr1 {
-			r.EncodeArrayStart(3)
+			r.EncodeArrayStart(4)
 		} else {
-			var yynn1 int = 3
+			var yynn1 int = 4
 			for _, b := range yyq1 {
 				if b {
 					yynn1++
@@ -125,6 +125,23 @@ func (x *Response) CodecEncodeSelf(e *codec1978.Encoder) {
 				x.PrevNode.CodecEncodeSelf(e)
 			}
 		}
+		if yyr1 || yy2arr1 {
+			if yysep1 {
+				r.EncodeArrayEntrySeparator()
+			}
+			r.EncodeString(codecSelferC_UTF81819, string(x.Next))
+		} else {
+			if yyfirst1 {
+				r.EncodeMapEntrySeparator()
+			} else {
+				yyfirst1 = true
+			}
+			r.EncodeString(codecSelferC_UTF81819, string("next"))
+			if yysep1 {
+				r.EncodeMapKVSeparator()
+			}
+			r.EncodeString(codecSelferC_UTF81819, string(x.Next))
+		}
 		if yysep1 {
 			if yyr1 || yy2arr1 {
 				r.EncodeArrayEnd()
@@ -212,6 +229,12 @@ func (x *Response) codecDecodeSelfFromMap(l int, d *codec1978.Decoder) {
 				}
 				x.PrevNode.CodecDecodeSelf(d)
 			}
+		case "next":
+			if r.TryDecodeAsNil() {
+				x.Next = ""
+			} else {
+				x.Next = string(r.DecodeString())
+			}
 		default:
 			z.DecStructFieldNotFound(-1, yys6)
 		} // end switch yys6
@@ -285,6 +308,22 @@ func (x *Response) codecDecodeSelfFromArray(l int, d *codec1978.Decoder) {
 		}
 		x.PrevNode.CodecDecodeSelf(d)
 	}
+	yyj10++
+	if yyhl10 {
+		yyb10 = yyj10 > l
+	} else {
+		yyb10 = r.CheckBreak()
+	}
+	if yyb10 {
+		r.ReadArrayEnd()
+		return
+	}
+	r.ReadArrayEntrySeparator()
+	if r.TryDecodeAsNil() {
+		x.Next = ""
+	} else {
+		x.Next = string(r.DecodeString())
+	}
 	for {
 		yyj10++
 		if yyhl10 {
@@ -17,9 +17,13 @@ package client
 //go:generate codecgen -r "Node|Response|Nodes" -o keys.generated.go keys.go
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -41,14 +45,17 @@ const (
 	ErrorCodeDirNotEmpty  = 108
 	ErrorCodeUnauthorized = 110
 
-	ErrorCodePrevValueRequired = 201
-	ErrorCodeTTLNaN            = 202
-	ErrorCodeIndexNaN          = 203
-	ErrorCodeInvalidField      = 209
-	ErrorCodeInvalidForm       = 210
+	ErrorCodePrevValueRequired  = 201
+	ErrorCodeTTLNaN             = 202
+	ErrorCodeIndexNaN           = 203
+	ErrorCodeInvalidField       = 209
+	ErrorCodeInvalidForm        = 210
+	ErrorCodeRefreshValue       = 211
+	ErrorCodeRefreshTTLRequired = 212
 
-	ErrorCodeRaftInternal = 300
-	ErrorCodeLeaderElect  = 301
+	ErrorCodeRaftInternal    = 300
+	ErrorCodeLeaderElect     = 301
+	ErrorCodeTooManyRequests = 302
 
 	ErrorCodeWatcherCleared    = 400
 	ErrorCodeEventIndexCleared = 401
@@ -68,6 +75,9 @@ func (e Error) Error() string {
 var (
 	ErrInvalidJSON = errors.New("client: response is invalid json. The endpoint is probably not valid etcd cluster endpoint.")
 	ErrEmptyBody   = errors.New("client: response body is empty")
+	// ErrWatcherClosed is returned by the Next method of a streaming
+	// Watcher which was closed.
+	ErrWatcherClosed = errors.New("client: watcher is closed")
 )
 
 // PrevExistType is used to define an existence condition when setting
@@ -80,8 +90,17 @@ const (
 	PrevNoExist = PrevExistType("false")
 )
 
+// TxnActionType is the type of write performed by a TxnOp.
+type TxnActionType string
+
+const (
+	TxnSet    = TxnActionType("set")
+	TxnDelete = TxnActionType("delete")
+)
+
 var (
 	defaultV2KeysPrefix = "/v2/keys"
+	defaultV2TxnPrefix  = "/v2/txn"
 )
 
 // NewKeysAPI builds a KeysAPI that interacts with etcd's key-value
@@ -123,6 +142,11 @@ type KeysAPI interface {
 	// Update is an alias for Set w/ PrevExist=true
 	Update(ctx context.Context, key, value string) (*Response, error)
 
+	// Txn atomically applies all the given ops if, and only if, all the
+	// given compares succeed. All the ops are applied at the same
+	// index, and the returned TxnResponse holds one Response per op.
+	Txn(ctx context.Context, compares []TxnCompare, ops []TxnOp) (*TxnResponse, error)
+
 	// Watcher builds a new Watcher targeted at a specific Node identified
 	// by the given key. The Watcher may be configured at creation time
 	// through a WatcherOptions object. The returned Watcher is designed
@@ -145,6 +169,37 @@ type WatcherOptions struct {
 	// to false (default), events will be limited to those that
 	// occur for the exact key.
 	Recursive bool
+
+	// Actions limits the events emitted by the Watcher to those
+	// with one of the given actions, e.g. "set" or "expire". If
+	// empty (default), events of any action are emitted.
+	Actions []string
+
+	// KeyGlob limits the events emitted by the Watcher to those
+	// whose key matches the given pattern, relative to the watched
+	// key, in the syntax of path.Match. For example, "*/health"
+	// matches "/services/a/health" when watching "/services".
+	KeyGlob string
+
+	// OmitPrevNode instructs the server not to send the PrevNode of
+	// the events emitted by the Watcher.
+	OmitPrevNode bool
+
+	// OmitValue instructs the server not to send the values of the
+	// nodes of the events emitted by the Watcher.
+	OmitValue bool
+
+	// Stream instructs the Watcher to receive the events over a
+	// single long-lived connection, instead of sending one request
+	// per event. If the connection is lost, the Watcher reconnects,
+	// backing off if the connections keep ending without any event,
+	// and resumes from the last event or progress notification it
+	// received. The connection is closed when the context given to
+	// Next is cancelled. The connection is otherwise kept open between
+	// the calls to Next, so the returned Watcher also implements
+	// io.Closer, whose Close must be called once the Watcher is no
+	// longer used.
+	Stream bool
 }
 
 type CreateInOrderOptions struct {
@@ -186,6 +241,12 @@ type SetOptions struct {
 
 	// Dir specifies whether or not this Node should be created as a directory.
 	Dir bool
+
+	// Refresh set to true means a TTL value can be updated
+	// without firing a watch or changing the node value. The
+	// Node must already exist, a TTL must be provided and the
+	// value given to Set is ignored.
+	Refresh bool
 }
 
 type GetOptions struct {
@@ -204,6 +265,17 @@ type GetOptions struct {
 	// has been applied in quorum of members, which ensures external
 	// consistency (or linearizability).
 	Quorum bool
+
+	// Limit defines the maximum number of children of a directory to
+	// return. If Limit is set, the children are always sorted by key, and
+	// the key of the first child left out is returned in Response.Next.
+	// If Limit is set to 0 (default), all children are returned.
+	Limit uint64
+
+	// StartKey instructs the server to only return the children of a
+	// directory whose key is not less than StartKey, sorted by key. It is
+	// usually set to the Response.Next of the previous page.
+	StartKey string
 }
 
 type DeleteOptions struct {
@@ -232,6 +304,70 @@ type DeleteOptions struct {
 	Dir bool
 }
 
+type TxnCompare struct {
+	// Key identifies the Node the compare is made against.
+	Key string
+
+	// PrevValue specifies what the current value of the Node must
+	// be in order for the Txn to succeed.
+	//
+	// Leaving this field empty means that the caller wishes to
+	// ignore the current value of the Node. This cannot be used
+	// to compare the Node's current value to an empty string.
+	PrevValue string
+
+	// PrevIndex indicates what the current ModifiedIndex of the
+	// Node must be in order for the Txn to succeed.
+	//
+	// If PrevIndex is set to 0 (default), no comparison is made.
+	PrevIndex uint64
+
+	// PrevExist specifies whether the Node must currently exist
+	// (PrevExist) or not (PrevNoExist). If the caller does not
+	// care about existence, set PrevExist to PrevIgnore, or simply
+	// leave it unset.
+	PrevExist PrevExistType
+}
+
+type TxnOp struct {
+	// Action is either TxnSet or TxnDelete.
+	Action TxnActionType
+
+	// Key identifies the Node to set or delete. The keys of the ops
+	// of a Txn must not overlap, that is, no key can be equal to or
+	// under the key of another op.
+	Key string
+
+	// Value is the value a TxnSet op assigns to the Node. It is
+	// ignored if Dir=true.
+	Value string
+
+	// TTL defines a period of time after-which the Node set by a
+	// TxnSet op should expire and no longer exist. Values <= 0 are
+	// ignored.
+	TTL time.Duration
+
+	// Dir specifies whether or not this Node should be created or
+	// removed as a directory.
+	Dir bool
+
+	// Recursive defines whether or not all children of the Node
+	// should be deleted by a TxnDelete op.
+	Recursive bool
+}
+
+type TxnResponse struct {
+	// Action is always "txn".
+	Action string `json:"action"`
+
+	// Responses holds the result of each op of the Txn, in the order
+	// the ops were given.
+	Responses []*Response `json:"events"`
+
+	// Index holds the cluster-level index at which the Txn was applied.
+	Index uint64 `json:"-"`
+}
+
 type Watcher interface {
 	// Next blocks until an etcd event occurs, then returns a Response
 	// represeting that event. The behavior of Next depends on the
@@ -249,7 +385,7 @@ type Watcher interface {
 type Response struct {
 	// Action is the name of the operation that occurred. Possible values
 	// include get, set, delete, update, create, compareAndSwap,
-	// compareAndDelete and expire.
+	// compareAndDelete, refresh and expire.
 	Action string `json:"action"`
 
 	// Node represents the state of the relevant etcd Node.
@@ -260,6 +396,12 @@ type Response struct {
 	// caused a change to the Node.
 	PrevNode *Node `json:"prevNode"`
 
+	// Next is the key of the first child left out of a directory listing
+	// limited by GetOptions.Limit. It can be given as GetOptions.StartKey
+	// to get the next page of the listing. Next is empty if there are no
+	// more children to list.
+	Next string `json:"next"`
+
 	// Index holds the cluster-level index at the time the Response was generated.
 	// This index is not tied to the Node(s) contained in this Response.
 	Index uint64 `json:"-"`
@@ -328,6 +470,7 @@ func (k *httpKeysAPI) Set(ctx context.Context, key, val string, opts *SetOptions
 		act.PrevExist = opts.PrevExist
 		act.TTL = opts.TTL
 		act.Dir = opts.Dir
+		act.Refresh = opts.Refresh
 	}
 
 	resp, body, err := k.client.Do(ctx, act)
@@ -365,6 +508,21 @@ func (k *httpKeysAPI) Update(ctx context.Context, key, val string) (*Response, e
 	return k.Set(ctx, key, val, &SetOptions{PrevExist: PrevExist})
 }
 
+func (k *httpKeysAPI) Txn(ctx context.Context, compares []TxnCompare, ops []TxnOp) (*TxnResponse, error) {
+	act := &txnAction{
+		Prefix:   defaultV2TxnPrefix,
+		Compares: compares,
+		Ops:      ops,
+	}
+
+	resp, body, err := k.client.Do(ctx, act)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalHTTPTxnResponse(resp.StatusCode, resp.Header, body)
+}
+
 func (k *httpKeysAPI) Delete(ctx context.Context, key string, opts *DeleteOptions) (*Response, error) {
 	act := &deleteAction{
 		Prefix: k.prefix,
@@ -396,6 +554,8 @@ func (k *httpKeysAPI) Get(ctx context.Context, key string, opts *GetOptions) (*R
 		act.Recursive = opts.Recursive
 		act.Sorted = opts.Sort
 		act.Quorum = opts.Quorum
+		act.Limit = opts.Limit
+		act.StartKey = opts.StartKey
 	}
 
 	resp, body, err := k.client.Do(ctx, act)
@@ -417,6 +577,15 @@ func (k *httpKeysAPI) Watcher(key string, opts *WatcherOptions) Watcher {
 		if opts.AfterIndex > 0 {
 			act.WaitIndex = opts.AfterIndex + 1
 		}
+		act.Actions = opts.Actions
+		act.KeyGlob = opts.KeyGlob
+		act.OmitPrevNode = opts.OmitPrevNode
+		act.OmitValue = opts.OmitValue
+
+		if sc, ok := k.client.(httpStreamClient); ok && opts.Stream {
+			act.Stream = true
+			return newHTTPStreamWatcher(sc, act)
+		}
 	}
 
 	return &httpWatcher{
@@ -450,6 +619,224 @@ func (hw *httpWatcher) Next(ctx context.Context) (*Response, error) {
 	}
 }
 
+// watchStreamProgress is the action of the progress notifications
+// received by streaming watches.
+const watchStreamProgress = "progress"
+
+// watchStreamLine is a line received by a streaming watch: either an
+// event or a progress notification.
+type watchStreamLine struct {
+	Action    string `json:"action"`
+	Node      *Node  `json:"node"`
+	PrevNode  *Node  `json:"prevNode"`
+	EtcdIndex uint64 `json:"etcdIndex"`
+}
+
+const (
+	// streamRetryMin and streamRetryMax bound the delay before reopening a
+	// stream which ended without delivering anything.
+	streamRetryMin = 100 * time.Millisecond
+	streamRetryMax = 5 * time.Second
+)
+
+type httpStreamWatcher struct {
+	client   httpStreamClient
+	nextWait waitAction
+	// seen is the number of events at nextWait.WaitIndex already
+	// returned, which are sent again by a stream resumed at that index:
+	// the ops of a transaction are all applied at the same index.
+	seen int
+	// dups is the number of events of the current stream left to drop
+	// as already returned.
+	dups int
+	// retry is the delay before reopening the stream when it ends.
+	retry time.Duration
+
+	// ctx is cancelled by Close; the streams are opened under it.
+	ctx  context.Context
+	stop context.CancelFunc
+
+	// cancel closes the current stream, if any
+	cancel context.CancelFunc
+	body   io.ReadCloser
+	r      *bufio.Reader
+}
+
+func newHTTPStreamWatcher(client httpStreamClient, act waitAction) *httpStreamWatcher {
+	hw := &httpStreamWatcher{client: client, nextWait: act}
+	hw.ctx, hw.stop = context.WithCancel(context.Background())
+	return hw
+}
+
+func (hw *httpStreamWatcher) Next(ctx context.Context) (*Response, error) {
+	for {
+		if hw.ctx.Err() != nil {
+			hw.close()
+			return nil, ErrWatcherClosed
+		}
+		if hw.cancel == nil {
+			// the stream outlives the context given to Next
+			var sctx context.Context
+			sctx, hw.cancel = context.WithCancel(hw.ctx)
+			if err := hw.connect(ctx, sctx); err != nil {
+				hw.close()
+				if hw.ctx.Err() != nil {
+					return nil, ErrWatcherClosed
+				}
+				return nil, err
+			}
+			hw.dups = hw.seen
+		}
+
+		r, linec := hw.r, make(chan []byte, 1)
+		go func() {
+			line, _ := r.ReadBytes('\n')
+			linec <- line
+		}()
+
+		var line []byte
+		select {
+		case line = <-linec:
+		case <-ctx.Done():
+			hw.close()
+			<-linec
+			return nil, ctx.Err()
+		}
+		if len(line) == 0 || line[len(line)-1] != '\n' {
+			// the stream ended; resume it where it was left
+			hw.close()
+			if err := hw.backoff(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		hw.retry = 0
+
+		var l watchStreamLine
+		if err := codec.NewDecoderBytes(line, new(codec.JsonHandle)).Decode(&l); err != nil {
+			hw.close()
+			return nil, ErrInvalidJSON
+		}
+		if l.Action == watchStreamProgress {
+			// all the events up to the index were already received
+			hw.nextWait.WaitIndex = l.EtcdIndex + 1
+			hw.seen, hw.dups = 0, 0
+			continue
+		}
+		if l.Node == nil {
+			hw.close()
+			return nil, ErrInvalidJSON
+		}
+
+		if idx := l.Node.ModifiedIndex; idx != hw.nextWait.WaitIndex {
+			hw.nextWait.WaitIndex = idx
+			hw.seen, hw.dups = 0, 0
+		} else if hw.dups > 0 {
+			hw.dups--
+			continue
+		}
+		hw.seen++
+		return &Response{
+			Action:   l.Action,
+			Node:     l.Node,
+			PrevNode: l.PrevNode,
+			Index:    l.EtcdIndex,
+		}, nil
+	}
+}
+
+// Close closes the stream of the watcher, if any, and makes the following
+// calls to Next return ErrWatcherClosed. It may be called concurrently with
+// Next.
+func (hw *httpStreamWatcher) Close() error {
+	hw.stop()
+	return nil
+}
+
+// backoff waits before the stream which just ended is reopened, doubling
+// the delay every time a stream ends without delivering anything.
+func (hw *httpStreamWatcher) backoff(ctx context.Context) error {
+	switch {
+	case hw.retry == 0:
+		hw.retry = streamRetryMin
+	case hw.retry < streamRetryMax:
+		if hw.retry *= 2; hw.retry > streamRetryMax {
+			hw.retry = streamRetryMax
+		}
+	}
+	select {
+	case <-time.After(hw.retry):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-hw.ctx.Done():
+		return ErrWatcherClosed
+	}
+}
+
+// connect opens a stream with the given stream context, waiting for the
+// headers of the response no longer than the given context allows.
+func (hw *httpStreamWatcher) connect(ctx, sctx context.Context) error {
+	type result struct {
+		resp *http.Response
+		body io.ReadCloser
+		err  error
+	}
+	rc := make(chan result, 1)
+	go func() {
+		resp, body, err := hw.client.DoStream(sctx, &hw.nextWait)
+		rc <- result{resp, body, err}
+	}()
+
+	var r result
+	select {
+	case r = <-rc:
+	case <-ctx.Done():
+		hw.cancel()
+		if r = <-rc; r.err == nil {
+			r.body.Close()
+		}
+		return ctx.Err()
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	if r.resp.StatusCode != http.StatusOK {
+		b, err := ioutil.ReadAll(r.body)
+		r.body.Close()
+		if err != nil {
+			return err
+		}
+		return unmarshalFailedKeysResponse(b)
+	}
+	if hw.nextWait.WaitIndex == 0 {
+		// resume after the index at which the stream was opened
+		idx, err := strconv.ParseUint(r.resp.Header.Get("X-Etcd-Index"), 10, 64)
+		if err != nil {
+			r.body.Close()
+			return err
+		}
+		hw.nextWait.WaitIndex = idx + 1
+	}
+	hw.body = r.body
+	hw.r = bufio.NewReader(r.body)
+	return nil
+}
+
+// close closes the current stream, if any.
+func (hw *httpStreamWatcher) close() {
+	if hw.cancel != nil {
+		hw.cancel()
+		hw.cancel = nil
+	}
+	if hw.body != nil {
+		hw.body.Close()
+		hw.body = nil
+		hw.r = nil
+	}
+}
+
 // v2KeysURL forms a URL representing the location of a key.
 // The endpoint argument represents the base URL of an etcd
 // server. The prefix is the path needed to route from the
@@ -475,6 +862,8 @@ type getAction struct {
 	Recursive bool
 	Sorted    bool
 	Quorum    bool
+	Limit     uint64
+	StartKey  string
 }
 
 func (g *getAction) HTTPRequest(ep url.URL) *http.Request {
@@ -484,6 +873,12 @@ func (g *getAction) HTTPRequest(ep url.URL) *http.Request {
 	params.Set("recursive", strconv.FormatBool(g.Recursive))
 	params.Set("sorted", strconv.FormatBool(g.Sorted))
 	params.Set("quorum", strconv.FormatBool(g.Quorum))
+	if g.Limit > 0 {
+		params.Set("limit", strconv.FormatUint(g.Limit, 10))
+	}
+	if g.StartKey != "" {
+		params.Set("startKey", g.StartKey)
+	}
 	u.RawQuery = params.Encode()
 
 	req, _ := http.NewRequest("GET", u.String(), nil)
@@ -491,10 +886,15 @@ func (g *getAction) HTTPRequest(ep url.URL) *http.Request {
 }
 
 type waitAction struct {
-	Prefix    string
-	Key       string
-	WaitIndex uint64
-	Recursive bool
+	Prefix       string
+	Key          string
+	WaitIndex    uint64
+	Recursive    bool
+	Actions      []string
+	KeyGlob      string
+	OmitPrevNode bool
+	OmitValue    bool
+	Stream       bool
 }
 
 func (w *waitAction) HTTPRequest(ep url.URL) *http.Request {
@@ -504,6 +904,21 @@ func (w *waitAction) HTTPRequest(ep url.URL) *http.Request {
 	params.Set("wait", "true")
 	params.Set("waitIndex", strconv.FormatUint(w.WaitIndex, 10))
 	params.Set("recursive", strconv.FormatBool(w.Recursive))
+	if len(w.Actions) > 0 {
+		params.Set("filterAction", strings.Join(w.Actions, ","))
+	}
+	if w.KeyGlob != "" {
+		params.Set("filterKey", w.KeyGlob)
+	}
+	if w.OmitPrevNode {
+		params.Set("omitPrevNode", "true")
+	}
+	if w.OmitValue {
+		params.Set("omitValue", "true")
+	}
+	if w.Stream {
+		params.Set("stream", "true")
+	}
 	u.RawQuery = params.Encode()
 
 	req, _ := http.NewRequest("GET", u.String(), nil)
@@ -519,6 +934,7 @@ type setAction struct {
 	PrevExist PrevExistType
 	TTL       time.Duration
 	Dir       bool
+	Refresh   bool
 }
 
 func (a *setAction) HTTPRequest(ep url.URL) *http.Request {
@@ -527,8 +943,11 @@ func (a *setAction) HTTPRequest(ep url.URL) *http.Request {
 	params := u.Query()
 	form := url.Values{}
 
-	// we're either creating a directory or setting a key
-	if a.Dir {
+	// we're either refreshing a TTL, creating a directory or setting a key
+	if a.Refresh {
+		// a refresh only carries the new TTL, the node is left untouched
+		params.Set("refresh", strconv.FormatBool(a.Refresh))
+	} else if a.Dir {
 		params.Set("dir", strconv.FormatBool(a.Dir))
 	} else {
 		// These options are only valid for setting a key
@@ -613,6 +1032,92 @@ func (a *createInOrderAction) HTTPRequest(ep url.URL) *http.Request {
 	return req
 }
 
+type txnAction struct {
+	Prefix   string
+	Compares []TxnCompare
+	Ops      []TxnOp
+}
+
+func (a *txnAction) HTTPRequest(ep url.URL) *http.Request {
+	u := v2KeysURL(ep, a.Prefix, "")
+
+	type compare struct {
+		Key       string `json:"key"`
+		PrevValue string `json:"prevValue,omitempty"`
+		PrevIndex uint64 `json:"prevIndex,omitempty"`
+		PrevExist *bool  `json:"prevExist,omitempty"`
+	}
+	type op struct {
+		Action    TxnActionType `json:"action"`
+		Key       string        `json:"key"`
+		Value     string        `json:"value,omitempty"`
+		Dir       bool          `json:"dir,omitempty"`
+		Recursive bool          `json:"recursive,omitempty"`
+		TTL       *uint64       `json:"ttl,omitempty"`
+	}
+	req := struct {
+		Compares []compare `json:"compares,omitempty"`
+		Ops      []op      `json:"ops"`
+	}{}
+
+	for _, c := range a.Compares {
+		cmp := compare{Key: c.Key, PrevValue: c.PrevValue, PrevIndex: c.PrevIndex}
+		if c.PrevExist != PrevIgnore {
+			exist := c.PrevExist == PrevExist
+			cmp.PrevExist = &exist
+		}
+		req.Compares = append(req.Compares, cmp)
+	}
+	for _, o := range a.Ops {
+		wop := op{Action: o.Action, Key: o.Key, Dir: o.Dir, Recursive: o.Recursive}
+		if !o.Dir {
+			wop.Value = o.Value
+		}
+		if o.TTL > 0 {
+			ttl := uint64(o.TTL.Seconds())
+			wop.TTL = &ttl
+		}
+		req.Ops = append(req.Ops, wop)
+	}
+
+	b, _ := json.Marshal(req)
+	hreq, _ := http.NewRequest("POST", u.String(), bytes.NewReader(b))
+	hreq.Header.Set("Content-Type", "application/json")
+	return hreq
+}
+
+func unmarshalHTTPTxnResponse(code int, header http.Header, body []byte) (res *TxnResponse, err error) {
+	switch code {
+	case http.StatusOK:
+		if len(body) == 0 {
+			return nil, ErrEmptyBody
+		}
+		res, err = unmarshalSuccessfulTxnResponse(header, body)
+	default:
+		err = unmarshalFailedKeysResponse(body)
+	}
+
+	return
+}
+
+func unmarshalSuccessfulTxnResponse(header http.Header, body []byte) (*TxnResponse, error) {
+	var res TxnResponse
+	err := codec.NewDecoderBytes(body, new(codec.JsonHandle)).Decode(&res)
+	if err != nil {
+		return nil, ErrInvalidJSON
+	}
+	if header.Get("X-Etcd-Index") != "" {
+		res.Index, err = strconv.ParseUint(header.Get("X-Etcd-Index"), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range res.Responses {
+		r.Index = res.Index
+	}
+	return &res, nil
+}
+
 func unmarshalHTTPResponse(code int, header http.Header, body []byte) (res *Response, err error) {
 	switch code {
 	case http.StatusOK, http.StatusCreated:
@@ -17,10 +17,12 @@ package client
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -117,6 +119,8 @@ func TestGetAction(t *testing.T) {
 		recursive bool
 		sorted    bool
 		quorum    bool
+		limit     uint64
+		startKey  string
 		wantQuery string
 	}{
 		{
@@ -149,6 +153,11 @@ func TestGetAction(t *testing.T) {
 			quorum:    true,
 			wantQuery: "quorum=true&recursive=false&sorted=false",
 		},
+		{
+			limit:     10,
+			startKey:  "/foo/bar/baz",
+			wantQuery: "limit=10&quorum=false&recursive=false&sorted=false&startKey=%2Ffoo%2Fbar%2Fbaz",
+		},
 	}
 
 	for i, tt := range tests {
@@ -157,6 +166,8 @@ func TestGetAction(t *testing.T) {
 			Recursive: tt.recursive,
 			Sorted:    tt.sorted,
 			Quorum:    tt.quorum,
+			Limit:     tt.limit,
+			StartKey:  tt.startKey,
 		}
 		got := *f.HTTPRequest(ep)
 
@@ -180,9 +191,14 @@ func TestWaitAction(t *testing.T) {
 	wantHeader := http.Header{}
 
 	tests := []struct {
-		waitIndex uint64
-		recursive bool
-		wantQuery string
+		waitIndex    uint64
+		recursive    bool
+		actions      []string
+		keyGlob      string
+		omitPrevNode bool
+		omitValue    bool
+		stream       bool
+		wantQuery    string
 	}{
 		{
 			recursive: false,
@@ -199,13 +215,33 @@ func TestWaitAction(t *testing.T) {
 			waitIndex: uint64(12),
 			wantQuery: "recursive=true&wait=true&waitIndex=12",
 		},
+		{
+			recursive:    true,
+			waitIndex:    uint64(12),
+			actions:      []string{"set", "expire"},
+			keyGlob:      "*/baz",
+			omitPrevNode: true,
+			omitValue:    true,
+			wantQuery:    "filterAction=set%2Cexpire&filterKey=%2A%2Fbaz&omitPrevNode=true&omitValue=true&recursive=true&wait=true&waitIndex=12",
+		},
+		{
+			recursive: true,
+			waitIndex: uint64(12),
+			stream:    true,
+			wantQuery: "recursive=true&stream=true&wait=true&waitIndex=12",
+		},
 	}
 
 	for i, tt := range tests {
 		f := waitAction{
-			Key:       "/foo/bar",
-			WaitIndex: tt.waitIndex,
-			Recursive: tt.recursive,
+			Key:          "/foo/bar",
+			WaitIndex:    tt.waitIndex,
+			Recursive:    tt.recursive,
+			Actions:      tt.actions,
+			KeyGlob:      tt.keyGlob,
+			OmitPrevNode: tt.omitPrevNode,
+			OmitValue:    tt.omitValue,
+			Stream:       tt.stream,
 		}
 		got := *f.HTTPRequest(ep)
 
@@ -327,6 +363,18 @@ func TestSetAction(t *testing.T) {
 			wantBody: "value=",
 		},
 
+		// Refresh is set, Value is ignored
+		{
+			act: setAction{
+				Key:     "foo",
+				Value:   "baz",
+				TTL:     10 * time.Second,
+				Refresh: true,
+			},
+			wantURL:  "http://example.com/foo?refresh=true",
+			wantBody: "ttl=10",
+		},
+
 		// PrevValue is urlencoded
 		{
 			act: setAction{
@@ -648,6 +696,60 @@ func assertRequest(got http.Request, wantMethod string, wantURL *url.URL, wantHe
 	return nil
 }
 
+func TestTxnAction(t *testing.T) {
+	wantHeader := http.Header(map[string][]string{
+		"Content-Type": {"application/json"},
+	})
+
+	tests := []struct {
+		act      txnAction
+		wantBody string
+	}{
+		{
+			act: txnAction{
+				Prefix: defaultV2TxnPrefix,
+				Ops:    []TxnOp{{Action: TxnSet, Key: "/foo", Value: "bar"}},
+			},
+			wantBody: `{"ops":[{"action":"set","key":"/foo","value":"bar"}]}`,
+		},
+		{
+			act: txnAction{
+				Prefix: defaultV2TxnPrefix,
+				Compares: []TxnCompare{
+					{Key: "/foo", PrevValue: "bar", PrevIndex: 12},
+					{Key: "/baz", PrevExist: PrevNoExist},
+				},
+				Ops: []TxnOp{
+					{Action: TxnSet, Key: "/foo", Value: "ignored", Dir: true, TTL: 10 * time.Second},
+					{Action: TxnDelete, Key: "/dir", Recursive: true},
+				},
+			},
+			wantBody: `{"compares":[{"key":"/foo","prevValue":"bar","prevIndex":12},{"key":"/baz","prevExist":false}],"ops":[{"action":"set","key":"/foo","dir":true,"ttl":10},{"action":"delete","key":"/dir","recursive":true}]}`,
+		},
+	}
+
+	for i, tt := range tests {
+		u, err := url.Parse("http://example.com")
+		if err != nil {
+			t.Errorf("#%d: unable to use test URL: %v", i, err)
+			continue
+		}
+
+		wantURL, err := url.Parse("http://example.com/v2/txn")
+		if err != nil {
+			t.Errorf("#%d: unable to use want URL: %v", i, err)
+			continue
+		}
+
+		got := tt.act.HTTPRequest(*u)
+
+		err = assertRequest(*got, "POST", wantURL, wantHeader, []byte(tt.wantBody))
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+}
+
 func TestUnmarshalSuccessfulResponse(t *testing.T) {
 	var expiration time.Time
 	expiration.UnmarshalText([]byte("2015-04-07T04:40:23.044979686Z"))
@@ -969,6 +1071,36 @@ func TestHTTPKeysAPIWatcherAction(t *testing.T) {
 				WaitIndex: 20,
 			},
 		},
+
+		{
+			key: "/foo",
+			opts: &WatcherOptions{
+				Recursive:    true,
+				Actions:      []string{"delete"},
+				KeyGlob:      "*/bar",
+				OmitPrevNode: true,
+				OmitValue:    true,
+			},
+			want: waitAction{
+				Key:          "/foo",
+				Recursive:    true,
+				Actions:      []string{"delete"},
+				KeyGlob:      "*/bar",
+				OmitPrevNode: true,
+				OmitValue:    true,
+			},
+		},
+
+		// the client does not support streaming
+		{
+			key: "/foo",
+			opts: &WatcherOptions{
+				Stream: true,
+			},
+			want: waitAction{
+				Key: "/foo",
+			},
+		},
 	}
 
 	for i, tt := range tests {
@@ -988,6 +1120,188 @@ func TestHTTPKeysAPIWatcherAction(t *testing.T) {
 	}
 }
 
+func TestHTTPKeysAPIStreamWatcher(t *testing.T) {
+	client := &streamHTTPClient{}
+	kAPI := &httpKeysAPI{client: client}
+
+	wact := waitAction{
+		Key:       "/foo",
+		Recursive: true,
+		WaitIndex: 20,
+		Stream:    true,
+	}
+
+	got, ok := kAPI.Watcher("/foo", &WatcherOptions{Recursive: true, AfterIndex: 19, Stream: true}).(*httpStreamWatcher)
+	if !ok {
+		t.Fatalf("watcher is not a streaming one")
+	}
+	if got.client != client || !reflect.DeepEqual(got.nextWait, wact) {
+		t.Errorf("incorrect watcher: want client %#v and action %#v, got %#v", client, wact, got)
+	}
+}
+
+// streamHTTPClient serves the given bodies to the successive stream
+// requests, then blocks. It records the requested actions.
+type streamHTTPClient struct {
+	staticHTTPClient
+	header http.Header
+	bodies []string
+	acts   []waitAction
+}
+
+func (s *streamHTTPClient) DoStream(ctx context.Context, act httpAction) (*http.Response, io.ReadCloser, error) {
+	s.acts = append(s.acts, *act.(*waitAction))
+	var body io.ReadCloser = &blockingBody{c: make(chan struct{})}
+	if len(s.bodies) > 0 {
+		body = ioutil.NopCloser(strings.NewReader(s.bodies[0]))
+		s.bodies = s.bodies[1:]
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: s.header}, newStreamBody(ctx, body), nil
+}
+
+func TestHTTPStreamWatcherNext(t *testing.T) {
+	client := &streamHTTPClient{
+		header: http.Header{"X-Etcd-Index": []string{"10"}},
+		bodies: []string{
+			`{"action":"set","node":{"key":"/foo/a","value":"1","modifiedIndex":11,"createdIndex":11},"etcdIndex":11}` + "\n" +
+				`{"action":"progress","etcdIndex":15}` + "\n",
+			`{"action":"delete","node":{"key":"/foo/a","modifiedIndex":17,"createdIndex":11},"etcdIndex":17}` + "\n",
+		},
+	}
+	watcher := newHTTPStreamWatcher(client, waitAction{Key: "/foo", Recursive: true, Stream: true})
+
+	wresps := []*Response{
+		{
+			Action: "set",
+			Node:   &Node{Key: "/foo/a", Value: "1", CreatedIndex: 11, ModifiedIndex: 11},
+			Index:  11,
+		},
+		{
+			Action: "delete",
+			Node:   &Node{Key: "/foo/a", CreatedIndex: 11, ModifiedIndex: 17},
+			Index:  17,
+		},
+	}
+	for i, wresp := range wresps {
+		resp, err := watcher.Next(context.Background())
+		if err != nil {
+			t.Fatalf("#%d: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(wresp, resp) {
+			t.Errorf("#%d: resp = %#v, want %#v", i, resp, wresp)
+		}
+	}
+
+	// the stream is resumed after the progress notification
+	wacts := []waitAction{
+		{Key: "/foo", Recursive: true, Stream: true},
+		{Key: "/foo", Recursive: true, Stream: true, WaitIndex: 16},
+	}
+	if !reflect.DeepEqual(client.acts, wacts) {
+		t.Errorf("actions = %+v, want %+v", client.acts, wacts)
+	}
+	if watcher.nextWait.WaitIndex != 17 || watcher.seen != 1 {
+		t.Errorf("waitIndex = %d with %d events seen, want 17 with 1", watcher.nextWait.WaitIndex, watcher.seen)
+	}
+}
+
+func TestHTTPStreamWatcherNextResumeTxn(t *testing.T) {
+	client := &streamHTTPClient{
+		bodies: []string{
+			`{"action":"set","node":{"key":"/foo/a","modifiedIndex":11,"createdIndex":11},"etcdIndex":11}` + "\n",
+			// the resumed stream sends again the events of the txn at 11
+			`{"action":"set","node":{"key":"/foo/a","modifiedIndex":11,"createdIndex":11},"etcdIndex":11}` + "\n" +
+				`{"action":"delete","node":{"key":"/foo/b","modifiedIndex":11,"createdIndex":5},"etcdIndex":11}` + "\n" +
+				`{"action":"set","node":{"key":"/foo/c","modifiedIndex":12,"createdIndex":12},"etcdIndex":12}` + "\n",
+		},
+	}
+	watcher := newHTTPStreamWatcher(client, waitAction{Key: "/foo", Recursive: true, Stream: true, WaitIndex: 11})
+
+	for i, wkey := range []string{"/foo/a", "/foo/b", "/foo/c"} {
+		resp, err := watcher.Next(context.Background())
+		if err != nil {
+			t.Fatalf("#%d: unexpected error: %v", i, err)
+		}
+		if resp.Node.Key != wkey {
+			t.Errorf("#%d: key = %s, want %s", i, resp.Node.Key, wkey)
+		}
+	}
+	if n := len(client.acts); n != 2 || client.acts[1].WaitIndex != 11 {
+		t.Errorf("actions = %+v, want a second one at index 11", client.acts)
+	}
+}
+
+func TestHTTPStreamWatcherNextBackoff(t *testing.T) {
+	// streams which end right away
+	client := &streamHTTPClient{bodies: make([]string, 10)}
+	watcher := newHTTPStreamWatcher(client, waitAction{Key: "/foo", Stream: true, WaitIndex: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*streamRetryMin)
+	defer cancel()
+	if _, err := watcher.Next(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := len(client.acts); n != 2 {
+		t.Errorf("len(actions) = %d, want 2", n)
+	}
+}
+
+func TestHTTPStreamWatcherClose(t *testing.T) {
+	client := &streamHTTPClient{}
+	watcher := newHTTPStreamWatcher(client, waitAction{Key: "/foo", Stream: true, WaitIndex: 1})
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := watcher.Next(context.Background())
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := watcher.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != ErrWatcherClosed {
+			t.Errorf("err = %v, want %v", err, ErrWatcherClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Next is not interrupted by Close")
+	}
+	if watcher.body != nil || watcher.cancel != nil {
+		t.Errorf("stream is not closed")
+	}
+	if _, err := watcher.Next(context.Background()); err != ErrWatcherClosed {
+		t.Errorf("err = %v, want %v", err, ErrWatcherClosed)
+	}
+	if n := len(client.acts); n != 1 {
+		t.Errorf("len(actions) = %d, want 1", n)
+	}
+}
+
+func TestHTTPStreamWatcherNextCancel(t *testing.T) {
+	client := &streamHTTPClient{
+		header: http.Header{"X-Etcd-Index": []string{"10"}},
+	}
+	watcher := newHTTPStreamWatcher(client, waitAction{Key: "/foo", Stream: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := watcher.Next(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if watcher.body != nil || watcher.cancel != nil {
+		t.Errorf("stream is not closed")
+	}
+
+	// the next call resumes after the index at which the stream was opened
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	watcher.Next(ctx)
+	if n := len(client.acts); n != 2 || client.acts[1].WaitIndex != 11 {
+		t.Errorf("actions = %+v, want a second one at index 11", client.acts)
+	}
+}
+
 func TestHTTPKeysAPISetAction(t *testing.T) {
 	tests := []struct {
 		key        string
@@ -1114,6 +1428,71 @@ func TestHTTPKeysAPISetResponse(t *testing.T) {
 	}
 }
 
+func TestHTTPKeysAPITxnResponse(t *testing.T) {
+	client := &staticHTTPClient{
+		resp: http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"X-Etcd-Index": []string{"21"}},
+		},
+		body: []byte(`{"action":"txn","events":[{"action":"set","node":{"key":"/foo","value":"bar","modifiedIndex":21,"createdIndex":21}},{"action":"delete","node":{"key":"/baz","modifiedIndex":21,"createdIndex":19},"prevNode":{"key":"/baz","value":"snazz","modifiedIndex":20,"createdIndex":19}}]}`),
+	}
+
+	wantResponse := &TxnResponse{
+		Action: "txn",
+		Responses: []*Response{
+			{
+				Action: "set",
+				Node:   &Node{Key: "/foo", Value: "bar", CreatedIndex: uint64(21), ModifiedIndex: uint64(21)},
+				Index:  uint64(21),
+			},
+			{
+				Action:   "delete",
+				Node:     &Node{Key: "/baz", CreatedIndex: uint64(19), ModifiedIndex: uint64(21)},
+				PrevNode: &Node{Key: "/baz", Value: "snazz", CreatedIndex: uint64(19), ModifiedIndex: uint64(20)},
+				Index:    uint64(21),
+			},
+		},
+		Index: uint64(21),
+	}
+
+	kAPI := &httpKeysAPI{client: client}
+	resp, err := kAPI.Txn(context.Background(), nil, []TxnOp{{Action: TxnSet, Key: "/foo", Value: "bar"}, {Action: TxnDelete, Key: "/baz"}})
+	if err != nil {
+		t.Errorf("non-nil error: %#v", err)
+	}
+	if !reflect.DeepEqual(wantResponse, resp) {
+		t.Errorf("incorrect Response: want=%#v got=%#v", wantResponse, resp)
+	}
+}
+
+func TestHTTPKeysAPITxnError(t *testing.T) {
+	tests := []httpClient{
+		// generic HTTP client failure
+		&staticHTTPClient{
+			err: errors.New("fail!"),
+		},
+
+		// etcd Error response
+		&staticHTTPClient{
+			resp: http.Response{
+				StatusCode: http.StatusPreconditionFailed,
+			},
+			body: []byte(`{"errorCode":101,"message":"Compare failed","cause":"/foo [bar != baz]","index":18}`),
+		},
+	}
+
+	for i, tt := range tests {
+		kAPI := httpKeysAPI{client: tt}
+		resp, err := kAPI.Txn(context.Background(), nil, []TxnOp{{Action: TxnSet, Key: "/foo"}})
+		if err == nil {
+			t.Errorf("#%d: received nil error", i)
+		}
+		if resp != nil {
+			t.Errorf("#%d: received non-nil Response: %#v", i, resp)
+		}
+	}
+}
+
 func TestHTTPKeysAPIGetAction(t *testing.T) {
 	tests := []struct {
 		key        string
@@ -1147,12 +1526,16 @@ func TestHTTPKeysAPIGetAction(t *testing.T) {
 				Sort:      true,
 				Recursive: true,
 				Quorum:    true,
+				Limit:     10,
+				StartKey:  "/foo/bar",
 			},
 			wantAction: &getAction{
 				Key:       "/foo",
 				Sorted:    true,
 				Recursive: true,
 				Quorum:    true,
+				Limit:     10,
+				StartKey:  "/foo/bar",
 			},
 		},
 	}
@@ -1205,7 +1588,7 @@ func TestHTTPKeysAPIGetResponse(t *testing.T) {
 			StatusCode: http.StatusOK,
 			Header:     http.Header{"X-Etcd-Index": []string{"42"}},
 		},
-		body: []byte(`{"action":"get","node":{"key":"/pants/foo/bar","modifiedIndex":25,"createdIndex":19,"nodes":[{"key":"/pants/foo/bar/baz","value":"snarf","createdIndex":21,"modifiedIndex":25}]}}`),
+		body: []byte(`{"action":"get","node":{"key":"/pants/foo/bar","modifiedIndex":25,"createdIndex":19,"nodes":[{"key":"/pants/foo/bar/baz","value":"snarf","createdIndex":21,"modifiedIndex":25}]},"next":"/pants/foo/bar/qux"}`),
 	}
 
 	wantResponse := &Response{
@@ -1218,6 +1601,7 @@ func TestHTTPKeysAPIGetResponse(t *testing.T) {
 			CreatedIndex:  uint64(19),
 			ModifiedIndex: uint64(25),
 		},
+		Next:  "/pants/foo/bar/qux",
 		Index: uint64(42),
 	}
 
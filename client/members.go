@@ -45,6 +45,10 @@ type Member struct {
 	// ClientURLs represents the HTTP(S) endpoints on which this Member
 	// serves it's client-facing APIs.
 	ClientURLs []string `json:"clientURLs"`
+
+	// IsWitness is set if this Member is a witness, which takes part in
+	// etcd's consensus protocol but does not serve the client-facing APIs.
+	IsWitness bool `json:"isWitness,omitempty"`
 }
 
 type memberCollection []Member
@@ -68,14 +72,17 @@ func (c *memberCollection) UnmarshalJSON(data []byte) error {
 }
 
 type memberCreateOrUpdateRequest struct {
-	PeerURLs types.URLs
+	PeerURLs  types.URLs
+	IsWitness bool
 }
 
 func (m *memberCreateOrUpdateRequest) MarshalJSON() ([]byte, error) {
 	s := struct {
-		PeerURLs []string `json:"peerURLs"`
+		PeerURLs  []string `json:"peerURLs"`
+		IsWitness bool     `json:"isWitness,omitempty"`
 	}{
-		PeerURLs: make([]string, len(m.PeerURLs)),
+		PeerURLs:  make([]string, len(m.PeerURLs)),
+		IsWitness: m.IsWitness,
 	}
 
 	for i, u := range m.PeerURLs {
@@ -100,11 +107,21 @@ type MembersAPI interface {
 	// Add instructs etcd to accept a new Member into the cluster.
 	Add(ctx context.Context, peerURL string) (*Member, error)
 
+	// AddWitness instructs etcd to accept a new witness Member into the
+	// cluster.
+	AddWitness(ctx context.Context, peerURL string) (*Member, error)
+
 	// Remove demotes an existing Member out of the cluster.
 	Remove(ctx context.Context, mID string) error
 
 	// Update instructs etcd to update an existing Member in the cluster.
 	Update(ctx context.Context, mID string, peerURLs []string) error
+
+	// Replace atomically demotes the existing Members with the given IDs
+	// out of the cluster and accepts a new Member for each given peer URL.
+	// The cluster requires a quorum of both the old and the new memberships
+	// until the change is done.
+	Replace(ctx context.Context, removeIDs []string, addPeerURLs []string) ([]Member, error)
 }
 
 type httpMembersAPI struct {
@@ -131,12 +148,20 @@ func (m *httpMembersAPI) List(ctx context.Context) ([]Member, error) {
 }
 
 func (m *httpMembersAPI) Add(ctx context.Context, peerURL string) (*Member, error) {
+	return m.add(ctx, peerURL, false)
+}
+
+func (m *httpMembersAPI) AddWitness(ctx context.Context, peerURL string) (*Member, error) {
+	return m.add(ctx, peerURL, true)
+}
+
+func (m *httpMembersAPI) add(ctx context.Context, peerURL string, witness bool) (*Member, error) {
 	urls, err := types.NewURLs([]string{peerURL})
 	if err != nil {
 		return nil, err
 	}
 
-	req := &membersAPIActionAdd{peerURLs: urls}
+	req := &membersAPIActionAdd{peerURLs: urls, witness: witness}
 	resp, body, err := m.client.Do(ctx, req)
 	if err != nil {
 		return nil, err
@@ -199,6 +224,41 @@ func (m *httpMembersAPI) Remove(ctx context.Context, memberID string) error {
 	return assertStatusCode(resp.StatusCode, http.StatusNoContent, http.StatusGone)
 }
 
+func (m *httpMembersAPI) Replace(ctx context.Context, removeIDs []string, addPeerURLs []string) ([]Member, error) {
+	req := &membersAPIActionReplace{removeIDs: removeIDs}
+	for _, peerURL := range addPeerURLs {
+		urls, err := types.NewURLs([]string{peerURL})
+		if err != nil {
+			return nil, err
+		}
+		req.adds = append(req.adds, urls)
+	}
+
+	resp, body, err := m.client.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := assertStatusCode(resp.StatusCode, http.StatusCreated, http.StatusNotFound, http.StatusConflict, http.StatusGone); err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		var merr membersError
+		if err := json.Unmarshal(body, &merr); err != nil {
+			return nil, err
+		}
+		return nil, merr
+	}
+
+	var mCollection memberCollection
+	if err := json.Unmarshal(body, &mCollection); err != nil {
+		return nil, err
+	}
+
+	return []Member(mCollection), nil
+}
+
 type membersAPIActionList struct{}
 
 func (l *membersAPIActionList) HTTPRequest(ep url.URL) *http.Request {
@@ -220,11 +280,12 @@ func (d *membersAPIActionRemove) HTTPRequest(ep url.URL) *http.Request {
 
 type membersAPIActionAdd struct {
 	peerURLs types.URLs
+	witness  bool
 }
 
 func (a *membersAPIActionAdd) HTTPRequest(ep url.URL) *http.Request {
 	u := v2MembersURL(ep)
-	m := memberCreateOrUpdateRequest{PeerURLs: a.peerURLs}
+	m := memberCreateOrUpdateRequest{PeerURLs: a.peerURLs, IsWitness: a.witness}
 	b, _ := json.Marshal(&m)
 	req, _ := http.NewRequest("POST", u.String(), bytes.NewReader(b))
 	req.Header.Set("Content-Type", "application/json")
@@ -246,6 +307,30 @@ func (a *membersAPIActionUpdate) HTTPRequest(ep url.URL) *http.Request {
 	return req
 }
 
+type membersAPIActionReplace struct {
+	removeIDs []string
+	adds      []types.URLs
+}
+
+func (a *membersAPIActionReplace) HTTPRequest(ep url.URL) *http.Request {
+	u := v2MembersURL(ep)
+	u.Path = path.Join(u.Path, "replace")
+	m := struct {
+		Remove []string                      `json:"remove"`
+		Add    []memberCreateOrUpdateRequest `json:"add"`
+	}{
+		Remove: a.removeIDs,
+		Add:    make([]memberCreateOrUpdateRequest, len(a.adds)),
+	}
+	for i, urls := range a.adds {
+		m.Add[i].PeerURLs = urls
+	}
+	b, _ := json.Marshal(&m)
+	req, _ := http.NewRequest("POST", u.String(), bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
 func assertStatusCode(got int, want ...int) (err error) {
 	for _, w := range want {
 		if w == got {
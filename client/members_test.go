@@ -70,6 +70,32 @@ func TestMembersAPIActionAdd(t *testing.T) {
 	}
 }
 
+func TestMembersAPIActionAddWitness(t *testing.T) {
+	ep := url.URL{Scheme: "http", Host: "example.com"}
+	act := &membersAPIActionAdd{
+		peerURLs: types.URLs([]url.URL{
+			{Scheme: "http", Host: "127.0.0.1:8080"},
+		}),
+		witness: true,
+	}
+
+	wantURL := &url.URL{
+		Scheme: "http",
+		Host:   "example.com",
+		Path:   "/v2/members",
+	}
+	wantHeader := http.Header{
+		"Content-Type": []string{"application/json"},
+	}
+	wantBody := []byte(`{"peerURLs":["http://127.0.0.1:8080"],"isWitness":true}`)
+
+	got := *act.HTTPRequest(ep)
+	err := assertRequest(got, "POST", wantURL, wantHeader, wantBody)
+	if err != nil {
+		t.Error(err.Error())
+	}
+}
+
 func TestMembersAPIActionUpdate(t *testing.T) {
 	ep := url.URL{Scheme: "http", Host: "example.com"}
 	act := &membersAPIActionUpdate{
@@ -97,6 +123,32 @@ func TestMembersAPIActionUpdate(t *testing.T) {
 	}
 }
 
+func TestMembersAPIActionReplace(t *testing.T) {
+	ep := url.URL{Scheme: "http", Host: "example.com"}
+	act := &membersAPIActionReplace{
+		removeIDs: []string{"abcd"},
+		adds: []types.URLs{
+			types.URLs([]url.URL{{Scheme: "http", Host: "127.0.0.1:8080"}}),
+		},
+	}
+
+	wantURL := &url.URL{
+		Scheme: "http",
+		Host:   "example.com",
+		Path:   "/v2/members/replace",
+	}
+	wantHeader := http.Header{
+		"Content-Type": []string{"application/json"},
+	}
+	wantBody := []byte(`{"remove":["abcd"],"add":[{"peerURLs":["http://127.0.0.1:8080"]}]}`)
+
+	got := *act.HTTPRequest(ep)
+	err := assertRequest(got, "POST", wantURL, wantHeader, wantBody)
+	if err != nil {
+		t.Error(err.Error())
+	}
+}
+
 func TestMembersAPIActionRemove(t *testing.T) {
 	ep := url.URL{Scheme: "http", Host: "example.com"}
 	act := &membersAPIActionRemove{memberID: "XXX"}
@@ -337,6 +389,40 @@ func TestHTTPMembersAPIAddSuccess(t *testing.T) {
 	}
 }
 
+func TestHTTPMembersAPIAddWitnessSuccess(t *testing.T) {
+	wantAction := &membersAPIActionAdd{
+		peerURLs: types.URLs([]url.URL{
+			{Scheme: "http", Host: "127.0.0.1:7002"},
+		}),
+		witness: true,
+	}
+
+	mAPI := &httpMembersAPI{
+		client: &actionAssertingHTTPClient{
+			t:   t,
+			act: wantAction,
+			resp: http.Response{
+				StatusCode: http.StatusCreated,
+			},
+			body: []byte(`{"id":"94088180e21eb87b","peerURLs":["http://127.0.0.1:7002"],"isWitness":true}`),
+		},
+	}
+
+	wantResponseMember := &Member{
+		ID:        "94088180e21eb87b",
+		PeerURLs:  []string{"http://127.0.0.1:7002"},
+		IsWitness: true,
+	}
+
+	m, err := mAPI.AddWitness(context.Background(), "http://127.0.0.1:7002")
+	if err != nil {
+		t.Errorf("got non-nil err: %#v", err)
+	}
+	if !reflect.DeepEqual(wantResponseMember, m) {
+		t.Errorf("incorrect Member: want=%#v got=%#v", wantResponseMember, m)
+	}
+}
+
 func TestHTTPMembersAPIAddError(t *testing.T) {
 	okPeer := "http://example.com:2379"
 	tests := []struct {
@@ -416,6 +502,93 @@ func TestHTTPMembersAPIAddError(t *testing.T) {
 	}
 }
 
+func TestHTTPMembersAPIReplaceSuccess(t *testing.T) {
+	wantAction := &membersAPIActionReplace{
+		removeIDs: []string{"abcd"},
+		adds: []types.URLs{
+			types.URLs([]url.URL{{Scheme: "http", Host: "127.0.0.1:7002"}}),
+		},
+	}
+
+	mAPI := &httpMembersAPI{
+		client: &actionAssertingHTTPClient{
+			t:   t,
+			act: wantAction,
+			resp: http.Response{
+				StatusCode: http.StatusCreated,
+			},
+			body: []byte(`{"members":[{"id":"94088180e21eb87b","peerURLs":["http://127.0.0.1:7002"]}]}`),
+		},
+	}
+
+	wantResponseMembers := []Member{
+		{
+			ID:       "94088180e21eb87b",
+			PeerURLs: []string{"http://127.0.0.1:7002"},
+		},
+	}
+
+	ms, err := mAPI.Replace(context.Background(), []string{"abcd"}, []string{"http://127.0.0.1:7002"})
+	if err != nil {
+		t.Errorf("got non-nil err: %#v", err)
+	}
+	if !reflect.DeepEqual(wantResponseMembers, ms) {
+		t.Errorf("incorrect Members: want=%#v got=%#v", wantResponseMembers, ms)
+	}
+}
+
+func TestHTTPMembersAPIReplaceError(t *testing.T) {
+	tests := []struct {
+		peerURL string
+		client  httpClient
+
+		// if wantErr == nil, assert that the returned error is non-nil
+		// if wantErr != nil, assert that the returned error matches
+		wantErr error
+	}{
+		// malformed peer URL
+		{
+			peerURL: ":",
+		},
+
+		// unrecognized HTTP status code
+		{
+			client: &staticHTTPClient{
+				resp: http.Response{StatusCode: http.StatusTeapot},
+			},
+		},
+
+		// unmarshal body into membersError on StatusNotFound
+		{
+			client: &staticHTTPClient{
+				resp: http.Response{
+					StatusCode: http.StatusNotFound,
+				},
+				body: []byte(`{"message":"fail!"}`),
+			},
+			wantErr: membersError{Message: "fail!"},
+		},
+	}
+
+	for i, tt := range tests {
+		var adds []string
+		if tt.peerURL != "" {
+			adds = append(adds, tt.peerURL)
+		}
+		mAPI := &httpMembersAPI{client: tt.client}
+		ms, err := mAPI.Replace(context.Background(), []string{"abcd"}, adds)
+		if err == nil {
+			t.Errorf("#%d: got nil err", i)
+		}
+		if tt.wantErr != nil && !reflect.DeepEqual(tt.wantErr, err) {
+			t.Errorf("#%d: incorrect error: want=%#v got=%#v", i, tt.wantErr, err)
+		}
+		if ms != nil {
+			t.Errorf("#%d: got non-nil Members", i)
+		}
+	}
+}
+
 func TestHTTPMembersAPIRemoveSuccess(t *testing.T) {
 	wantAction := &membersAPIActionRemove{
 		memberID: "94088180e21eb87b",
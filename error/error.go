@@ -49,10 +49,13 @@ var errors = map[int]string{
 	ecodeIndexValueMutex:      "Index and value cannot both be specified",
 	EcodeInvalidField:         "Invalid field",
 	EcodeInvalidForm:          "Invalid POST form",
+	EcodeRefreshValue:         "Value provided on refresh",
+	EcodeRefreshTTLRequired:   "A TTL must be provided on refresh",
 
 	// raft related errors
-	EcodeRaftInternal: "Raft Internal Error",
-	EcodeLeaderElect:  "During Leader Election",
+	EcodeRaftInternal:    "Raft Internal Error",
+	EcodeLeaderElect:     "During Leader Election",
+	EcodeTooManyRequests: "Too Many Requests",
 
 	// etcd related errors
 	EcodeWatcherCleared:     "watcher is cleared due to etcd recovery",
@@ -65,15 +68,19 @@ var errors = map[int]string{
 	ecodeClientInternal: "Client Internal Error",
 }
 
+// http.StatusTooManyRequests is only defined since go1.6.
+const statusTooManyRequests = 429
+
 var errorStatus = map[int]int{
-	EcodeKeyNotFound:  http.StatusNotFound,
-	EcodeNotFile:      http.StatusForbidden,
-	EcodeDirNotEmpty:  http.StatusForbidden,
-	EcodeUnauthorized: http.StatusUnauthorized,
-	EcodeTestFailed:   http.StatusPreconditionFailed,
-	EcodeNodeExist:    http.StatusPreconditionFailed,
-	EcodeRaftInternal: http.StatusInternalServerError,
-	EcodeLeaderElect:  http.StatusInternalServerError,
+	EcodeKeyNotFound:     http.StatusNotFound,
+	EcodeNotFile:         http.StatusForbidden,
+	EcodeDirNotEmpty:     http.StatusForbidden,
+	EcodeUnauthorized:    http.StatusUnauthorized,
+	EcodeTestFailed:      http.StatusPreconditionFailed,
+	EcodeNodeExist:       http.StatusPreconditionFailed,
+	EcodeRaftInternal:    http.StatusInternalServerError,
+	EcodeLeaderElect:     http.StatusInternalServerError,
+	EcodeTooManyRequests: statusTooManyRequests,
 }
 
 const (
@@ -100,9 +107,12 @@ const (
 	ecodeIndexValueMutex      = 208
 	EcodeInvalidField         = 209
 	EcodeInvalidForm          = 210
+	EcodeRefreshValue         = 211
+	EcodeRefreshTTLRequired   = 212
 
-	EcodeRaftInternal = 300
-	EcodeLeaderElect  = 301
+	EcodeRaftInternal    = 300
+	EcodeLeaderElect     = 301
+	EcodeTooManyRequests = 302
 
 	EcodeWatcherCleared     = 400
 	EcodeEventIndexCleared  = 401
@@ -15,17 +15,24 @@
 package command
 
 import (
+	"archive/tar"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/codegangsta/cli"
 	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/pkg/idutil"
 	"github.com/coreos/etcd/pkg/pbutil"
 	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/store"
 	"github.com/coreos/etcd/wal"
 	"github.com/coreos/etcd/wal/walpb"
 )
@@ -36,7 +43,9 @@ func NewBackupCommand() cli.Command {
 		Usage: "backup an etcd directory",
 		Flags: []cli.Flag{
 			cli.StringFlag{Name: "data-dir", Value: "", Usage: "Path to the etcd data dir"},
+			cli.StringFlag{Name: "endpoint", Value: "", Usage: "Client URL of a running member to download the backup from, instead of the data dir"},
 			cli.StringFlag{Name: "backup-dir", Value: "", Usage: "Path to the backup dir"},
+			cli.StringFlag{Name: "encryption-key-provider", Value: "", Usage: "Key provider decrypting the etcd dir and encrypting the backup, if encrypted"},
 		},
 		Action: handleBackup,
 	}
@@ -44,29 +53,62 @@ func NewBackupCommand() cli.Command {
 
 // handleBackup handles a request that intends to do a backup.
 func handleBackup(c *cli.Context) {
-	srcSnap := path.Join(c.String("data-dir"), "member", "snap")
-	destSnap := path.Join(c.String("backup-dir"), "member", "snap")
-	srcWAL := path.Join(c.String("data-dir"), "member", "wal")
-	destWAL := path.Join(c.String("backup-dir"), "member", "wal")
+	var kp encryption.KeyProvider
+	if spec := c.String("encryption-key-provider"); spec != "" {
+		var err error
+		if kp, err = encryption.NewKeyProvider(spec); err != nil {
+			log.Fatalf("failed loading key provider: %v", err)
+		}
+	}
+
+	ep := c.String("endpoint")
+	if ep == "" {
+		backupDataDir(c.String("data-dir"), c.String("backup-dir"), kp)
+		return
+	}
+	if c.String("data-dir") != "" {
+		log.Fatal("data-dir and endpoint flags cannot be used together")
+	}
+	tmpdir, err := ioutil.TempDir("", "etcd-backup")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	mustDownloadBackup(c, ep, tmpdir)
+	backupDataDir(tmpdir, c.String("backup-dir"), kp)
+	// the v3 database downloaded is a consistent copy, which is kept as is.
+	db := path.Join(tmpdir, "member", "v3demo")
+	if _, err := os.Stat(db); err == nil {
+		mustCopy(db, path.Join(c.String("backup-dir"), "member", "v3demo"))
+	}
+}
+
+// backupDataDir backs up the snapshot and the wal of the given data dir into
+// the given backup dir, under a new member ID and a new cluster ID.
+func backupDataDir(dataDir, backupDir string, kp encryption.KeyProvider) {
+	srcSnap := path.Join(dataDir, "member", "snap")
+	destSnap := path.Join(backupDir, "member", "snap")
+	srcWAL := path.Join(dataDir, "member", "wal")
+	destWAL := path.Join(backupDir, "member", "wal")
 
 	if err := os.MkdirAll(destSnap, 0700); err != nil {
 		log.Fatalf("failed creating backup snapshot dir %v: %v", destSnap, err)
 	}
-	ss := snap.New(srcSnap)
-	snapshot, err := ss.Load()
+	ss := snap.NewWithKeyProvider(srcSnap, kp)
+	snapshot, err := ss.LoadChain(store.IsDeltaSnapshot)
 	if err != nil && err != snap.ErrNoSnapshot {
 		log.Fatal(err)
 	}
 	var walsnap walpb.Snapshot
 	if snapshot != nil {
 		walsnap.Index, walsnap.Term = snapshot.Metadata.Index, snapshot.Metadata.Term
-		newss := snap.New(destSnap)
+		newss := snap.NewWithKeyProvider(destSnap, kp)
 		if err := newss.SaveSnap(*snapshot); err != nil {
 			log.Fatal(err)
 		}
 	}
 
-	w, err := wal.OpenForRead(srcWAL, walsnap)
+	w, err := wal.OpenForReadWithOptions(srcWAL, walsnap, wal.Options{KeyProvider: kp})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -86,7 +128,7 @@ func handleBackup(c *cli.Context) {
 	metadata.NodeID = idgen.Next()
 	metadata.ClusterID = idgen.Next()
 
-	neww, err := wal.Create(destWAL, pbutil.MustMarshal(&metadata))
+	neww, err := wal.CreateWithOptions(destWAL, pbutil.MustMarshal(&metadata), wal.Options{KeyProvider: kp})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -98,3 +140,59 @@ func handleBackup(c *cli.Context) {
 		log.Fatal(err)
 	}
 }
+
+// mustDownloadBackup downloads the backup streamed by the member at the
+// given endpoint, and extracts it into dir.
+func mustDownloadBackup(c *cli.Context, endpoint, dir string) {
+	tr, err := getTransport(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(endpoint, "/")+"/backup", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if uFlag := c.GlobalString("username"); uFlag != "" {
+		username, password, err := getUsernamePasswordFromFlag(uFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		log.Fatalf("failed downloading backup: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		log.Fatalf("failed downloading backup: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	var nwal int
+	r := tar.NewReader(resp.Body)
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("failed downloading backup: %v", err)
+		}
+		name := path.Clean(hdr.Name)
+		if !strings.HasPrefix(name, "member/") || strings.Contains(name, "..") {
+			log.Fatalf("unexpected file %q in backup", hdr.Name)
+		}
+		if path.Dir(name) == "member/wal" {
+			nwal++
+		}
+		p := path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(p), 0700); err != nil {
+			log.Fatal(err)
+		}
+		mustWriteFile(p, r)
+	}
+	if nwal == 0 {
+		log.Fatal("failed downloading backup: no wal file")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/codegangsta/cli"
@@ -20,6 +22,7 @@ func NewClusterHealthCommand() cli.Command {
 		Usage: "check the health of the etcd cluster",
 		Flags: []cli.Flag{
 			cli.BoolFlag{Name: "forever", Usage: "forever check the health every 10 second until CTRL+C"},
+			cli.BoolFlag{Name: "verbose", Usage: "print the status of the connections between the members"},
 		},
 		Action: handleClusterHealth,
 	}
@@ -106,6 +109,10 @@ func handleClusterHealth(c *cli.Context) {
 		} else {
 			fmt.Println("cluster is unhealthy")
 		}
+		if c.Bool("verbose") {
+			fmt.Println()
+			printPeerStats(hc, ms)
+		}
 
 		if !forever {
 			break
@@ -114,3 +121,67 @@ func handleClusterHealth(c *cli.Context) {
 		time.Sleep(10 * time.Second)
 	}
 }
+
+type peerStats struct {
+	ID               string    `json:"id"`
+	Active           bool      `json:"active"`
+	SendingStreams   []string  `json:"sendingStreams"`
+	ReceivingStreams []string  `json:"receivingStreams"`
+	BytesSent        int64     `json:"bytesSent"`
+	BytesReceived    int64     `json:"bytesReceived"`
+	RTT              float64   `json:"rtt"`
+	ClockDrift       float64   `json:"clockDrift"`
+	LastError        string    `json:"lastError"`
+	LastErrorTime    time.Time `json:"lastErrorTime"`
+}
+
+// printPeerStats prints the status of the connection of every member with
+// each of its peers, as seen by the member.
+func printPeerStats(hc http.Client, ms []client.Member) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "FROM\tTO\tACTIVE\tRTT\tCLOCK DRIFT\tSENT\tRECEIVED\tSTREAMS\tLAST ERROR")
+	for _, m := range ms {
+		var result struct {
+			Peers []peerStats `json:"peers"`
+		}
+		err := fmt.Errorf("no available published client urls")
+		for _, url := range m.ClientURLs {
+			if err = getJSON(hc, url+"/v2/stats/peers", &result); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t-\t-\tfailed to get the peer stats (%v)\n", m.ID, err)
+			continue
+		}
+		for _, p := range result.Peers {
+			lastErr := "-"
+			if p.LastError != "" {
+				lastErr = fmt.Sprintf("%s: %s", p.LastErrorTime.Format(time.RFC3339), p.LastError)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%t\t%.3fms\t%.3fms\t%d\t%d\tout:%s in:%s\t%s\n",
+				m.ID, p.ID, p.Active, p.RTT, p.ClockDrift, p.BytesSent, p.BytesReceived,
+				streamList(p.SendingStreams), streamList(p.ReceivingStreams), lastErr)
+		}
+	}
+	w.Flush()
+}
+
+func streamList(ss []string) string {
+	if len(ss) == 0 {
+		return "-"
+	}
+	return strings.Join(ss, ",")
+}
+
+func getJSON(hc http.Client, url string, v interface{}) error {
+	resp, err := hc.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
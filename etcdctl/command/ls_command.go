@@ -15,6 +15,7 @@
 package command
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/codegangsta/cli"
@@ -30,6 +31,7 @@ func NewLsCommand() cli.Command {
 			cli.BoolFlag{Name: "sort", Usage: "returns result in sorted order"},
 			cli.BoolFlag{Name: "recursive", Usage: "returns all key names recursively for the given path"},
 			cli.BoolFlag{Name: "p", Usage: "append slash (/) to directories"},
+			cli.IntFlag{Name: "page-size", Value: 0, Usage: "retrieve the directory in sorted pages of the given number of children"},
 		},
 		Action: func(c *cli.Context) {
 			lsCommandFunc(c, mustNewKeyAPI(c))
@@ -46,13 +48,34 @@ func lsCommandFunc(c *cli.Context, ki client.KeysAPI) {
 
 	sort := c.Bool("sort")
 	recursive := c.Bool("recursive")
+	pageSize := c.Int("page-size")
+	if pageSize < 0 {
+		handleError(ExitBadArgs, errors.New("--page-size must not be negative"))
+	}
 
-	resp, err := ki.Get(context.TODO(), key, &client.GetOptions{Sort: sort, Recursive: recursive})
-	if err != nil {
-		handleError(ExitServerError, err)
+	if pageSize == 0 {
+		resp, err := ki.Get(context.TODO(), key, &client.GetOptions{Sort: sort, Recursive: recursive})
+		if err != nil {
+			handleError(ExitServerError, err)
+		}
+		printLs(c, resp)
+		return
 	}
 
-	printLs(c, resp)
+	// each page is read at its own index, so the listing is not a
+	// consistent snapshot of the directory if it changes meanwhile.
+	opts := &client.GetOptions{Recursive: recursive, Limit: uint64(pageSize)}
+	for {
+		resp, err := ki.Get(context.TODO(), key, opts)
+		if err != nil {
+			handleError(ExitServerError, err)
+		}
+		printLs(c, resp)
+		if resp.Next == "" {
+			return
+		}
+		opts.StartKey = resp.Next
+	}
 }
 
 // printLs writes a response out in a manner similar to the `ls` command in unix.
@@ -35,8 +35,11 @@ func NewMemberCommand() cli.Command {
 				Action: actionMemberList,
 			},
 			{
-				Name:   "add",
-				Usage:  "add a new member to the etcd cluster",
+				Name:  "add",
+				Usage: "add a new member to the etcd cluster",
+				Flags: []cli.Flag{
+					cli.BoolFlag{Name: "witness", Usage: "add a witness member, which votes but neither stores keys nor serves clients"},
+				},
 				Action: actionMemberAdd,
 			},
 			{
@@ -68,10 +71,14 @@ func actionMemberList(c *cli.Context) {
 	}
 
 	for _, m := range members {
+		var witness string
+		if m.IsWitness {
+			witness = "[witness]"
+		}
 		if len(m.Name) == 0 {
-			fmt.Printf("%s[unstarted]: peerURLs=%s\n", m.ID, strings.Join(m.PeerURLs, ","))
+			fmt.Printf("%s[unstarted]%s: peerURLs=%s\n", m.ID, witness, strings.Join(m.PeerURLs, ","))
 		} else {
-			fmt.Printf("%s: name=%s peerURLs=%s clientURLs=%s\n", m.ID, m.Name, strings.Join(m.PeerURLs, ","), strings.Join(m.ClientURLs, ","))
+			fmt.Printf("%s%s: name=%s peerURLs=%s clientURLs=%s\n", m.ID, witness, m.Name, strings.Join(m.PeerURLs, ","), strings.Join(m.ClientURLs, ","))
 		}
 	}
 }
@@ -87,7 +94,13 @@ func actionMemberAdd(c *cli.Context) {
 
 	url := args[1]
 	ctx, cancel := context.WithTimeout(context.Background(), client.DefaultRequestTimeout)
-	m, err := mAPI.Add(ctx, url)
+	var m *client.Member
+	var err error
+	if c.Bool("witness") {
+		m, err = mAPI.AddWitness(ctx, url)
+	} else {
+		m, err = mAPI.Add(ctx, url)
+	}
 	cancel()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -96,7 +109,11 @@ func actionMemberAdd(c *cli.Context) {
 
 	newID := m.ID
 	newName := args[0]
-	fmt.Printf("Added member named %s with ID %s to cluster\n", newName, newID)
+	if m.IsWitness {
+		fmt.Printf("Added witness member named %s with ID %s to cluster\n", newName, newID)
+	} else {
+		fmt.Printf("Added member named %s with ID %s to cluster\n", newName, newID)
+	}
 
 	ctx, cancel = context.WithTimeout(context.Background(), client.DefaultRequestTimeout)
 	members, err := mAPI.List(ctx)
@@ -0,0 +1,203 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/codegangsta/cli"
+	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/pkg/encryption"
+	"github.com/coreos/etcd/pkg/fileutil"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/wal"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+func NewRestoreCommand() cli.Command {
+	return cli.Command{
+		Name:  "restore",
+		Usage: "restore a snapshot and the wal following it up to a point in time into a new single-member etcd dir",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "snapshot", Value: "", Usage: "Path to the full snapshot file to start from, possibly gzipped; none to replay the wal from its start"},
+			cli.StringSliceFlag{Name: "wal-dir", Value: new(cli.StringSlice), Usage: "Path to a dir holding wal files, possibly gzipped, e.g. an archive dir (can be given several times)"},
+			cli.IntFlag{Name: "to-index", Value: 0, Usage: "Raft index of the last entry to replay (0 for no limit)"},
+			cli.StringFlag{Name: "to-time", Value: "", Usage: "Replay the requests proposed up to this time, in RFC3339 format, e.g. 2015-10-21T07:28:00Z"},
+			cli.StringFlag{Name: "data-dir", Value: "", Usage: "Path to the new etcd data dir"},
+			cli.StringFlag{Name: "name", Value: "default", Usage: "Name of the restored member"},
+			cli.StringFlag{Name: "initial-advertise-peer-urls", Value: "http://localhost:2380,http://localhost:7001", Usage: "Peer URLs of the restored member"},
+			cli.StringFlag{Name: "encryption-key-provider", Value: "", Usage: "Key provider decrypting the snapshot and the wal, and encrypting the new dir, if encrypted"},
+		},
+		Action: handleRestore,
+	}
+}
+
+// handleRestore handles a request that intends to restore a data dir up to
+// a point in time.
+func handleRestore(c *cli.Context) {
+	if c.String("data-dir") == "" {
+		log.Fatal("no data dir provided (use --data-dir)")
+	}
+	if len(c.StringSlice("wal-dir")) == 0 {
+		log.Fatal("no wal dir provided (use --wal-dir)")
+	}
+	var to etcdserver.RestorePoint
+	if c.Int("to-index") < 0 {
+		log.Fatalf("invalid index %d", c.Int("to-index"))
+	}
+	to.Index = uint64(c.Int("to-index"))
+	if s := c.String("to-time"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			log.Fatalf("failed parsing time: %v", err)
+		}
+		to.Time = t
+	}
+	urls, err := types.NewURLs(strings.Split(c.String("initial-advertise-peer-urls"), ","))
+	if err != nil {
+		log.Fatalf("failed parsing peer urls: %v", err)
+	}
+	var kp encryption.KeyProvider
+	if spec := c.String("encryption-key-provider"); spec != "" {
+		if kp, err = encryption.NewKeyProvider(spec); err != nil {
+			log.Fatalf("failed loading key provider: %v", err)
+		}
+	}
+
+	tmpdir, err := ioutil.TempDir("", "etcd-restore")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var (
+		snapshot *raftpb.Snapshot
+		walsnap  walpb.Snapshot
+	)
+	if p := c.String("snapshot"); p != "" {
+		if strings.HasSuffix(p, ".gz") {
+			p = mustGunzip(p, path.Join(tmpdir, strings.TrimSuffix(path.Base(p), ".gz")))
+		}
+		if snapshot, err = snap.ReadWithKeyProvider(p, kp); err != nil {
+			log.Fatalf("failed reading snapshot: %v", err)
+		}
+		walsnap.Index, walsnap.Term = snapshot.Metadata.Index, snapshot.Metadata.Term
+	}
+
+	// gather the wal files into a single dir, so that they are read as
+	// the ones of a data dir.
+	waldir := path.Join(tmpdir, "wal")
+	if err := os.Mkdir(waldir, 0700); err != nil {
+		log.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, dir := range c.StringSlice("wal-dir") {
+		names, err := fileutil.ReadDir(dir)
+		if err != nil {
+			log.Fatalf("failed reading wal dir: %v", err)
+		}
+		for _, name := range names {
+			base := strings.TrimSuffix(name, ".gz")
+			if !strings.HasSuffix(base, ".wal") || seen[base] {
+				continue
+			}
+			seen[base] = true
+			if base != name {
+				mustGunzip(path.Join(dir, name), path.Join(waldir, base))
+			} else {
+				mustCopy(path.Join(dir, name), path.Join(waldir, base))
+			}
+		}
+	}
+
+	w, err := wal.OpenForReadWithOptions(waldir, walsnap, wal.Options{KeyProvider: kp})
+	if err != nil {
+		log.Fatalf("failed opening wal: %v", err)
+	}
+	_, state, ents, err := w.ReadAll()
+	w.Close()
+	switch err {
+	case nil:
+	case wal.ErrSnapshotNotFound:
+		fmt.Printf("Failed to find the match snapshot record %+v in wal, replaying the entries following it.\n", walsnap)
+	default:
+		log.Fatalf("failed reading wal: %v", err)
+	}
+	// only the committed entries are replayed.
+	for i, e := range ents {
+		if e.Index > state.Commit {
+			ents = ents[:i]
+			break
+		}
+	}
+
+	cfg := &etcdserver.ServerConfig{
+		Name:        c.String("name"),
+		DataDir:     c.String("data-dir"),
+		PeerURLs:    urls,
+		KeyProvider: kp,
+	}
+	res, err := etcdserver.Restore(cfg, snapshot, ents, to)
+	if err != nil {
+		log.Fatalf("failed restoring: %v", err)
+	}
+	fmt.Printf("Restored member %s of cluster %s at index %d into %s.\n", res.MemberID, res.ClusterID, res.Index, cfg.DataDir)
+}
+
+// mustGunzip decompresses the gzipped file src into dst, and returns dst.
+func mustGunzip(src, dst string) string {
+	f, err := os.Open(src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		log.Fatalf("failed decompressing %s: %v", src, err)
+	}
+	mustWriteFile(dst, zr)
+	return dst
+}
+
+// mustCopy copies the file src into dst.
+func mustCopy(src, dst string) {
+	f, err := os.Open(src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	mustWriteFile(dst, f)
+}
+
+func mustWriteFile(dst string, r io.Reader) {
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		log.Fatalf("failed writing %s: %v", dst, err)
+	}
+}
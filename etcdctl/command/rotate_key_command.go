@@ -0,0 +1,92 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/codegangsta/cli"
+	"github.com/coreos/etcd/pkg/encryption"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/storage/backend"
+	"github.com/coreos/etcd/wal"
+)
+
+func NewRotateKeyCommand() cli.Command {
+	return cli.Command{
+		Name:  "rotate-key",
+		Usage: "rewrap the data keys of an encrypted etcd directory with a new key-encryption key",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "data-dir", Value: "", Usage: "Path to the etcd data dir"},
+			cli.StringFlag{Name: "wal-dir", Value: "", Usage: "Path to the dedicated wal dir, if any"},
+			cli.StringFlag{Name: "old-key-provider", Value: "", Usage: "Key provider of the current key-encryption key, e.g. file:/path/to/key"},
+			cli.StringFlag{Name: "new-key-provider", Value: "", Usage: "Key provider of the new key-encryption key"},
+		},
+		Action: handleRotateKey,
+	}
+}
+
+// handleRotateKey rewraps the data keys of the WAL files, the snapshot files
+// and the v3 databases of a data directory, whose member must be stopped.
+// The encrypted data itself is left untouched.
+func handleRotateKey(c *cli.Context) {
+	if c.String("data-dir") == "" {
+		log.Fatal("no data dir provided (use --data-dir)")
+	}
+	from, err := encryption.NewKeyProvider(c.String("old-key-provider"))
+	if err != nil {
+		log.Fatalf("failed loading old key provider: %v", err)
+	}
+	to, err := encryption.NewKeyProvider(c.String("new-key-provider"))
+	if err != nil {
+		log.Fatalf("failed loading new key provider: %v", err)
+	}
+
+	memberDir := path.Join(c.String("data-dir"), "member")
+	walDir := c.String("wal-dir")
+	if walDir == "" {
+		walDir = path.Join(memberDir, "wal")
+	}
+	if err := wal.RotateKey(walDir, from, to); err != nil {
+		log.Fatalf("failed rotating key of wal %v: %v", walDir, err)
+	}
+	snapDir := path.Join(memberDir, "snap")
+	if err := snap.RotateKey(snapDir, from, to); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("failed rotating key of snapshots in %v: %v", snapDir, err)
+	}
+
+	dbs, err := filepath.Glob(path.Join(snapDir, "*.snap.db"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if p := path.Join(memberDir, "v3demo"); exist(p) {
+		dbs = append(dbs, p)
+	}
+	for _, p := range dbs {
+		if err := backend.RotateKey(p, from, to); err != nil {
+			log.Fatalf("failed rotating key of database %v: %v", p, err)
+		}
+	}
+	fmt.Printf("rotated the key of %v to %v\n", c.String("data-dir"), to.KeyID())
+}
+
+func exist(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
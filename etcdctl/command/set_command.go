@@ -33,6 +33,7 @@ func NewSetCommand() cli.Command {
 			cli.IntFlag{Name: "ttl", Value: 0, Usage: "key time-to-live"},
 			cli.StringFlag{Name: "swap-with-value", Value: "", Usage: "previous value"},
 			cli.IntFlag{Name: "swap-with-index", Value: 0, Usage: "previous index"},
+			cli.BoolFlag{Name: "refresh", Usage: "only refresh the ttl of an existing key, without changing its value or notifying watchers"},
 		},
 		Action: func(c *cli.Context) {
 			setCommandFunc(c, mustNewKeyAPI(c))
@@ -46,16 +47,28 @@ func setCommandFunc(c *cli.Context, ki client.KeysAPI) {
 		handleError(ExitBadArgs, errors.New("key required"))
 	}
 	key := c.Args()[0]
-	value, err := argOrStdin(c.Args(), os.Stdin, 1)
-	if err != nil {
-		handleError(ExitBadArgs, errors.New("value required"))
-	}
-
 	ttl := c.Int("ttl")
 	prevValue := c.String("swap-with-value")
 	prevIndex := c.Int("swap-with-index")
+	refresh := c.Bool("refresh")
+
+	var value string
+	if refresh {
+		if ttl <= 0 {
+			handleError(ExitBadArgs, errors.New("--ttl is required with --refresh"))
+		}
+		if len(c.Args()) > 1 {
+			handleError(ExitBadArgs, errors.New("value cannot be given with --refresh"))
+		}
+	} else {
+		var err error
+		value, err = argOrStdin(c.Args(), os.Stdin, 1)
+		if err != nil {
+			handleError(ExitBadArgs, errors.New("value required"))
+		}
+	}
 
-	resp, err := ki.Set(context.TODO(), key, value, &client.SetOptions{TTL: time.Duration(ttl) * time.Second, PrevIndex: uint64(prevIndex), PrevValue: prevValue})
+	resp, err := ki.Set(context.TODO(), key, value, &client.SetOptions{TTL: time.Duration(ttl) * time.Second, PrevIndex: uint64(prevIndex), PrevValue: prevValue, Refresh: refresh})
 	if err != nil {
 		handleError(ExitServerError, err)
 	}
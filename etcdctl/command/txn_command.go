@@ -0,0 +1,144 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/codegangsta/cli"
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/client"
+)
+
+// NewTxnCommand returns the CLI command for "txn".
+func NewTxnCommand() cli.Command {
+	return cli.Command{
+		Name:  "txn",
+		Usage: "atomically set or remove several keys if all the given conditions hold",
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{Name: "if-value", Value: new(cli.StringSlice), Usage: "condition as key=value, the key must have the given value"},
+			cli.StringSliceFlag{Name: "if-index", Value: new(cli.StringSlice), Usage: "condition as key=index, the key must have the given modified index"},
+			cli.StringSliceFlag{Name: "if-exists", Value: new(cli.StringSlice), Usage: "condition, the key must exist"},
+			cli.StringSliceFlag{Name: "if-not-exists", Value: new(cli.StringSlice), Usage: "condition, the key must not exist"},
+			cli.StringSliceFlag{Name: "set", Value: new(cli.StringSlice), Usage: "operation as key=value, set the key to the given value"},
+			cli.StringSliceFlag{Name: "rm", Value: new(cli.StringSlice), Usage: "operation, remove the key"},
+			cli.StringSliceFlag{Name: "rmdir", Value: new(cli.StringSlice), Usage: "operation, remove the directory and all its children"},
+			cli.IntFlag{Name: "ttl", Value: 0, Usage: "time-to-live of the keys set by the transaction"},
+		},
+		Action: func(c *cli.Context) {
+			txnCommandFunc(c, mustNewKeyAPI(c))
+		},
+	}
+}
+
+// txnCommandFunc executes the "txn" command.
+func txnCommandFunc(c *cli.Context, ki client.KeysAPI) {
+	cmps, err := txnCompares(c)
+	if err != nil {
+		handleError(ExitBadArgs, err)
+	}
+	ops, err := txnOps(c)
+	if err != nil {
+		handleError(ExitBadArgs, err)
+	}
+	if len(ops) == 0 {
+		handleError(ExitBadArgs, errors.New("at least one of --set, --rm or --rmdir is required"))
+	}
+
+	resp, err := ki.Txn(context.TODO(), cmps, ops)
+	if err != nil {
+		handleError(ExitServerError, err)
+	}
+
+	printTxnResponse(resp, c.GlobalString("output"))
+}
+
+func txnCompares(c *cli.Context) ([]client.TxnCompare, error) {
+	var cmps []client.TxnCompare
+	for _, s := range c.StringSlice("if-value") {
+		key, value, err := splitKeyValue(s)
+		if err != nil {
+			return nil, err
+		}
+		cmps = append(cmps, client.TxnCompare{Key: key, PrevValue: value})
+	}
+	for _, s := range c.StringSlice("if-index") {
+		key, value, err := splitKeyValue(s)
+		if err != nil {
+			return nil, err
+		}
+		index, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index in %q", s)
+		}
+		cmps = append(cmps, client.TxnCompare{Key: key, PrevIndex: index})
+	}
+	for _, key := range c.StringSlice("if-exists") {
+		cmps = append(cmps, client.TxnCompare{Key: key, PrevExist: client.PrevExist})
+	}
+	for _, key := range c.StringSlice("if-not-exists") {
+		cmps = append(cmps, client.TxnCompare{Key: key, PrevExist: client.PrevNoExist})
+	}
+	return cmps, nil
+}
+
+func txnOps(c *cli.Context) ([]client.TxnOp, error) {
+	ttl := time.Duration(c.Int("ttl")) * time.Second
+
+	var ops []client.TxnOp
+	for _, s := range c.StringSlice("set") {
+		key, value, err := splitKeyValue(s)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, client.TxnOp{Action: client.TxnSet, Key: key, Value: value, TTL: ttl})
+	}
+	for _, key := range c.StringSlice("rm") {
+		ops = append(ops, client.TxnOp{Action: client.TxnDelete, Key: key})
+	}
+	for _, key := range c.StringSlice("rmdir") {
+		ops = append(ops, client.TxnOp{Action: client.TxnDelete, Key: key, Dir: true, Recursive: true})
+	}
+	return ops, nil
+}
+
+// splitKeyValue splits the given "key=value" argument.
+func splitKeyValue(s string) (string, string, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return "", "", fmt.Errorf("expected key=value, got %q", s)
+	}
+	return s[:i], s[i+1:], nil
+}
+
+// printTxnResponse prints the result of every op of the transaction.
+func printTxnResponse(resp *client.TxnResponse, format string) {
+	if format == "json" {
+		b, err := json.Marshal(resp)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+	for _, r := range resp.Responses {
+		printResponseKey(r, format)
+	}
+}
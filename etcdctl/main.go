@@ -43,6 +43,8 @@ func main() {
 	}
 	app.Commands = []cli.Command{
 		command.NewBackupCommand(),
+		command.NewRestoreCommand(),
+		command.NewRotateKeyCommand(),
 		command.NewClusterHealthCommand(),
 		command.NewMakeCommand(),
 		command.NewMakeDirCommand(),
@@ -54,6 +56,7 @@ func main() {
 		command.NewSetDirCommand(),
 		command.NewUpdateCommand(),
 		command.NewUpdateDirCommand(),
+		command.NewTxnCommand(),
 		command.NewWatchCommand(),
 		command.NewExecWatchCommand(),
 		command.NewMemberCommand(),
@@ -21,12 +21,16 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/coreos/etcd/etcdserver"
 	"github.com/coreos/etcd/pkg/cors"
 	"github.com/coreos/etcd/pkg/flags"
 	"github.com/coreos/etcd/pkg/transport"
+	"github.com/coreos/etcd/rafthttp"
+	"github.com/coreos/etcd/snap"
 	"github.com/coreos/etcd/version"
+	"github.com/coreos/etcd/wal"
 )
 
 const (
@@ -79,12 +83,26 @@ type config struct {
 	lpurls, lcurls []url.URL
 	maxSnapFiles   uint
 	maxWalFiles    uint
+	maxSnapAge     time.Duration
+	maxWalAge      time.Duration
+	archiveDir     string
 	name           string
 	snapCount      uint64
+	snapDeltas     uint
 	// TODO: decouple tickMs and heartbeat tick (current heartbeat tick = 1).
 	// make ticks a cluster wide configuration.
 	TickMs     uint
 	ElectionMs uint
+	// snapshotSendRate limits the rate, in bytes per second, at which the
+	// snapshots are sent to the peers.
+	snapshotSendRate   uint64
+	peerCompression    *flags.StringsFlag
+	walSyncPolicy      *flags.StringsFlag
+	snapCompression    *flags.StringsFlag
+	archiveCompression *flags.StringsFlag
+	// encryptionKeyProvider is the spec of the key provider encrypting the
+	// data directory, if any.
+	encryptionKeyProvider string
 
 	// clustering
 	apurls, acurls      []url.URL
@@ -116,7 +134,8 @@ type config struct {
 
 	printVersion bool
 
-	v3demo bool
+	v3demo      bool
+	lazyRaftLog bool
 
 	ignored []string
 }
@@ -133,11 +152,28 @@ func NewConfig() *config {
 			fallbackFlagProxy,
 		),
 		ignored: ignored,
+		peerCompression: flags.NewStringsFlag(
+			string(rafthttp.CompressionNone),
+			string(rafthttp.CompressionGzip),
+		),
 		proxy: flags.NewStringsFlag(
 			proxyFlagOff,
 			proxyFlagReadonly,
 			proxyFlagOn,
 		),
+		archiveCompression: flags.NewStringsFlag(
+			string(snap.CompressionNone),
+			string(snap.CompressionGzip),
+		),
+		snapCompression: flags.NewStringsFlag(
+			string(snap.CompressionNone),
+			string(snap.CompressionGzip),
+		),
+		walSyncPolicy: flags.NewStringsFlag(
+			wal.SyncFsync.String(),
+			wal.SyncFdatasync.String(),
+			wal.SyncNone.String(),
+		),
 	}
 
 	cfg.FlagSet = flag.NewFlagSet("etcd", flag.ContinueOnError)
@@ -154,10 +190,36 @@ func NewConfig() *config {
 	fs.Var(flags.NewURLsValue("http://localhost:2379,http://localhost:4001"), "listen-client-urls", "List of URLs to listen on for client traffic")
 	fs.UintVar(&cfg.maxSnapFiles, "max-snapshots", defaultMaxSnapshots, "Maximum number of snapshot files to retain (0 is unlimited)")
 	fs.UintVar(&cfg.maxWalFiles, "max-wals", defaultMaxWALs, "Maximum number of wal files to retain (0 is unlimited)")
+	fs.DurationVar(&cfg.maxSnapAge, "max-snapshot-age", 0, "Maximum age of the snapshot files to retain (0 is unlimited)")
+	fs.DurationVar(&cfg.maxWalAge, "max-wal-age", 0, "Maximum age of the wal files to retain (0 is unlimited)")
+	fs.StringVar(&cfg.archiveDir, "archive-dir", "", "Path to the directory the purged wal and snapshot files are archived to")
+	fs.Var(cfg.archiveCompression, "archive-compression", fmt.Sprintf("Compression of the files archived. Valid values include %s", strings.Join(cfg.archiveCompression.Values, ", ")))
+	if err := cfg.archiveCompression.Set(string(snap.CompressionNone)); err != nil {
+		// Should never happen.
+		plog.Panicf("unexpected error setting up archive-compression flag: %v", err)
+	}
 	fs.StringVar(&cfg.name, "name", defaultName, "Unique human-readable name for this node")
 	fs.Uint64Var(&cfg.snapCount, "snapshot-count", etcdserver.DefaultSnapCount, "Number of committed transactions to trigger a snapshot")
+	fs.Var(cfg.snapCompression, "snapshot-compression", fmt.Sprintf("Compression of the snapshots saved. Valid values include %s", strings.Join(cfg.snapCompression.Values, ", ")))
+	if err := cfg.snapCompression.Set(string(snap.CompressionNone)); err != nil {
+		// Should never happen.
+		plog.Panicf("unexpected error setting up snapshot-compression flag: %v", err)
+	}
+	fs.UintVar(&cfg.snapDeltas, "snapshot-deltas", 0, "Number of delta snapshots, holding only the changes since the previous snapshot, saved between two full snapshots (0 disables delta snapshots)")
 	fs.UintVar(&cfg.TickMs, "heartbeat-interval", 100, "Time (in milliseconds) of a heartbeat interval.")
 	fs.UintVar(&cfg.ElectionMs, "election-timeout", 1000, "Time (in milliseconds) for an election to timeout.")
+	fs.Uint64Var(&cfg.snapshotSendRate, "snapshot-send-rate", 0, "Maximum rate (in bytes per second) at which a snapshot is sent to a peer (0 is unlimited).")
+	fs.Var(cfg.peerCompression, "peer-compression", fmt.Sprintf("Compression of the traffic sent to the peers which support it. Valid values include %s", strings.Join(cfg.peerCompression.Values, ", ")))
+	if err := cfg.peerCompression.Set(string(rafthttp.CompressionNone)); err != nil {
+		// Should never happen.
+		plog.Panicf("unexpected error setting up peer-compression flag: %v", err)
+	}
+	fs.Var(cfg.walSyncPolicy, "wal-sync-policy", fmt.Sprintf("How the wal entries are made durable before they are acknowledged. Valid values include %s", strings.Join(cfg.walSyncPolicy.Values, ", ")))
+	if err := cfg.walSyncPolicy.Set(wal.SyncFsync.String()); err != nil {
+		// Should never happen.
+		plog.Panicf("unexpected error setting up wal-sync-policy flag: %v", err)
+	}
+	fs.StringVar(&cfg.encryptionKeyProvider, "encryption-key-provider", "", "Key provider of the key-encryption key which encrypts the data directory, e.g. file:/path/to/key (empty means no encryption).")
 
 	// clustering
 	fs.Var(flags.NewURLsValue("http://localhost:2380,http://localhost:7001"), "initial-advertise-peer-urls", "List of this member's peer URLs to advertise to the rest of the cluster")
@@ -214,6 +276,7 @@ func NewConfig() *config {
 
 	// demo flag
 	fs.BoolVar(&cfg.v3demo, "experimental-v3demo", false, "Enable experimental v3 demo API")
+	fs.BoolVar(&cfg.lazyRaftLog, "experimental-lazy-raft-log", false, "Read the raft log entries out of the WAL files when needed instead of keeping them in memory")
 
 	// backwards-compatibility with v0.4.6
 	fs.Var(&flags.IPAddressPort{}, "addr", "DEPRECATED: Use -advertise-client-urls instead.")
@@ -307,6 +370,10 @@ func (cfg *config) Parse(arguments []string) error {
 	if cfg.ElectionMs > maxElectionMs {
 		return fmt.Errorf("-election-timeout[%vms] is too long, and should be set less than %vms", cfg.ElectionMs, maxElectionMs)
 	}
+	// the full snapshot the deltas are based on must be retained.
+	if cfg.maxSnapFiles > 0 && cfg.snapDeltas >= cfg.maxSnapFiles {
+		return fmt.Errorf("-snapshot-deltas[%v] should be less than -max-snapshots[%v]", cfg.snapDeltas, cfg.maxSnapFiles)
+	}
 
 	return nil
 }
@@ -38,6 +38,7 @@ import (
 	"github.com/coreos/etcd/etcdserver/etcdhttp"
 	"github.com/coreos/etcd/etcdserver/etcdserverpb"
 	"github.com/coreos/etcd/pkg/cors"
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/pkg/fileutil"
 	"github.com/coreos/etcd/pkg/osutil"
 	runtimeutil "github.com/coreos/etcd/pkg/runtime"
@@ -45,7 +46,9 @@ import (
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/proxy"
 	"github.com/coreos/etcd/rafthttp"
+	"github.com/coreos/etcd/snap"
 	"github.com/coreos/etcd/version"
+	"github.com/coreos/etcd/wal"
 )
 
 type dirType string
@@ -246,6 +249,17 @@ func startEtcd(cfg *config) (<-chan struct{}, error) {
 		plog.Infof("listening for client rpc on 127.0.0.1:12379")
 	}
 
+	walPolicy, err := wal.ParseSyncPolicy(cfg.walSyncPolicy.String())
+	if err != nil {
+		return nil, err
+	}
+	var kp encryption.KeyProvider
+	if cfg.encryptionKeyProvider != "" {
+		if kp, err = encryption.NewKeyProvider(cfg.encryptionKeyProvider); err != nil {
+			return nil, err
+		}
+	}
+
 	srvcfg := &etcdserver.ServerConfig{
 		Name:                cfg.name,
 		ClientURLs:          cfg.acurls,
@@ -253,8 +267,12 @@ func startEtcd(cfg *config) (<-chan struct{}, error) {
 		DataDir:             cfg.dir,
 		DedicatedWALDir:     cfg.walDir,
 		SnapCount:           cfg.snapCount,
+		SnapDeltas:          cfg.snapDeltas,
+		SnapCompression:     snap.Compression(cfg.snapCompression.String()),
 		MaxSnapFiles:        cfg.maxSnapFiles,
 		MaxWALFiles:         cfg.maxWalFiles,
+		MaxSnapAge:          cfg.maxSnapAge,
+		MaxWALAge:           cfg.maxWalAge,
 		InitialPeerURLsMap:  urlsmap,
 		InitialClusterToken: token,
 		DiscoveryURL:        cfg.durl,
@@ -264,7 +282,17 @@ func startEtcd(cfg *config) (<-chan struct{}, error) {
 		Transport:           pt,
 		TickMs:              cfg.TickMs,
 		ElectionTicks:       cfg.electionTicks(),
+		SnapshotSendRate:    cfg.snapshotSendRate,
+		PeerCompression:     rafthttp.Compression(cfg.peerCompression.String()),
+		WALSyncPolicy:       walPolicy,
+		KeyProvider:         kp,
 		V3demo:              cfg.v3demo,
+		LazyRaftLog:         cfg.lazyRaftLog,
+	}
+	if cfg.archiveDir != "" {
+		compress := cfg.archiveCompression.String() == string(snap.CompressionGzip)
+		srvcfg.SnapArchiver = fileutil.NewDirArchiver(path.Join(cfg.archiveDir, "snap"), compress)
+		srvcfg.WALArchiver = fileutil.NewDirArchiver(path.Join(cfg.archiveDir, "wal"), compress)
 	}
 	var s *etcdserver.EtcdServer
 	s, err = etcdserver.NewServer(srvcfg)
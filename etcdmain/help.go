@@ -33,12 +33,32 @@ member flags:
 		path to the data directory.
 	--wal-dir ''
 		path to the dedicated wal directory.
+	--wal-sync-policy 'fsync'
+		how the wal entries are made durable ('fsync', 'fdatasync' or 'none').
+	--encryption-key-provider ''
+		key provider of the key encrypting the data directory, e.g. 'file:/path/to/key'.
 	--snapshot-count '10000'
 		number of committed transactions to trigger a snapshot to disk.
+	--snapshot-compression 'none'
+		compression of the snapshots saved ('none' or 'gzip').
+	--snapshot-deltas '0'
+		number of delta snapshots saved between two full snapshots.
+	--max-snapshot-age '0s'
+		maximum age of the snapshot files to retain (0 is unlimited).
+	--max-wal-age '0s'
+		maximum age of the wal files to retain (0 is unlimited).
+	--archive-dir ''
+		path to the directory the purged wal and snapshot files are archived to.
+	--archive-compression 'none'
+		compression of the files archived ('none' or 'gzip').
 	--heartbeat-interval '100'
 		time (in milliseconds) of a heartbeat interval.
 	--election-timeout '1000'
 		time (in milliseconds) for an election to timeout. See tuning documentation for details.
+	--snapshot-send-rate '0'
+		maximum rate (in bytes per second) at which a snapshot is sent to a peer (0 is unlimited).
+	--peer-compression 'none'
+		compression of the traffic sent to the peers which support it ('none' or 'gzip').
 	--listen-peer-urls 'http://localhost:2380,http://localhost:7001'
 		list of URLs to listen on for peer traffic.
 	--listen-client-urls 'http://localhost:2379,http://localhost:4001'
@@ -129,5 +149,7 @@ experimental flags:
 
 	--experimental-v3demo 'false'
 		enable experimental v3 demo API
+	--experimental-lazy-raft-log 'false'
+		read the raft log entries out of the WAL files instead of keeping them in memory.
 `
 )
@@ -0,0 +1,132 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/store"
+)
+
+// Backup writes into w a tar archive of a backup of the data dir of the
+// member, taken while it keeps serving. The archive holds the files of the
+// last snapshot and of the snapshots it is based on, the wal files holding
+// the entries which follow it and the v3 database, if any, laid out as in a
+// data dir and encrypted as the files of the member are. The wal files are
+// copied up to the records saved once the v3 database is copied, so that
+// they hold the entries applied to it.
+func (s *EtcdServer) Backup(w io.Writer) error {
+	if s.r.witness {
+		return ErrWitness
+	}
+	var (
+		db     *os.File
+		dbSize int64
+	)
+	if s.kv != nil {
+		f, err := s.snapshotter.CreateTempDB()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+		if dbSize, err = s.snapshotKV(f); err != nil {
+			return err
+		}
+		db = f
+	}
+	// the entries applied to the copy of the v3 database are all saved
+	// once the apply loop is between two applies.
+	select {
+	case s.savedc <- struct{}{}:
+	case <-s.done:
+		return ErrStopped
+	}
+
+	// no snapshot is saved meanwhile, so that the wal files opened hold
+	// the entries following the snapshot.
+	s.snapMu.Lock()
+	md, sfs, err := s.snapshotter.OpenChain(store.IsDeltaSnapshot)
+	if err != nil && err != snap.ErrNoSnapshot {
+		s.snapMu.Unlock()
+		return err
+	}
+	wfs, size, err := s.r.storage.OpenSegments(md.Index)
+	s.snapMu.Unlock()
+	defer func() {
+		for _, f := range append(sfs, wfs...) {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, f := range sfs {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, path.Join("member", "snap", path.Base(f.Name())), f, fi.Size()); err != nil {
+			return err
+		}
+	}
+	for i, f := range wfs {
+		n := size
+		if i != len(wfs)-1 {
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			n = fi.Size()
+		}
+		if err := writeTarFile(tw, path.Join("member", "wal", path.Base(f.Name())), f, n); err != nil {
+			return err
+		}
+	}
+	if db != nil {
+		if _, err := db.Seek(0, os.SEEK_SET); err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, path.Join("member", "v3demo"), db, dbSize); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeTarFile writes into tw a file of the given name holding the given
+// number of bytes read from r.
+func writeTarFile(tw *tar.Writer, name string, r io.Reader, n int64) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    n,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, n)
+	return err
+}
@@ -229,6 +229,43 @@ func (c *cluster) Recover() {
 // ensures that it is still valid.
 func (c *cluster) ValidateConfigurationChange(cc raftpb.ConfChange) error {
 	members, removed := membersFromStore(c.store)
+	return validateConfChange(members, removed, cc)
+}
+
+// ValidateConfigurationChanges is like ValidateConfigurationChange for
+// the given changes applied together, in order. Each change is validated
+// against the membership the earlier changes lead to.
+func (c *cluster) ValidateConfigurationChanges(ccs []raftpb.ConfChange) error {
+	members, removed := membersFromStore(c.store)
+	for _, cc := range ccs {
+		if err := validateConfChange(members, removed, cc); err != nil {
+			return err
+		}
+		id := types.ID(cc.NodeID)
+		switch cc.Type {
+		case raftpb.ConfChangeAddNode:
+			m := new(Member)
+			if err := json.Unmarshal(cc.Context, m); err != nil {
+				plog.Panicf("unmarshal member should never fail: %v", err)
+			}
+			members[id] = m
+		case raftpb.ConfChangeRemoveNode:
+			delete(members, id)
+			removed[id] = true
+		case raftpb.ConfChangeUpdateNode:
+			m := new(Member)
+			if err := json.Unmarshal(cc.Context, m); err != nil {
+				plog.Panicf("unmarshal member should never fail: %v", err)
+			}
+			members[id].RaftAttributes = m.RaftAttributes
+		}
+	}
+	return nil
+}
+
+// validateConfChange validates the given ConfChange against the given
+// membership.
+func validateConfChange(members map[types.ID]*Member, removed map[types.ID]bool, cc raftpb.ConfChange) error {
 	id := types.ID(cc.NodeID)
 	if removed[id] {
 		return ErrIDRemoved
@@ -336,6 +373,9 @@ func (c *cluster) UpdateAttributes(id types.ID, attr Attributes) {
 func (c *cluster) UpdateRaftAttributes(id types.ID, raftAttr RaftAttributes) {
 	c.Lock()
 	defer c.Unlock()
+	// a member cannot become or stop being a witness once added, since
+	// a witness does not hold the key space.
+	raftAttr.IsWitness = c.members[id].IsWitness
 	b, err := json.Marshal(raftAttr)
 	if err != nil {
 		plog.Panicf("marshal raftAttributes should never fail: %v", err)
@@ -429,6 +469,7 @@ func ValidateClusterAndAssignIDs(local *cluster, existing *cluster) error {
 			return fmt.Errorf("unmatched member while checking PeerURLs")
 		}
 		lms[i].ID = ems[i].ID
+		lms[i].IsWitness = ems[i].IsWitness
 	}
 	local.members = make(map[types.ID]*Member)
 	for _, m := range lms {
@@ -394,6 +394,85 @@ func TestClusterValidateConfigurationChange(t *testing.T) {
 	}
 }
 
+func TestClusterValidateConfigurationChanges(t *testing.T) {
+	cl := newCluster("")
+	cl.SetStore(store.New())
+	for i := 1; i <= 3; i++ {
+		attr := RaftAttributes{PeerURLs: []string{fmt.Sprintf("http://127.0.0.1:%d", i)}}
+		cl.AddMember(&Member{ID: types.ID(i), RaftAttributes: attr})
+	}
+	member := func(id, port int) []byte {
+		attr := RaftAttributes{PeerURLs: []string{fmt.Sprintf("http://127.0.0.1:%d", port)}}
+		b, err := json.Marshal(&Member{ID: types.ID(id), RaftAttributes: attr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		ccs  []raftpb.ConfChange
+		werr error
+	}{
+		// replace a member by a new one at the same peer URL
+		{
+			[]raftpb.ConfChange{
+				{Type: raftpb.ConfChangeRemoveNode, NodeID: 1},
+				{Type: raftpb.ConfChangeAddNode, NodeID: 4, Context: member(4, 1)},
+			},
+			nil,
+		},
+		// the peer URL is still used by the member removed afterwards
+		{
+			[]raftpb.ConfChange{
+				{Type: raftpb.ConfChangeAddNode, NodeID: 4, Context: member(4, 1)},
+				{Type: raftpb.ConfChangeRemoveNode, NodeID: 1},
+			},
+			ErrPeerURLexists,
+		},
+		// move a member to the peer URL of a removed one
+		{
+			[]raftpb.ConfChange{
+				{Type: raftpb.ConfChangeRemoveNode, NodeID: 3},
+				{Type: raftpb.ConfChangeUpdateNode, NodeID: 2, Context: member(2, 3)},
+			},
+			nil,
+		},
+		// two new members at the same peer URL
+		{
+			[]raftpb.ConfChange{
+				{Type: raftpb.ConfChangeAddNode, NodeID: 4, Context: member(4, 4)},
+				{Type: raftpb.ConfChangeAddNode, NodeID: 5, Context: member(5, 4)},
+			},
+			ErrPeerURLexists,
+		},
+		// a member removed cannot be added back
+		{
+			[]raftpb.ConfChange{
+				{Type: raftpb.ConfChangeRemoveNode, NodeID: 2},
+				{Type: raftpb.ConfChangeAddNode, NodeID: 2, Context: member(2, 2)},
+			},
+			ErrIDRemoved,
+		},
+		{
+			[]raftpb.ConfChange{
+				{Type: raftpb.ConfChangeRemoveNode, NodeID: 2},
+				{Type: raftpb.ConfChangeRemoveNode, NodeID: 2},
+			},
+			ErrIDRemoved,
+		},
+	}
+	for i, tt := range tests {
+		if err := cl.ValidateConfigurationChanges(tt.ccs); err != tt.werr {
+			t.Errorf("#%d: validateConfigurationChanges error = %v, want %v", i, err, tt.werr)
+		}
+	}
+	// the cluster is left as is
+	if ids := cl.MemberIDs(); len(ids) != 3 {
+		t.Errorf("members = %v, want 3 members", ids)
+	}
+}
+
 func TestClusterGenID(t *testing.T) {
 	cs := newTestCluster([]*Member{
 		newTestMember(1, nil, "", nil),
@@ -21,8 +21,13 @@ import (
 	"sort"
 	"time"
 
+	"github.com/coreos/etcd/pkg/encryption"
+	"github.com/coreos/etcd/pkg/fileutil"
 	"github.com/coreos/etcd/pkg/netutil"
 	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/rafthttp"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/wal"
 )
 
 // ServerConfig holds the configuration of etcd as taken from the command line or discovery.
@@ -48,6 +53,37 @@ type ServerConfig struct {
 	TickMs        uint
 	ElectionTicks int
 
+	// SnapDeltas is the number of delta snapshots, holding the changes to
+	// the store since the previous snapshot, saved between two full
+	// snapshots.
+	SnapDeltas uint
+	// SnapCompression is the compression of the snapshots saved.
+	SnapCompression snap.Compression
+	// MaxSnapAge and MaxWALAge are the maximum age of the snapshot and WAL
+	// files retained, in addition to MaxSnapFiles and MaxWALFiles. 0 means
+	// no limit.
+	MaxSnapAge time.Duration
+	MaxWALAge  time.Duration
+	// SnapArchiver and WALArchiver, if not nil, archive the snapshot and
+	// WAL files before they are purged.
+	SnapArchiver fileutil.Archiver
+	WALArchiver  fileutil.Archiver
+	// SnapshotSendRate is the maximum rate, in bytes per second, at which
+	// a snapshot is sent to a member. 0 means no limit.
+	SnapshotSendRate uint64
+	// PeerCompression is the compression of the messages sent to the
+	// members which can decompress it.
+	PeerCompression rafthttp.Compression
+	// WALSyncPolicy is how the entries saved to the WAL are made durable.
+	WALSyncPolicy wal.SyncPolicy
+	// KeyProvider, if not nil, encrypts the WAL, the snapshots and the v3
+	// database of the member.
+	KeyProvider encryption.KeyProvider
+
+	// LazyRaftLog reads the raft log entries out of the WAL files when they
+	// are needed, instead of keeping them all in memory.
+	LazyRaftLog bool
+
 	V3demo bool
 }
 
@@ -117,6 +153,16 @@ func (c *ServerConfig) WALDir() string {
 
 func (c *ServerConfig) SnapDir() string { return path.Join(c.MemberDir(), "snap") }
 
+func (c *ServerConfig) v3demoPath() string { return path.Join(c.MemberDir(), "v3demo") }
+
+func (c *ServerConfig) walOptions() wal.Options {
+	return wal.Options{SyncPolicy: c.WALSyncPolicy, KeyProvider: c.KeyProvider}
+}
+
+func (c *ServerConfig) snapOptions() snap.Options {
+	return snap.Options{KeyProvider: c.KeyProvider, Compression: c.SnapCompression}
+}
+
 func (c *ServerConfig) ShouldDiscover() bool { return c.DiscoveryURL != "" }
 
 // ReqTimeout returns timeout for request to finish.
@@ -143,6 +189,9 @@ func (c *ServerConfig) print(initial bool) {
 	plog.Infof("heartbeat = %dms", c.TickMs)
 	plog.Infof("election = %dms", c.ElectionTicks*int(c.TickMs))
 	plog.Infof("snapshot count = %d", c.SnapCount)
+	if c.SnapDeltas > 0 {
+		plog.Infof("delta snapshots = %d", c.SnapDeltas)
+	}
 	if len(c.DiscoveryURL) != 0 {
 		plog.Infof("discovery URL= %s", c.DiscoveryURL)
 		if len(c.DiscoveryProxy) != 0 {
@@ -31,6 +31,8 @@ var (
 	ErrTimeout                    = errors.New("etcdserver: request timed out")
 	ErrTimeoutDueToLeaderFail     = errors.New("etcdserver: request timed out, possibly due to previous leader failure")
 	ErrTimeoutDueToConnectionLost = errors.New("etcdserver: request timed out, possibly due to connection lost")
+	ErrTooManyRequests            = errors.New("etcdserver: too many requests")
+	ErrWitness                    = errors.New("etcdserver: witness member does not serve requests")
 )
 
 func isKeyNotFound(err error) bool {
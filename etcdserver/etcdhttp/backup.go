@@ -0,0 +1,75 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/coreos/etcd/etcdserver/auth"
+)
+
+// backuper writes a backup of the data dir of a member.
+type backuper interface {
+	Backup(w io.Writer) error
+}
+
+// backupHandler streams a backup of the member as a tar archive, which
+// only the root user may download.
+type backupHandler struct {
+	sec    auth.Store
+	server backuper
+}
+
+func (h *backupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "GET") {
+		return
+	}
+	if !hasRootAccess(h.sec, r) {
+		writeNoAuth(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	bw := &backupWriter{w: w}
+	if err := h.server.Backup(bw); err != nil {
+		if !bw.written {
+			writeError(w, err)
+			return
+		}
+		// the error cannot be reported once the archive is being
+		// streamed, so the connection is closed for the client to see
+		// the archive truncated.
+		plog.Errorf("error streaming backup (%v)", err)
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+			}
+		}
+	}
+}
+
+// backupWriter records whether a backup started to be streamed.
+type backupWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (bw *backupWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	bw.written = true
+	return bw.w.Write(p)
+}
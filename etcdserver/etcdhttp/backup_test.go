@@ -0,0 +1,80 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdhttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coreos/etcd/etcdserver/auth"
+)
+
+type fakeBackuper struct {
+	data string
+	err  error
+}
+
+func (b *fakeBackuper) Backup(w io.Writer) error {
+	if _, err := io.WriteString(w, b.data); err != nil {
+		return err
+	}
+	return b.err
+}
+
+func TestServeBackup(t *testing.T) {
+	root := &auth.User{User: "root", Password: goodPassword, Roles: []string{"root"}}
+	tests := []struct {
+		method   string
+		password string
+		enabled  bool
+		backuper *fakeBackuper
+
+		wcode int
+		wbody string
+	}{
+		{"GET", "", false, &fakeBackuper{data: "archive"}, http.StatusOK, "archive"},
+		{"GET", "good", true, &fakeBackuper{data: "archive"}, http.StatusOK, "archive"},
+		{"GET", "bad", true, &fakeBackuper{data: "archive"}, http.StatusUnauthorized, ""},
+		{"POST", "", false, &fakeBackuper{data: "archive"}, http.StatusMethodNotAllowed, ""},
+		// an error before streaming is reported
+		{"GET", "", false, &fakeBackuper{err: errors.New("fail")}, http.StatusInternalServerError, ""},
+		// an error while streaming truncates the archive
+		{"GET", "", false, &fakeBackuper{data: "arch", err: errors.New("fail")}, http.StatusOK, "arch"},
+	}
+	for i, tt := range tests {
+		h := &backupHandler{
+			sec:    &mockAuthStore{user: root, enabled: tt.enabled},
+			server: tt.backuper,
+		}
+		req, err := http.NewRequest(tt.method, "http://localhost"+backupPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.password != "" {
+			req.SetBasicAuth("root", tt.password)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if tt.wbody != "" && rw.Body.String() != tt.wbody {
+			t.Errorf("#%d: body = %q, want %q", i, rw.Body.String(), tt.wbody)
+		}
+	}
+}
@@ -46,6 +46,7 @@ import (
 const (
 	authPrefix               = "/v2/auth"
 	keysPrefix               = "/v2/keys"
+	txnPrefix                = "/v2/txn"
 	deprecatedMachinesPrefix = "/v2/machines"
 	membersPrefix            = "/v2/members"
 	statsPrefix              = "/v2/stats"
@@ -54,8 +55,17 @@ const (
 	healthPath               = "/health"
 	versionPath              = "/version"
 	configPath               = "/config"
+	backupPath               = "/backup"
+
+	// watchProgressAction is the action of the progress notifications
+	// written to streaming watches.
+	watchProgressAction = "progress"
 )
 
+// watchProgressInterval is the interval at which streaming watches which
+// were not sent any event are sent a progress notification.
+var watchProgressInterval = 10 * time.Second
+
 // NewClientHandler generates a muxed http.Handler with the given parameters to serve etcd client requests.
 func NewClientHandler(server *etcdserver.EtcdServer, timeout time.Duration) http.Handler {
 	go capabilityLoop(server)
@@ -70,6 +80,14 @@ func NewClientHandler(server *etcdserver.EtcdServer, timeout time.Duration) http
 		timeout: timeout,
 	}
 
+	th := &txnHandler{
+		sec:     sec,
+		server:  server,
+		cluster: server.Cluster(),
+		timer:   server,
+		timeout: timeout,
+	}
+
 	sh := &statsHandler{
 		stats: server,
 	}
@@ -91,21 +109,30 @@ func NewClientHandler(server *etcdserver.EtcdServer, timeout time.Duration) http
 		cluster: server.Cluster(),
 	}
 
+	bh := &backupHandler{
+		sec:    sec,
+		server: server,
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", http.NotFound)
 	mux.Handle(healthPath, healthHandler(server))
 	mux.HandleFunc(versionPath, versionHandler(server.Cluster(), serveVersion))
 	mux.Handle(keysPrefix, kh)
 	mux.Handle(keysPrefix+"/", kh)
+	mux.Handle(txnPrefix, th)
 	mux.HandleFunc(statsPrefix+"/store", sh.serveStore)
 	mux.HandleFunc(statsPrefix+"/self", sh.serveSelf)
 	mux.HandleFunc(statsPrefix+"/leader", sh.serveLeader)
+	mux.HandleFunc(statsPrefix+"/raft", sh.serveRaft)
+	mux.HandleFunc(statsPrefix+"/peers", sh.servePeers)
 	mux.HandleFunc(varsPath, serveVars)
 	mux.HandleFunc(configPath+"/local/log", logHandleFunc)
 	mux.Handle(metricsPath, prometheus.Handler())
 	mux.Handle(membersPrefix, mh)
 	mux.Handle(membersPrefix+"/", mh)
 	mux.Handle(deprecatedMachinesPrefix, dmh)
+	mux.Handle(backupPath, bh)
 	handleAuth(mux, sech)
 
 	return requestLogger(mux)
@@ -161,6 +188,64 @@ func (h *keysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type txnHandler struct {
+	sec     auth.Store
+	server  etcdserver.Server
+	cluster etcdserver.Cluster
+	timer   etcdserver.RaftTimer
+	timeout time.Duration
+}
+
+func (h *txnHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "POST") {
+		return
+	}
+
+	w.Header().Set("X-Etcd-Cluster-ID", h.cluster.ID().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	req := httptypes.TxnRequest{}
+	if ok := unmarshalRequest(r, &req, w); !ok {
+		return
+	}
+	if err := cleanTxnKeys(&req); err != nil {
+		writeKeyError(w, err)
+		return
+	}
+	// A txn requires write access to every key it compares or modifies.
+	for _, c := range req.Compares {
+		if !hasKeyPrefixAccess(h.sec, r, c.Key, false) {
+			writeKeyNoAuth(w)
+			return
+		}
+	}
+	for _, op := range req.Ops {
+		if !hasKeyPrefixAccess(h.sec, r, op.Key, op.Recursive) {
+			writeKeyNoAuth(w)
+			return
+		}
+	}
+
+	rr, err := newTxnRequest(req)
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	resp, err := h.server.Do(ctx, rr)
+	if err != nil {
+		err = trimErrorPrefix(err, etcdserver.StoreKeysPrefix)
+		writeKeyError(w, err)
+		return
+	}
+	if err := writeTxnEvents(w, resp.Events, h.timer); err != nil {
+		// Should never be reached
+		plog.Errorf("error writing txn events (%v)", err)
+	}
+}
+
 type deprecatedMachinesHandler struct {
 	cluster etcdserver.Cluster
 }
@@ -218,12 +303,17 @@ func (h *membersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			writeError(w, httptypes.NewHTTPError(http.StatusNotFound, "Not found"))
 		}
 	case "POST":
+		if trimPrefix(r.URL.Path, membersPrefix) == "replace" {
+			h.serveReplace(ctx, w, r)
+			return
+		}
 		req := httptypes.MemberCreateRequest{}
 		if ok := unmarshalRequest(r, &req, w); !ok {
 			return
 		}
 		now := h.clock.Now()
 		m := etcdserver.NewMember("", req.PeerURLs, "", &now)
+		m.IsWitness = req.IsWitness
 		err := h.server.AddMember(ctx, *m)
 		switch {
 		case err == etcdserver.ErrIDExists || err == etcdserver.ErrPeerURLexists:
@@ -285,6 +375,53 @@ func (h *membersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveReplace atomically removes and adds members, and replies with the
+// added ones.
+func (h *membersHandler) serveReplace(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	req := httptypes.MemberReplaceRequest{}
+	if ok := unmarshalRequest(r, &req, w); !ok {
+		return
+	}
+	if len(req.Remove)+len(req.Add) == 0 {
+		writeError(w, httptypes.NewHTTPError(http.StatusBadRequest, "No member to replace"))
+		return
+	}
+	now := h.clock.Now()
+	removeIDs := make([]uint64, len(req.Remove))
+	for i, id := range req.Remove {
+		removeIDs[i] = uint64(id)
+	}
+	adds := make([]etcdserver.Member, len(req.Add))
+	ms := make([]*etcdserver.Member, len(req.Add))
+	for i, a := range req.Add {
+		ms[i] = etcdserver.NewMember("", a.PeerURLs, "", &now)
+		ms[i].IsWitness = a.IsWitness
+		adds[i] = *ms[i]
+	}
+	err := h.server.ReplaceMembers(ctx, removeIDs, adds)
+	switch {
+	case err == etcdserver.ErrIDExists || err == etcdserver.ErrPeerURLexists:
+		writeError(w, httptypes.NewHTTPError(http.StatusConflict, err.Error()))
+		return
+	case err == etcdserver.ErrIDRemoved:
+		writeError(w, httptypes.NewHTTPError(http.StatusGone, err.Error()))
+		return
+	case err == etcdserver.ErrIDNotFound:
+		writeError(w, httptypes.NewHTTPError(http.StatusNotFound, err.Error()))
+		return
+	case err != nil:
+		plog.Errorf("error replacing members (%v)", err)
+		writeError(w, err)
+		return
+	}
+	res := newMemberCollection(ms)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		plog.Warningf("failed to encode members response (%v)", err)
+	}
+}
+
 type statsHandler struct {
 	stats stats.Stats
 }
@@ -318,6 +455,22 @@ func (h *statsHandler) serveLeader(w http.ResponseWriter, r *http.Request) {
 	w.Write(stats)
 }
 
+func (h *statsHandler) serveRaft(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "GET") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(h.stats.RaftStats())
+}
+
+func (h *statsHandler) servePeers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r.Method, "GET") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(h.stats.PeerStats())
+}
+
 func serveVars(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	fmt.Fprintf(w, "{\n")
@@ -448,6 +601,14 @@ func parseKeyRequest(r *http.Request, clock clockwork.Clock) (etcdserverpb.Reque
 		)
 	}
 
+	var limit uint64
+	if limit, err = getUint64(r.Form, "limit"); err != nil {
+		return emptyReq, etcdErr.NewRequestError(
+			etcdErr.EcodeInvalidField,
+			`invalid value for "limit"`,
+		)
+	}
+
 	var rec, sort, wait, dir, quorum, stream bool
 	if rec, err = getBool(r.Form, "recursive"); err != nil {
 		return emptyReq, etcdErr.NewRequestError(
@@ -494,6 +655,50 @@ func parseKeyRequest(r *http.Request, clock clockwork.Clock) (etcdserverpb.Reque
 		)
 	}
 
+	var omitPrevNode, omitValue bool
+	if omitPrevNode, err = getBool(r.Form, "omitPrevNode"); err != nil {
+		return emptyReq, etcdErr.NewRequestError(
+			etcdErr.EcodeInvalidField,
+			`invalid value for "omitPrevNode"`,
+		)
+	}
+	if omitValue, err = getBool(r.Form, "omitValue"); err != nil {
+		return emptyReq, etcdErr.NewRequestError(
+			etcdErr.EcodeInvalidField,
+			`invalid value for "omitValue"`,
+		)
+	}
+
+	// filterAction may be given several times, or as a comma-separated list
+	var actions []string
+	for _, v := range r.Form["filterAction"] {
+		for _, a := range strings.Split(v, ",") {
+			if a != "" {
+				actions = append(actions, a)
+			}
+		}
+	}
+	keyGlob := r.FormValue("filterKey")
+
+	if (len(actions) > 0 || keyGlob != "" || omitPrevNode || omitValue) && !wait {
+		return emptyReq, etcdErr.NewRequestError(
+			etcdErr.EcodeInvalidField,
+			`"filterAction", "filterKey", "omitPrevNode" and "omitValue" can only be used with "wait"`,
+		)
+	}
+
+	var startKey string
+	if sk := r.FormValue("startKey"); sk != "" {
+		startKey = path.Join(etcdserver.StoreKeysPrefix, sk)
+	}
+
+	if (limit > 0 || startKey != "") && (r.Method != "GET" || wait) {
+		return emptyReq, etcdErr.NewRequestError(
+			etcdErr.EcodeInvalidField,
+			`"limit" and "startKey" can only be used with GET requests which do not wait`,
+		)
+	}
+
 	pV := r.FormValue("prevValue")
 	if _, ok := r.Form["prevValue"]; ok && pV == "" {
 		return emptyReq, etcdErr.NewRequestError(
@@ -529,6 +734,46 @@ func parseKeyRequest(r *http.Request, clock clockwork.Clock) (etcdserverpb.Reque
 		pe = &bv
 	}
 
+	// refresh is nullable, so leave it null if not specified
+	var refresh *bool
+	if _, ok := r.Form["refresh"]; ok {
+		bv, err := getBool(r.Form, "refresh")
+		if err != nil {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				"invalid value for refresh",
+			)
+		}
+		refresh = &bv
+	}
+
+	if refresh != nil && *refresh {
+		if r.Method != "PUT" {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"refresh" can only be used with PUT requests`,
+			)
+		}
+		if _, ok := r.Form["value"]; ok {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeRefreshValue,
+				`A value was provided on a refresh`,
+			)
+		}
+		if ttl == nil {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeRefreshTTLRequired,
+				`No TTL value set`,
+			)
+		}
+		if pV != "" || pIdx != 0 || pe != nil || dir {
+			return emptyReq, etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				`"refresh" cannot be combined with "prevValue", "prevIndex", "prevExist" or "dir"`,
+			)
+		}
+	}
+
 	rr := etcdserverpb.Request{
 		Method:    r.Method,
 		Path:      p,
@@ -543,6 +788,14 @@ func parseKeyRequest(r *http.Request, clock clockwork.Clock) (etcdserverpb.Reque
 		Sorted:    sort,
 		Quorum:    quorum,
 		Stream:    stream,
+		Refresh:   refresh,
+		Limit:     limit,
+		StartKey:  startKey,
+
+		WatchActions:    actions,
+		WatchKeyGlob:    keyGlob,
+		WatchNoPrevNode: omitPrevNode,
+		WatchNoValue:    omitValue,
 	}
 
 	if pe != nil {
@@ -558,6 +811,95 @@ func parseKeyRequest(r *http.Request, clock clockwork.Clock) (etcdserverpb.Reque
 	return rr, nil
 }
 
+// cleanTxnKeys cleans the keys of the given TxnRequest, which must all be
+// under the key space once joined to StoreKeysPrefix.
+func cleanTxnKeys(req *httptypes.TxnRequest) error {
+	clean := func(key string) (string, error) {
+		p := path.Join(etcdserver.StoreKeysPrefix, key)
+		if !strings.HasPrefix(p, etcdserver.StoreKeysPrefix+"/") {
+			return "", etcdErr.NewRequestError(
+				etcdErr.EcodeInvalidField,
+				fmt.Sprintf("invalid txn key %q", key),
+			)
+		}
+		return strings.TrimPrefix(p, etcdserver.StoreKeysPrefix), nil
+	}
+	var err error
+	for i := range req.Compares {
+		if req.Compares[i].Key, err = clean(req.Compares[i].Key); err != nil {
+			return err
+		}
+	}
+	for i := range req.Ops {
+		if req.Ops[i].Key, err = clean(req.Ops[i].Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newTxnRequest converts the given TxnRequest, whose keys are clean, into a
+// "TXN" etcdserverpb.Request carrying an etcdserver.TxnRequest, whose keys
+// are under StoreKeysPrefix.
+func newTxnRequest(req httptypes.TxnRequest) (etcdserverpb.Request, error) {
+	txn := etcdserver.TxnRequest{
+		Compares: make([]etcdserver.TxnCompare, len(req.Compares)),
+		Ops:      make([]etcdserver.TxnOp, len(req.Ops)),
+	}
+	for i, c := range req.Compares {
+		txn.Compares[i] = etcdserver.TxnCompare{
+			Key:       etcdserver.StoreKeysPrefix + c.Key,
+			PrevValue: c.PrevValue,
+			PrevIndex: c.PrevIndex,
+			PrevExist: c.PrevExist,
+		}
+	}
+	for i, op := range req.Ops {
+		txn.Ops[i] = etcdserver.TxnOp{
+			Action:    op.Action,
+			Key:       etcdserver.StoreKeysPrefix + op.Key,
+			Value:     op.Value,
+			Dir:       op.Dir,
+			Recursive: op.Recursive,
+			TTL:       op.TTL,
+		}
+	}
+	b, err := json.Marshal(txn)
+	if err != nil {
+		return etcdserverpb.Request{}, err
+	}
+	return etcdserverpb.Request{
+		Method: "TXN",
+		Path:   etcdserver.StoreKeysPrefix,
+		Val:    string(b),
+	}, nil
+}
+
+// writeTxnEvents trims the prefix of key path in the Events applied by a
+// transaction, serializes them and writes the resulting JSON to the given
+// ResponseWriter, along with the appropriate headers.
+func writeTxnEvents(w http.ResponseWriter, evs []*store.Event, rt etcdserver.RaftTimer) error {
+	if len(evs) == 0 {
+		return errors.New("cannot write empty txn Events!")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", fmt.Sprint(evs[0].EtcdIndex))
+	w.Header().Set("X-Raft-Index", fmt.Sprint(rt.Index()))
+	w.Header().Set("X-Raft-Term", fmt.Sprint(rt.Term()))
+
+	resp := struct {
+		Action string         `json:"action"`
+		Events []*store.Event `json:"events"`
+	}{
+		Action: store.Txn,
+		Events: make([]*store.Event, len(evs)),
+	}
+	for i, ev := range evs {
+		resp.Events[i] = trimEventPrefix(ev, etcdserver.StoreKeysPrefix)
+	}
+	return json.NewEncoder(w).Encode(resp)
+}
+
 // writeKeyEvent trims the prefix of key path in a single Event under
 // StoreKeysPrefix, serializes it and writes the resulting JSON to the given
 // ResponseWriter, along with the appropriate headers.
@@ -594,6 +936,10 @@ func writeKeyError(w http.ResponseWriter, err error) {
 		e.WriteTo(w)
 	default:
 		switch err {
+		case etcdserver.ErrTooManyRequests:
+			ee := etcdErr.NewError(etcdErr.EcodeTooManyRequests, err.Error(), 0)
+			ee.WriteTo(w)
+			return
 		case etcdserver.ErrTimeoutDueToLeaderFail, etcdserver.ErrTimeoutDueToConnectionLost:
 			plog.Error(err)
 		default:
@@ -621,6 +967,15 @@ func handleKeyWatch(ctx context.Context, w http.ResponseWriter, wa store.Watcher
 	// Ensure headers are flushed early, in case of long polling
 	w.(http.Flusher).Flush()
 
+	var progressc <-chan time.Time
+	if stream {
+		t := time.NewTicker(watchProgressInterval)
+		defer t.Stop()
+		progressc = t.C
+	}
+	// sent is set if an event was sent since the last progress tick
+	sent := false
+
 	for {
 		select {
 		case <-nch:
@@ -629,6 +984,23 @@ func handleKeyWatch(ctx context.Context, w http.ResponseWriter, wa store.Watcher
 		case <-ctx.Done():
 			// Timed out. net/http will close the connection for us, so nothing to do.
 			return
+		case <-progressc:
+			if sent {
+				sent = false
+				continue
+			}
+			// The index must be read before checking for pending events:
+			// all the events up to it are in the channel once it is read.
+			idx := wa.CurrentIndex()
+			if len(ech) != 0 {
+				continue
+			}
+			ev := &watchStreamEvent{Event: &store.Event{Action: watchProgressAction}, EtcdIndex: idx}
+			if err := json.NewEncoder(w).Encode(ev); err != nil {
+				plog.Warningf("error writing watch progress (%v)", err)
+				return
+			}
+			w.(http.Flusher).Flush()
 		case ev, ok := <-ech:
 			if !ok {
 				// If the channel is closed this may be an indication of
@@ -637,19 +1009,31 @@ func handleKeyWatch(ctx context.Context, w http.ResponseWriter, wa store.Watcher
 				return
 			}
 			ev = trimEventPrefix(ev, etcdserver.StoreKeysPrefix)
-			if err := json.NewEncoder(w).Encode(ev); err != nil {
-				// Should never be reached
-				plog.Warningf("error writing event (%v)", err)
+			if !stream {
+				if err := json.NewEncoder(w).Encode(ev); err != nil {
+					// Should never be reached
+					plog.Warningf("error writing event (%v)", err)
+				}
 				return
 			}
-			if !stream {
+			if err := json.NewEncoder(w).Encode(&watchStreamEvent{Event: ev, EtcdIndex: ev.EtcdIndex}); err != nil {
+				// Should never be reached
+				plog.Warningf("error writing event (%v)", err)
 				return
 			}
 			w.(http.Flusher).Flush()
+			sent = true
 		}
 	}
 }
 
+// watchStreamEvent is an event as written to streaming watches. It carries
+// the EtcdIndex of the store when the event was notified.
+type watchStreamEvent struct {
+	*store.Event
+	EtcdIndex uint64 `json:"etcdIndex"`
+}
+
 func trimEventPrefix(ev *store.Event, prefix string) *store.Event {
 	if ev == nil {
 		return nil
@@ -659,6 +1043,7 @@ func trimEventPrefix(ev *store.Event, prefix string) *store.Event {
 	e := ev.Clone()
 	e.Node = trimNodeExternPrefix(e.Node, prefix)
 	e.PrevNode = trimNodeExternPrefix(e.PrevNode, prefix)
+	e.Next = strings.TrimPrefix(e.Next, prefix)
 	return e
 }
 
@@ -758,6 +1143,7 @@ func newMember(m *etcdserver.Member) httptypes.Member {
 		Name:       m.Name,
 		PeerURLs:   make([]string, len(m.PeerURLs)),
 		ClientURLs: make([]string, len(m.ClientURLs)),
+		IsWitness:  m.IsWitness,
 	}
 
 	copy(tm.PeerURLs, m.PeerURLs)
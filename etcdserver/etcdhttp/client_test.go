@@ -33,6 +33,7 @@ import (
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	etcdErr "github.com/coreos/etcd/error"
 	"github.com/coreos/etcd/etcdserver"
+	"github.com/coreos/etcd/etcdserver/auth"
 	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
 	"github.com/coreos/etcd/etcdserver/etcdserverpb"
 	"github.com/coreos/etcd/pkg/testutil"
@@ -117,6 +118,11 @@ func (s *serverRecorder) UpdateMember(_ context.Context, m etcdserver.Member) er
 	return nil
 }
 
+func (s *serverRecorder) ReplaceMembers(_ context.Context, ids []uint64, ms []etcdserver.Member) error {
+	s.actions = append(s.actions, action{name: "ReplaceMembers", params: []interface{}{ids, ms}})
+	return nil
+}
+
 func (s *serverRecorder) ClusterVersion() *semver.Version { return nil }
 
 type action struct {
@@ -153,6 +159,9 @@ func (rs *resServer) AddMember(_ context.Context, _ etcdserver.Member) error
 func (rs *resServer) RemoveMember(_ context.Context, _ uint64) error            { return nil }
 func (rs *resServer) UpdateMember(_ context.Context, _ etcdserver.Member) error { return nil }
 func (rs *resServer) ClusterVersion() *semver.Version                           { return nil }
+func (rs *resServer) ReplaceMembers(_ context.Context, _ []uint64, _ []etcdserver.Member) error {
+	return nil
+}
 
 func boolp(b bool) *bool { return &b }
 
@@ -164,13 +173,15 @@ func (drt dummyRaftTimer) Term() uint64  { return uint64(5) }
 type dummyWatcher struct {
 	echan chan *store.Event
 	sidx  uint64
+	cidx  uint64
 }
 
 func (w *dummyWatcher) EventChan() chan *store.Event {
 	return w.echan
 }
-func (w *dummyWatcher) StartIndex() uint64 { return w.sidx }
-func (w *dummyWatcher) Remove()            {}
+func (w *dummyWatcher) StartIndex() uint64   { return w.sidx }
+func (w *dummyWatcher) CurrentIndex() uint64 { return w.cidx }
+func (w *dummyWatcher) Remove()              {}
 
 func TestBadParseRequest(t *testing.T) {
 	tests := []struct {
@@ -314,6 +325,62 @@ func TestBadParseRequest(t *testing.T) {
 			),
 			etcdErr.EcodeInvalidField,
 		},
+		// limit must be a number
+		{
+			mustNewRequest(t, "foo?limit=garbage"),
+			etcdErr.EcodeInvalidField,
+		},
+		// limit and startKey are only valid with GET requests which do not wait
+		{
+			mustNewForm(t, "foo", url.Values{"limit": []string{"10"}}),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewRequest(t, "foo?wait=true&startKey=/foo/bar"),
+			etcdErr.EcodeInvalidField,
+		},
+		// watch filters are only valid with wait
+		{
+			mustNewRequest(t, "foo?filterAction=set"),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewRequest(t, "foo?omitValue=true"),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewRequest(t, "foo?wait=true&omitPrevNode=garbage"),
+			etcdErr.EcodeInvalidField,
+		},
+		// refresh must be a bool
+		{
+			mustNewForm(t, "foo", url.Values{"refresh": []string{"garbage"}}),
+			etcdErr.EcodeInvalidField,
+		},
+		// refresh is only valid with PUT requests
+		{
+			mustNewPostForm(t, "foo", url.Values{"refresh": []string{"true"}, "ttl": []string{"10"}}),
+			etcdErr.EcodeInvalidField,
+		},
+		// refresh cannot change the value
+		{
+			mustNewForm(t, "foo", url.Values{"refresh": []string{"true"}, "ttl": []string{"10"}, "value": []string{"bar"}}),
+			etcdErr.EcodeRefreshValue,
+		},
+		// refresh requires a ttl
+		{
+			mustNewForm(t, "foo", url.Values{"refresh": []string{"true"}}),
+			etcdErr.EcodeRefreshTTLRequired,
+		},
+		// refresh cannot be combined with conditions
+		{
+			mustNewForm(t, "foo", url.Values{"refresh": []string{"true"}, "ttl": []string{"10"}, "prevValue": []string{"bar"}}),
+			etcdErr.EcodeInvalidField,
+		},
+		{
+			mustNewForm(t, "foo", url.Values{"refresh": []string{"true"}, "ttl": []string{"10"}, "prevExist": []string{"true"}}),
+			etcdErr.EcodeInvalidField,
+		},
 	}
 	for i, tt := range tests {
 		got, err := parseKeyRequest(tt.in, clockwork.NewFakeClock())
@@ -351,6 +418,29 @@ func TestGoodParseRequest(t *testing.T) {
 				Path:   path.Join(etcdserver.StoreKeysPrefix, "/foo"),
 			},
 		},
+		{
+			// watch filters specified
+			mustNewRequest(t, "foo?wait=true&filterAction=set,delete&filterAction=expire&filterKey=*/bar&omitPrevNode=true&omitValue=true"),
+			etcdserverpb.Request{
+				Method:          "GET",
+				Path:            path.Join(etcdserver.StoreKeysPrefix, "/foo"),
+				Wait:            true,
+				WatchActions:    []string{"set", "delete", "expire"},
+				WatchKeyGlob:    "*/bar",
+				WatchNoPrevNode: true,
+				WatchNoValue:    true,
+			},
+		},
+		{
+			// limit and startKey specified
+			mustNewRequest(t, "foo?limit=10&startKey=/foo/bar"),
+			etcdserverpb.Request{
+				Method:   "GET",
+				Path:     path.Join(etcdserver.StoreKeysPrefix, "/foo"),
+				Limit:    10,
+				StartKey: path.Join(etcdserver.StoreKeysPrefix, "/foo/bar"),
+			},
+		},
 		{
 			// value specified
 			mustNewForm(
@@ -496,6 +586,20 @@ func TestGoodParseRequest(t *testing.T) {
 				Path:      path.Join(etcdserver.StoreKeysPrefix, "/foo"),
 			},
 		},
+		{
+			// refresh should be non-null if specified
+			mustNewForm(
+				t,
+				"foo",
+				url.Values{"refresh": []string{"true"}, "ttl": []string{"10"}},
+			),
+			etcdserverpb.Request{
+				Method:     "PUT",
+				Refresh:    boolp(true),
+				Path:       path.Join(etcdserver.StoreKeysPrefix, "/foo"),
+				Expiration: fc.Now().Add(10 * time.Second).UnixNano(),
+			},
+		},
 		// mix various fields
 		{
 			mustNewForm(
@@ -711,6 +815,45 @@ func TestServeMembersCreate(t *testing.T) {
 	}
 }
 
+func TestServeMembersReplace(t *testing.T) {
+	u := testutil.MustNewURL(t, path.Join(membersPrefix, "replace"))
+	b := []byte(`{"remove":["BEEF"],"add":[{"peerURLs":["http://127.0.0.1:1"]}]}`)
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s := &serverRecorder{}
+	h := &membersHandler{
+		server:  s,
+		clock:   clockwork.NewFakeClock(),
+		cluster: &fakeCluster{id: 1},
+	}
+	rw := httptest.NewRecorder()
+
+	h.ServeHTTP(rw, req)
+
+	wcode := http.StatusCreated
+	if rw.Code != wcode {
+		t.Errorf("code=%d, want %d", rw.Code, wcode)
+	}
+	wb := `{"members":[{"id":"2a86a83729b330d5","name":"","peerURLs":["http://127.0.0.1:1"],"clientURLs":[]}]}` + "\n"
+	if g := rw.Body.String(); g != wb {
+		t.Errorf("got body=%q, want %q", g, wb)
+	}
+
+	wm := etcdserver.Member{
+		ID: 3064321551348478165,
+		RaftAttributes: etcdserver.RaftAttributes{
+			PeerURLs: []string{"http://127.0.0.1:1"},
+		},
+	}
+	wactions := []action{{name: "ReplaceMembers", params: []interface{}{[]uint64{0xBEEF}, []etcdserver.Member{wm}}}}
+	if !reflect.DeepEqual(s.actions, wactions) {
+		t.Errorf("actions = %+v, want %+v", s.actions, wactions)
+	}
+}
+
 func TestServeMembersDelete(t *testing.T) {
 	req := &http.Request{
 		Method: "DELETE",
@@ -847,6 +990,44 @@ func TestServeMembersFail(t *testing.T) {
 
 			http.StatusBadRequest,
 		},
+		{
+			// no member to replace
+			&http.Request{
+				URL:    testutil.MustNewURL(t, path.Join(membersPrefix, "replace")),
+				Method: "POST",
+				Body:   ioutil.NopCloser(strings.NewReader(`{}`)),
+				Header: map[string][]string{"Content-Type": {"application/json"}},
+			},
+			nil,
+
+			http.StatusBadRequest,
+		},
+		{
+			// bad member ID to remove
+			&http.Request{
+				URL:    testutil.MustNewURL(t, path.Join(membersPrefix, "replace")),
+				Method: "POST",
+				Body:   ioutil.NopCloser(strings.NewReader(`{"remove": ["zzz"]}`)),
+				Header: map[string][]string{"Content-Type": {"application/json"}},
+			},
+			nil,
+
+			http.StatusBadRequest,
+		},
+		{
+			// etcdserver.ReplaceMembers error
+			&http.Request{
+				URL:    testutil.MustNewURL(t, path.Join(membersPrefix, "replace")),
+				Method: "POST",
+				Body:   ioutil.NopCloser(strings.NewReader(`{"remove": ["BEEF"]}`)),
+				Header: map[string][]string{"Content-Type": {"application/json"}},
+			},
+			&errServer{
+				etcdserver.ErrIDNotFound,
+			},
+
+			http.StatusNotFound,
+		},
 		{
 			// etcdserver.AddMember error
 			&http.Request{
@@ -1225,6 +1406,8 @@ type dummyStats struct {
 func (ds *dummyStats) SelfStats() []byte                 { return ds.data }
 func (ds *dummyStats) LeaderStats() []byte               { return ds.data }
 func (ds *dummyStats) StoreStats() []byte                { return ds.data }
+func (ds *dummyStats) RaftStats() []byte                 { return ds.data }
+func (ds *dummyStats) PeerStats() []byte                 { return ds.data }
 func (ds *dummyStats) UpdateRecvApp(_ types.ID, _ int64) {}
 
 func TestServeSelfStats(t *testing.T) {
@@ -1320,6 +1503,46 @@ func TestServeStoreStats(t *testing.T) {
 
 }
 
+func TestServeRaftStats(t *testing.T) {
+	wb := []byte("some statistics")
+	w := string(wb)
+	sh := &statsHandler{
+		stats: &dummyStats{data: wb},
+	}
+	rw := httptest.NewRecorder()
+	sh.serveRaft(rw, &http.Request{Method: "GET"})
+	if rw.Code != http.StatusOK {
+		t.Errorf("code = %d, want %d", rw.Code, http.StatusOK)
+	}
+	wct := "application/json"
+	if gct := rw.Header().Get("Content-Type"); gct != wct {
+		t.Errorf("Content-Type = %q, want %q", gct, wct)
+	}
+	if g := rw.Body.String(); g != w {
+		t.Errorf("body = %s, want %s", g, w)
+	}
+}
+
+func TestServePeerStats(t *testing.T) {
+	wb := []byte("some statistics")
+	w := string(wb)
+	sh := &statsHandler{
+		stats: &dummyStats{data: wb},
+	}
+	rw := httptest.NewRecorder()
+	sh.servePeers(rw, &http.Request{Method: "GET"})
+	if rw.Code != http.StatusOK {
+		t.Errorf("code = %d, want %d", rw.Code, http.StatusOK)
+	}
+	wct := "application/json"
+	if gct := rw.Header().Get("Content-Type"); gct != wct {
+		t.Errorf("Content-Type = %q, want %q", gct, wct)
+	}
+	if g := rw.Body.String(); g != w {
+		t.Errorf("body = %s, want %s", g, w)
+	}
+}
+
 func TestServeVersion(t *testing.T) {
 	req, err := http.NewRequest("GET", "", nil)
 	if err != nil {
@@ -1545,6 +1768,187 @@ func TestServeKeysEvent(t *testing.T) {
 	}
 }
 
+func TestServeTxn(t *testing.T) {
+	u := testutil.MustNewURL(t, txnPrefix)
+	b := []byte(`{"compares":[{"key":"/foo","prevValue":"bar"}],"ops":[{"action":"set","key":"foo","value":"baz","ttl":10},{"action":"delete","key":"/foo/../bar/"}]}`)
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s := &serverRecorder{}
+	h := &txnHandler{
+		timeout: time.Hour,
+		server:  s,
+		cluster: &fakeCluster{id: 1},
+		timer:   &dummyRaftTimer{},
+	}
+	rw := httptest.NewRecorder()
+
+	h.ServeHTTP(rw, req)
+
+	if len(s.actions) != 1 {
+		t.Fatalf("len(actions) = %d, want 1", len(s.actions))
+	}
+	r := s.actions[0].params[0].(etcdserverpb.Request)
+	if r.Method != "TXN" {
+		t.Errorf("method = %s, want TXN", r.Method)
+	}
+	var txn etcdserver.TxnRequest
+	if err := json.Unmarshal([]byte(r.Val), &txn); err != nil {
+		t.Fatal(err)
+	}
+	wcmps := []etcdserver.TxnCompare{{Key: "/1/foo", PrevValue: "bar"}}
+	if !reflect.DeepEqual(txn.Compares, wcmps) {
+		t.Errorf("compares = %+v, want %+v", txn.Compares, wcmps)
+	}
+	ttl := uint64(10)
+	wops := []etcdserver.TxnOp{
+		{Action: store.Set, Key: "/1/foo", Value: "baz", TTL: &ttl},
+		{Action: store.Delete, Key: "/1/bar"},
+	}
+	if !reflect.DeepEqual(txn.Ops, wops) {
+		t.Errorf("ops = %+v, want %+v", txn.Ops, wops)
+	}
+}
+
+func TestServeTxnAuth(t *testing.T) {
+	sec := &mockAuthStore{
+		user: &auth.User{User: "user", Password: goodPassword, Roles: []string{"foorole"}},
+		roles: map[string]*auth.Role{
+			"foorole": {
+				Role: "foorole",
+				Permissions: auth.Permissions{
+					KV: auth.RWPermission{
+						Read:  []string{"/foo/*"},
+						Write: []string{"/foo/*"},
+					},
+				},
+			},
+		},
+		enabled: true,
+	}
+	tests := []struct {
+		body string
+
+		wcode int
+	}{
+		{`{"ops":[{"action":"set","key":"/foo/bar"}]}`, http.StatusOK},
+		{`{"ops":[{"action":"set","key":"/foo/../foo/bar"}]}`, http.StatusOK},
+		// the access is checked on the cleaned keys
+		{`{"ops":[{"action":"set","key":"/foo/../bar"}]}`, http.StatusUnauthorized},
+		{`{"compares":[{"key":"/foo/../bar","prevExist":true}],"ops":[{"action":"set","key":"/foo/bar"}]}`, http.StatusUnauthorized},
+	}
+	for i, tt := range tests {
+		u := testutil.MustNewURL(t, txnPrefix)
+		req, err := http.NewRequest("POST", u.String(), strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("user", "good")
+		h := &txnHandler{
+			sec:     sec,
+			timeout: time.Hour,
+			server: &resServer{
+				etcdserver.Response{Events: []*store.Event{{Action: store.Set, Node: &store.NodeExtern{Key: "/1/foo/bar"}}}},
+			},
+			cluster: &fakeCluster{id: 1},
+			timer:   &dummyRaftTimer{},
+		}
+		rw := httptest.NewRecorder()
+
+		h.ServeHTTP(rw, req)
+
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+	}
+}
+
+func TestServeTxnEvents(t *testing.T) {
+	u := testutil.MustNewURL(t, txnPrefix)
+	b := []byte(`{"ops":[{"action":"set","key":"/foo","value":"bar"}]}`)
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	server := &resServer{
+		etcdserver.Response{
+			Events: []*store.Event{
+				{Action: store.Set, Node: &store.NodeExtern{Key: "/1/foo"}, EtcdIndex: 3},
+			},
+		},
+	}
+	h := &txnHandler{
+		timeout: time.Hour,
+		server:  server,
+		cluster: &fakeCluster{id: 1},
+		timer:   &dummyRaftTimer{},
+	}
+	rw := httptest.NewRecorder()
+
+	h.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Errorf("code = %d, want %d", rw.Code, http.StatusOK)
+	}
+	if g := rw.Header().Get("X-Etcd-Index"); g != "3" {
+		t.Errorf("X-Etcd-Index = %s, want 3", g)
+	}
+	wbody := `{"action":"txn","events":[{"action":"set","node":{"key":"/foo"}}]}` + "\n"
+	if g := rw.Body.String(); g != wbody {
+		t.Errorf("body = %q, want %q", g, wbody)
+	}
+}
+
+func TestBadServeTxn(t *testing.T) {
+	tests := []struct {
+		method string
+		body   string
+
+		wcode int
+	}{
+		{"GET", `{"ops":[{"action":"set","key":"/foo"}]}`, http.StatusMethodNotAllowed},
+		{"POST", `{"ops":[]}`, http.StatusBadRequest},
+		{"POST", `{"ops":[{"action":"update","key":"/foo"}]}`, http.StatusBadRequest},
+		{"POST", `{"ops":[{"action":"set","key":""}]}`, http.StatusBadRequest},
+		{"POST", `{"compares":[{"prevValue":"bar"}],"ops":[{"action":"set","key":"/foo"}]}`, http.StatusBadRequest},
+		{"POST", `{`, http.StatusBadRequest},
+		// keys out of the key space
+		{"POST", `{"ops":[{"action":"set","key":"../0/members/x"}]}`, http.StatusBadRequest},
+		{"POST", `{"ops":[{"action":"delete","key":"/foo/../..","recursive":true}]}`, http.StatusBadRequest},
+		{"POST", `{"compares":[{"key":"/../0/version"}],"ops":[{"action":"set","key":"/foo"}]}`, http.StatusBadRequest},
+		{"POST", `{"ops":[{"action":"delete","key":"/","recursive":true}]}`, http.StatusBadRequest},
+	}
+	for i, tt := range tests {
+		u := testutil.MustNewURL(t, txnPrefix)
+		req, err := http.NewRequest(tt.method, u.String(), strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		s := &serverRecorder{}
+		h := &txnHandler{
+			timeout: time.Hour,
+			server:  s,
+			cluster: &fakeCluster{id: 1},
+			timer:   &dummyRaftTimer{},
+		}
+		rw := httptest.NewRecorder()
+
+		h.ServeHTTP(rw, req)
+
+		if rw.Code != tt.wcode {
+			t.Errorf("#%d: code = %d, want %d", i, rw.Code, tt.wcode)
+		}
+		if len(s.actions) != 0 {
+			t.Errorf("#%d: actions = %+v, want none", i, s.actions)
+		}
+	}
+}
+
 func TestServeKeysWatch(t *testing.T) {
 	req := mustNewRequest(t, "/foo/bar")
 	ec := make(chan *store.Event)
@@ -1774,13 +2178,7 @@ func TestHandleWatchStreaming(t *testing.T) {
 	}
 
 	// And check the body is as expected
-	wbody = mustMarshalEvent(
-		t,
-		&store.Event{
-			Action: store.Get,
-			Node:   &store.NodeExtern{},
-		},
-	)
+	wbody = `{"action":"get","node":{},"etcdIndex":0}` + "\n"
 	g = rw.Body.String()
 	if g != wbody {
 		t.Errorf("got body=%#v, want %#v", g, wbody)
@@ -1819,6 +2217,63 @@ func TestHandleWatchStreaming(t *testing.T) {
 	}
 }
 
+func TestHandleWatchStreamingProgress(t *testing.T) {
+	defer func(d time.Duration) { watchProgressInterval = d }(watchProgressInterval)
+	watchProgressInterval = 10 * time.Millisecond
+
+	rw := &flushingRecorder{
+		httptest.NewRecorder(),
+		make(chan struct{}, 1),
+	}
+	wa := &dummyWatcher{
+		echan: make(chan *store.Event, 1),
+		sidx:  10,
+		cidx:  12,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		handleKeyWatch(ctx, rw, wa, true, dummyRaftTimer{})
+		close(done)
+	}()
+
+	// headers
+	select {
+	case <-rw.ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for flush")
+	}
+	// the idle watch is notified of the current index
+	select {
+	case <-rw.ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for progress")
+	}
+	// keep draining the flushes of later progress notifications
+	go func() {
+		for {
+			select {
+			case <-rw.ch:
+			case <-done:
+				return
+			}
+		}
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for done")
+	}
+
+	wline := `{"action":"progress","etcdIndex":12}`
+	lines := strings.Split(strings.TrimSpace(rw.Body.String()), "\n")
+	if lines[0] != wline {
+		t.Errorf("got progress=%q, want %q", lines[0], wline)
+	}
+}
+
 func TestTrimEventPrefix(t *testing.T) {
 	pre := "/abc"
 	tests := []struct {
@@ -1851,6 +2306,16 @@ func TestTrimEventPrefix(t *testing.T) {
 				PrevNode: &store.NodeExtern{Key: "/ghi"},
 			},
 		},
+		{
+			&store.Event{
+				Node: &store.NodeExtern{Key: "/abc/def"},
+				Next: "/abc/def/jkl",
+			},
+			&store.Event{
+				Node: &store.NodeExtern{Key: "/def"},
+				Next: "/def/jkl",
+			},
+		},
 	}
 	for i, tt := range tests {
 		ev := trimEventPrefix(tt.ev, pre)
@@ -31,6 +31,9 @@ import (
 const (
 	// time to wait for a Watch request
 	defaultWatchTimeout = time.Duration(math.MaxInt64)
+
+	// http.StatusTooManyRequests is only defined since go1.6.
+	statusTooManyRequests = 429
 )
 
 var (
@@ -55,6 +58,14 @@ func writeError(w http.ResponseWriter, err error) {
 		herr.WriteTo(w)
 	default:
 		switch err {
+		case etcdserver.ErrTooManyRequests:
+			herr := httptypes.NewHTTPError(statusTooManyRequests, "Too Many Requests")
+			herr.WriteTo(w)
+			return
+		case etcdserver.ErrWitness:
+			herr := httptypes.NewHTTPError(http.StatusServiceUnavailable, "Witness member does not serve requests")
+			herr.WriteTo(w)
+			return
 		case etcdserver.ErrTimeoutDueToLeaderFail, etcdserver.ErrTimeoutDueToConnectionLost:
 			plog.Error(err)
 		default:
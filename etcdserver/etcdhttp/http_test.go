@@ -75,6 +75,9 @@ func (fs *errServer) RemoveMember(ctx context.Context, id uint64) error {
 func (fs *errServer) UpdateMember(ctx context.Context, m etcdserver.Member) error {
 	return fs.err
 }
+func (fs *errServer) ReplaceMembers(ctx context.Context, ids []uint64, ms []etcdserver.Member) error {
+	return fs.err
+}
 
 func (fs *errServer) ClusterVersion() *semver.Version { return nil }
 
@@ -110,6 +113,14 @@ func TestWriteError(t *testing.T) {
 			err:   errors.New("something went wrong"),
 			wcode: http.StatusInternalServerError,
 		},
+		{
+			err:   etcdserver.ErrTooManyRequests,
+			wcode: statusTooManyRequests,
+		},
+		{
+			err:   etcdserver.ErrWitness,
+			wcode: http.StatusServiceUnavailable,
+		},
 	}
 
 	for i, tt := range tests {
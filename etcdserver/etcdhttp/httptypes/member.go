@@ -25,10 +25,12 @@ type Member struct {
 	Name       string   `json:"name"`
 	PeerURLs   []string `json:"peerURLs"`
 	ClientURLs []string `json:"clientURLs"`
+	IsWitness  bool     `json:"isWitness,omitempty"`
 }
 
 type MemberCreateRequest struct {
-	PeerURLs types.URLs
+	PeerURLs  types.URLs
+	IsWitness bool
 }
 
 type MemberUpdateRequest struct {
@@ -37,7 +39,8 @@ type MemberUpdateRequest struct {
 
 func (m *MemberCreateRequest) UnmarshalJSON(data []byte) error {
 	s := struct {
-		PeerURLs []string `json:"peerURLs"`
+		PeerURLs  []string `json:"peerURLs"`
+		IsWitness bool     `json:"isWitness"`
 	}{}
 
 	err := json.Unmarshal(data, &s)
@@ -51,6 +54,40 @@ func (m *MemberCreateRequest) UnmarshalJSON(data []byte) error {
 	}
 
 	m.PeerURLs = urls
+	m.IsWitness = s.IsWitness
+	return nil
+}
+
+// MemberReplaceRequest atomically removes the members with the given IDs
+// and adds members with the given peer URLs.
+type MemberReplaceRequest struct {
+	Remove []types.ID
+	Add    []MemberCreateRequest
+}
+
+func (m *MemberReplaceRequest) UnmarshalJSON(data []byte) error {
+	s := struct {
+		Remove []string          `json:"remove"`
+		Add    []json.RawMessage `json:"add"`
+	}{}
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	m.Remove = make([]types.ID, len(s.Remove))
+	for i, idStr := range s.Remove {
+		if m.Remove[i], err = types.IDFromString(idStr); err != nil {
+			return err
+		}
+	}
+	m.Add = make([]MemberCreateRequest, len(s.Add))
+	for i, b := range s.Add {
+		if err = m.Add[i].UnmarshalJSON(b); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -112,6 +112,23 @@ func TestMemberCreateRequestUnmarshal(t *testing.T) {
 	}
 }
 
+func TestMemberCreateRequestUnmarshalWitness(t *testing.T) {
+	body := []byte(`{"peerURLs": ["http://127.0.0.1:8081"], "isWitness": true}`)
+	want := MemberCreateRequest{
+		PeerURLs:  types.URLs([]url.URL{{Scheme: "http", Host: "127.0.0.1:8081"}}),
+		IsWitness: true,
+	}
+
+	var req MemberCreateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("Unmarshal returned unexpected err=%v", err)
+	}
+
+	if !reflect.DeepEqual(want, req) {
+		t.Fatalf("Failed to unmarshal MemberCreateRequest: want=%#v, got=%#v", want, req)
+	}
+}
+
 func TestMemberCreateRequestUnmarshalFail(t *testing.T) {
 	tests := [][]byte{
 		// invalid JSON
@@ -133,3 +150,42 @@ func TestMemberCreateRequestUnmarshalFail(t *testing.T) {
 		}
 	}
 }
+
+func TestMemberReplaceRequestUnmarshal(t *testing.T) {
+	body := []byte(`{"remove": ["beef"], "add": [{"peerURLs": ["http://127.0.0.1:8081"]}]}`)
+	want := MemberReplaceRequest{
+		Remove: []types.ID{0xbeef},
+		Add: []MemberCreateRequest{
+			{PeerURLs: types.URLs([]url.URL{{Scheme: "http", Host: "127.0.0.1:8081"}})},
+		},
+	}
+
+	var req MemberReplaceRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("Unmarshal returned unexpected err=%v", err)
+	}
+
+	if !reflect.DeepEqual(want, req) {
+		t.Fatalf("Failed to unmarshal MemberReplaceRequest: want=%#v, got=%#v", want, req)
+	}
+}
+
+func TestMemberReplaceRequestUnmarshalFail(t *testing.T) {
+	tests := [][]byte{
+		// invalid JSON
+		[]byte(`{`),
+
+		// invalid member ID
+		[]byte(`{"remove": ["xyz"]}`),
+
+		// invalid peer URLs
+		[]byte(`{"add": [{"peerURLs": []}]}`),
+	}
+
+	for i, tt := range tests {
+		var req MemberReplaceRequest
+		if err := json.Unmarshal(tt, &req); err == nil {
+			t.Errorf("#%d: expected err, got nil", i)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httptypes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type TxnCompare struct {
+	Key       string `json:"key"`
+	PrevValue string `json:"prevValue,omitempty"`
+	PrevIndex uint64 `json:"prevIndex,omitempty"`
+	PrevExist *bool  `json:"prevExist,omitempty"`
+}
+
+type TxnOp struct {
+	Action    string  `json:"action"`
+	Key       string  `json:"key"`
+	Value     string  `json:"value,omitempty"`
+	Dir       bool    `json:"dir,omitempty"`
+	Recursive bool    `json:"recursive,omitempty"`
+	TTL       *uint64 `json:"ttl,omitempty"`
+}
+
+type TxnRequest struct {
+	Compares []TxnCompare `json:"compares"`
+	Ops      []TxnOp      `json:"ops"`
+}
+
+func (t *TxnRequest) UnmarshalJSON(data []byte) error {
+	type txnRequest TxnRequest
+	s := txnRequest{}
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	if len(s.Ops) == 0 {
+		return errors.New("txn: at least one op is required")
+	}
+	for _, c := range s.Compares {
+		if c.Key == "" {
+			return errors.New("txn: compare key cannot be empty")
+		}
+	}
+	for _, op := range s.Ops {
+		if op.Key == "" {
+			return errors.New("txn: op key cannot be empty")
+		}
+		if op.Action != "set" && op.Action != "delete" {
+			return fmt.Errorf("txn: unsupported op action %q", op.Action)
+		}
+	}
+
+	*t = TxnRequest(s)
+	return nil
+}
@@ -29,23 +29,30 @@ var _ = proto.Marshal
 var _ = math.Inf
 
 type Request struct {
-	ID               uint64 `protobuf:"varint,1,opt" json:"ID"`
-	Method           string `protobuf:"bytes,2,opt" json:"Method"`
-	Path             string `protobuf:"bytes,3,opt" json:"Path"`
-	Val              string `protobuf:"bytes,4,opt" json:"Val"`
-	Dir              bool   `protobuf:"varint,5,opt" json:"Dir"`
-	PrevValue        string `protobuf:"bytes,6,opt" json:"PrevValue"`
-	PrevIndex        uint64 `protobuf:"varint,7,opt" json:"PrevIndex"`
-	PrevExist        *bool  `protobuf:"varint,8,opt" json:"PrevExist,omitempty"`
-	Expiration       int64  `protobuf:"varint,9,opt" json:"Expiration"`
-	Wait             bool   `protobuf:"varint,10,opt" json:"Wait"`
-	Since            uint64 `protobuf:"varint,11,opt" json:"Since"`
-	Recursive        bool   `protobuf:"varint,12,opt" json:"Recursive"`
-	Sorted           bool   `protobuf:"varint,13,opt" json:"Sorted"`
-	Quorum           bool   `protobuf:"varint,14,opt" json:"Quorum"`
-	Time             int64  `protobuf:"varint,15,opt" json:"Time"`
-	Stream           bool   `protobuf:"varint,16,opt" json:"Stream"`
-	XXX_unrecognized []byte `json:"-"`
+	ID               uint64   `protobuf:"varint,1,opt" json:"ID"`
+	Method           string   `protobuf:"bytes,2,opt" json:"Method"`
+	Path             string   `protobuf:"bytes,3,opt" json:"Path"`
+	Val              string   `protobuf:"bytes,4,opt" json:"Val"`
+	Dir              bool     `protobuf:"varint,5,opt" json:"Dir"`
+	PrevValue        string   `protobuf:"bytes,6,opt" json:"PrevValue"`
+	PrevIndex        uint64   `protobuf:"varint,7,opt" json:"PrevIndex"`
+	PrevExist        *bool    `protobuf:"varint,8,opt" json:"PrevExist,omitempty"`
+	Expiration       int64    `protobuf:"varint,9,opt" json:"Expiration"`
+	Wait             bool     `protobuf:"varint,10,opt" json:"Wait"`
+	Since            uint64   `protobuf:"varint,11,opt" json:"Since"`
+	Recursive        bool     `protobuf:"varint,12,opt" json:"Recursive"`
+	Sorted           bool     `protobuf:"varint,13,opt" json:"Sorted"`
+	Quorum           bool     `protobuf:"varint,14,opt" json:"Quorum"`
+	Time             int64    `protobuf:"varint,15,opt" json:"Time"`
+	Stream           bool     `protobuf:"varint,16,opt" json:"Stream"`
+	Refresh          *bool    `protobuf:"varint,17,opt" json:"Refresh,omitempty"`
+	Limit            uint64   `protobuf:"varint,18,opt" json:"Limit"`
+	StartKey         string   `protobuf:"bytes,19,opt" json:"StartKey"`
+	WatchActions     []string `protobuf:"bytes,20,rep" json:"WatchActions,omitempty"`
+	WatchKeyGlob     string   `protobuf:"bytes,21,opt" json:"WatchKeyGlob"`
+	WatchNoPrevNode  bool     `protobuf:"varint,22,opt" json:"WatchNoPrevNode"`
+	WatchNoValue     bool     `protobuf:"varint,23,opt" json:"WatchNoValue"`
+	XXX_unrecognized []byte   `json:"-"`
 }
 
 func (m *Request) Reset()         { *m = Request{} }
@@ -55,6 +62,7 @@ func (*Request) ProtoMessage()    {}
 type Metadata struct {
 	NodeID           uint64 `protobuf:"varint,1,opt" json:"NodeID"`
 	ClusterID        uint64 `protobuf:"varint,2,opt" json:"ClusterID"`
+	Witness          bool   `protobuf:"varint,3,opt" json:"Witness"`
 	XXX_unrecognized []byte `json:"-"`
 }
 
@@ -168,6 +176,72 @@ func (m *Request) MarshalTo(data []byte) (int, error) {
 		data[i] = 0
 	}
 	i++
+	if m.Refresh != nil {
+		data[i] = 0x88
+		i++
+		data[i] = 0x1
+		i++
+		if *m.Refresh {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
+	data[i] = 0x90
+	i++
+	data[i] = 0x1
+	i++
+	i = encodeVarintEtcdserver(data, i, uint64(m.Limit))
+	data[i] = 0x9a
+	i++
+	data[i] = 0x1
+	i++
+	i = encodeVarintEtcdserver(data, i, uint64(len(m.StartKey)))
+	i += copy(data[i:], m.StartKey)
+	if len(m.WatchActions) > 0 {
+		for _, s := range m.WatchActions {
+			data[i] = 0xa2
+			i++
+			data[i] = 0x1
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				data[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			data[i] = uint8(l)
+			i++
+			i += copy(data[i:], s)
+		}
+	}
+	data[i] = 0xaa
+	i++
+	data[i] = 0x1
+	i++
+	i = encodeVarintEtcdserver(data, i, uint64(len(m.WatchKeyGlob)))
+	i += copy(data[i:], m.WatchKeyGlob)
+	data[i] = 0xb0
+	i++
+	data[i] = 0x1
+	i++
+	if m.WatchNoPrevNode {
+		data[i] = 1
+	} else {
+		data[i] = 0
+	}
+	i++
+	data[i] = 0xb8
+	i++
+	data[i] = 0x1
+	i++
+	if m.WatchNoValue {
+		data[i] = 1
+	} else {
+		data[i] = 0
+	}
+	i++
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
@@ -195,6 +269,14 @@ func (m *Metadata) MarshalTo(data []byte) (int, error) {
 	data[i] = 0x10
 	i++
 	i = encodeVarintEtcdserver(data, i, uint64(m.ClusterID))
+	data[i] = 0x18
+	i++
+	if m.Witness {
+		data[i] = 1
+	} else {
+		data[i] = 0
+	}
+	i++
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
@@ -253,6 +335,22 @@ func (m *Request) Size() (n int) {
 	n += 2
 	n += 1 + sovEtcdserver(uint64(m.Time))
 	n += 3
+	if m.Refresh != nil {
+		n += 3
+	}
+	n += 2 + sovEtcdserver(uint64(m.Limit))
+	l = len(m.StartKey)
+	n += 2 + l + sovEtcdserver(uint64(l))
+	if len(m.WatchActions) > 0 {
+		for _, s := range m.WatchActions {
+			l = len(s)
+			n += 2 + l + sovEtcdserver(uint64(l))
+		}
+	}
+	l = len(m.WatchKeyGlob)
+	n += 2 + l + sovEtcdserver(uint64(l))
+	n += 3
+	n += 3
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -264,6 +362,7 @@ func (m *Metadata) Size() (n int) {
 	_ = l
 	n += 1 + sovEtcdserver(uint64(m.NodeID))
 	n += 1 + sovEtcdserver(uint64(m.ClusterID))
+	n += 2
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -606,6 +705,152 @@ func (m *Request) Unmarshal(data []byte) error {
 				}
 			}
 			m.Stream = bool(v != 0)
+		case 17:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Refresh", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			b := bool(v != 0)
+			m.Refresh = &b
+		case 18:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Limit", wireType)
+			}
+			m.Limit = 0
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.Limit |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 19:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StartKey", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthEtcdserver
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.StartKey = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 20:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field WatchActions", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthEtcdserver
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.WatchActions = append(m.WatchActions, string(data[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 21:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field WatchKeyGlob", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthEtcdserver
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.WatchKeyGlob = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 22:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field WatchNoPrevNode", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.WatchNoPrevNode = bool(v != 0)
+		case 23:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field WatchNoValue", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.WatchNoValue = bool(v != 0)
 		default:
 			var sizeOfWire int
 			for {
@@ -684,6 +929,23 @@ func (m *Metadata) Unmarshal(data []byte) error {
 					break
 				}
 			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Witness", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Witness = bool(v != 0)
 		default:
 			var sizeOfWire int
 			for {
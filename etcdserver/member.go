@@ -37,6 +37,10 @@ var (
 type RaftAttributes struct {
 	// TODO(philips): ensure these are URLs
 	PeerURLs []string `json:"peerURLs"`
+	// IsWitness is set if the member is a witness, which votes in
+	// elections and acknowledges entries, but neither stores the
+	// key space nor serves client requests.
+	IsWitness bool `json:"isWitness,omitempty"`
 }
 
 // Attributes represents all the non-raft related attributes of an etcd member.
@@ -89,7 +93,8 @@ func (m *Member) Clone() *Member {
 		return nil
 	}
 	mm := &Member{
-		ID: m.ID,
+		ID:             m.ID,
+		RaftAttributes: RaftAttributes{IsWitness: m.IsWitness},
 		Attributes: Attributes{
 			Name: m.Name,
 		},
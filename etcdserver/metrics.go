@@ -19,6 +19,8 @@ import (
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/prometheus/client_golang/prometheus"
 	"github.com/coreos/etcd/pkg/runtime"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft"
 )
 
 var (
@@ -50,6 +52,39 @@ var (
 		Name:      "file_descriptors_used_total",
 		Help:      "The total number of file descriptors used.",
 	})
+
+	raftEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd",
+		Subsystem: "server",
+		Name:      "raft_events_total",
+		Help:      "The total number of raft events.",
+	}, []string{"type"})
+
+	// The progress of the followers is only exported by the leader.
+	followerMatchIndex = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "etcd",
+		Subsystem: "server",
+		Name:      "follower_match_index",
+		Help:      "The index of the last entry matched by each follower.",
+	}, []string{"followerID"})
+	followerNextIndex = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "etcd",
+		Subsystem: "server",
+		Name:      "follower_next_index",
+		Help:      "The index of the next entry to send to each follower.",
+	}, []string{"followerID"})
+	followerInflightMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "etcd",
+		Subsystem: "server",
+		Name:      "follower_inflight_messages",
+		Help:      "The number of in-flight append messages to each follower.",
+	}, []string{"followerID"})
+	followerProgressState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "etcd",
+		Subsystem: "server",
+		Name:      "follower_progress_state",
+		Help:      "The replication state of each follower (0 for probe, 1 for replicate, 2 for snapshot).",
+	}, []string{"followerID"})
 )
 
 func init() {
@@ -57,6 +92,11 @@ func init() {
 	prometheus.MustRegister(proposePending)
 	prometheus.MustRegister(proposeFailed)
 	prometheus.MustRegister(fileDescriptorUsed)
+	prometheus.MustRegister(raftEvents)
+	prometheus.MustRegister(followerMatchIndex)
+	prometheus.MustRegister(followerNextIndex)
+	prometheus.MustRegister(followerInflightMessages)
+	prometheus.MustRegister(followerProgressState)
 }
 
 func monitorFileDescriptor(done <-chan struct{}) {
@@ -84,3 +124,52 @@ func monitorFileDescriptor(done <-chan struct{}) {
 		}
 	}
 }
+
+// raftEventCounter counts the events of the raft by type.
+type raftEventCounter struct{}
+
+func (raftEventCounter) HandleEvent(e raft.Event) {
+	raftEvents.WithLabelValues(e.Type.String()).Inc()
+}
+
+// monitorFollowers exports the progress of the followers periodically while
+// the member is the leader, and stops exporting it once it is not.
+func (s *EtcdServer) monitorFollowers() {
+	ticker := time.NewTicker(monitorFollowersInterval)
+	defer ticker.Stop()
+	exported := make(map[uint64]bool)
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		prs := s.r.Status().Progress
+		for id := range exported {
+			if _, ok := prs[id]; !ok {
+				deleteFollowerMetrics(id)
+				delete(exported, id)
+			}
+		}
+		for id, pr := range prs {
+			if id == uint64(s.id) {
+				continue
+			}
+			fid := types.ID(id).String()
+			followerMatchIndex.WithLabelValues(fid).Set(float64(pr.Match))
+			followerNextIndex.WithLabelValues(fid).Set(float64(pr.Next))
+			followerInflightMessages.WithLabelValues(fid).Set(float64(pr.Inflight()))
+			followerProgressState.WithLabelValues(fid).Set(float64(pr.State))
+			exported[id] = true
+		}
+	}
+}
+
+func deleteFollowerMetrics(id uint64) {
+	fid := types.ID(id).String()
+	followerMatchIndex.DeleteLabelValues(fid)
+	followerNextIndex.DeleteLabelValues(fid)
+	followerInflightMessages.DeleteLabelValues(fid)
+	followerProgressState.DeleteLabelValues(fid)
+}
@@ -49,6 +49,19 @@ const (
 	// Never overflow the rafthttp buffer, which is 4096.
 	// TODO: a better const?
 	maxInflightMsgs = 4096 / 8
+
+	// maxUncommittedEntriesSize limits the size of the proposals the leader
+	// holds before they are committed. Proposals beyond it are rejected
+	// with ErrTooManyRequests.
+	maxUncommittedEntriesSize = 64 * 1024 * 1024
+
+	// raftLogCacheSize is the number of recently used entries the raft
+	// storage caches instead of reading them out of the WAL.
+	raftLogCacheSize = 1024
+
+	// maxInflightApplies is the number of batches of committed entries the
+	// raft routine may hand out before it waits for the apply routine.
+	maxInflightApplies = 64
 )
 
 var (
@@ -70,13 +83,26 @@ type RaftTimer interface {
 	Term() uint64
 }
 
+// raftStorage is the raft.Storage of a raftNode. The raft routine appends the
+// saved entries to it, and the server snapshots and compacts it.
+type raftStorage interface {
+	raft.Storage
+	Append(ents []raftpb.Entry) error
+	ApplySnapshot(snap raftpb.Snapshot) error
+	CreateSnapshot(i uint64, cs *raftpb.ConfState, data []byte) (raftpb.Snapshot, error)
+	Compact(compactIndex uint64) error
+}
+
 // apply contains entries, snapshot be applied.
-// After applied all the items, the application needs
-// to send notification to done chan.
+// The application may apply the items as soon as it receives them,
+// but it must wait for raftDone to be closed, which happens exactly once,
+// after the raft routine has persisted them, before snapshotting.
+// The application must close applied once it has applied the items.
 type apply struct {
 	entries  []raftpb.Entry
 	snapshot raftpb.Snapshot
-	done     chan struct{}
+	raftDone <-chan struct{}
+	applied  chan<- struct{}
 }
 
 type raftNode struct {
@@ -93,6 +119,10 @@ type raftNode struct {
 
 	raft.Node
 
+	// witness is set if the member is a witness, which strips the entries
+	// and the snapshots it persists of the key space.
+	witness bool
+
 	// a chan to send out apply
 	applyc chan apply
 
@@ -103,7 +133,7 @@ type raftNode struct {
 
 	// utility
 	ticker      <-chan time.Time
-	raftStorage *raft.MemoryStorage
+	raftStorage raftStorage
 	storage     Storage
 	// transport specifies the transport to send and receive msgs to members.
 	// Sending messages MUST NOT block. It is okay to drop messages, since
@@ -120,12 +150,13 @@ type raftNode struct {
 // TODO: Ideally raftNode should get rid of the passed in server structure.
 func (r *raftNode) start(s *EtcdServer) {
 	r.s = s
-	r.applyc = make(chan apply)
+	r.applyc = make(chan apply, maxInflightApplies)
 	r.stopped = make(chan struct{})
 	r.done = make(chan struct{})
 
 	go func() {
 		var syncC <-chan time.Time
+		var islead bool
 
 		defer r.onStop()
 		for {
@@ -140,7 +171,8 @@ func (r *raftNode) start(s *EtcdServer) {
 						r.mu.Unlock()
 					}
 					atomic.StoreUint64(&r.lead, rd.SoftState.Lead)
-					if rd.RaftState == raft.StateLeader {
+					islead = rd.RaftState == raft.StateLeader
+					if islead {
 						syncC = r.s.SyncTicker
 						// TODO: remove the nil checking
 						// current test utility does not provide the stats
@@ -152,10 +184,19 @@ func (r *raftNode) start(s *EtcdServer) {
 					}
 				}
 
+				if r.witness {
+					if !raft.IsEmptySnap(rd.Snapshot) {
+						rd.Snapshot = witnessSnapshot(rd.Snapshot)
+					}
+					rd.Entries = witnessEntries(rd.Entries)
+				}
+
+				raftDone, applied := make(chan struct{}), make(chan struct{})
 				apply := apply{
 					entries:  rd.CommittedEntries,
 					snapshot: rd.Snapshot,
-					done:     make(chan struct{}),
+					raftDone: raftDone,
+					applied:  applied,
 				}
 
 				select {
@@ -164,6 +205,13 @@ func (r *raftNode) start(s *EtcdServer) {
 					return
 				}
 
+				// the leader can write to its disk in parallel with replicating
+				// to the followers and them writing to their disks.
+				// For more details, check raft thesis 10.2.1
+				if islead {
+					r.s.send(rd.Messages)
+				}
+
 				if !raft.IsEmptySnap(rd.Snapshot) {
 					if err := r.storage.SaveSnap(rd.Snapshot); err != nil {
 						plog.Fatalf("raft save snapshot error: %v", err)
@@ -175,13 +223,20 @@ func (r *raftNode) start(s *EtcdServer) {
 					plog.Fatalf("raft save state and entries error: %v", err)
 				}
 				r.raftStorage.Append(rd.Entries)
-
-				r.s.send(rd.Messages)
-
-				select {
-				case <-apply.done:
-				case <-r.stopped:
-					return
+				close(raftDone)
+
+				if !islead {
+					// wait for the committed configuration changes to be applied
+					// before replying, so that the transport knows about the
+					// members they add.
+					if hasConfChange(rd.CommittedEntries) {
+						select {
+						case <-applied:
+						case <-r.stopped:
+							return
+						}
+					}
+					r.s.send(rd.Messages)
 				}
 				r.Advance()
 			case <-syncC:
@@ -193,6 +248,16 @@ func (r *raftNode) start(s *EtcdServer) {
 	}()
 }
 
+// hasConfChange returns true if the given entries hold a configuration change.
+func hasConfChange(ents []raftpb.Entry) bool {
+	for _, e := range ents {
+		if e.Type == raftpb.EntryConfChange || e.Type == raftpb.EntryConfChangeV2 {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *raftNode) apply() chan apply {
 	return r.applyc
 }
@@ -238,19 +303,20 @@ func advanceTicksForElection(n raft.Node, electionTicks int) {
 	}
 }
 
-func startNode(cfg *ServerConfig, cl *cluster, ids []types.ID) (id types.ID, n raft.Node, s *raft.MemoryStorage, w *wal.WAL) {
+func startNode(cfg *ServerConfig, cl *cluster, ids []types.ID) (id types.ID, n raft.Node, s raftStorage, w *wal.WAL, witness bool) {
 	var err error
 	member := cl.MemberByName(cfg.Name)
 	metadata := pbutil.MustMarshal(
 		&pb.Metadata{
 			NodeID:    uint64(member.ID),
 			ClusterID: uint64(cl.ID()),
+			Witness:   member.IsWitness,
 		},
 	)
 	if err := os.MkdirAll(cfg.SnapDir(), privateDirMode); err != nil {
 		plog.Fatalf("create snapshot directory error: %v", err)
 	}
-	if w, err = wal.Create(cfg.WALDir(), metadata); err != nil {
+	if w, err = wal.CreateWithOptions(cfg.WALDir(), metadata, cfg.walOptions()); err != nil {
 		plog.Fatalf("create wal error: %v", err)
 	}
 	peers := make([]raft.Peer, len(ids))
@@ -261,9 +327,17 @@ func startNode(cfg *ServerConfig, cl *cluster, ids []types.ID) (id types.ID, n r
 		}
 		peers[i] = raft.Peer{ID: uint64(id), Context: ctx}
 	}
-	id = member.ID
-	plog.Infof("starting member %s in cluster %s", id, cl.ID())
-	s = raft.NewMemoryStorage()
+	id, witness = member.ID, member.IsWitness
+	if witness {
+		plog.Infof("starting witness member %s in cluster %s", id, cl.ID())
+	} else {
+		plog.Infof("starting member %s in cluster %s", id, cl.ID())
+	}
+	if cfg.LazyRaftLog {
+		s = wal.NewStorage(w, raftLogCacheSize)
+	} else {
+		s = raft.NewMemoryStorage()
+	}
 	c := &raft.Config{
 		ID:              uint64(id),
 		ElectionTick:    cfg.ElectionTicks,
@@ -271,6 +345,11 @@ func startNode(cfg *ServerConfig, cl *cluster, ids []types.ID) (id types.ID, n r
 		Storage:         s,
 		MaxSizePerMsg:   maxSizePerMsg,
 		MaxInflightMsgs: maxInflightMsgs,
+		ParallelPersist: true,
+		EventListener:   raftEventCounter{},
+		Witness:         witness,
+
+		MaxUncommittedEntriesSize: maxUncommittedEntriesSize,
 	}
 	n = raft.StartNode(c, peers)
 	raftStatus = n.Status
@@ -278,22 +357,47 @@ func startNode(cfg *ServerConfig, cl *cluster, ids []types.ID) (id types.ID, n r
 	return
 }
 
-func restartNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (types.ID, *cluster, raft.Node, *raft.MemoryStorage, *wal.WAL) {
-	var walsnap walpb.Snapshot
+func restartNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (types.ID, *cluster, raft.Node, raftStorage, *wal.WAL, bool) {
+	var snap raftpb.Snapshot
 	if snapshot != nil {
-		walsnap.Index, walsnap.Term = snapshot.Metadata.Index, snapshot.Metadata.Term
+		snap = *snapshot
 	}
-	w, id, cid, st, ents := readWAL(cfg.WALDir(), walsnap)
+	var (
+		w  *wal.WAL
+		md pb.Metadata
+		st raftpb.HardState
+		s  raftStorage
+	)
+	if cfg.LazyRaftLog {
+		// the entries are read lazily out of the WAL
+		var ws *wal.Storage
+		w, md, ws = readWALStorage(cfg.WALDir(), snap, cfg.walOptions())
+		var err error
+		if st, _, err = ws.InitialState(); err != nil {
+			plog.Fatalf("read raft storage error: %v", err)
+		}
+		s = ws
+	} else {
+		walsnap := walpb.Snapshot{Index: snap.Metadata.Index, Term: snap.Metadata.Term}
+		var ents []raftpb.Entry
+		w, md, st, ents = readWAL(cfg.WALDir(), walsnap, cfg.walOptions())
+		ms := raft.NewMemoryStorage()
+		if snapshot != nil {
+			ms.ApplySnapshot(*snapshot)
+		}
+		ms.SetHardState(st)
+		ms.Append(ents)
+		s = ms
+	}
+	id, cid := types.ID(md.NodeID), types.ID(md.ClusterID)
 
-	plog.Infof("restarting member %s in cluster %s at commit index %d", id, cid, st.Commit)
+	if md.Witness {
+		plog.Infof("restarting witness member %s in cluster %s at commit index %d", id, cid, st.Commit)
+	} else {
+		plog.Infof("restarting member %s in cluster %s at commit index %d", id, cid, st.Commit)
+	}
 	cl := newCluster("")
 	cl.SetID(cid)
-	s := raft.NewMemoryStorage()
-	if snapshot != nil {
-		s.ApplySnapshot(*snapshot)
-	}
-	s.SetHardState(st)
-	s.Append(ents)
 	c := &raft.Config{
 		ID:              uint64(id),
 		ElectionTick:    cfg.ElectionTicks,
@@ -301,19 +405,30 @@ func restartNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (types.ID, *clust
 		Storage:         s,
 		MaxSizePerMsg:   maxSizePerMsg,
 		MaxInflightMsgs: maxInflightMsgs,
+		ParallelPersist: true,
+		EventListener:   raftEventCounter{},
+		Witness:         md.Witness,
+
+		MaxUncommittedEntriesSize: maxUncommittedEntriesSize,
 	}
 	n := raft.RestartNode(c)
 	raftStatus = n.Status
 	advanceTicksForElection(n, c.ElectionTick)
-	return id, cl, n, s, w
+	return id, cl, n, s, w, md.Witness
 }
 
-func restartAsStandaloneNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (types.ID, *cluster, raft.Node, *raft.MemoryStorage, *wal.WAL) {
+func restartAsStandaloneNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (types.ID, *cluster, raft.Node, raftStorage, *wal.WAL) {
 	var walsnap walpb.Snapshot
 	if snapshot != nil {
 		walsnap.Index, walsnap.Term = snapshot.Metadata.Index, snapshot.Metadata.Term
 	}
-	w, id, cid, st, ents := readWAL(cfg.WALDir(), walsnap)
+	w, md, st, ents := readWAL(cfg.WALDir(), walsnap, cfg.walOptions())
+	id, cid := types.ID(md.NodeID), types.ID(md.ClusterID)
+	if md.Witness {
+		// a witness does not hold the key space, so it cannot be the
+		// only member of a cluster.
+		plog.Fatalf("cannot force a new cluster out of witness member %s", id)
+	}
 
 	// discard the previously uncommitted entries
 	for i, ent := range ents {
@@ -353,6 +468,10 @@ func restartAsStandaloneNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (type
 		Storage:         s,
 		MaxSizePerMsg:   maxSizePerMsg,
 		MaxInflightMsgs: maxInflightMsgs,
+		ParallelPersist: true,
+		EventListener:   raftEventCounter{},
+
+		MaxUncommittedEntriesSize: maxUncommittedEntriesSize,
 	}
 	n := raft.RestartNode(c)
 	raftStatus = n.Status
@@ -360,34 +479,47 @@ func restartAsStandaloneNode(cfg *ServerConfig, snapshot *raftpb.Snapshot) (type
 }
 
 // getIDs returns an ordered set of IDs included in the given snapshot and
-// the entries. The given snapshot/entries can contain two kinds of
+// the entries. The given snapshot/entries can contain three kinds of
 // ID-related entry:
 // - ConfChangeAddNode, in which case the contained ID will be added into the set.
 // - ConfChangeAddRemove, in which case the contained ID will be removed from the set.
+// - ConfChangeV2, in which case each contained change is applied as above.
 func getIDs(snap *raftpb.Snapshot, ents []raftpb.Entry) []uint64 {
 	ids := make(map[uint64]bool)
 	if snap != nil {
 		for _, id := range snap.Metadata.ConfState.Nodes {
 			ids[id] = true
 		}
-	}
-	for _, e := range ents {
-		if e.Type != raftpb.EntryConfChange {
-			continue
+		for _, id := range snap.Metadata.ConfState.OutgoingNodes {
+			ids[id] = true
 		}
-		var cc raftpb.ConfChange
-		pbutil.MustUnmarshal(&cc, e.Data)
-		switch cc.Type {
+	}
+	apply := func(typ raftpb.ConfChangeType, id uint64) {
+		switch typ {
 		case raftpb.ConfChangeAddNode:
-			ids[cc.NodeID] = true
+			ids[id] = true
 		case raftpb.ConfChangeRemoveNode:
-			delete(ids, cc.NodeID)
+			delete(ids, id)
 		case raftpb.ConfChangeUpdateNode:
 			// do nothing
 		default:
 			plog.Panicf("ConfChange Type should be either ConfChangeAddNode or ConfChangeRemoveNode!")
 		}
 	}
+	for _, e := range ents {
+		switch e.Type {
+		case raftpb.EntryConfChange:
+			var cc raftpb.ConfChange
+			pbutil.MustUnmarshal(&cc, e.Data)
+			apply(cc.Type, cc.NodeID)
+		case raftpb.EntryConfChangeV2:
+			var cc raftpb.ConfChangeV2
+			pbutil.MustUnmarshal(&cc, e.Data)
+			for _, c := range cc.Changes {
+				apply(c.Type, c.NodeID)
+			}
+		}
+	}
 	sids := make(types.Uint64Slice, 0)
 	for id := range ids {
 		sids = append(sids, id)
@@ -32,6 +32,11 @@ func TestGetIDs(t *testing.T) {
 	removecc := &raftpb.ConfChange{Type: raftpb.ConfChangeRemoveNode, NodeID: 2}
 	removeEntry := raftpb.Entry{Type: raftpb.EntryConfChange, Data: pbutil.MustMarshal(removecc)}
 	normalEntry := raftpb.Entry{Type: raftpb.EntryNormal}
+	replacecc := &raftpb.ConfChangeV2{Changes: []raftpb.ConfChangeSingle{
+		{Type: raftpb.ConfChangeRemoveNode, NodeID: 1},
+		{Type: raftpb.ConfChangeAddNode, NodeID: 3},
+	}}
+	replaceEntry := raftpb.Entry{Type: raftpb.EntryConfChangeV2, Data: pbutil.MustMarshal(replacecc)}
 	updatecc := &raftpb.ConfChange{Type: raftpb.ConfChangeUpdateNode, NodeID: 2}
 	updateEntry := raftpb.Entry{Type: raftpb.EntryConfChange, Data: pbutil.MustMarshal(updatecc)}
 
@@ -54,6 +59,10 @@ func TestGetIDs(t *testing.T) {
 			[]raftpb.Entry{addEntry, normalEntry, updateEntry}, []uint64{1, 2}},
 		{&raftpb.ConfState{Nodes: []uint64{1}},
 			[]raftpb.Entry{addEntry, removeEntry, normalEntry}, []uint64{1}},
+		{&raftpb.ConfState{Nodes: []uint64{1}},
+			[]raftpb.Entry{addEntry, replaceEntry}, []uint64{2, 3}},
+		{&raftpb.ConfState{Nodes: []uint64{1, 3}, OutgoingNodes: []uint64{1, 2}},
+			[]raftpb.Entry{}, []uint64{1, 2, 3}},
 	}
 
 	for i, tt := range tests {
@@ -171,3 +180,81 @@ func TestStopRaftWhenWaitingForApplyDone(t *testing.T) {
 		t.Fatalf("failed to stop raft loop")
 	}
 }
+
+// TestRaftAdvanceBeforeApply ensures that the raft routine advances raft
+// without waiting for the committed entries to be applied.
+func TestRaftAdvanceBeforeApply(t *testing.T) {
+	n := newAdvanceNode()
+	s := &EtcdServer{r: raftNode{
+		Node:        n,
+		storage:     &storageRecorder{},
+		raftStorage: raft.NewMemoryStorage(),
+		transport:   &nopTransporter{},
+	}}
+	r := &s.r
+	r.start(s)
+	defer r.stop()
+
+	n.readyc <- raft.Ready{CommittedEntries: []raftpb.Entry{{Index: 1, Type: raftpb.EntryNormal}}}
+	var ap apply
+	select {
+	case ap = <-r.applyc:
+	case <-time.After(time.Second):
+		t.Fatalf("failed to receive apply struct")
+	}
+	select {
+	case <-n.advancec:
+	case <-time.After(time.Second):
+		t.Fatalf("failed to advance before apply")
+	}
+	select {
+	case <-ap.raftDone:
+	default:
+		t.Errorf("raftDone is not closed after advance")
+	}
+}
+
+// TestRaftWaitConfChangeApply ensures that a follower waits for the committed
+// configuration changes to be applied before advancing raft.
+func TestRaftWaitConfChangeApply(t *testing.T) {
+	n := newAdvanceNode()
+	s := &EtcdServer{r: raftNode{
+		Node:        n,
+		storage:     &storageRecorder{},
+		raftStorage: raft.NewMemoryStorage(),
+		transport:   &nopTransporter{},
+	}}
+	r := &s.r
+	r.start(s)
+	defer r.stop()
+
+	n.readyc <- raft.Ready{CommittedEntries: []raftpb.Entry{{Index: 1, Type: raftpb.EntryConfChange}}}
+	var ap apply
+	select {
+	case ap = <-r.applyc:
+	case <-time.After(time.Second):
+		t.Fatalf("failed to receive apply struct")
+	}
+	select {
+	case <-n.advancec:
+		t.Fatalf("unexpected advance before applying the configuration change")
+	case <-time.After(10 * time.Millisecond):
+	}
+	// the entries are persisted before they are applied
+	select {
+	case <-ap.raftDone:
+	case <-time.After(time.Second):
+		t.Fatalf("raftDone is not closed")
+	}
+	select {
+	case <-n.advancec:
+		t.Fatalf("unexpected advance before applying the configuration change")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(ap.applied)
+	select {
+	case <-n.advancec:
+	case <-time.After(time.Second):
+		t.Fatalf("failed to advance after apply")
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/fileutil"
+	"github.com/coreos/etcd/pkg/pbutil"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/wal"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+var (
+	ErrRestoreDeltaSnapshot = errors.New("etcdserver: cannot restore from a delta snapshot")
+	ErrRestoreGap           = errors.New("etcdserver: entries do not follow the snapshot")
+)
+
+// A RestorePoint tells up to where the entries are replayed by Restore.
+// The replay stops after the entry at Index, if it is not zero, and before
+// the first request proposed after Time, if it is not zero. The entries
+// proposed before the requests carried their proposal time are replayed
+// unless a later request stops the replay.
+type RestorePoint struct {
+	Index uint64
+	Time  time.Time
+}
+
+// reached returns true if e must not be replayed to restore up to p.
+func (p RestorePoint) reached(e raftpb.Entry) bool {
+	if p.Index != 0 && e.Index > p.Index {
+		return true
+	}
+	if p.Time.IsZero() || e.Type != raftpb.EntryNormal || len(e.Data) == 0 {
+		return false
+	}
+	r := entryRequest(e.Data)
+	return r != nil && r.Time != 0 && time.Unix(0, r.Time).After(p.Time)
+}
+
+// RestoreResult describes the data dir created by Restore.
+type RestoreResult struct {
+	MemberID  types.ID
+	ClusterID types.ID
+	// Index and Term are the ones of the last entry replayed, which
+	// are the ones of the snapshot of the restored data dir.
+	Index uint64
+	Term  uint64
+}
+
+// Restore creates the data dir of cfg for a new single-member cluster,
+// which holds the store of the given snapshot with the given committed
+// entries replayed onto it up to the restore point. The member is named
+// after cfg.Name and advertises cfg.PeerURLs. The member and the cluster
+// get fresh IDs, so the restored member never talks with the members of the
+// original cluster. The snapshot may be nil to replay the entries onto an
+// empty store, in which case the entries must start at index 1.
+func Restore(cfg *ServerConfig, snapshot *raftpb.Snapshot, ents []raftpb.Entry, to RestorePoint) (*RestoreResult, error) {
+	if wal.Exist(cfg.WALDir()) {
+		return nil, fmt.Errorf("etcdserver: wal dir %s already exists", cfg.WALDir())
+	}
+	if names, err := fileutil.ReadDir(cfg.SnapDir()); err == nil && len(names) != 0 {
+		return nil, fmt.Errorf("etcdserver: snap dir %s is not empty", cfg.SnapDir())
+	}
+
+	st := store.New(StoreClusterPrefix, StoreKeysPrefix)
+	var res RestoreResult
+	if snapshot != nil {
+		if store.IsDeltaSnapshot(snapshot.Data) {
+			return nil, ErrRestoreDeltaSnapshot
+		}
+		if err := st.Recovery(snapshot.Data); err != nil {
+			return nil, err
+		}
+		res.Index, res.Term = snapshot.Metadata.Index, snapshot.Metadata.Term
+	}
+	if to.Index != 0 && to.Index < res.Index {
+		return nil, fmt.Errorf("etcdserver: restore index %d is before the snapshot index %d", to.Index, res.Index)
+	}
+
+	cl := newCluster("")
+	cl.SetStore(st)
+	cl.Recover()
+	s := &EtcdServer{store: st, cluster: cl}
+	for _, e := range ents {
+		if e.Index <= res.Index {
+			continue
+		}
+		if e.Index != res.Index+1 {
+			return nil, ErrRestoreGap
+		}
+		if to.reached(e) {
+			break
+		}
+		s.replay(e)
+		res.Index, res.Term = e.Index, e.Term
+	}
+
+	if res.Index == 0 {
+		return nil, errors.New("etcdserver: nothing to restore")
+	}
+
+	now := time.Now()
+	m := NewMember(cfg.Name, cfg.PeerURLs, cfg.InitialClusterToken, &now)
+	m.ClientURLs = cfg.ClientURLs.StringSlice()
+	cl = restoredCluster(st, m)
+	res.MemberID, res.ClusterID = m.ID, cl.ID()
+
+	data, err := st.SaveNoCopy()
+	if err != nil {
+		return nil, err
+	}
+	if err := saveRestored(cfg, res, data); err != nil {
+		os.RemoveAll(cfg.WALDir())
+		os.RemoveAll(cfg.SnapDir())
+		return nil, err
+	}
+	return &res, nil
+}
+
+// replay applies the given committed entry onto the store of s, as the
+// apply loop of a server does it, without notifying any waiter.
+func (s *EtcdServer) replay(e raftpb.Entry) {
+	switch e.Type {
+	case raftpb.EntryNormal:
+		if len(e.Data) == 0 {
+			return
+		}
+		if r := entryRequest(e.Data); r != nil {
+			s.applyRequest(*r)
+		}
+	case raftpb.EntryConfChange:
+		var cc raftpb.ConfChange
+		pbutil.MustUnmarshal(&cc, e.Data)
+		s.replayMemberChange(cc)
+	case raftpb.EntryConfChangeV2:
+		var cc raftpb.ConfChangeV2
+		pbutil.MustUnmarshal(&cc, e.Data)
+		// the changes are rejected together, as the server does it.
+		// The members removed through joint consensus are removed as
+		// soon as they are replayed, since only the membership the
+		// replay ends with is kept.
+		ccs := confChanges(cc)
+		if err := s.cluster.ValidateConfigurationChanges(ccs); err != nil {
+			return
+		}
+		for _, c := range ccs {
+			s.replayMemberChange(c)
+		}
+	}
+}
+
+// replayMemberChange applies the effect of the given configuration change
+// on the cluster membership, unless the server rejected it when it was
+// applied.
+func (s *EtcdServer) replayMemberChange(cc raftpb.ConfChange) {
+	if err := s.cluster.ValidateConfigurationChange(cc); err != nil {
+		return
+	}
+	switch cc.Type {
+	case raftpb.ConfChangeAddNode, raftpb.ConfChangeUpdateNode:
+		m := new(Member)
+		if err := json.Unmarshal(cc.Context, m); err != nil {
+			plog.Panicf("unmarshal member should never fail: %v", err)
+		}
+		if cc.Type == raftpb.ConfChangeAddNode {
+			s.cluster.AddMember(m)
+		} else {
+			s.cluster.UpdateRaftAttributes(m.ID, m.RaftAttributes)
+		}
+	case raftpb.ConfChangeRemoveNode:
+		s.cluster.RemoveMember(types.ID(cc.NodeID))
+	}
+}
+
+// restoredCluster replaces the membership held by st with the given member
+// alone, and returns the cluster made of it.
+func restoredCluster(st store.Store, m *Member) *cluster {
+	for _, p := range []string{storeMembersPrefix, storeRemovedMembersPrefix} {
+		if _, err := st.Delete(p, true, true); err != nil && !isKeyNotFound(err) {
+			plog.Panicf("delete %s should never fail: %v", p, err)
+		}
+	}
+	cl := newCluster("")
+	cl.SetStore(st)
+	cl.AddMember(m)
+	cl.genID()
+	b, err := json.Marshal(m.Attributes)
+	if err != nil {
+		plog.Panicf("marshal attributes should never fail: %v", err)
+	}
+	if _, err := st.Set(MemberAttributesStorePath(m.ID), false, string(b), store.Permanent); err != nil {
+		plog.Panicf("set attributes should never fail: %v", err)
+	}
+	return cl
+}
+
+// saveRestored saves the snapshot of the restored store and the WAL
+// starting at it into the data dir of cfg.
+func saveRestored(cfg *ServerConfig, res RestoreResult, data []byte) error {
+	if err := os.MkdirAll(cfg.SnapDir(), privateDirMode); err != nil {
+		return err
+	}
+	ss := snap.NewWithOptions(cfg.SnapDir(), cfg.snapOptions())
+	if err := ss.SaveSnap(raftpb.Snapshot{
+		Data: data,
+		Metadata: raftpb.SnapshotMetadata{
+			Index:     res.Index,
+			Term:      res.Term,
+			ConfState: raftpb.ConfState{Nodes: []uint64{uint64(res.MemberID)}},
+		},
+	}); err != nil {
+		return err
+	}
+
+	md := pbutil.MustMarshal(&pb.Metadata{NodeID: uint64(res.MemberID), ClusterID: uint64(res.ClusterID)})
+	w, err := wal.CreateWithOptions(cfg.WALDir(), md, cfg.walOptions())
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := w.SaveSnapshot(walpb.Snapshot{Index: res.Index, Term: res.Term}); err != nil {
+		return err
+	}
+	return w.Save(raftpb.HardState{Term: res.Term, Commit: res.Index}, nil)
+}
@@ -0,0 +1,179 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/pbutil"
+	"github.com/coreos/etcd/pkg/testutil"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/wal"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+func restoreEntries(t *testing.T, start time.Time) []raftpb.Entry {
+	m := NewMember("old", types.URLs(testutil.MustNewURLs(t, []string{"http://10.0.0.1:2380"})), "", nil)
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := raftpb.ConfChange{ID: 1, Type: raftpb.ConfChangeAddNode, NodeID: uint64(m.ID), Context: b}
+	reqs := []pb.Request{
+		{ID: 2, Method: "PUT", Path: MemberAttributesStorePath(m.ID), Val: `{"name":"old"}`, Time: start.UnixNano()},
+		{ID: 3, Method: "PUT", Path: "/1/foo", Val: "bar", Time: start.Add(time.Minute).UnixNano()},
+		{ID: 4, Method: "PUT", Path: "/1/baz", Val: "qux"},
+		{ID: 5, Method: "DELETE", Path: "/1/foo", Time: start.Add(2 * time.Minute).UnixNano()},
+	}
+	ents := []raftpb.Entry{{Index: 1, Term: 1, Type: raftpb.EntryConfChange, Data: pbutil.MustMarshal(&cc)}}
+	for i := range reqs {
+		ents = append(ents, raftpb.Entry{Index: uint64(i + 2), Term: 2, Data: pbutil.MustMarshal(&reqs[i])})
+	}
+	return ents
+}
+
+func TestRestore(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		to RestorePoint
+
+		windex uint64
+		wkeys  map[string]bool
+	}{
+		{RestorePoint{}, 5, map[string]bool{"/1/foo": false, "/1/baz": true}},
+		{RestorePoint{Index: 3}, 3, map[string]bool{"/1/foo": true, "/1/baz": false}},
+		// the entry without a time is replayed with the ones before it.
+		{RestorePoint{Time: start.Add(90 * time.Second)}, 4, map[string]bool{"/1/foo": true, "/1/baz": true}},
+		{RestorePoint{Index: 5, Time: start.Add(30 * time.Second)}, 2, map[string]bool{"/1/foo": false, "/1/baz": false}},
+	}
+	for i, tt := range tests {
+		dir, err := ioutil.TempDir(os.TempDir(), "restore")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		cfg := &ServerConfig{
+			Name:     "restored",
+			DataDir:  dir,
+			PeerURLs: types.URLs(testutil.MustNewURLs(t, []string{"http://127.0.0.1:2380"})),
+		}
+
+		res, err := Restore(cfg, nil, restoreEntries(t, start), tt.to)
+		if err != nil {
+			t.Fatalf("#%d: unexpected restore error: %v", i, err)
+		}
+		if res.Index != tt.windex || res.Term == 0 {
+			t.Errorf("#%d: index = %d, term = %d, want %d, >0", i, res.Index, res.Term, tt.windex)
+		}
+
+		snapshot, err := snap.New(cfg.SnapDir()).Load()
+		if err != nil {
+			t.Fatalf("#%d: unexpected load error: %v", i, err)
+		}
+		if snapshot.Metadata.Index != res.Index || !reflect.DeepEqual(snapshot.Metadata.ConfState.Nodes, []uint64{uint64(res.MemberID)}) {
+			t.Errorf("#%d: snapshot metadata = %+v, want index %d and node %s", i, snapshot.Metadata, res.Index, res.MemberID)
+		}
+		st := store.New(StoreClusterPrefix, StoreKeysPrefix)
+		if err := st.Recovery(snapshot.Data); err != nil {
+			t.Fatalf("#%d: unexpected recovery error: %v", i, err)
+		}
+		for k, w := range tt.wkeys {
+			if _, err := st.Get(k, false, false); (err == nil) != w {
+				t.Errorf("#%d: %s exists = %v, want %v", i, k, err == nil, w)
+			}
+		}
+		members, removed := membersFromStore(st)
+		if len(members) != 1 || len(removed) != 0 {
+			t.Fatalf("#%d: members = %v, removed = %v, want the restored member only", i, members, removed)
+		}
+		if m := members[res.MemberID]; m == nil || m.Name != "restored" || !reflect.DeepEqual(m.PeerURLs, []string{"http://127.0.0.1:2380"}) {
+			t.Errorf("#%d: member = %+v, want the restored member", i, m)
+		}
+
+		w, err := wal.OpenForRead(cfg.WALDir(), walpb.Snapshot{Index: res.Index, Term: res.Term})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wmd, hs, ents, err := w.ReadAll()
+		w.Close()
+		if err != nil {
+			t.Fatalf("#%d: unexpected wal error: %v", i, err)
+		}
+		var md pb.Metadata
+		pbutil.MustUnmarshal(&md, wmd)
+		if md.NodeID != uint64(res.MemberID) || md.ClusterID != uint64(res.ClusterID) || res.ClusterID == 0 {
+			t.Errorf("#%d: metadata = %+v, want member %s and cluster %s", i, md, res.MemberID, res.ClusterID)
+		}
+		if hs.Commit != res.Index || len(ents) != 0 {
+			t.Errorf("#%d: commit = %d with %d entries, want %d with none", i, hs.Commit, len(ents), res.Index)
+		}
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	ents := restoreEntries(t, time.Unix(1000, 0))
+	data, err := store.New(StoreClusterPrefix, StoreKeysPrefix).Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		snapshot *raftpb.Snapshot
+		ents     []raftpb.Entry
+		to       RestorePoint
+		walExist bool
+	}{
+		// gap between the empty store and the entries
+		{nil, ents[1:], RestorePoint{}, false},
+		// nothing to replay
+		{nil, nil, RestorePoint{}, false},
+		// restore point before the snapshot
+		{&raftpb.Snapshot{Data: data, Metadata: raftpb.SnapshotMetadata{Index: 3, Term: 1}}, ents, RestorePoint{Index: 2}, false},
+		// existing data dir
+		{nil, ents, RestorePoint{}, true},
+	}
+	for i, tt := range tests {
+		dir, err := ioutil.TempDir(os.TempDir(), "restore")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		cfg := &ServerConfig{
+			Name:     "restored",
+			DataDir:  dir,
+			PeerURLs: types.URLs(testutil.MustNewURLs(t, []string{"http://127.0.0.1:2380"})),
+		}
+		if tt.walExist {
+			if err := os.MkdirAll(cfg.WALDir(), 0700); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path.Join(cfg.WALDir(), "0000000000000000-0000000000000000.wal"), nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := Restore(cfg, tt.snapshot, tt.ents, tt.to); err == nil {
+			t.Errorf("#%d: err = nil, want an error", i)
+		}
+	}
+}
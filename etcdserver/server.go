@@ -18,11 +18,13 @@ import (
 	"encoding/json"
 	"expvar"
 	"fmt"
+	"io"
 	"math/rand"
 	"net/http"
 	"os"
 	"path"
 	"regexp"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -59,14 +61,20 @@ const (
 	StoreClusterPrefix = "/0"
 	StoreKeysPrefix    = "/1"
 
-	purgeFileInterval      = 30 * time.Second
-	monitorVersionInterval = 5 * time.Second
+	purgeFileInterval        = 30 * time.Second
+	monitorVersionInterval   = 5 * time.Second
+	monitorFollowersInterval = time.Second
 )
 
 var (
 	plog = capnslog.NewPackageLogger("github.com/coreos/etcd", "etcdserver")
 
 	storeMemberAttributeRegexp = regexp.MustCompile(path.Join(storeMembersPrefix, "[[:xdigit:]]{1,16}", attributesSuffix))
+
+	// binarySnapshotVersion is the lowest cluster version whose members
+	// can recover from the binary snapshots of the store. It is the cluster
+	// version of the members of this release, which introduced them.
+	binarySnapshotVersion = semver.Must(semver.NewVersion("2.2.0"))
 )
 
 func init() {
@@ -85,10 +93,37 @@ func init() {
 
 type Response struct {
 	Event   *store.Event
+	Events  []*store.Event
 	Watcher store.Watcher
 	err     error
 }
 
+// TxnRequest is the payload of a "TXN" request. It is carried JSON encoded
+// in the Val field of the request, and is applied through store.Txn.
+type TxnRequest struct {
+	Compares []TxnCompare `json:"compares"`
+	Ops      []TxnOp      `json:"ops"`
+}
+
+// TxnCompare is a condition of a TxnRequest on the key at Key.
+type TxnCompare struct {
+	Key       string `json:"key"`
+	PrevValue string `json:"prevValue,omitempty"`
+	PrevIndex uint64 `json:"prevIndex,omitempty"`
+	PrevExist *bool  `json:"prevExist,omitempty"`
+}
+
+// TxnOp is a write of a TxnRequest. Its TTL, in seconds, counts from the
+// proposal time of the request.
+type TxnOp struct {
+	Action    string  `json:"action"`
+	Key       string  `json:"key"`
+	Value     string  `json:"value,omitempty"`
+	Dir       bool    `json:"dir,omitempty"`
+	Recursive bool    `json:"recursive,omitempty"`
+	TTL       *uint64 `json:"ttl,omitempty"`
+}
+
 type Server interface {
 	// Start performs any initialization of the Server necessary for it to
 	// begin serving requests. It must be called before Do or Process.
@@ -120,6 +155,11 @@ type Server interface {
 	// return ErrIDNotFound if the member ID does not exist.
 	UpdateMember(ctx context.Context, updateMemb Member) error
 
+	// ReplaceMembers attempts to atomically remove the members with the
+	// given IDs from the cluster and add the given members into it. It
+	// returns the same errors as AddMember and RemoveMember.
+	ReplaceMembers(ctx context.Context, removeIDs []uint64, adds []Member) error
+
 	// ClusterVersion is the cluster-wide minimum major.minor version.
 	// Cluster version is set to the min version that a etcd member is
 	// compatible with when first bootstrap.
@@ -156,7 +196,12 @@ type EtcdServer struct {
 	cluster *cluster
 
 	store store.Store
-	kv    dstorage.KV
+
+	// kvMu protects kv, which is replaced when the v3 database received
+	// along a snapshot is recovered.
+	kvMu        sync.RWMutex
+	kv          dstorage.KV
+	snapshotter *snap.Snapshotter
 
 	stats  *stats.ServerStats
 	lstats *stats.LeaderStats
@@ -168,6 +213,22 @@ type EtcdServer struct {
 	// forceVersionC is used to force the version monitor loop
 	// to detect the cluster version immediately.
 	forceVersionC chan struct{}
+
+	// savedc is received from by the apply loop between two applies, where
+	// the entries applied are all saved in the WAL.
+	savedc chan struct{}
+
+	// snapDonec is closed when the last snapshot started is done.
+	snapDonec chan struct{}
+	// snapMu protects the state of the snapshots below.
+	snapMu sync.Mutex
+	// snapBinary tells whether the last snapshot saved is a binary one,
+	// full or delta, which the next delta can be based on.
+	snapBinary bool
+	// snapDeltas is the number of deltas saved since the last full snapshot.
+	snapDeltas uint
+	// snapStoreIndex is the index of the store at the last snapshot saved.
+	snapStoreIndex uint64
 }
 
 // NewServer creates a new EtcdServer from the supplied configuration. The
@@ -176,9 +237,10 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 	st := store.New(StoreClusterPrefix, StoreKeysPrefix)
 	var w *wal.WAL
 	var n raft.Node
-	var s *raft.MemoryStorage
+	var s raftStorage
 	var id types.ID
 	var cl *cluster
+	var witness bool
 
 	// Run the migrations.
 	dataVer, err := version.DetectDataDir(cfg.DataDir)
@@ -195,7 +257,7 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 	}
 
 	haveWAL := wal.Exist(cfg.WALDir())
-	ss := snap.New(cfg.SnapDir())
+	ss := snap.NewWithOptions(cfg.SnapDir(), cfg.snapOptions())
 
 	var remotes []*Member
 	switch {
@@ -222,7 +284,7 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 		cl.SetID(existingCluster.id)
 		cl.SetStore(st)
 		cfg.Print()
-		id, n, s, w = startNode(cfg, cl, nil)
+		id, n, s, w, witness = startNode(cfg, cl, nil)
 	case !haveWAL && cfg.NewCluster:
 		if err := cfg.VerifyBootstrap(); err != nil {
 			return nil, err
@@ -253,7 +315,7 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 		}
 		cl.SetStore(st)
 		cfg.PrintWithInitial()
-		id, n, s, w = startNode(cfg, cl, cl.MemberIDs())
+		id, n, s, w, witness = startNode(cfg, cl, cl.MemberIDs())
 	case haveWAL:
 		if err := fileutil.IsDirWriteable(cfg.DataDir); err != nil {
 			return nil, fmt.Errorf("cannot write to data directory: %v", err)
@@ -270,12 +332,16 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 		if cfg.ShouldDiscover() {
 			plog.Warningf("discovery token ignored since a cluster has already been initialized. Valid log found at %q", cfg.WALDir())
 		}
-		snapshot, err := ss.Load()
+		// the store is recovered as the snapshots are read; their data is
+		// read again from disk when they are sent to a follower.
+		snapshot, rc, err := ss.ReadChain(store.IsDeltaSnapshot)
 		if err != nil && err != snap.ErrNoSnapshot {
 			return nil, err
 		}
 		if snapshot != nil {
-			if err := st.Recovery(snapshot.Data); err != nil {
+			err := st.RecoveryFrom(rc)
+			rc.Close()
+			if err != nil {
 				plog.Panicf("recovered store from snapshot error: %v", err)
 			}
 			plog.Infof("recovered store from snapshot at index %d", snapshot.Metadata.Index)
@@ -285,7 +351,7 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 			plog.Infof("loaded cluster information from store: %s", cl)
 		}
 		if !cfg.ForceNewCluster {
-			id, cl, n, s, w = restartNode(cfg, snapshot)
+			id, cl, n, s, w, witness = restartNode(cfg, snapshot)
 		} else {
 			id, cl, n, s, w = restartAsStandaloneNode(cfg, snapshot)
 		}
@@ -309,6 +375,7 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 		store:     st,
 		r: raftNode{
 			Node:        n,
+			witness:     witness,
 			ticker:      time.Tick(time.Duration(cfg.TickMs) * time.Millisecond),
 			raftStorage: s,
 			storage:     NewStorage(w, ss),
@@ -321,17 +388,23 @@ func NewServer(cfg *ServerConfig) (*EtcdServer, error) {
 		SyncTicker:    time.Tick(500 * time.Millisecond),
 		reqIDGen:      idutil.NewGenerator(uint8(id), time.Now()),
 		forceVersionC: make(chan struct{}),
+		savedc:        make(chan struct{}),
 	}
 
 	if cfg.V3demo {
-		srv.kv = dstorage.New(path.Join(cfg.DataDir, "member", "v3demo"))
+		srv.kv = dstorage.NewWithKeyProvider(cfg.v3demoPath(), cfg.KeyProvider)
 	} else {
 		// we do not care about the error of the removal
-		os.RemoveAll(path.Join(cfg.DataDir, "member", "v3demo"))
+		os.RemoveAll(cfg.v3demoPath())
 	}
 
+	srv.snapshotter = ss
+	snapcfg := &rafthttp.SnapshotConfig{Snapshotter: ss, Data: srv.snapshotData, RateLimit: cfg.SnapshotSendRate}
+	if srv.kv != nil {
+		snapcfg.DB = srv.snapshotKV
+	}
 	// TODO: move transport initialization near the definition of remote
-	tr := rafthttp.NewTransporter(cfg.Transport, id, cl.ID(), srv, srv.errorc, sstats, lstats)
+	tr := rafthttp.NewTransporter(cfg.Transport, id, cl.ID(), srv, srv.errorc, sstats, lstats, snapcfg, cfg.PeerCompression)
 	// add all remotes into transport
 	for _, m := range remotes {
 		if m.ID != id {
@@ -356,6 +429,7 @@ func (s *EtcdServer) Start() {
 	go s.purgeFile()
 	go monitorFileDescriptor(s.done)
 	go s.monitorVersions()
+	go s.monitorFollowers()
 }
 
 // start prepares and starts server in a new goroutine. It is no longer safe to
@@ -381,11 +455,24 @@ func (s *EtcdServer) start() {
 
 func (s *EtcdServer) purgeFile() {
 	var serrc, werrc <-chan error
-	if s.cfg.MaxSnapFiles > 0 {
-		serrc = fileutil.PurgeFile(s.cfg.SnapDir(), "snap", s.cfg.MaxSnapFiles, purgeFileInterval, s.done)
+	if s.cfg.MaxSnapFiles > 0 || s.cfg.MaxSnapAge > 0 {
+		// the full snapshot the deltas are based on is retained.
+		opts := fileutil.PurgeOptions{
+			Max:      s.cfg.MaxSnapFiles,
+			MaxAge:   s.cfg.MaxSnapAge,
+			Min:      s.cfg.SnapDeltas + 1,
+			Archiver: s.cfg.SnapArchiver,
+		}
+		serrc = s.snapshotter.Purge(opts, purgeFileInterval, s.done)
 	}
-	if s.cfg.MaxWALFiles > 0 {
-		werrc = fileutil.PurgeFile(s.cfg.WALDir(), "wal", s.cfg.MaxWALFiles, purgeFileInterval, s.done)
+	if s.cfg.MaxWALFiles > 0 || s.cfg.MaxWALAge > 0 {
+		opts := fileutil.PurgeOptions{
+			Max:      s.cfg.MaxWALFiles,
+			MaxAge:   s.cfg.MaxWALAge,
+			Min:      1,
+			Archiver: s.cfg.WALArchiver,
+		}
+		werrc = fileutil.PurgeFileWithOptions(s.cfg.WALDir(), "wal", opts, purgeFileInterval, s.done)
 	}
 	select {
 	case e := <-werrc:
@@ -450,7 +537,11 @@ func (s *EtcdServer) run() {
 				if err := s.store.Recovery(apply.snapshot.Data); err != nil {
 					plog.Panicf("recovery store error: %v", err)
 				}
+				s.resetSnapshotChain()
 				s.cluster.Recover()
+				if s.kv != nil {
+					s.recoverKV(apply.snapshot.Metadata.Index)
+				}
 
 				// recover raft transport
 				s.r.transport.RemoveAllPeers()
@@ -481,11 +572,16 @@ func (s *EtcdServer) run() {
 					go s.stopWithDelay(10*100*time.Millisecond, fmt.Errorf("the member has been permanently removed from the cluster"))
 				}
 			}
+			close(apply.applied)
 
 			// wait for the raft routine to finish the disk writes before triggering a
 			// snapshot. or applied index might be greater than the last index in raft
 			// storage, since the raft routine might be slower than apply routine.
-			apply.done <- struct{}{}
+			select {
+			case <-apply.raftDone:
+			case <-s.stop:
+				return
+			}
 
 			// trigger snapshot
 			if appliedi-snapi > s.snapCount {
@@ -493,6 +589,9 @@ func (s *EtcdServer) run() {
 				s.snapshot(appliedi, confState)
 				snapi = appliedi
 			}
+		case <-s.savedc:
+			// the disk writes of the entries applied were waited for
+			// above, so Backup can cut the WAL after them.
 		case err := <-s.errorc:
 			plog.Errorf("%s", err)
 			plog.Infof("the data-dir used by this member must be removed.")
@@ -527,17 +626,23 @@ func (s *EtcdServer) stopWithDelay(d time.Duration, err error) {
 func (s *EtcdServer) StopNotify() <-chan struct{} { return s.done }
 
 // Do interprets r and performs an operation on s.store according to r.Method
-// and other fields. If r.Method is "POST", "PUT", "DELETE", or a "GET" with
-// Quorum == true, r will be sent through consensus before performing its
+// and other fields. If r.Method is "POST", "PUT", "DELETE", "TXN", or a "GET"
+// with Quorum == true, r will be sent through consensus before performing its
 // respective operation. Do will block until an action is performed or there is
 // an error.
 func (s *EtcdServer) Do(ctx context.Context, r pb.Request) (Response, error) {
+	if s.r.witness && !isClusterPath(r.Path) {
+		return Response{}, ErrWitness
+	}
 	r.ID = s.reqIDGen.Next()
 	if r.Method == "GET" && r.Quorum {
 		r.Method = "QGET"
 	}
 	switch r.Method {
-	case "POST", "PUT", "DELETE", "QGET":
+	case "POST", "PUT", "DELETE", "TXN", "QGET":
+		// the proposal time lets a restore stop replaying the log at
+		// a point in time.
+		r.Time = time.Now().UnixNano()
 		data, err := r.Marshal()
 		if err != nil {
 			return Response{}, err
@@ -547,7 +652,11 @@ func (s *EtcdServer) Do(ctx context.Context, r pb.Request) (Response, error) {
 		// TODO: benchmark the cost of time.Now()
 		// might be sampling?
 		start := time.Now()
-		s.r.Propose(ctx, data)
+		if err := s.r.Propose(ctx, data); err == raft.ErrProposalDropped {
+			proposeFailed.Inc()
+			s.w.Trigger(r.ID, nil) // GC wait
+			return Response{}, ErrTooManyRequests
+		}
 
 		proposePending.Inc()
 		defer proposePending.Dec()
@@ -567,11 +676,23 @@ func (s *EtcdServer) Do(ctx context.Context, r pb.Request) (Response, error) {
 	case "GET":
 		switch {
 		case r.Wait:
-			wc, err := s.store.Watch(r.Path, r.Recursive, r.Stream, r.Since)
+			filter := store.WatchFilter{
+				Actions:    r.WatchActions,
+				KeyGlob:    r.WatchKeyGlob,
+				NoPrevNode: r.WatchNoPrevNode,
+				NoValue:    r.WatchNoValue,
+			}
+			wc, err := s.store.WatchFiltered(r.Path, r.Recursive, r.Stream, r.Since, filter)
 			if err != nil {
 				return Response{}, err
 			}
 			return Response{Watcher: wc}, nil
+		case r.Limit > 0 || r.StartKey != "":
+			ev, err := s.store.GetPage(r.Path, r.Recursive, r.StartKey, r.Limit)
+			if err != nil {
+				return Response{}, err
+			}
+			return Response{Event: ev}, nil
 		default:
 			ev, err := s.store.Get(r.Path, r.Recursive, r.Sorted)
 			if err != nil {
@@ -602,6 +723,23 @@ func (s *EtcdServer) LeaderStats() []byte {
 
 func (s *EtcdServer) StoreStats() []byte { return s.store.JsonStats() }
 
+func (s *EtcdServer) RaftStats() []byte { return []byte(s.r.Status().String()) }
+
+func (s *EtcdServer) PeerStats() []byte {
+	b, err := json.Marshal(struct {
+		ID    string               `json:"id"`
+		Peers []rafthttp.PeerStats `json:"peers"`
+	}{
+		ID:    s.id.String(),
+		Peers: s.r.transport.PeerStats(),
+	})
+	if err != nil {
+		plog.Errorf("failed to marshal the peer stats (%v)", err)
+		return nil
+	}
+	return b
+}
+
 func (s *EtcdServer) AddMember(ctx context.Context, memb Member) error {
 	// TODO: move Member to protobuf type
 	b, err := json.Marshal(memb)
@@ -637,6 +775,32 @@ func (s *EtcdServer) UpdateMember(ctx context.Context, memb Member) error {
 	return s.configure(ctx, cc)
 }
 
+// ReplaceMembers atomically removes the members with the given IDs and adds
+// the given members to the cluster. The change goes through joint consensus,
+// so the cluster keeps its quorum requirements over both the old and the new
+// memberships until it is completed.
+func (s *EtcdServer) ReplaceMembers(ctx context.Context, removeIDs []uint64, adds []Member) error {
+	cc := raftpb.ConfChangeV2{}
+	for _, id := range removeIDs {
+		cc.Changes = append(cc.Changes, raftpb.ConfChangeSingle{
+			Type:   raftpb.ConfChangeRemoveNode,
+			NodeID: id,
+		})
+	}
+	for _, memb := range adds {
+		b, err := json.Marshal(memb)
+		if err != nil {
+			return err
+		}
+		cc.Changes = append(cc.Changes, raftpb.ConfChangeSingle{
+			Type:    raftpb.ConfChangeAddNode,
+			NodeID:  uint64(memb.ID),
+			Context: b,
+		})
+	}
+	return s.configureV2(ctx, cc)
+}
+
 // Implement the RaftTimer interface
 func (s *EtcdServer) Index() uint64 { return atomic.LoadUint64(&s.r.index) }
 
@@ -654,10 +818,26 @@ func (s *EtcdServer) Leader() types.ID { return types.ID(s.Lead()) }
 // will block until the change is performed or there is an error.
 func (s *EtcdServer) configure(ctx context.Context, cc raftpb.ConfChange) error {
 	cc.ID = s.reqIDGen.Next()
-	ch := s.w.Register(cc.ID)
+	return s.waitConfChange(ctx, cc.ID, func() error {
+		return s.r.ProposeConfChange(ctx, cc)
+	})
+}
+
+// configureV2 is like configure, for a ConfChangeV2.
+func (s *EtcdServer) configureV2(ctx context.Context, cc raftpb.ConfChangeV2) error {
+	cc.ID = s.reqIDGen.Next()
+	return s.waitConfChange(ctx, cc.ID, func() error {
+		return s.r.ProposeConfChangeV2(ctx, cc)
+	})
+}
+
+// waitConfChange registers the configuration change with the given ID,
+// proposes it through propose, and waits for it to be applied.
+func (s *EtcdServer) waitConfChange(ctx context.Context, id uint64, propose func() error) error {
+	ch := s.w.Register(id)
 	start := time.Now()
-	if err := s.r.ProposeConfChange(ctx, cc); err != nil {
-		s.w.Trigger(cc.ID, nil)
+	if err := propose(); err != nil {
+		s.w.Trigger(id, nil)
 		return err
 	}
 	select {
@@ -670,7 +850,7 @@ func (s *EtcdServer) configure(ctx context.Context, cc raftpb.ConfChange) error
 		}
 		return nil
 	case <-ctx.Done():
-		s.w.Trigger(cc.ID, nil) // GC wait
+		s.w.Trigger(id, nil) // GC wait
 		return s.parseProposeCtxErr(ctx.Err(), start)
 	case <-s.done:
 		return ErrStopped
@@ -759,6 +939,10 @@ func (s *EtcdServer) apply(es []raftpb.Entry, confState *raftpb.ConfState) (uint
 				}
 				break
 			}
+			// a witness only applies the requests on the cluster metadata.
+			if s.r.witness && !isClusterEntry(e) {
+				break
+			}
 
 			var raftReq pb.InternalRaftRequest
 			if !pbutil.MaybeUnmarshal(&raftReq, e.Data) { // backward compatible
@@ -777,8 +961,13 @@ func (s *EtcdServer) apply(es []raftpb.Entry, confState *raftpb.ConfState) (uint
 			pbutil.MustUnmarshal(&cc, e.Data)
 			shouldstop, err = s.applyConfChange(cc, confState)
 			s.w.Trigger(cc.ID, err)
+		case raftpb.EntryConfChangeV2:
+			var cc raftpb.ConfChangeV2
+			pbutil.MustUnmarshal(&cc, e.Data)
+			shouldstop, err = s.applyConfChangeV2(cc, confState)
+			s.w.Trigger(cc.ID, err)
 		default:
-			plog.Panicf("entry type should be either EntryNormal or EntryConfChange")
+			plog.Panicf("entry type should be either EntryNormal, EntryConfChange or EntryConfChangeV2")
 		}
 		atomic.StoreUint64(&s.r.index, e.Index)
 		atomic.StoreUint64(&s.r.term, e.Term)
@@ -799,7 +988,10 @@ func (s *EtcdServer) applyRequest(r pb.Request) Response {
 		return f(s.store.Create(r.Path, r.Dir, r.Val, true, expr))
 	case "PUT":
 		exists, existsSet := pbutil.GetBool(r.PrevExist)
+		refresh, _ := pbutil.GetBool(r.Refresh)
 		switch {
+		case refresh:
+			return f(s.store.Refresh(r.Path, expr))
 		case existsSet:
 			if exists {
 				if r.PrevIndex == 0 && r.PrevValue == "" {
@@ -834,7 +1026,18 @@ func (s *EtcdServer) applyRequest(r pb.Request) Response {
 		default:
 			return f(s.store.Delete(r.Path, r.Dir, r.Recursive))
 		}
+	case "TXN":
+		var txn TxnRequest
+		if err := json.Unmarshal([]byte(r.Val), &txn); err != nil {
+			plog.Panicf("unmarshal %s should never fail: %v", r.Val, err)
+		}
+		cmps, ops := storeTxn(txn, time.Unix(0, r.Time))
+		evs, err := s.store.Txn(cmps, ops)
+		return Response{Events: evs, err: err}
 	case "QGET":
+		if r.Limit > 0 || r.StartKey != "" {
+			return f(s.store.GetPage(r.Path, r.Recursive, r.StartKey, r.Limit))
+		}
 		return f(s.store.Get(r.Path, r.Recursive, r.Sorted))
 	case "SYNC":
 		s.store.DeleteExpiredKeys(time.Unix(0, r.Time))
@@ -845,6 +1048,36 @@ func (s *EtcdServer) applyRequest(r pb.Request) Response {
 	}
 }
 
+// storeTxn converts the given transaction into the compares and ops applied
+// by store.Txn. The TTLs of the ops count from the given proposal time, so
+// that every member computes the same expiration.
+func storeTxn(txn TxnRequest, proposed time.Time) ([]store.TxnCompare, []store.TxnOp) {
+	cmps := make([]store.TxnCompare, len(txn.Compares))
+	for i, c := range txn.Compares {
+		cmps[i] = store.TxnCompare{
+			Key:       c.Key,
+			PrevValue: c.PrevValue,
+			PrevIndex: c.PrevIndex,
+			PrevExist: c.PrevExist,
+		}
+	}
+	ops := make([]store.TxnOp, len(txn.Ops))
+	for i, op := range txn.Ops {
+		ops[i] = store.TxnOp{
+			Action:    op.Action,
+			Key:       op.Key,
+			Value:     op.Value,
+			Dir:       op.Dir,
+			Recursive: op.Recursive,
+		}
+		// Null TTL is equivalent to unset Expiration
+		if op.TTL != nil {
+			ops[i].ExpireTime = proposed.Add(time.Duration(*op.TTL) * time.Second)
+		}
+	}
+	return cmps, ops
+}
+
 // applyConfChange applies a ConfChange to the server. It is only
 // invoked with a ConfChange that has already passed through Raft
 func (s *EtcdServer) applyConfChange(cc raftpb.ConfChange, confState *raftpb.ConfState) (bool, error) {
@@ -854,13 +1087,66 @@ func (s *EtcdServer) applyConfChange(cc raftpb.ConfChange, confState *raftpb.Con
 		return false, err
 	}
 	*confState = *s.r.ApplyConfChange(cc)
-	switch cc.Type {
+	return s.applyMemberChange(cc.Type, cc.NodeID, cc.Context), nil
+}
+
+// applyConfChangeV2 applies a ConfChangeV2 to the server. It is only
+// invoked with a ConfChangeV2 that has already passed through Raft.
+// Members added through joint consensus join the cluster as soon as the
+// joint configuration is entered, while removed ones leave it only once
+// the joint configuration is left.
+func (s *EtcdServer) applyConfChangeV2(cc raftpb.ConfChangeV2, confState *raftpb.ConfState) (bool, error) {
+	if cc.LeaveJoint() {
+		incoming := make(map[uint64]bool)
+		outgoing := confState.OutgoingNodes
+		*confState = *s.r.ApplyConfChangeV2(cc)
+		for _, id := range confState.Nodes {
+			incoming[id] = true
+		}
+		var shouldstop bool
+		for _, id := range outgoing {
+			if !incoming[id] {
+				shouldstop = s.applyMemberChange(raftpb.ConfChangeRemoveNode, id, nil) || shouldstop
+			}
+		}
+		return shouldstop, nil
+	}
+	if err := s.cluster.ValidateConfigurationChanges(confChanges(cc)); err != nil {
+		s.r.ApplyConfChangeV2(raftpb.ConfChangeV2{Changes: []raftpb.ConfChangeSingle{{NodeID: raft.None}}})
+		return false, err
+	}
+	_, joint := cc.EnterJoint()
+	*confState = *s.r.ApplyConfChangeV2(cc)
+	var shouldstop bool
+	for _, c := range cc.Changes {
+		if joint && c.Type == raftpb.ConfChangeRemoveNode {
+			continue
+		}
+		shouldstop = s.applyMemberChange(c.Type, c.NodeID, c.Context) || shouldstop
+	}
+	return shouldstop, nil
+}
+
+// confChanges returns the single changes of the given ConfChangeV2.
+func confChanges(cc raftpb.ConfChangeV2) []raftpb.ConfChange {
+	ccs := make([]raftpb.ConfChange, len(cc.Changes))
+	for i, c := range cc.Changes {
+		ccs[i] = raftpb.ConfChange{Type: c.Type, NodeID: c.NodeID, Context: c.Context}
+	}
+	return ccs
+}
+
+// applyMemberChange applies the effect of a single configuration change
+// on the cluster membership and the transport. It returns true if the
+// local member has been removed.
+func (s *EtcdServer) applyMemberChange(typ raftpb.ConfChangeType, nodeID uint64, context []byte) bool {
+	switch typ {
 	case raftpb.ConfChangeAddNode:
 		m := new(Member)
-		if err := json.Unmarshal(cc.Context, m); err != nil {
+		if err := json.Unmarshal(context, m); err != nil {
 			plog.Panicf("unmarshal member should never fail: %v", err)
 		}
-		if cc.NodeID != uint64(m.ID) {
+		if nodeID != uint64(m.ID) {
 			plog.Panicf("nodeID should always be equal to member ID")
 		}
 		s.cluster.AddMember(m)
@@ -871,20 +1157,20 @@ func (s *EtcdServer) applyConfChange(cc raftpb.ConfChange, confState *raftpb.Con
 			plog.Noticef("added member %s %v to cluster %s", m.ID, m.PeerURLs, s.cluster.ID())
 		}
 	case raftpb.ConfChangeRemoveNode:
-		id := types.ID(cc.NodeID)
+		id := types.ID(nodeID)
 		s.cluster.RemoveMember(id)
 		if id == s.id {
-			return true, nil
+			return true
 		} else {
 			s.r.transport.RemovePeer(id)
 			plog.Noticef("removed member %s from cluster %s", id, s.cluster.ID())
 		}
 	case raftpb.ConfChangeUpdateNode:
 		m := new(Member)
-		if err := json.Unmarshal(cc.Context, m); err != nil {
+		if err := json.Unmarshal(context, m); err != nil {
 			plog.Panicf("unmarshal member should never fail: %v", err)
 		}
-		if cc.NodeID != uint64(m.ID) {
+		if nodeID != uint64(m.ID) {
 			plog.Panicf("nodeID should always be equal to member ID")
 		}
 		s.cluster.UpdateRaftAttributes(m.ID, m.RaftAttributes)
@@ -895,19 +1181,38 @@ func (s *EtcdServer) applyConfChange(cc raftpb.ConfChange, confState *raftpb.Con
 			plog.Noticef("update member %s %v in cluster %s", m.ID, m.PeerURLs, s.cluster.ID())
 		}
 	}
-	return false, nil
+	return false
 }
 
 // TODO: non-blocking snapshot
 func (s *EtcdServer) snapshot(snapi uint64, confState raftpb.ConfState) {
 	clone := s.store.Clone()
+	// the snapshots are made in order, as a delta is based on the previous
+	// snapshot.
+	prevc, donec := s.snapDonec, make(chan struct{})
+	s.snapDonec = donec
 
 	go func() {
-		d, err := clone.SaveNoCopy()
-		// TODO: current store will never fail to do a snapshot
-		// what should we do if the store might fail?
-		if err != nil {
-			plog.Panicf("store save should never fail: %v", err)
+		defer close(donec)
+		if prevc != nil {
+			<-prevc
+		}
+		s.snapMu.Lock()
+		defer s.snapMu.Unlock()
+
+		binary := s.binarySnapshots()
+		full := !binary || !s.snapBinary || s.snapDeltas >= s.cfg.SnapDeltas
+		// the binary snapshots are encoded straight into the snap files,
+		// and read out of them when sent to a follower.
+		var d []byte
+		var err error
+		if !binary {
+			d, err = clone.SaveNoCopy()
+			// TODO: current store will never fail to do a snapshot
+			// what should we do if the store might fail?
+			if err != nil {
+				plog.Panicf("store save should never fail: %v", err)
+			}
 		}
 		snap, err := s.r.raftStorage.CreateSnapshot(snapi, &confState, d)
 		if err != nil {
@@ -918,10 +1223,32 @@ func (s *EtcdServer) snapshot(snapi uint64, confState raftpb.ConfState) {
 			}
 			plog.Panicf("unexpected create snapshot error %v", err)
 		}
-		if err := s.r.storage.SaveSnap(snap); err != nil {
+		switch {
+		case !binary:
+			err = s.r.storage.SaveSnap(snap)
+		case full:
+			err = s.r.storage.SaveSnapFrom(snap.Metadata, clone.SaveTo)
+		default:
+			// only the delta is saved, which is based on the previous
+			// snapshots saved.
+			since := s.snapStoreIndex
+			err = s.r.storage.SaveSnapFrom(snap.Metadata, func(w io.Writer) error {
+				return clone.SaveDeltaTo(w, since)
+			})
+		}
+		if err != nil {
 			plog.Fatalf("save snapshot error: %v", err)
 		}
-		plog.Infof("saved snapshot at index %d", snap.Metadata.Index)
+		// a delta cannot be based on a JSON snapshot.
+		s.snapBinary, s.snapStoreIndex = binary, clone.Index()
+		if full {
+			s.snapDeltas = 0
+			plog.Infof("saved snapshot at index %d", snap.Metadata.Index)
+		} else {
+			s.snapDeltas++
+			plog.Infof("saved delta snapshot at index %d", snap.Metadata.Index)
+		}
+		s.store.CompactChanges(s.snapStoreIndex)
 
 		// keep some in memory log entries for slow followers.
 		compacti := uint64(1)
@@ -941,6 +1268,43 @@ func (s *EtcdServer) snapshot(snapi uint64, confState raftpb.ConfState) {
 	}()
 }
 
+// binarySnapshots returns whether the snapshots of the store are saved in
+// the binary format, followed by deltas. It is the case if delta snapshots
+// are enabled and all the members can recover from binary snapshots.
+// Otherwise, full JSON snapshots are saved.
+func (s *EtcdServer) binarySnapshots() bool {
+	if s.cfg.SnapDeltas == 0 {
+		return false
+	}
+	v := s.ClusterVersion()
+	return v != nil && !v.LessThan(*binarySnapshotVersion)
+}
+
+// resetSnapshotChain makes the next snapshot a full one, after the store was
+// recovered from a snapshot this member did not save.
+func (s *EtcdServer) resetSnapshotChain() {
+	s.snapMu.Lock()
+	s.snapBinary, s.snapDeltas, s.snapStoreIndex = false, 0, 0
+	s.snapMu.Unlock()
+}
+
+// snapshotData reads the data of the snapshot of the given metadata out of
+// the snap files, for the snapshots whose data is not kept in the raft
+// storage: the ones loaded at start and the binary ones saved since then.
+func (s *EtcdServer) snapshotData(md raftpb.SnapshotMetadata) ([]byte, error) {
+	// the snapshot might still be being saved.
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+	snapshot, err := s.snapshotter.LoadChain(store.IsDeltaSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.Metadata.Index != md.Index || snapshot.Metadata.Term != md.Term {
+		return nil, fmt.Errorf("etcdserver: snapshot at index %d is no longer on disk", md.Index)
+	}
+	return snapshot.Data, nil
+}
+
 func (s *EtcdServer) PauseSending() { s.r.pauseSending() }
 
 func (s *EtcdServer) ResumeSending() { s.r.resumeSending() }
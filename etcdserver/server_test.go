@@ -17,13 +17,17 @@ package etcdserver
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"path"
 	"reflect"
 	"strconv"
 	"testing"
 	"time"
 
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/coreos/go-semver/semver"
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
 	"github.com/coreos/etcd/pkg/idutil"
@@ -32,7 +36,10 @@ import (
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft"
 	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/rafthttp"
+	"github.com/coreos/etcd/snap"
 	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/wal"
 )
 
 // TestDoLocalAction tests requests which do not need to go through raft to be applied,
@@ -47,7 +54,7 @@ func TestDoLocalAction(t *testing.T) {
 	}{
 		{
 			pb.Request{Method: "GET", ID: 1, Wait: true},
-			Response{Watcher: &nopWatcher{}}, nil, []testutil.Action{{Name: "Watch"}},
+			Response{Watcher: &nopWatcher{}}, nil, []testutil.Action{{Name: "WatchFiltered"}},
 		},
 		{
 			pb.Request{Method: "GET", ID: 1},
@@ -59,6 +66,16 @@ func TestDoLocalAction(t *testing.T) {
 				},
 			},
 		},
+		{
+			pb.Request{Method: "GET", ID: 1, Limit: 10, StartKey: "/foo"},
+			Response{Event: &store.Event{}}, nil,
+			[]testutil.Action{
+				{
+					Name:   "GetPage",
+					Params: []interface{}{"", false, "/foo", uint64(10)},
+				},
+			},
+		},
 		{
 			pb.Request{Method: "HEAD", ID: 1},
 			Response{Event: &store.Event{}}, nil,
@@ -106,7 +123,7 @@ func TestDoBadLocalAction(t *testing.T) {
 	}{
 		{
 			pb.Request{Method: "GET", ID: 1, Wait: true},
-			[]testutil.Action{{Name: "Watch"}},
+			[]testutil.Action{{Name: "WatchFiltered"}},
 		},
 		{
 			pb.Request{Method: "GET", ID: 1},
@@ -254,6 +271,17 @@ func TestApplyRequest(t *testing.T) {
 				},
 			},
 		},
+		// PUT with Refresh set ==> Refresh
+		{
+			pb.Request{Method: "PUT", ID: 1, Refresh: pbutil.Boolp(true), Expiration: 1337},
+			Response{Event: &store.Event{}},
+			[]testutil.Action{
+				{
+					Name:   "Refresh",
+					Params: []interface{}{"", time.Unix(0, 1337)},
+				},
+			},
+		},
 		// PUT with PrevIndex set ==> CompareAndSwap
 		{
 			pb.Request{Method: "PUT", ID: 1, PrevIndex: 1},
@@ -342,6 +370,54 @@ func TestApplyRequest(t *testing.T) {
 				},
 			},
 		},
+		// QGET with Limit set ==> GetPage
+		{
+			pb.Request{Method: "QGET", ID: 1, Limit: 10},
+			Response{Event: &store.Event{}},
+			[]testutil.Action{
+				{
+					Name:   "GetPage",
+					Params: []interface{}{"", false, "", uint64(10)},
+				},
+			},
+		},
+		// TXN ==> Txn
+		{
+			pb.Request{
+				Method: "TXN",
+				ID:     1,
+				Val:    `{"compares":[{"key":"/foo","prevValue":"bar"}],"ops":[{"action":"set","key":"/foo","value":"baz"},{"action":"delete","key":"/bar"}]}`,
+			},
+			Response{Events: []*store.Event{}},
+			[]testutil.Action{
+				{
+					Name: "Txn",
+					Params: []interface{}{
+						[]store.TxnCompare{{Key: "/foo", PrevValue: "bar"}},
+						[]store.TxnOp{{Action: store.Set, Key: "/foo", Value: "baz"}, {Action: store.Delete, Key: "/bar"}},
+					},
+				},
+			},
+		},
+		// TXN with a TTL ==> Txn expiring from the proposal time
+		{
+			pb.Request{
+				Method: "TXN",
+				ID:     1,
+				Val:    `{"ops":[{"action":"set","key":"/foo","ttl":10}]}`,
+				Time:   time.Unix(1000, 0).UnixNano(),
+			},
+			Response{Events: []*store.Event{}},
+			[]testutil.Action{
+				{
+					Name: "Txn",
+					Params: []interface{}{
+						[]store.TxnCompare{},
+						[]store.TxnOp{{Action: store.Set, Key: "/foo", ExpireTime: time.Unix(1010, 0)}},
+					},
+				},
+			},
+		},
 		// SYNC ==> DeleteExpiredKeys
 		{
 			pb.Request{Method: "SYNC", ID: 1},
@@ -507,6 +583,82 @@ func TestApplyConfChangeShouldStop(t *testing.T) {
 	}
 }
 
+// TestApplyConfChangeV2 tests that members added through joint consensus
+// join the cluster when the joint configuration is entered, and that the
+// removed ones leave it when the joint configuration is left.
+func TestApplyConfChangeV2(t *testing.T) {
+	cl := newCluster("")
+	cl.SetStore(store.New())
+	for i := 1; i <= 3; i++ {
+		cl.AddMember(&Member{ID: types.ID(i)})
+	}
+	n := &nodeRecorder{}
+	srv := &EtcdServer{
+		id: 1,
+		r: raftNode{
+			Node:      n,
+			transport: &nopTransporter{},
+		},
+		cluster: cl,
+	}
+	b, err := json.Marshal(&Member{ID: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := raftpb.ConfChangeV2{Changes: []raftpb.ConfChangeSingle{
+		{Type: raftpb.ConfChangeRemoveNode, NodeID: 1},
+		{Type: raftpb.ConfChangeAddNode, NodeID: 4, Context: b},
+	}}
+	cs := &raftpb.ConfState{}
+	shouldStop, err := srv.applyConfChangeV2(cc, cs)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if shouldStop != false {
+		t.Errorf("shouldStop = %t, want %t", shouldStop, false)
+	}
+	if cl.Member(1) == nil || cl.Member(4) == nil {
+		t.Errorf("members = %v, want 1 and 4 in the cluster", cl.MemberIDs())
+	}
+
+	// leaving the joint configuration removes the local member
+	*cs = raftpb.ConfState{Nodes: []uint64{2, 3, 4}, OutgoingNodes: []uint64{1, 2, 3}}
+	srv.r.Node = &nodeConfStateRecorder{cs: raftpb.ConfState{Nodes: []uint64{2, 3, 4}}}
+	shouldStop, err = srv.applyConfChangeV2(raftpb.ConfChangeV2{}, cs)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if shouldStop != true {
+		t.Errorf("shouldStop = %t, want %t", shouldStop, true)
+	}
+	if cl.Member(1) != nil {
+		t.Errorf("member with id 1 is not removed")
+	}
+
+	// an invalid change resets raft without changing the cluster
+	cc = raftpb.ConfChangeV2{Changes: []raftpb.ConfChangeSingle{
+		{Type: raftpb.ConfChangeRemoveNode, NodeID: 2},
+		{Type: raftpb.ConfChangeAddNode, NodeID: 1, Context: b},
+	}}
+	n = &nodeRecorder{}
+	srv.r.Node = n
+	if _, err = srv.applyConfChangeV2(cc, cs); err != ErrIDRemoved {
+		t.Errorf("applyConfChangeV2 error = %v, want %v", err, ErrIDRemoved)
+	}
+	w := []testutil.Action{
+		{
+			Name:   "ApplyConfChangeV2",
+			Params: []interface{}{raftpb.ConfChangeV2{Changes: []raftpb.ConfChangeSingle{{NodeID: raft.None}}}},
+		},
+	}
+	if g := n.Action(); !reflect.DeepEqual(g, w) {
+		t.Errorf("action = %+v, want %+v", g, w)
+	}
+	if cl.Member(2) == nil {
+		t.Errorf("member with id 2 is removed")
+	}
+}
+
 func TestDoProposal(t *testing.T) {
 	tests := []pb.Request{
 		{Method: "POST", ID: 1},
@@ -580,6 +732,24 @@ func TestDoProposalTimeout(t *testing.T) {
 	}
 }
 
+func TestDoProposalDropped(t *testing.T) {
+	wait := &waitRecorder{}
+	srv := &EtcdServer{
+		cfg:      &ServerConfig{TickMs: 1},
+		r:        raftNode{Node: &nodeProposalDropperRecorder{}},
+		w:        wait,
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	_, err := srv.Do(context.Background(), pb.Request{Method: "PUT"})
+	if err != ErrTooManyRequests {
+		t.Fatalf("err = %v, want %v", err, ErrTooManyRequests)
+	}
+	w := []testutil.Action{{Name: "Register"}, {Name: "Trigger"}}
+	if !reflect.DeepEqual(wait.action, w) {
+		t.Errorf("wait.action = %+v, want %+v", wait.action, w)
+	}
+}
+
 func TestDoProposalStopped(t *testing.T) {
 	srv := &EtcdServer{
 		cfg:      &ServerConfig{TickMs: 1},
@@ -709,13 +879,14 @@ func TestSnapshot(t *testing.T) {
 			raftStorage: s,
 			storage:     p,
 		},
+		cfg:   &ServerConfig{},
 		store: st,
 	}
 	srv.snapshot(1, raftpb.ConfState{Nodes: []uint64{1}})
 	testutil.WaitSchedule()
 	gaction := st.Action()
-	if len(gaction) != 2 {
-		t.Fatalf("len(action) = %d, want 1", len(gaction))
+	if len(gaction) != 3 {
+		t.Fatalf("len(action) = %d, want 3", len(gaction))
 	}
 	if !reflect.DeepEqual(gaction[0], testutil.Action{Name: "Clone"}) {
 		t.Errorf("action = %s, want Clone", gaction[0])
@@ -723,6 +894,9 @@ func TestSnapshot(t *testing.T) {
 	if !reflect.DeepEqual(gaction[1], testutil.Action{Name: "SaveNoCopy"}) {
 		t.Errorf("action = %s, want SaveNoCopy", gaction[1])
 	}
+	if gaction[2].Name != "CompactChanges" {
+		t.Errorf("action = %s, want CompactChanges", gaction[2])
+	}
 	gaction = p.Action()
 	if len(gaction) != 1 {
 		t.Fatalf("len(action) = %d, want 1", len(gaction))
@@ -732,6 +906,119 @@ func TestSnapshot(t *testing.T) {
 	}
 }
 
+// snapshot should save the changes since the previous snapshot as a delta
+// into the snap files, from which the full snapshot followed by the delta is
+// read back when sent.
+func TestSnapshotDelta(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "snapshotdelta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := wal.Create(path.Join(dir, "wal"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := os.Mkdir(path.Join(dir, "snap"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	ss := snap.New(path.Join(dir, "snap"))
+
+	s := raft.NewMemoryStorage()
+	s.Append([]raftpb.Entry{{Index: 1}, {Index: 2}, {Index: 3}})
+	st := store.New()
+	srv := &EtcdServer{
+		r: raftNode{
+			Node:        &nodeRecorder{},
+			raftStorage: s,
+			storage:     NewStorage(w, ss),
+		},
+		cfg:         &ServerConfig{SnapDeltas: 1},
+		store:       st,
+		cluster:     &cluster{version: semver.Must(semver.NewVersion("2.2.0"))},
+		snapshotter: ss,
+	}
+	tests := []struct {
+		key   string
+		delta bool
+	}{
+		{"/foo", false},
+		{"/bar", true},
+		{"/baz", false},
+	}
+	for i, tt := range tests {
+		if _, err := st.Create(tt.key, false, "v", false, store.Permanent); err != nil {
+			t.Fatal(err)
+		}
+		srv.snapshot(uint64(i+1), raftpb.ConfState{Nodes: []uint64{1}})
+		<-srv.snapDonec
+
+		if g := srv.snapDeltas > 0; g != tt.delta {
+			t.Errorf("#%d: delta = %v, want %v", i, g, tt.delta)
+		}
+		snapshot, err := s.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshot.Data) != 0 {
+			t.Errorf("#%d: len(data) = %d, want 0", i, len(snapshot.Data))
+		}
+		d, err := srv.snapshotData(snapshot.Metadata)
+		if err != nil {
+			t.Fatalf("#%d: snapshot data error: %v", i, err)
+		}
+		rst := store.New()
+		if err := rst.Recovery(d); err != nil {
+			t.Fatalf("#%d: recovery error: %v", i, err)
+		}
+		for _, prev := range tests[:i+1] {
+			if _, err := rst.Get(prev.key, false, false); err != nil {
+				t.Errorf("#%d: get %s error: %v", i, prev.key, err)
+			}
+		}
+	}
+}
+
+// snapshot should save full JSON snapshots, and no delta, unless the cluster
+// version supports binary snapshots.
+func TestSnapshotDeltaOldCluster(t *testing.T) {
+	for i, cv := range []*semver.Version{nil, semver.Must(semver.NewVersion("2.1.0"))} {
+		s := raft.NewMemoryStorage()
+		s.Append([]raftpb.Entry{{Index: 1}, {Index: 2}})
+		st := store.New()
+		srv := &EtcdServer{
+			r: raftNode{
+				Node:        &nodeRecorder{},
+				raftStorage: s,
+				storage:     &storageRecorder{},
+			},
+			cfg:     &ServerConfig{SnapDeltas: 1},
+			store:   st,
+			cluster: &cluster{version: cv},
+		}
+		for j, key := range []string{"/foo", "/bar"} {
+			if _, err := st.Create(key, false, "v", false, store.Permanent); err != nil {
+				t.Fatal(err)
+			}
+			srv.snapshot(uint64(j+1), raftpb.ConfState{Nodes: []uint64{1}})
+			<-srv.snapDonec
+
+			snap, err := s.Snapshot()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v interface{}
+			if err := json.Unmarshal(snap.Data, &v); err != nil {
+				t.Errorf("#%d.%d: snapshot data is not JSON (%v)", i, j, err)
+			}
+			if srv.snapBinary {
+				t.Errorf("#%d.%d: binary snapshot saved, want JSON", i, j)
+			}
+		}
+	}
+}
+
 // Applied > SnapCount should trigger a SaveSnap event
 func TestTriggerSnap(t *testing.T) {
 	snapc := 10
@@ -923,6 +1210,50 @@ func TestRemoveMember(t *testing.T) {
 	}
 }
 
+// TestReplaceMembers tests ReplaceMembers can propose and perform a joint
+// membership change.
+func TestReplaceMembers(t *testing.T) {
+	n := newNodeConfChangeCommitterRecorder()
+	n.readyc <- raft.Ready{
+		SoftState: &raft.SoftState{RaftState: raft.StateLeader},
+	}
+	cl := newTestCluster(nil)
+	st := store.New()
+	cl.SetStore(store.New())
+	cl.AddMember(&Member{ID: 1234})
+	s := &EtcdServer{
+		r: raftNode{
+			Node:        n,
+			raftStorage: raft.NewMemoryStorage(),
+			storage:     &storageRecorder{},
+			transport:   &nopTransporter{},
+		},
+		store:    st,
+		cluster:  cl,
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	s.start()
+	m := Member{ID: 5678, RaftAttributes: RaftAttributes{PeerURLs: []string{"foo"}}}
+	err := s.ReplaceMembers(context.TODO(), []uint64{1234}, []Member{m})
+	gaction := n.Action()
+	s.Stop()
+
+	if err != nil {
+		t.Fatalf("ReplaceMembers error: %v", err)
+	}
+	wactions := []testutil.Action{{Name: "ProposeConfChangeV2"}, {Name: "ApplyConfChangeV2"}}
+	if !reflect.DeepEqual(gaction, wactions) {
+		t.Errorf("action = %v, want %v", gaction, wactions)
+	}
+	if cl.Member(5678) == nil {
+		t.Errorf("member with id 5678 is not added")
+	}
+	// the removal waits for the joint configuration to be left
+	if cl.Member(1234) == nil {
+		t.Errorf("member with id 1234 is removed")
+	}
+}
+
 // TestUpdateMember tests RemoveMember can propose and perform node update.
 func TestUpdateMember(t *testing.T) {
 	n := newNodeConfChangeCommitterRecorder()
@@ -1166,6 +1497,13 @@ func (s *storeRecorder) Get(path string, recursive, sorted bool) (*store.Event,
 	})
 	return &store.Event{}, nil
 }
+func (s *storeRecorder) GetPage(path string, recursive bool, startKey string, limit uint64) (*store.Event, error) {
+	s.Record(testutil.Action{
+		Name:   "GetPage",
+		Params: []interface{}{path, recursive, startKey, limit},
+	})
+	return &store.Event{}, nil
+}
 func (s *storeRecorder) Set(path string, dir bool, val string, expr time.Time) (*store.Event, error) {
 	s.Record(testutil.Action{
 		Name:   "Set",
@@ -1180,6 +1518,13 @@ func (s *storeRecorder) Update(path, val string, expr time.Time) (*store.Event,
 	})
 	return &store.Event{}, nil
 }
+func (s *storeRecorder) Refresh(path string, expr time.Time) (*store.Event, error) {
+	s.Record(testutil.Action{
+		Name:   "Refresh",
+		Params: []interface{}{path, expr},
+	})
+	return &store.Event{}, nil
+}
 func (s *storeRecorder) Create(path string, dir bool, val string, uniq bool, exp time.Time) (*store.Event, error) {
 	s.Record(testutil.Action{
 		Name:   "Create",
@@ -1208,10 +1553,21 @@ func (s *storeRecorder) CompareAndDelete(path, prevVal string, prevIdx uint64) (
 	})
 	return &store.Event{}, nil
 }
+func (s *storeRecorder) Txn(compares []store.TxnCompare, ops []store.TxnOp) ([]*store.Event, error) {
+	s.Record(testutil.Action{
+		Name:   "Txn",
+		Params: []interface{}{compares, ops},
+	})
+	return []*store.Event{}, nil
+}
 func (s *storeRecorder) Watch(_ string, _, _ bool, _ uint64) (store.Watcher, error) {
 	s.Record(testutil.Action{Name: "Watch"})
 	return &nopWatcher{}, nil
 }
+func (s *storeRecorder) WatchFiltered(_ string, _, _ bool, _ uint64, _ store.WatchFilter) (store.Watcher, error) {
+	s.Record(testutil.Action{Name: "WatchFiltered"})
+	return &nopWatcher{}, nil
+}
 func (s *storeRecorder) Save() ([]byte, error) {
 	s.Record(testutil.Action{Name: "Save"})
 	return nil, nil
@@ -1220,12 +1576,30 @@ func (s *storeRecorder) Recovery(b []byte) error {
 	s.Record(testutil.Action{Name: "Recovery"})
 	return nil
 }
+func (s *storeRecorder) RecoveryFrom(r io.Reader) error {
+	s.Record(testutil.Action{Name: "RecoveryFrom"})
+	return nil
+}
 
 func (s *storeRecorder) SaveNoCopy() ([]byte, error) {
 	s.Record(testutil.Action{Name: "SaveNoCopy"})
 	return nil, nil
 }
 
+func (s *storeRecorder) SaveTo(w io.Writer) error {
+	s.Record(testutil.Action{Name: "SaveTo"})
+	return nil
+}
+
+func (s *storeRecorder) SaveDeltaTo(w io.Writer, since uint64) error {
+	s.Record(testutil.Action{Name: "SaveDeltaTo", Params: []interface{}{since}})
+	return nil
+}
+
+func (s *storeRecorder) CompactChanges(index uint64) {
+	s.Record(testutil.Action{Name: "CompactChanges", Params: []interface{}{index}})
+}
+
 func (s *storeRecorder) Clone() store.Store {
 	s.Record(testutil.Action{Name: "Clone"})
 	return s
@@ -1243,6 +1617,7 @@ type nopWatcher struct{}
 
 func (w *nopWatcher) EventChan() chan *store.Event { return nil }
 func (w *nopWatcher) StartIndex() uint64           { return 0 }
+func (w *nopWatcher) CurrentIndex() uint64         { return 0 }
 func (w *nopWatcher) Remove()                      {}
 
 // errStoreRecorder is a storeRecorder, but returns the given error on
@@ -1260,6 +1635,10 @@ func (s *errStoreRecorder) Watch(path string, recursive, sorted bool, index uint
 	s.storeRecorder.Watch(path, recursive, sorted, index)
 	return nil, s.err
 }
+func (s *errStoreRecorder) WatchFiltered(path string, recursive, sorted bool, index uint64, filter store.WatchFilter) (store.Watcher, error) {
+	s.storeRecorder.WatchFiltered(path, recursive, sorted, index, filter)
+	return nil, s.err
+}
 
 type waitRecorder struct {
 	action []testutil.Action
@@ -1294,6 +1673,14 @@ func (p *storageRecorder) SaveSnap(st raftpb.Snapshot) error {
 	}
 	return nil
 }
+func (p *storageRecorder) SaveSnapFrom(md raftpb.SnapshotMetadata, save func(w io.Writer) error) error {
+	p.Record(testutil.Action{Name: "SaveSnapFrom"})
+	return nil
+}
+func (p *storageRecorder) OpenSegments(index uint64) ([]*os.File, int64, error) {
+	p.Record(testutil.Action{Name: "OpenSegments"})
+	return nil, 0, nil
+}
 func (p *storageRecorder) Close() error { return nil }
 
 type nodeRecorder struct{ testutil.Recorder }
@@ -1311,10 +1698,18 @@ func (n *nodeRecorder) ProposeConfChange(ctx context.Context, conf raftpb.ConfCh
 	n.Record(testutil.Action{Name: "ProposeConfChange"})
 	return nil
 }
+func (n *nodeRecorder) ProposeConfChangeV2(ctx context.Context, conf raftpb.ConfChangeV2) error {
+	n.Record(testutil.Action{Name: "ProposeConfChangeV2"})
+	return nil
+}
 func (n *nodeRecorder) Step(ctx context.Context, msg raftpb.Message) error {
 	n.Record(testutil.Action{Name: "Step"})
 	return nil
 }
+func (n *nodeRecorder) ReadIndex(ctx context.Context, rctx []byte) error {
+	n.Record(testutil.Action{Name: "ReadIndex"})
+	return nil
+}
 func (n *nodeRecorder) Status() raft.Status      { return raft.Status{} }
 func (n *nodeRecorder) Ready() <-chan raft.Ready { return nil }
 func (n *nodeRecorder) Advance()                 {}
@@ -1323,6 +1718,11 @@ func (n *nodeRecorder) ApplyConfChange(conf raftpb.ConfChange) *raftpb.ConfState
 	return &raftpb.ConfState{}
 }
 
+func (n *nodeRecorder) ApplyConfChangeV2(conf raftpb.ConfChangeV2) *raftpb.ConfState {
+	n.Record(testutil.Action{Name: "ApplyConfChangeV2", Params: []interface{}{conf}})
+	return &raftpb.ConfState{}
+}
+
 func (n *nodeRecorder) Stop() {
 	n.Record(testutil.Action{Name: "Stop"})
 }
@@ -1335,6 +1735,15 @@ func (n *nodeRecorder) Compact(index uint64, nodes []uint64, d []byte) {
 	n.Record(testutil.Action{Name: "Compact"})
 }
 
+type nodeProposalDropperRecorder struct {
+	nodeRecorder
+}
+
+func (n *nodeProposalDropperRecorder) Propose(ctx context.Context, data []byte) error {
+	n.Record(testutil.Action{Name: "Propose dropped"})
+	return raft.ErrProposalDropped
+}
+
 type nodeProposalBlockerRecorder struct {
 	nodeRecorder
 }
@@ -1365,6 +1774,16 @@ func (n *nodeConfChangeCommitterRecorder) ProposeConfChange(ctx context.Context,
 	n.readyc <- raft.Ready{CommittedEntries: []raftpb.Entry{{Index: n.index, Type: raftpb.EntryConfChange, Data: data}}}
 	return nil
 }
+func (n *nodeConfChangeCommitterRecorder) ProposeConfChangeV2(ctx context.Context, conf raftpb.ConfChangeV2) error {
+	data, err := conf.Marshal()
+	if err != nil {
+		return err
+	}
+	n.index++
+	n.Record(testutil.Action{Name: "ProposeConfChangeV2"})
+	n.readyc <- raft.Ready{CommittedEntries: []raftpb.Entry{{Index: n.index, Type: raftpb.EntryConfChangeV2, Data: data}}}
+	return nil
+}
 func (n *nodeConfChangeCommitterRecorder) Ready() <-chan raft.Ready {
 	return n.readyc
 }
@@ -1373,6 +1792,23 @@ func (n *nodeConfChangeCommitterRecorder) ApplyConfChange(conf raftpb.ConfChange
 	return &raftpb.ConfState{}
 }
 
+func (n *nodeConfChangeCommitterRecorder) ApplyConfChangeV2(conf raftpb.ConfChangeV2) *raftpb.ConfState {
+	n.Record(testutil.Action{Name: "ApplyConfChangeV2"})
+	return &raftpb.ConfState{}
+}
+
+// nodeConfStateRecorder returns the given ConfState when applying a
+// ConfChangeV2.
+type nodeConfStateRecorder struct {
+	nodeRecorder
+	cs raftpb.ConfState
+}
+
+func (n *nodeConfStateRecorder) ApplyConfChangeV2(conf raftpb.ConfChangeV2) *raftpb.ConfState {
+	n.Record(testutil.Action{Name: "ApplyConfChangeV2"})
+	return &n.cs
+}
+
 // nodeCommitter commits proposed data immediately.
 type nodeCommitter struct {
 	nodeRecorder
@@ -1408,6 +1844,20 @@ func newReadyNode() *readyNode {
 }
 func (n *readyNode) Ready() <-chan raft.Ready { return n.readyc }
 
+// advanceNode notifies advancec when it is advanced.
+type advanceNode struct {
+	readyNode
+	advancec chan struct{}
+}
+
+func newAdvanceNode() *advanceNode {
+	return &advanceNode{
+		readyNode: readyNode{readyc: make(chan raft.Ready, 1)},
+		advancec:  make(chan struct{}, 1),
+	}
+}
+func (n *advanceNode) Advance() { n.advancec <- struct{}{} }
+
 type nopTransporter struct{}
 
 func (s *nopTransporter) Handler() http.Handler               { return nil }
@@ -1418,6 +1868,7 @@ func (s *nopTransporter) RemovePeer(id types.ID)              {}
 func (s *nopTransporter) RemoveAllPeers()                     {}
 func (s *nopTransporter) UpdatePeer(id types.ID, us []string) {}
 func (s *nopTransporter) ActiveSince(id types.ID) time.Time   { return time.Time{} }
+func (s *nopTransporter) PeerStats() []rafthttp.PeerStats     { return nil }
 func (s *nopTransporter) Stop()                               {}
 func (s *nopTransporter) Pause()                              {}
 func (s *nopTransporter) Resume()                             {}
@@ -28,4 +28,10 @@ type Stats interface {
 	LeaderStats() []byte
 	// StoreStats returns statistics of the store backing this EtcdServer
 	StoreStats() []byte
+	// RaftStats returns the status of the raft of this server, including
+	// the progress of all followers if this server is leader.
+	RaftStats() []byte
+	// PeerStats returns the status of the connections of this server
+	// with its peers.
+	PeerStats() []byte
 }
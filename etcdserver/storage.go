@@ -21,7 +21,6 @@ import (
 
 	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
 	"github.com/coreos/etcd/pkg/pbutil"
-	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft/raftpb"
 	"github.com/coreos/etcd/snap"
 	"github.com/coreos/etcd/version"
@@ -35,6 +34,13 @@ type Storage interface {
 	Save(st raftpb.HardState, ents []raftpb.Entry) error
 	// SaveSnap function saves snapshot to the underlying stable storage.
 	SaveSnap(snap raftpb.Snapshot) error
+	// SaveSnapFrom saves like SaveSnap the snapshot of the given metadata,
+	// whose data is written by save as it is saved.
+	SaveSnapFrom(md raftpb.SnapshotMetadata, save func(w io.Writer) error) error
+	// OpenSegments opens the wal files holding the records which follow
+	// the given index, and returns them with the size up to which the
+	// last one holds complete records.
+	OpenSegments(index uint64) ([]*os.File, int64, error)
 	// Close closes the Storage and performs finalization.
 	Close() error
 }
@@ -51,26 +57,57 @@ func NewStorage(w *wal.WAL, s *snap.Snapshotter) Storage {
 // SaveSnap saves the snapshot to disk and release the locked
 // wal files since they will not be used.
 func (st *storage) SaveSnap(snap raftpb.Snapshot) error {
+	return st.saveSnap(snap.Metadata, func() error { return st.Snapshotter.SaveSnap(snap) })
+}
+
+func (st *storage) SaveSnapFrom(md raftpb.SnapshotMetadata, save func(w io.Writer) error) error {
+	return st.saveSnap(md, func() error { return st.Snapshotter.SaveSnapFrom(md, save) })
+}
+
+// saveSnap saves the snapshot of the given metadata to disk with save, and
+// release the locked wal files since they will not be used.
+func (st *storage) saveSnap(md raftpb.SnapshotMetadata, save func() error) error {
 	walsnap := walpb.Snapshot{
-		Index: snap.Metadata.Index,
-		Term:  snap.Metadata.Term,
+		Index: md.Index,
+		Term:  md.Term,
 	}
 	err := st.WAL.SaveSnapshot(walsnap)
 	if err != nil {
 		return err
 	}
-	err = st.Snapshotter.SaveSnap(snap)
+	err = save()
 	if err != nil {
 		return err
 	}
-	err = st.WAL.ReleaseLockTo(snap.Metadata.Index)
+	err = st.WAL.ReleaseLockTo(md.Index)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func readWAL(waldir string, snap walpb.Snapshot) (w *wal.WAL, id, cid types.ID, st raftpb.HardState, ents []raftpb.Entry) {
+func readWAL(waldir string, snap walpb.Snapshot, opts wal.Options) (w *wal.WAL, md pb.Metadata, st raftpb.HardState, ents []raftpb.Entry) {
+	w, md = openWAL(waldir, snap, opts, func(w *wal.WAL) (wmetadata []byte, err error) {
+		wmetadata, st, ents, err = w.ReadAll()
+		return
+	})
+	return
+}
+
+// readWALStorage is like readWAL, but returns a storage which reads the
+// entries of the WAL lazily instead of the entries.
+func readWALStorage(waldir string, snap raftpb.Snapshot, opts wal.Options) (w *wal.WAL, md pb.Metadata, s *wal.Storage) {
+	walsnap := walpb.Snapshot{Index: snap.Metadata.Index, Term: snap.Metadata.Term}
+	w, md = openWAL(waldir, walsnap, opts, func(w *wal.WAL) (wmetadata []byte, err error) {
+		wmetadata, s, err = w.ReadStorage(snap, raftLogCacheSize)
+		return
+	})
+	return
+}
+
+// openWAL opens the WAL at the given snap and reads it out with the given
+// function, repairing it if needed. It returns the metadata of the WAL.
+func openWAL(waldir string, snap walpb.Snapshot, opts wal.Options, read func(w *wal.WAL) ([]byte, error)) (w *wal.WAL, md pb.Metadata) {
 	var (
 		err       error
 		wmetadata []byte
@@ -78,10 +115,10 @@ func readWAL(waldir string, snap walpb.Snapshot) (w *wal.WAL, id, cid types.ID,
 
 	repaired := false
 	for {
-		if w, err = wal.Open(waldir, snap); err != nil {
+		if w, err = wal.OpenWithOptions(waldir, snap, opts); err != nil {
 			plog.Fatalf("open wal error: %v", err)
 		}
-		if wmetadata, st, ents, err = w.ReadAll(); err != nil {
+		if wmetadata, err = read(w); err != nil {
 			w.Close()
 			// we can only repair ErrUnexpectedEOF and we never repair twice.
 			if repaired || err != io.ErrUnexpectedEOF {
@@ -97,10 +134,7 @@ func readWAL(waldir string, snap walpb.Snapshot) (w *wal.WAL, id, cid types.ID,
 		}
 		break
 	}
-	var metadata pb.Metadata
-	pbutil.MustUnmarshal(&metadata, wmetadata)
-	id = types.ID(metadata.NodeID)
-	cid = types.ID(metadata.ClusterID)
+	pbutil.MustUnmarshal(&md, wmetadata)
 	return
 }
 
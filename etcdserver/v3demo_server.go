@@ -16,11 +16,14 @@ package etcdserver
 
 import (
 	"bytes"
+	"io"
+	"os"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
 	dstorage "github.com/coreos/etcd/storage"
+	"github.com/coreos/etcd/storage/backend"
 )
 
 type V3DemoServer interface {
@@ -28,6 +31,8 @@ type V3DemoServer interface {
 }
 
 func (s *EtcdServer) V3DemoDo(ctx context.Context, r pb.InternalRaftRequest) proto.Message {
+	s.kvMu.RLock()
+	defer s.kvMu.RUnlock()
 	switch {
 	case r.Range != nil:
 		return doRange(s.kv, r.Range)
@@ -167,3 +172,40 @@ func compareInt64(a, b int64) int {
 		return 0
 	}
 }
+
+// snapshotKV writes the v3 database into w, to be sent along a snapshot.
+// An encrypted database is written decrypted, to be sealed by the receiver
+// with its own key provider.
+func (s *EtcdServer) snapshotKV(w io.Writer) (int64, error) {
+	s.kvMu.RLock()
+	defer s.kvMu.RUnlock()
+	return s.kv.Snapshot(w)
+}
+
+// recoverKV replaces the v3 database with the one received along the
+// snapshot at the given index, if any.
+func (s *EtcdServer) recoverKV(index uint64) {
+	fn := s.snapshotter.DBFilePath(index)
+	if _, err := os.Stat(fn); os.IsNotExist(err) {
+		plog.Warningf("no database received along the snapshot at index %d", index)
+		return
+	}
+	if err := backend.CheckKeyProvider(fn, s.cfg.KeyProvider); err != nil {
+		plog.Errorf("cannot open the database received along the snapshot at index %d (%v)", index, err)
+		plog.Errorf("the v3 database is left at its state before the snapshot")
+		return
+	}
+	s.kvMu.Lock()
+	defer s.kvMu.Unlock()
+	if err := s.kv.Close(); err != nil {
+		plog.Panicf("close KV error: %v", err)
+	}
+	if err := os.Rename(fn, s.cfg.v3demoPath()); err != nil {
+		plog.Panicf("rename database error: %v", err)
+	}
+	s.kv = dstorage.NewWithKeyProvider(s.cfg.v3demoPath(), s.cfg.KeyProvider)
+	if err := s.kv.Restore(); err != nil {
+		plog.Panicf("restore KV error: %v", err)
+	}
+	plog.Infof("recovered the v3 database received along the snapshot at index %d", index)
+}
@@ -0,0 +1,86 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"strings"
+
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/pbutil"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/store"
+)
+
+// A witness member votes in elections and acknowledges entries like any
+// other member, but it only keeps the cluster metadata: the requests on the
+// key space are stripped before they are written to its WAL, and are never
+// applied to its store.
+
+// isClusterPath returns true if the given store path is in the cluster
+// metadata, which a witness keeps.
+func isClusterPath(p string) bool {
+	return p == StoreClusterPrefix || strings.HasPrefix(p, StoreClusterPrefix+"/")
+}
+
+// entryRequest returns the v2 request held by the given normal entry, or
+// nil if it holds none.
+func entryRequest(data []byte) *pb.Request {
+	var raftReq pb.InternalRaftRequest
+	if !pbutil.MaybeUnmarshal(&raftReq, data) { // backward compatible
+		var r pb.Request
+		pbutil.MustUnmarshal(&r, data)
+		return &r
+	}
+	return raftReq.V2
+}
+
+// isClusterEntry returns true if the given normal entry holds a request on
+// the cluster metadata.
+func isClusterEntry(e raftpb.Entry) bool {
+	r := entryRequest(e.Data)
+	return r != nil && isClusterPath(r.Path)
+}
+
+// witnessEntries returns the given entries as a witness persists them. The
+// requests which are not on the cluster metadata are stripped down to their
+// ID, so that their entries keep their index and term only.
+func witnessEntries(ents []raftpb.Entry) []raftpb.Entry {
+	wents := make([]raftpb.Entry, len(ents))
+	for i, e := range ents {
+		if e.Type == raftpb.EntryNormal && len(e.Data) != 0 && !isClusterEntry(e) {
+			var id uint64
+			if r := entryRequest(e.Data); r != nil {
+				id = r.ID
+			}
+			e.Data = pbutil.MustMarshal(&pb.Request{ID: id})
+		}
+		wents[i] = e
+	}
+	return wents
+}
+
+// witnessSnapshot returns the given snapshot as a witness persists it, that
+// is with the cluster metadata of its store only. The rest of the store is
+// skipped as the snapshot is decoded.
+func witnessSnapshot(snap raftpb.Snapshot) raftpb.Snapshot {
+	st, err := store.RecoverPrefix(snap.Data, StoreClusterPrefix, StoreClusterPrefix, StoreKeysPrefix)
+	if err != nil {
+		plog.Panicf("recovered store from snapshot error: %v", err)
+	}
+	if snap.Data, err = st.Save(); err != nil {
+		plog.Panicf("store save should never fail: %v", err)
+	}
+	return snap
+}
@@ -0,0 +1,168 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/idutil"
+	"github.com/coreos/etcd/pkg/pbutil"
+	"github.com/coreos/etcd/pkg/testutil"
+	"github.com/coreos/etcd/pkg/wait"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/store"
+)
+
+func TestIsClusterPath(t *testing.T) {
+	tests := []struct {
+		p  string
+		wb bool
+	}{
+		{"/0", true},
+		{"/0/version", true},
+		{"/0/members/1/attributes", true},
+		{"/1/foo", false},
+		{"/0foo", false},
+		{"", false},
+	}
+	for i, tt := range tests {
+		if g := isClusterPath(tt.p); g != tt.wb {
+			t.Errorf("#%d: isClusterPath(%q) = %v, want %v", i, tt.p, g, tt.wb)
+		}
+	}
+}
+
+func TestWitnessEntries(t *testing.T) {
+	creq := pb.Request{ID: 1, Method: "PUT", Path: "/0/members/1/attributes", Val: "{}"}
+	kreq := pb.Request{ID: 2, Method: "PUT", Path: "/1/foo", Val: "bar"}
+	cc := raftpb.ConfChange{ID: 3, Type: raftpb.ConfChangeAddNode, NodeID: 2}
+	ents := []raftpb.Entry{
+		{Index: 1, Term: 1, Type: raftpb.EntryNormal},
+		{Index: 2, Term: 1, Type: raftpb.EntryNormal, Data: pbutil.MustMarshal(&creq)},
+		{Index: 3, Term: 1, Type: raftpb.EntryNormal, Data: pbutil.MustMarshal(&kreq)},
+		{Index: 4, Term: 2, Type: raftpb.EntryNormal, Data: pbutil.MustMarshal(&pb.InternalRaftRequest{V2: &kreq})},
+		{Index: 5, Term: 2, Type: raftpb.EntryConfChange, Data: pbutil.MustMarshal(&cc)},
+	}
+	wents := []raftpb.Entry{
+		ents[0],
+		ents[1],
+		{Index: 3, Term: 1, Type: raftpb.EntryNormal, Data: pbutil.MustMarshal(&pb.Request{ID: 2})},
+		{Index: 4, Term: 2, Type: raftpb.EntryNormal, Data: pbutil.MustMarshal(&pb.Request{ID: 2})},
+		ents[4],
+	}
+	data := ents[2].Data
+
+	g := witnessEntries(ents)
+	if !reflect.DeepEqual(g, wents) {
+		t.Errorf("entries = %+v, want %+v", g, wents)
+	}
+	if !reflect.DeepEqual(ents[2].Data, data) {
+		t.Errorf("the given entries are modified")
+	}
+	for i, e := range g[1:4] {
+		if isClusterEntry(e) != (i == 0) {
+			t.Errorf("#%d: isClusterEntry = %v, want %v", i, i != 0, i == 0)
+		}
+	}
+}
+
+func TestWitnessSnapshot(t *testing.T) {
+	st := store.New(StoreClusterPrefix, StoreKeysPrefix)
+	st.Set("/0/members/1/raftAttributes", false, `{"peerURLs":["http://a"]}`, store.Permanent)
+	st.Set("/0/version", false, "2.2.0", store.Permanent)
+	st.Set("/0/removed_members", true, "", store.Permanent)
+	st.Set("/1/foo", false, "bar", store.Permanent)
+	st.Set("/1/dir/_hidden", false, "bar", store.Permanent)
+	data, err := st.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap := witnessSnapshot(raftpb.Snapshot{Data: data, Metadata: raftpb.SnapshotMetadata{Index: 10, Term: 2}})
+	if snap.Metadata.Index != 10 || snap.Metadata.Term != 2 {
+		t.Errorf("metadata = %+v, want index 10 and term 2", snap.Metadata)
+	}
+	wst := store.New(StoreClusterPrefix, StoreKeysPrefix)
+	if err := wst.Recovery(snap.Data); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/0/members/1/raftAttributes", "/0/version"} {
+		e, err := wst.Get(p, false, false)
+		if err != nil {
+			t.Fatalf("get %s error: %v", p, err)
+		}
+		w, _ := st.Get(p, false, false)
+		if *e.Node.Value != *w.Node.Value {
+			t.Errorf("%s = %s, want %s", p, *e.Node.Value, *w.Node.Value)
+		}
+	}
+	if _, err := wst.Get("/0/removed_members", false, false); err != nil {
+		t.Errorf("get /0/removed_members error: %v", err)
+	}
+	for _, p := range []string{"/1/foo", "/1/dir"} {
+		if _, err := wst.Get(p, false, false); !isKeyNotFound(err) {
+			t.Errorf("get %s error = %v, want key not found", p, err)
+		}
+	}
+}
+
+func TestWitnessDo(t *testing.T) {
+	st := &storeRecorder{}
+	srv := &EtcdServer{
+		r:        raftNode{witness: true},
+		store:    st,
+		reqIDGen: idutil.NewGenerator(0, time.Time{}),
+	}
+	for i, r := range []pb.Request{
+		{Method: "GET", Path: "/1/foo"},
+		{Method: "PUT", Path: "/1/foo", Val: "bar"},
+		{Method: "GET", Path: "/1/foo", Wait: true},
+	} {
+		if _, err := srv.Do(context.Background(), r); err != ErrWitness {
+			t.Errorf("#%d: err = %v, want %v", i, err, ErrWitness)
+		}
+	}
+	if _, err := srv.Do(context.Background(), pb.Request{Method: "GET", Path: "/0/version"}); err != nil {
+		t.Fatal(err)
+	}
+	waction := []testutil.Action{{Name: "Get", Params: []interface{}{"/0/version", false, false}}}
+	if g := st.Action(); !reflect.DeepEqual(g, waction) {
+		t.Errorf("action = %+v, want %+v", g, waction)
+	}
+}
+
+func TestWitnessApply(t *testing.T) {
+	st := &storeRecorder{}
+	srv := &EtcdServer{
+		r:     raftNode{witness: true},
+		store: st,
+		w:     wait.New(),
+	}
+	ents := witnessEntries([]raftpb.Entry{
+		{Index: 1, Data: pbutil.MustMarshal(&pb.Request{ID: 1, Method: "PUT", Path: "/1/foo", Val: "bar"})},
+		{Index: 2, Data: pbutil.MustMarshal(&pb.Request{ID: 2, Method: "PUT", Path: "/0/foo", Val: "bar"})},
+	})
+	if applied, _ := srv.apply(ents, &raftpb.ConfState{}); applied != 2 {
+		t.Errorf("applied = %d, want 2", applied)
+	}
+	waction := []testutil.Action{{Name: "Set", Params: []interface{}{"/0/foo", false, "bar", time.Time{}}}}
+	if g := st.Action(); !reflect.DeepEqual(g, waction) {
+		t.Errorf("action = %+v, want %+v", g, waction)
+	}
+}
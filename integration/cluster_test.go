@@ -16,6 +16,7 @@ package integration
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
@@ -173,6 +174,65 @@ func testDecreaseClusterSize(t *testing.T, size int) {
 	clusterMustProgress(t, c.Members)
 }
 
+func TestReplaceMember(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 3)
+	c.Launch(t)
+	defer c.Terminate(t)
+
+	c.ReplaceMember(t, uint64(c.Members[len(c.Members)-1].s.ID()))
+	c.waitLeader(t, c.Members)
+	clusterMustProgress(t, c.Members)
+}
+
+// TestWitnessMember tests that a witness member makes a quorum with the other
+// members while it does not serve the key space, even after a restart.
+func TestWitnessMember(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 2)
+	c.Launch(t)
+	defer c.Terminate(t)
+
+	c.AddWitnessMember(t)
+	c.waitLeader(t, c.Members)
+	w := c.Members[2]
+	mustNotServeKeys(t, w)
+
+	// stop the leader: the other member is elected with the vote of the
+	// witness, and commits with its acknowledgments.
+	lead, other := c.Members[0], c.Members[1]
+	if lead.s.Lead() != uint64(lead.s.ID()) {
+		lead, other = other, lead
+	}
+	lead.Stop(t)
+	c.waitLeader(t, []*member{other, w})
+	if w.s.Lead() == uint64(w.s.ID()) {
+		t.Fatalf("witness %s is leader", w.s.ID())
+	}
+	clusterMustProgress(t, []*member{other})
+	if err := lead.Restart(t); err != nil {
+		t.Fatal(err)
+	}
+
+	w.Stop(t)
+	if err := w.Restart(t); err != nil {
+		t.Fatal(err)
+	}
+	c.waitLeader(t, c.Members)
+	mustNotServeKeys(t, w)
+	clusterMustProgress(t, c.Members[:2])
+}
+
+func mustNotServeKeys(t *testing.T, m *member) {
+	cc := mustNewHTTPClient(t, []string{m.URL()})
+	kapi := client.NewKeysAPI(cc)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	if _, err := kapi.Get(ctx, "/foo", nil); err == nil {
+		t.Fatalf("get on witness %s succeeded, want error", m.URL())
+	}
+	cancel()
+}
+
 func TestForceNewCluster(t *testing.T) {
 	c := NewCluster(t, 3)
 	c.Launch(t)
@@ -310,6 +370,54 @@ func TestIssue2904(t *testing.T) {
 	c.waitMembersMatch(t, c.HTTPMembers())
 }
 
+// TestStreamWatcher ensures that a streaming watcher receives the
+// successive events over its stream, in order.
+func TestStreamWatcher(t *testing.T) {
+	defer afterTest(t)
+	c := NewCluster(t, 1)
+	c.Launch(t)
+	defer c.Terminate(t)
+
+	kapi := client.NewKeysAPI(mustNewHTTPClient(t, []string{c.Members[0].URL()}))
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	resp, err := kapi.Set(ctx, "/foo/a", "bar", nil)
+	cancel()
+	if err != nil {
+		t.Fatalf("unexpected set error: %v", err)
+	}
+
+	w := kapi.Watcher("/foo", &client.WatcherOptions{AfterIndex: resp.Node.ModifiedIndex, Recursive: true, Stream: true})
+	keys := []string{"/foo/b", "/foo/c", "/foo/d"}
+	for _, key := range keys {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		_, err := kapi.Set(ctx, key, "bar", nil)
+		cancel()
+		if err != nil {
+			t.Fatalf("unexpected set error: %v", err)
+		}
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	for i, key := range keys {
+		resp, err := w.Next(ctx)
+		if err != nil {
+			t.Fatalf("#%d: unexpected watch error: %v", i, err)
+		}
+		if resp.Action != "set" || resp.Node.Key != key {
+			t.Errorf("#%d: event = %s %s, want set %s", i, resp.Action, resp.Node.Key, key)
+		}
+	}
+
+	// closing the watcher closes its stream
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Next(ctx); err != client.ErrWatcherClosed {
+		t.Errorf("err = %v, want %v", err, client.ErrWatcherClosed)
+	}
+}
+
 // clusterMustProgress ensures that cluster can make progress. It creates
 // a random key first, and check the new key could be got from all client urls
 // of the cluster.
@@ -449,6 +557,7 @@ func (c *cluster) HTTPMembers() []client.Member {
 			scheme = "https"
 		}
 		ms[i].Name = m.Name
+		ms[i].IsWitness = m.witness
 		for _, ln := range m.PeerListeners {
 			ms[i].PeerURLs = append(ms[i].PeerURLs, scheme+"://"+ln.Addr().String())
 		}
@@ -459,8 +568,9 @@ func (c *cluster) HTTPMembers() []client.Member {
 	return ms
 }
 
-func (c *cluster) addMember(t *testing.T, usePeerTLS bool) {
+func (c *cluster) addMember(t *testing.T, usePeerTLS, witness bool) {
 	m := mustNewMember(t, c.name(rand.Int()), usePeerTLS)
+	m.witness = witness
 	scheme := "http"
 	if usePeerTLS {
 		scheme = "https"
@@ -471,13 +581,17 @@ func (c *cluster) addMember(t *testing.T, usePeerTLS bool) {
 	ma := client.NewMembersAPI(cc)
 	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
 	peerURL := scheme + "://" + m.PeerListeners[0].Addr().String()
-	if _, err := ma.Add(ctx, peerURL); err != nil {
+	add := ma.Add
+	if witness {
+		add = ma.AddWitness
+	}
+	if _, err := add(ctx, peerURL); err != nil {
 		t.Fatalf("add member on %s error: %v", c.URL(0), err)
 	}
 	cancel()
 
 	// wait for the add node entry applied in the cluster
-	members := append(c.HTTPMembers(), client.Member{PeerURLs: []string{peerURL}, ClientURLs: []string{}})
+	members := append(c.HTTPMembers(), client.Member{PeerURLs: []string{peerURL}, ClientURLs: []string{}, IsWitness: witness})
 	c.waitMembersMatch(t, members)
 
 	m.InitialPeerURLsMap = types.URLsMap{}
@@ -495,11 +609,15 @@ func (c *cluster) addMember(t *testing.T, usePeerTLS bool) {
 }
 
 func (c *cluster) AddMember(t *testing.T) {
-	c.addMember(t, false)
+	c.addMember(t, false, false)
 }
 
 func (c *cluster) AddTLSMember(t *testing.T) {
-	c.addMember(t, true)
+	c.addMember(t, true, false)
+}
+
+func (c *cluster) AddWitnessMember(t *testing.T) {
+	c.addMember(t, false, true)
 }
 
 func (c *cluster) RemoveMember(t *testing.T, id uint64) {
@@ -531,6 +649,52 @@ func (c *cluster) RemoveMember(t *testing.T, id uint64) {
 	c.waitMembersMatch(t, c.HTTPMembers())
 }
 
+// ReplaceMember replaces the member with the given id by a new one in
+// a single membership change.
+func (c *cluster) ReplaceMember(t *testing.T, id uint64) {
+	m := mustNewMember(t, c.name(rand.Int()), false)
+	peerURL := "http://" + m.PeerListeners[0].Addr().String()
+
+	// send replace request to the cluster
+	cc := mustNewHTTPClient(t, c.URLs())
+	ma := client.NewMembersAPI(cc)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	if _, err := ma.Replace(ctx, []string{types.ID(id).String()}, []string{peerURL}); err != nil {
+		t.Fatalf("unexpected replace error %v", err)
+	}
+	cancel()
+
+	newMembers := make([]*member, 0)
+	for _, mm := range c.Members {
+		if uint64(mm.s.ID()) != id {
+			newMembers = append(newMembers, mm)
+			continue
+		}
+		select {
+		case <-mm.s.StopNotify():
+			mm.Terminate(t)
+		case <-time.After(time.Second + time.Duration(electionTicks)*tickDuration + time.Second + rafthttp.ConnWriteTimeout):
+			t.Fatalf("failed to remove member %s in time", mm.s.ID())
+		}
+	}
+	c.Members = newMembers
+	members := append(c.HTTPMembers(), client.Member{PeerURLs: []string{peerURL}, ClientURLs: []string{}})
+	c.waitMembersMatch(t, members)
+
+	m.InitialPeerURLsMap = types.URLsMap{}
+	for _, mm := range c.Members {
+		m.InitialPeerURLsMap[mm.Name] = mm.PeerURLs
+	}
+	m.InitialPeerURLsMap[m.Name] = m.PeerURLs
+	m.NewCluster = false
+	if err := m.Launch(); err != nil {
+		t.Fatal(err)
+	}
+	c.Members = append(c.Members, m)
+	// wait cluster to be stable to receive future client requests
+	c.waitMembersMatch(t, c.HTTPMembers())
+}
+
 func (c *cluster) Terminate(t *testing.T) {
 	for _, m := range c.Members {
 		m.Terminate(t)
@@ -632,6 +796,8 @@ type member struct {
 	PeerListeners, ClientListeners []net.Listener
 	// inited PeerTLSInfo implies to enable peer TLS
 	PeerTLSInfo transport.TLSInfo
+	// witness is set if the member was added as a witness
+	witness bool
 
 	raftHandler *testutil.PauseableHandler
 	s           *etcdserver.EtcdServer
@@ -15,14 +15,23 @@
 package integration
 
 import (
+	"archive/tar"
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	"github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/store"
+	"github.com/coreos/etcd/wal"
+	"github.com/coreos/etcd/wal/walpb"
 )
 
 func TestPauseMember(t *testing.T) {
@@ -120,3 +129,162 @@ func TestSnapshotAndRestartMember(t *testing.T) {
 		}
 	}
 }
+
+// TestSnapshotDeltasAndRestartMember ensures that a member saves delta
+// snapshots once the cluster version allows them, and recovers its store
+// from the chain of snapshots when restarted.
+func TestSnapshotDeltasAndRestartMember(t *testing.T) {
+	defer afterTest(t)
+	m := mustNewMember(t, "snapDeltasAndRestartTest", false)
+	m.SnapCount = 10
+	m.SnapDeltas = 2
+	m.Launch()
+	defer m.Terminate(t)
+	m.WaitOK(t)
+
+	waitClusterVersion(t, m, "2.2.0")
+
+	cc := mustNewHTTPClient(t, []string{m.URL()})
+	kapi := client.NewKeysAPI(cc)
+	for i := 0; i < 60; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		key := fmt.Sprintf("/foo%d", i)
+		_, err := kapi.Create(ctx, key, "bar")
+		if err == nil && i%3 == 0 {
+			_, err = kapi.Delete(ctx, fmt.Sprintf("/foo%d", i/3), nil)
+		}
+		cancel()
+		if err != nil {
+			t.Fatalf("#%d: write on %s error: %v", i, m.URL(), err)
+		}
+	}
+	m.Stop(t)
+
+	names, err := ioutil.ReadDir(m.SnapDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deltas := 0
+	for _, fi := range names {
+		if path.Ext(fi.Name()) != ".snap" {
+			continue
+		}
+		snapshot, err := snap.Read(path.Join(m.SnapDir(), fi.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if store.IsDeltaSnapshot(snapshot.Data) {
+			deltas++
+		}
+	}
+	if deltas == 0 {
+		t.Fatalf("no delta snapshot saved in %s", m.SnapDir())
+	}
+
+	m.Restart(t)
+	cc = mustNewHTTPClient(t, []string{m.URL()})
+	kapi = client.NewKeysAPI(cc)
+	for i := 0; i < 60; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		key := fmt.Sprintf("/foo%d", i)
+		_, err := kapi.Get(ctx, key, nil)
+		cancel()
+		// the keys /foo0 to /foo19 are deleted.
+		deleted := i < 20
+		switch {
+		case deleted && !isKeyNotFound(err):
+			t.Errorf("#%d: get %s error = %v, want key not found", i, key, err)
+		case !deleted && err != nil:
+			t.Errorf("#%d: get %s error: %v", i, key, err)
+		}
+	}
+}
+
+// TestBackupMember ensures that the backup of a member holds the files of
+// its snapshots and its wal files, which can be read as a data dir.
+func TestBackupMember(t *testing.T) {
+	defer afterTest(t)
+	m := mustNewMember(t, "backupTest", false)
+	m.SnapCount = 10
+	m.SnapDeltas = 2
+	m.Launch()
+	defer m.Terminate(t)
+	m.WaitOK(t)
+	waitClusterVersion(t, m, "2.2.0")
+
+	cc := mustNewHTTPClient(t, []string{m.URL()})
+	kapi := client.NewKeysAPI(cc)
+	for i := 0; i < 30; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		_, err := kapi.Create(ctx, fmt.Sprintf("/foo%d", i), "bar")
+		cancel()
+		if err != nil {
+			t.Fatalf("#%d: create on %s error: %v", i, m.URL(), err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := m.s.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir(os.TempDir(), "etcd-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := path.Join(dir, hdr.Name)
+		if err := os.MkdirAll(path.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot, err := snap.New(path.Join(dir, "member", "snap")).LoadChain(store.IsDeltaSnapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.New().Recovery(snapshot.Data); err != nil {
+		t.Fatal(err)
+	}
+	walsnap := walpb.Snapshot{Index: snapshot.Metadata.Index, Term: snapshot.Metadata.Term}
+	w, err := wal.OpenForRead(path.Join(dir, "member", "wal"), walsnap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, _, _, err := w.ReadAll(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitClusterVersion(t *testing.T, m *member, ver string) {
+	for i := 0; ; i++ {
+		if v := m.s.ClusterVersion(); v != nil && v.String() == ver {
+			return
+		}
+		if i == 100 {
+			t.Fatalf("cluster version = %v, want %s", m.s.ClusterVersion(), ver)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func isKeyNotFound(err error) bool {
+	cerr, ok := err.(client.Error)
+	return ok && cerr.Code == client.ErrorCodeKeyNotFound
+}
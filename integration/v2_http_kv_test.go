@@ -324,6 +324,161 @@ func TestV2CAS(t *testing.T) {
 	}
 }
 
+func TestV2Txn(t *testing.T) {
+	cl := NewCluster(t, 1)
+	cl.Launch(t)
+	defer cl.Terminate(t)
+
+	u := cl.URL(0)
+	tc := NewTestClient()
+
+	v := url.Values{}
+	v.Set("value", "XXX")
+	resp, err := tc.PutForm(fmt.Sprintf("%s%s", u, "/v2/keys/txn/foo"), v)
+	if err != nil {
+		t.Fatalf("put err = %v, want nil", err)
+	}
+	resp.Body.Close()
+
+	tests := []struct {
+		body    string
+		wStatus int
+		w       map[string]interface{}
+	}{
+		{
+			`{"compares":[{"key":"/txn/foo","prevValue":"XXX"},{"key":"/txn/bar","prevExist":false}],` +
+				`"ops":[{"action":"set","key":"/txn/foo","value":"YYY"},{"action":"set","key":"/txn/bar","value":"YYY"}]}`,
+			http.StatusOK,
+			map[string]interface{}{
+				"action": "txn",
+			},
+		},
+		{
+			`{"compares":[{"key":"/txn/foo","prevValue":"XXX"}],"ops":[{"action":"delete","key":"/txn/bar"}]}`,
+			http.StatusPreconditionFailed,
+			map[string]interface{}{
+				"errorCode": float64(101),
+				"message":   "Compare failed",
+				"cause":     "/txn/foo [XXX != YYY]",
+			},
+		},
+		{
+			`{"ops":[{"action":"delete","key":"/txn/nokey"},{"action":"delete","key":"/txn/bar"}]}`,
+			http.StatusNotFound,
+			map[string]interface{}{
+				"errorCode": float64(100),
+				"cause":     "/txn/nokey",
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		resp, err := tc.Post(fmt.Sprintf("%s%s", u, "/v2/txn"), "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("#%d: post err = %v, want nil", i, err)
+		}
+		if resp.StatusCode != tt.wStatus {
+			t.Errorf("#%d: status = %d, want %d", i, resp.StatusCode, tt.wStatus)
+		}
+		if err := checkBody(tc.ReadBodyJSON(resp), tt.w); err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+
+	// both keys were set at the same index, and the failed txns changed nothing
+	var idx []interface{}
+	for _, key := range []string{"/v2/keys/txn/foo", "/v2/keys/txn/bar"} {
+		resp, err := tc.Get(fmt.Sprintf("%s%s", u, key))
+		if err != nil {
+			t.Fatalf("get err = %v, want nil", err)
+		}
+		body := tc.ReadBodyJSON(resp)
+		n, ok := body["node"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: body = %v, want a node", key, body)
+		}
+		if n["value"] != "YYY" {
+			t.Errorf("%s: value = %v, want YYY", key, n["value"])
+		}
+		idx = append(idx, n["modifiedIndex"])
+	}
+	if idx[0] != idx[1] {
+		t.Errorf("modifiedIndex = %v and %v, want equal", idx[0], idx[1])
+	}
+}
+
+func TestV2Refresh(t *testing.T) {
+	cl := NewCluster(t, 1)
+	cl.Launch(t)
+	defer cl.Terminate(t)
+
+	u := cl.URL(0)
+	tc := NewTestClient()
+
+	v := url.Values{}
+	v.Set("value", "XXX")
+	v.Set("ttl", "100")
+	resp, err := tc.PutForm(fmt.Sprintf("%s%s", u, "/v2/keys/refresh/foo"), v)
+	if err != nil {
+		t.Fatalf("put err = %v, want nil", err)
+	}
+	resp.Body.Close()
+
+	tests := []struct {
+		key     string
+		vals    url.Values
+		wStatus int
+		w       map[string]interface{}
+	}{
+		{
+			"/v2/keys/refresh/foo",
+			url.Values(map[string][]string{"ttl": {"200"}, "refresh": {"true"}}),
+			http.StatusOK,
+			map[string]interface{}{
+				"action": "refresh",
+				"node": map[string]interface{}{
+					"value":         "XXX",
+					"ttl":           float64(200),
+					"modifiedIndex": float64(4),
+				},
+				"prevNode": map[string]interface{}{
+					"value":         "XXX",
+					"modifiedIndex": float64(4),
+				},
+			},
+		},
+		{
+			"/v2/keys/refresh/foo",
+			url.Values(map[string][]string{"ttl": {"200"}, "refresh": {"true"}, "value": {"YYY"}}),
+			http.StatusBadRequest,
+			map[string]interface{}{
+				"errorCode": float64(211),
+			},
+		},
+		{
+			"/v2/keys/refresh/bar",
+			url.Values(map[string][]string{"ttl": {"200"}, "refresh": {"true"}}),
+			http.StatusNotFound,
+			map[string]interface{}{
+				"errorCode": float64(100),
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		resp, err := tc.PutForm(fmt.Sprintf("%s%s", u, tt.key), tt.vals)
+		if err != nil {
+			t.Fatalf("#%d: put err = %v, want nil", i, err)
+		}
+		if resp.StatusCode != tt.wStatus {
+			t.Errorf("#%d: status = %d, want %d", i, resp.StatusCode, tt.wStatus)
+		}
+		if err := checkBody(tc.ReadBodyJSON(resp), tt.w); err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+}
+
 func TestV2Delete(t *testing.T) {
 	cl := NewCluster(t, 1)
 	cl.Launch(t)
@@ -685,6 +840,98 @@ func TestV2Get(t *testing.T) {
 	}
 }
 
+func TestV2GetPage(t *testing.T) {
+	cl := NewCluster(t, 1)
+	cl.Launch(t)
+	defer cl.Terminate(t)
+
+	u := cl.URL(0)
+	tc := NewTestClient()
+
+	for _, key := range []string{"a", "b", "c"} {
+		v := url.Values{}
+		v.Set("value", "XXX")
+		r, err := tc.PutForm(fmt.Sprintf("%s%s", u, "/v2/keys/page/"+key), v)
+		if err != nil {
+			t.Error(err)
+		}
+		r.Body.Close()
+	}
+
+	tests := []struct {
+		relativeURL string
+		wStatus     int
+		w           map[string]interface{}
+	}{
+		{
+			"/v2/keys/page?limit=2",
+			http.StatusOK,
+			map[string]interface{}{
+				"node": map[string]interface{}{
+					"key": "/page",
+					"dir": true,
+					"nodes": []interface{}{
+						map[string]interface{}{
+							"key":           "/page/a",
+							"value":         "XXX",
+							"createdIndex":  float64(4),
+							"modifiedIndex": float64(4),
+						},
+						map[string]interface{}{
+							"key":           "/page/b",
+							"value":         "XXX",
+							"createdIndex":  float64(5),
+							"modifiedIndex": float64(5),
+						},
+					},
+				},
+				"action": "get",
+				"next":   "/page/c",
+			},
+		},
+		{
+			"/v2/keys/page?limit=2&startKey=/page/c&quorum=true",
+			http.StatusOK,
+			map[string]interface{}{
+				"node": map[string]interface{}{
+					"key": "/page",
+					"dir": true,
+					"nodes": []interface{}{
+						map[string]interface{}{
+							"key":           "/page/c",
+							"value":         "XXX",
+							"createdIndex":  float64(6),
+							"modifiedIndex": float64(6),
+						},
+					},
+				},
+				"action": "get",
+				"next":   nil,
+			},
+		},
+		{
+			"/v2/keys/page?limit=garbage",
+			http.StatusBadRequest,
+			map[string]interface{}{
+				"errorCode": float64(209),
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		resp, err := tc.Get(fmt.Sprintf("%s%s", u, tt.relativeURL))
+		if err != nil {
+			t.Fatalf("#%d: get err = %v, want nil", i, err)
+		}
+		if resp.StatusCode != tt.wStatus {
+			t.Errorf("#%d: status = %d, want %d", i, resp.StatusCode, tt.wStatus)
+		}
+		if err := checkBody(tc.ReadBodyJSON(resp), tt.w); err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+}
+
 func TestV2QuorumGet(t *testing.T) {
 	cl := NewCluster(t, 1)
 	cl.Launch(t)
@@ -819,6 +1066,58 @@ func TestV2Watch(t *testing.T) {
 	}
 }
 
+func TestV2WatchFilter(t *testing.T) {
+	cl := NewCluster(t, 1)
+	cl.Launch(t)
+	defer cl.Terminate(t)
+
+	u := cl.URL(0)
+	tc := NewTestClient()
+
+	for _, key := range []string{"/v2/keys/foo/a/status", "/v2/keys/foo/b/health", "/v2/keys/foo/b/health"} {
+		v := url.Values{}
+		v.Set("value", "XXX")
+		resp, err := tc.PutForm(fmt.Sprintf("%s%s", u, key), v)
+		if err != nil {
+			t.Fatalf("put err = %v, want nil", err)
+		}
+		resp.Body.Close()
+	}
+
+	tests := []struct {
+		query  string
+		wIndex float64
+		wPrev  bool
+	}{
+		{"waitIndex=4&filterKey=*/health", 5, false},
+		{"waitIndex=4&filterAction=set&filterKey=b/*", 5, false},
+		{"waitIndex=4&filterKey=*/health&omitValue=true", 5, false},
+		{"waitIndex=6&filterKey=*/health", 6, true},
+		{"waitIndex=6&filterKey=*/health&omitPrevNode=true", 6, false},
+	}
+	for i, tt := range tests {
+		q := "wait=true&recursive=true&" + tt.query
+		resp, err := tc.Get(fmt.Sprintf("%s%s?%s", u, "/v2/keys/foo", q))
+		if err != nil {
+			t.Fatalf("#%d: watch err = %v, want nil", i, err)
+		}
+		body := tc.ReadBodyJSON(resp)
+		n, ok := body["node"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("#%d: body = %v, want a node", i, body)
+		}
+		if n["key"] != "/foo/b/health" || n["modifiedIndex"] != tt.wIndex {
+			t.Errorf("#%d: node = %v, want /foo/b/health at %v", i, n, tt.wIndex)
+		}
+		if _, ok := n["value"]; ok == strings.Contains(tt.query, "omitValue") {
+			t.Errorf("#%d: node = %v, want value omitted = %v", i, n, !ok)
+		}
+		if _, ok := body["prevNode"]; ok != tt.wPrev {
+			t.Errorf("#%d: prevNode present = %v, want %v", i, ok, tt.wPrev)
+		}
+	}
+}
+
 func TestV2WatchWithIndex(t *testing.T) {
 	cl := NewCluster(t, 1)
 	cl.Launch(t)
@@ -0,0 +1,23 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encryption implements the envelope encryption of the data etcd
+// writes to disk.
+//
+// Each file is encrypted with its own random data key, using AES-256-GCM.
+// The data key is itself encrypted, or wrapped, with a key-encryption key
+// held by a KeyProvider, and is stored wrapped in a Header alongside the
+// data. Rotating the key-encryption key only requires to rewrap the data
+// keys, leaving the encrypted data untouched.
+package encryption
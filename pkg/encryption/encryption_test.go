@@ -0,0 +1,153 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func newTestKeyProvider(t *testing.T, dir string, hexEncoded bool) KeyProvider {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := key
+	if hexEncoded {
+		b = []byte(hex.EncodeToString(key) + "\n")
+	}
+	f, err := ioutil.TempFile(dir, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	kp, err := NewKeyProvider("file:" + f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+func TestSealOpen(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "encryptiontest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, hexEncoded := range []bool{false, true} {
+		kp := newTestKeyProvider(t, dir, hexEncoded)
+		plain := []byte("some secret data")
+		sealed, err := Seal(kp, plain)
+		if err != nil {
+			t.Fatalf("#%d: err = %v", i, err)
+		}
+		if !IsSealed(sealed) {
+			t.Errorf("#%d: sealed data is not detected", i)
+		}
+		if bytes.Contains(sealed, plain) {
+			t.Errorf("#%d: sealed data contains the plaintext", i)
+		}
+		g, err := Open(kp, sealed)
+		if err != nil {
+			t.Fatalf("#%d: err = %v", i, err)
+		}
+		if !bytes.Equal(g, plain) {
+			t.Errorf("#%d: data = %q, want %q", i, g, plain)
+		}
+
+		// another key-encryption key cannot unwrap the data key.
+		if _, err := Open(newTestKeyProvider(t, dir, hexEncoded), sealed); err != ErrUnknownKey {
+			t.Errorf("#%d: err = %v, want %v", i, err, ErrUnknownKey)
+		}
+		// tampered data is not authentic.
+		sealed[len(sealed)-1] ^= 1
+		if _, err := Open(kp, sealed); err != ErrDecrypt {
+			t.Errorf("#%d: err = %v, want %v", i, err, ErrDecrypt)
+		}
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "encryptiontest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	from, to := newTestKeyProvider(t, dir, false), newTestKeyProvider(t, dir, false)
+	plain := []byte("some secret data")
+	sealed, err := Seal(from, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrapped, err := Rewrap(sealed, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(from, rewrapped); err != ErrUnknownKey {
+		t.Errorf("err = %v, want %v", err, ErrUnknownKey)
+	}
+	g, err := Open(to, rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(g, plain) {
+		t.Errorf("data = %q, want %q", g, plain)
+	}
+}
+
+type fakeKeyProvider struct{ KeyProvider }
+
+func TestNewKeyProvider(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "encryptiontest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	short := path.Join(dir, "short")
+	if err := ioutil.WriteFile(short, []byte("0123"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterProvider("fake", func(arg string) (KeyProvider, error) { return &fakeKeyProvider{}, nil })
+	tests := []struct {
+		spec  string
+		wok   bool
+		wfake bool
+	}{
+		{"fake:arg", true, true},
+		{"file:" + short, false, false},
+		{"file:" + path.Join(dir, "missing"), false, false},
+		{"unknown:arg", false, false},
+		{"noarg", false, false},
+	}
+	for i, tt := range tests {
+		kp, err := NewKeyProvider(tt.spec)
+		if (err == nil) != tt.wok {
+			t.Errorf("#%d: err = %v, want ok %t", i, err, tt.wok)
+		}
+		if _, ok := kp.(*fakeKeyProvider); ok != tt.wfake {
+			t.Errorf("#%d: provider = %T", i, kp)
+		}
+	}
+}
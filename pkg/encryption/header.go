@@ -0,0 +1,175 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// magic starts a marshaled Header. Its leading zero byte never starts a
+// JSON document nor a bolt page, so sealed data is told apart from
+// plaintext.
+var magic = []byte("\x00etcdenc")
+
+const version = 1
+
+var ErrInvalidHeader = errors.New("encryption: invalid header")
+
+// Header holds the data key of a file, wrapped with the key-encryption key
+// of ID KeyID.
+type Header struct {
+	KeyID      string
+	WrappedKey []byte
+}
+
+// NewDataKey generates a random data key, and returns it along with its
+// Header, wrapped by the given KeyProvider.
+func NewDataKey(kp KeyProvider) (*DataKey, *Header, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, err
+	}
+	wk, err := kp.WrapKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &DataKey{aead: aead}, &Header{KeyID: kp.KeyID(), WrappedKey: wk}, nil
+}
+
+// DataKey unwraps the data key of the header with the given KeyProvider.
+func (h *Header) DataKey(kp KeyProvider) (*DataKey, error) {
+	key, err := kp.UnwrapKey(h.KeyID, h.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{aead: aead}, nil
+}
+
+// Rewrap wraps the data key of the header, unwrapped by from, with the
+// key-encryption key of to.
+func (h *Header) Rewrap(from, to KeyProvider) error {
+	key, err := from.UnwrapKey(h.KeyID, h.WrappedKey)
+	if err != nil {
+		return err
+	}
+	wk, err := to.WrapKey(key)
+	if err != nil {
+		return err
+	}
+	h.KeyID, h.WrappedKey = to.KeyID(), wk
+	return nil
+}
+
+// Marshal returns the binary encoding of the header.
+func (h *Header) Marshal() []byte {
+	var buf bytes.Buffer
+	buf.Write(magic)
+	buf.WriteByte(version)
+	writeBytes(&buf, []byte(h.KeyID))
+	writeBytes(&buf, h.WrappedKey)
+	return buf.Bytes()
+}
+
+// UnmarshalHeader decodes the header at the start of b, and returns it
+// along with the rest of b.
+func UnmarshalHeader(b []byte) (*Header, []byte, error) {
+	if !IsSealed(b) || len(b) < len(magic)+1 || b[len(magic)] != version {
+		return nil, nil, ErrInvalidHeader
+	}
+	b = b[len(magic)+1:]
+	id, b, err := readBytes(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	wk, b, err := readBytes(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Header{KeyID: string(id), WrappedKey: wk}, b, nil
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	var lb [2]byte
+	binary.BigEndian.PutUint16(lb[:], uint16(len(b)))
+	buf.Write(lb[:])
+	buf.Write(b)
+}
+
+func readBytes(b []byte) ([]byte, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, ErrInvalidHeader
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return nil, nil, ErrInvalidHeader
+	}
+	return b[2 : 2+n], b[2+n:], nil
+}
+
+// IsSealed returns true if b starts like the data returned by Seal, or a
+// marshaled Header.
+func IsSealed(b []byte) bool {
+	return bytes.HasPrefix(b, magic)
+}
+
+// Seal encrypts the plaintext with a new data key, and returns it
+// preceded by the Header of the data key.
+func Seal(kp KeyProvider, plaintext []byte) ([]byte, error) {
+	dk, h, err := NewDataKey(kp)
+	if err != nil {
+		return nil, err
+	}
+	// the header is not authenticated along the data, as Rewrap changes
+	// it. A tampered header fails to unwrap the data key.
+	return append(h.Marshal(), dk.Seal(plaintext, nil)...), nil
+}
+
+// Open decrypts the data returned by Seal.
+func Open(kp KeyProvider, sealed []byte) ([]byte, error) {
+	h, rest, err := UnmarshalHeader(sealed)
+	if err != nil {
+		return nil, err
+	}
+	dk, err := h.DataKey(kp)
+	if err != nil {
+		return nil, err
+	}
+	return dk.Open(rest, nil)
+}
+
+// Rewrap returns the data returned by Seal with its data key, unwrapped by
+// from, rewrapped with the key-encryption key of to.
+func Rewrap(sealed []byte, from, to KeyProvider) ([]byte, error) {
+	h, rest, err := UnmarshalHeader(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.Rewrap(from, to); err != nil {
+		return nil, err
+	}
+	return append(h.Marshal(), rest...), nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+const keySize = 32
+
+var (
+	ErrUnknownKey = errors.New("encryption: unknown key-encryption key")
+	ErrDecrypt    = errors.New("encryption: message authentication failed")
+)
+
+// KeyProvider holds the key-encryption keys, and wraps and unwraps the data
+// keys with them.
+type KeyProvider interface {
+	// KeyID returns the ID of the key-encryption key the new data keys are
+	// wrapped with.
+	KeyID() string
+	// WrapKey wraps the given data key with the key-encryption key of ID
+	// KeyID.
+	WrapKey(dk []byte) ([]byte, error)
+	// UnwrapKey unwraps the given data key, wrapped with the key-encryption
+	// key of the given ID. It returns ErrUnknownKey if the provider does not
+	// hold the key.
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// ProviderFunc returns the KeyProvider configured by the given argument.
+type ProviderFunc func(arg string) (KeyProvider, error)
+
+var (
+	providersMu sync.Mutex
+	providers   = map[string]ProviderFunc{
+		"file": func(arg string) (KeyProvider, error) { return NewFileKeyProvider(arg) },
+	}
+)
+
+// RegisterProvider makes the KeyProvider returned by f available to
+// NewKeyProvider under the given name, e.g. to use a KMS.
+func RegisterProvider(name string, f ProviderFunc) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = f
+}
+
+// NewKeyProvider returns the KeyProvider described by the given spec, of
+// the form "name:arg", where name is a registered provider. The "file"
+// provider is always registered, and takes the path of a key file.
+func NewKeyProvider(spec string) (KeyProvider, error) {
+	i := strings.Index(spec, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("encryption: invalid key provider %q, want name:arg", spec)
+	}
+	providersMu.Lock()
+	f, ok := providers[spec[:i]]
+	providersMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("encryption: unknown key provider %q", spec[:i])
+	}
+	return f(spec[i+1:])
+}
+
+// fileKeyProvider holds a single key-encryption key read from a file.
+type fileKeyProvider struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewFileKeyProvider returns a KeyProvider holding the key-encryption key
+// stored in the given file, either as 32 raw bytes or as 64 hexadecimal
+// digits. The ID of the key is derived from the key itself.
+func NewFileKeyProvider(path string) (KeyProvider, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := b
+	if len(b) != keySize {
+		if key, err = hex.DecodeString(strings.TrimSpace(string(b))); err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("encryption: %s does not hold a %d-byte key", path, keySize)
+		}
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &fileKeyProvider{id: hex.EncodeToString(sum[:8]), aead: aead}, nil
+}
+
+func (p *fileKeyProvider) KeyID() string { return p.id }
+
+func (p *fileKeyProvider) WrapKey(dk []byte) ([]byte, error) {
+	return seal(p.aead, dk, []byte(p.id)), nil
+}
+
+func (p *fileKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != p.id {
+		return nil, ErrUnknownKey
+	}
+	return open(p.aead, wrapped, []byte(p.id))
+}
+
+// GenerateKey returns a random key, usable as a key-encryption key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DataKey encrypts and authenticates the data of a file.
+type DataKey struct {
+	aead cipher.AEAD
+}
+
+// Seal encrypts and authenticates the plaintext and authenticates the
+// additional data, and returns the result.
+func (k *DataKey) Seal(plaintext, additional []byte) []byte {
+	return seal(k.aead, plaintext, additional)
+}
+
+// Open authenticates and decrypts the data returned by Seal with the same
+// additional data. It returns ErrDecrypt if the data is not authentic.
+func (k *DataKey) Open(sealed, additional []byte) ([]byte, error) {
+	return open(k.aead, sealed, additional)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the random nonce followed by the sealed plaintext.
+func seal(aead cipher.AEAD, plaintext, additional []byte) []byte {
+	ns := aead.NonceSize()
+	b := make([]byte, ns, ns+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(fmt.Sprintf("encryption: cannot read random nonce (%v)", err))
+	}
+	return aead.Seal(b, b, plaintext, additional)
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	ns := aead.NonceSize()
+	if len(sealed) < ns {
+		return nil, ErrDecrypt
+	}
+	b, err := aead.Open(nil, sealed[:ns], sealed[ns:], additional)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return b, nil
+}
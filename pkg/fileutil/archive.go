@@ -0,0 +1,128 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileutil
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Archiver archives the files purged, before they are removed.
+type Archiver interface {
+	// Archive archives the file at p. The file is removed afterwards, if it
+	// still exists.
+	Archive(p string) error
+}
+
+// ArchiveError is the error of an Archiver failing to archive a file.
+type ArchiveError struct {
+	Path string
+	Err  error
+}
+
+func (e *ArchiveError) Error() string {
+	return fmt.Sprintf("failed to archive file %s (%v)", e.Path, e.Err)
+}
+
+// RetireFile archives the file at p with a, if a is not nil, and removes it.
+// If the file fails to be archived, an *ArchiveError is returned and the file
+// is not removed.
+func RetireFile(p string, a Archiver) error {
+	kind := strings.TrimPrefix(path.Ext(p), ".")
+	if a != nil {
+		if err := a.Archive(p); err != nil {
+			archiveFailures.WithLabelValues(kind).Inc()
+			return &ArchiveError{Path: p, Err: err}
+		}
+		archivedFiles.WithLabelValues(kind).Inc()
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	purgedFiles.WithLabelValues(kind).Inc()
+	return nil
+}
+
+type dirArchiver struct {
+	dir      string
+	compress bool
+}
+
+// NewDirArchiver returns an Archiver which moves the files to dir, creating
+// it if needed. If compress is true, the files are compressed with gzip
+// instead, and ".gz" is appended to their name.
+func NewDirArchiver(dir string, compress bool) Archiver {
+	return &dirArchiver{dir: dir, compress: compress}
+}
+
+func (a *dirArchiver) Archive(p string) error {
+	if err := os.MkdirAll(a.dir, 0700); err != nil {
+		return err
+	}
+	dst := path.Join(a.dir, path.Base(p))
+	if !a.compress {
+		if err := os.Rename(p, dst); err == nil {
+			return nil
+		}
+		// the archive directory may be on another device.
+	} else {
+		dst += ".gz"
+	}
+	return a.copy(p, dst)
+}
+
+// copy copies the file at src to dst, through a temporary file synced and
+// renamed to dst.
+func (a *dirArchiver) copy(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	tmp := dst + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = a.write(f, r); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (a *dirArchiver) write(w io.Writer, r io.Reader) error {
+	if !a.compress {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	gw := gzip.NewWriter(w)
+	if _, err := io.Copy(gw, r); err != nil {
+		return err
+	}
+	return gw.Close()
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileutil
+
+import "github.com/coreos/etcd/Godeps/_workspace/src/github.com/prometheus/client_golang/prometheus"
+
+var (
+	purgedFiles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd",
+		Subsystem: "fileutil",
+		Name:      "purged_files_total",
+		Help:      "The total number of files purged, by type.",
+	}, []string{"type"})
+
+	archivedFiles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd",
+		Subsystem: "fileutil",
+		Name:      "archived_files_total",
+		Help:      "The total number of files archived before they were purged, by type.",
+	}, []string{"type"})
+
+	archiveFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd",
+		Subsystem: "fileutil",
+		Name:      "archive_failures_total",
+		Help:      "The total number of files which failed to be archived, by type.",
+	}, []string{"type"})
+)
+
+func init() {
+	prometheus.MustRegister(purgedFiles)
+	prometheus.MustRegister(archivedFiles)
+	prometheus.MustRegister(archiveFailures)
+}
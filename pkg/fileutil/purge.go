@@ -22,7 +22,45 @@ import (
 	"time"
 )
 
+// PurgeOptions are the options of PurgeFileWithOptions.
+type PurgeOptions struct {
+	// Max is the maximum number of files retained. 0 means no limit.
+	Max uint
+	// MaxAge is the maximum age of the files retained, from their
+	// modification time. 0 means no limit.
+	MaxAge time.Duration
+	// Min is the number of newest files retained whatever their age.
+	Min uint
+	// Archiver, if not nil, archives the files before they are removed.
+	Archiver Archiver
+}
+
+// Exceeds returns whether the file at p, with n retained files newer than
+// it, exceeds the limits of opts.
+func (opts PurgeOptions) Exceeds(p string, n int) bool {
+	if n < int(opts.Min) {
+		return false
+	}
+	if opts.Max > 0 && n >= int(opts.Max) {
+		return true
+	}
+	if opts.MaxAge > 0 {
+		fi, err := os.Stat(p)
+		return err == nil && time.Since(fi.ModTime()) > opts.MaxAge
+	}
+	return false
+}
+
 func PurgeFile(dirname string, suffix string, max uint, interval time.Duration, stop <-chan struct{}) <-chan error {
+	return PurgeFileWithOptions(dirname, suffix, PurgeOptions{Max: max}, interval, stop)
+}
+
+// PurgeFileWithOptions removes the oldest files of dirname with the given
+// suffix which exceed the limits of opts, every interval until stop is
+// closed. A file locked by another process is not removed, nor the files
+// older than it. A file which fails to be archived is retained until the next
+// interval.
+func PurgeFileWithOptions(dirname string, suffix string, opts PurgeOptions, interval time.Duration, stop <-chan struct{}) <-chan error {
 	errC := make(chan error, 1)
 	go func() {
 		for {
@@ -38,7 +76,7 @@ func PurgeFile(dirname string, suffix string, max uint, interval time.Duration,
 				}
 			}
 			sort.Strings(newfnames)
-			for len(newfnames) > int(max) {
+			for len(newfnames) > 0 && opts.Exceeds(path.Join(dirname, newfnames[0]), len(newfnames)-1) {
 				f := path.Join(dirname, newfnames[0])
 				l, err := NewLock(f)
 				if err != nil {
@@ -49,9 +87,9 @@ func PurgeFile(dirname string, suffix string, max uint, interval time.Duration,
 				if err != nil {
 					break
 				}
-				err = os.Remove(f)
-				if err != nil {
-					errC <- err
+				rerr := RetireFile(f, opts.Archiver)
+				if _, ok := rerr.(*ArchiveError); rerr != nil && !ok {
+					errC <- rerr
 					return
 				}
 				err = l.Unlock()
@@ -66,6 +104,10 @@ func PurgeFile(dirname string, suffix string, max uint, interval time.Duration,
 					errC <- err
 					return
 				}
+				if rerr != nil {
+					plog.Warningf("%v, retrying later", rerr)
+					break
+				}
 				plog.Infof("purged file %s successfully", f)
 				newfnames = newfnames[1:]
 			}
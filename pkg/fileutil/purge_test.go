@@ -15,6 +15,8 @@
 package fileutil
 
 import (
+	"compress/gzip"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -130,3 +132,115 @@ func TestPurgeFileHoldingLock(t *testing.T) {
 
 	close(stop)
 }
+
+func TestPurgeFileMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purgefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// files 0 to 3 are older than an hour.
+	for i := 0; i < 6; i++ {
+		p := path.Join(dir, fmt.Sprintf("%d.test", i))
+		if _, err = os.Create(p); err != nil {
+			t.Fatal(err)
+		}
+		if i < 4 {
+			old := time.Now().Add(-2 * time.Hour)
+			if err = os.Chtimes(p, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	errch := PurgeFileWithOptions(dir, "test", PurgeOptions{MaxAge: time.Hour, Min: 3}, time.Millisecond, stop)
+	time.Sleep(20 * time.Millisecond)
+
+	fnames, err := ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 is retained as one of the Min newest files.
+	wnames := []string{"3.test", "4.test", "5.test"}
+	if !reflect.DeepEqual(fnames, wnames) {
+		t.Errorf("filenames = %v, want %v", fnames, wnames)
+	}
+	select {
+	case err := <-errch:
+		t.Errorf("unexpected purge error %v", err)
+	default:
+	}
+}
+
+func TestPurgeFileArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purgefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 5; i++ {
+		if err = ioutil.WriteFile(path.Join(dir, fmt.Sprintf("%d.test", i)), []byte{byte(i)}, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		compress bool
+		wnames   []string
+	}{
+		{false, []string{"0.test", "1.test"}},
+		{true, []string{"0.test.gz", "1.test.gz"}},
+	}
+	for i, tt := range tests {
+		adir := path.Join(dir, fmt.Sprintf("archive%d", i))
+		for j := 0; j < 2; j++ {
+			p := path.Join(dir, fmt.Sprintf("%d.test", j))
+			if err = ioutil.WriteFile(p, []byte{byte(j)}, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err = RetireFile(p, NewDirArchiver(adir, tt.compress)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = os.Stat(p); !os.IsNotExist(err) {
+				t.Errorf("#%d: err = %v, want the file removed", i, err)
+			}
+		}
+		anames, err := ReadDir(adir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(anames, tt.wnames) {
+			t.Errorf("#%d: archived = %v, want %v", i, anames, tt.wnames)
+		}
+	}
+
+	f, err := os.Open(path.Join(dir, "archive1", "1.test.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(gr); err != nil || !reflect.DeepEqual(b, []byte{1}) {
+		t.Errorf("archived content = %v, %v, want %v", b, err, []byte{1})
+	}
+
+	// a file which fails to be archived is retained.
+	p := path.Join(dir, "2.test")
+	err = RetireFile(p, archiverFunc(func(string) error { return errors.New("fail") }))
+	if _, ok := err.(*ArchiveError); !ok {
+		t.Errorf("err = %v, want *ArchiveError", err)
+	}
+	if _, err = os.Stat(p); err != nil {
+		t.Errorf("err = %v, want the file retained", err)
+	}
+}
+
+type archiverFunc func(p string) error
+
+func (f archiverFunc) Archive(p string) error { return f(p) }
@@ -43,8 +43,11 @@ previously-persisted entries with Index >= i must be discarded.
 2. Send all Messages to the nodes named in the To field. It is important that
 no messages be sent until after the latest HardState has been persisted to disk,
 and all Entries written by any previous Ready batch (Messages may be sent while
-entries from the same batch are being persisted). If any Message has type MsgSnap,
-call Node.ReportSnapshot() after it has been sent (these messages may be large).
+entries from the same batch are being persisted). If Config.ParallelPersist is
+set, a leader may send its Messages before persisting anything, since it then
+only counts its own entries towards the commit index once Node.Advance() has been
+called. If any Message has type MsgSnap, call Node.ReportSnapshot() after it
+has been sent (these messages may be large).
 
 3. Apply Snapshot (if any) and CommittedEntries to the state machine.
 If any committed Entry has Type EntryConfChange, call Node.ApplyConfChange()
@@ -56,7 +59,9 @@ the observed health of the node).
 
 4. Call Node.Advance() to signal readiness for the next batch of updates.
 This may be done at any time after step 1, although all updates must be processed
-in the order they were returned by Ready.
+in the order they were returned by Ready. In particular, CommittedEntries may be
+handed to another goroutine which applies them while the following batches are
+persisted, as long as they are applied in order.
 
 Second, all persisted log entries must be made available via an
 implementation of the Storage interface. The provided MemoryStorage
@@ -108,6 +113,12 @@ If the proposal is committed, data will appear in committed entries with type
 raftpb.EntryNormal. There is no guarantee that a proposed command will be
 committed; you may have to re-propose after a timeout.
 
+Concurrent proposals are coalesced into fewer messages. To bound the memory
+held by a leader which receives proposals faster than it can commit them,
+set Config.MaxUncommittedEntriesSize: once it is exceeded, Propose returns
+ErrProposalDropped, which should be taken as a signal to propose at a lower
+rate.
+
 To add or remove node in a cluster, build ConfChange struct 'cc' and call:
 
 	n.ProposeConfChange(ctx, cc)
@@ -119,6 +130,27 @@ raftpb.EntryConfChange will be returned. You must apply it to node through:
 	cc.Unmarshal(data)
 	n.ApplyConfChange(cc)
 
+To change several nodes at once, build a ConfChangeV2 struct carrying the
+changes and call n.ProposeConfChangeV2(ctx, cc). It is committed as an
+entry with type raftpb.EntryConfChangeV2, which must be unmarshaled into a
+raftpb.ConfChangeV2 and applied through n.ApplyConfChangeV2(cc). Such a
+change goes through joint consensus: once applied, entries are committed
+and leaders elected by majorities of both the old and the new
+configurations, until an empty ConfChangeV2 leaves the joint configuration.
+Unless the Transition field is ConfChangeTransitionJointExplicit, the
+leader proposes it on its own.
+
+To serve a linearizable read without going through the log, call
+n.ReadIndex(ctx, rctx) with a context rctx unique to the request. Once the
+leader has confirmed its leadership with a quorum, a ReadState carrying rctx
+and the commit index appears in Ready.ReadStates; the read can be served
+once the entries up to that index are applied.
+
+A MultiNode hosts many raft groups in one process. It is driven like a Node,
+except that the Readys of the groups come together, keyed by group, and that
+the heartbeats of the groups are coalesced into the messages of NodeGroup,
+which must be sent to the peers like those of any group.
+
 Note: An ID represents a unique node in a cluster for all time. A
 given ID MUST be used only once even if the old node has been removed.
 This means that for example IP addresses make poor node IDs since they
@@ -149,5 +181,10 @@ cannot be removed any more since the cluster cannot make progress.
 For this reason it is highly recommened to use three or more nodes in
 every cluster.
 
+Membership changes proposed through ConfChangeV2 with several changes,
+or with an explicit joint Transition, follow the joint consensus
+protocol of section 4.3 instead. While the configuration is joint, the
+leader only accepts the ConfChangeV2 which leaves it.
+
 */
 package raft
@@ -0,0 +1,80 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import "fmt"
+
+const (
+	// EventElection is emitted when the raft starts an election.
+	EventElection EventType = iota
+	// EventStateChange is emitted when the raft becomes a follower,
+	// a candidate or the leader. State and Lead are set.
+	EventStateChange
+	// EventTermChange is emitted when the term of the raft changes.
+	EventTermChange
+	// EventSnapshotSend is emitted when the leader sends a snapshot to
+	// a follower. Peer and Index are set.
+	EventSnapshotSend
+	// EventProgressStateChange is emitted when the progress of a follower
+	// changes state in the view of the leader. Peer, ProgressState and
+	// Index, the index of the last entry the follower matches, are set.
+	EventProgressStateChange
+)
+
+type EventType int
+
+var evtmap = [...]string{
+	"EventElection",
+	"EventStateChange",
+	"EventTermChange",
+	"EventSnapshotSend",
+	"EventProgressStateChange",
+}
+
+func (t EventType) String() string { return evtmap[t] }
+
+// Event describes something which happened to a raft. Term is always set
+// to the term of the raft; which other fields are set depends on the Type.
+type Event struct {
+	Type EventType
+	Term uint64
+
+	State StateType
+	Lead  uint64
+
+	Peer          uint64
+	ProgressState ProgressStateType
+	Index         uint64
+}
+
+func (e Event) String() string {
+	switch e.Type {
+	case EventStateChange:
+		return fmt.Sprintf("%s[term: %d, state: %s, lead: %x]", e.Type, e.Term, e.State, e.Lead)
+	case EventSnapshotSend:
+		return fmt.Sprintf("%s[term: %d, peer: %x, index: %d]", e.Type, e.Term, e.Peer, e.Index)
+	case EventProgressStateChange:
+		return fmt.Sprintf("%s[term: %d, peer: %x, state: %s, match: %d]", e.Type, e.Term, e.Peer, e.ProgressState, e.Index)
+	default:
+		return fmt.Sprintf("%s[term: %d]", e.Type, e.Term)
+	}
+}
+
+// EventListener receives the events of a raft. HandleEvent is called
+// synchronously by the goroutine driving the raft, so it must not block
+// and must not call back into the Node.
+type EventListener interface {
+	HandleEvent(e Event)
+}
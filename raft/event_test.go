@@ -0,0 +1,83 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"reflect"
+	"testing"
+
+	pb "github.com/coreos/etcd/raft/raftpb"
+)
+
+type eventRecorder struct {
+	events []Event
+}
+
+func (er *eventRecorder) HandleEvent(e Event) { er.events = append(er.events, e) }
+
+func TestElectionEvents(t *testing.T) {
+	rec := &eventRecorder{}
+	c := newTestConfig(1, []uint64{1}, 10, 1, NewMemoryStorage())
+	c.EventListener = rec
+	r := newRaft(c)
+	wevents := []Event{{Type: EventStateChange, State: StateFollower}}
+	if !reflect.DeepEqual(rec.events, wevents) {
+		t.Fatalf("events = %v, want %v", rec.events, wevents)
+	}
+
+	rec.events = nil
+	r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+	wevents = []Event{
+		{Type: EventTermChange, Term: 1},
+		{Type: EventStateChange, Term: 1, State: StateCandidate},
+		{Type: EventElection, Term: 1},
+		{Type: EventStateChange, Term: 1, State: StateLeader, Lead: 1},
+	}
+	if !reflect.DeepEqual(rec.events, wevents) {
+		t.Errorf("events = %v, want %v", rec.events, wevents)
+	}
+}
+
+func TestProgressStateEvents(t *testing.T) {
+	sm := newTestRaft(1, []uint64{1, 2}, 10, 1, NewMemoryStorage())
+	sm.restore(testingSnap)
+	sm.becomeCandidate()
+	sm.becomeLeader()
+	sm.readMessages()
+	rec := &eventRecorder{}
+	sm.events = rec
+
+	// node 2 needs a snapshot
+	sm.prs[2].Next = sm.raftLog.firstIndex()
+	sm.Step(pb.Message{From: 2, To: 1, Type: pb.MsgAppResp, Index: sm.prs[2].Next - 1, Reject: true})
+	sm.Step(pb.Message{From: 2, To: 1, Type: pb.MsgSnapStatus})
+	sm.Step(pb.Message{From: 2, To: 1, Type: pb.MsgAppResp, Index: 12})
+	sm.Step(pb.Message{From: 2, To: 1, Type: pb.MsgUnreachable})
+	// the progress is already in probe
+	sm.becomeProgressState(2, ProgressStateProbe, 0)
+	sm.Step(pb.Message{From: 2, To: 1, Type: pb.MsgAppResp, Index: sm.prs[2].Next - 1, Reject: true, RejectHint: 12})
+	sm.Step(pb.Message{From: 2, To: 1, Type: pb.MsgUnreachable})
+
+	wevents := []Event{
+		{Type: EventProgressStateChange, Term: 1, Peer: 2, ProgressState: ProgressStateSnapshot},
+		{Type: EventSnapshotSend, Term: 1, Peer: 2, Index: 11},
+		{Type: EventProgressStateChange, Term: 1, Peer: 2, ProgressState: ProgressStateProbe},
+		{Type: EventProgressStateChange, Term: 1, Peer: 2, ProgressState: ProgressStateReplicate, Index: 12},
+		{Type: EventProgressStateChange, Term: 1, Peer: 2, ProgressState: ProgressStateProbe, Index: 12},
+	}
+	if !reflect.DeepEqual(rec.events, wevents) {
+		t.Errorf("events = %v, want %v", rec.events, wevents)
+	}
+}
@@ -199,6 +199,9 @@ func (l *raftLog) appliedTo(i uint64) {
 
 func (l *raftLog) stableTo(i, t uint64) { l.unstable.stableTo(i, t) }
 
+// lastStableIndex returns the index of the last entry known to be persisted.
+func (l *raftLog) lastStableIndex() uint64 { return min(l.unstable.offset-1, l.lastIndex()) }
+
 func (l *raftLog) stableSnapTo(i uint64) { l.unstable.stableSnapTo(i) }
 
 func (l *raftLog) lastTerm() uint64 {
@@ -1,20 +1,46 @@
 package raft
 
 import (
+	"encoding/binary"
+	"errors"
+
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	pb "github.com/coreos/etcd/raft/raftpb"
 )
 
+// NodeGroup is the group of the messages exchanged by the MultiNodes
+// themselves rather than by the rafts of a group, like the coalesced
+// heartbeats. It cannot be used as the ID of a group.
+const NodeGroup uint64 = 0
+
+var (
+	ErrGroupExists  = errors.New("raft: group already exists")
+	ErrInvalidGroup = errors.New("raft: invalid group ID")
+)
+
 // MultiNode represents a node that is participating in multiple consensus groups.
 // A MultiNode is more efficient than a collection of Nodes.
 // The methods of this interface correspond to the methods of Node and are described
 // more fully there.
+//
+// The heartbeats of the groups are coalesced: instead of sending a heartbeat
+// per group and per peer, a MultiNode periodically sends a single heartbeat
+// to each peer which follows it in some group, and fans the heartbeats it
+// receives out to the groups led by their sender, with the term and commit
+// index it carries for each of them. These heartbeats are
+// returned by Ready under NodeGroup, and must be sent and stepped like the
+// messages of any group.
+//
+// The groups created with Config.Quiesce are quiesced once they are idle:
+// they are neither ticked nor heartbeated until they receive a message, like
+// a proposal. A quiesced follower wakes up when its leader's MultiNode is not
+// heard of for an election timeout.
 type MultiNode interface {
 	// CreateGroup adds a new group to the MultiNode. The application must call CreateGroup
 	// on each particpating node with the same group ID; it may create groups on demand as it
 	// receives messages. If the given storage contains existing log entries the list of peers
 	// may be empty. The Config.ID field will be ignored and replaced by the ID passed
-	// to StartMultiNode.
+	// to StartMultiNode. It returns ErrGroupExists if the group already exists.
 	CreateGroup(group uint64, c *Config, peers []Peer) error
 	// RemoveGroup removes a group from the MultiNode.
 	RemoveGroup(group uint64) error
@@ -26,12 +52,20 @@ type MultiNode interface {
 	Propose(ctx context.Context, group uint64, data []byte) error
 	// ProposeConfChange proposes a config change.
 	ProposeConfChange(ctx context.Context, group uint64, cc pb.ConfChange) error
+	// ProposeConfChangeV2 proposes a config change applied through joint consensus.
+	ProposeConfChangeV2(ctx context.Context, group uint64, cc pb.ConfChangeV2) error
 	// ApplyConfChange applies a config change to the local node.
 	ApplyConfChange(group uint64, cc pb.ConfChange) *pb.ConfState
-	// Step advances the state machine using the given message.
+	// ApplyConfChangeV2 applies a config change made of several changes to the local node.
+	ApplyConfChangeV2(group uint64, cc pb.ConfChangeV2) *pb.ConfState
+	// Step advances the state machine using the given message. The messages
+	// of NodeGroup are stepped into the MultiNode itself.
 	Step(ctx context.Context, group uint64, msg pb.Message) error
+	// ReadIndex requests a read state for a linearizable read in the given group.
+	ReadIndex(ctx context.Context, group uint64, rctx []byte) error
 	// Ready returns a channel that returns the current point-in-time state of any ready
-	// groups. Only groups with something to report will appear in the map.
+	// groups. Only groups with something to report will appear in the map. The
+	// messages of the MultiNode itself appear under NodeGroup.
 	Ready() <-chan map[uint64]Ready
 	// Advance notifies the node that the application has applied and saved progress in the
 	// last Ready results. It must be called with the last value returned from the Ready()
@@ -65,7 +99,7 @@ type multiMessage struct {
 
 type multiConfChange struct {
 	group uint64
-	msg   pb.ConfChange
+	msg   pb.ConfChangeV2
 	ch    chan pb.ConfState
 }
 
@@ -81,7 +115,7 @@ type groupCreation struct {
 	// TODO(bdarnell): do we really need the done channel here? It's
 	// unlike the rest of this package, but we need the group creation
 	// to be complete before any Propose or other calls.
-	done chan struct{}
+	done chan error
 }
 
 type groupRemoval struct {
@@ -128,12 +162,68 @@ type groupState struct {
 	prevSoftSt *SoftState
 	prevHardSt pb.HardState
 	prevSnapi  uint64
+
+	// canQuiesce is set if the group may be quiesced, and quiesced once it is.
+	canQuiesce bool
+	quiesced   bool
+	// idle is the number of ticks since the group last stepped a message,
+	// not counting the heartbeats fanned out to it.
+	idle int
 }
 
 func (g *groupState) newReady() Ready {
+	// the heartbeats of the group are replaced by the coalesced heartbeats
+	// of the MultiNode, except the ones confirming read only requests.
+	msgs := g.raft.msgs[:0]
+	for _, m := range g.raft.msgs {
+		if !isCoalescedHeartbeat(m) {
+			msgs = append(msgs, m)
+		}
+	}
+	g.raft.msgs = msgs
 	return newReady(g.raft, g.prevSoftSt, g.prevHardSt)
 }
 
+// wake marks the group as active.
+func (g *groupState) wake() {
+	g.idle = 0
+	g.quiesced = false
+}
+
+// shouldQuiesce returns true if the group is idle for an election timeout,
+// and both its log and the logs of the followers are up to date.
+func (g *groupState) shouldQuiesce() bool {
+	r := g.raft
+	if !g.canQuiesce || g.idle < r.electionTimeout {
+		return false
+	}
+	for _, m := range r.msgs {
+		if !isCoalescedHeartbeat(m) {
+			return false
+		}
+	}
+	li := r.raftLog.lastIndex()
+	if r.raftLog.committed != li || r.raftLog.applied != li || len(r.raftLog.unstableEntries()) > 0 {
+		return false
+	}
+	switch r.state {
+	case StateLeader:
+		if r.pendingConf || len(r.readOnly.readIndexQueue) > 0 {
+			return false
+		}
+		for _, pr := range r.prs {
+			if pr.Match != li {
+				return false
+			}
+		}
+		return true
+	case StateFollower:
+		return r.lead != None
+	default:
+		return false
+	}
+}
+
 func (g *groupState) commitReady(rd Ready) {
 	if rd.SoftState != nil {
 		g.prevSoftSt = rd.SoftState
@@ -155,7 +245,7 @@ func (g *groupState) commitReady(rd Ready) {
 	}
 	if len(rd.Entries) > 0 {
 		e := rd.Entries[len(rd.Entries)-1]
-		g.raft.raftLog.stableTo(e.Index, e.Term)
+		g.raft.stableTo(e.Index, e.Term)
 	}
 	if !IsEmptySnap(rd.Snapshot) {
 		g.prevSnapi = rd.Snapshot.Metadata.Index
@@ -167,6 +257,14 @@ func (mn *multiNode) run() {
 	groups := map[uint64]*groupState{}
 	rds := map[uint64]Ready{}
 	var advancec chan map[uint64]Ready
+	// the clock of the MultiNode, the tick at which each peer was last
+	// heard of, and the coalesced heartbeat interval, which is the
+	// shortest heartbeat interval of the groups.
+	var ticks, heartbeatElapsed uint64
+	lastHeard := map[uint64]uint64{}
+	heartbeatTick := uint64(1)
+	// msgs are the messages of the MultiNode itself.
+	var msgs []pb.Message
 	for {
 		// Only select readyc if we have something to report and we are not
 		// currently waiting for an advance.
@@ -175,17 +273,26 @@ func (mn *multiNode) run() {
 			readyc = nil
 		}
 
-		// group points to the group that was touched on this iteration (if any)
-		var group *groupState
+		// touched are the groups which were touched on this iteration (if any)
+		var touched []*groupState
 		select {
 		case gc := <-mn.groupc:
+			if _, ok := groups[gc.id]; ok {
+				gc.done <- ErrGroupExists
+				break
+			}
 			gc.config.ID = mn.id
 			r := newRaft(gc.config)
-			group = &groupState{
-				id:   gc.id,
-				raft: r,
+			group := &groupState{
+				id:         gc.id,
+				raft:       r,
+				canQuiesce: gc.config.Quiesce,
 			}
 			groups[gc.id] = group
+			touched = append(touched, group)
+			if len(groups) == 1 || uint64(gc.config.HeartbeatTick) < heartbeatTick {
+				heartbeatTick = uint64(gc.config.HeartbeatTick)
+			}
 			lastIndex, err := gc.config.Storage.LastIndex()
 			if err != nil {
 				panic(err) // TODO(bdarnell)
@@ -210,11 +317,12 @@ func (mn *multiNode) run() {
 				for _, peer := range gc.peers {
 					r.addNode(peer.ID)
 				}
+				r.Commit = r.raftLog.committed
 			}
 			// Set the initial hard and soft states after performing all initialization.
 			group.prevSoftSt = r.softState()
 			group.prevHardSt = r.HardState
-			close(gc.done)
+			gc.done <- nil
 
 		case gr := <-mn.rmgroupc:
 			delete(groups, gr.id)
@@ -227,60 +335,86 @@ func (mn *multiNode) run() {
 			// We'll have to buffer somewhere on a group-by-group basis, or just let
 			// raft.Step drop any such proposals on the floor.
 			mm.msg.From = mn.id
-			var ok bool
-			if group, ok = groups[mm.group]; ok {
+			if group, ok := groups[mm.group]; ok {
+				group.wake()
 				group.raft.Step(mm.msg)
+				touched = append(touched, group)
 			}
 
 		case mm := <-mn.recvc:
-			group = groups[mm.group]
+			if mm.msg.From != None && mm.msg.From != mn.id {
+				lastHeard[mm.msg.From] = ticks
+			}
+			if mm.group == NodeGroup {
+				var beats []groupBeat
+				touched, beats = mn.fanOut(groups, mm.msg)
+				if mm.msg.Type == pb.MsgHeartbeat {
+					msgs = append(msgs, pb.Message{Type: pb.MsgHeartbeatResp, From: mn.id, To: mm.msg.From, Context: encodeGroupBeats(beats)})
+				}
+				break
+			}
+			group, ok := groups[mm.group]
+			if !ok {
+				break
+			}
+			group.wake()
 			if _, ok := group.raft.prs[mm.msg.From]; ok || !IsResponseMsg(mm.msg) {
 				group.raft.Step(mm.msg)
 			}
+			touched = append(touched, group)
 
 		case mcc := <-mn.confc:
-			group = groups[mcc.group]
-			if mcc.msg.NodeID == None {
-				group.raft.resetPendingConf()
-				select {
-				case mcc.ch <- pb.ConfState{Nodes: group.raft.nodes()}:
-				case <-mn.done:
-				}
-				break
-			}
-			switch mcc.msg.Type {
-			case pb.ConfChangeAddNode:
-				group.raft.addNode(mcc.msg.NodeID)
-			case pb.ConfChangeRemoveNode:
-				group.raft.removeNode(mcc.msg.NodeID)
-			case pb.ConfChangeUpdateNode:
-				group.raft.resetPendingConf()
-			default:
-				panic("unexpected conf type")
+			cs := pb.ConfState{}
+			if group, ok := groups[mcc.group]; ok {
+				group.wake()
+				group.raft.applyConfChangeV2(mcc.msg)
+				cs = group.raft.confState()
+				touched = append(touched, group)
 			}
 			select {
-			case mcc.ch <- pb.ConfState{Nodes: group.raft.nodes()}:
+			case mcc.ch <- cs:
 			case <-mn.done:
 			}
 
 		case <-mn.tickc:
+			ticks++
 			// TODO(bdarnell): instead of calling every group on every tick,
 			// we should have a priority queue of groups based on their next
 			// time-based event.
 			for _, g := range groups {
+				if g.quiesced {
+					// wake up a follower whose leader is not heard of any more,
+					// so it campaigns if the leader is down.
+					r := g.raft
+					if r.state == StateLeader || ticks-lastHeard[r.lead] <= uint64(r.electionTimeout) {
+						continue
+					}
+					g.wake()
+				}
 				g.raft.tick()
-				rd := g.newReady()
-				if rd.containsUpdates() {
-					rds[g.id] = rd
+				g.idle++
+				if g.shouldQuiesce() {
+					g.quiesced = true
 				}
+				touched = append(touched, g)
+			}
+			if heartbeatElapsed++; heartbeatElapsed >= heartbeatTick {
+				heartbeatElapsed = 0
+				msgs = append(msgs, mn.heartbeats(groups)...)
 			}
 
 		case readyc <- rds:
 			// Clear outgoing messages as soon as we've passed them to the application.
-			for g := range rds {
+			for g, rd := range rds {
+				if g == NodeGroup {
+					continue
+				}
 				groups[g].raft.msgs = nil
+				groups[g].raft.readStates = nil
+				groups[g].raft.reduceUncommittedSize(rd.CommittedEntries)
 			}
 			rds = map[uint64]Ready{}
+			msgs = nil
 			advancec = mn.advancec
 
 		case advs := <-advancec:
@@ -294,16 +428,14 @@ func (mn *multiNode) run() {
 				// We've been accumulating new entries in rds which may now be obsolete.
 				// Drop the old Ready object and create a new one if needed.
 				delete(rds, groupID)
-				newRd := g.newReady()
-				if newRd.containsUpdates() {
-					rds[groupID] = newRd
-				}
+				touched = append(touched, g)
 			}
 			advancec = nil
 
 		case ms := <-mn.status:
 			if g, ok := groups[ms.group]; ok {
 				s := getStatus(g.raft)
+				s.Quiesced = g.quiesced
 				ms.ch <- &s
 			} else {
 				ms.ch <- nil
@@ -314,26 +446,143 @@ func (mn *multiNode) run() {
 			return
 		}
 
-		if group != nil {
-			rd := group.newReady()
+		for _, g := range touched {
+			rd := g.newReady()
 			if rd.containsUpdates() {
-				rds[group.id] = rd
+				rds[g.id] = rd
+			}
+		}
+		if len(msgs) > 0 {
+			rds[NodeGroup] = Ready{Messages: msgs}
+		}
+	}
+}
+
+// groupBeat is the heartbeat of a group carried by a coalesced heartbeat,
+// or its response: the term of the group and, in a heartbeat, the commit
+// index of the recipient.
+type groupBeat struct {
+	group  uint64
+	term   uint64
+	commit uint64
+}
+
+// encodeGroupBeats encodes the given beats into the Context of a coalesced
+// heartbeat, or heartbeat response.
+func encodeGroupBeats(beats []groupBeat) []byte {
+	b := make([]byte, 24*len(beats))
+	for i, gb := range beats {
+		binary.BigEndian.PutUint64(b[24*i:], gb.group)
+		binary.BigEndian.PutUint64(b[24*i+8:], gb.term)
+		binary.BigEndian.PutUint64(b[24*i+16:], gb.commit)
+	}
+	return b
+}
+
+// decodeGroupBeats decodes the beats carried by the Context of a coalesced
+// heartbeat, or heartbeat response. A truncated trailing beat is ignored.
+func decodeGroupBeats(b []byte) []groupBeat {
+	beats := make([]groupBeat, 0, len(b)/24)
+	for ; len(b) >= 24; b = b[24:] {
+		beats = append(beats, groupBeat{
+			group:  binary.BigEndian.Uint64(b),
+			term:   binary.BigEndian.Uint64(b[8:]),
+			commit: binary.BigEndian.Uint64(b[16:]),
+		})
+	}
+	return beats
+}
+
+// heartbeats returns the coalesced heartbeats to the peers which follow
+// this MultiNode in some group, quiesced or not. Each heartbeat carries the
+// term and the commit index of the peer of the active groups it stands for.
+func (mn *multiNode) heartbeats(groups map[uint64]*groupState) []pb.Message {
+	peers := map[uint64]bool{}
+	beats := map[uint64][]groupBeat{}
+	for _, g := range groups {
+		r := g.raft
+		if r.state != StateLeader {
+			continue
+		}
+		for id, pr := range r.prs {
+			if id == mn.id {
+				continue
+			}
+			peers[id] = true
+			if !g.quiesced {
+				beats[id] = append(beats[id], groupBeat{group: g.id, term: r.Term, commit: min(pr.Match, r.raftLog.committed)})
 			}
 		}
 	}
+	msgs := make([]pb.Message, 0, len(peers))
+	for _, id := range setIDs(peers) {
+		msgs = append(msgs, pb.Message{Type: pb.MsgHeartbeat, From: mn.id, To: id, Context: encodeGroupBeats(beats[id])})
+	}
+	return msgs
+}
+
+// fanOut steps the given coalesced heartbeat, or heartbeat response, into
+// the active groups it stands for, and returns them with the beats of the
+// groups it was stepped into: a heartbeat into the groups following its
+// sender, and a response into the groups it follows. The beats whose term
+// is not the term of their group are dropped.
+func (mn *multiNode) fanOut(groups map[uint64]*groupState, m pb.Message) ([]*groupState, []groupBeat) {
+	var (
+		touched []*groupState
+		stepped []groupBeat
+	)
+	for _, gb := range decodeGroupBeats(m.Context) {
+		g, ok := groups[gb.group]
+		if !ok || g.quiesced {
+			continue
+		}
+		r := g.raft
+		if gb.term != r.Term {
+			continue
+		}
+		switch m.Type {
+		case pb.MsgHeartbeat:
+			if r.state != StateFollower || r.lead != m.From {
+				continue
+			}
+		case pb.MsgHeartbeatResp:
+			if _, ok := r.prs[m.From]; r.state != StateLeader || !ok {
+				continue
+			}
+		default:
+			return nil, nil
+		}
+		r.Step(pb.Message{Type: m.Type, From: m.From, To: mn.id, Term: gb.term, Commit: gb.commit})
+		touched = append(touched, g)
+		stepped = append(stepped, groupBeat{group: gb.group, term: gb.term})
+	}
+	return touched, stepped
+}
+
+// isCoalescedHeartbeat returns true if the given message of a group is a
+// heartbeat, or heartbeat response, replaced by the coalesced heartbeats.
+func isCoalescedHeartbeat(m pb.Message) bool {
+	return (m.Type == pb.MsgHeartbeat || m.Type == pb.MsgHeartbeatResp) && len(m.Context) == 0
 }
 
 func (mn *multiNode) CreateGroup(id uint64, config *Config, peers []Peer) error {
+	if id == NodeGroup {
+		return ErrInvalidGroup
+	}
 	gc := groupCreation{
 		id:     id,
 		config: config,
 		peers:  peers,
-		done:   make(chan struct{}),
+		done:   make(chan error, 1),
 	}
-	mn.groupc <- gc
 	select {
-	case <-gc.done:
-		return nil
+	case mn.groupc <- gc:
+	case <-mn.done:
+		return ErrStopped
+	}
+	select {
+	case err := <-gc.done:
+		return err
 	case <-mn.done:
 		return ErrStopped
 	}
@@ -344,7 +593,11 @@ func (mn *multiNode) RemoveGroup(id uint64) error {
 		id:   id,
 		done: make(chan struct{}),
 	}
-	mn.rmgroupc <- gr
+	select {
+	case mn.rmgroupc <- gr:
+	case <-mn.done:
+		return ErrStopped
+	}
 	select {
 	case <-gr.done:
 		return nil
@@ -400,6 +653,20 @@ func (mn *multiNode) ProposeConfChange(ctx context.Context, group uint64, cc pb.
 		})
 }
 
+func (mn *multiNode) ProposeConfChangeV2(ctx context.Context, group uint64, cc pb.ConfChangeV2) error {
+	data, err := cc.Marshal()
+	if err != nil {
+		return err
+	}
+	return mn.Step(ctx, group,
+		pb.Message{
+			Type: pb.MsgProp,
+			Entries: []pb.Entry{
+				{Type: pb.EntryConfChangeV2, Data: data},
+			},
+		})
+}
+
 func (mn *multiNode) step(ctx context.Context, m multiMessage) error {
 	ch := mn.recvc
 	if m.msg.Type == pb.MsgProp {
@@ -417,6 +684,10 @@ func (mn *multiNode) step(ctx context.Context, m multiMessage) error {
 }
 
 func (mn *multiNode) ApplyConfChange(group uint64, cc pb.ConfChange) *pb.ConfState {
+	return mn.ApplyConfChangeV2(group, cc.AsV2())
+}
+
+func (mn *multiNode) ApplyConfChangeV2(group uint64, cc pb.ConfChangeV2) *pb.ConfState {
 	mcc := multiConfChange{group, cc, make(chan pb.ConfState)}
 	select {
 	case mn.confc <- mcc:
@@ -431,6 +702,14 @@ func (mn *multiNode) ApplyConfChange(group uint64, cc pb.ConfChange) *pb.ConfSta
 	}
 }
 
+func (mn *multiNode) ReadIndex(ctx context.Context, group uint64, rctx []byte) error {
+	return mn.step(ctx, multiMessage{group,
+		pb.Message{
+			Type:    pb.MsgReadIndex,
+			Entries: []pb.Entry{{Data: rctx}},
+		}})
+}
+
 func (mn *multiNode) Step(ctx context.Context, group uint64, m pb.Message) error {
 	// ignore unexpected local messages receiving over network
 	if IsLocalMsg(m) {
@@ -456,8 +735,12 @@ func (mn *multiNode) Status(group uint64) *Status {
 		group: group,
 		ch:    make(chan *Status),
 	}
-	mn.status <- ms
-	return <-ms.ch
+	select {
+	case mn.status <- ms:
+		return <-ms.ch
+	case <-mn.done:
+		return nil
+	}
 }
 
 func (mn *multiNode) ReportUnreachable(id, groupID uint64) {
@@ -476,3 +476,360 @@ func TestMultiNodeStatus(t *testing.T) {
 		t.Errorf("expected nil status, got %+v", status)
 	}
 }
+
+func TestMultiNodeCreateGroup(t *testing.T) {
+	mn := StartMultiNode(1)
+	defer mn.Stop()
+	if err := mn.CreateGroup(NodeGroup, newTestConfig(1, nil, 10, 1, NewMemoryStorage()), []Peer{{ID: 1}}); err != ErrInvalidGroup {
+		t.Errorf("err = %v, want %v", err, ErrInvalidGroup)
+	}
+	if err := mn.CreateGroup(1, newTestConfig(1, nil, 10, 1, NewMemoryStorage()), []Peer{{ID: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.CreateGroup(1, newTestConfig(1, nil, 10, 1, NewMemoryStorage()), []Peer{{ID: 1}}); err != ErrGroupExists {
+		t.Errorf("err = %v, want %v", err, ErrGroupExists)
+	}
+	// the messages and configuration changes of unknown groups are ignored
+	mn.Step(context.TODO(), 2, raftpb.Message{Type: raftpb.MsgApp, From: 2, To: 1})
+	if cs := mn.ApplyConfChange(2, raftpb.ConfChange{Type: raftpb.ConfChangeAddNode, NodeID: 2}); cs == nil || len(cs.Nodes) != 0 {
+		t.Errorf("confState = %v, want empty", cs)
+	}
+	if s := mn.Status(1); s == nil {
+		t.Errorf("expected status of group 1, got nil")
+	}
+}
+
+// multiNodeNetwork connects MultiNodes hosting the same groups, which
+// are all replicated on every node.
+type multiNodeNetwork struct {
+	t        *testing.T
+	ids      []uint64
+	groups   []uint64
+	nodes    map[uint64]MultiNode
+	storages map[uint64]map[uint64]*MemoryStorage
+	// isolated nodes neither send nor receive messages.
+	isolated map[uint64]bool
+
+	// msgs are the messages sent since the last call to readMessages.
+	msgs []multiMessage
+	// committed are the data of the normal entries committed by each group
+	// of each node, and readStates the read states of each node.
+	committed  map[uint64]map[uint64][]string
+	readStates map[uint64][]ReadState
+}
+
+func newMultiNodeNetwork(t *testing.T, nodes, groups int) *multiNodeNetwork {
+	nw := &multiNodeNetwork{
+		t:          t,
+		nodes:      make(map[uint64]MultiNode),
+		storages:   make(map[uint64]map[uint64]*MemoryStorage),
+		isolated:   make(map[uint64]bool),
+		committed:  make(map[uint64]map[uint64][]string),
+		readStates: make(map[uint64][]ReadState),
+	}
+	var peers []Peer
+	for i := 1; i <= nodes; i++ {
+		nw.ids = append(nw.ids, uint64(i))
+		peers = append(peers, Peer{ID: uint64(i)})
+	}
+	for g := 1; g <= groups; g++ {
+		nw.groups = append(nw.groups, uint64(g))
+	}
+	for _, id := range nw.ids {
+		mn := StartMultiNode(id)
+		nw.nodes[id] = mn
+		nw.storages[id] = make(map[uint64]*MemoryStorage)
+		nw.committed[id] = make(map[uint64][]string)
+		for _, g := range nw.groups {
+			s := NewMemoryStorage()
+			nw.storages[id][g] = s
+			c := newTestConfig(id, nil, 10, 1, s)
+			c.Quiesce = true
+			if err := mn.CreateGroup(g, c, peers); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	nw.process()
+	return nw
+}
+
+func (nw *multiNodeNetwork) stop() {
+	for _, mn := range nw.nodes {
+		mn.Stop()
+	}
+}
+
+// process handles the Readys of the nodes and delivers their messages
+// until no node has a Ready any more.
+func (nw *multiNodeNetwork) process() {
+	for {
+		var msgs []multiMessage
+		ready := false
+		for _, id := range nw.ids {
+			mn := nw.nodes[id]
+			// wait for the node to handle the messages stepped so far
+			mn.Status(NodeGroup)
+			select {
+			case rds := <-mn.Ready():
+				msgs = append(msgs, nw.handleReady(id, rds)...)
+				mn.Advance(rds)
+				ready = true
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+		if !ready {
+			return
+		}
+		for _, mm := range msgs {
+			if nw.isolated[mm.msg.From] || nw.isolated[mm.msg.To] {
+				continue
+			}
+			if err := nw.nodes[mm.msg.To].Step(context.TODO(), mm.group, mm.msg); err != nil {
+				nw.t.Fatal(err)
+			}
+		}
+	}
+}
+
+func (nw *multiNodeNetwork) handleReady(id uint64, rds map[uint64]Ready) []multiMessage {
+	var msgs []multiMessage
+	for g, rd := range rds {
+		for _, m := range rd.Messages {
+			msgs = append(msgs, multiMessage{group: g, msg: m})
+		}
+		if g == NodeGroup {
+			continue
+		}
+		s := nw.storages[id][g]
+		if !IsEmptySnap(rd.Snapshot) {
+			s.ApplySnapshot(rd.Snapshot)
+		}
+		if !IsEmptyHardState(rd.HardState) {
+			s.SetHardState(rd.HardState)
+		}
+		s.Append(rd.Entries)
+		for _, e := range rd.CommittedEntries {
+			if e.Type == raftpb.EntryNormal && len(e.Data) > 0 {
+				nw.committed[id][g] = append(nw.committed[id][g], string(e.Data))
+			}
+		}
+		nw.readStates[id] = append(nw.readStates[id], rd.ReadStates...)
+	}
+	nw.msgs = append(nw.msgs, msgs...)
+	return msgs
+}
+
+// tick ticks every node once and processes the resulting messages.
+func (nw *multiNodeNetwork) tick() {
+	for _, id := range nw.ids {
+		nw.nodes[id].Tick()
+	}
+	nw.process()
+}
+
+// quiesce ticks the nodes until the idle groups are quiesced.
+func (nw *multiNodeNetwork) quiesce() {
+	for i := 0; i < 12; i++ {
+		nw.tick()
+	}
+}
+
+func (nw *multiNodeNetwork) readMessages() []multiMessage {
+	msgs := nw.msgs
+	nw.msgs = nil
+	return msgs
+}
+
+// elect campaigns every group, spreading the leaders over the nodes.
+func (nw *multiNodeNetwork) elect() {
+	for i, g := range nw.groups {
+		if err := nw.nodes[nw.ids[i%len(nw.ids)]].Campaign(context.TODO(), g); err != nil {
+			nw.t.Fatal(err)
+		}
+	}
+	nw.process()
+	for i, g := range nw.groups {
+		wlead := nw.ids[i%len(nw.ids)]
+		for _, id := range nw.ids {
+			if s := nw.nodes[id].Status(g); s.Lead != wlead {
+				nw.t.Fatalf("node %d group %d: lead = %d, want %d", id, g, s.Lead, wlead)
+			}
+		}
+	}
+}
+
+// TestMultiNodeCoalesceHeartbeats ensures that a MultiNode sends a single
+// heartbeat per tick to each of its peers, whatever the number of groups.
+func TestMultiNodeCoalesceHeartbeats(t *testing.T) {
+	nw := newMultiNodeNetwork(t, 3, 300)
+	defer nw.stop()
+	nw.elect()
+	nw.readMessages()
+
+	for i := 0; i < 5; i++ {
+		nw.tick()
+		beats := make(map[[2]uint64]int)
+		for _, mm := range nw.readMessages() {
+			if mm.group != NodeGroup {
+				if isCoalescedHeartbeat(mm.msg) {
+					t.Fatalf("#%d: unexpected heartbeat %+v in group %d", i, mm.msg, mm.group)
+				}
+				continue
+			}
+			if mm.msg.Type == raftpb.MsgHeartbeat {
+				beats[[2]uint64{mm.msg.From, mm.msg.To}]++
+			}
+		}
+		// every node leads some group, so it heartbeats every other node
+		if len(beats) != 6 {
+			t.Errorf("#%d: len(heartbeats) = %d, want %d", i, len(beats), 6)
+		}
+		for p, n := range beats {
+			if n != 1 {
+				t.Errorf("#%d: heartbeats %x->%x = %d, want 1", i, p[0], p[1], n)
+			}
+		}
+	}
+	// the followers still know their leaders
+	for i, g := range nw.groups {
+		for _, id := range nw.ids {
+			if s := nw.nodes[id].Status(g); s.Lead != nw.ids[i%3] || s.Term != 2 {
+				t.Errorf("node %d group %d: lead = %d term = %d, want %d, %d", id, g, s.Lead, s.Term, nw.ids[i%3], 2)
+			}
+		}
+	}
+}
+
+// TestMultiNodeFanOutHeartbeat ensures that a coalesced heartbeat is
+// stepped into a group with the term and the commit index carried for it,
+// and is dropped for the groups whose term does not match.
+func TestMultiNodeFanOutHeartbeat(t *testing.T) {
+	storage := NewMemoryStorage()
+	storage.Append([]raftpb.Entry{{Index: 1, Term: 1}, {Index: 2, Term: 1}, {Index: 3, Term: 1}})
+	r := newTestRaft(1, []uint64{1, 2}, 10, 1, storage)
+	r.becomeFollower(2, 2)
+	groups := map[uint64]*groupState{1: {id: 1, raft: r}}
+	mn := &multiNode{id: 1}
+
+	tests := []struct {
+		beats []groupBeat
+
+		wstepped   []groupBeat
+		wcommitted uint64
+	}{
+		// stale term, and unknown group
+		{[]groupBeat{{group: 1, term: 1, commit: 3}, {group: 2, term: 2, commit: 3}}, nil, 0},
+		{[]groupBeat{{group: 1, term: 2, commit: 2}}, []groupBeat{{group: 1, term: 2}}, 2},
+	}
+	for i, tt := range tests {
+		m := raftpb.Message{Type: raftpb.MsgHeartbeat, From: 2, To: 1, Context: encodeGroupBeats(tt.beats)}
+		_, stepped := mn.fanOut(groups, m)
+		if !reflect.DeepEqual(stepped, tt.wstepped) {
+			t.Errorf("#%d: stepped = %+v, want %+v", i, stepped, tt.wstepped)
+		}
+		if g := r.raftLog.committed; g != tt.wcommitted {
+			t.Errorf("#%d: committed = %d, want %d", i, g, tt.wcommitted)
+		}
+		if r.Term != 2 || r.lead != 2 {
+			t.Errorf("#%d: term = %d lead = %d, want 2, 2", i, r.Term, r.lead)
+		}
+	}
+}
+
+// TestMultiNodeQuiesce ensures that idle groups are quiesced, and that
+// a proposal wakes up its group.
+func TestMultiNodeQuiesce(t *testing.T) {
+	nw := newMultiNodeNetwork(t, 3, 200)
+	defer nw.stop()
+	nw.elect()
+
+	nw.quiesce()
+	for _, g := range nw.groups {
+		for _, id := range nw.ids {
+			if s := nw.nodes[id].Status(g); !s.Quiesced {
+				t.Fatalf("node %d group %d is not quiesced", id, g)
+			}
+		}
+	}
+	nw.readMessages()
+	for i := 0; i < 10; i++ {
+		nw.tick()
+	}
+	for _, mm := range nw.readMessages() {
+		if mm.group != NodeGroup {
+			t.Errorf("unexpected message %+v in quiesced group %d", mm.msg, mm.group)
+		}
+	}
+
+	// group 1 is led by node 1
+	if err := nw.nodes[1].Propose(context.TODO(), 1, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	nw.process()
+	for _, id := range nw.ids {
+		if c := nw.committed[id][1]; !reflect.DeepEqual(c, []string{"foo"}) {
+			t.Errorf("node %d: committed = %v, want [foo]", id, c)
+		}
+		if s := nw.nodes[id].Status(1); s.Quiesced {
+			t.Errorf("node %d: group 1 is quiesced, want awake", id)
+		}
+		if s := nw.nodes[id].Status(2); !s.Quiesced {
+			t.Errorf("node %d: group 2 is awake, want quiesced", id)
+		}
+	}
+	// and quiesces again
+	nw.quiesce()
+	for _, id := range nw.ids {
+		if s := nw.nodes[id].Status(1); !s.Quiesced {
+			t.Errorf("node %d: group 1 is awake, want quiesced", id)
+		}
+	}
+}
+
+// TestMultiNodeQuiescedLeaderDown ensures that the quiesced followers
+// elect a new leader once the node of their leader is not heard of.
+func TestMultiNodeQuiescedLeaderDown(t *testing.T) {
+	nw := newMultiNodeNetwork(t, 3, 200)
+	defer nw.stop()
+	nw.elect()
+	nw.quiesce()
+
+	nw.isolated[1] = true
+	for i := 0; i < 40; i++ {
+		nw.tick()
+	}
+	for i, g := range nw.groups {
+		s2, s3 := nw.nodes[2].Status(g), nw.nodes[3].Status(g)
+		if s2.Lead != s3.Lead || s2.Lead == None || s2.Lead == 1 {
+			t.Errorf("group %d: lead = %d, %d, want the same leader other than 1", g, s2.Lead, s3.Lead)
+		}
+		// the groups which were not led by node 1 keep their leader
+		if i%3 != 0 && s2.Lead != nw.ids[i%3] {
+			t.Errorf("group %d: lead = %d, want %d", g, s2.Lead, nw.ids[i%3])
+		}
+	}
+}
+
+// TestMultiNodeReadIndex ensures that the followers of a group get read
+// states through ReadIndex, even if the group is quiesced.
+func TestMultiNodeReadIndex(t *testing.T) {
+	nw := newMultiNodeNetwork(t, 3, 200)
+	defer nw.stop()
+	nw.elect()
+	nw.quiesce()
+
+	// group 1 is led by node 1, and group 2 by node 2
+	for i, g := range []uint64{1, 2} {
+		rctx := []byte{byte(g)}
+		if err := nw.nodes[3].ReadIndex(context.TODO(), g, rctx); err != nil {
+			t.Fatal(err)
+		}
+		nw.process()
+		s := nw.nodes[3].Status(g)
+		wrs := ReadState{Index: s.Commit, RequestCtx: rctx}
+		if len(nw.readStates[3]) != i+1 || !reflect.DeepEqual(nw.readStates[3][i], wrs) {
+			t.Errorf("group %d: readStates = %+v, want last %+v", g, nw.readStates[3], wrs)
+		}
+	}
+}
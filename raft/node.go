@@ -33,6 +33,10 @@ var (
 
 	// ErrStopped is returned by methods on Nodes that have been stopped.
 	ErrStopped = errors.New("raft: stopped")
+	// ErrProposalDropped is returned when a proposal is dropped because
+	// too many entries are uncommitted. The application should retry
+	// later, at a lower rate.
+	ErrProposalDropped = errors.New("raft: proposal dropped")
 )
 
 // SoftState provides state that is useful for logging and debugging.
@@ -74,9 +78,16 @@ type Ready struct {
 
 	// Messages specifies outbound messages to be sent AFTER Entries are
 	// committed to stable storage.
+	// If Config.ParallelPersist is set, the Messages of a leader may be sent
+	// while its Entries are committed to stable storage.
 	// If it contains a MsgSnap message, the application MUST report back to raft
 	// when the snapshot has been received or has failed by calling ReportSnapshot.
 	Messages []pb.Message
+
+	// ReadStates are the states of the read only requests made through
+	// ReadIndex which can be served once the entries up to their Index
+	// are applied.
+	ReadStates []ReadState
 }
 
 func isHardStateEqual(a, b pb.HardState) bool {
@@ -96,7 +107,8 @@ func IsEmptySnap(sp pb.Snapshot) bool {
 func (rd Ready) containsUpdates() bool {
 	return rd.SoftState != nil || !IsEmptyHardState(rd.HardState) ||
 		!IsEmptySnap(rd.Snapshot) || len(rd.Entries) > 0 ||
-		len(rd.CommittedEntries) > 0 || len(rd.Messages) > 0
+		len(rd.CommittedEntries) > 0 || len(rd.Messages) > 0 ||
+		len(rd.ReadStates) > 0
 }
 
 // Node represents a node in a raft cluster.
@@ -106,14 +118,34 @@ type Node interface {
 	Tick()
 	// Campaign causes the Node to transition to candidate state and start campaigning to become leader.
 	Campaign(ctx context.Context) error
-	// Propose proposes that data be appended to the log.
+	// Propose proposes that data be appended to the log. It returns
+	// ErrProposalDropped if the local node is the leader and
+	// Config.MaxUncommittedEntriesSize is exceeded. Proposals may
+	// otherwise be dropped silently, e.g. when there is no leader or
+	// when they are forwarded to the leader.
+	// Concurrent proposals are coalesced into fewer messages.
 	Propose(ctx context.Context, data []byte) error
 	// ProposeConfChange proposes config change.
 	// At most one ConfChange can be in the process of going through consensus.
 	// Application needs to call ApplyConfChange when applying EntryConfChange type entry.
 	ProposeConfChange(ctx context.Context, cc pb.ConfChange) error
+	// ProposeConfChangeV2 proposes a config change made of several changes,
+	// which are applied at once through joint consensus: until the joint
+	// configuration is left, entries are committed and leaders elected by
+	// majorities of both the previous and the new configurations.
+	// A ConfChangeV2 without changes leaves the joint configuration; it only
+	// needs to be proposed if the transition is ConfChangeTransitionJointExplicit.
+	// Application needs to call ApplyConfChangeV2 when applying EntryConfChangeV2 type entry.
+	ProposeConfChangeV2(ctx context.Context, cc pb.ConfChangeV2) error
 	// Step advances the state machine using the given message. ctx.Err() will be returned, if any.
 	Step(ctx context.Context, msg pb.Message) error
+	// ReadIndex requests a read state for a linearizable read. The read state
+	// is returned in Ready.ReadStates once the leader confirmed that it is
+	// still the leader, which takes a round of heartbeats. rctx identifies
+	// the request; it should be unique among the pending requests.
+	// The request might be dropped silently, e.g. when there is no leader,
+	// in which case the application should retry it.
+	ReadIndex(ctx context.Context, rctx []byte) error
 	// Ready returns a channel that returns the current point-in-time state
 	// Users of the Node must call Advance after applying the state returned by Ready
 	Ready() <-chan Ready
@@ -125,6 +157,10 @@ type Node interface {
 	// in snapshots. Will never return nil; it returns a pointer only
 	// to match MemoryStorage.Compact.
 	ApplyConfChange(cc pb.ConfChange) *pb.ConfState
+	// ApplyConfChangeV2 is like ApplyConfChange for a ConfChangeV2. The
+	// returned ConfState carries the voters of both configurations while
+	// in joint consensus.
+	ApplyConfChangeV2(cc pb.ConfChangeV2) *pb.ConfState
 	// Status returns the current status of the raft state machine.
 	Status() Status
 	// Report reports the given node is not reachable for the last send.
@@ -191,11 +227,18 @@ func RestartNode(c *Config) Node {
 	return &n
 }
 
+// msgWithResult is a proposal along with the channel on which
+// the result of stepping it into raft is returned.
+type msgWithResult struct {
+	m      pb.Message
+	result chan error
+}
+
 // node is the canonical implementation of the Node interface
 type node struct {
-	propc      chan pb.Message
+	propc      chan msgWithResult
 	recvc      chan pb.Message
-	confc      chan pb.ConfChange
+	confc      chan pb.ConfChangeV2
 	confstatec chan pb.ConfState
 	readyc     chan Ready
 	advancec   chan struct{}
@@ -207,9 +250,9 @@ type node struct {
 
 func newNode() node {
 	return node{
-		propc:      make(chan pb.Message),
+		propc:      make(chan msgWithResult),
 		recvc:      make(chan pb.Message),
-		confc:      make(chan pb.ConfChange),
+		confc:      make(chan pb.ConfChangeV2),
 		confstatec: make(chan pb.ConfState),
 		readyc:     make(chan Ready),
 		advancec:   make(chan struct{}),
@@ -233,7 +276,7 @@ func (n *node) Stop() {
 }
 
 func (n *node) run(r *raft) {
-	var propc chan pb.Message
+	var propc chan msgWithResult
 	var readyc chan Ready
 	var advancec chan struct{}
 	var prevLastUnstablei, prevLastUnstablet uint64
@@ -276,40 +319,28 @@ func (n *node) run(r *raft) {
 		// TODO: maybe buffer the config propose if there exists one (the way
 		// described in raft dissertation)
 		// Currently it is dropped in Step silently.
-		case m := <-propc:
+		case pm := <-propc:
+			m, results := n.coalesceProposals(pm, r.maxMsgSize)
 			m.From = r.id
-			r.Step(m)
+			err := r.Step(m)
+			for _, c := range results {
+				c <- err
+			}
 		case m := <-n.recvc:
 			// filter out response message from unknown From.
 			if _, ok := r.prs[m.From]; ok || !IsResponseMsg(m) {
 				r.Step(m) // raft never returns an error
 			}
 		case cc := <-n.confc:
-			if cc.NodeID == None {
-				r.resetPendingConf()
-				select {
-				case n.confstatec <- pb.ConfState{Nodes: r.nodes()}:
-				case <-n.done:
-				}
-				break
-			}
-			switch cc.Type {
-			case pb.ConfChangeAddNode:
-				r.addNode(cc.NodeID)
-			case pb.ConfChangeRemoveNode:
-				// block incoming proposal when local node is
-				// removed
-				if cc.NodeID == r.id {
-					n.propc = nil
-				}
-				r.removeNode(cc.NodeID)
-			case pb.ConfChangeUpdateNode:
-				r.resetPendingConf()
-			default:
-				panic("unexpected conf type")
+			_, member := r.prs[r.id]
+			r.applyConfChangeV2(cc)
+			// block incoming proposal when local node is
+			// removed
+			if _, ok := r.prs[r.id]; member && !ok {
+				n.propc = nil
 			}
 			select {
-			case n.confstatec <- pb.ConfState{Nodes: r.nodes()}:
+			case n.confstatec <- r.confState():
 			case <-n.done:
 			}
 		case <-n.tickc:
@@ -329,14 +360,16 @@ func (n *node) run(r *raft) {
 			if !IsEmptySnap(rd.Snapshot) {
 				prevSnapi = rd.Snapshot.Metadata.Index
 			}
+			r.reduceUncommittedSize(rd.CommittedEntries)
 			r.msgs = nil
+			r.readStates = nil
 			advancec = n.advancec
 		case <-advancec:
 			if prevHardSt.Commit != 0 {
 				r.raftLog.appliedTo(prevHardSt.Commit)
 			}
 			if havePrevLastUnstablei {
-				r.raftLog.stableTo(prevLastUnstablei, prevLastUnstablet)
+				r.stableTo(prevLastUnstablei, prevLastUnstablet)
 				havePrevLastUnstablei = false
 			}
 			r.raftLog.stableSnapTo(prevSnapi)
@@ -350,6 +383,31 @@ func (n *node) run(r *raft) {
 	}
 }
 
+// coalesceProposals merges the proposals that are already waiting on propc
+// into the given one, as long as the payloads of the merged message do not
+// exceed maxSize. It returns the merged message along with the channels on
+// which the result is expected.
+func (n *node) coalesceProposals(pm msgWithResult, maxSize uint64) (pb.Message, []chan error) {
+	m, results := pm.m, []chan error{pm.result}
+	var size uint64
+	for _, e := range m.Entries {
+		size += payloadSize(e)
+	}
+	for size < maxSize {
+		select {
+		case pm := <-n.propc:
+			m.Entries = append(m.Entries, pm.m.Entries...)
+			results = append(results, pm.result)
+			for _, e := range pm.m.Entries {
+				size += payloadSize(e)
+			}
+		default:
+			return m, results
+		}
+	}
+	return m, results
+}
+
 // Tick increments the internal logical clock for this Node. Election timeouts
 // and heartbeat timeouts are in units of ticks.
 func (n *node) Tick() {
@@ -365,6 +423,10 @@ func (n *node) Propose(ctx context.Context, data []byte) error {
 	return n.step(ctx, pb.Message{Type: pb.MsgProp, Entries: []pb.Entry{{Data: data}}})
 }
 
+func (n *node) ReadIndex(ctx context.Context, rctx []byte) error {
+	return n.step(ctx, pb.Message{Type: pb.MsgReadIndex, Entries: []pb.Entry{{Data: rctx}}})
+}
+
 func (n *node) Step(ctx context.Context, m pb.Message) error {
 	// ignore unexpected local messages receiving over network
 	if IsLocalMsg(m) {
@@ -382,16 +444,23 @@ func (n *node) ProposeConfChange(ctx context.Context, cc pb.ConfChange) error {
 	return n.Step(ctx, pb.Message{Type: pb.MsgProp, Entries: []pb.Entry{{Type: pb.EntryConfChange, Data: data}}})
 }
 
+func (n *node) ProposeConfChangeV2(ctx context.Context, cc pb.ConfChangeV2) error {
+	data, err := cc.Marshal()
+	if err != nil {
+		return err
+	}
+	return n.Step(ctx, pb.Message{Type: pb.MsgProp, Entries: []pb.Entry{{Type: pb.EntryConfChangeV2, Data: data}}})
+}
+
 // Step advances the state machine using msgs. The ctx.Err() will be returned,
 // if any.
 func (n *node) step(ctx context.Context, m pb.Message) error {
-	ch := n.recvc
 	if m.Type == pb.MsgProp {
-		ch = n.propc
+		return n.propose(ctx, m)
 	}
 
 	select {
-	case ch <- m:
+	case n.recvc <- m:
 		return nil
 	case <-ctx.Done():
 		return ctx.Err()
@@ -400,6 +469,28 @@ func (n *node) step(ctx context.Context, m pb.Message) error {
 	}
 }
 
+// propose steps the given proposal and waits for raft to accept
+// or drop it.
+func (n *node) propose(ctx context.Context, m pb.Message) error {
+	pm := msgWithResult{m: m, result: make(chan error, 1)}
+	select {
+	case n.propc <- pm:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-n.done:
+		return ErrStopped
+	}
+
+	select {
+	case err := <-pm.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-n.done:
+		return ErrStopped
+	}
+}
+
 func (n *node) Ready() <-chan Ready { return n.readyc }
 
 func (n *node) Advance() {
@@ -410,6 +501,10 @@ func (n *node) Advance() {
 }
 
 func (n *node) ApplyConfChange(cc pb.ConfChange) *pb.ConfState {
+	return n.ApplyConfChangeV2(cc.AsV2())
+}
+
+func (n *node) ApplyConfChangeV2(cc pb.ConfChangeV2) *pb.ConfState {
 	var cs pb.ConfState
 	select {
 	case n.confc <- cc:
@@ -424,8 +519,12 @@ func (n *node) ApplyConfChange(cc pb.ConfChange) *pb.ConfState {
 
 func (n *node) Status() Status {
 	c := make(chan Status)
-	n.status <- c
-	return <-c
+	select {
+	case n.status <- c:
+		return <-c
+	case <-n.done:
+		return Status{}
+	}
 }
 
 func (n *node) ReportUnreachable(id uint64) {
@@ -449,6 +548,7 @@ func newReady(r *raft, prevSoftSt *SoftState, prevHardSt pb.HardState) Ready {
 		Entries:          r.raftLog.unstableEntries(),
 		CommittedEntries: r.raftLog.nextEnts(),
 		Messages:         r.msgs,
+		ReadStates:       r.readStates,
 	}
 	if softSt := r.softState(); !softSt.equal(prevSoftSt) {
 		rd.SoftState = softSt
@@ -15,10 +15,13 @@
 package raft
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	pb "github.com/coreos/etcd/raft/raftpb"
 )
 
 func BenchmarkOneNode(b *testing.B) {
@@ -50,3 +53,145 @@ func BenchmarkOneNode(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkOneNodeSerial(b *testing.B)       { benchmarkNodes(b, 1, false, 256) }
+func BenchmarkOneNodePipelined(b *testing.B)    { benchmarkNodes(b, 1, true, 256) }
+func BenchmarkThreeNodesSerial(b *testing.B)    { benchmarkNodes(b, 3, false, 256) }
+func BenchmarkThreeNodesPipelined(b *testing.B) { benchmarkNodes(b, 3, true, 256) }
+
+// With a single proposal in flight, the time per operation is the latency
+// of a proposal, from its proposal to its application by the leader.
+func BenchmarkThreeNodesSerialLatency(b *testing.B)    { benchmarkNodes(b, 3, false, 1) }
+func BenchmarkThreeNodesPipelinedLatency(b *testing.B) { benchmarkNodes(b, 3, true, 1) }
+
+// benchmarkNodes measures the throughput of a cluster of the given size,
+// whose nodes take a reasonable disk sync latency to persist each Ready and
+// as much to apply its committed entries, for the given number of proposals
+// in flight. If pipelined, the leader sends its messages while persisting
+// its entries, and committed entries are applied by another goroutine while
+// the following Ready are persisted.
+func benchmarkNodes(b *testing.B, size int, pipelined bool, inflight int) {
+	const latency = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peers := make([]Peer, size)
+	for i := range peers {
+		peers[i] = Peer{ID: uint64(i + 1)}
+	}
+	nodes := make([]Node, size)
+	storages := make([]*MemoryStorage, size)
+	for i := range nodes {
+		storages[i] = NewMemoryStorage()
+		c := &Config{
+			ID:              uint64(i + 1),
+			ElectionTick:    10,
+			HeartbeatTick:   1,
+			Storage:         storages[i],
+			MaxSizePerMsg:   noLimit,
+			MaxInflightMsgs: 256,
+			ParallelPersist: pipelined,
+		}
+		nodes[i] = StartNode(c, peers)
+	}
+
+	var applied int64
+	appliedc := make(chan struct{})
+	proposalc := make(chan struct{}, inflight)
+	var wg sync.WaitGroup
+	for i := range nodes {
+		wg.Add(1)
+		go func(n Node, s *MemoryStorage) {
+			defer wg.Done()
+			applyc := make(chan []pb.Entry, 64)
+			defer close(applyc)
+			apply := func(ents []pb.Entry) {
+				time.Sleep(latency)
+				if n != nodes[0] {
+					return
+				}
+				for _, e := range ents {
+					if e.Type != pb.EntryNormal || len(e.Data) == 0 {
+						continue
+					}
+					<-proposalc
+					if atomic.AddInt64(&applied, 1) == int64(b.N) {
+						close(appliedc)
+					}
+				}
+			}
+			if pipelined {
+				go func() {
+					for ents := range applyc {
+						apply(ents)
+					}
+				}()
+			}
+			send := func(msgs []pb.Message) {
+				for _, m := range msgs {
+					nodes[m.To-1].Step(ctx, m)
+				}
+			}
+
+			var lead bool
+			for {
+				select {
+				case rd := <-n.Ready():
+					if rd.SoftState != nil {
+						lead = rd.RaftState == StateLeader
+					}
+					parallel := pipelined && lead
+					if parallel {
+						send(rd.Messages)
+					}
+					time.Sleep(latency)
+					if !IsEmptyHardState(rd.HardState) {
+						s.SetHardState(rd.HardState)
+					}
+					s.Append(rd.Entries)
+					if !parallel {
+						send(rd.Messages)
+					}
+					if len(rd.CommittedEntries) != 0 {
+						if pipelined {
+							applyc <- rd.CommittedEntries
+						} else {
+							apply(rd.CommittedEntries)
+						}
+					}
+					n.Advance()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(nodes[i], storages[i])
+	}
+
+	nodes[0].Campaign(ctx)
+	for nodes[0].Status().RaftState != StateLeader {
+		time.Sleep(latency)
+	}
+
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			select {
+			case proposalc <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			if err := nodes[0].Propose(ctx, []byte("foo")); err != nil {
+				return
+			}
+		}
+	}()
+	<-appliedc
+	b.StopTimer()
+
+	cancel()
+	wg.Wait()
+	for _, n := range nodes {
+		n.Stop()
+	}
+}
@@ -29,19 +29,22 @@ import (
 func TestNodeStep(t *testing.T) {
 	for i, msgn := range raftpb.MessageType_name {
 		n := &node{
-			propc: make(chan raftpb.Message, 1),
+			propc: make(chan msgWithResult, 1),
 			recvc: make(chan raftpb.Message, 1),
 		}
 		msgt := raftpb.MessageType(i)
-		n.Step(context.TODO(), raftpb.Message{Type: msgt})
 		// Proposal goes to proc chan. Others go to recvc chan.
 		if msgt == raftpb.MsgProp {
+			// a proposal waits for its result
+			go n.Step(context.TODO(), raftpb.Message{Type: msgt})
 			select {
-			case <-n.propc:
-			default:
+			case pm := <-n.propc:
+				pm.result <- nil
+			case <-time.After(time.Second):
 				t.Errorf("%d: cannot receive %s on propc chan", msgt, msgn)
 			}
 		} else {
+			n.Step(context.TODO(), raftpb.Message{Type: msgt})
 			if msgt == raftpb.MsgBeat || msgt == raftpb.MsgHup || msgt == raftpb.MsgUnreachable || msgt == raftpb.MsgSnapStatus {
 				select {
 				case <-n.recvc:
@@ -63,7 +66,7 @@ func TestNodeStep(t *testing.T) {
 func TestNodeStepUnblock(t *testing.T) {
 	// a node without buffer to block step
 	n := &node{
-		propc: make(chan raftpb.Message),
+		propc: make(chan msgWithResult),
 		done:  make(chan struct{}),
 	}
 
@@ -108,8 +111,9 @@ func TestNodeStepUnblock(t *testing.T) {
 // TestNodePropose ensures that node.Propose sends the given proposal to the underlying raft.
 func TestNodePropose(t *testing.T) {
 	msgs := []raftpb.Message{}
-	appendStep := func(r *raft, m raftpb.Message) {
+	appendStep := func(r *raft, m raftpb.Message) error {
 		msgs = append(msgs, m)
+		return nil
 	}
 
 	n := newNode()
@@ -146,8 +150,9 @@ func TestNodePropose(t *testing.T) {
 // to the underlying raft.
 func TestNodeProposeConfig(t *testing.T) {
 	msgs := []raftpb.Message{}
-	appendStep := func(r *raft, m raftpb.Message) {
+	appendStep := func(r *raft, m raftpb.Message) error {
 		msgs = append(msgs, m)
+		return nil
 	}
 
 	n := newNode()
@@ -218,6 +223,58 @@ func TestBlockProposal(t *testing.T) {
 	}
 }
 
+// TestNodeCoalesceProposals ensures that the proposals waiting on propc are
+// merged into one message, up to the given size.
+func TestNodeCoalesceProposals(t *testing.T) {
+	n := &node{propc: make(chan msgWithResult, 3)}
+	for i := 0; i < 3; i++ {
+		n.propc <- msgWithResult{
+			m:      raftpb.Message{Type: raftpb.MsgProp, Entries: []raftpb.Entry{{Data: []byte("b")}}},
+			result: make(chan error, 1),
+		}
+	}
+	pm := msgWithResult{
+		m:      raftpb.Message{Type: raftpb.MsgProp, Entries: []raftpb.Entry{{Data: []byte("a")}}},
+		result: make(chan error, 1),
+	}
+
+	m, results := n.coalesceProposals(pm, 3)
+	if len(m.Entries) != 3 {
+		t.Errorf("len(entries) = %d, want %d", len(m.Entries), 3)
+	}
+	if len(results) != 3 || results[0] != pm.result {
+		t.Errorf("results = %v, want 3 results starting with %v", results, pm.result)
+	}
+	if len(n.propc) != 1 {
+		t.Errorf("len(propc) = %d, want %d", len(n.propc), 1)
+	}
+
+	m, results = n.coalesceProposals(pm, 0)
+	if len(m.Entries) != 1 || len(results) != 1 {
+		t.Errorf("len(entries), len(results) = %d, %d, want 1, 1", len(m.Entries), len(results))
+	}
+}
+
+// TestNodeProposeDropped ensures that node.Propose returns ErrProposalDropped
+// once too many entries are uncommitted.
+func TestNodeProposeDropped(t *testing.T) {
+	n := newNode()
+	s := NewMemoryStorage()
+	r := newTestRaft(1, []uint64{1, 2}, 10, 1, s)
+	r.maxUncommittedSize = 4
+	r.becomeCandidate()
+	r.becomeLeader()
+	go n.run(r)
+	defer n.Stop()
+
+	if err := n.Propose(context.TODO(), []byte("data")); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if err := n.Propose(context.TODO(), []byte("data")); err != ErrProposalDropped {
+		t.Fatalf("err = %v, want %v", err, ErrProposalDropped)
+	}
+}
+
 // TestNodeTick ensures that node.Tick() will increase the
 // elapsed of the underlying raft state machine.
 func TestNodeTick(t *testing.T) {
@@ -292,6 +349,45 @@ func TestReadyContainUpdates(t *testing.T) {
 // TestNodeStart ensures that a node can be started correctly. The node should
 // start with correct configuration change entries, and can accept and commit
 // proposals.
+// TestNodeReadIndex ensures that the read states of ReadIndex are returned
+// through Ready.
+func TestNodeReadIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := NewMemoryStorage()
+	c := &Config{
+		ID:              1,
+		ElectionTick:    10,
+		HeartbeatTick:   1,
+		Storage:         storage,
+		MaxSizePerMsg:   noLimit,
+		MaxInflightMsgs: 256,
+	}
+	n := StartNode(c, []Peer{{ID: 1}})
+	defer n.Stop()
+	n.Campaign(ctx)
+	rd := <-n.Ready()
+	storage.Append(rd.Entries)
+	n.Advance()
+
+	if err := n.ReadIndex(ctx, []byte("ctx")); err != nil {
+		t.Fatal(err)
+	}
+	rd = <-n.Ready()
+	wrs := []ReadState{{Index: 2, RequestCtx: []byte("ctx")}}
+	if !reflect.DeepEqual(rd.ReadStates, wrs) {
+		t.Errorf("readStates = %+v, want %+v", rd.ReadStates, wrs)
+	}
+	n.Advance()
+
+	select {
+	case rd := <-n.Ready():
+		t.Errorf("unexpected Ready: %+v", rd)
+	case <-time.After(time.Millisecond):
+	}
+}
+
 func TestNodeStart(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -468,6 +564,60 @@ func TestNodeAdvance(t *testing.T) {
 	}
 }
 
+// TestNodeParallelPersist ensures that with ParallelPersist the entries
+// proposed to a single node are committed once their Ready is advanced.
+func TestNodeParallelPersist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := NewMemoryStorage()
+	c := &Config{
+		ID:              1,
+		ElectionTick:    10,
+		HeartbeatTick:   1,
+		Storage:         storage,
+		MaxSizePerMsg:   noLimit,
+		MaxInflightMsgs: 256,
+		ParallelPersist: true,
+	}
+	n := StartNode(c, []Peer{{ID: 1}})
+	defer n.Stop()
+	n.Campaign(ctx)
+	for {
+		rd := <-n.Ready()
+		storage.Append(rd.Entries)
+		n.Advance()
+		if rd.SoftState != nil && rd.SoftState.RaftState == StateLeader {
+			break
+		}
+	}
+	n.Propose(ctx, []byte("foo"))
+
+	hasFoo := func(ents []raftpb.Entry) bool {
+		for _, e := range ents {
+			if reflect.DeepEqual(e.Data, []byte("foo")) {
+				return true
+			}
+		}
+		return false
+	}
+	persisted := false
+	for {
+		rd := <-n.Ready()
+		if hasFoo(rd.CommittedEntries) {
+			if !persisted {
+				t.Fatalf("committed entries = %+v, want the proposed entry not committed before being persisted", rd.CommittedEntries)
+			}
+			break
+		}
+		if hasFoo(rd.Entries) {
+			persisted = true
+		}
+		storage.Append(rd.Entries)
+		n.Advance()
+	}
+}
+
 func TestSoftStateEqual(t *testing.T) {
 	tests := []struct {
 		st *SoftState
@@ -165,6 +165,14 @@ func (pr *Progress) maybeSnapshotAbort() bool {
 	return pr.State == ProgressStateSnapshot && pr.Match >= pr.PendingSnapshot
 }
 
+// Inflight returns the number of in-flight append messages to the follower.
+func (pr Progress) Inflight() int {
+	if pr.ins == nil {
+		return 0
+	}
+	return pr.ins.count
+}
+
 func (pr *Progress) String() string {
 	return fmt.Sprintf("next = %d, match = %d, state = %s, waiting = %v, pendingSnapshot = %d", pr.Next, pr.Match, pr.State, pr.isPaused(), pr.PendingSnapshot)
 }
@@ -94,8 +94,34 @@ type Config struct {
 	// MaxInflightMsgs limits the max number of in-flight append messages during optimistic
 	// replication phase. The application transportation layer usually has its own sending
 	// buffer over TCP/UDP. Setting MaxInflightMsgs to avoid overflowing that sending buffer.
-	// TODO (xiangli): feedback to application to limit the proposal rate?
+	// The rate of proposals is limited by MaxUncommittedEntriesSize instead.
 	MaxInflightMsgs int
+	// MaxUncommittedEntriesSize limits the aggregate byte size of the
+	// uncommitted entries that may be appended to a leader's log. Once this
+	// limit is exceeded, proposals fail with ErrProposalDropped, which tells
+	// the application to slow down its rate of proposals.
+	// Note: 0 for no limit.
+	MaxUncommittedEntriesSize uint64
+
+	// ParallelPersist allows the application to send the Messages of a Ready
+	// while the leader persists its Entries, instead of after (see section
+	// 10.2.1 of the raft thesis). The leader then only counts its own entries
+	// towards the commit index once they are persisted, that is once the
+	// Ready holding them is acknowledged through Advance.
+	ParallelPersist bool
+
+	// EventListener, if set, receives the events of the raft, like
+	// elections, term changes and state changes of the followers' progress.
+	EventListener EventListener
+
+	// Quiesce allows a group of a MultiNode to stop ticking and heartbeating
+	// once it is idle, until it receives a message. It is ignored by Node.
+	Quiesce bool
+
+	// Witness marks the node as a witness, which votes in elections and
+	// acknowledges entries like any voter, but never campaigns, so that it
+	// never becomes leader.
+	Witness bool
 
 	// logger is the logger used for raft log. For multinode which
 	// can host multiple raft group, each raft group can have its
@@ -141,7 +167,25 @@ type raft struct {
 
 	maxInflight int
 	maxMsgSize  uint64
-	prs         map[uint64]*Progress
+	// uncommittedSize is the byte size of the payloads of the entries
+	// appended by the leader that are not known to be committed yet.
+	uncommittedSize    uint64
+	maxUncommittedSize uint64
+	// parallelPersist is set if the leader's own entries only count
+	// towards the commit index once they are persisted.
+	parallelPersist bool
+	// witness is set if the node never campaigns.
+	witness bool
+	// prs is the progress of the voters, which are the nodes of both the
+	// incoming and the outgoing configurations during joint consensus.
+	prs map[uint64]*Progress
+
+	// incoming and outgoing are the voters of the new and the previous
+	// configurations during joint consensus, and are nil otherwise.
+	incoming, outgoing map[uint64]bool
+	// autoLeave is set if the leader leaves the joint configuration on
+	// its own once it is applied.
+	autoLeave bool
 
 	state StateType
 
@@ -149,6 +193,11 @@ type raft struct {
 
 	msgs []pb.Message
 
+	// readOnly holds the read only requests waiting for the leadership
+	// to be confirmed, and readStates the requests which can be served.
+	readOnly   *readOnly
+	readStates []ReadState
+
 	// the leader id
 	lead uint64
 
@@ -162,6 +211,7 @@ type raft struct {
 	tick             func()
 	step             stepFunc
 
+	events EventListener
 	logger Logger
 }
 
@@ -182,7 +232,7 @@ func newRaft(c *Config) *raft {
 			// updated to specify their nodes through a snapshot.
 			panic("cannot specify both newRaft(peers) and ConfState.Nodes)")
 		}
-		peers = cs.Nodes
+		peers = append(append([]uint64{}, cs.Nodes...), cs.OutgoingNodes...)
 	}
 	r := &raft{
 		id:      c.ID,
@@ -191,17 +241,22 @@ func newRaft(c *Config) *raft {
 		// 4MB for now and hard code it
 		// TODO(xiang): add a config argument into newRaft after we add
 		// the max inflight message field.
-		maxMsgSize:       c.MaxSizePerMsg,
-		maxInflight:      c.MaxInflightMsgs,
-		prs:              make(map[uint64]*Progress),
-		electionTimeout:  c.ElectionTick,
-		heartbeatTimeout: c.HeartbeatTick,
-		logger:           c.Logger,
+		maxMsgSize:         c.MaxSizePerMsg,
+		maxInflight:        c.MaxInflightMsgs,
+		maxUncommittedSize: c.MaxUncommittedEntriesSize,
+		prs:                make(map[uint64]*Progress),
+		electionTimeout:    c.ElectionTick,
+		heartbeatTimeout:   c.HeartbeatTick,
+		parallelPersist:    c.ParallelPersist,
+		witness:            c.Witness,
+		events:             c.EventListener,
+		logger:             c.Logger,
 	}
 	r.rand = rand.New(rand.NewSource(int64(c.ID)))
 	for _, p := range peers {
 		r.prs[p] = &Progress{Next: 1, ins: newInflights(r.maxInflight)}
 	}
+	r.loadJoint(cs)
 	if !isHardStateEqual(hs, emptyState) {
 		r.loadState(hs)
 	}
@@ -226,6 +281,21 @@ func (r *raft) softState() *SoftState { return &SoftState{Lead: r.lead, RaftStat
 
 func (r *raft) q() int { return len(r.prs)/2 + 1 }
 
+func (r *raft) isJoint() bool { return r.outgoing != nil }
+
+// voterSets returns the sets of voters which must each reach a majority to
+// commit an entry or to win an election.
+func (r *raft) voterSets() []map[uint64]bool {
+	if r.isJoint() {
+		return []map[uint64]bool{r.incoming, r.outgoing}
+	}
+	voters := make(map[uint64]bool, len(r.prs))
+	for id := range r.prs {
+		voters[id] = true
+	}
+	return []map[uint64]bool{voters}
+}
+
 func (r *raft) nodes() []uint64 {
 	nodes := make([]uint64, 0, len(r.prs))
 	for k := range r.prs {
@@ -238,10 +308,10 @@ func (r *raft) nodes() []uint64 {
 // send persists state to stable storage and then sends to its mailbox.
 func (r *raft) send(m pb.Message) {
 	m.From = r.id
-	// do not attach term to MsgProp
-	// proposals are a way to forward to the leader and
-	// should be treated as local message.
-	if m.Type != pb.MsgProp {
+	// do not attach term to MsgProp and MsgReadIndex
+	// proposals and read only requests are a way to forward to
+	// the leader and should be treated as local message.
+	if m.Type != pb.MsgProp && m.Type != pb.MsgReadIndex {
 		m.Term = r.Term
 	}
 	r.msgs = append(r.msgs, m)
@@ -272,7 +342,8 @@ func (r *raft) sendAppend(to uint64) {
 		sindex, sterm := snapshot.Metadata.Index, snapshot.Metadata.Term
 		r.logger.Debugf("%x [firstindex: %d, commit: %d] sent snapshot[index: %d, term: %d] to %x [%s]",
 			r.id, r.raftLog.firstIndex(), r.Commit, sindex, sterm, to, pr)
-		pr.becomeSnapshot(sindex)
+		r.becomeProgressState(to, ProgressStateSnapshot, sindex)
+		r.emit(Event{Type: EventSnapshotSend, Peer: to, Index: sindex})
 		r.logger.Debugf("%x paused sending replication messages to %x [%s]", r.id, to, pr)
 	} else {
 		m.Type = pb.MsgApp
@@ -298,7 +369,7 @@ func (r *raft) sendAppend(to uint64) {
 }
 
 // sendHeartbeat sends an empty MsgApp
-func (r *raft) sendHeartbeat(to uint64) {
+func (r *raft) sendHeartbeat(to uint64, ctx []byte) {
 	// Attach the commit as min(to.matched, r.committed).
 	// When the leader sends out heartbeat message,
 	// the receiver(follower) might not be matched with the leader
@@ -307,9 +378,10 @@ func (r *raft) sendHeartbeat(to uint64) {
 	// an unmatched index.
 	commit := min(r.prs[to].Match, r.raftLog.committed)
 	m := pb.Message{
-		To:     to,
-		Type:   pb.MsgHeartbeat,
-		Commit: commit,
+		To:      to,
+		Type:    pb.MsgHeartbeat,
+		Commit:  commit,
+		Context: ctx,
 	}
 	r.send(m)
 }
@@ -326,17 +398,28 @@ func (r *raft) bcastAppend() {
 }
 
 // bcastHeartbeat sends RRPC, without entries to all the peers.
+// bcastHeartbeat sends heartbeats to all peers. The heartbeats carry the
+// context of the last pending read only request, if any, so that they
+// confirm the leadership for it.
 func (r *raft) bcastHeartbeat() {
+	r.bcastHeartbeatWithCtx(r.readOnly.lastPendingRequestCtx())
+}
+
+func (r *raft) bcastHeartbeatWithCtx(ctx []byte) {
 	for i := range r.prs {
 		if i == r.id {
 			continue
 		}
-		r.sendHeartbeat(i)
+		r.sendHeartbeat(i, ctx)
 		r.prs[i].resume()
 	}
 }
 
 func (r *raft) maybeCommit() bool {
+	if r.isJoint() {
+		mci := min(r.quorumMatch(r.incoming), r.quorumMatch(r.outgoing))
+		return r.raftLog.maybeCommit(mci, r.Term)
+	}
 	// TODO(bmizerany): optimize.. Currently naive
 	mis := make(uint64Slice, 0, len(r.prs))
 	for i := range r.prs {
@@ -347,21 +430,37 @@ func (r *raft) maybeCommit() bool {
 	return r.raftLog.maybeCommit(mci, r.Term)
 }
 
+// quorumMatch returns the largest index matched by a majority of the given voters.
+func (r *raft) quorumMatch(voters map[uint64]bool) uint64 {
+	if len(voters) == 0 {
+		return noLimit
+	}
+	mis := make(uint64Slice, 0, len(voters))
+	for id := range voters {
+		mis = append(mis, r.prs[id].Match)
+	}
+	sort.Sort(sort.Reverse(mis))
+	return mis[len(mis)/2]
+}
+
 func (r *raft) reset(term uint64) {
 	if r.Term != term {
 		r.Term = term
 		r.Vote = None
+		r.emit(Event{Type: EventTermChange})
 	}
 	r.lead = None
 	r.elapsed = 0
 	r.votes = make(map[uint64]bool)
+	r.readOnly = newReadOnly()
 	for i := range r.prs {
 		r.prs[i] = &Progress{Next: r.raftLog.lastIndex() + 1, ins: newInflights(r.maxInflight)}
 		if i == r.id {
-			r.prs[i].Match = r.raftLog.lastIndex()
+			r.prs[i].Match = r.selfMatch()
 		}
 	}
 	r.pendingConf = false
+	r.uncommittedSize = 0
 }
 
 func (r *raft) appendEntry(es ...pb.Entry) {
@@ -371,10 +470,73 @@ func (r *raft) appendEntry(es ...pb.Entry) {
 		es[i].Index = li + 1 + uint64(i)
 	}
 	r.raftLog.append(es...)
-	r.prs[r.id].maybeUpdate(r.raftLog.lastIndex())
+	r.prs[r.id].maybeUpdate(r.selfMatch())
 	r.maybeCommit()
 }
 
+// increaseUncommittedSize accounts for the payloads of the given entries,
+// which the leader is about to append to its log. It returns false, and
+// does not account for them, if that would exceed maxUncommittedSize.
+// A proposal is always accepted when nothing is uncommitted, so that
+// entries larger than the limit cannot be stuck forever.
+func (r *raft) increaseUncommittedSize(ents []pb.Entry) bool {
+	var s uint64
+	for _, e := range ents {
+		s += payloadSize(e)
+	}
+	if r.maxUncommittedSize > 0 && r.uncommittedSize > 0 && r.uncommittedSize+s > r.maxUncommittedSize {
+		return false
+	}
+	r.uncommittedSize += s
+	return true
+}
+
+// reduceUncommittedSize releases the payloads of the given committed
+// entries. Entries appended by a previous leader were not accounted for,
+// so the size never goes below zero.
+func (r *raft) reduceUncommittedSize(ents []pb.Entry) {
+	if r.uncommittedSize == 0 {
+		return
+	}
+	var s uint64
+	for _, e := range ents {
+		s += payloadSize(e)
+	}
+	if s > r.uncommittedSize {
+		r.uncommittedSize = 0
+	} else {
+		r.uncommittedSize -= s
+	}
+}
+
+func payloadSize(e pb.Entry) uint64 { return uint64(len(e.Data)) }
+
+// selfMatch returns the last index of the local log which counts towards
+// the commit index.
+func (r *raft) selfMatch() uint64 {
+	if r.parallelPersist {
+		return r.raftLog.lastStableIndex()
+	}
+	return r.raftLog.lastIndex()
+}
+
+// stableTo marks the entries up to index i at term t as persisted. If the
+// leader's own entries only count once persisted, it may commit them.
+func (r *raft) stableTo(i, t uint64) {
+	r.raftLog.stableTo(i, t)
+	if !r.parallelPersist || r.state != StateLeader {
+		return
+	}
+	pr, ok := r.prs[r.id]
+	if !ok {
+		return
+	}
+	if pr.maybeUpdate(r.selfMatch()) && r.maybeCommit() {
+		r.bcastAppend()
+		r.Commit = r.raftLog.committed
+	}
+}
+
 // tickElection is run by followers and candidates after r.electionTimeout.
 func (r *raft) tickElection() {
 	if !r.promotable() {
@@ -404,6 +566,7 @@ func (r *raft) becomeFollower(term uint64, lead uint64) {
 	r.lead = lead
 	r.state = StateFollower
 	r.logger.Infof("%x became follower at term %d", r.id, r.Term)
+	r.emit(Event{Type: EventStateChange, State: r.state, Lead: r.lead})
 }
 
 func (r *raft) becomeCandidate() {
@@ -417,6 +580,7 @@ func (r *raft) becomeCandidate() {
 	r.Vote = r.id
 	r.state = StateCandidate
 	r.logger.Infof("%x became candidate at term %d", r.id, r.Term)
+	r.emit(Event{Type: EventStateChange, State: r.state, Lead: r.lead})
 }
 
 func (r *raft) becomeLeader() {
@@ -435,7 +599,7 @@ func (r *raft) becomeLeader() {
 	}
 
 	for _, e := range ents {
-		if e.Type != pb.EntryConfChange {
+		if e.Type != pb.EntryConfChange && e.Type != pb.EntryConfChangeV2 {
 			continue
 		}
 		if r.pendingConf {
@@ -445,11 +609,18 @@ func (r *raft) becomeLeader() {
 	}
 	r.appendEntry(pb.Entry{Data: nil})
 	r.logger.Infof("%x became leader at term %d", r.id, r.Term)
+	r.emit(Event{Type: EventStateChange, State: r.state, Lead: r.lead})
+	// the previous leader might have stepped down before leaving the
+	// joint configuration
+	if r.isJoint() && r.autoLeave && !r.pendingConf {
+		r.proposeLeaveJoint()
+	}
 }
 
 func (r *raft) campaign() {
 	r.becomeCandidate()
-	if r.q() == r.poll(r.id, true) {
+	r.emit(Event{Type: EventElection})
+	if r.poll(r.id, true); r.electionWon() {
 		r.becomeLeader()
 		return
 	}
@@ -480,8 +651,48 @@ func (r *raft) poll(id uint64, v bool) (granted int) {
 	return granted
 }
 
+// electionWon returns true if a majority of each set of voters granted
+// their votes.
+func (r *raft) electionWon() bool {
+	for _, voters := range r.voterSets() {
+		if granted, _ := r.countVotes(voters); len(voters) > 0 && granted < len(voters)/2+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// electionLost returns true if a majority of any set of voters rejected
+// their votes.
+func (r *raft) electionLost() bool {
+	for _, voters := range r.voterSets() {
+		if _, rejected := r.countVotes(voters); len(voters) > 0 && rejected >= len(voters)/2+1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *raft) countVotes(voters map[uint64]bool) (granted, rejected int) {
+	for id := range voters {
+		v, ok := r.votes[id]
+		switch {
+		case !ok:
+		case v:
+			granted++
+		default:
+			rejected++
+		}
+	}
+	return granted, rejected
+}
+
 func (r *raft) Step(m pb.Message) error {
 	if m.Type == pb.MsgHup {
+		if r.witness {
+			r.logger.Warningf("%x is a witness and cannot campaign at term %d", r.id, r.Term)
+			return nil
+		}
 		r.logger.Infof("%x is starting a new election at term %d", r.id, r.Term)
 		r.campaign()
 		r.Commit = r.raftLog.committed
@@ -505,14 +716,14 @@ func (r *raft) Step(m pb.Message) error {
 			r.id, r.Term, m.Type, m.From, m.Term)
 		return nil
 	}
-	r.step(r, m)
+	err := r.step(r, m)
 	r.Commit = r.raftLog.committed
-	return nil
+	return err
 }
 
-type stepFunc func(r *raft, m pb.Message)
+type stepFunc func(r *raft, m pb.Message) error
 
-func stepLeader(r *raft, m pb.Message) {
+func stepLeader(r *raft, m pb.Message) error {
 	pr := r.prs[m.From]
 
 	switch m.Type {
@@ -526,15 +737,25 @@ func stepLeader(r *raft, m pb.Message) {
 			// If we are not currently a member of the range (i.e. this node
 			// was removed from the configuration while serving as leader),
 			// drop any new proposals.
-			return
+			return nil
+		}
+		if !r.increaseUncommittedSize(m.Entries) {
+			r.logger.Debugf("%x dropped proposal: too many uncommitted entries (%d bytes)", r.id, r.uncommittedSize)
+			return ErrProposalDropped
 		}
 		for i, e := range m.Entries {
-			if e.Type == pb.EntryConfChange {
-				if r.pendingConf {
-					m.Entries[i] = pb.Entry{Type: pb.EntryNormal}
-				}
-				r.pendingConf = true
+			if e.Type != pb.EntryConfChange && e.Type != pb.EntryConfChangeV2 {
+				continue
+			}
+			if !r.pendingConf && !r.confChangeAllowed(e) {
+				r.logger.Infof("%x ignored conf change not allowed by the current configuration", r.id)
+				m.Entries[i] = pb.Entry{Type: pb.EntryNormal}
+				continue
+			}
+			if r.pendingConf {
+				m.Entries[i] = pb.Entry{Type: pb.EntryNormal}
 			}
+			r.pendingConf = true
 		}
 		r.appendEntry(m.Entries...)
 		r.bcastAppend()
@@ -545,7 +766,7 @@ func stepLeader(r *raft, m pb.Message) {
 			if pr.maybeDecrTo(m.Index, m.RejectHint) {
 				r.logger.Debugf("%x decreased progress of %x to [%s]", r.id, m.From, pr)
 				if pr.State == ProgressStateReplicate {
-					pr.becomeProbe()
+					r.becomeProgressState(m.From, ProgressStateProbe, 0)
 				}
 				r.sendAppend(m.From)
 			}
@@ -554,10 +775,10 @@ func stepLeader(r *raft, m pb.Message) {
 			if pr.maybeUpdate(m.Index) {
 				switch {
 				case pr.State == ProgressStateProbe:
-					pr.becomeReplicate()
+					r.becomeProgressState(m.From, ProgressStateReplicate, 0)
 				case pr.State == ProgressStateSnapshot && pr.maybeSnapshotAbort():
 					r.logger.Debugf("%x snapshot aborted, resumed sending replication messages to %x [%s]", r.id, m.From, pr)
-					pr.becomeProbe()
+					r.becomeProgressState(m.From, ProgressStateProbe, 0)
 				case pr.State == ProgressStateReplicate:
 					pr.ins.freeTo(m.Index)
 				}
@@ -579,20 +800,39 @@ func stepLeader(r *raft, m pb.Message) {
 		if pr.Match < r.raftLog.lastIndex() {
 			r.sendAppend(m.From)
 		}
+		if acks := r.readOnly.recvAck(m.From, m.Context); acks != nil && r.hasQuorum(acks) {
+			for _, rs := range r.readOnly.advance(m.Context) {
+				r.respondReadIndex(rs.req, rs.index)
+			}
+		}
+	case pb.MsgReadIndex:
+		if r.raftLog.zeroTermOnErrCompacted(r.raftLog.term(r.raftLog.committed)) != r.Term {
+			// the leader does not know the latest committed index until it
+			// commits an entry of its term.
+			r.logger.Debugf("%x dropped read index request: no entry committed at term %d", r.id, r.Term)
+			return nil
+		}
+		if r.hasQuorum(map[uint64]bool{r.id: true}) {
+			r.respondReadIndex(m, r.raftLog.committed)
+			return nil
+		}
+		r.readOnly.addRequest(r.raftLog.committed, m)
+		r.readOnly.recvAck(r.id, m.Entries[0].Data)
+		r.bcastHeartbeatWithCtx(m.Entries[0].Data)
 	case pb.MsgVote:
 		r.logger.Infof("%x [logterm: %d, index: %d, vote: %x] rejected vote from %x [logterm: %d, index: %d] at term %d",
 			r.id, r.raftLog.lastTerm(), r.raftLog.lastIndex(), r.Vote, m.From, m.LogTerm, m.Index, r.Term)
 		r.send(pb.Message{To: m.From, Type: pb.MsgVoteResp, Reject: true})
 	case pb.MsgSnapStatus:
 		if pr.State != ProgressStateSnapshot {
-			return
+			return nil
 		}
 		if !m.Reject {
-			pr.becomeProbe()
+			r.becomeProgressState(m.From, ProgressStateProbe, 0)
 			r.logger.Debugf("%x snapshot succeeded, resumed sending replication messages to %x [%s]", r.id, m.From, pr)
 		} else {
 			pr.snapshotFailure()
-			pr.becomeProbe()
+			r.becomeProgressState(m.From, ProgressStateProbe, 0)
 			r.logger.Debugf("%x snapshot failed, resumed sending replication messages to %x [%s]", r.id, m.From, pr)
 		}
 		// If snapshot finish, wait for the msgAppResp from the remote node before sending
@@ -603,17 +843,20 @@ func stepLeader(r *raft, m pb.Message) {
 		// During optimistic replication, if the remote becomes unreachable,
 		// there is huge probability that a MsgApp is lost.
 		if pr.State == ProgressStateReplicate {
-			pr.becomeProbe()
+			r.becomeProgressState(m.From, ProgressStateProbe, 0)
 		}
 		r.logger.Debugf("%x failed to send message to %x because it is unreachable [%s]", r.id, m.From, pr)
 	}
+	return nil
 }
 
-func stepCandidate(r *raft, m pb.Message) {
+func stepCandidate(r *raft, m pb.Message) error {
 	switch m.Type {
 	case pb.MsgProp:
 		r.logger.Infof("%x no leader at term %d; dropping proposal", r.id, r.Term)
-		return
+		return nil
+	case pb.MsgReadIndex:
+		r.logger.Infof("%x no leader at term %d; dropping read index request", r.id, r.Term)
 	case pb.MsgApp:
 		r.becomeFollower(r.Term, m.From)
 		r.handleAppendEntries(m)
@@ -630,25 +873,39 @@ func stepCandidate(r *raft, m pb.Message) {
 	case pb.MsgVoteResp:
 		gr := r.poll(m.From, !m.Reject)
 		r.logger.Infof("%x [q:%d] has received %d votes and %d vote rejections", r.id, r.q(), gr, len(r.votes)-gr)
-		switch r.q() {
-		case gr:
+		switch {
+		case r.electionWon():
 			r.becomeLeader()
 			r.bcastAppend()
-		case len(r.votes) - gr:
+		case r.electionLost():
 			r.becomeFollower(r.Term, None)
 		}
 	}
+	return nil
 }
 
-func stepFollower(r *raft, m pb.Message) {
+func stepFollower(r *raft, m pb.Message) error {
 	switch m.Type {
 	case pb.MsgProp:
 		if r.lead == None {
 			r.logger.Infof("%x no leader at term %d; dropping proposal", r.id, r.Term)
-			return
+			return nil
 		}
 		m.To = r.lead
 		r.send(m)
+	case pb.MsgReadIndex:
+		if r.lead == None {
+			r.logger.Infof("%x no leader at term %d; dropping read index request", r.id, r.Term)
+			return nil
+		}
+		m.To = r.lead
+		r.send(m)
+	case pb.MsgReadIndexResp:
+		if len(m.Entries) != 1 {
+			r.logger.Errorf("%x invalid format of MsgReadIndexResp from %x, entries count: %d", r.id, m.From, len(m.Entries))
+			return nil
+		}
+		r.readStates = append(r.readStates, ReadState{Index: m.Index, RequestCtx: m.Entries[0].Data})
 	case pb.MsgApp:
 		r.elapsed = 0
 		r.lead = m.From
@@ -673,6 +930,7 @@ func stepFollower(r *raft, m pb.Message) {
 			r.send(pb.Message{To: m.From, Type: pb.MsgVoteResp, Reject: true})
 		}
 	}
+	return nil
 }
 
 func (r *raft) handleAppendEntries(m pb.Message) {
@@ -692,7 +950,7 @@ func (r *raft) handleAppendEntries(m pb.Message) {
 
 func (r *raft) handleHeartbeat(m pb.Message) {
 	r.raftLog.commitTo(m.Commit)
-	r.send(pb.Message{To: m.From, Type: pb.MsgHeartbeatResp})
+	r.send(pb.Message{To: m.From, Type: pb.MsgHeartbeatResp, Context: m.Context})
 }
 
 func (r *raft) handleSnapshot(m pb.Message) {
@@ -726,7 +984,8 @@ func (r *raft) restore(s pb.Snapshot) bool {
 
 	r.raftLog.restore(s)
 	r.prs = make(map[uint64]*Progress)
-	for _, n := range s.Metadata.ConfState.Nodes {
+	cs := s.Metadata.ConfState
+	for _, n := range append(append([]uint64{}, cs.Nodes...), cs.OutgoingNodes...) {
 		match, next := uint64(0), uint64(r.raftLog.lastIndex())+1
 		if n == r.id {
 			match = next - 1
@@ -736,14 +995,15 @@ func (r *raft) restore(s pb.Snapshot) bool {
 		r.setProgress(n, match, next)
 		r.logger.Infof("%x restored progress of %x [%s]", r.id, n, r.prs[n])
 	}
+	r.loadJoint(cs)
 	return true
 }
 
 // promotable indicates whether state machine can be promoted to leader,
-// which is true when its own id is in progress list.
+// which is true when its own id is in progress list and it is not a witness.
 func (r *raft) promotable() bool {
 	_, ok := r.prs[r.id]
-	return ok
+	return ok && !r.witness
 }
 
 func (r *raft) addNode(id uint64) {
@@ -754,16 +1014,217 @@ func (r *raft) addNode(id uint64) {
 	}
 
 	r.setProgress(id, 0, r.raftLog.lastIndex()+1)
+	if r.isJoint() {
+		r.incoming[id] = true
+		r.outgoing[id] = true
+	}
 	r.pendingConf = false
 }
 
 func (r *raft) removeNode(id uint64) {
 	r.delProgress(id)
+	if r.isJoint() {
+		delete(r.incoming, id)
+		delete(r.outgoing, id)
+	}
+	r.pendingConf = false
+}
+
+// applyConfChangeV2 applies the given ConfChangeV2, either directly if it
+// carries a single change, or by entering or leaving joint consensus.
+// Changes on the None node only reset the pending configuration.
+func (r *raft) applyConfChangeV2(cc pb.ConfChangeV2) {
+	autoLeave, joint := cc.EnterJoint()
+	switch {
+	case cc.LeaveJoint():
+		r.leaveJoint()
+	case joint:
+		r.enterJoint(autoLeave, cc.Changes)
+	default:
+		c := cc.Changes[0]
+		if c.NodeID == None {
+			break
+		}
+		switch c.Type {
+		case pb.ConfChangeAddNode:
+			r.addNode(c.NodeID)
+		case pb.ConfChangeRemoveNode:
+			r.removeNode(c.NodeID)
+		case pb.ConfChangeUpdateNode:
+		default:
+			r.logger.Panicf("%x unexpected conf change type %s", r.id, c.Type)
+		}
+	}
 	r.pendingConf = false
+
+	if r.isJoint() && r.autoLeave && r.state == StateLeader {
+		r.proposeLeaveJoint()
+	}
+}
+
+// enterJoint enters joint consensus: the incoming configuration is the
+// current one with the given changes applied, and entries are committed
+// and leaders elected by majorities of both configurations.
+func (r *raft) enterJoint(autoLeave bool, changes []pb.ConfChangeSingle) {
+	if r.isJoint() {
+		r.logger.Warningf("%x ignored entering a joint configuration while in one", r.id)
+		return
+	}
+	r.incoming = make(map[uint64]bool, len(r.prs))
+	r.outgoing = make(map[uint64]bool, len(r.prs))
+	for id := range r.prs {
+		r.incoming[id] = true
+		r.outgoing[id] = true
+	}
+	for _, c := range changes {
+		if c.NodeID == None {
+			continue
+		}
+		switch c.Type {
+		case pb.ConfChangeAddNode:
+			r.incoming[c.NodeID] = true
+			if _, ok := r.prs[c.NodeID]; !ok {
+				r.setProgress(c.NodeID, 0, r.raftLog.lastIndex()+1)
+			}
+		case pb.ConfChangeRemoveNode:
+			delete(r.incoming, c.NodeID)
+		case pb.ConfChangeUpdateNode:
+		default:
+			r.logger.Panicf("%x unexpected conf change type %s", r.id, c.Type)
+		}
+	}
+	r.autoLeave = autoLeave
+	r.logger.Infof("%x entered joint configuration [incoming: %x, outgoing: %x]", r.id, setIDs(r.incoming), setIDs(r.outgoing))
+}
+
+// leaveJoint leaves joint consensus for the incoming configuration.
+func (r *raft) leaveJoint() {
+	if !r.isJoint() {
+		return
+	}
+	for id := range r.outgoing {
+		if !r.incoming[id] {
+			r.delProgress(id)
+		}
+	}
+	r.incoming, r.outgoing, r.autoLeave = nil, nil, false
+	r.logger.Infof("%x left joint configuration [voters: %x]", r.id, r.nodes())
+}
+
+// proposeLeaveJoint appends an entry which leaves the joint configuration.
+func (r *raft) proposeLeaveJoint() {
+	data, err := (&pb.ConfChangeV2{}).Marshal()
+	if err != nil {
+		r.logger.Panicf("%x unexpected marshal error (%v)", r.id, err)
+	}
+	r.appendEntry(pb.Entry{Type: pb.EntryConfChangeV2, Data: data})
+	r.bcastAppend()
+	r.pendingConf = true
+	r.logger.Infof("%x proposed to leave the joint configuration", r.id)
+}
+
+// confChangeAllowed returns true if the given conf change entry can be
+// proposed in the current configuration: joint consensus can only be left
+// once entered, and no other change is allowed meanwhile.
+func (r *raft) confChangeAllowed(e pb.Entry) bool {
+	if e.Type == pb.EntryConfChange {
+		return !r.isJoint()
+	}
+	var cc pb.ConfChangeV2
+	if err := cc.Unmarshal(e.Data); err != nil {
+		return false
+	}
+	return cc.LeaveJoint() == r.isJoint()
+}
+
+// confState returns the current configuration.
+func (r *raft) confState() pb.ConfState {
+	if !r.isJoint() {
+		return pb.ConfState{Nodes: r.nodes()}
+	}
+	return pb.ConfState{
+		Nodes:         setIDs(r.incoming),
+		OutgoingNodes: setIDs(r.outgoing),
+		AutoLeave:     r.autoLeave,
+	}
+}
+
+// loadJoint restores the joint configuration described by the given
+// ConfState, if any.
+func (r *raft) loadJoint(cs pb.ConfState) {
+	r.incoming, r.outgoing, r.autoLeave = nil, nil, false
+	if len(cs.OutgoingNodes) == 0 {
+		return
+	}
+	r.incoming = make(map[uint64]bool, len(cs.Nodes))
+	for _, id := range cs.Nodes {
+		r.incoming[id] = true
+	}
+	r.outgoing = make(map[uint64]bool, len(cs.OutgoingNodes))
+	for _, id := range cs.OutgoingNodes {
+		r.outgoing[id] = true
+	}
+	r.autoLeave = cs.AutoLeave
 }
 
 func (r *raft) resetPendingConf() { r.pendingConf = false }
 
+// becomeProgressState moves the progress of the given peer to the given
+// state. snapshoti is the index of the pending snapshot when moving to
+// ProgressStateSnapshot. An event is emitted only if the state changes.
+func (r *raft) becomeProgressState(id uint64, state ProgressStateType, snapshoti uint64) {
+	pr := r.prs[id]
+	prev := pr.State
+	switch state {
+	case ProgressStateProbe:
+		pr.becomeProbe()
+	case ProgressStateReplicate:
+		pr.becomeReplicate()
+	case ProgressStateSnapshot:
+		pr.becomeSnapshot(snapshoti)
+	}
+	if pr.State != prev {
+		r.emit(Event{Type: EventProgressStateChange, Peer: id, ProgressState: state, Index: pr.Match})
+	}
+}
+
+// hasQuorum returns true if the given nodes are a majority of the voters
+// (of both configurations during joint consensus).
+func (r *raft) hasQuorum(ids map[uint64]bool) bool {
+	for _, voters := range r.voterSets() {
+		n := 0
+		for id := range voters {
+			if ids[id] {
+				n++
+			}
+		}
+		if n < len(voters)/2+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// respondReadIndex serves the given read only request at the given index,
+// by appending its ReadState to readStates if it was received locally, or
+// by responding to the follower which forwarded it.
+func (r *raft) respondReadIndex(req pb.Message, index uint64) {
+	if req.From == None || req.From == r.id {
+		r.readStates = append(r.readStates, ReadState{Index: index, RequestCtx: req.Entries[0].Data})
+		return
+	}
+	r.send(pb.Message{To: req.From, Type: pb.MsgReadIndexResp, Index: index, Entries: req.Entries})
+}
+
+// emit sends the given event to the EventListener, if any.
+func (r *raft) emit(e Event) {
+	if r.events == nil {
+		return
+	}
+	e.Term = r.Term
+	r.events.HandleEvent(e)
+}
+
 func (r *raft) setProgress(id, match, next uint64) {
 	r.prs[id] = &Progress{Next: next, Match: match, ins: newInflights(r.maxInflight)}
 }
@@ -153,3 +153,24 @@ func TestMsgAppFlowControlRecvHeartbeat(t *testing.T) {
 		r.readMessages()
 	}
 }
+
+// TestStatusProgressInflight ensures that the status exports the number of
+// inflight messages without sharing the inflights of the raft.
+func TestStatusProgressInflight(t *testing.T) {
+	r := newTestRaft(1, []uint64{1, 2}, 5, 1, NewMemoryStorage())
+	r.becomeCandidate()
+	r.becomeLeader()
+	r.prs[2].becomeReplicate()
+
+	for i := 0; i < 3; i++ {
+		r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: []byte("somedata")}}})
+	}
+	s := getStatus(r)
+	if n := s.Progress[2].Inflight(); n != 3 {
+		t.Fatalf("inflight = %d, want %d", n, 3)
+	}
+	r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: []byte("somedata")}}})
+	if n := s.Progress[2].Inflight(); n != 3 {
+		t.Errorf("inflight = %d, want %d", n, 3)
+	}
+}
@@ -747,6 +747,131 @@ func TestCommit(t *testing.T) {
 	}
 }
 
+// TestParallelPersistCommit tests that with parallelPersist a leader only
+// counts its own entries towards the commit index once they are persisted.
+func TestParallelPersistCommit(t *testing.T) {
+	s := NewMemoryStorage()
+	r := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, s)
+	r.parallelPersist = true
+	r.becomeCandidate()
+	r.becomeLeader()
+	r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: []byte("foo")}}})
+	li := r.raftLog.lastIndex()
+
+	r.Step(pb.Message{From: 2, To: 1, Type: pb.MsgAppResp, Index: li})
+	if r.raftLog.committed != 0 {
+		t.Fatalf("committed = %d, want %d", r.raftLog.committed, 0)
+	}
+	s.Append(r.raftLog.unstableEntries())
+	r.stableTo(li, r.Term)
+	if r.raftLog.committed != li {
+		t.Errorf("committed = %d, want %d", r.raftLog.committed, li)
+	}
+
+	// a majority of followers commits the entries the leader did not persist
+	r.readMessages()
+	r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: []byte("bar")}}})
+	li = r.raftLog.lastIndex()
+	r.Step(pb.Message{From: 2, To: 1, Type: pb.MsgAppResp, Index: li})
+	r.Step(pb.Message{From: 3, To: 1, Type: pb.MsgAppResp, Index: li})
+	if r.raftLog.committed != li {
+		t.Errorf("committed = %d, want %d", r.raftLog.committed, li)
+	}
+}
+
+// TestParallelPersistSingleNode tests that with parallelPersist a single
+// node cluster commits its entries once they are persisted.
+func TestParallelPersistSingleNode(t *testing.T) {
+	s := NewMemoryStorage()
+	r := newTestRaft(1, []uint64{1}, 10, 1, s)
+	r.parallelPersist = true
+	r.becomeCandidate()
+	r.becomeLeader()
+	li := r.raftLog.lastIndex()
+	if r.raftLog.committed != 0 {
+		t.Fatalf("committed = %d, want %d", r.raftLog.committed, 0)
+	}
+	s.Append(r.raftLog.unstableEntries())
+	r.stableTo(li, r.Term)
+	if r.raftLog.committed != li {
+		t.Errorf("committed = %d, want %d", r.raftLog.committed, li)
+	}
+	if r.Commit != li {
+		t.Errorf("hardstate commit = %d, want %d", r.Commit, li)
+	}
+}
+
+// TestUncommittedEntryLimit tests that the leader drops proposals once the
+// payloads of its uncommitted entries exceed maxUncommittedSize, and
+// accepts them again once the entries are committed.
+func TestUncommittedEntryLimit(t *testing.T) {
+	const maxEntries = 16
+	data := []byte("testdata")
+	r := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	r.maxUncommittedSize = uint64(maxEntries * len(data))
+	r.becomeCandidate()
+	r.becomeLeader()
+
+	// the leader accepts proposals up to the limit
+	var ents []pb.Entry
+	for i := 0; i < maxEntries; i++ {
+		e := pb.Entry{Data: data}
+		if err := r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{e}}); err != nil {
+			t.Fatalf("#%d: err = %v, want nil", i, err)
+		}
+		ents = append(ents, e)
+	}
+	if err := r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: data}}}); err != ErrProposalDropped {
+		t.Fatalf("err = %v, want %v", err, ErrProposalDropped)
+	}
+
+	// committing entries frees room for new proposals
+	r.reduceUncommittedSize(ents[:1])
+	if err := r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: data}}}); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	r.reduceUncommittedSize(ents)
+	if r.uncommittedSize != 0 {
+		t.Errorf("uncommittedSize = %d, want 0", r.uncommittedSize)
+	}
+
+	// an entry larger than the limit is accepted when nothing is uncommitted
+	large := pb.Entry{Data: make([]byte, r.maxUncommittedSize+1)}
+	if err := r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{large}}); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if err := r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: data}}}); err != ErrProposalDropped {
+		t.Fatalf("err = %v, want %v", err, ErrProposalDropped)
+	}
+
+	// a new leader starts from an empty uncommitted size
+	r.becomeFollower(r.Term+1, None)
+	if r.uncommittedSize != 0 {
+		t.Errorf("uncommittedSize = %d, want 0", r.uncommittedSize)
+	}
+}
+
+// TestProposalDroppedWithoutLeader tests that followers and candidates
+// without a leader drop the proposals silently, as they are not rejected
+// for overload.
+func TestProposalDroppedWithoutLeader(t *testing.T) {
+	tests := []func(r *raft){
+		func(r *raft) { r.becomeFollower(1, None) },
+		func(r *raft) { r.becomeCandidate() },
+	}
+	for i, tt := range tests {
+		r := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+		tt(r)
+		err := r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: []byte("foo")}}})
+		if err != nil {
+			t.Errorf("#%d: err = %v, want nil", i, err)
+		}
+		if l := len(r.msgs); l != 0 {
+			t.Errorf("#%d: len(msgs) = %d, want 0", i, l)
+		}
+	}
+}
+
 func TestIsElectionTimeout(t *testing.T) {
 	tests := []struct {
 		elapse       int
@@ -783,8 +908,9 @@ func TestIsElectionTimeout(t *testing.T) {
 // actual stepX function.
 func TestStepIgnoreOldTermMsg(t *testing.T) {
 	called := false
-	fakeStep := func(r *raft, m pb.Message) {
+	fakeStep := func(r *raft, m pb.Message) error {
 		called = true
+		return nil
 	}
 	sm := newTestRaft(1, []uint64{1}, 10, 1, NewMemoryStorage())
 	sm.step = fakeStep
@@ -1710,6 +1836,202 @@ func TestRemoveNode(t *testing.T) {
 	}
 }
 
+// replaceConfChange returns a ConfChangeV2 which replaces the given node
+// with another one.
+func replaceConfChange(removed, added uint64, tr pb.ConfChangeTransition) pb.ConfChangeV2 {
+	return pb.ConfChangeV2{
+		Transition: tr,
+		Changes: []pb.ConfChangeSingle{
+			{Type: pb.ConfChangeRemoveNode, NodeID: removed},
+			{Type: pb.ConfChangeAddNode, NodeID: added},
+		},
+	}
+}
+
+// TestJointConfChange tests that a ConfChangeV2 with several changes enters
+// a joint configuration holding both sets of voters, which is left by an
+// empty ConfChangeV2.
+func TestJointConfChange(t *testing.T) {
+	r := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	r.pendingConf = true
+	r.applyConfChangeV2(replaceConfChange(3, 4, pb.ConfChangeTransitionAuto))
+	if r.pendingConf != false {
+		t.Errorf("pendingConf = %v, want false", r.pendingConf)
+	}
+	wcs := pb.ConfState{Nodes: []uint64{1, 2, 4}, OutgoingNodes: []uint64{1, 2, 3}, AutoLeave: true}
+	if cs := r.confState(); !reflect.DeepEqual(cs, wcs) {
+		t.Errorf("confState = %+v, want %+v", cs, wcs)
+	}
+	if w := []uint64{1, 2, 3, 4}; !reflect.DeepEqual(r.nodes(), w) {
+		t.Errorf("nodes = %v, want %v", r.nodes(), w)
+	}
+
+	r.applyConfChangeV2(pb.ConfChangeV2{})
+	wcs = pb.ConfState{Nodes: []uint64{1, 2, 4}}
+	if cs := r.confState(); !reflect.DeepEqual(cs, wcs) {
+		t.Errorf("confState = %+v, want %+v", cs, wcs)
+	}
+}
+
+// TestJointCommit tests that in a joint configuration an entry is only
+// committed once replicated to majorities of both configurations.
+func TestJointCommit(t *testing.T) {
+	r := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	r.applyConfChangeV2(pb.ConfChangeV2{
+		Transition: pb.ConfChangeTransitionJointExplicit,
+		Changes: []pb.ConfChangeSingle{
+			{Type: pb.ConfChangeRemoveNode, NodeID: 2},
+			{Type: pb.ConfChangeRemoveNode, NodeID: 3},
+			{Type: pb.ConfChangeAddNode, NodeID: 4},
+			{Type: pb.ConfChangeAddNode, NodeID: 5},
+		},
+	})
+	r.becomeCandidate()
+	r.becomeLeader()
+	r.readMessages()
+	li := r.raftLog.lastIndex()
+
+	// a majority of the incoming configuration only
+	r.Step(pb.Message{From: 4, To: 1, Type: pb.MsgAppResp, Index: li})
+	if r.raftLog.committed == li {
+		t.Errorf("committed = %d, want less than %d", r.raftLog.committed, li)
+	}
+	// and of the outgoing one
+	r.Step(pb.Message{From: 2, To: 1, Type: pb.MsgAppResp, Index: li})
+	if r.raftLog.committed != li {
+		t.Errorf("committed = %d, want %d", r.raftLog.committed, li)
+	}
+}
+
+// TestJointElection tests that in a joint configuration a candidate needs
+// the votes of majorities of both configurations.
+func TestJointElection(t *testing.T) {
+	r := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	r.applyConfChangeV2(replaceConfChange(3, 4, pb.ConfChangeTransitionJointExplicit))
+	// 2 and 3 do not vote for 1
+	r.applyConfChangeV2(replaceConfChange(2, 5, pb.ConfChangeTransitionJointExplicit))
+
+	r.becomeCandidate()
+	r.poll(1, true)
+	r.Step(pb.Message{From: 4, To: 1, Term: r.Term, Type: pb.MsgVoteResp})
+	if r.state != StateCandidate {
+		t.Fatalf("state = %s, want %s", r.state, StateCandidate)
+	}
+	r.Step(pb.Message{From: 2, To: 1, Term: r.Term, Type: pb.MsgVoteResp})
+	if r.state != StateLeader {
+		t.Errorf("state = %s, want %s", r.state, StateLeader)
+	}
+
+	// a majority of the outgoing configuration rejecting the candidate
+	// loses the election
+	r = newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	r.applyConfChangeV2(pb.ConfChangeV2{
+		Transition: pb.ConfChangeTransitionJointExplicit,
+		Changes: []pb.ConfChangeSingle{
+			{Type: pb.ConfChangeRemoveNode, NodeID: 2},
+			{Type: pb.ConfChangeRemoveNode, NodeID: 3},
+			{Type: pb.ConfChangeAddNode, NodeID: 4},
+		},
+	})
+	r.becomeCandidate()
+	r.poll(1, true)
+	r.Step(pb.Message{From: 2, To: 1, Term: r.Term, Type: pb.MsgVoteResp, Reject: true})
+	r.Step(pb.Message{From: 3, To: 1, Term: r.Term, Type: pb.MsgVoteResp, Reject: true})
+	if r.state != StateFollower {
+		t.Errorf("state = %s, want %s", r.state, StateFollower)
+	}
+}
+
+// TestJointAutoLeave tests that a leader proposes to leave a joint
+// configuration once applied, unless the transition is explicit.
+func TestJointAutoLeave(t *testing.T) {
+	tests := []struct {
+		tr     pb.ConfChangeTransition
+		wleave bool
+	}{
+		{pb.ConfChangeTransitionAuto, true},
+		{pb.ConfChangeTransitionJointImplicit, true},
+		{pb.ConfChangeTransitionJointExplicit, false},
+	}
+	for i, tt := range tests {
+		r := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+		r.becomeCandidate()
+		r.becomeLeader()
+		li := r.raftLog.lastIndex()
+		r.applyConfChangeV2(replaceConfChange(3, 4, tt.tr))
+
+		if g := r.raftLog.lastIndex() == li+1; g != tt.wleave {
+			t.Fatalf("#%d: appended leave entry = %v, want %v", i, g, tt.wleave)
+		}
+		if r.pendingConf != tt.wleave {
+			t.Errorf("#%d: pendingConf = %v, want %v", i, r.pendingConf, tt.wleave)
+		}
+		if !tt.wleave {
+			continue
+		}
+		e := r.raftLog.unstableEntries()[li]
+		var cc pb.ConfChangeV2
+		if err := cc.Unmarshal(e.Data); err != nil {
+			t.Fatalf("#%d: unmarshal error: %v", i, err)
+		}
+		if e.Type != pb.EntryConfChangeV2 || !cc.LeaveJoint() {
+			t.Errorf("#%d: entry = %+v, want a leave joint entry", i, e)
+		}
+	}
+}
+
+// TestStepConfChangeInJoint tests that a leader in a joint configuration
+// only accepts to leave it.
+func TestStepConfChangeInJoint(t *testing.T) {
+	mustMarshal := func(cc pb.ConfChangeV2) []byte {
+		d, err := cc.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		e      pb.Entry
+		wtype  pb.EntryType
+		wjoint bool
+	}{
+		{pb.Entry{Type: pb.EntryConfChange}, pb.EntryNormal, true},
+		{pb.Entry{Type: pb.EntryConfChangeV2, Data: mustMarshal(replaceConfChange(2, 5, pb.ConfChangeTransitionAuto))}, pb.EntryNormal, true},
+		{pb.Entry{Type: pb.EntryConfChangeV2, Data: mustMarshal(pb.ConfChangeV2{})}, pb.EntryConfChangeV2, true},
+	}
+	for i, tt := range tests {
+		r := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+		r.applyConfChangeV2(replaceConfChange(3, 4, pb.ConfChangeTransitionJointExplicit))
+		r.becomeCandidate()
+		r.becomeLeader()
+		r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{tt.e}})
+		ents := r.raftLog.unstableEntries()
+		if g := ents[len(ents)-1].Type; g != tt.wtype {
+			t.Errorf("#%d: type = %s, want %s", i, g, tt.wtype)
+		}
+		if r.pendingConf != (tt.wtype != pb.EntryNormal) {
+			t.Errorf("#%d: pendingConf = %v, want %v", i, r.pendingConf, tt.wtype != pb.EntryNormal)
+		}
+	}
+}
+
+// TestRestoreJoint tests that a joint configuration is restored from a snapshot.
+func TestRestoreJoint(t *testing.T) {
+	cs := pb.ConfState{Nodes: []uint64{1, 2, 4}, OutgoingNodes: []uint64{1, 2, 3}, AutoLeave: true}
+	s := pb.Snapshot{Metadata: pb.SnapshotMetadata{Index: 11, Term: 11, ConfState: cs}}
+
+	sm := newTestRaft(1, []uint64{1, 2}, 10, 1, NewMemoryStorage())
+	if ok := sm.restore(s); !ok {
+		t.Fatal("restore fail, want succeed")
+	}
+	if g := sm.confState(); !reflect.DeepEqual(g, cs) {
+		t.Errorf("confState = %+v, want %+v", g, cs)
+	}
+	if w := []uint64{1, 2, 3, 4}; !reflect.DeepEqual(sm.nodes(), w) {
+		t.Errorf("nodes = %v, want %v", sm.nodes(), w)
+	}
+}
+
 func TestPromotable(t *testing.T) {
 	id := uint64(1)
 	tests := []struct {
@@ -1729,6 +2051,48 @@ func TestPromotable(t *testing.T) {
 	}
 }
 
+// TestWitness tests that a witness never campaigns, even after the election
+// timeout, but votes and acknowledges entries like any other voter.
+func TestWitness(t *testing.T) {
+	c := newTestConfig(3, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	c.Witness = true
+	w := newRaft(c)
+	if w.promotable() {
+		t.Fatalf("promotable = true, want false")
+	}
+	for i := 0; i < 2*w.electionTimeout; i++ {
+		w.tick()
+	}
+	if w.state != StateFollower {
+		t.Fatalf("state = %s, want %s", w.state, StateFollower)
+	}
+	if msgs := w.readMessages(); len(msgs) != 0 {
+		t.Fatalf("msgs = %v, want none", msgs)
+	}
+
+	nt := newNetwork(nil, nil, w)
+	nt.send(pb.Message{From: 3, To: 3, Type: pb.MsgHup})
+	if w.state != StateFollower || w.Term != 0 {
+		t.Fatalf("state = %s at term %d, want %s at term 0", w.state, w.Term, StateFollower)
+	}
+
+	// node 2 is down: node 1 needs the vote and the acknowledgments of
+	// the witness.
+	nt.isolate(2)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+	sm := nt.peers[1].(*raft)
+	if sm.state != StateLeader {
+		t.Fatalf("state = %s, want %s", sm.state, StateLeader)
+	}
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgProp, Entries: []pb.Entry{{Data: []byte("foo")}}})
+	if g := sm.raftLog.committed; g != 2 {
+		t.Errorf("committed = %d, want 2", g)
+	}
+	if g := w.raftLog.lastIndex(); g != 2 {
+		t.Errorf("witness lastIndex = %d, want 2", g)
+	}
+}
+
 func TestRaftNodes(t *testing.T) {
 	tests := []struct {
 		ids  []uint64
@@ -1761,6 +2125,64 @@ func ents(terms ...uint64) *raft {
 	return sm
 }
 
+func TestReadIndex(t *testing.T) {
+	a := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	b := newTestRaft(2, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	c := newTestRaft(3, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	nt := newNetwork(a, b, c)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgHup})
+
+	tests := []struct {
+		sm   *raft
+		wctx []byte
+	}{
+		// served by the leader
+		{a, []byte("ctx1")},
+		// forwarded to the leader
+		{b, []byte("ctx2")},
+		{c, []byte("ctx3")},
+	}
+	for i, tt := range tests {
+		nt.send(pb.Message{From: tt.sm.id, To: tt.sm.id, Type: pb.MsgReadIndex, Entries: []pb.Entry{{Data: tt.wctx}}})
+		wrs := []ReadState{{Index: a.raftLog.committed, RequestCtx: tt.wctx}}
+		if !reflect.DeepEqual(tt.sm.readStates, wrs) {
+			t.Errorf("#%d: readStates = %+v, want %+v", i, tt.sm.readStates, wrs)
+		}
+		tt.sm.readStates = nil
+	}
+
+	// the leadership cannot be confirmed without a quorum
+	nt.isolate(1)
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgReadIndex, Entries: []pb.Entry{{Data: []byte("ctx4")}}})
+	if len(a.readStates) != 0 {
+		t.Errorf("readStates = %+v, want none", a.readStates)
+	}
+	nt.recover()
+	// the pending request is confirmed by the next round of heartbeats
+	nt.send(pb.Message{From: 1, To: 1, Type: pb.MsgBeat})
+	wrs := []ReadState{{Index: a.raftLog.committed, RequestCtx: []byte("ctx4")}}
+	if !reflect.DeepEqual(a.readStates, wrs) {
+		t.Errorf("readStates = %+v, want %+v", a.readStates, wrs)
+	}
+}
+
+// TestReadIndexWithoutCommitInTerm ensures that the leader does not serve
+// read only requests before it commits an entry of its term.
+func TestReadIndexWithoutCommitInTerm(t *testing.T) {
+	r := newTestRaft(1, []uint64{1, 2, 3}, 10, 1, NewMemoryStorage())
+	r.becomeCandidate()
+	r.becomeLeader()
+	r.readMessages()
+
+	r.Step(pb.Message{From: 1, To: 1, Type: pb.MsgReadIndex, Entries: []pb.Entry{{Data: []byte("ctx")}}})
+	if msgs := r.readMessages(); len(msgs) != 0 {
+		t.Errorf("msgs = %+v, want none", msgs)
+	}
+	if len(r.readOnly.readIndexQueue) != 0 {
+		t.Errorf("pending read requests = %d, want 0", len(r.readOnly.readIndexQueue))
+	}
+}
+
 type network struct {
 	peers   map[uint64]Interface
 	storage map[uint64]*MemoryStorage
@@ -0,0 +1,44 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftpb
+
+// AsV2 returns the ConfChangeV2 which applies the same single change.
+func (c ConfChange) AsV2() ConfChangeV2 {
+	return ConfChangeV2{
+		ID: c.ID,
+		Changes: []ConfChangeSingle{{
+			Type:    c.Type,
+			NodeID:  c.NodeID,
+			Context: c.Context,
+		}},
+	}
+}
+
+// EnterJoint returns true if the ConfChangeV2 is applied through joint
+// consensus, that is if it carries several changes or asks for a joint
+// transition explicitly. autoLeave is set if raft leaves the joint
+// configuration on its own once it is applied.
+func (c ConfChangeV2) EnterJoint() (autoLeave bool, ok bool) {
+	if c.Transition == ConfChangeTransitionAuto && len(c.Changes) <= 1 {
+		return false, false
+	}
+	return c.Transition != ConfChangeTransitionJointExplicit, true
+}
+
+// LeaveJoint returns true if the ConfChangeV2 leaves the joint
+// configuration, which is the case of the ConfChangeV2 without changes.
+func (c ConfChangeV2) LeaveJoint() bool {
+	return len(c.Changes) == 0
+}
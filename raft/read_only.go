@@ -0,0 +1,96 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import pb "github.com/coreos/etcd/raft/raftpb"
+
+// ReadState provides the state of a read only request. The application
+// asks for it through ReadIndex, and receives it in Ready.ReadStates.
+// It can serve the request once its state machine has applied the entries
+// up to Index. RequestCtx is the context given to ReadIndex, which tells
+// the requests apart.
+type ReadState struct {
+	Index      uint64
+	RequestCtx []byte
+}
+
+type readIndexStatus struct {
+	req   pb.Message
+	index uint64
+	acks  map[uint64]bool
+}
+
+// readOnly tracks the read only requests received by the leader which wait
+// for a quorum of the voters to acknowledge its leadership.
+type readOnly struct {
+	pendingReadIndex map[string]*readIndexStatus
+	readIndexQueue   []string
+}
+
+func newReadOnly() *readOnly {
+	return &readOnly{
+		pendingReadIndex: make(map[string]*readIndexStatus),
+	}
+}
+
+// addRequest adds the given read only request, which is served at the given
+// committed index, to the pending requests.
+func (ro *readOnly) addRequest(index uint64, m pb.Message) {
+	ctx := string(m.Entries[0].Data)
+	if _, ok := ro.pendingReadIndex[ctx]; ok {
+		return
+	}
+	ro.pendingReadIndex[ctx] = &readIndexStatus{index: index, req: m, acks: make(map[uint64]bool)}
+	ro.readIndexQueue = append(ro.readIndexQueue, ctx)
+}
+
+// recvAck records that the given node acknowledged the heartbeat carrying
+// the given request context, and returns the acknowledgments of the request
+// so far, or nil if there is no such request.
+func (ro *readOnly) recvAck(id uint64, ctx []byte) map[uint64]bool {
+	rs, ok := ro.pendingReadIndex[string(ctx)]
+	if !ok {
+		return nil
+	}
+	rs.acks[id] = true
+	return rs.acks
+}
+
+// advance removes the request with the given context, and the requests
+// received before it, from the pending requests and returns them.
+func (ro *readOnly) advance(ctx []byte) []*readIndexStatus {
+	for i, c := range ro.readIndexQueue {
+		if c != string(ctx) {
+			continue
+		}
+		rss := make([]*readIndexStatus, i+1)
+		for j, c := range ro.readIndexQueue[:i+1] {
+			rss[j] = ro.pendingReadIndex[c]
+			delete(ro.pendingReadIndex, c)
+		}
+		ro.readIndexQueue = ro.readIndexQueue[i+1:]
+		return rss
+	}
+	return nil
+}
+
+// lastPendingRequestCtx returns the context of the last pending request,
+// or nil if there is none.
+func (ro *readOnly) lastPendingRequestCtx() []byte {
+	if len(ro.readIndexQueue) == 0 {
+		return nil
+	}
+	return []byte(ro.readIndexQueue[len(ro.readIndexQueue)-1])
+}
@@ -28,6 +28,9 @@ type Status struct {
 
 	Applied  uint64
 	Progress map[uint64]Progress
+
+	// Quiesced is set if the group of a MultiNode is quiesced.
+	Quiesced bool
 }
 
 // getStatus gets a copy of the current raft status.
@@ -41,7 +44,13 @@ func getStatus(r *raft) Status {
 	if s.RaftState == StateLeader {
 		s.Progress = make(map[uint64]Progress)
 		for id, p := range r.prs {
-			s.Progress[id] = *p
+			pr := *p
+			// only the count of the inflights is exported, so the copy
+			// does not share the buffer used by the raft.
+			if p.ins != nil {
+				pr.ins = &inflights{count: p.ins.count, size: p.ins.size}
+			}
+			s.Progress[id] = pr
 		}
 	}
 
@@ -57,7 +66,8 @@ func (s Status) MarshalJSON() ([]byte, error) {
 		j += "}}"
 	} else {
 		for k, v := range s.Progress {
-			subj := fmt.Sprintf(`"%x":{"match":%d,"next":%d,"state":%q},`, k, v.Match, v.Next, v.State)
+			subj := fmt.Sprintf(`"%x":{"match":%d,"next":%d,"state":%q,"inflight":%d,"paused":%t},`,
+				k, v.Match, v.Next, v.State, v.Inflight(), v.isPaused())
 			j += subj
 		}
 		// remove the trailing ","
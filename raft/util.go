@@ -17,6 +17,7 @@ package raft
 import (
 	"bytes"
 	"fmt"
+	"sort"
 
 	pb "github.com/coreos/etcd/raft/raftpb"
 )
@@ -46,6 +47,16 @@ func max(a, b uint64) uint64 {
 	return b
 }
 
+// setIDs returns the sorted IDs of the given set.
+func setIDs(set map[uint64]bool) []uint64 {
+	ids := make([]uint64, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Sort(uint64Slice(ids))
+	return ids
+}
+
 func IsLocalMsg(m pb.Message) bool {
 	return m.Type == pb.MsgHup || m.Type == pb.MsgBeat || m.Type == pb.MsgUnreachable || m.Type == pb.MsgSnapStatus
 }
@@ -0,0 +1,178 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/coreos/etcd/pkg/types"
+)
+
+// The compression of the peer traffic is negotiated through http headers,
+// so that a member only compresses what the remote can decompress:
+//  - every member advertises the encodings it can decompress in the
+//    X-Raft-Accept-Encoding header of its stream requests and of its
+//    responses to the pipeline and snapshot requests.
+//  - the member serving a stream compresses it if the stream request
+//    accepts it, and tells so in the X-Raft-Content-Encoding header of its
+//    response.
+//  - the pipeline and snapshot requests are compressed once a response of
+//    the remote accepts it, and are then sent with a Content-Encoding header.
+// Members which do not know about compression never send the
+// X-Raft-Accept-Encoding header, so they are always sent uncompressed data.
+
+// Compression is the compression of the messages sent to the peers.
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+)
+
+// ParseCompression returns the Compression named by s.
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(s); c {
+	case CompressionNone, CompressionGzip:
+		return c, nil
+	case "":
+		return CompressionNone, nil
+	}
+	return "", fmt.Errorf("unknown compression %q", s)
+}
+
+// acceptEncoding returns true if the given header accepts gzip encoding in
+// X-Raft-Accept-Encoding.
+func acceptEncoding(h http.Header) bool {
+	for _, e := range strings.Split(h.Get("X-Raft-Accept-Encoding"), ",") {
+		if strings.TrimSpace(e) == string(CompressionGzip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerCompression decides whether the requests sent to a peer are compressed.
+type peerCompression struct {
+	local Compression
+
+	mu       sync.Mutex
+	accepted bool // the remote accepted compression in its last response
+}
+
+func newPeerCompression(c Compression) *peerCompression {
+	return &peerCompression{local: c}
+}
+
+// enabled returns true if the next request sent to the peer is compressed.
+func (pc *peerCompression) enabled() bool {
+	if pc == nil || pc.local != CompressionGzip {
+		return false
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.accepted
+}
+
+// update records whether the given response header of the peer accepts
+// compression.
+func (pc *peerCompression) update(h http.Header) {
+	if pc == nil {
+		return
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.accepted = acceptEncoding(h)
+}
+
+// compressWriter compresses into w the data written into it. If w is an
+// http.Flusher, it is flushed along with the compressed data.
+type compressWriter struct {
+	gz *gzip.Writer
+	fl http.Flusher
+
+	sendingType string
+	to          string
+}
+
+func newCompressWriter(w io.Writer, sendingType string, to types.ID) *compressWriter {
+	cw := &compressWriter{sendingType: sendingType, to: to.String()}
+	cw.gz = gzip.NewWriter(&wireCounter{w: w, cw: cw})
+	cw.fl, _ = w.(http.Flusher)
+	return cw
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	n, err := cw.gz.Write(p)
+	compressionRawBytes.WithLabelValues(cw.sendingType, cw.to).Add(float64(n))
+	return n, err
+}
+
+// Flush writes the pending compressed data into the underlying writer and
+// flushes it if it is an http.Flusher.
+func (cw *compressWriter) Flush() {
+	if err := cw.gz.Flush(); err != nil {
+		plog.Debugf("failed to flush compressed data to %s (%v)", cw.to, err)
+		return
+	}
+	if cw.fl != nil {
+		cw.fl.Flush()
+	}
+}
+
+// Close writes the end of the compressed data, without closing the
+// underlying writer.
+func (cw *compressWriter) Close() error { return cw.gz.Close() }
+
+// wireCounter counts the compressed bytes written into w.
+type wireCounter struct {
+	w  io.Writer
+	cw *compressWriter
+}
+
+func (wc *wireCounter) Write(p []byte) (int, error) {
+	n, err := wc.w.Write(p)
+	compressionWireBytes.WithLabelValues(wc.cw.sendingType, wc.cw.to).Add(float64(n))
+	return n, err
+}
+
+// decompressReadCloser decompresses the data read from rc. The gzip header
+// is only read at the first Read, so that a stream does not block before it
+// is used.
+type decompressReadCloser struct {
+	rc io.ReadCloser
+	gz *gzip.Reader
+}
+
+func newDecompressReadCloser(rc io.ReadCloser) *decompressReadCloser {
+	return &decompressReadCloser{rc: rc}
+}
+
+func (d *decompressReadCloser) Read(p []byte) (int, error) {
+	if d.gz == nil {
+		gz, err := gzip.NewReader(d.rc)
+		if err != nil {
+			return 0, err
+		}
+		d.gz = gz
+	}
+	return d.gz.Read(p)
+}
+
+func (d *decompressReadCloser) Close() error { return d.rc.Close() }
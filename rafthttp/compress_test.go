@@ -0,0 +1,167 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/version"
+)
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		s    string
+		wc   Compression
+		werr bool
+	}{
+		{"", CompressionNone, false},
+		{"none", CompressionNone, false},
+		{"gzip", CompressionGzip, false},
+		{"snappy", "", true},
+	}
+	for i, tt := range tests {
+		c, err := ParseCompression(tt.s)
+		if c != tt.wc || (err != nil) != tt.werr {
+			t.Errorf("#%d: ParseCompression(%q) = %q, %v, want %q, error %v", i, tt.s, c, err, tt.wc, tt.werr)
+		}
+	}
+}
+
+func TestCompressWriter(t *testing.T) {
+	var buf bytes.Buffer
+	cw := newCompressWriter(&buf, pipelineMsg, types.ID(1))
+	data := bytes.Repeat([]byte("some data"), 100)
+	if _, err := cw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	// the flushed data can be read before the end of the stream
+	cw.Flush()
+	r := newDecompressReadCloser(ioutil.NopCloser(&buf))
+	b := make([]byte, len(data))
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("data = %q, want %q", b, data)
+	}
+	if buf.Len() >= len(data) {
+		t.Errorf("compressed size = %d, want less than %d", buf.Len(), len(data))
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(b); err != io.EOF {
+		t.Errorf("err = %v, want %v", err, io.EOF)
+	}
+}
+
+// TestPipelineCompression tests that pipeline compresses its requests once
+// the remote has accepted compression.
+func TestPipelineCompression(t *testing.T) {
+	recvc := make(chan raftpb.Message, 1)
+	h := NewHandler(&fakeRaft{recvc: recvc}, types.ID(1))
+	var (
+		mu        sync.Mutex
+		encodings []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		mu.Unlock()
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	picker := mustNewURLPicker(t, []string{srv.URL})
+	p := newPipeline(&http.Transport{}, picker, types.ID(2), types.ID(1), types.ID(1), newPeerStatus(types.ID(1)), nil, &fakeRaft{}, nil, newPeerCompression(CompressionGzip))
+	defer p.stop()
+	for i := 0; i < 2; i++ {
+		m := raftpb.Message{Type: raftpb.MsgApp, From: 2, To: 1, Entries: []raftpb.Entry{{Data: []byte("some data")}}}
+		p.msgc <- m
+		select {
+		case g := <-recvc:
+			if !reflect.DeepEqual(g, m) {
+				t.Errorf("#%d: msg = %+v, want %+v", i, g, m)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("#%d: timed out waiting for the message", i)
+		}
+		// wait for the response to be handled
+		for j := 0; j < 1000 && !p.comp.enabled(); j++ {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if w := []string{"", "gzip"}; !reflect.DeepEqual(encodings, w) {
+		t.Errorf("encodings = %q, want %q", encodings, w)
+	}
+}
+
+func TestStreamHandlerCompression(t *testing.T) {
+	tests := []struct {
+		local  Compression
+		accept string
+		typ    streamType
+
+		wencoding string
+	}{
+		{CompressionGzip, "gzip", streamTypeMessage, "gzip"},
+		{CompressionGzip, "gzip", streamTypeMsgAppV2, "gzip"},
+		// etcd 2.0 msgapp stream
+		{CompressionGzip, "gzip", streamTypeMsgApp, ""},
+		// remote without compression
+		{CompressionGzip, "", streamTypeMessage, ""},
+		// local without compression
+		{CompressionNone, "gzip", streamTypeMessage, ""},
+	}
+	for i, tt := range tests {
+		peer := newFakePeer()
+		peerGetter := &fakePeerGetter{peers: map[types.ID]Peer{types.ID(1): peer}}
+		h := newStreamHandler(peerGetter, &fakeRaft{}, types.ID(2), types.ID(1), tt.local)
+
+		req, _ := http.NewRequest("GET", "http://localhost:2380"+tt.typ.endpoint()+"/1", nil)
+		req.Header.Set("X-Etcd-Cluster-ID", "1")
+		req.Header.Set("X-Server-Version", version.Version)
+		req.Header.Set("X-Raft-To", "2")
+		req.Header.Set("X-Raft-Accept-Encoding", tt.accept)
+		rw := httptest.NewRecorder()
+		go h.ServeHTTP(rw, req)
+
+		var conn *outgoingConn
+		select {
+		case conn = <-peer.connc:
+		case <-time.After(time.Second):
+			t.Fatalf("#%d: failed to attach outgoingConn", i)
+		}
+		if g := rw.Header().Get("X-Raft-Content-Encoding"); g != tt.wencoding {
+			t.Errorf("#%d: encoding = %q, want %q", i, g, tt.wencoding)
+		}
+		if _, ok := conn.Writer.(*compressWriter); ok != (tt.wencoding != "") {
+			t.Errorf("#%d: compressed = %v, want %v", i, ok, tt.wencoding != "")
+		}
+		conn.Close()
+	}
+}
@@ -29,15 +29,30 @@ import (
 )
 
 func TestSendMessage(t *testing.T) {
+	tests := []struct {
+		c1, c2 Compression
+	}{
+		{CompressionNone, CompressionNone},
+		{CompressionGzip, CompressionGzip},
+		// compressed members can talk with uncompressed ones
+		{CompressionGzip, CompressionNone},
+		{CompressionNone, CompressionGzip},
+	}
+	for _, tt := range tests {
+		testSendMessage(t, tt.c1, tt.c2)
+	}
+}
+
+func testSendMessage(t *testing.T, c1, c2 Compression) {
 	// member 1
-	tr := NewTransporter(&http.Transport{}, types.ID(1), types.ID(1), &fakeRaft{}, nil, newServerStats(), stats.NewLeaderStats("1"))
+	tr := NewTransporter(&http.Transport{}, types.ID(1), types.ID(1), &fakeRaft{}, nil, newServerStats(), stats.NewLeaderStats("1"), nil, c1)
 	srv := httptest.NewServer(tr.Handler())
 	defer srv.Close()
 
 	// member 2
 	recvc := make(chan raftpb.Message, 1)
 	p := &fakeRaft{recvc: recvc}
-	tr2 := NewTransporter(&http.Transport{}, types.ID(2), types.ID(1), p, nil, newServerStats(), stats.NewLeaderStats("2"))
+	tr2 := NewTransporter(&http.Transport{}, types.ID(2), types.ID(1), p, nil, newServerStats(), stats.NewLeaderStats("2"), nil, c2)
 	srv2 := httptest.NewServer(tr2.Handler())
 	defer srv2.Close()
 
@@ -46,7 +61,7 @@ func TestSendMessage(t *testing.T) {
 	tr2.AddPeer(types.ID(1), []string{srv.URL})
 	defer tr2.Stop()
 	if !waitStreamWorking(tr.(*transport).Get(types.ID(2)).(*peer)) {
-		t.Fatalf("stream from 1 to 2 is not in work as expected")
+		t.Fatalf("%s/%s: stream from 1 to 2 is not in work as expected", c1, c2)
 	}
 
 	data := []byte("some data")
@@ -66,7 +81,7 @@ func TestSendMessage(t *testing.T) {
 		tr.Send([]raftpb.Message{tt})
 		msg := <-recvc
 		if !reflect.DeepEqual(msg, tt) {
-			t.Errorf("#%d: msg = %+v, want %+v", i, msg, tt)
+			t.Errorf("%s/%s #%d: msg = %+v, want %+v", c1, c2, i, msg, tt)
 		}
 	}
 }
@@ -75,14 +90,14 @@ func TestSendMessage(t *testing.T) {
 // remote in a limited time when all underlying connections are broken.
 func TestSendMessageWhenStreamIsBroken(t *testing.T) {
 	// member 1
-	tr := NewTransporter(&http.Transport{}, types.ID(1), types.ID(1), &fakeRaft{}, nil, newServerStats(), stats.NewLeaderStats("1"))
+	tr := NewTransporter(&http.Transport{}, types.ID(1), types.ID(1), &fakeRaft{}, nil, newServerStats(), stats.NewLeaderStats("1"), nil, CompressionNone)
 	srv := httptest.NewServer(tr.Handler())
 	defer srv.Close()
 
 	// member 2
 	recvc := make(chan raftpb.Message, 1)
 	p := &fakeRaft{recvc: recvc}
-	tr2 := NewTransporter(&http.Transport{}, types.ID(2), types.ID(1), p, nil, newServerStats(), stats.NewLeaderStats("2"))
+	tr2 := NewTransporter(&http.Transport{}, types.ID(2), types.ID(1), p, nil, newServerStats(), stats.NewLeaderStats("2"), nil, CompressionNone)
 	srv2 := httptest.NewServer(tr2.Handler())
 	defer srv2.Close()
 
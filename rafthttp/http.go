@@ -15,15 +15,21 @@
 package rafthttp
 
 import (
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"path"
+	"strconv"
+	"sync"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
 	pioutil "github.com/coreos/etcd/pkg/ioutil"
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
 	"github.com/coreos/etcd/version"
 )
 
@@ -35,6 +41,10 @@ var (
 	RaftPrefix       = "/raft"
 	ProbingPrefix    = path.Join(RaftPrefix, "probing")
 	RaftStreamPrefix = path.Join(RaftPrefix, "stream")
+	// RaftSnapshotPrefix is the endpoint the snapshots are sent to.
+	RaftSnapshotPrefix = path.Join(RaftPrefix, "snapshot")
+	// RaftGroupStreamPrefix is the endpoint of the streams of MultiTransporter.
+	RaftGroupStreamPrefix = path.Join(RaftStreamPrefix, "group")
 
 	errIncompatibleVersion = errors.New("incompatible version")
 	errClusterIDMismatch   = errors.New("cluster ID mismatch")
@@ -47,16 +57,25 @@ func NewHandler(r Raft, cid types.ID) http.Handler {
 	}
 }
 
+func newSnapshotHandler(r Raft, cid types.ID, ss *snap.Snapshotter) http.Handler {
+	return &snapshotHandler{
+		r:           r,
+		cid:         cid,
+		snapshotter: ss,
+	}
+}
+
 type peerGetter interface {
 	Get(id types.ID) Peer
 }
 
-func newStreamHandler(peerGetter peerGetter, r Raft, id, cid types.ID) http.Handler {
+func newStreamHandler(peerGetter peerGetter, r Raft, id, cid types.ID, comp Compression) http.Handler {
 	return &streamHandler{
-		peerGetter: peerGetter,
-		r:          r,
-		id:         id,
-		cid:        cid,
+		peerGetter:  peerGetter,
+		r:           r,
+		id:          id,
+		cid:         cid,
+		compression: comp,
 	}
 }
 
@@ -92,9 +111,11 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("X-Raft-Accept-Encoding", string(CompressionGzip))
+	body := requestBody(r)
 	// Limit the data size that could be read from the request body, which ensures that read from
 	// connection will not time out accidentally due to possible block in underlying implementation.
-	limitedr := pioutil.NewLimitedBufferReader(r.Body, ConnReadLimitByte)
+	limitedr := pioutil.NewLimitedBufferReader(body, ConnReadLimitByte)
 	b, err := ioutil.ReadAll(limitedr)
 	if err != nil {
 		plog.Errorf("failed to read raft message (%v)", err)
@@ -122,11 +143,144 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// snapshotHandler receives the snapshots sent by snapshotSender. The v3
+// database following a snapshot message is written into a snap.PartialDB,
+// which is kept across requests so that an interrupted transfer can be
+// resumed. Once the database is complete and verified, the message is
+// processed, and the database is found at DBFilePath of the snapshot index.
+type snapshotHandler struct {
+	r           Raft
+	cid         types.ID
+	snapshotter *snap.Snapshotter
+
+	mu      sync.Mutex
+	partial *snap.PartialDB
+}
+
+func (h *snapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := checkVersionCompability(r.Header.Get("X-Server-From"), serverVersion(r.Header), minClusterVersion(r.Header)); err != nil {
+		plog.Errorf("request received was ignored (%v)", err)
+		http.Error(w, errIncompatibleVersion.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	wcid := h.cid.String()
+	w.Header().Set("X-Etcd-Cluster-ID", wcid)
+
+	gcid := r.Header.Get("X-Etcd-Cluster-ID")
+	if gcid != wcid {
+		plog.Errorf("request received was ignored (cluster ID mismatch got %s want %s)", gcid, wcid)
+		http.Error(w, errClusterIDMismatch.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	w.Header().Set("X-Raft-Accept-Encoding", string(CompressionGzip))
+	r.Body = requestBody(r)
+	m, err := (&messageDecoder{r: r.Body}).decode()
+	if err != nil {
+		plog.Errorf("failed to decode raft message (%v)", err)
+		http.Error(w, "error decoding raft message", http.StatusBadRequest)
+		return
+	}
+	if m.Type != raftpb.MsgSnap {
+		plog.Errorf("unexpected raft message type %s on snapshot path", m.Type)
+		http.Error(w, "wrong raft message type", http.StatusBadRequest)
+		return
+	}
+
+	if r.Header.Get("X-Snapshot-DB-Size") != "" {
+		if code, err := h.receiveDB(w, r, m.Snapshot.Metadata.Index); err != nil {
+			plog.Warningf("failed to receive the database of the snapshot at index %d (%v)", m.Snapshot.Metadata.Index, err)
+			http.Error(w, err.Error(), code)
+			return
+		}
+	}
+
+	if err := h.r.Process(context.TODO(), m); err != nil {
+		switch v := err.(type) {
+		case writerToResponse:
+			v.WriteTo(w)
+		default:
+			plog.Warningf("failed to process raft message (%v)", err)
+			http.Error(w, "error processing raft message", http.StatusInternalServerError)
+		}
+		return
+	}
+	// Write StatusNoContent header after the message has been processed by
+	// raft, which facilitates the client to report MsgSnap status.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// receiveDB receives the part of the v3 database of the snapshot at the
+// given index held by the request. It returns nil once the whole database
+// is received and verified. Otherwise, it returns the error and the http
+// status to reply with. If the request does not start at the offset the
+// database has been received to, it replies with StatusConflict and this
+// offset in the X-Snapshot-DB-Offset header.
+func (h *snapshotHandler) receiveDB(w http.ResponseWriter, r *http.Request, index uint64) (int, error) {
+	if h.snapshotter == nil {
+		return http.StatusInternalServerError, errors.New("no snapshot directory to receive the database")
+	}
+	size, err := strconv.ParseInt(r.Header.Get("X-Snapshot-DB-Size"), 10, 64)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("bad database size (%v)", err)
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("X-Snapshot-DB-Offset"), 10, 64)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("bad database offset (%v)", err)
+	}
+	sum, err := hex.DecodeString(r.Header.Get("X-Snapshot-DB-Checksum"))
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("bad database checksum (%v)", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.partial != nil && !h.partial.Is(index, sum) {
+		h.partial.Close()
+		h.partial = nil
+	}
+	if h.partial == nil {
+		if h.partial, err = h.snapshotter.CreatePartialDB(index, sum); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+	p := h.partial
+	if offset != p.Size() {
+		w.Header().Set("X-Snapshot-DB-Offset", strconv.FormatInt(p.Size(), 10))
+		return http.StatusConflict, fmt.Errorf("database received up to offset %d", p.Size())
+	}
+	if offset > size {
+		return http.StatusBadRequest, fmt.Errorf("database offset %d beyond its size %d", offset, size)
+	}
+	// The received part is kept even if the connection is lost, so that
+	// the sender can resume after it.
+	if _, err := p.ReadFrom(io.LimitReader(r.Body, size-offset)); err != nil {
+		w.Header().Set("X-Snapshot-DB-Offset", strconv.FormatInt(p.Size(), 10))
+		return http.StatusConflict, fmt.Errorf("database received up to offset %d (%v)", p.Size(), err)
+	}
+	if p.Size() != size {
+		return http.StatusBadRequest, fmt.Errorf("unexpected end of database at offset %d", p.Size())
+	}
+	h.partial = nil
+	if err := p.Commit(); err != nil {
+		return http.StatusBadRequest, err
+	}
+	return 0, nil
+}
+
 type streamHandler struct {
-	peerGetter peerGetter
-	r          Raft
-	id         types.ID
-	cid        types.ID
+	peerGetter  peerGetter
+	r           Raft
+	id          types.ID
+	cid         types.ID
+	compression Compression
 }
 
 func (h *streamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -199,6 +353,11 @@ func (h *streamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the stream of msgapp is kept uncompressed for etcd 2.0
+	compressed := h.compression == CompressionGzip && t != streamTypeMsgApp && acceptEncoding(r.Header)
+	if compressed {
+		w.Header().Set("X-Raft-Content-Encoding", string(CompressionGzip))
+	}
 	w.WriteHeader(http.StatusOK)
 	w.(http.Flusher).Flush()
 
@@ -210,8 +369,27 @@ func (h *streamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Flusher: w.(http.Flusher),
 		Closer:  c,
 	}
+	var cw *compressWriter
+	if compressed {
+		cw = newCompressWriter(w, t.String(), from)
+		conn.Writer, conn.Flusher = cw, cw
+	}
 	p.attachOutgoingConn(conn)
 	<-c.closeNotify()
+	if cw != nil {
+		// the stream writer no longer writes into the closed conn, so
+		// the compressed stream can be ended cleanly.
+		cw.Close()
+	}
+}
+
+// requestBody returns the body of the given request, decompressed if it
+// was sent compressed.
+func requestBody(r *http.Request) io.ReadCloser {
+	if r.Header.Get("Content-Encoding") == string(CompressionGzip) {
+		return newDecompressReadCloser(r.Body)
+	}
+	return r.Body
 }
 
 type closeNotifier struct {
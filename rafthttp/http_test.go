@@ -189,7 +189,7 @@ func TestServeRaftStreamPrefix(t *testing.T) {
 
 		peer := newFakePeer()
 		peerGetter := &fakePeerGetter{peers: map[types.ID]Peer{types.ID(1): peer}}
-		h := newStreamHandler(peerGetter, &fakeRaft{}, types.ID(2), types.ID(1))
+		h := newStreamHandler(peerGetter, &fakeRaft{}, types.ID(2), types.ID(1), CompressionNone)
 
 		rw := httptest.NewRecorder()
 		go h.ServeHTTP(rw, req)
@@ -307,7 +307,7 @@ func TestServeRaftStreamPrefixBad(t *testing.T) {
 		rw := httptest.NewRecorder()
 		peerGetter := &fakePeerGetter{peers: map[types.ID]Peer{types.ID(1): newFakePeer()}}
 		r := &fakeRaft{removedID: removedID}
-		h := newStreamHandler(peerGetter, r, types.ID(1), types.ID(1))
+		h := newStreamHandler(peerGetter, r, types.ID(1), types.ID(1), CompressionNone)
 		h.ServeHTTP(rw, req)
 
 		if rw.Code != tt.wcode {
@@ -367,4 +367,5 @@ func (pr *fakePeer) Update(urls types.URLs)                { pr.urls = urls }
 func (pr *fakePeer) setTerm(term uint64)                   { pr.term = term }
 func (pr *fakePeer) attachOutgoingConn(conn *outgoingConn) { pr.connc <- conn }
 func (pr *fakePeer) activeSince() time.Time                { return time.Time{} }
+func (pr *fakePeer) stats() PeerStats                      { return PeerStats{} }
 func (pr *fakePeer) Stop()                                 {}
@@ -53,3 +53,38 @@ func (dec *messageDecoder) decode() (raftpb.Message, error) {
 	}
 	return m, m.Unmarshal(buf)
 }
+
+// groupMessage is a message of a group of a MultiNode.
+type groupMessage struct {
+	group uint64
+	msg   raftpb.Message
+}
+
+// groupMessageEncoder encodes the messages of the groups of a MultiNode,
+// each preceded by its group. It MUST be used with a paired
+// groupMessageDecoder.
+type groupMessageEncoder struct {
+	w io.Writer
+}
+
+func (enc *groupMessageEncoder) encode(gm groupMessage) error {
+	if err := binary.Write(enc.w, binary.BigEndian, gm.group); err != nil {
+		return err
+	}
+	return (&messageEncoder{w: enc.w}).encode(gm.msg)
+}
+
+// groupMessageDecoder decodes the messages encoded by groupMessageEncoder.
+type groupMessageDecoder struct {
+	r io.Reader
+}
+
+func (dec *groupMessageDecoder) decode() (groupMessage, error) {
+	var gm groupMessage
+	if err := binary.Read(dec.r, binary.BigEndian, &gm.group); err != nil {
+		return gm, err
+	}
+	var err error
+	gm.msg, err = (&messageDecoder{r: dec.r}).decode()
+	return gm, err
+}
@@ -63,3 +63,29 @@ func TestMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestGroupMessage(t *testing.T) {
+	tests := []groupMessage{
+		{group: 1, msg: raftpb.Message{Type: raftpb.MsgApp, From: 1, To: 2, Term: 1, Entries: []raftpb.Entry{{Term: 1, Index: 4}}}},
+		{group: 0, msg: raftpb.Message{Type: raftpb.MsgHeartbeat, From: 1, To: 2}},
+		{group: 1<<64 - 1, msg: raftpb.Message{Type: raftpb.MsgReadIndex, From: 2, To: 1, Entries: []raftpb.Entry{{Data: []byte("ctx")}}}},
+		{group: 0, msg: linkHeartbeatMessage},
+	}
+	b := &bytes.Buffer{}
+	enc := &groupMessageEncoder{w: b}
+	for i, tt := range tests {
+		if err := enc.encode(tt); err != nil {
+			t.Fatalf("#%d: unexpected encode message error: %v", i, err)
+		}
+	}
+	dec := &groupMessageDecoder{r: b}
+	for i, tt := range tests {
+		gm, err := dec.decode()
+		if err != nil {
+			t.Fatalf("#%d: unexpected decode message error: %v", i, err)
+		}
+		if !reflect.DeepEqual(gm, tt) {
+			t.Errorf("#%d: message = %+v, want %+v", i, gm, tt)
+		}
+	}
+}
@@ -41,11 +41,31 @@ var (
 	},
 		[]string{"sendingType", "remoteID", "msgType"},
 	)
+
+	compressionRawBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd",
+		Subsystem: "rafthttp",
+		Name:      "compression_raw_bytes_total",
+		Help:      "The total number of bytes sent compressed, before compression.",
+	},
+		[]string{"sendingType", "remoteID"},
+	)
+
+	compressionWireBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd",
+		Subsystem: "rafthttp",
+		Name:      "compression_wire_bytes_total",
+		Help:      "The total number of bytes sent compressed, after compression.",
+	},
+		[]string{"sendingType", "remoteID"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(msgSentDuration)
 	prometheus.MustRegister(msgSentFailed)
+	prometheus.MustRegister(compressionRawBytes)
+	prometheus.MustRegister(compressionWireBytes)
 }
 
 func reportSentDuration(sendingType string, m raftpb.Message, duration time.Duration) {
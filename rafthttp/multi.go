@@ -0,0 +1,552 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/version"
+)
+
+// MultiRaft is the counterpart of Raft for a raft.MultiNode, whose messages
+// belong to groups.
+type MultiRaft interface {
+	Process(ctx context.Context, group uint64, m raftpb.Message) error
+	IsIDRemoved(id uint64) bool
+	ReportUnreachable(id, group uint64)
+	ReportSnapshot(id, group uint64, status raft.SnapshotStatus)
+}
+
+// MultiTransporter is the counterpart of Transporter for a raft.MultiNode.
+// The messages of all the groups exchanged with a peer, including the
+// messages of raft.NodeGroup, are multiplexed over a single stream. There
+// is no pipeline: the messages to a peer whose stream is not established
+// are dropped, and reported as unreachable to the MultiRaft.
+type MultiTransporter interface {
+	// Handler returns the HTTP handler of the transporter.
+	// The handler MUST be used to handle the RaftGroupStreamPrefix
+	// endpoint.
+	Handler() http.Handler
+	// Send sends out the given messages of the given group to the
+	// remote peers. If the To field of a message cannot be found in
+	// the transport, the message will be ignored.
+	Send(group uint64, msgs []raftpb.Message)
+	// AddPeer adds a peer with given peer urls into the transport.
+	// It is the caller's responsibility to ensure the urls are all valid,
+	// or it panics.
+	AddPeer(id types.ID, urls []string)
+	// RemovePeer removes the peer with given id.
+	RemovePeer(id types.ID)
+	// RemoveAllPeers removes all the existing peers in the transport.
+	RemoveAllPeers()
+	// UpdatePeer updates the peer urls of the peer with the given id.
+	UpdatePeer(id types.ID, urls []string)
+	// ActiveSince returns the time that the stream with the peer of
+	// the given id becomes active, or zero time if it is inactive.
+	ActiveSince(id types.ID) time.Time
+	// Stop closes the connections and stops the transporter.
+	Stop()
+}
+
+type multiTransport struct {
+	roundTripper http.RoundTripper
+	id           types.ID
+	clusterID    types.ID
+	raft         MultiRaft
+	errorc       chan error
+
+	mu    sync.RWMutex // protect the peer map
+	peers map[types.ID]*groupPeer
+}
+
+func NewMultiTransporter(rt http.RoundTripper, id, cid types.ID, r MultiRaft, errorc chan error) MultiTransporter {
+	return &multiTransport{
+		roundTripper: rt,
+		id:           id,
+		clusterID:    cid,
+		raft:         r,
+		errorc:       errorc,
+		peers:        make(map[types.ID]*groupPeer),
+	}
+}
+
+func (t *multiTransport) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(RaftGroupStreamPrefix+"/", &groupStreamHandler{t: t})
+	return mux
+}
+
+func (t *multiTransport) get(id types.ID) *groupPeer {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.peers[id]
+}
+
+func (t *multiTransport) Send(group uint64, msgs []raftpb.Message) {
+	for _, m := range msgs {
+		// intentionally dropped message
+		if m.To == 0 {
+			continue
+		}
+		to := types.ID(m.To)
+		if p := t.get(to); p != nil {
+			p.send(groupMessage{group: group, msg: m})
+			continue
+		}
+		plog.Debugf("ignored message %s of group %d (sent to unknown peer %s)", m.Type, group, to)
+	}
+}
+
+func (t *multiTransport) Stop() {
+	t.RemoveAllPeers()
+	if tr, ok := t.roundTripper.(*http.Transport); ok {
+		tr.CloseIdleConnections()
+	}
+}
+
+func (t *multiTransport) AddPeer(id types.ID, us []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.peers[id]; ok {
+		return
+	}
+	urls, err := types.NewURLs(us)
+	if err != nil {
+		plog.Panicf("newURLs %+v should never fail: %+v", us, err)
+	}
+	t.peers[id] = startGroupPeer(t.roundTripper, urls, t.id, id, t.clusterID, t.raft, t.errorc)
+}
+
+func (t *multiTransport) RemovePeer(id types.ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.peers[id]; ok {
+		p.stop()
+		delete(t.peers, id)
+	}
+}
+
+func (t *multiTransport) RemoveAllPeers() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, p := range t.peers {
+		p.stop()
+		delete(t.peers, id)
+	}
+}
+
+func (t *multiTransport) UpdatePeer(id types.ID, us []string) {
+	p := t.get(id)
+	if p == nil {
+		return
+	}
+	urls, err := types.NewURLs(us)
+	if err != nil {
+		plog.Panicf("newURLs %+v should never fail: %+v", us, err)
+	}
+	p.picker.update(urls)
+}
+
+func (t *multiTransport) ActiveSince(id types.ID) time.Time {
+	if p := t.get(id); p != nil {
+		p.status.mu.Lock()
+		defer p.status.mu.Unlock()
+		return p.status.activeSince
+	}
+	return time.Time{}
+}
+
+// groupPeer is the representative of a remote MultiNode. The messages of
+// all the groups are sent to the remote over the stream it opens to the
+// local member, and received over the stream the local member opens to it.
+type groupPeer struct {
+	id     types.ID
+	r      MultiRaft
+	status *peerStatus
+	picker *urlPicker
+
+	writer *groupStreamWriter
+	reader *groupStreamReader
+
+	recvc  chan groupMessage
+	cancel func()
+	stopc  chan struct{}
+	done   chan struct{}
+}
+
+func startGroupPeer(tr http.RoundTripper, urls types.URLs, local, to, cid types.ID, r MultiRaft, errorc chan error) *groupPeer {
+	status := newPeerStatus(to)
+	p := &groupPeer{
+		id:     to,
+		r:      r,
+		status: status,
+		picker: newURLPicker(urls),
+		writer: startGroupStreamWriter(to, status, r),
+		recvc:  make(chan groupMessage, recvBufSize),
+		stopc:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	p.reader = startGroupStreamReader(tr, p.picker, local, to, cid, status, p.recvc, errorc)
+
+	// Process may block on proposals when there is no leader, so it is
+	// canceled on stop.
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(context.Background())
+	go func() {
+		for {
+			select {
+			case gm := <-p.recvc:
+				if err := r.Process(ctx, gm.group, gm.msg); err != nil {
+					plog.Warningf("failed to process raft message of group %d (%v)", gm.group, err)
+				}
+			case <-p.stopc:
+				close(p.done)
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// send sends the given message to the remote peer. It is non-blocking, and
+// reports the message as unreachable if it cannot be sent out.
+func (p *groupPeer) send(gm groupMessage) {
+	writec, ok := p.writer.writec()
+	if ok {
+		select {
+		case writec <- gm:
+			return
+		default:
+		}
+	}
+	p.r.ReportUnreachable(gm.msg.To, gm.group)
+	if isMsgSnap(gm.msg) {
+		p.r.ReportSnapshot(gm.msg.To, gm.group, raft.SnapshotFailure)
+	}
+	reason := "the stream is not established"
+	if ok {
+		reason = "the sending buffer is full"
+	}
+	if p.status.isActive() {
+		plog.Warningf("dropped %s of group %d to %s since %s", gm.msg.Type, gm.group, p.id, reason)
+	} else {
+		plog.Debugf("dropped %s of group %d to %s since %s", gm.msg.Type, gm.group, p.id, reason)
+	}
+}
+
+func (p *groupPeer) stop() {
+	p.cancel()
+	close(p.stopc)
+	<-p.done
+	p.writer.stop()
+	p.reader.stop()
+}
+
+// groupStreamWriter is a long-running go-routine that writes the messages
+// of the groups into the attached outgoingConn.
+type groupStreamWriter struct {
+	id     types.ID
+	status *peerStatus
+	r      MultiRaft
+
+	mu      sync.Mutex // guard field working and closer
+	closer  io.Closer
+	working bool
+
+	msgc  chan groupMessage
+	connc chan *outgoingConn
+	stopc chan struct{}
+	done  chan struct{}
+}
+
+func startGroupStreamWriter(id types.ID, status *peerStatus, r MultiRaft) *groupStreamWriter {
+	w := &groupStreamWriter{
+		id:     id,
+		status: status,
+		r:      r,
+		msgc:   make(chan groupMessage, streamBufSize),
+		connc:  make(chan *outgoingConn),
+		stopc:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (cw *groupStreamWriter) run() {
+	var msgc chan groupMessage
+	var heartbeatc <-chan time.Time
+	var enc *groupMessageEncoder
+	var flusher http.Flusher
+	tickc := time.Tick(ConnReadTimeout / 3)
+	t := string(streamTypeGroup)
+
+	for {
+		select {
+		case <-heartbeatc:
+			start := time.Now()
+			if err := enc.encode(groupMessage{msg: linkHeartbeatMessage}); err != nil {
+				reportSentFailure(t, linkHeartbeatMessage)
+
+				cw.status.deactivate(failureType{source: streamTypeGroup.String(), action: "heartbeat"}, err.Error())
+				cw.close()
+				heartbeatc, msgc = nil, nil
+				continue
+			}
+			flusher.Flush()
+			reportSentDuration(t, linkHeartbeatMessage, time.Since(start))
+		case gm := <-msgc:
+			start := time.Now()
+			if err := enc.encode(gm); err != nil {
+				reportSentFailure(t, gm.msg)
+
+				cw.status.deactivate(failureType{source: streamTypeGroup.String(), action: "write"}, err.Error())
+				cw.close()
+				heartbeatc, msgc = nil, nil
+				cw.r.ReportUnreachable(gm.msg.To, gm.group)
+				continue
+			}
+			flusher.Flush()
+			reportSentDuration(t, gm.msg, time.Since(start))
+		case conn := <-cw.connc:
+			cw.close()
+			enc = &groupMessageEncoder{w: conn.Writer}
+			flusher = conn.Flusher
+			cw.mu.Lock()
+			cw.status.activate()
+			cw.closer = conn.Closer
+			cw.working = true
+			cw.mu.Unlock()
+			heartbeatc, msgc = tickc, cw.msgc
+		case <-cw.stopc:
+			cw.close()
+			close(cw.done)
+			return
+		}
+	}
+}
+
+func (cw *groupStreamWriter) writec() (chan<- groupMessage, bool) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.msgc, cw.working
+}
+
+func (cw *groupStreamWriter) close() {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if !cw.working {
+		return
+	}
+	cw.closer.Close()
+	// report the groups whose messages are dropped
+	groups := make(map[uint64]bool)
+	for len(cw.msgc) > 0 {
+		gm := <-cw.msgc
+		groups[gm.group] = true
+	}
+	for g := range groups {
+		cw.r.ReportUnreachable(uint64(cw.id), g)
+	}
+	cw.msgc = make(chan groupMessage, streamBufSize)
+	cw.working = false
+}
+
+func (cw *groupStreamWriter) attach(conn *outgoingConn) bool {
+	select {
+	case cw.connc <- conn:
+		return true
+	case <-cw.done:
+		return false
+	}
+}
+
+func (cw *groupStreamWriter) stop() {
+	close(cw.stopc)
+	<-cw.done
+}
+
+// groupStreamReader is a long-running go-routine that dials to the group
+// stream endpoint of the remote and reads the messages of the groups from
+// the response body returned. It dials like streamReader.
+type groupStreamReader struct {
+	*streamReader
+	recvc chan<- groupMessage
+}
+
+func startGroupStreamReader(tr http.RoundTripper, picker *urlPicker, local, remote, cid types.ID, status *peerStatus, recvc chan<- groupMessage, errorc chan<- error) *groupStreamReader {
+	r := &groupStreamReader{
+		streamReader: &streamReader{
+			tr:     tr,
+			picker: picker,
+			t:      streamTypeGroup,
+			local:  local,
+			remote: remote,
+			cid:    cid,
+			status: status,
+			errorc: errorc,
+			stopc:  make(chan struct{}),
+			done:   make(chan struct{}),
+		},
+		recvc: recvc,
+	}
+	go r.run()
+	return r
+}
+
+func (cr *groupStreamReader) run() {
+	for {
+		rc, err := cr.dial(streamTypeGroup)
+		if err != nil {
+			cr.status.deactivate(failureType{source: streamTypeGroup.String(), action: "dial"}, err.Error())
+		} else {
+			cr.status.activate()
+			err := cr.decodeLoop(rc)
+			switch {
+			// all data is read out
+			case err == io.EOF:
+			// connection is closed by the remote
+			case isClosedConnectionError(err):
+			default:
+				cr.status.deactivate(failureType{source: streamTypeGroup.String(), action: "read"}, err.Error())
+			}
+		}
+		select {
+		// Wait 100ms to create a new stream, so it doesn't bring too much
+		// overhead when retry.
+		case <-time.After(100 * time.Millisecond):
+		case <-cr.stopc:
+			close(cr.done)
+			return
+		}
+	}
+}
+
+func (cr *groupStreamReader) decodeLoop(rc io.ReadCloser) error {
+	dec := &groupMessageDecoder{r: rc}
+	cr.mu.Lock()
+	cr.closer = rc
+	cr.mu.Unlock()
+
+	for {
+		gm, err := dec.decode()
+		switch {
+		case err != nil:
+			cr.mu.Lock()
+			cr.close()
+			cr.mu.Unlock()
+			return err
+		case isLinkHeartbeatMessage(gm.msg):
+			// do nothing for linkHeartbeatMessage
+		default:
+			select {
+			case cr.recvc <- gm:
+			default:
+				if cr.status.isActive() {
+					plog.Warningf("dropped %s of group %d from %s since receiving buffer is full", gm.msg.Type, gm.group, types.ID(gm.msg.From))
+				} else {
+					plog.Debugf("dropped %s of group %d from %s since receiving buffer is full", gm.msg.Type, gm.group, types.ID(gm.msg.From))
+				}
+			}
+		}
+	}
+}
+
+// groupStreamHandler accepts the group streams opened by the remote peers,
+// and attaches them to the peers to write their messages.
+type groupStreamHandler struct {
+	t *multiTransport
+}
+
+func (h *groupStreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("X-Server-Version", version.Version)
+
+	if err := checkVersionCompability(r.Header.Get("X-Server-From"), serverVersion(r.Header), minClusterVersion(r.Header)); err != nil {
+		plog.Errorf("request received was ignored (%v)", err)
+		http.Error(w, errIncompatibleVersion.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	wcid := h.t.clusterID.String()
+	w.Header().Set("X-Etcd-Cluster-ID", wcid)
+
+	if gcid := r.Header.Get("X-Etcd-Cluster-ID"); gcid != wcid {
+		plog.Errorf("streaming request ignored (cluster ID mismatch got %s want %s)", gcid, wcid)
+		http.Error(w, errClusterIDMismatch.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	if path.Dir(r.URL.Path) != RaftGroupStreamPrefix {
+		plog.Debugf("ignored unexpected streaming request path %s", r.URL.Path)
+		http.Error(w, "invalid path", http.StatusNotFound)
+		return
+	}
+
+	fromStr := path.Base(r.URL.Path)
+	from, err := types.IDFromString(fromStr)
+	if err != nil {
+		plog.Errorf("failed to parse from %s into ID (%v)", fromStr, err)
+		http.Error(w, "invalid from", http.StatusNotFound)
+		return
+	}
+	if h.t.raft.IsIDRemoved(uint64(from)) {
+		plog.Warningf("rejected the stream from peer %s since it was removed", from)
+		http.Error(w, "removed member", http.StatusGone)
+		return
+	}
+	p := h.t.get(from)
+	if p == nil {
+		plog.Errorf("failed to find member %s in cluster %s", from, wcid)
+		http.Error(w, "error sender not found", http.StatusNotFound)
+		return
+	}
+
+	wto := h.t.id.String()
+	if gto := r.Header.Get("X-Raft-To"); gto != wto {
+		plog.Errorf("streaming request ignored (ID mismatch got %s want %s)", gto, wto)
+		http.Error(w, "to field mismatch", http.StatusPreconditionFailed)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	c := newCloseNotifier()
+	conn := &outgoingConn{
+		t:       streamTypeGroup,
+		Writer:  w,
+		Flusher: w.(http.Flusher),
+		Closer:  c,
+	}
+	if !p.writer.attach(conn) {
+		conn.Close()
+		return
+	}
+	<-c.closeNotify()
+}
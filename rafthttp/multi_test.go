@@ -0,0 +1,140 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+func TestMultiTransportSend(t *testing.T) {
+	// member 1
+	tr := NewMultiTransporter(&http.Transport{}, types.ID(1), types.ID(1), &fakeMultiRaft{}, nil)
+	srv := httptest.NewServer(tr.Handler())
+	defer srv.Close()
+
+	// member 2
+	recvc := make(chan groupMessage, 1)
+	tr2 := NewMultiTransporter(&http.Transport{}, types.ID(2), types.ID(1), &fakeMultiRaft{recvc: recvc}, nil)
+	srv2 := httptest.NewServer(tr2.Handler())
+	defer srv2.Close()
+
+	tr.AddPeer(types.ID(2), []string{srv2.URL})
+	defer tr.Stop()
+	tr2.AddPeer(types.ID(1), []string{srv.URL})
+	defer tr2.Stop()
+	if !waitGroupStreamWorking(tr.(*multiTransport).get(types.ID(2))) {
+		t.Fatalf("stream from 1 to 2 is not in work as expected")
+	}
+	if tr.ActiveSince(types.ID(2)).IsZero() {
+		t.Errorf("activeSince = zero, want the time the stream became active")
+	}
+
+	data := []byte("some data")
+	tests := []groupMessage{
+		{1, raftpb.Message{Type: raftpb.MsgProp, From: 1, To: 2, Entries: []raftpb.Entry{{Data: data}}}},
+		{2, raftpb.Message{Type: raftpb.MsgApp, From: 1, To: 2, Term: 1, Index: 3, Entries: []raftpb.Entry{{Index: 4, Term: 1, Data: data}}, Commit: 3}},
+		{3, raftpb.Message{Type: raftpb.MsgSnap, From: 1, To: 2, Term: 1, Snapshot: raftpb.Snapshot{Metadata: raftpb.SnapshotMetadata{Index: 1000, Term: 1}, Data: data}}},
+		{4, raftpb.Message{Type: raftpb.MsgReadIndex, From: 1, To: 2, Entries: []raftpb.Entry{{Data: data}}}},
+		{raft.NodeGroup, raftpb.Message{Type: raftpb.MsgHeartbeat, From: 1, To: 2}},
+		{raft.NodeGroup, raftpb.Message{Type: raftpb.MsgHeartbeatResp, From: 1, To: 2}},
+	}
+	for i, tt := range tests {
+		tr.Send(tt.group, []raftpb.Message{tt.msg})
+		select {
+		case gm := <-recvc:
+			if !reflect.DeepEqual(gm, tt) {
+				t.Errorf("#%d: message = %+v, want %+v", i, gm, tt)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("#%d: message is not received", i)
+		}
+	}
+}
+
+func TestMultiTransportSendUnreachable(t *testing.T) {
+	r := &fakeMultiRaft{}
+	tr := NewMultiTransporter(&http.Transport{}, types.ID(1), types.ID(1), r, nil)
+	defer tr.Stop()
+	// no member 2 is listening
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	tr.AddPeer(types.ID(2), []string{srv.URL})
+
+	tr.Send(3, []raftpb.Message{{Type: raftpb.MsgSnap, From: 1, To: 2}})
+	// unknown peers are ignored
+	tr.Send(4, []raftpb.Message{{Type: raftpb.MsgApp, From: 1, To: 3}})
+	if w := []uint64{3}; !reflect.DeepEqual(r.unreachable, w) {
+		t.Errorf("unreachable groups = %v, want %v", r.unreachable, w)
+	}
+	if w := []uint64{3}; !reflect.DeepEqual(r.snapFailed, w) {
+		t.Errorf("snapshot failed groups = %v, want %v", r.snapFailed, w)
+	}
+	if !tr.ActiveSince(types.ID(2)).IsZero() {
+		t.Errorf("activeSince = %v, want zero", tr.ActiveSince(types.ID(2)))
+	}
+}
+
+func waitGroupStreamWorking(p *groupPeer) bool {
+	for i := 0; i < 1000; i++ {
+		time.Sleep(time.Millisecond)
+		if _, ok := p.writer.writec(); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type fakeMultiRaft struct {
+	recvc     chan<- groupMessage
+	removedID uint64
+
+	mu          sync.Mutex
+	unreachable []uint64
+	snapFailed  []uint64
+}
+
+func (p *fakeMultiRaft) Process(ctx context.Context, group uint64, m raftpb.Message) error {
+	select {
+	case p.recvc <- groupMessage{group: group, msg: m}:
+	default:
+	}
+	return nil
+}
+
+func (p *fakeMultiRaft) IsIDRemoved(id uint64) bool { return id == p.removedID }
+
+func (p *fakeMultiRaft) ReportUnreachable(id, group uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unreachable = append(p.unreachable, group)
+}
+
+func (p *fakeMultiRaft) ReportSnapshot(id, group uint64, status raft.SnapshotStatus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if status == raft.SnapshotFailure {
+		p.snapFailed = append(p.snapFailed, group)
+	}
+}
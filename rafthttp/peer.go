@@ -49,6 +49,7 @@ const (
 	streamAppV2 = "streamMsgAppV2"
 	streamMsg   = "streamMsg"
 	pipelineMsg = "pipeline"
+	snapshotMsg = "snapshot"
 )
 
 type Peer interface {
@@ -69,6 +70,8 @@ type Peer interface {
 	// activeSince returns the time that the connection with the
 	// peer becomes active.
 	activeSince() time.Time
+	// stats returns the status of the connection with the peer.
+	stats() PeerStats
 	// Stop performs any necessary finalization and terminates the peer
 	// elegantly.
 	Stop()
@@ -85,6 +88,8 @@ type Peer interface {
 // to the remote follower node.
 // A pipeline is a series of http clients that send http requests to the remote.
 // It is only used when the stream has not been established.
+// MsgSnap is sent through a dedicated snapshot sender, which streams the
+// v3 database after the message.
 type peer struct {
 	// id of the remote raft peer node
 	id types.ID
@@ -95,7 +100,9 @@ type peer struct {
 	msgAppWriter *streamWriter
 	writer       *streamWriter
 	pipeline     *pipeline
+	snapSender   *snapshotSender
 	msgAppReader *streamReader
+	msgReader    *streamReader
 
 	sendc    chan raftpb.Message
 	recvc    chan raftpb.Message
@@ -111,16 +118,18 @@ type peer struct {
 	done  chan struct{}
 }
 
-func startPeer(tr http.RoundTripper, urls types.URLs, local, to, cid types.ID, r Raft, fs *stats.FollowerStats, errorc chan error, term uint64) *peer {
+func startPeer(tr http.RoundTripper, urls types.URLs, local, to, cid types.ID, r Raft, fs *stats.FollowerStats, errorc chan error, term uint64, snapcfg *SnapshotConfig, comp Compression) *peer {
 	picker := newURLPicker(urls)
 	status := newPeerStatus(to)
+	pc := newPeerCompression(comp)
 	p := &peer{
 		id:           to,
 		r:            r,
 		status:       status,
 		msgAppWriter: startStreamWriter(to, status, fs, r),
 		writer:       startStreamWriter(to, status, fs, r),
-		pipeline:     newPipeline(tr, picker, local, to, cid, status, fs, r, errorc),
+		pipeline:     newPipeline(tr, picker, local, to, cid, status, fs, r, errorc, pc),
+		snapSender:   startSnapshotSender(tr, picker, local, to, cid, status, r, errorc, snapcfg, pc),
 		sendc:        make(chan raftpb.Message),
 		recvc:        make(chan raftpb.Message, recvBufSize),
 		propc:        make(chan raftpb.Message, maxPendingProposals),
@@ -149,7 +158,7 @@ func startPeer(tr http.RoundTripper, urls types.URLs, local, to, cid types.ID, r
 	}()
 
 	p.msgAppReader = startStreamReader(tr, picker, streamTypeMsgAppV2, local, to, cid, status, p.recvc, p.propc, errorc, term)
-	reader := startStreamReader(tr, picker, streamTypeMessage, local, to, cid, status, p.recvc, p.propc, errorc, term)
+	p.msgReader = startStreamReader(tr, picker, streamTypeMessage, local, to, cid, status, p.recvc, p.propc, errorc, term)
 	go func() {
 		var paused bool
 		for {
@@ -187,8 +196,9 @@ func startPeer(tr http.RoundTripper, urls types.URLs, local, to, cid types.ID, r
 				p.msgAppWriter.stop()
 				p.writer.stop()
 				p.pipeline.stop()
+				p.snapSender.stop()
 				p.msgAppReader.stop()
-				reader.stop()
+				p.msgReader.stop()
 				close(p.done)
 				return
 			}
@@ -231,6 +241,23 @@ func (p *peer) attachOutgoingConn(conn *outgoingConn) {
 
 func (p *peer) activeSince() time.Time { return p.status.activeSince }
 
+func (p *peer) stats() PeerStats {
+	ps := p.status.stats()
+	ps.SendingStreams = []string{}
+	for _, w := range []*streamWriter{p.msgAppWriter, p.writer} {
+		if t, ok := w.workingType(); ok {
+			ps.SendingStreams = append(ps.SendingStreams, string(t))
+		}
+	}
+	ps.ReceivingStreams = []string{}
+	for _, r := range []*streamReader{p.msgAppReader, p.msgReader} {
+		if t, ok := r.workingType(); ok {
+			ps.ReceivingStreams = append(ps.ReceivingStreams, string(t))
+		}
+	}
+	return ps
+}
+
 // Pause pauses the peer. The peer will simply drops all incoming
 // messages without retruning an error.
 func (p *peer) Pause() {
@@ -258,9 +285,9 @@ func (p *peer) Stop() {
 func (p *peer) pick(m raftpb.Message) (writec chan<- raftpb.Message, picked string) {
 	var ok bool
 	// Considering MsgSnap may have a big size, e.g., 1G, and will block
-	// stream for a long time, only use one of the N pipelines to send MsgSnap.
+	// stream for a long time, send it through the dedicated snapshot sender.
 	if isMsgSnap(m) {
-		return p.pipeline.msgc, pipelineMsg
+		return p.snapSender.msgc, snapshotMsg
 	} else if writec, ok = p.msgAppWriter.writec(); ok && canUseMsgAppStream(m) {
 		return writec, streamApp
 	} else if writec, ok = p.writer.writec(); ok {
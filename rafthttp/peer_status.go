@@ -16,7 +16,9 @@ package rafthttp
 
 import (
 	"fmt"
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coreos/etcd/pkg/types"
@@ -28,11 +30,19 @@ type failureType struct {
 }
 
 type peerStatus struct {
+	// bytes sent to and received from the peer, accessed atomically.
+	// They are kept first to be 64-bit aligned on 32-bit platforms.
+	sent     int64
+	received int64
+
 	id          types.ID
 	mu          sync.Mutex // protect variables below
 	active      bool
 	failureMap  map[failureType]string
 	activeSince time.Time
+
+	lastError     string
+	lastErrorTime time.Time
 }
 
 func newPeerStatus(id types.ID) *peerStatus {
@@ -62,6 +72,8 @@ func (s *peerStatus) deactivate(failure failureType, reason string) {
 		s.activeSince = time.Time{}
 	}
 	logline := fmt.Sprintf("failed to %s %s on %s (%s)", failure.action, s.id, failure.source, reason)
+	s.lastError = logline
+	s.lastErrorTime = time.Now()
 	if r, ok := s.failureMap[failure]; ok && r == reason {
 		plog.Debugf(logline)
 		return
@@ -75,3 +87,74 @@ func (s *peerStatus) isActive() bool {
 	defer s.mu.Unlock()
 	return s.active
 }
+
+func (s *peerStatus) addSent(n int)     { atomic.AddInt64(&s.sent, int64(n)) }
+func (s *peerStatus) addReceived(n int) { atomic.AddInt64(&s.received, int64(n)) }
+
+// countingWriter counts the bytes written into w as sent to the peer.
+type countingWriter struct {
+	w      io.Writer
+	status *peerStatus
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.status.addSent(n)
+	return n, err
+}
+
+// countingReader counts the bytes read from r as received from the peer.
+type countingReader struct {
+	r      io.Reader
+	status *peerStatus
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.status.addReceived(n)
+	return n, err
+}
+
+// PeerStats is the status of the connection with a peer.
+type PeerStats struct {
+	ID          string    `json:"id"`
+	Active      bool      `json:"active"`
+	ActiveSince time.Time `json:"activeSince"`
+	// the types of the streams used to send messages to the peer and to
+	// receive messages from it
+	SendingStreams   []string `json:"sendingStreams"`
+	ReceivingStreams []string `json:"receivingStreams"`
+	// the bytes of raft messages sent to and received from the peer,
+	// before compression
+	BytesSent     int64 `json:"bytesSent"`
+	BytesReceived int64 `json:"bytesReceived"`
+
+	// the round trip time and the clock difference against the peer in
+	// milliseconds. They are estimated by the prober from the periodic
+	// HTTP round trips to the /raft/probing endpoint of the peer, not from
+	// the stream connections, so they may differ from the latency seen by
+	// raft messages.
+	RTT         float64 `json:"rtt"`
+	ClockDrift  float64 `json:"clockDrift"`
+	ProbesTotal int64   `json:"probesTotal"`
+	ProbesLost  int64   `json:"probesLost"`
+	Healthy     bool    `json:"healthy"`
+
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorTime time.Time `json:"lastErrorTime"`
+}
+
+func (s *peerStatus) stats() PeerStats {
+	ps := PeerStats{
+		ID:            s.id.String(),
+		BytesSent:     atomic.LoadInt64(&s.sent),
+		BytesReceived: atomic.LoadInt64(&s.received),
+	}
+	s.mu.Lock()
+	ps.Active = s.active
+	ps.ActiveSince = s.activeSince
+	ps.LastError = s.lastError
+	ps.LastErrorTime = s.lastErrorTime
+	s.mu.Unlock()
+	return ps
+}
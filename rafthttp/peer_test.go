@@ -15,8 +15,12 @@
 package rafthttp
 
 import (
+	"bytes"
+	"io/ioutil"
+	"strings"
 	"testing"
 
+	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft/raftpb"
 )
 
@@ -30,7 +34,7 @@ func TestPeerPick(t *testing.T) {
 		{
 			true, true,
 			raftpb.Message{Type: raftpb.MsgSnap},
-			pipelineMsg,
+			snapshotMsg,
 		},
 		{
 			true, true,
@@ -65,7 +69,7 @@ func TestPeerPick(t *testing.T) {
 		{
 			false, false,
 			raftpb.Message{Type: raftpb.MsgSnap},
-			pipelineMsg,
+			snapshotMsg,
 		},
 		{
 			false, false,
@@ -78,6 +82,7 @@ func TestPeerPick(t *testing.T) {
 			msgAppWriter: &streamWriter{working: tt.msgappWorking},
 			writer:       &streamWriter{working: tt.messageWorking},
 			pipeline:     &pipeline{},
+			snapSender:   &snapshotSender{},
 		}
 		_, picked := peer.pick(tt.m)
 		if picked != tt.wpicked {
@@ -85,3 +90,31 @@ func TestPeerPick(t *testing.T) {
 		}
 	}
 }
+
+func TestPeerStatusStats(t *testing.T) {
+	status := newPeerStatus(types.ID(2))
+	status.activate()
+	(&countingWriter{w: ioutil.Discard, status: status}).Write(make([]byte, 10))
+	ioutil.ReadAll(&countingReader{r: bytes.NewReader(make([]byte, 5)), status: status})
+	status.deactivate(failureType{source: pipelineMsg, action: "write"}, "some error")
+
+	ps := status.stats()
+	if ps.ID != "2" {
+		t.Errorf("id = %s, want 2", ps.ID)
+	}
+	if ps.Active {
+		t.Errorf("active = true, want false")
+	}
+	if ps.BytesSent != 10 || ps.BytesReceived != 5 {
+		t.Errorf("sent, received = %d, %d, want 10, 5", ps.BytesSent, ps.BytesReceived)
+	}
+	if !strings.Contains(ps.LastError, "some error") || ps.LastErrorTime.IsZero() {
+		t.Errorf("last error = %q at %v, want some error", ps.LastError, ps.LastErrorTime)
+	}
+
+	// the last error is kept once the connection works again
+	status.activate()
+	if ps = status.stats(); !ps.Active || ps.LastError == "" {
+		t.Errorf("active, last error = %t, %q, want true and kept error", ps.Active, ps.LastError)
+	}
+}
@@ -54,6 +54,7 @@ type pipeline struct {
 	fs     *stats.FollowerStats
 	r      Raft
 	errorc chan error
+	comp   *peerCompression
 
 	msgc chan raftpb.Message
 	// wait for the handling routines
@@ -61,7 +62,7 @@ type pipeline struct {
 	stopc chan struct{}
 }
 
-func newPipeline(tr http.RoundTripper, picker *urlPicker, from, to, cid types.ID, status *peerStatus, fs *stats.FollowerStats, r Raft, errorc chan error) *pipeline {
+func newPipeline(tr http.RoundTripper, picker *urlPicker, from, to, cid types.ID, status *peerStatus, fs *stats.FollowerStats, r Raft, errorc chan error, comp *peerCompression) *pipeline {
 	p := &pipeline{
 		from:   from,
 		to:     to,
@@ -72,6 +73,7 @@ func newPipeline(tr http.RoundTripper, picker *urlPicker, from, to, cid types.ID
 		fs:     fs,
 		r:      r,
 		errorc: errorc,
+		comp:   comp,
 		stopc:  make(chan struct{}),
 		msgc:   make(chan raftpb.Message, pipelineBufSize),
 	}
@@ -92,7 +94,8 @@ func (p *pipeline) handle() {
 	defer p.wg.Done()
 	for m := range p.msgc {
 		start := time.Now()
-		err := p.post(pbutil.MustMarshal(&m))
+		data := pbutil.MustMarshal(&m)
+		err := p.post(data)
 		if err == errStopped {
 			return
 		}
@@ -110,6 +113,7 @@ func (p *pipeline) handle() {
 			}
 		} else {
 			p.status.activate()
+			p.status.addSent(len(data))
 			if m.Type == raftpb.MsgApp && p.fs != nil {
 				p.fs.Succ(end.Sub(start))
 			}
@@ -127,19 +131,33 @@ func (p *pipeline) post(data []byte) (err error) {
 	u := p.picker.pick()
 	uu := u
 	uu.Path = RaftPrefix
+	compressed := p.comp.enabled()
+	if compressed {
+		var buf bytes.Buffer
+		cw := newCompressWriter(&buf, pipelineMsg, p.to)
+		cw.Write(data)
+		cw.Close()
+		data = buf.Bytes()
+	}
 	req, err := http.NewRequest("POST", uu.String(), bytes.NewBuffer(data))
 	if err != nil {
 		p.picker.unreachable(u)
 		return err
 	}
 	req.Header.Set("Content-Type", "application/protobuf")
+	if compressed {
+		req.Header.Set("Content-Encoding", string(CompressionGzip))
+	}
 	req.Header.Set("X-Server-From", p.from.String())
 	req.Header.Set("X-Server-Version", version.Version)
 	req.Header.Set("X-Min-Cluster-Version", version.MinClusterVersion)
 	req.Header.Set("X-Etcd-Cluster-ID", p.cid.String())
 
 	var stopped bool
+	// exited is closed once the canceling routine is done with stopped
+	exited := make(chan struct{})
 	defer func() {
+		<-exited
 		if stopped {
 			// rewrite to errStopped so the caller goroutine can stop itself
 			err = errStopped
@@ -148,6 +166,7 @@ func (p *pipeline) post(data []byte) (err error) {
 	done := make(chan struct{}, 1)
 	cancel := httputil.RequestCanceler(p.tr, req)
 	go func() {
+		defer close(exited)
 		select {
 		case <-done:
 		case <-p.stopc:
@@ -169,6 +188,7 @@ func (p *pipeline) post(data []byte) (err error) {
 		return err
 	}
 	resp.Body.Close()
+	p.comp.update(resp.Header)
 
 	switch resp.StatusCode {
 	case http.StatusPreconditionFailed:
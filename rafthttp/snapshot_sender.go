@@ -0,0 +1,416 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/pkg/httputil"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/version"
+)
+
+const (
+	// snapshotChunkSize is the maximum size of the writes of a snapshot
+	// into its connection.
+	snapshotChunkSize = 32 * 1024
+	// maxSnapshotRetries is the number of times in a row the sending of a
+	// snapshot is resumed without progress before it is given up.
+	maxSnapshotRetries = 5
+	// snapshotRetryInterval is the interval between the resumptions of the
+	// sending of a snapshot.
+	snapshotRetryInterval = time.Second
+)
+
+var (
+	errSnapshotResume = errors.New("snapshot resumed by the remote")
+	errMemberRemoved  = errors.New("the member has been permanently removed from the cluster")
+)
+
+// SnapshotConfig configures how the snapshots are sent to the peers.
+type SnapshotConfig struct {
+	// Snapshotter keeps the v3 databases received along the snapshots, and
+	// the temporary copies of the ones being sent.
+	Snapshotter *snap.Snapshotter
+	// DB writes the v3 database sent along the snapshots into w. If it is
+	// nil, the snapshots are sent without any database.
+	DB func(w io.Writer) (int64, error)
+	// Data returns the data of the snapshot of the given metadata, for the
+	// snapshots given without their data, which are kept on disk only. If
+	// it is nil, the snapshots are sent as they are given.
+	Data func(md raftpb.SnapshotMetadata) ([]byte, error)
+	// RateLimit is the maximum rate, in bytes per second, at which a
+	// snapshot is sent to a peer. 0 means no limit.
+	RateLimit uint64
+}
+
+// snapshotSender sends the MsgSnap messages to a peer, each followed by the
+// v3 database, over a dedicated connection. The database is streamed from
+// disk rather than held in memory, and its sending is resumed where it
+// stopped after a connection loss.
+type snapshotSender struct {
+	from, to types.ID
+	cid      types.ID
+
+	tr     http.RoundTripper
+	picker *urlPicker
+	status *peerStatus
+	r      Raft
+	errorc chan error
+	cfg    SnapshotConfig
+	comp   *peerCompression
+
+	msgc  chan raftpb.Message
+	stopc chan struct{}
+	done  chan struct{}
+}
+
+func startSnapshotSender(tr http.RoundTripper, picker *urlPicker, from, to, cid types.ID, status *peerStatus, r Raft, errorc chan error, cfg *SnapshotConfig, comp *peerCompression) *snapshotSender {
+	s := &snapshotSender{
+		from:   from,
+		to:     to,
+		cid:    cid,
+		tr:     tr,
+		picker: picker,
+		status: status,
+		r:      r,
+		errorc: errorc,
+		comp:   comp,
+		msgc:   make(chan raftpb.Message, 1),
+		stopc:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if cfg != nil {
+		s.cfg = *cfg
+	}
+	go s.run()
+	return s
+}
+
+func (s *snapshotSender) stop() {
+	close(s.stopc)
+	<-s.done
+}
+
+func (s *snapshotSender) run() {
+	defer close(s.done)
+	for {
+		select {
+		case m := <-s.msgc:
+			s.send(m)
+		case <-s.stopc:
+			return
+		}
+	}
+}
+
+func (s *snapshotSender) send(m raftpb.Message) {
+	start := time.Now()
+	if len(m.Snapshot.Data) == 0 && s.cfg.Data != nil {
+		data, err := s.cfg.Data(m.Snapshot.Metadata)
+		if err != nil {
+			plog.Errorf("failed to read the snapshot at index %d sent to %s (%v)", m.Snapshot.Metadata.Index, s.to, err)
+			s.fail(m, err)
+			return
+		}
+		m.Snapshot.Data = data
+	}
+	db, err := s.createDB()
+	if err != nil {
+		plog.Errorf("failed to create the database sent along the snapshot to %s (%v)", s.to, err)
+		s.fail(m, err)
+		return
+	}
+	if db != nil {
+		defer db.close()
+	}
+
+	var offset int64
+	for retries := 0; ; {
+		roffset, err := s.post(m, db, offset)
+		if roffset > offset {
+			// the remote made progress
+			retries = 0
+		}
+		offset = roffset
+		switch {
+		case err == nil:
+			s.status.activate()
+			s.r.ReportSnapshot(m.To, raft.SnapshotFinish)
+			reportSentDuration(snapshotMsg, m, time.Since(start))
+			plog.Infof("sent the snapshot at index %d to %s", m.Snapshot.Metadata.Index, s.to)
+			return
+		case err == errStopped:
+			s.r.ReportSnapshot(m.To, raft.SnapshotFailure)
+			return
+		case isSnapshotResumable(err) && retries < maxSnapshotRetries:
+			retries++
+			if err == errSnapshotResume {
+				plog.Infof("resuming the snapshot sent to %s at offset %d", s.to, offset)
+				continue
+			}
+			plog.Warningf("failed to send the snapshot to %s (%v), retrying at offset %d", s.to, err, offset)
+			select {
+			case <-time.After(snapshotRetryInterval):
+			case <-s.stopc:
+				s.r.ReportSnapshot(m.To, raft.SnapshotFailure)
+				return
+			}
+		default:
+			plog.Errorf("failed to send the snapshot to %s (%v)", s.to, err)
+			s.fail(m, err)
+			return
+		}
+	}
+}
+
+func (s *snapshotSender) fail(m raftpb.Message, err error) {
+	reportSentFailure(snapshotMsg, m)
+	s.status.deactivate(failureType{source: snapshotMsg, action: "write"}, err.Error())
+	s.r.ReportUnreachable(m.To)
+	s.r.ReportSnapshot(m.To, raft.SnapshotFailure)
+}
+
+// createDB copies the v3 database into a temporary file, so that its
+// sending can be resumed from a consistent copy. It returns nil if there
+// is no database to send.
+func (s *snapshotSender) createDB() (*snapshotDB, error) {
+	if s.cfg.DB == nil || s.cfg.Snapshotter == nil {
+		return nil, nil
+	}
+	f, err := s.cfg.Snapshotter.CreateTempDB()
+	if err != nil {
+		return nil, err
+	}
+	db := &snapshotDB{f: f}
+	h := sha256.New()
+	if db.size, err = s.cfg.DB(io.MultiWriter(f, h)); err != nil {
+		db.close()
+		return nil, err
+	}
+	db.sum = h.Sum(nil)
+	return db, nil
+}
+
+// post POSTs the given snapshot message followed by the given database from
+// the given offset. If the remote asks for the database from another offset,
+// it returns this offset and errSnapshotResume.
+func (s *snapshotSender) post(m raftpb.Message, db *snapshotDB, offset int64) (roffset int64, err error) {
+	u := s.picker.pick()
+	uu := u
+	uu.Path = RaftSnapshotPrefix
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	req, err := http.NewRequest("POST", uu.String(), pr)
+	if err != nil {
+		s.picker.unreachable(u)
+		return offset, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	compressed := s.comp.enabled()
+	if compressed {
+		req.Header.Set("Content-Encoding", string(CompressionGzip))
+	}
+	req.Header.Set("X-Server-From", s.from.String())
+	req.Header.Set("X-Server-Version", version.Version)
+	req.Header.Set("X-Min-Cluster-Version", version.MinClusterVersion)
+	req.Header.Set("X-Etcd-Cluster-ID", s.cid.String())
+	if db != nil {
+		req.Header.Set("X-Snapshot-DB-Size", strconv.FormatInt(db.size, 10))
+		req.Header.Set("X-Snapshot-DB-Checksum", hex.EncodeToString(db.sum))
+		req.Header.Set("X-Snapshot-DB-Offset", strconv.FormatInt(offset, 10))
+	}
+
+	var stopped bool
+	// exited is closed once the canceling routine is done with stopped
+	exited := make(chan struct{})
+	defer func() {
+		<-exited
+		if stopped {
+			err = errStopped
+		}
+	}()
+	done := make(chan struct{}, 1)
+	cancel := httputil.RequestCanceler(s.tr, req)
+	go func() {
+		defer close(exited)
+		select {
+		case <-done:
+		case <-s.stopc:
+			waitSchedule()
+			stopped = true
+			cancel()
+		}
+	}()
+
+	go func() {
+		// the rate is limited on the wire
+		var w io.Writer = newRateLimitedWriter(pw, s.cfg.RateLimit)
+		var cw *compressWriter
+		if compressed {
+			cw = newCompressWriter(w, snapshotMsg, s.to)
+			w = cw
+		}
+		w = &countingWriter{w: w, status: s.status}
+		err := (&messageEncoder{w: w}).encode(m)
+		if err == nil && db != nil {
+			_, err = io.Copy(w, io.NewSectionReader(db.f, offset, db.size-offset))
+		}
+		if err == nil && cw != nil {
+			err = cw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	resp, err := s.tr.RoundTrip(req)
+	done <- struct{}{}
+	if err != nil {
+		s.picker.unreachable(u)
+		// The remote keeps the part of the database it has received, and
+		// asks for the rest when the sending is resumed.
+		return offset, err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		s.picker.unreachable(u)
+		return offset, err
+	}
+	s.comp.update(resp.Header)
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return offset, nil
+	case http.StatusConflict:
+		roffset, err := strconv.ParseInt(resp.Header.Get("X-Snapshot-DB-Offset"), 10, 64)
+		if err != nil || roffset < 0 || db == nil || roffset > db.size {
+			return offset, fmt.Errorf("unexpected database offset %q asked by the remote", resp.Header.Get("X-Snapshot-DB-Offset"))
+		}
+		return roffset, errSnapshotResume
+	case http.StatusPreconditionFailed:
+		switch strings.TrimSuffix(string(b), "\n") {
+		case errIncompatibleVersion.Error():
+			plog.Errorf("request sent was ignored by peer %s (server version incompatible)", s.to)
+			return offset, errIncompatibleVersion
+		case errClusterIDMismatch.Error():
+			plog.Errorf("request sent was ignored (cluster ID mismatch: remote[%s]=%s, local=%s)",
+				s.to, resp.Header.Get("X-Etcd-Cluster-ID"), s.cid)
+			return offset, errClusterIDMismatch
+		default:
+			return offset, fmt.Errorf("unhandled error %q when precondition failed", string(b))
+		}
+	case http.StatusForbidden:
+		select {
+		case s.errorc <- errMemberRemoved:
+		default:
+		}
+		return offset, errMemberRemoved
+	default:
+		return offset, &snapshotStatusError{code: resp.StatusCode, body: strings.TrimSuffix(string(b), "\n")}
+	}
+}
+
+// snapshotStatusError is the error returned when the remote rejects a
+// snapshot, which is not worth sending again.
+type snapshotStatusError struct {
+	code int
+	body string
+}
+
+func (e *snapshotStatusError) Error() string {
+	return fmt.Sprintf("unexpected http status %s while posting snapshot (%s)", http.StatusText(e.code), e.body)
+}
+
+// isSnapshotResumable returns true if the sending of a snapshot which
+// failed with the given error may be resumed.
+func isSnapshotResumable(err error) bool {
+	switch err.(type) {
+	case *snapshotStatusError:
+		return false
+	}
+	return err != errIncompatibleVersion && err != errClusterIDMismatch && err != errMemberRemoved
+}
+
+// snapshotDB is the temporary copy of the v3 database sent along a snapshot.
+type snapshotDB struct {
+	f    *os.File
+	size int64
+	sum  []byte
+}
+
+func (db *snapshotDB) close() {
+	db.f.Close()
+	if err := os.Remove(db.f.Name()); err != nil {
+		plog.Warningf("failed to remove the temporary database %s (%v)", db.f.Name(), err)
+	}
+}
+
+// rateLimitedWriter writes into w in chunks, at a rate of at most rate bytes
+// per second. It does not limit the rate if rate is 0.
+type rateLimitedWriter struct {
+	w     io.Writer
+	rate  uint64
+	chunk int
+	start time.Time
+	n     uint64
+}
+
+func newRateLimitedWriter(w io.Writer, rate uint64) *rateLimitedWriter {
+	chunk := snapshotChunkSize
+	// keep the chunks small enough for the connection not to stay idle
+	// for long between two of them
+	if rate != 0 && rate/10 < uint64(chunk) {
+		chunk = int(rate/10) + 1
+	}
+	return &rateLimitedWriter{w: w, rate: rate, chunk: chunk, start: time.Now()}
+}
+
+func (w *rateLimitedWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		c := p
+		if len(c) > w.chunk {
+			c = c[:w.chunk]
+		}
+		cn, err := w.w.Write(c)
+		n += cn
+		if err != nil {
+			return n, err
+		}
+		p = p[cn:]
+		if w.rate == 0 {
+			continue
+		}
+		w.n += uint64(cn)
+		want := time.Duration(float64(w.n) / float64(w.rate) * float64(time.Second))
+		if d := want - time.Since(w.start); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,262 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rafthttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
+)
+
+var testSnapMsg = raftpb.Message{
+	Type: raftpb.MsgSnap,
+	From: 1,
+	To:   2,
+	Snapshot: raftpb.Snapshot{
+		Data:     []byte("some snapshot"),
+		Metadata: raftpb.SnapshotMetadata{Index: 5, Term: 1},
+	},
+}
+
+func TestSnapshotSend(t *testing.T) {
+	data := bytes.Repeat([]byte("some database "), 10000)
+	st := newSnapshotTest(t, data, nil)
+	defer st.close()
+
+	st.s.msgc <- testSnapMsg
+	if g := st.status(t); g != raft.SnapshotFinish {
+		t.Fatalf("status = %v, want %v", g, raft.SnapshotFinish)
+	}
+	select {
+	case m := <-st.recvc:
+		if m.Snapshot.Metadata.Index != 5 {
+			t.Errorf("index = %d, want 5", m.Snapshot.Metadata.Index)
+		}
+	default:
+		t.Errorf("the snapshot message is not processed")
+	}
+	b, err := ioutil.ReadFile(st.rss.DBFilePath(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("received database differs from the sent one")
+	}
+	if names, _ := ioutil.ReadDir(st.sdir); len(names) != 0 {
+		t.Errorf("len(sender files) = %d, want 0", len(names))
+	}
+}
+
+func TestSnapshotSendResume(t *testing.T) {
+	data := bytes.Repeat([]byte("some database "), 10000)
+	var (
+		mu      sync.Mutex
+		offsets []string
+	)
+	st := newSnapshotTest(t, data, func(r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		offsets = append(offsets, r.Header.Get("X-Snapshot-DB-Offset"))
+		if len(offsets) == 1 {
+			// lose the connection after the first 1000 bytes of the database
+			r.Body = ioutil.NopCloser(&errAfterReader{r: r.Body, n: 8 + testSnapMsg.Size() + 1000})
+		}
+	})
+	defer st.close()
+
+	st.s.msgc <- testSnapMsg
+	if g := st.status(t); g != raft.SnapshotFinish {
+		t.Fatalf("status = %v, want %v", g, raft.SnapshotFinish)
+	}
+	b, err := ioutil.ReadFile(st.rss.DBFilePath(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("received database differs from the sent one")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(offsets) != 2 || offsets[0] != "0" || offsets[1] != "1000" {
+		t.Errorf("offsets = %v, want [0 1000]", offsets)
+	}
+}
+
+// The data of a snapshot given without it should be read with Data.
+func TestSnapshotSendData(t *testing.T) {
+	st := newSnapshotTest(t, []byte("some database"), nil)
+	defer st.close()
+	st.s.cfg.Data = func(md raftpb.SnapshotMetadata) ([]byte, error) {
+		if md.Index != 5 {
+			t.Errorf("index = %d, want 5", md.Index)
+		}
+		return []byte("some snapshot"), nil
+	}
+
+	m := testSnapMsg
+	m.Snapshot.Data = nil
+	st.s.msgc <- m
+	if g := st.status(t); g != raft.SnapshotFinish {
+		t.Fatalf("status = %v, want %v", g, raft.SnapshotFinish)
+	}
+	select {
+	case m := <-st.recvc:
+		if g := string(m.Snapshot.Data); g != "some snapshot" {
+			t.Errorf("data = %q, want %q", g, "some snapshot")
+		}
+	default:
+		t.Errorf("the snapshot message is not processed")
+	}
+}
+
+func TestSnapshotSendChecksumMismatch(t *testing.T) {
+	st := newSnapshotTest(t, []byte("some database"), func(r *http.Request) {
+		r.Header.Set("X-Snapshot-DB-Checksum", "0123456789abcdef")
+	})
+	defer st.close()
+
+	st.s.msgc <- testSnapMsg
+	if g := st.status(t); g != raft.SnapshotFailure {
+		t.Fatalf("status = %v, want %v", g, raft.SnapshotFailure)
+	}
+	select {
+	case m := <-st.recvc:
+		t.Errorf("unexpected processed message %+v", m)
+	default:
+	}
+	if _, err := os.Stat(st.rss.DBFilePath(5)); !os.IsNotExist(err) {
+		t.Errorf("stat error = %v, want not exist", err)
+	}
+}
+
+func TestRateLimitedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newRateLimitedWriter(&buf, 100*1024)
+	start := time.Now()
+	if _, err := w.Write(make([]byte, 20*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("write took %v, want at least 150ms", d)
+	}
+	if buf.Len() != 20*1024 {
+		t.Errorf("written = %d, want %d", buf.Len(), 20*1024)
+	}
+}
+
+type snapshotTest struct {
+	s       *snapshotSender
+	srv     *httptest.Server
+	sdir    string
+	rdir    string
+	rss     *snap.Snapshotter
+	recvc   chan raftpb.Message
+	statusc chan raft.SnapshotStatus
+}
+
+// newSnapshotTest starts a snapshot sender sending the given database to a
+// snapshot handler. If not nil, hook is called on each request before it
+// is handled.
+func newSnapshotTest(t *testing.T, db []byte, hook func(r *http.Request)) *snapshotTest {
+	sdir, err := ioutil.TempDir(os.TempDir(), "snapsender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdir, err := ioutil.TempDir(os.TempDir(), "snaphandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &snapshotTest{
+		sdir:    sdir,
+		rdir:    rdir,
+		rss:     snap.New(rdir),
+		recvc:   make(chan raftpb.Message, 1),
+		statusc: make(chan raft.SnapshotStatus, 1),
+	}
+	h := newSnapshotHandler(&fakeRaft{recvc: st.recvc}, types.ID(1), st.rss)
+	st.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hook != nil {
+			hook(r)
+		}
+		h.ServeHTTP(w, r)
+	}))
+	cfg := &SnapshotConfig{
+		Snapshotter: snap.New(sdir),
+		DB: func(w io.Writer) (int64, error) {
+			n, err := w.Write(db)
+			return int64(n), err
+		},
+	}
+	picker := mustNewURLPicker(t, []string{st.srv.URL})
+	r := &snapshotStatusRaft{statusc: st.statusc}
+	st.s = startSnapshotSender(&http.Transport{}, picker, types.ID(1), types.ID(2), types.ID(1), newPeerStatus(types.ID(2)), r, nil, cfg, nil)
+	return st
+}
+
+func (st *snapshotTest) status(t *testing.T) raft.SnapshotStatus {
+	select {
+	case s := <-st.statusc:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the snapshot status")
+	}
+	return 0
+}
+
+func (st *snapshotTest) close() {
+	st.s.stop()
+	st.srv.Close()
+	os.RemoveAll(st.sdir)
+	os.RemoveAll(st.rdir)
+}
+
+type snapshotStatusRaft struct {
+	fakeRaft
+	statusc chan raft.SnapshotStatus
+}
+
+func (r *snapshotStatusRaft) ReportSnapshot(id uint64, status raft.SnapshotStatus) {
+	r.statusc <- status
+}
+
+// errAfterReader reads from r and fails after n bytes.
+type errAfterReader struct {
+	r io.Reader
+	n int
+}
+
+func (er *errAfterReader) Read(p []byte) (int, error) {
+	if er.n == 0 {
+		return 0, errors.New("connection lost")
+	}
+	if len(p) > er.n {
+		p = p[:er.n]
+	}
+	n, err := er.r.Read(p)
+	er.n -= n
+	return n, err
+}
@@ -38,6 +38,8 @@ const (
 	streamTypeMessage  streamType = "message"
 	streamTypeMsgAppV2 streamType = "msgappv2"
 	streamTypeMsgApp   streamType = "msgapp"
+	// streamTypeGroup multiplexes the messages of the groups of a MultiNode.
+	streamTypeGroup streamType = "group"
 
 	streamBufSize = 4096
 )
@@ -63,6 +65,8 @@ func (t streamType) endpoint() string {
 		return path.Join(RaftStreamPrefix, "msgapp")
 	case streamTypeMessage:
 		return path.Join(RaftStreamPrefix, "message")
+	case streamTypeGroup:
+		return RaftGroupStreamPrefix
 	default:
 		plog.Panicf("unhandled stream type %v", t)
 		return ""
@@ -77,6 +81,8 @@ func (t streamType) String() string {
 		return "stream MsgApp v2"
 	case streamTypeMessage:
 		return "stream Message"
+	case streamTypeGroup:
+		return "stream Group"
 	default:
 		return "unknown stream"
 	}
@@ -109,9 +115,10 @@ type streamWriter struct {
 	fs     *stats.FollowerStats
 	r      Raft
 
-	mu      sync.Mutex // guard field working and closer
+	mu      sync.Mutex // guard field working, closer and t
 	closer  io.Closer
 	working bool
+	t       streamType
 
 	msgc  chan raftpb.Message
 	connc chan *outgoingConn
@@ -183,6 +190,7 @@ func (cw *streamWriter) run() {
 		case conn := <-cw.connc:
 			cw.close()
 			t = conn.t
+			w := &countingWriter{w: conn.Writer, status: cw.status}
 			switch conn.t {
 			case streamTypeMsgApp:
 				var err error
@@ -190,11 +198,11 @@ func (cw *streamWriter) run() {
 				if err != nil {
 					plog.Panicf("could not parse term %s to uint (%v)", conn.termStr, err)
 				}
-				enc = &msgAppEncoder{w: conn.Writer, fs: cw.fs}
+				enc = &msgAppEncoder{w: w, fs: cw.fs}
 			case streamTypeMsgAppV2:
-				enc = newMsgAppV2Encoder(conn.Writer, cw.fs)
+				enc = newMsgAppV2Encoder(w, cw.fs)
 			case streamTypeMessage:
-				enc = &messageEncoder{w: conn.Writer}
+				enc = &messageEncoder{w: w}
 			default:
 				plog.Panicf("unhandled stream type %s", conn.t)
 			}
@@ -203,6 +211,7 @@ func (cw *streamWriter) run() {
 			cw.status.activate()
 			cw.closer = conn.Closer
 			cw.working = true
+			cw.t = t
 			cw.mu.Unlock()
 			heartbeatc, msgc = tickc, cw.msgc
 		case <-cw.stopc:
@@ -219,6 +228,14 @@ func (cw *streamWriter) writec() (chan<- raftpb.Message, bool) {
 	return cw.msgc, cw.working
 }
 
+// workingType returns the type of the stream the writer writes into, and
+// false if it is not working.
+func (cw *streamWriter) workingType() (streamType, bool) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.t, cw.working
+}
+
 func (cw *streamWriter) close() {
 	cw.mu.Lock()
 	defer cw.mu.Unlock()
@@ -264,6 +281,7 @@ type streamReader struct {
 	msgAppTerm uint64
 	cancel     func()
 	closer     io.Closer
+	workingt   streamType // the type of the stream being read
 	stopc      chan struct{}
 	done       chan struct{}
 }
@@ -330,18 +348,20 @@ func (cr *streamReader) run() {
 
 func (cr *streamReader) decodeLoop(rc io.ReadCloser, t streamType) error {
 	var dec decoder
+	r := &countingReader{r: rc, status: cr.status}
 	cr.mu.Lock()
 	switch t {
 	case streamTypeMsgApp:
-		dec = &msgAppDecoder{r: rc, local: cr.local, remote: cr.remote, term: cr.msgAppTerm}
+		dec = &msgAppDecoder{r: r, local: cr.local, remote: cr.remote, term: cr.msgAppTerm}
 	case streamTypeMsgAppV2:
-		dec = newMsgAppV2Decoder(rc, cr.local, cr.remote)
+		dec = newMsgAppV2Decoder(r, cr.local, cr.remote)
 	case streamTypeMessage:
-		dec = &messageDecoder{r: rc}
+		dec = &messageDecoder{r: r}
 	default:
 		plog.Panicf("unhandled stream type %s", t)
 	}
 	cr.closer = rc
+	cr.workingt = t
 	cr.mu.Unlock()
 
 	for {
@@ -403,6 +423,14 @@ func (cr *streamReader) isWorking() bool {
 	return cr.closer != nil
 }
 
+// workingType returns the type of the stream the reader reads from, and
+// false if it is not working.
+func (cr *streamReader) workingType() (streamType, bool) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.workingt, cr.closer != nil
+}
+
 func (cr *streamReader) dial(t streamType) (io.ReadCloser, error) {
 	u := cr.picker.pick()
 	cr.mu.Lock()
@@ -422,6 +450,7 @@ func (cr *streamReader) dial(t streamType) (io.ReadCloser, error) {
 	req.Header.Set("X-Min-Cluster-Version", version.MinClusterVersion)
 	req.Header.Set("X-Etcd-Cluster-ID", cr.cid.String())
 	req.Header.Set("X-Raft-To", cr.remote.String())
+	req.Header.Set("X-Raft-Accept-Encoding", string(CompressionGzip))
 	if t == streamTypeMsgApp {
 		req.Header.Set("X-Raft-Term", strconv.FormatUint(term, 10))
 	}
@@ -459,6 +488,9 @@ func (cr *streamReader) dial(t streamType) (io.ReadCloser, error) {
 		}
 		return nil, err
 	case http.StatusOK:
+		if resp.Header.Get("X-Raft-Content-Encoding") == string(CompressionGzip) {
+			return newDecompressReadCloser(resp.Body), nil
+		}
 		return resp.Body, nil
 	case http.StatusNotFound:
 		resp.Body.Close()
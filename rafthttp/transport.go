@@ -16,6 +16,7 @@ package rafthttp
 
 import (
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -26,6 +27,7 @@ import (
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft"
 	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap"
 )
 
 var plog = capnslog.NewPackageLogger("github.com/coreos/etcd", "rafthttp")
@@ -74,6 +76,10 @@ type Transporter interface {
 	// If the connection is active since peer was added, it returns the adding time.
 	// If the connection is currently inactive, it returns zero time.
 	ActiveSince(id types.ID) time.Time
+	// PeerStats returns the status of the connections with the peers,
+	// sorted by their IDs. The round trip time and clock drift come from
+	// the /raft/probing round trips of the prober, not from the streams.
+	PeerStats() []PeerStats
 	// Stop closes the connections and stops the transporter.
 	Stop()
 }
@@ -85,6 +91,8 @@ type transport struct {
 	raft         Raft
 	serverStats  *stats.ServerStats
 	leaderStats  *stats.LeaderStats
+	snapshot     *SnapshotConfig
+	compression  Compression
 
 	mu      sync.RWMutex         // protect the term, remote and peer map
 	term    uint64               // the latest term that has been observed
@@ -95,7 +103,11 @@ type transport struct {
 	errorc chan error
 }
 
-func NewTransporter(rt http.RoundTripper, id, cid types.ID, r Raft, errorc chan error, ss *stats.ServerStats, ls *stats.LeaderStats) Transporter {
+// NewTransporter returns a Transporter. The given SnapshotConfig, which
+// may be nil, configures how the snapshots are sent and received. The
+// messages sent to the peers are compressed with the given Compression if
+// they can decompress it.
+func NewTransporter(rt http.RoundTripper, id, cid types.ID, r Raft, errorc chan error, ss *stats.ServerStats, ls *stats.LeaderStats, snapcfg *SnapshotConfig, comp Compression) Transporter {
 	return &transport{
 		roundTripper: rt,
 		id:           id,
@@ -103,6 +115,8 @@ func NewTransporter(rt http.RoundTripper, id, cid types.ID, r Raft, errorc chan
 		raft:         r,
 		serverStats:  ss,
 		leaderStats:  ls,
+		snapshot:     snapcfg,
+		compression:  comp,
 		remotes:      make(map[types.ID]*remote),
 		peers:        make(map[types.ID]Peer),
 
@@ -113,14 +127,22 @@ func NewTransporter(rt http.RoundTripper, id, cid types.ID, r Raft, errorc chan
 
 func (t *transport) Handler() http.Handler {
 	pipelineHandler := NewHandler(t.raft, t.clusterID)
-	streamHandler := newStreamHandler(t, t.raft, t.id, t.clusterID)
+	streamHandler := newStreamHandler(t, t.raft, t.id, t.clusterID, t.compression)
 	mux := http.NewServeMux()
 	mux.Handle(RaftPrefix, pipelineHandler)
 	mux.Handle(RaftStreamPrefix+"/", streamHandler)
+	mux.Handle(RaftSnapshotPrefix, newSnapshotHandler(t.raft, t.clusterID, t.snapshotter()))
 	mux.Handle(ProbingPrefix, probing.NewHandler())
 	return mux
 }
 
+func (t *transport) snapshotter() *snap.Snapshotter {
+	if t.snapshot == nil {
+		return nil
+	}
+	return t.snapshot.Snapshotter
+}
+
 func (t *transport) Get(id types.ID) Peer {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -151,7 +173,11 @@ func (t *transport) Send(msgs []raftpb.Message) {
 			t.maybeUpdatePeersTerm(m.Term)
 		}
 
+		t.mu.RLock()
 		p, ok := t.peers[to]
+		g, rok := t.remotes[to]
+		t.mu.RUnlock()
+
 		if ok {
 			if m.Type == raftpb.MsgApp {
 				t.serverStats.SendAppendReq(m.Size())
@@ -160,8 +186,7 @@ func (t *transport) Send(msgs []raftpb.Message) {
 			continue
 		}
 
-		g, ok := t.remotes[to]
-		if ok {
+		if rok {
 			g.Send(m)
 			continue
 		}
@@ -193,7 +218,7 @@ func (t *transport) AddRemote(id types.ID, us []string) {
 	if err != nil {
 		plog.Panicf("newURLs %+v should never fail: %+v", us, err)
 	}
-	t.remotes[id] = startRemote(t.roundTripper, urls, t.id, id, t.clusterID, t.raft, t.errorc)
+	t.remotes[id] = startRemote(t.roundTripper, urls, t.id, id, t.clusterID, t.raft, t.errorc, t.compression)
 }
 
 func (t *transport) AddPeer(id types.ID, us []string) {
@@ -207,7 +232,7 @@ func (t *transport) AddPeer(id types.ID, us []string) {
 		plog.Panicf("newURLs %+v should never fail: %+v", us, err)
 	}
 	fs := t.leaderStats.Follower(id.String())
-	t.peers[id] = startPeer(t.roundTripper, urls, t.id, id, t.clusterID, t.raft, fs, t.errorc, t.term)
+	t.peers[id] = startPeer(t.roundTripper, urls, t.id, id, t.clusterID, t.raft, fs, t.errorc, t.term, t.snapshot, t.compression)
 	addPeerToProber(t.prober, id.String(), us)
 }
 
@@ -263,6 +288,33 @@ func (t *transport) ActiveSince(id types.ID) time.Time {
 	return time.Time{}
 }
 
+func (t *transport) PeerStats() []PeerStats {
+	t.mu.RLock()
+	ids := make(types.Uint64Slice, 0, len(t.peers))
+	for id := range t.peers {
+		ids = append(ids, uint64(id))
+	}
+	sort.Sort(ids)
+	pss := make([]PeerStats, len(ids))
+	for i, id := range ids {
+		pss[i] = t.peers[types.ID(id)].stats()
+	}
+	t.mu.RUnlock()
+
+	for i := range pss {
+		s, err := t.prober.Status(pss[i].ID)
+		if err != nil {
+			continue
+		}
+		pss[i].RTT = float64(s.SRTT()) / float64(time.Millisecond)
+		pss[i].ClockDrift = float64(s.ClockDiff()) / float64(time.Millisecond)
+		pss[i].ProbesTotal = s.Total()
+		pss[i].ProbesLost = s.Loss()
+		pss[i].Healthy = s.Health()
+	}
+	return pss
+}
+
 type Pausable interface {
 	Pause()
 	Resume()
@@ -0,0 +1,136 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	dbSuffix        = ".snap.db"
+	partialDBSuffix = dbSuffix + ".part"
+)
+
+var ErrDBChecksumMismatch = errors.New("snap: database checksum mismatch")
+
+// DBFilePath returns the path of the v3 database received along the
+// snapshot at the given index.
+func (s *Snapshotter) DBFilePath(index uint64) string {
+	return path.Join(s.dir, fmt.Sprintf("%016x%s", index, dbSuffix))
+}
+
+// CreateTempDB creates a temporary file in the snapshot directory, into
+// which the v3 database sent along a snapshot can be written. The caller
+// is responsible for removing it.
+func (s *Snapshotter) CreateTempDB() (*os.File, error) {
+	return ioutil.TempFile(s.dir, "tmp"+dbSuffix+".")
+}
+
+// PartialDB is a v3 database being received along a snapshot. It may be
+// received in several parts, so that a transfer interrupted by a connection
+// loss can be resumed where it stopped.
+type PartialDB struct {
+	index uint64
+	sum   []byte
+	path  string
+	f     *os.File
+	h     hash.Hash
+	size  int64
+}
+
+// CreatePartialDB starts receiving the v3 database with the given sha256
+// checksum along the snapshot at the given index. The partial databases
+// left over by earlier transfers are removed.
+func (s *Snapshotter) CreatePartialDB(index uint64, sum []byte) (*PartialDB, error) {
+	s.purgePartialDBs()
+	p := path.Join(s.dir, fmt.Sprintf("%016x%s", index, partialDBSuffix))
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &PartialDB{
+		index: index,
+		sum:   sum,
+		path:  p,
+		f:     f,
+		h:     sha256.New(),
+	}, nil
+}
+
+func (s *Snapshotter) purgePartialDBs() {
+	names, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		plog.Warningf("cannot read snapshot directory %v: %v", s.dir, err)
+		return
+	}
+	for _, fi := range names {
+		if !strings.HasSuffix(fi.Name(), partialDBSuffix) {
+			continue
+		}
+		if err := os.Remove(path.Join(s.dir, fi.Name())); err != nil {
+			plog.Warningf("cannot remove partial database file %v: %v", fi.Name(), err)
+		}
+	}
+}
+
+// Is returns true if p is the v3 database with the given checksum received
+// along the snapshot at the given index.
+func (p *PartialDB) Is(index uint64, sum []byte) bool {
+	return p.index == index && bytes.Equal(p.sum, sum)
+}
+
+// Size returns the number of bytes received so far.
+func (p *PartialDB) Size() int64 { return p.size }
+
+// ReadFrom appends the data read from r until EOF or an error, and syncs it
+// to disk. The data appended before an error is kept.
+func (p *PartialDB) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(io.MultiWriter(p.f, p.h), r)
+	p.size += n
+	if serr := p.f.Sync(); err == nil {
+		err = serr
+	}
+	return n, err
+}
+
+// Commit verifies the checksum of the complete database and moves it to
+// DBFilePath of its index. If the checksum does not match, the database is
+// removed and ErrDBChecksumMismatch is returned.
+func (p *PartialDB) Commit() error {
+	if !bytes.Equal(p.h.Sum(nil), p.sum) {
+		plog.Errorf("corrupted database file %v: checksum mismatch", p.path)
+		p.Close()
+		return ErrDBChecksumMismatch
+	}
+	if err := p.f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(p.path, path.Join(path.Dir(p.path), fmt.Sprintf("%016x%s", p.index, dbSuffix)))
+}
+
+// Close abandons the partial database and removes it.
+func (p *PartialDB) Close() error {
+	p.f.Close()
+	return os.Remove(p.path)
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPartialDB(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "snapdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ss := New(dir)
+	data := []byte("some database")
+	sum := sha256.Sum256(data)
+
+	// a partial database left over by an earlier transfer
+	if _, err := ss.CreatePartialDB(1, sum[:]); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ss.CreatePartialDB(2, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Is(2, sum[:]) || p.Is(1, sum[:]) {
+		t.Errorf("partial database does not match its index")
+	}
+	for i, b := range [][]byte{data[:4], data[4:]} {
+		if n := p.Size(); n != int64(4*i) {
+			t.Errorf("#%d: size = %d, want %d", i, n, 4*i)
+		}
+		if _, err := p.ReadFrom(bytes.NewReader(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(ss.DBFilePath(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("database = %q, want %q", b, data)
+	}
+	if names, err := ss.snapNames(); err != ErrNoSnapshot {
+		t.Errorf("snapNames() = %v, %v, want %v", names, err, ErrNoSnapshot)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 {
+		t.Errorf("len(files) = %d, want 1", len(fis))
+	}
+}
+
+func TestPartialDBChecksumMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "snapdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ss := New(dir)
+	sum := sha256.Sum256([]byte("some database"))
+
+	p, err := ss.CreatePartialDB(1, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ReadFrom(bytes.NewReader([]byte("other database"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Commit(); err != ErrDBChecksumMismatch {
+		t.Errorf("err = %v, want %v", err, ErrDBChecksumMismatch)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 0 {
+		t.Errorf("len(files) = %d, want 0", len(fis))
+	}
+}
@@ -0,0 +1,388 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/coreos/etcd/pkg/pbutil"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// A snapshot file written with a compression starts with fileMagic and
+// fileVersion, followed by the marshalled raft snapshot and its sha256
+// checksum. Without compression, the files are written as by previous
+// versions, which can read them: a snappb.Snapshot holding the marshalled
+// raft snapshot, checksummed with crc32.
+//
+// A snapshot file whose data is streamed into it, as written by
+// SaveSnapFrom, starts with fileMagic and fileVersionStream, followed by
+// the length of the marshalled metadata of the snapshot, the marshalled
+// metadata, the data of the snapshot and the sha256 checksum of what follows
+// fileVersionStream.
+const (
+	fileMagic         = "\x00etcdsnp"
+	fileVersion       = 1
+	fileVersionStream = 2
+)
+
+// Compression is the compression of the data of the snapshots saved.
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+)
+
+// The compressed data of a snapshot starts with compressedMagic and the
+// compression, followed by the compressed data. The data is compressed before
+// it is encrypted.
+const (
+	compressedMagic = "\x00etcdcmp"
+
+	compressedGzip byte = 1
+)
+
+var (
+	ErrChecksumMismatch   = errors.New("snap: checksum mismatch")
+	ErrUnknownVersion     = errors.New("snap: unknown snapshot file version")
+	ErrUnknownCompression = errors.New("snap: unknown compression")
+)
+
+// ParseCompression returns the Compression named by s.
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(s); c {
+	case CompressionNone, CompressionGzip:
+		return c, nil
+	case "":
+		return CompressionNone, nil
+	default:
+		return "", fmt.Errorf("snap: unknown compression %q", s)
+	}
+}
+
+// writeSnapFile writes the snapshot file at fpath with write. The file is
+// written to a temporary file first, which is synced and renamed to fpath,
+// and the directory is synced, so that fpath is either complete or absent.
+func writeSnapFile(fpath string, write func(w io.Writer) error) error {
+	tmp := fpath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, fpath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(path.Dir(fpath))
+}
+
+// writeSnap writes the given snapshot into w, followed by its sha256
+// checksum if withSHA256 is true, or as a snappb.Snapshot otherwise. The
+// data of the snapshot is written as is, without marshalling the snapshot
+// into a buffer first.
+func writeSnap(w io.Writer, snapshot *raftpb.Snapshot, withSHA256 bool) error {
+	bw := bufio.NewWriter(w)
+	if !withSHA256 {
+		// the crc is computed over the marshalled snapshot, which is
+		// written twice. bw keeps the first write error until Flush.
+		crc := crc32.New(crcTable)
+		if err := writeSnapshotPb(crc, snapshot); err != nil {
+			return err
+		}
+		bw.WriteByte(0x8) // snappb.Snapshot.Crc
+		writeUvarint(bw, uint64(crc.Sum32()))
+		bw.WriteByte(0x12) // snappb.Snapshot.Data
+		writeUvarint(bw, uint64(snapshot.Size()))
+		if err := writeSnapshotPb(bw, snapshot); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
+	if _, err := bw.WriteString(fileMagic); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(fileVersion); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if err := writeSnapshotPb(io.MultiWriter(bw, h), snapshot); err != nil {
+		return err
+	}
+	if _, err := bw.Write(h.Sum(nil)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeSnapStream writes into w a snapshot of the given metadata, whose data
+// is written by data, in the format of fileVersionStream.
+func writeSnapStream(w io.Writer, md *raftpb.SnapshotMetadata, data func(w io.Writer) error) error {
+	bw := bufio.NewWriter(w)
+	h := sha256.New()
+	mw := io.MultiWriter(bw, h)
+	if _, err := bw.WriteString(fileMagic); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(fileVersionStream); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	b := pbutil.MustMarshal(md)
+	writeUvarint(&buf, uint64(len(b)))
+	buf.Write(b)
+	if _, err := mw.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := data(mw); err != nil {
+		return err
+	}
+	if _, err := bw.Write(h.Sum(nil)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeSnapshotPb writes into w the protobuf encoding of the given snapshot,
+// as marshalled by raftpb, without copying its data.
+func writeSnapshotPb(w io.Writer, snapshot *raftpb.Snapshot) error {
+	var buf bytes.Buffer
+	if snapshot.Data != nil {
+		buf.WriteByte(0xa) // raftpb.Snapshot.Data
+		writeUvarint(&buf, uint64(len(snapshot.Data)))
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		if _, err := w.Write(snapshot.Data); err != nil {
+			return err
+		}
+		buf.Reset()
+	}
+	buf.WriteByte(0x12) // raftpb.Snapshot.Metadata
+	writeUvarint(&buf, uint64(snapshot.Metadata.Size()))
+	buf.Write(pbutil.MustMarshal(&snapshot.Metadata))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeUvarint(w io.ByteWriter, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	for _, c := range b[:n] {
+		w.WriteByte(c)
+	}
+}
+
+// readSnap decodes the given content of a snapshot file written by
+// writeSnap or writeSnapStream.
+func readSnap(b []byte) (*raftpb.Snapshot, error) {
+	if len(b) <= len(fileMagic) {
+		return nil, ErrUnknownVersion
+	}
+	ver := b[len(fileMagic)]
+	if ver != fileVersion && ver != fileVersionStream {
+		return nil, ErrUnknownVersion
+	}
+	if len(b) < len(fileMagic)+1+sha256.Size {
+		return nil, ErrEmptySnapshot
+	}
+	payload, sum := b[len(fileMagic)+1:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	if s := sha256.Sum256(payload); !bytes.Equal(s[:], sum) {
+		return nil, ErrChecksumMismatch
+	}
+	var snap raftpb.Snapshot
+	if ver == fileVersion {
+		if err := snap.Unmarshal(payload); err != nil {
+			return nil, err
+		}
+		return &snap, nil
+	}
+	l, n := binary.Uvarint(payload)
+	if n <= 0 || l > uint64(len(payload)-n) {
+		return nil, ErrEmptySnapshot
+	}
+	if err := snap.Metadata.Unmarshal(payload[n : n+int(l)]); err != nil {
+		return nil, err
+	}
+	snap.Data = payload[n+int(l):]
+	return &snap, nil
+}
+
+// isSnapStream returns whether the snapshot file at fpath is written by
+// writeSnapStream.
+func isSnapStream(fpath string) (bool, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	b := make([]byte, len(fileMagic)+1)
+	if _, err := io.ReadFull(f, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(b[:len(fileMagic)]) == fileMagic && b[len(fileMagic)] == fileVersionStream, nil
+}
+
+// openSnapStream opens the snapshot file at fpath, written by
+// writeSnapStream, and checks its checksum. It returns the file with the
+// metadata of the snapshot and a reader of its data out of the file.
+func openSnapStream(fpath string) (*os.File, *raftpb.SnapshotMetadata, *io.SectionReader, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	md, data, err := readSnapStream(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	return f, md, data, nil
+}
+
+func readSnapStream(f *os.File) (*raftpb.SnapshotMetadata, *io.SectionReader, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	size := fi.Size() - sha256.Size
+	hdr := len(fileMagic) + 1
+	if size < int64(hdr) {
+		return nil, nil, ErrEmptySnapshot
+	}
+	h := sha256.New()
+	if _, err := f.Seek(int64(hdr), os.SEEK_SET); err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.CopyN(h, f, size-int64(hdr)); err != nil {
+		return nil, nil, err
+	}
+	sum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(f, sum); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return nil, nil, ErrChecksumMismatch
+	}
+
+	br := bufio.NewReader(io.NewSectionReader(f, int64(hdr), size-int64(hdr)))
+	l, err := binary.ReadUvarint(br)
+	if err != nil || l > uint64(size) {
+		return nil, nil, ErrEmptySnapshot
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return nil, nil, ErrEmptySnapshot
+	}
+	var md raftpb.SnapshotMetadata
+	if err := md.Unmarshal(b); err != nil {
+		return nil, nil, err
+	}
+	off := int64(hdr+uvarintSize(l)) + int64(l)
+	return &md, io.NewSectionReader(f, off, size-off), nil
+}
+
+func uvarintSize(v uint64) int {
+	var b [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(b[:], v)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// compress returns data compressed with c.
+func compress(data []byte, c Compression) ([]byte, error) {
+	if c != CompressionGzip {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	err := compressTo(&buf, c, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	return buf.Bytes(), err
+}
+
+// compressTo writes into w the data written by write, compressed with c.
+func compressTo(w io.Writer, c Compression, write func(w io.Writer) error) error {
+	if c != CompressionGzip {
+		return write(w)
+	}
+	if _, err := io.WriteString(w, compressedMagic+string(compressedGzip)); err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(w)
+	if err := write(gw); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// decompress returns the given data decompressed, if it is compressed.
+func decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(compressedMagic)) {
+		return data, nil
+	}
+	r, err := decompressReader(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// decompressReader returns a reader of the data read from r decompressed,
+// if it is compressed, or r otherwise.
+func decompressReader(r *bufio.Reader) (io.Reader, error) {
+	b, err := r.Peek(len(compressedMagic) + 1)
+	if !bytes.HasPrefix(b, []byte(compressedMagic)) {
+		return r, nil
+	}
+	if err != nil || b[len(compressedMagic)] != compressedGzip {
+		return nil, ErrUnknownCompression
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(len(b))); err != nil {
+		return nil, err
+	}
+	return gzip.NewReader(r)
+}
@@ -0,0 +1,114 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"os"
+	"path"
+	"time"
+
+	"github.com/coreos/etcd/pkg/fileutil"
+)
+
+// quarantineDir is the directory, in the directory of the snapshots, the
+// broken snapshot files are moved to.
+const quarantineDir = "quarantine"
+
+// Purge removes the snapshot files which exceed the limits of opts, every
+// interval until stop is closed. Only the valid snapshot files count as
+// retained; the other ones are quarantined, so that they do not get loaded.
+// A file is read to be verified once, unless this Snapshotter saved or loaded
+// it. An error stops the purge and is sent on the returned channel.
+func (s *Snapshotter) Purge(opts fileutil.PurgeOptions, interval time.Duration, stop <-chan struct{}) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			if err := s.purge(opts); err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case <-time.After(interval):
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return errc
+}
+
+func (s *Snapshotter) purge(opts fileutil.PurgeOptions) error {
+	names, err := s.snapNames()
+	if err == ErrNoSnapshot {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// valid is the number of valid snapshots newer than the current one.
+	valid := 0
+	for _, name := range names {
+		fpath := path.Join(s.dir, name)
+		if !opts.Exceeds(fpath, valid) {
+			if s.isVerified(name) {
+				valid++
+			} else if _, err := s.loadSnap(name); err == nil {
+				valid++
+			}
+			continue
+		}
+		err := fileutil.RetireFile(fpath, opts.Archiver)
+		if _, ok := err.(*fileutil.ArchiveError); ok {
+			plog.Warningf("%v, retrying later", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		delete(s.verified, name)
+		s.mu.Unlock()
+		plog.Infof("purged snapshot file %s successfully", name)
+	}
+	return nil
+}
+
+// quarantine moves the broken snapshot file of the given name to the
+// quarantine directory.
+func (s *Snapshotter) quarantine(name string) {
+	qdir := path.Join(s.dir, quarantineDir)
+	if err := os.MkdirAll(qdir, 0700); err != nil {
+		plog.Warningf("cannot create quarantine directory %v: %v", qdir, err)
+		return
+	}
+	fpath, qpath := path.Join(s.dir, name), path.Join(qdir, name)
+	if err := os.Rename(fpath, qpath); err != nil {
+		plog.Warningf("cannot quarantine broken snapshot file %v to %v: %v", fpath, qpath, err)
+		return
+	}
+	plog.Warningf("quarantined broken snapshot file %v to %v", fpath, qpath)
+}
+
+func (s *Snapshotter) setVerified(name string) {
+	s.mu.Lock()
+	s.verified[name] = true
+	s.mu.Unlock()
+}
+
+func (s *Snapshotter) isVerified(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.verified[name]
+}
@@ -0,0 +1,75 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"io"
+	"os"
+	"path"
+
+	"github.com/coreos/etcd/pkg/encryption"
+)
+
+// RotateKey rewraps the data keys of the encrypted snapshots in dir,
+// unwrapped by from, with the key-encryption key of to. The snapshots
+// which are not encrypted are left untouched.
+func RotateKey(dir string, from, to encryption.KeyProvider) error {
+	names, err := New(dir).snapNames()
+	if err == ErrNoSnapshot {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fpath := path.Join(dir, name)
+		snap, err := Read(fpath)
+		if err != nil {
+			return err
+		}
+		if !encryption.IsSealed(snap.Data) {
+			continue
+		}
+		if snap.Data, err = encryption.Rewrap(snap.Data, from, to); err != nil {
+			return err
+		}
+		withSHA256, err := hasFileMagic(fpath)
+		if err != nil {
+			return err
+		}
+		err = writeSnapFile(fpath, func(w io.Writer) error {
+			return writeSnap(w, snap, withSHA256)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasFileMagic returns whether the snapshot file at fpath is written in the
+// format with a sha256 checksum, to be rewritten in the same format.
+func hasFileMagic(fpath string) (bool, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	b := make([]byte, len(fileMagic))
+	if _, err := io.ReadFull(f, b); err != nil && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return string(b) == fileMagic, nil
+}
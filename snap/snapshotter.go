@@ -15,17 +15,21 @@
 package snap
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/coreos/etcd/pkg/pbutil"
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/raft"
 	"github.com/coreos/etcd/raft/raftpb"
 	"github.com/coreos/etcd/snap/snappb"
@@ -43,16 +47,52 @@ var (
 	ErrNoSnapshot    = errors.New("snap: no available snapshot")
 	ErrEmptySnapshot = errors.New("snap: empty snapshot")
 	ErrCRCMismatch   = errors.New("snap: crc mismatch")
+	ErrEncrypted     = errors.New("snap: encrypted snapshot, but no key provider given")
 	crcTable         = crc32.MakeTable(crc32.Castagnoli)
 )
 
 type Snapshotter struct {
 	dir string
+	// kp, if not nil, encrypts the data of the snapshots saved, and
+	// decrypts the one of the encrypted snapshots loaded.
+	kp encryption.KeyProvider
+	// compression is the compression of the data of the snapshots saved.
+	compression Compression
+
+	// mu protects verified, the names of the snapshot files known to be
+	// valid, which are not read again when purging.
+	mu       sync.Mutex
+	verified map[string]bool
+}
+
+// Options are the options of a Snapshotter.
+type Options struct {
+	// KeyProvider, if not nil, encrypts the data of the snapshots with a
+	// data key per file, wrapped by KeyProvider.
+	KeyProvider encryption.KeyProvider
+	// Compression is the compression of the data of the snapshots saved.
+	// The snapshots loaded are decompressed whatever their compression.
+	Compression Compression
 }
 
 func New(dir string) *Snapshotter {
+	return NewWithOptions(dir, Options{})
+}
+
+// NewWithKeyProvider returns a Snapshotter like New, which encrypts the
+// data of the snapshots with a data key per file, wrapped by kp.
+func NewWithKeyProvider(dir string, kp encryption.KeyProvider) *Snapshotter {
+	return NewWithOptions(dir, Options{KeyProvider: kp})
+}
+
+// NewWithOptions returns a Snapshotter of the snapshots in dir, which saves
+// the snapshots as configured by opts.
+func NewWithOptions(dir string, opts Options) *Snapshotter {
 	return &Snapshotter{
-		dir: dir,
+		dir:         dir,
+		kp:          opts.KeyProvider,
+		compression: opts.Compression,
+		verified:    make(map[string]bool),
 	}
 }
 
@@ -66,22 +106,74 @@ func (s *Snapshotter) SaveSnap(snapshot raftpb.Snapshot) error {
 func (s *Snapshotter) save(snapshot *raftpb.Snapshot) error {
 	start := time.Now()
 
-	fname := fmt.Sprintf("%016x-%016x%s", snapshot.Metadata.Term, snapshot.Metadata.Index, snapSuffix)
-	b := pbutil.MustMarshal(snapshot)
-	crc := crc32.Update(0, crcTable, b)
-	snap := snappb.Snapshot{Crc: crc, Data: b}
-	d, err := snap.Marshal()
+	fname := snapName(snapshot)
+	if err := s.encode(snapshot); err != nil {
+		return err
+	}
+	marshallingDurations.Observe(float64(time.Since(start).Nanoseconds() / int64(time.Microsecond)))
+
+	err := writeSnapFile(path.Join(s.dir, fname), func(w io.Writer) error {
+		return writeSnap(w, snapshot, s.compression == CompressionGzip)
+	})
+	if err != nil {
+		return err
+	}
+	saveDurations.Observe(float64(time.Since(start).Nanoseconds() / int64(time.Microsecond)))
+	s.setVerified(fname)
+	return nil
+}
+
+// SaveSnapFrom saves a snapshot of the given metadata, whose data is written
+// by save. The data is compressed as configured, and streamed into the file
+// as save writes it rather than held in memory, unless it is encrypted: it
+// is then sealed as a whole. The file can only be read by the versions
+// which introduced SaveSnapFrom.
+func (s *Snapshotter) SaveSnapFrom(md raftpb.SnapshotMetadata, save func(w io.Writer) error) error {
+	start := time.Now()
+
+	fname := snapName(&raftpb.Snapshot{Metadata: md})
+	err := writeSnapFile(path.Join(s.dir, fname), func(w io.Writer) error {
+		return writeSnapStream(w, &md, func(w io.Writer) error {
+			if s.kp == nil {
+				return compressTo(w, s.compression, save)
+			}
+			var buf bytes.Buffer
+			if err := save(&buf); err != nil {
+				return err
+			}
+			snapshot := raftpb.Snapshot{Data: buf.Bytes()}
+			if err := s.encode(&snapshot); err != nil {
+				return err
+			}
+			_, err := w.Write(snapshot.Data)
+			return err
+		})
+	})
 	if err != nil {
 		return err
-	} else {
-		marshallingDurations.Observe(float64(time.Since(start).Nanoseconds() / int64(time.Microsecond)))
 	}
+	saveDurations.Observe(float64(time.Since(start).Nanoseconds() / int64(time.Microsecond)))
+	s.setVerified(fname)
+	return nil
+}
 
-	err = ioutil.WriteFile(path.Join(s.dir, fname), d, 0666)
-	if err == nil {
-		saveDurations.Observe(float64(time.Since(start).Nanoseconds() / int64(time.Microsecond)))
+// encode compresses and seals the data of the given snapshot, as configured.
+func (s *Snapshotter) encode(snapshot *raftpb.Snapshot) error {
+	data, err := compress(snapshot.Data, s.compression)
+	if err != nil {
+		return err
+	}
+	if s.kp != nil {
+		if data, err = encryption.Seal(s.kp, data); err != nil {
+			return err
+		}
 	}
-	return err
+	snapshot.Data = data
+	return nil
+}
+
+func snapName(snapshot *raftpb.Snapshot) string {
+	return fmt.Sprintf("%016x-%016x%s", snapshot.Metadata.Term, snapshot.Metadata.Index, snapSuffix)
 }
 
 func (s *Snapshotter) Load() (*raftpb.Snapshot, error) {
@@ -91,27 +183,258 @@ func (s *Snapshotter) Load() (*raftpb.Snapshot, error) {
 	}
 	var snap *raftpb.Snapshot
 	for _, name := range names {
-		if snap, err = loadSnap(s.dir, name); err == nil {
+		if snap, err = s.loadSnap(name); err == nil {
 			break
 		}
 	}
 	if err != nil {
 		return nil, ErrNoSnapshot
 	}
+	// a snapshot which cannot be decrypted is not broken, so the error is
+	// returned instead of falling back on an older snapshot.
+	if err := decode(snap, s.kp); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// LoadChain loads the newest available snapshot like Load. If its data is a
+// delta, as told by isDelta, the older snapshots are loaded until one which is
+// not a delta, and the data of the returned snapshot is the concatenation of
+// their data, oldest first. A delta whose base cannot be loaded is skipped.
+func (s *Snapshotter) LoadChain(isDelta func(data []byte) bool) (*raftpb.Snapshot, error) {
+	snap, rc, err := s.ReadChain(isDelta)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if snap.Data, err = ioutil.ReadAll(rc); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// ReadChain opens the snapshots LoadChain loads. It returns the newest one,
+// without its data, and a reader of the data LoadChain returns. The data of
+// the files saved by SaveSnapFrom is read out of them as it is read from the
+// reader, unless it is encrypted. The caller must close the reader.
+func (s *Snapshotter) ReadChain(isDelta func(data []byte) bool) (*raftpb.Snapshot, io.ReadCloser, error) {
+	chain, err := s.openChain(isDelta)
+	if err != nil {
+		return nil, nil, err
+	}
+	rc := &chainReader{chain: chain}
+	rs := make([]io.Reader, len(chain))
+	for i, c := range chain {
+		rs[len(chain)-1-i] = c.r
+	}
+	rc.Reader = io.MultiReader(rs...)
+	return &raftpb.Snapshot{Metadata: chain[0].md}, rc, nil
+}
+
+// OpenChain opens the files of the snapshots LoadChain loads, newest first,
+// and returns them with the metadata of the newest snapshot. The files are
+// left as they are on disk, encrypted and compressed as they were saved.
+// The caller must close the files.
+func (s *Snapshotter) OpenChain(isDelta func(data []byte) bool) (raftpb.SnapshotMetadata, []*os.File, error) {
+	chain, err := s.openChain(isDelta)
+	if err != nil {
+		return raftpb.SnapshotMetadata{}, nil, err
+	}
+	closeChain(chain)
+	fs := make([]*os.File, 0, len(chain))
+	for _, c := range chain {
+		f, err := os.Open(path.Join(s.dir, c.name))
+		if err != nil {
+			for _, f := range fs {
+				f.Close()
+			}
+			return raftpb.SnapshotMetadata{}, nil, err
+		}
+		fs = append(fs, f)
+	}
+	return chain[0].md, fs, nil
+}
+
+// chainSnap is a snapshot of a chain, whose data is read from r.
+type chainSnap struct {
+	name string
+	md   raftpb.SnapshotMetadata
+	r    io.Reader
+	f    *os.File
+}
+
+type chainReader struct {
+	io.Reader
+	chain []*chainSnap
+}
+
+func (r *chainReader) Close() error {
+	closeChain(r.chain)
+	return nil
+}
+
+func closeChain(chain []*chainSnap) {
+	for _, c := range chain {
+		if c.f != nil {
+			c.f.Close()
+		}
+	}
+}
+
+// deltaPeekSize is the size of the start of the data of a snapshot given
+// to isDelta.
+const deltaPeekSize = 64
+
+// openChain opens the snapshots of the chain ending with the newest
+// available snapshot, newest first.
+func (s *Snapshotter) openChain(isDelta func(data []byte) bool) ([]*chainSnap, error) {
+	names, err := s.snapNames()
+	if err != nil {
+		return nil, err
+	}
+	var chain []*chainSnap
+	for _, name := range names {
+		c, err := s.openSnap(name)
+		if err == errBrokenSnapshot {
+			// the deltas opened so far are based on the broken snapshot.
+			closeChain(chain)
+			chain = nil
+			continue
+		}
+		if err != nil {
+			closeChain(chain)
+			return nil, err
+		}
+		chain = append(chain, c)
+		br := bufio.NewReader(c.r)
+		c.r = br
+		if b, _ := br.Peek(deltaPeekSize); !isDelta(b) {
+			break
+		}
+	}
+	if len(chain) == 0 {
+		return nil, ErrNoSnapshot
+	}
+	last := chain[len(chain)-1].r.(*bufio.Reader)
+	if b, _ := last.Peek(deltaPeekSize); isDelta(b) {
+		closeChain(chain)
+		return nil, ErrNoSnapshot
+	}
+	return chain, nil
+}
+
+// errBrokenSnapshot is returned by openSnap for a broken snapshot file,
+// which it quarantines.
+var errBrokenSnapshot = errors.New("snap: broken snapshot")
+
+// openSnap opens the snapshot file of the given name, and returns its data
+// decrypted and decompressed.
+func (s *Snapshotter) openSnap(name string) (*chainSnap, error) {
+	fpath := path.Join(s.dir, name)
+	if ok, err := isSnapStream(fpath); err != nil || !ok {
+		snap, err := s.loadSnap(name)
+		if err != nil {
+			return nil, errBrokenSnapshot
+		}
+		if err := decode(snap, s.kp); err != nil {
+			return nil, err
+		}
+		return &chainSnap{name: name, md: snap.Metadata, r: bytes.NewReader(snap.Data)}, nil
+	}
+
+	f, md, data, err := openSnapStream(fpath)
+	if err != nil {
+		plog.Errorf("corrupted snapshot file %v: %v", fpath, err)
+		s.quarantine(name)
+		return nil, errBrokenSnapshot
+	}
+	s.setVerified(name)
+	c := &chainSnap{name: name, md: *md}
+	br := bufio.NewReader(data)
+	if b, _ := br.Peek(deltaPeekSize); encryption.IsSealed(b) {
+		// the data is sealed as a whole.
+		defer f.Close()
+		b, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		snap := &raftpb.Snapshot{Metadata: *md, Data: b}
+		if err := decode(snap, s.kp); err != nil {
+			return nil, err
+		}
+		c.r = bytes.NewReader(snap.Data)
+		return c, nil
+	}
+	if c.r, err = decompressReader(br); err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.f = f
+	return c, nil
+}
+
+// ReadWithKeyProvider reads the snapshot named by snapname like Read, and
+// decrypts its data with kp if it is encrypted.
+func ReadWithKeyProvider(snapname string, kp encryption.KeyProvider) (*raftpb.Snapshot, error) {
+	snap, err := Read(snapname)
+	if err != nil {
+		return nil, err
+	}
+	if err := decode(snap, kp); err != nil {
+		return nil, err
+	}
 	return snap, nil
 }
 
-func loadSnap(dir, name string) (*raftpb.Snapshot, error) {
-	fpath := path.Join(dir, name)
-	snap, err := Read(fpath)
+// decode decrypts the data of the given snapshot with kp and decompresses
+// it, if it is encrypted. Read decompresses the data which is not.
+func decode(snap *raftpb.Snapshot, kp encryption.KeyProvider) error {
+	if !encryption.IsSealed(snap.Data) {
+		return nil
+	}
+	if kp == nil {
+		return ErrEncrypted
+	}
+	data, err := encryption.Open(kp, snap.Data)
 	if err != nil {
-		renameBroken(fpath)
+		return err
+	}
+	if snap.Data, err = decompress(data); err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadSnap reads the snapshot file of the given name, and quarantines it if
+// it is broken.
+func (s *Snapshotter) loadSnap(name string) (*raftpb.Snapshot, error) {
+	snap, err := Read(path.Join(s.dir, name))
+	if err != nil {
+		s.quarantine(name)
+		return nil, err
 	}
-	return snap, err
+	s.setVerified(name)
+	return snap, nil
 }
 
-// Read reads the snapshot named by snapname and returns the snapshot.
+// Read reads the snapshot named by snapname and returns the snapshot. Its
+// data is decompressed, unless it is encrypted.
 func Read(snapname string) (*raftpb.Snapshot, error) {
+	snap, err := read(snapname)
+	if err != nil {
+		return nil, err
+	}
+	if !encryption.IsSealed(snap.Data) {
+		if snap.Data, err = decompress(snap.Data); err != nil {
+			plog.Errorf("corrupted snapshot file %v: %v", snapname, err)
+			return nil, err
+		}
+	}
+	return snap, nil
+}
+
+func read(snapname string) (*raftpb.Snapshot, error) {
 	b, err := ioutil.ReadFile(snapname)
 	if err != nil {
 		plog.Errorf("cannot read file %v: %v", snapname, err)
@@ -123,6 +446,14 @@ func Read(snapname string) (*raftpb.Snapshot, error) {
 		return nil, ErrEmptySnapshot
 	}
 
+	if bytes.HasPrefix(b, []byte(fileMagic)) {
+		snap, err := readSnap(b)
+		if err != nil {
+			plog.Errorf("corrupted snapshot file %v: %v", snapname, err)
+		}
+		return snap, err
+	}
+
 	var serializedSnap snappb.Snapshot
 	if err = serializedSnap.Unmarshal(b); err != nil {
 		plog.Errorf("corrupted snapshot file %v: %v", snapname, err)
@@ -173,16 +504,9 @@ func checkSuffix(names []string) []string {
 	for i := range names {
 		if strings.HasSuffix(names[i], snapSuffix) {
 			snaps = append(snaps, names[i])
-		} else {
+		} else if !strings.Contains(names[i], dbSuffix) && names[i] != quarantineDir {
 			plog.Warningf("skipped unexpected non snapshot file %v", names[i])
 		}
 	}
 	return snaps
 }
-
-func renameBroken(path string) {
-	brokenPath := path + ".broken"
-	if err := os.Rename(path, brokenPath); err != nil {
-		plog.Warningf("cannot rename broken snapshot file %v to %v: %v", path, brokenPath, err)
-	}
-}
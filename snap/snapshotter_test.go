@@ -15,15 +15,22 @@
 package snap
 
 import (
+	"bytes"
 	"fmt"
 	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/coreos/etcd/pkg/encryption"
+	"github.com/coreos/etcd/pkg/fileutil"
+	"github.com/coreos/etcd/pkg/pbutil"
 	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/snap/snappb"
 )
 
 var testSnap = &raftpb.Snapshot{
@@ -59,6 +66,61 @@ func TestSaveAndLoad(t *testing.T) {
 	}
 }
 
+func TestSaveAndLoadEncrypted(t *testing.T) {
+	dir := path.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	from, to := newTestKeyProvider(t, dir, "from"), newTestKeyProvider(t, dir, "to")
+	if err = NewWithKeyProvider(dir, from).SaveSnap(*testSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	fpath := path.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1))
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, testSnap.Data) {
+		t.Errorf("snapshot file holds plaintext")
+	}
+	if _, err = New(dir).Load(); err != ErrEncrypted {
+		t.Errorf("err = %v, want %v", err, ErrEncrypted)
+	}
+
+	if err = RotateKey(dir, from, to); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewWithKeyProvider(dir, from).Load(); err != encryption.ErrUnknownKey {
+		t.Errorf("err = %v, want %v", err, encryption.ErrUnknownKey)
+	}
+	g, err := NewWithKeyProvider(dir, to).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+}
+
+func newTestKeyProvider(t *testing.T, dir, name string) encryption.KeyProvider {
+	key, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.Join(dir, name+".key")
+	if err := ioutil.WriteFile(p, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	kp, err := encryption.NewFileKeyProvider(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
 func TestBadCRC(t *testing.T) {
 	dir := path.Join(os.TempDir(), "snapshot")
 	err := os.Mkdir(dir, 0700)
@@ -66,11 +128,16 @@ func TestBadCRC(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(dir)
-	ss := New(dir)
-	err = ss.save(testSnap)
+	// the files of previous versions are checksummed with crc32.
+	b := pbutil.MustMarshal(testSnap)
+	d := pbutil.MustMarshal(&snappb.Snapshot{Crc: crc32.Update(0, crcTable, b), Data: b})
+	err = ioutil.WriteFile(path.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)), d, 0666)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if g, err := Read(path.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1))); err != nil || !reflect.DeepEqual(g, testSnap) {
+		t.Fatalf("snap, err = %#v, %v, want %#v, nil", g, err, testSnap)
+	}
 	defer func() { crcTable = crc32.MakeTable(crc32.Castagnoli) }()
 	// switch to use another crc table
 	// fake a crc mismatch
@@ -82,6 +149,229 @@ func TestBadCRC(t *testing.T) {
 	}
 }
 
+func TestBadChecksum(t *testing.T) {
+	dir := path.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the files with a sha256 checksum are written with a compression.
+	ss := NewWithOptions(dir, Options{Compression: CompressionGzip})
+	// save compresses the data of the given snapshot in place.
+	snap := *testSnap
+	if err = ss.save(&snap); err != nil {
+		t.Fatal(err)
+	}
+	fpath := path.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1))
+	corruptByte(t, fpath, len(fileMagic)+3)
+
+	_, err = Read(fpath)
+	if err != ErrChecksumMismatch {
+		t.Errorf("err = %v, want %v", err, ErrChecksumMismatch)
+	}
+}
+
+// Without compression, the snapshot files are written as by previous
+// versions.
+func TestSaveFormat(t *testing.T) {
+	dir := path.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := path.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1))
+
+	b := pbutil.MustMarshal(testSnap)
+	wd := pbutil.MustMarshal(&snappb.Snapshot{Crc: crc32.Update(0, crcTable, b), Data: b})
+	if err = New(dir).save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	d, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d, wd) {
+		t.Errorf("file = %x, want %x", d, wd)
+	}
+
+	ss := NewWithOptions(dir, Options{Compression: CompressionGzip})
+	// save compresses the data of the given snapshot in place.
+	snap := *testSnap
+	if err = ss.save(&snap); err != nil {
+		t.Fatal(err)
+	}
+	if d, err = ioutil.ReadFile(fpath); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(d, []byte(fileMagic)) {
+		t.Errorf("file = %x, want prefix %x", d, fileMagic)
+	}
+}
+
+func TestSaveAndLoadCompressed(t *testing.T) {
+	dir := path.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snap := *testSnap
+	snap.Data = bytes.Repeat([]byte("compressible "), 1000)
+
+	for i, kp := range []encryption.KeyProvider{nil, newTestKeyProvider(t, dir, "key")} {
+		ss := NewWithOptions(dir, Options{KeyProvider: kp, Compression: CompressionGzip})
+		wsnap := snap
+		wsnap.Metadata.Index = uint64(i + 1)
+		if err = ss.SaveSnap(wsnap); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, i+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() >= int64(len(snap.Data))/10 {
+			t.Errorf("#%d: size = %d, want less than %d", i, fi.Size(), len(snap.Data)/10)
+		}
+		g, err := ss.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*g, wsnap) {
+			t.Errorf("#%d: snap = %#v, want %#v", i, g, wsnap)
+		}
+	}
+}
+
+func TestSaveSnapFrom(t *testing.T) {
+	dir := path.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kp := newTestKeyProvider(t, dir, "key")
+	data := bytes.Repeat([]byte("compressible "), 1000)
+
+	tests := []Options{
+		{},
+		{Compression: CompressionGzip},
+		{KeyProvider: kp},
+		{KeyProvider: kp, Compression: CompressionGzip},
+	}
+	for i, opts := range tests {
+		ss := NewWithOptions(dir, opts)
+		wsnap := raftpb.Snapshot{Data: data, Metadata: testSnap.Metadata}
+		wsnap.Metadata.Index = uint64(i + 1)
+		err = ss.SaveSnapFrom(wsnap.Metadata, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fpath := path.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, i+1))
+		if ok, err := isSnapStream(fpath); err != nil || !ok {
+			t.Errorf("#%d: stream = %v, %v, want true", i, ok, err)
+		}
+		g, err := ss.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*g, wsnap) {
+			t.Errorf("#%d: snap = %#v, want %#v", i, g, wsnap)
+		}
+
+		g, rc, err := ss.ReadChain(func([]byte) bool { return false })
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.Metadata.Index != wsnap.Metadata.Index || !bytes.Equal(b, data) {
+			t.Errorf("#%d: snap = index %d data %d bytes, want index %d data %d bytes", i, g.Metadata.Index, len(b), wsnap.Metadata.Index, len(data))
+		}
+	}
+
+	// a broken file is quarantined.
+	corruptByte(t, path.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, len(tests))), len(fileMagic)+3)
+	g, err := NewWithOptions(dir, Options{KeyProvider: kp}).LoadChain(func([]byte) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Metadata.Index != uint64(len(tests)-1) {
+		t.Errorf("index = %d, want %d", g.Metadata.Index, len(tests)-1)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	dir := path.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 1; i <= 5; i++ {
+		snap := *testSnap
+		snap.Metadata.Index = uint64(i)
+		if err = New(dir).SaveSnap(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := func(i int) string { return fmt.Sprintf("%016x-%016x.snap", 1, i) }
+	corruptByte(t, path.Join(dir, name(4)), len(fileMagic)+3)
+
+	stop := make(chan struct{})
+	adir := path.Join(dir, "archive")
+	errc := New(dir).Purge(fileutil.PurgeOptions{Max: 2, Archiver: fileutil.NewDirArchiver(adir, false)}, time.Hour, stop)
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	select {
+	case err = <-errc:
+		t.Fatal(err)
+	default:
+	}
+
+	names, err := New(dir).snapNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the broken snapshot is quarantined and does not count as retained.
+	if wnames := []string{name(5), name(3)}; !reflect.DeepEqual(names, wnames) {
+		t.Errorf("names = %v, want %v", names, wnames)
+	}
+	if _, err = os.Stat(path.Join(dir, quarantineDir, name(4))); err != nil {
+		t.Errorf("err = %v, want the broken snapshot quarantined", err)
+	}
+	anames, err := fileutil.ReadDir(adir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wnames := []string{name(1), name(2)}; !reflect.DeepEqual(anames, wnames) {
+		t.Errorf("archived = %v, want %v", anames, wnames)
+	}
+}
+
+func corruptByte(t *testing.T, fpath string, off int) {
+	f, err := os.OpenFile(fpath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	if _, err = f.ReadAt(b, int64(off)); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err = f.WriteAt(b, int64(off)); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestFailback(t *testing.T) {
 	dir := path.Join(os.TempDir(), "snapshot")
 	err := os.Mkdir(dir, 0700)
@@ -109,8 +399,8 @@ func TestFailback(t *testing.T) {
 	if !reflect.DeepEqual(g, testSnap) {
 		t.Errorf("snap = %#v, want %#v", g, testSnap)
 	}
-	if f, err := os.Open(path.Join(dir, large) + ".broken"); err != nil {
-		t.Fatal("broken snapshot does not exist")
+	if f, err := os.Open(path.Join(dir, quarantineDir, large)); err != nil {
+		t.Fatal("broken snapshot is not quarantined")
 	} else {
 		f.Close()
 	}
@@ -174,6 +464,128 @@ func TestLoadNewestSnap(t *testing.T) {
 	}
 }
 
+func TestLoadChain(t *testing.T) {
+	dir := path.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ss := New(dir)
+	isDelta := func(data []byte) bool { return bytes.HasPrefix(data, []byte("delta")) }
+	for i, data := range []string{"old", "full", "delta1", "delta2"} {
+		snap := *testSnap
+		snap.Metadata.Index = uint64(i + 1)
+		snap.Data = []byte(data)
+		if err = ss.save(&snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g, err := ss.LoadChain(isDelta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Metadata.Index != 4 || string(g.Data) != "fulldelta1delta2" {
+		t.Errorf("snap = index %d data %q, want index 4 data %q", g.Metadata.Index, g.Data, "fulldelta1delta2")
+	}
+
+	// the deltas based on a broken snapshot are skipped.
+	corruptByte(t, path.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 2)), len(fileMagic)+3)
+	if g, err = ss.LoadChain(isDelta); err != nil {
+		t.Fatal(err)
+	}
+	if g.Metadata.Index != 1 || string(g.Data) != "old" {
+		t.Errorf("snap = index %d data %q, want index 1 data %q", g.Metadata.Index, g.Data, "old")
+	}
+}
+
+// ReadChain should read the chain of snapshots out of files saved by both
+// SaveSnap and SaveSnapFrom.
+func TestReadChain(t *testing.T) {
+	dir := path.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ss := NewWithOptions(dir, Options{Compression: CompressionGzip})
+	isDelta := func(data []byte) bool { return bytes.HasPrefix(data, []byte("delta")) }
+	snap := *testSnap
+	snap.Data = []byte("full")
+	if err = ss.SaveSnap(snap); err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range []string{"delta1", "delta2"} {
+		md := testSnap.Metadata
+		md.Index = uint64(i + 2)
+		err = ss.SaveSnapFrom(md, func(w io.Writer) error {
+			_, err := io.WriteString(w, data)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g, rc, err := ss.ReadChain(isDelta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Metadata.Index != 3 || g.Data != nil || string(b) != "fulldelta1delta2" {
+		t.Errorf("snap = index %d data %q read %q, want index 3 data nil read %q", g.Metadata.Index, g.Data, b, "fulldelta1delta2")
+	}
+}
+
+func TestOpenChain(t *testing.T) {
+	dir := path.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ss := New(dir)
+	isDelta := func(data []byte) bool { return bytes.HasPrefix(data, []byte("delta")) }
+	for i, data := range []string{"old", "full", "delta1", "delta2"} {
+		snap := *testSnap
+		snap.Metadata.Index = uint64(i + 1)
+		snap.Data = []byte(data)
+		if err = ss.save(&snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	md, fs, err := ss.OpenChain(isDelta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, f := range fs {
+			f.Close()
+		}
+	}()
+	if md.Index != 4 {
+		t.Errorf("index = %d, want 4", md.Index)
+	}
+	var names []string
+	for _, f := range fs {
+		names = append(names, path.Base(f.Name()))
+	}
+	wnames := []string{
+		fmt.Sprintf("%016x-%016x.snap", 1, 4),
+		fmt.Sprintf("%016x-%016x.snap", 1, 3),
+		fmt.Sprintf("%016x-%016x.snap", 1, 2),
+	}
+	if !reflect.DeepEqual(names, wnames) {
+		t.Errorf("names = %v, want %v", names, wnames)
+	}
+}
+
 func TestNoSnapshot(t *testing.T) {
 	dir := path.Join(os.TempDir(), "snapshot")
 	err := os.Mkdir(dir, 0700)
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/boltdb/bolt"
+	"github.com/coreos/etcd/pkg/encryption"
 )
 
 type Backend interface {
@@ -21,26 +22,40 @@ type backend struct {
 	batchInterval time.Duration
 	batchLimit    int
 	batchTx       *batchTx
+	// dk seals the values of an encrypted database.
+	dk *encryption.DataKey
 
 	stopc chan struct{}
 	donec chan struct{}
 }
 
 func New(path string, d time.Duration, limit int) Backend {
-	return newBackend(path, d, limit)
+	return newBackend(path, d, limit, nil)
 }
 
-func newBackend(path string, d time.Duration, limit int) *backend {
+// NewWithKeyProvider returns a Backend like New, whose values are sealed
+// with the data key of the database, wrapped by kp. A database which is
+// not encrypted yet gets encrypted.
+func NewWithKeyProvider(path string, d time.Duration, limit int, kp encryption.KeyProvider) Backend {
+	return newBackend(path, d, limit, kp)
+}
+
+func newBackend(path string, d time.Duration, limit int, kp encryption.KeyProvider) *backend {
 	db, err := bolt.Open(path, 0600, nil)
 	if err != nil {
 		log.Panicf("backend: cannot open database at %s (%v)", path, err)
 	}
+	dk, err := setupEncryption(db, kp)
+	if err != nil {
+		log.Panicf("backend: cannot set up the encryption of database at %s (%v)", path, err)
+	}
 
 	b := &backend{
 		db: db,
 
 		batchInterval: d,
 		batchLimit:    limit,
+		dk:            dk,
 
 		stopc: make(chan struct{}),
 		donec: make(chan struct{}),
@@ -62,7 +77,13 @@ func (b *backend) ForceCommit() {
 	b.batchTx.Commit()
 }
 
+// Snapshot writes the committed content of the database into w. The values
+// of an encrypted database are written decrypted, without its data key, so
+// that the receiver can seal the copy with its own key.
 func (b *backend) Snapshot(w io.Writer) (n int64, err error) {
+	if b.dk != nil {
+		return b.snapshotOpened(w)
+	}
 	b.db.View(func(tx *bolt.Tx) error {
 		n, err = tx.WriteTo(w)
 		return nil
@@ -23,7 +23,7 @@ func init() {
 }
 
 func TestBackendClose(t *testing.T) {
-	b := newBackend(tmpPath, time.Hour, 10000)
+	b := newBackend(tmpPath, time.Hour, 10000, nil)
 	defer os.Remove(tmpPath)
 
 	// check close could work
@@ -79,7 +79,7 @@ func TestBackendSnapshot(t *testing.T) {
 
 func TestBackendBatchIntervalCommit(t *testing.T) {
 	// start backend with super short batch interval
-	b := newBackend(tmpPath, time.Nanosecond, 10000)
+	b := newBackend(tmpPath, time.Nanosecond, 10000, nil)
 	defer cleanup(b, tmpPath)
 
 	tx := b.BatchTx()
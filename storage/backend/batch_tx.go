@@ -45,6 +45,9 @@ func (t *batchTx) UnsafePut(bucketName []byte, key []byte, value []byte) {
 	if bucket == nil {
 		log.Fatalf("storage: bucket %s does not exist", string(bucketName))
 	}
+	if dk := t.backend.dk; dk != nil {
+		value = dk.Seal(value, valueAD(bucketName, key))
+	}
 	if err := bucket.Put(key, value); err != nil {
 		log.Fatalf("storage: cannot put key into bucket (%v)", err)
 	}
@@ -66,13 +69,13 @@ func (t *batchTx) UnsafeRange(bucketName []byte, key, endKey []byte, limit int64
 		if v := bucket.Get(key); v == nil {
 			return keys, vs
 		} else {
-			return append(keys, key), append(vs, v)
+			return append(keys, key), append(vs, t.open(bucketName, key, v))
 		}
 	}
 
 	c := bucket.Cursor()
 	for ck, cv := c.Seek(key); ck != nil && bytes.Compare(ck, endKey) < 0; ck, cv = c.Next() {
-		vs = append(vs, cv)
+		vs = append(vs, t.open(bucketName, ck, cv))
 		keys = append(keys, ck)
 		if limit > 0 && limit == int64(len(keys)) {
 			break
@@ -82,6 +85,20 @@ func (t *batchTx) UnsafeRange(bucketName []byte, key, endKey []byte, limit int64
 	return keys, vs
 }
 
+// open returns the given value stored at the given key, decrypted if the
+// database is encrypted.
+func (t *batchTx) open(bucketName, key, v []byte) []byte {
+	dk := t.backend.dk
+	if dk == nil {
+		return v
+	}
+	pv, err := dk.Open(v, valueAD(bucketName, key))
+	if err != nil {
+		log.Fatalf("storage: cannot decrypt value of key %x in bucket %s (%v)", key, string(bucketName), err)
+	}
+	return pv
+}
+
 // before calling unsafeDelete, the caller MUST hold the lock on tx.
 func (t *batchTx) UnsafeDelete(bucketName []byte, key []byte) {
 	bucket := t.tx.Bucket(bucketName)
@@ -9,7 +9,7 @@ import (
 )
 
 func TestBatchTxPut(t *testing.T) {
-	b := newBackend(tmpPath, time.Hour, 10000)
+	b := newBackend(tmpPath, time.Hour, 10000, nil)
 	defer cleanup(b, tmpPath)
 
 	tx := b.batchTx
@@ -34,7 +34,7 @@ func TestBatchTxPut(t *testing.T) {
 }
 
 func TestBatchTxRange(t *testing.T) {
-	b := newBackend(tmpPath, time.Hour, 10000)
+	b := newBackend(tmpPath, time.Hour, 10000, nil)
 	defer cleanup(b, tmpPath)
 
 	tx := b.batchTx
@@ -105,7 +105,7 @@ func TestBatchTxRange(t *testing.T) {
 }
 
 func TestBatchTxDelete(t *testing.T) {
-	b := newBackend(tmpPath, time.Hour, 10000)
+	b := newBackend(tmpPath, time.Hour, 10000, nil)
 	defer cleanup(b, tmpPath)
 
 	tx := b.batchTx
@@ -128,7 +128,7 @@ func TestBatchTxDelete(t *testing.T) {
 }
 
 func TestBatchTxCommit(t *testing.T) {
-	b := newBackend(tmpPath, time.Hour, 10000)
+	b := newBackend(tmpPath, time.Hour, 10000, nil)
 	defer cleanup(b, tmpPath)
 
 	tx := b.batchTx
@@ -156,7 +156,7 @@ func TestBatchTxCommit(t *testing.T) {
 
 func TestBatchTxBatchLimitCommit(t *testing.T) {
 	// start backend with batch limit 1
-	b := newBackend(tmpPath, time.Hour, 1)
+	b := newBackend(tmpPath, time.Hour, 1, nil)
 	defer cleanup(b, tmpPath)
 
 	tx := b.batchTx
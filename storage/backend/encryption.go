@@ -0,0 +1,194 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/boltdb/bolt"
+	"github.com/coreos/etcd/pkg/encryption"
+)
+
+var (
+	// encryptionBucketName is the bucket holding the header of the data key
+	// of an encrypted database. Its presence marks the values of all the
+	// other buckets as sealed.
+	encryptionBucketName = []byte("encryption")
+	headerKeyName        = []byte("header")
+
+	ErrEncrypted = errors.New("backend: encrypted database, but no key provider given")
+)
+
+// setupEncryption returns the data key of the given database, unwrapped by
+// kp. If the database is not encrypted yet, a new data key is generated and
+// all the values already stored are sealed with it.
+// With a nil kp, it returns ErrEncrypted if the database is encrypted.
+func setupEncryption(db *bolt.DB, kp encryption.KeyProvider) (dk *encryption.DataKey, err error) {
+	err = db.Update(func(tx *bolt.Tx) error {
+		if eb := tx.Bucket(encryptionBucketName); eb != nil {
+			if kp == nil {
+				return ErrEncrypted
+			}
+			h, _, err := encryption.UnmarshalHeader(eb.Get(headerKeyName))
+			if err != nil {
+				return err
+			}
+			dk, err = h.DataKey(kp)
+			return err
+		}
+		if kp == nil {
+			return nil
+		}
+
+		var h *encryption.Header
+		dk, h, err = encryption.NewDataKey(kp)
+		if err != nil {
+			return err
+		}
+		if err := sealValues(tx, dk); err != nil {
+			return err
+		}
+		eb, err := tx.CreateBucket(encryptionBucketName)
+		if err != nil {
+			return err
+		}
+		return eb.Put(headerKeyName, h.Marshal())
+	})
+	return dk, err
+}
+
+// sealValues seals all the values stored in the buckets of tx.
+func sealValues(tx *bolt.Tx, dk *encryption.DataKey) error {
+	return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		// the values cannot be updated while iterating over the bucket.
+		var keys, vals [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return fmt.Errorf("backend: unexpected nested bucket %s in bucket %s", k, name)
+			}
+			keys = append(keys, append([]byte(nil), k...))
+			vals = append(vals, dk.Seal(v, valueAD(name, k)))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i := range keys {
+			if err := b.Put(keys[i], vals[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// snapshotOpened writes into w a copy of the committed content of the
+// encrypted database, with its values decrypted and without its encryption
+// bucket. The copy is built in a temporary file next to the database.
+func (b *backend) snapshotOpened(w io.Writer) (int64, error) {
+	f, err := ioutil.TempFile(path.Dir(b.db.Path()), "snapshot")
+	if err != nil {
+		return 0, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	cdb, err := bolt.Open(f.Name(), 0600, nil)
+	if err != nil {
+		return 0, err
+	}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		return cdb.Update(func(ctx *bolt.Tx) error {
+			return tx.ForEach(func(name []byte, bkt *bolt.Bucket) error {
+				if bytes.Equal(name, encryptionBucketName) {
+					return nil
+				}
+				cb, err := ctx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return bkt.ForEach(func(k, v []byte) error {
+					pv, err := b.dk.Open(v, valueAD(name, k))
+					if err != nil {
+						return fmt.Errorf("backend: cannot decrypt value of key %x in bucket %s (%v)", k, name, err)
+					}
+					return cb.Put(k, pv)
+				})
+			})
+		})
+	})
+	if cerr := cdb.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	f, err = os.Open(f.Name())
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(w, f)
+}
+
+// CheckKeyProvider returns an error if the database at the given path,
+// which must not be open, is encrypted with a data key kp cannot unwrap.
+// It returns ErrEncrypted for an encrypted database and a nil kp.
+func CheckKeyProvider(p string, kp encryption.KeyProvider) error {
+	db, err := bolt.Open(p, 0400, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		eb := tx.Bucket(encryptionBucketName)
+		if eb == nil {
+			return nil
+		}
+		if kp == nil {
+			return ErrEncrypted
+		}
+		h, _, err := encryption.UnmarshalHeader(eb.Get(headerKeyName))
+		if err != nil {
+			return err
+		}
+		_, err = h.DataKey(kp)
+		return err
+	})
+}
+
+// valueAD returns the additional data authenticated along a sealed value,
+// so that the values cannot be moved between keys.
+func valueAD(bucketName, key []byte) []byte {
+	return bytes.Join([][]byte{bucketName, key}, []byte{0})
+}
+
+// RotateKey rewraps the data key of the encrypted database at the given
+// path, unwrapped by from, with the key-encryption key of to. The database
+// must not be open.
+func RotateKey(path string, from, to encryption.KeyProvider) error {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		eb := tx.Bucket(encryptionBucketName)
+		if eb == nil {
+			return fmt.Errorf("backend: database at %s is not encrypted", path)
+		}
+		h, _, err := encryption.UnmarshalHeader(eb.Get(headerKeyName))
+		if err != nil {
+			return err
+		}
+		if err := h.Rewrap(from, to); err != nil {
+			return err
+		}
+		return eb.Put(headerKeyName, h.Marshal())
+	})
+}
@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/boltdb/bolt"
+	"github.com/coreos/etcd/pkg/encryption"
+)
+
+func newTestKeyProvider(t *testing.T, name string) encryption.KeyProvider {
+	key, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.Join(path.Dir(tmpPath), name)
+	if err := ioutil.WriteFile(p, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	kp, err := encryption.NewFileKeyProvider(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+func TestBackendEncryption(t *testing.T) {
+	defer os.Remove(tmpPath)
+	from, to := newTestKeyProvider(t, "from"), newTestKeyProvider(t, "to")
+
+	// a plaintext database gets encrypted when opened with a key provider.
+	b := newBackend(tmpPath, time.Hour, 10000, nil)
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket([]byte("test"))
+	tx.UnsafePut([]byte("test"), []byte("foo"), []byte("plaintext"))
+	tx.Unlock()
+	b.Close()
+
+	b = newBackend(tmpPath, time.Hour, 10000, from)
+	tx = b.BatchTx()
+	tx.Lock()
+	tx.UnsafePut([]byte("test"), []byte("bar"), []byte("secret"))
+	ks, vs := tx.UnsafeRange([]byte("test"), []byte("bar"), []byte("zoo"), 0)
+	tx.Unlock()
+	wks := [][]byte{[]byte("bar"), []byte("foo")}
+	wvs := [][]byte{[]byte("secret"), []byte("plaintext")}
+	if !reflect.DeepEqual(ks, wks) || !reflect.DeepEqual(vs, wvs) {
+		t.Errorf("range = %q, %q, want %q, %q", ks, vs, wks, wvs)
+	}
+	b.Close()
+
+	db, err := bolt.Open(tmpPath, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("test")).ForEach(func(k, v []byte) error {
+			if bytes.Contains(v, []byte("secret")) || bytes.Contains(v, []byte("plaintext")) {
+				t.Errorf("value of %s is not encrypted", k)
+			}
+			return nil
+		})
+	})
+	db.Close()
+
+	if _, err = setupEncryptionAt(tmpPath, nil); err != ErrEncrypted {
+		t.Errorf("err = %v, want %v", err, ErrEncrypted)
+	}
+	if err = RotateKey(tmpPath, from, to); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = setupEncryptionAt(tmpPath, from); err != encryption.ErrUnknownKey {
+		t.Errorf("err = %v, want %v", err, encryption.ErrUnknownKey)
+	}
+
+	b = newBackend(tmpPath, time.Hour, 10000, to)
+	defer b.Close()
+	tx = b.BatchTx()
+	tx.Lock()
+	_, vs = tx.UnsafeRange([]byte("test"), []byte("foo"), nil, 0)
+	tx.Unlock()
+	if len(vs) != 1 || string(vs[0]) != "plaintext" {
+		t.Errorf("value = %q, want %q", vs, "plaintext")
+	}
+}
+
+func TestBackendSnapshotEncrypted(t *testing.T) {
+	defer os.Remove(tmpPath)
+	from, to := newTestKeyProvider(t, "from"), newTestKeyProvider(t, "to")
+
+	b := newBackend(tmpPath, time.Hour, 10000, from)
+	tx := b.BatchTx()
+	tx.Lock()
+	tx.UnsafeCreateBucket([]byte("test"))
+	tx.UnsafePut([]byte("test"), []byte("foo"), []byte("secret"))
+	tx.Unlock()
+	b.ForceCommit()
+	var buf bytes.Buffer
+	if _, err := b.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	// the snapshot is decrypted, and can be sealed with another key or
+	// read without any.
+	for i, kp := range []encryption.KeyProvider{to, nil} {
+		p := tmpPath + "-snapshot"
+		if err := ioutil.WriteFile(p, buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckKeyProvider(p, kp); err != nil {
+			t.Fatalf("#%d: err = %v, want nil", i, err)
+		}
+		sb := newBackend(p, time.Hour, 10000, kp)
+		tx = sb.BatchTx()
+		tx.Lock()
+		_, vs := tx.UnsafeRange([]byte("test"), []byte("foo"), nil, 0)
+		tx.Unlock()
+		if len(vs) != 1 || string(vs[0]) != "secret" {
+			t.Errorf("#%d: value = %q, want %q", i, vs, "secret")
+		}
+		sb.Close()
+		os.Remove(p)
+	}
+
+	if err := CheckKeyProvider(tmpPath, to); err != encryption.ErrUnknownKey {
+		t.Errorf("err = %v, want %v", err, encryption.ErrUnknownKey)
+	}
+	if err := CheckKeyProvider(tmpPath, nil); err != ErrEncrypted {
+		t.Errorf("err = %v, want %v", err, ErrEncrypted)
+	}
+}
+
+func setupEncryptionAt(p string, kp encryption.KeyProvider) (*encryption.DataKey, error) {
+	db, err := bolt.Open(p, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return setupEncryption(db, kp)
+}
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/storage/backend"
 	"github.com/coreos/etcd/storage/storagepb"
 )
@@ -48,9 +49,19 @@ func New(path string) KV {
 	return newStore(path)
 }
 
+// NewWithKeyProvider returns a KV like New, whose database is encrypted
+// with a data key wrapped by kp, if not nil.
+func NewWithKeyProvider(path string, kp encryption.KeyProvider) KV {
+	return newStoreWithBackend(backend.NewWithKeyProvider(path, batchInterval, batchLimit, kp))
+}
+
 func newStore(path string) *store {
+	return newStoreWithBackend(backend.New(path, batchInterval, batchLimit))
+}
+
+func newStoreWithBackend(b backend.Backend) *store {
 	s := &store{
-		b:              backend.New(path, batchInterval, batchLimit),
+		b:              b,
 		kvindex:        newTreeIndex(),
 		currentRev:     revision{},
 		compactMainRev: -1,
@@ -23,12 +23,15 @@ const (
 	CompareAndSwap   = "compareAndSwap"
 	CompareAndDelete = "compareAndDelete"
 	Expire           = "expire"
+	Txn              = "txn"
+	Refresh          = "refresh"
 )
 
 type Event struct {
 	Action    string      `json:"action"`
 	Node      *NodeExtern `json:"node,omitempty"`
 	PrevNode  *NodeExtern `json:"prevNode,omitempty"`
+	Next      string      `json:"next,omitempty"`
 	EtcdIndex uint64      `json:"-"`
 }
 
@@ -67,5 +70,6 @@ func (e *Event) Clone() *Event {
 		EtcdIndex: e.EtcdIndex,
 		Node:      e.Node.Clone(),
 		PrevNode:  e.PrevNode.Clone(),
+		Next:      e.Next,
 	}
 }
@@ -55,7 +55,17 @@ func (eh *EventHistory) addEvent(e *Event) *Event {
 
 // scan enumerates events from the index history and stops at the first point
 // where the key matches.
-func (eh *EventHistory) scan(key string, recursive bool, index uint64) (*Event, *etcdErr.Error) {
+func (eh *EventHistory) scan(key string, recursive bool, index uint64, match func(*Event) bool) (*Event, *etcdErr.Error) {
+	es, err := eh.scanN(key, recursive, index, match, 1)
+	if err != nil || len(es) == 0 {
+		return nil, err
+	}
+	return es[0], nil
+}
+
+// scanN enumerates events from the index history and returns the first n
+// ones where the key matches, or all of them if n is zero.
+func (eh *EventHistory) scanN(key string, recursive bool, index uint64, match func(*Event) bool, n int) ([]*Event, *etcdErr.Error) {
 	eh.rwl.RLock()
 	defer eh.rwl.RUnlock()
 
@@ -72,13 +82,16 @@ func (eh *EventHistory) scan(key string, recursive bool, index uint64) (*Event,
 		return nil, nil
 	}
 
+	// events applied by a transaction share the same index, so the event at
+	// offset might come before the requested index; those are skipped below.
 	offset := index - eh.StartIndex
 	i := (eh.Queue.Front + int(offset)) % eh.Queue.Capacity
 
+	var es []*Event
 	for {
 		e := eh.Queue.Events[i]
 
-		ok := (e.Node.Key == key)
+		ok := (e.Node.Key == key) && e.Index() >= index
 
 		if recursive {
 			// add tailing slash
@@ -87,17 +100,20 @@ func (eh *EventHistory) scan(key string, recursive bool, index uint64) (*Event,
 				key = key + "/"
 			}
 
-			ok = ok || strings.HasPrefix(e.Node.Key, key)
+			ok = ok || (strings.HasPrefix(e.Node.Key, key) && e.Index() >= index)
 		}
 
-		if ok {
-			return e, nil
+		if ok && (match == nil || match(e)) {
+			es = append(es, e)
+			if len(es) == n {
+				return es, nil
+			}
 		}
 
 		i = (i + 1) % eh.Queue.Capacity
 
 		if i == eh.Queue.Back {
-			return nil, nil
+			return es, nil
 		}
 	}
 }
@@ -55,24 +55,24 @@ func TestScanHistory(t *testing.T) {
 	eh.addEvent(newEvent(Create, "/foo/bar/bar", 4, 4))
 	eh.addEvent(newEvent(Create, "/foo/foo/foo", 5, 5))
 
-	e, err := eh.scan("/foo", false, 1)
+	e, err := eh.scan("/foo", false, 1, nil)
 	if err != nil || e.Index() != 1 {
 		t.Fatalf("scan error [/foo] [1] %v", e.Index)
 	}
 
-	e, err = eh.scan("/foo/bar", false, 1)
+	e, err = eh.scan("/foo/bar", false, 1, nil)
 
 	if err != nil || e.Index() != 2 {
 		t.Fatalf("scan error [/foo/bar] [2] %v", e.Index)
 	}
 
-	e, err = eh.scan("/foo/bar", true, 3)
+	e, err = eh.scan("/foo/bar", true, 3, nil)
 
 	if err != nil || e.Index() != 4 {
 		t.Fatalf("scan error [/foo/bar/bar] [4] %v", e.Index)
 	}
 
-	e, err = eh.scan("/foo/bar", true, 6)
+	e, err = eh.scan("/foo/bar", true, 6, nil)
 
 	if e != nil {
 		t.Fatalf("bad index shoud reuturn nil")
@@ -90,7 +90,7 @@ func TestFullEventQueue(t *testing.T) {
 	for i := 0; i < 1000; i++ {
 		ce := newEvent(Create, "/foo", uint64(i), uint64(i))
 		eh.addEvent(ce)
-		e, err := eh.scan("/foo", true, uint64(i-1))
+		e, err := eh.scan("/foo", true, uint64(i-1), nil)
 		if i > 0 {
 			if e == nil || err != nil {
 				t.Fatalf("scan error [/foo] [%v] %v", i-1, i)
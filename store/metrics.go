@@ -83,6 +83,7 @@ var (
 
 const (
 	GetRecursive = "getRecursive"
+	GetPage      = "getPage"
 )
 
 func init() {
@@ -48,6 +48,10 @@ type node struct {
 	Value      string           // for key-value pair
 	Children   map[string]*node // for directory
 
+	// sorted caches the children sorted by path, for the paginated
+	// listings. It is dropped whenever a child is added or removed.
+	sorted nodesByPath
+
 	// A reference to the store this node is attached to.
 	store *store
 }
@@ -121,6 +125,7 @@ func (n *node) Write(value string, index uint64) *etcdErr.Error {
 		return etcdErr.NewError(etcdErr.EcodeNotFile, "", n.store.CurrentIndex)
 	}
 
+	n.store.changed(n.Path)
 	n.Value = value
 	n.ModifiedIndex = index
 
@@ -165,6 +170,18 @@ func (n *node) List() ([]*node, *etcdErr.Error) {
 	return nodes, nil
 }
 
+// sortedChildren returns the children of the directory node sorted by path.
+// The sorted view is cached until a child is added or removed, so that a
+// large directory is not sorted again for every page read out of it.
+// The caller must hold the sortedMu of the store.
+func (n *node) sortedChildren() nodesByPath {
+	if n.sorted == nil {
+		n.sorted, _ = n.List()
+		sort.Sort(n.sorted)
+	}
+	return n.sorted
+}
+
 // GetChild function returns the child node under the directory node.
 // On success, it returns the file node
 func (n *node) GetChild(name string) (*node, *etcdErr.Error) {
@@ -198,7 +215,9 @@ func (n *node) Add(child *node) *etcdErr.Error {
 		return etcdErr.NewError(etcdErr.EcodeNodeExist, "", n.store.CurrentIndex)
 	}
 
+	n.store.changed(child.Path)
 	n.Children[name] = child
+	n.sorted = nil
 
 	return nil
 }
@@ -224,7 +243,9 @@ func (n *node) Remove(dir, recursive bool, callback func(path string)) *etcdErr.
 
 		// find its parent and remove the node from the map
 		if n.Parent != nil && n.Parent.Children[name] == n {
+			n.store.changed(n.Path)
 			delete(n.Parent.Children, name)
+			n.Parent.sorted = nil
 		}
 
 		if callback != nil {
@@ -245,7 +266,9 @@ func (n *node) Remove(dir, recursive bool, callback func(path string)) *etcdErr.
 	// delete self
 	_, name := path.Split(n.Path)
 	if n.Parent != nil && n.Parent.Children[name] == n {
+		n.store.changed(n.Path)
 		delete(n.Parent.Children, name)
+		n.Parent.sorted = nil
 
 		if callback != nil {
 			callback(n.Path)
@@ -314,6 +337,7 @@ func (n *node) Repr(recursive, sorted bool, clock clockwork.Clock) *NodeExtern {
 }
 
 func (n *node) UpdateTTL(expireTime time.Time) {
+	n.store.changed(n.Path)
 
 	if !n.IsPermanent() {
 		if expireTime.IsZero() {
@@ -71,6 +71,48 @@ func (eNode *NodeExtern) loadInternalNode(n *node, recursive, sorted bool, clock
 	eNode.Expiration, eNode.TTL = n.expirationAndTTL(clock)
 }
 
+// loadInternalNodePage is like loadInternalNode, except that only the children
+// of a directory whose key is not less than startKey are loaded, sorted by
+// key, up to limit of them if limit is not 0.
+// It returns the key of the first child left out, or "" if there is none.
+func (eNode *NodeExtern) loadInternalNodePage(n *node, recursive bool, startKey string, limit uint64, clock clockwork.Clock) string {
+	if !n.IsDir() {
+		eNode.loadInternalNode(n, false, false, clock)
+		return ""
+	}
+	eNode.Dir = true
+
+	// only the children of the page are converted to their external
+	// representation
+	n.store.sortedMu.Lock()
+	children := n.sortedChildren()
+	n.store.sortedMu.Unlock()
+
+	i := sort.Search(len(children), func(i int) bool { return children[i].Path >= startKey })
+	var (
+		page nodesByPath
+		next string
+	)
+	for _, child := range children[i:] {
+		if child.IsHidden() {
+			continue
+		}
+		if limit > 0 && uint64(len(page)) == limit {
+			next = child.Path
+			break
+		}
+		page = append(page, child)
+	}
+
+	eNode.Nodes = make(NodeExterns, len(page))
+	for i, child := range page {
+		eNode.Nodes[i] = child.Repr(recursive, true, clock)
+	}
+
+	eNode.Expiration, eNode.TTL = n.expirationAndTTL(clock)
+	return next
+}
+
 func (eNode *NodeExtern) Clone() *NodeExtern {
 	if eNode == nil {
 		return nil
@@ -113,3 +155,9 @@ func (ns NodeExterns) Less(i, j int) bool {
 func (ns NodeExterns) Swap(i, j int) {
 	ns[i], ns[j] = ns[j], ns[i]
 }
+
+type nodesByPath []*node
+
+func (ns nodesByPath) Len() int           { return len(ns) }
+func (ns nodesByPath) Less(i, j int) bool { return ns[i].Path < ns[j].Path }
+func (ns nodesByPath) Swap(i, j int)      { ns[i], ns[j] = ns[j], ns[i] }
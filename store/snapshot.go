@@ -0,0 +1,556 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A binary snapshot of the store starts with snapshotMagic and the kind of
+// the snapshot, followed by its header: the index and the version of the
+// store, the index the snapshot is based on (0 for a full snapshot), and the
+// stats and the watcher hub of the store encoded in JSON.
+//
+// A full snapshot then holds the encoding of the root node. A delta snapshot
+// holds the number of its records, each of which is the path of a node changed
+// since the index the delta is based on, followed by either the encoding of the
+// node or nodeDeleted. A snapshot may be followed by deltas based on it.
+//
+// A node is encoded as its kind, its created and modified index and its expire
+// time in nanoseconds (0 if permanent), followed by either the value of a
+// key-value pair, or the number of children of a directory, each of which is
+// its name followed by its encoding.
+const (
+	snapshotMagic = "\x00etcdv2s"
+
+	snapshotFull  byte = 1
+	snapshotDelta byte = 2
+
+	nodeKV      byte = 1
+	nodeDir     byte = 2
+	nodeDeleted byte = 3
+
+	// maxChildrenHint is the largest number of children a directory is
+	// allocated for before they are decoded.
+	maxChildrenHint = 1024
+)
+
+var (
+	ErrInvalidSnapshot  = errors.New("store: invalid snapshot")
+	ErrSnapshotMismatch = errors.New("store: delta snapshot does not follow the snapshot it is based on")
+	ErrDeltaUnavailable = errors.New("store: changes since the given index are not tracked")
+)
+
+// IsDeltaSnapshot returns whether the given data is a delta snapshot, which
+// needs the snapshot it is based on to be recovered.
+func IsDeltaSnapshot(data []byte) bool {
+	return len(data) > len(snapshotMagic) && string(data[:len(snapshotMagic)]) == snapshotMagic &&
+		data[len(snapshotMagic)] == snapshotDelta
+}
+
+// SaveTo writes a full snapshot of the store to w. Like SaveNoCopy, it does
+// not lock the store, so it is meant to be called on a clone.
+func (s *store) SaveTo(w io.Writer) error {
+	e := newSnapshotEncoder(w)
+	e.header(s, snapshotFull, 0)
+	e.node(s.Root)
+	return e.flush()
+}
+
+// SaveDeltaTo writes to w a delta snapshot holding the nodes changed after
+// index since. Like SaveTo, it is meant to be called on a clone.
+func (s *store) SaveDeltaTo(w io.Writer, since uint64) error {
+	if since < s.changesFrom || since > s.CurrentIndex {
+		return ErrDeltaUnavailable
+	}
+	changed := make(map[string]bool)
+	for p, index := range s.changes {
+		if index > since {
+			changed[p] = true
+		}
+	}
+	// a node under a changed directory is written along the directory.
+	paths := make([]string, 0, len(changed))
+	for p := range changed {
+		if !hasChangedParent(changed, p) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	e := newSnapshotEncoder(w)
+	e.header(s, snapshotDelta, since)
+	e.uvarint(uint64(len(paths)))
+	for _, p := range paths {
+		e.string(p)
+		if n := s.lookup(p); n != nil {
+			e.node(n)
+		} else {
+			e.byte(nodeDeleted)
+		}
+	}
+	return e.flush()
+}
+
+func hasChangedParent(changed map[string]bool, p string) bool {
+	for p != "/" {
+		p = path.Dir(p)
+		if changed[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// CompactChanges forgets the changes made up to index, which the deltas
+// saved afterwards are not based on anymore.
+func (s *store) CompactChanges(index uint64) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+	if index <= s.changesFrom {
+		return
+	}
+	for p, i := range s.changes {
+		if i <= index {
+			delete(s.changes, p)
+		}
+	}
+	s.changesFrom = index
+}
+
+// changed records that the node at nodePath is changed by the operation
+// being applied at the next index.
+func (s *store) changed(nodePath string) {
+	if s.changes == nil {
+		s.changes = make(map[string]uint64)
+	}
+	s.changes[nodePath] = s.CurrentIndex + 1
+}
+
+// lookup returns the node at nodePath, or nil if there is none.
+func (s *store) lookup(nodePath string) *node {
+	n := s.Root
+	for _, name := range strings.Split(nodePath, "/") {
+		if name == "" {
+			continue
+		}
+		if n = n.Children[name]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// recoverBinary recovers the store from a full binary snapshot followed by
+// any number of deltas, read from r. The nodes for which keep returns false
+// are skipped.
+func (s *store) recoverBinary(r *bufio.Reader, keep func(nodePath string) bool) error {
+	d := &snapshotDecoder{r: r, keep: keep}
+	kind, since, err := d.header(s)
+	if err != nil {
+		return err
+	}
+	if kind != snapshotFull {
+		return ErrSnapshotMismatch
+	}
+	root, err := d.node("/")
+	if err != nil {
+		return err
+	}
+	if !root.IsDir() {
+		return ErrInvalidSnapshot
+	}
+	s.Root = root
+	for {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			return nil
+		}
+		index := s.CurrentIndex
+		if kind, since, err = d.header(s); err != nil {
+			return err
+		}
+		if kind != snapshotDelta || since != index {
+			return ErrSnapshotMismatch
+		}
+		if err = s.applyDelta(d); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *store) applyDelta(d *snapshotDecoder) error {
+	count, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return ErrInvalidSnapshot
+	}
+	for i := uint64(0); i < count; i++ {
+		p, err := d.string()
+		if err != nil {
+			return err
+		}
+		if !d.kept(p) {
+			if _, err := d.skipNode(); err != nil {
+				return err
+			}
+			continue
+		}
+		n, err := d.node(p)
+		if err != nil {
+			return err
+		}
+		if p == "/" {
+			if n == nil || !n.IsDir() {
+				return ErrInvalidSnapshot
+			}
+			s.Root = n
+			continue
+		}
+		parent := s.lookup(path.Dir(p))
+		if parent == nil || !parent.IsDir() {
+			return ErrInvalidSnapshot
+		}
+		_, name := path.Split(p)
+		if n == nil {
+			delete(parent.Children, name)
+		} else {
+			parent.Children[name] = n
+		}
+		parent.sorted = nil
+	}
+	return nil
+}
+
+type snapshotEncoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func newSnapshotEncoder(w io.Writer) *snapshotEncoder {
+	return &snapshotEncoder{w: bufio.NewWriter(w)}
+}
+
+func (e *snapshotEncoder) header(s *store, kind byte, since uint64) {
+	e.write([]byte(snapshotMagic))
+	e.byte(kind)
+	e.uvarint(s.CurrentIndex)
+	e.uvarint(uint64(s.CurrentVersion))
+	e.uvarint(since)
+	e.json(s.Stats)
+	e.json(s.WatcherHub)
+}
+
+func (e *snapshotEncoder) node(n *node) {
+	if n.IsDir() {
+		e.byte(nodeDir)
+	} else {
+		e.byte(nodeKV)
+	}
+	e.uvarint(n.CreatedIndex)
+	e.uvarint(n.ModifiedIndex)
+	var expire int64
+	if !n.IsPermanent() {
+		expire = n.ExpireTime.UnixNano()
+	}
+	e.varint(expire)
+	if !n.IsDir() {
+		e.string(n.Value)
+		return
+	}
+	e.uvarint(uint64(len(n.Children)))
+	for name, child := range n.Children {
+		e.string(name)
+		e.node(child)
+	}
+}
+
+func (e *snapshotEncoder) json(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	e.uvarint(uint64(len(b)))
+	e.write(b)
+}
+
+func (e *snapshotEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+func (e *snapshotEncoder) uvarint(v uint64) {
+	e.write(e.buf[:binary.PutUvarint(e.buf[:], v)])
+}
+
+func (e *snapshotEncoder) varint(v int64) {
+	e.write(e.buf[:binary.PutVarint(e.buf[:], v)])
+}
+
+func (e *snapshotEncoder) byte(b byte) {
+	if e.err == nil {
+		e.err = e.w.WriteByte(b)
+	}
+}
+
+func (e *snapshotEncoder) write(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+func (e *snapshotEncoder) flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+type snapshotDecoder struct {
+	r *bufio.Reader
+	// keep returns whether the node at the given path is decoded. The
+	// other nodes are skipped. If it is nil, all the nodes are decoded.
+	keep func(nodePath string) bool
+}
+
+func (d *snapshotDecoder) kept(nodePath string) bool {
+	return d.keep == nil || d.keep(nodePath)
+}
+
+func (d *snapshotDecoder) header(s *store) (kind byte, since uint64, err error) {
+	magic := make([]byte, len(snapshotMagic))
+	if _, err = io.ReadFull(d.r, magic); err != nil || string(magic) != snapshotMagic {
+		return 0, 0, ErrInvalidSnapshot
+	}
+	if kind, err = d.r.ReadByte(); err != nil {
+		return 0, 0, ErrInvalidSnapshot
+	}
+	index, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return 0, 0, ErrInvalidSnapshot
+	}
+	version, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return 0, 0, ErrInvalidSnapshot
+	}
+	if since, err = binary.ReadUvarint(d.r); err != nil {
+		return 0, 0, ErrInvalidSnapshot
+	}
+	stats, hub := newStats(), newWatchHub(1000)
+	if err = d.json(stats); err != nil {
+		return 0, 0, err
+	}
+	if err = d.json(hub); err != nil {
+		return 0, 0, err
+	}
+	s.CurrentIndex = index
+	s.CurrentVersion = int(version)
+	s.Stats = stats
+	s.WatcherHub.EventHistory = hub.EventHistory
+	return kind, since, nil
+}
+
+// node decodes the node at nodePath, or returns nil if it is deleted.
+func (d *snapshotDecoder) node(nodePath string) (*node, error) {
+	kind, err := d.r.ReadByte()
+	if err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	if kind == nodeDeleted {
+		return nil, nil
+	}
+	if kind != nodeKV && kind != nodeDir {
+		return nil, ErrInvalidSnapshot
+	}
+	n := &node{Path: nodePath}
+	if n.CreatedIndex, err = binary.ReadUvarint(d.r); err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	if n.ModifiedIndex, err = binary.ReadUvarint(d.r); err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	expire, err := binary.ReadVarint(d.r)
+	if err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	if expire != 0 {
+		n.ExpireTime = time.Unix(0, expire)
+	}
+	if kind == nodeKV {
+		n.Value, err = d.string()
+		return n, err
+	}
+	count, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	// the count is not trusted to size the map, as the snapshot is read
+	// as it is decoded.
+	n.Children = make(map[string]*node, minUint64(count, maxChildrenHint))
+	for i := uint64(0); i < count; i++ {
+		name, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		childPath := path.Join(nodePath, name)
+		if !d.kept(childPath) {
+			deleted, err := d.skipNode()
+			if err != nil {
+				return nil, err
+			}
+			if deleted {
+				return nil, ErrInvalidSnapshot
+			}
+			continue
+		}
+		child, err := d.node(childPath)
+		if err != nil {
+			return nil, err
+		}
+		if child == nil {
+			return nil, ErrInvalidSnapshot
+		}
+		n.Children[name] = child
+	}
+	return n, nil
+}
+
+// skipNode reads past a node without decoding it, and returns whether it
+// is deleted.
+func (d *snapshotDecoder) skipNode() (deleted bool, err error) {
+	kind, err := d.r.ReadByte()
+	if err != nil {
+		return false, ErrInvalidSnapshot
+	}
+	if kind == nodeDeleted {
+		return true, nil
+	}
+	if kind != nodeKV && kind != nodeDir {
+		return false, ErrInvalidSnapshot
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := binary.ReadUvarint(d.r); err != nil {
+			return false, ErrInvalidSnapshot
+		}
+	}
+	if _, err := binary.ReadVarint(d.r); err != nil {
+		return false, ErrInvalidSnapshot
+	}
+	if kind == nodeKV {
+		return false, d.skipBytes()
+	}
+	count, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return false, ErrInvalidSnapshot
+	}
+	for i := uint64(0); i < count; i++ {
+		if err := d.skipBytes(); err != nil {
+			return false, err
+		}
+		deleted, err := d.skipNode()
+		if err != nil {
+			return false, err
+		}
+		if deleted {
+			return false, ErrInvalidSnapshot
+		}
+	}
+	return false, nil
+}
+
+func (d *snapshotDecoder) string() (string, error) {
+	b, err := d.bytes()
+	return string(b), err
+}
+
+func (d *snapshotDecoder) json(v interface{}) error {
+	b, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return ErrInvalidSnapshot
+	}
+	return nil
+}
+
+func (d *snapshotDecoder) bytes() ([]byte, error) {
+	l, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	// a broken length fails when the data is exhausted, rather than
+	// allocating it up front.
+	var buf bytes.Buffer
+	if n, err := io.CopyN(&buf, d.r, int64(l)); err != nil || uint64(n) != l {
+		return nil, ErrInvalidSnapshot
+	}
+	return buf.Bytes(), nil
+}
+
+// skipBytes reads past a length-prefixed byte string.
+func (d *snapshotDecoder) skipBytes() error {
+	l, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return ErrInvalidSnapshot
+	}
+	if n, err := io.CopyN(ioutil.Discard, d.r, int64(l)); err != nil || uint64(n) != l {
+		return ErrInvalidSnapshot
+	}
+	return nil
+}
+
+// prefixFilter returns a function which returns whether a node is under
+// prefix or is one of its parents, or nil if all the nodes are.
+func prefixFilter(prefix string) func(nodePath string) bool {
+	prefix = path.Join("/", prefix)
+	if prefix == "/" {
+		return nil
+	}
+	return func(p string) bool {
+		return p == "/" || p == prefix || strings.HasPrefix(p, prefix+"/") || strings.HasPrefix(prefix, p+"/")
+	}
+}
+
+// pruneNode removes the descendants of n for which keep returns false.
+func pruneNode(n *node, keep func(nodePath string) bool) {
+	for name, child := range n.Children {
+		if !keep(child.Path) {
+			delete(n.Children, name)
+			continue
+		}
+		if child.IsDir() {
+			pruneNode(child, keep)
+		}
+	}
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
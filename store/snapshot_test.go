@@ -0,0 +1,208 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestSaveToRecovery(t *testing.T) {
+	s := newStore("/0", "/1")
+	s.clock = newFakeClock()
+	exp := s.clock.Now().Add(time.Hour)
+	s.Create("/0/foo", true, "", false, Permanent)
+	s.Create("/0/foo/bar", false, "baz", false, exp)
+	s.Create("/1/qux", false, "", false, Permanent)
+
+	var buf bytes.Buffer
+	if err := s.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s2 := newStore()
+	s2.clock = s.clock
+	if err := s2.Recovery(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if s2.CurrentIndex != s.CurrentIndex {
+		t.Errorf("index = %d, want %d", s2.CurrentIndex, s.CurrentIndex)
+	}
+	assertSameTree(t, s2, s)
+	if n := s2.ttlKeyHeap.top(); n == nil || n.Path != "/0/foo/bar" || !n.ExpireTime.Equal(exp) {
+		t.Errorf("ttl heap top = %+v, want /0/foo/bar expiring at %v", n, exp)
+	}
+}
+
+func TestSaveDeltaToRecovery(t *testing.T) {
+	s := newStore()
+	s.clock = newFakeClock()
+	s.Create("/foo/bar", false, "v", false, Permanent)
+	s.Create("/foo/baz", false, "v", false, Permanent)
+	s.Create("/dir/a", false, "v", false, Permanent)
+	for i := 0; i < 100; i++ {
+		s.Create("/unchanged/"+strconv.Itoa(i), false, "value", false, Permanent)
+	}
+	base := saveBinary(t, s)
+	since := s.CurrentIndex
+	s.CompactChanges(since)
+
+	s.Update("/foo/bar", "v2", Permanent)
+	s.Delete("/foo/baz", false, false)
+	s.Delete("/dir", true, true)
+	s.Create("/new/a/b", false, "v", false, Permanent)
+	s.Refresh("/new/a/b", s.clock.Now().Add(time.Hour))
+
+	var buf bytes.Buffer
+	if err := s.SaveDeltaTo(&buf, since); err != nil {
+		t.Fatal(err)
+	}
+	if !IsDeltaSnapshot(buf.Bytes()) {
+		t.Errorf("IsDeltaSnapshot = false, want true")
+	}
+	if IsDeltaSnapshot(base) {
+		t.Errorf("IsDeltaSnapshot(base) = true, want false")
+	}
+	full := saveBinary(t, s)
+	if buf.Len() >= len(full) {
+		t.Errorf("len(delta) = %d, want less than the full snapshot %d", buf.Len(), len(full))
+	}
+
+	// the chain is decoded as it is read.
+	s2 := newStore()
+	s2.clock = s.clock
+	err := s2.RecoveryFrom(iotest.OneByteReader(io.MultiReader(bytes.NewReader(base), bytes.NewReader(buf.Bytes()))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2.CurrentIndex != s.CurrentIndex {
+		t.Errorf("index = %d, want %d", s2.CurrentIndex, s.CurrentIndex)
+	}
+	assertSameTree(t, s2, s)
+
+	// a delta cannot be recovered without its base.
+	if err = newStore().Recovery(buf.Bytes()); err != ErrSnapshotMismatch {
+		t.Errorf("err = %v, want %v", err, ErrSnapshotMismatch)
+	}
+	// nor after a snapshot it is not based on.
+	if err = newStore().Recovery(append(full, buf.Bytes()...)); err != ErrSnapshotMismatch {
+		t.Errorf("err = %v, want %v", err, ErrSnapshotMismatch)
+	}
+	// nor when it is truncated.
+	if err = newStore().Recovery(append(base, buf.Bytes()[:buf.Len()-1]...)); err != ErrInvalidSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrInvalidSnapshot)
+	}
+	// the changes before since are forgotten.
+	if err = s.SaveDeltaTo(&buf, since-1); err != ErrDeltaUnavailable {
+		t.Errorf("err = %v, want %v", err, ErrDeltaUnavailable)
+	}
+}
+
+func TestRecoveryJSON(t *testing.T) {
+	s := newStore()
+	s.Create("/foo/bar", false, "baz", false, Permanent)
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := newStore()
+	if err := s2.Recovery(b); err != nil {
+		t.Fatal(err)
+	}
+	assertSameTree(t, s2, s)
+}
+
+// RecoverPrefix should only recover the nodes under the prefix, from a JSON
+// snapshot as well as from a binary snapshot followed by a delta.
+func TestRecoverPrefix(t *testing.T) {
+	s := newStore()
+	s.Create("/0/a", false, "v", false, Permanent)
+	s.Create("/0/b/c", false, "v", false, Permanent)
+	s.Create("/1/x", false, "v", false, Permanent)
+	s.Create("/1/y/z", false, "v", false, Permanent)
+	s.Create("/10", false, "v", false, Permanent)
+	base := saveBinary(t, s)
+	since := s.CurrentIndex
+	s.CompactChanges(since)
+
+	s.Update("/0/a", "v2", Permanent)
+	s.Create("/0/d", false, "v", false, Permanent)
+	s.Delete("/1/x", false, false)
+	s.Create("/1/new", false, "v", false, Permanent)
+	var delta bytes.Buffer
+	if err := s.SaveDeltaTo(&delta, since); err != nil {
+		t.Fatal(err)
+	}
+	js, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := [][]byte{
+		js,
+		append(base, delta.Bytes()...),
+	}
+	for i, tt := range tests {
+		rs, err := RecoverPrefix(tt, "/0")
+		if err != nil {
+			t.Fatalf("#%d: recover error: %v", i, err)
+		}
+		ge, err := rs.Get("/0", true, true)
+		if err != nil {
+			t.Fatalf("#%d: get /0 error: %v", i, err)
+		}
+		we, _ := s.Get("/0", true, true)
+		if !reflect.DeepEqual(ge.Node, we.Node) {
+			t.Errorf("#%d: tree = %+v, want %+v", i, ge.Node, we.Node)
+		}
+		if rs.Index() != s.CurrentIndex {
+			t.Errorf("#%d: index = %d, want %d", i, rs.Index(), s.CurrentIndex)
+		}
+		for _, p := range []string{"/1", "/10"} {
+			if _, err := rs.Get(p, false, false); err == nil {
+				t.Errorf("#%d: get %s succeeded, want key not found", i, p)
+			}
+		}
+	}
+}
+
+// saveBinary returns a full binary snapshot of s.
+func saveBinary(t *testing.T, s *store) []byte {
+	var buf bytes.Buffer
+	if err := s.Clone().SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func assertSameTree(t *testing.T, g, w *store) {
+	ge, err := g.Get("/", true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	we, err := w.Get("/", true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ge.EtcdIndex, we.EtcdIndex = 0, 0
+	if !reflect.DeepEqual(ge.Node, we.Node) {
+		t.Errorf("tree = %+v, want %+v", ge.Node, we.Node)
+	}
+}
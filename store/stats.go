@@ -35,6 +35,10 @@ const (
 	ExpireCount
 	CompareAndDeleteSuccess
 	CompareAndDeleteFail
+	TxnSuccess
+	TxnFail
+	RefreshSuccess
+	RefreshFail
 )
 
 type Stats struct {
@@ -67,6 +71,14 @@ type Stats struct {
 	CompareAndDeleteSuccess uint64 `json:"compareAndDeleteSuccess"`
 	CompareAndDeleteFail    uint64 `json:"compareAndDeleteFail"`
 
+	// Number of txn requests
+	TxnSuccess uint64 `json:"txnSuccess"`
+	TxnFail    uint64 `json:"txnFail"`
+
+	// Number of refresh requests
+	RefreshSuccess uint64 `json:"refreshSuccess"`
+	RefreshFail    uint64 `json:"refreshFail"`
+
 	ExpireCount uint64 `json:"expireCount"`
 
 	Watchers uint64 `json:"watchers"`
@@ -93,6 +105,10 @@ func (s *Stats) clone() *Stats {
 		CompareAndSwapFail:      s.CompareAndSwapFail,
 		CompareAndDeleteSuccess: s.CompareAndDeleteSuccess,
 		CompareAndDeleteFail:    s.CompareAndDeleteFail,
+		TxnSuccess:              s.TxnSuccess,
+		TxnFail:                 s.TxnFail,
+		RefreshSuccess:          s.RefreshSuccess,
+		RefreshFail:             s.RefreshFail,
 		ExpireCount:             s.ExpireCount,
 		Watchers:                s.Watchers,
 	}
@@ -133,6 +149,14 @@ func (s *Stats) Inc(field int) {
 		atomic.AddUint64(&s.CompareAndDeleteSuccess, 1)
 	case CompareAndDeleteFail:
 		atomic.AddUint64(&s.CompareAndDeleteFail, 1)
+	case TxnSuccess:
+		atomic.AddUint64(&s.TxnSuccess, 1)
+	case TxnFail:
+		atomic.AddUint64(&s.TxnFail, 1)
+	case RefreshSuccess:
+		atomic.AddUint64(&s.RefreshSuccess, 1)
+	case RefreshFail:
+		atomic.AddUint64(&s.RefreshFail, 1)
 	case ExpireCount:
 		atomic.AddUint64(&s.ExpireCount, 1)
 	}
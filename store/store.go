@@ -15,8 +15,11 @@
 package store
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"path"
 	"strconv"
 	"strings"
@@ -42,22 +45,30 @@ type Store interface {
 	Index() uint64
 
 	Get(nodePath string, recursive, sorted bool) (*Event, error)
+	GetPage(nodePath string, recursive bool, startKey string, limit uint64) (*Event, error)
 	Set(nodePath string, dir bool, value string, expireTime time.Time) (*Event, error)
 	Update(nodePath string, newValue string, expireTime time.Time) (*Event, error)
+	Refresh(nodePath string, expireTime time.Time) (*Event, error)
 	Create(nodePath string, dir bool, value string, unique bool,
 		expireTime time.Time) (*Event, error)
 	CompareAndSwap(nodePath string, prevValue string, prevIndex uint64,
 		value string, expireTime time.Time) (*Event, error)
 	Delete(nodePath string, dir, recursive bool) (*Event, error)
 	CompareAndDelete(nodePath string, prevValue string, prevIndex uint64) (*Event, error)
+	Txn(compares []TxnCompare, ops []TxnOp) ([]*Event, error)
 
 	Watch(prefix string, recursive, stream bool, sinceIndex uint64) (Watcher, error)
+	WatchFiltered(prefix string, recursive, stream bool, sinceIndex uint64, filter WatchFilter) (Watcher, error)
 
 	Save() ([]byte, error)
 	Recovery(state []byte) error
+	RecoveryFrom(r io.Reader) error
 
 	Clone() Store
 	SaveNoCopy() ([]byte, error)
+	SaveTo(w io.Writer) error
+	SaveDeltaTo(w io.Writer, since uint64) error
+	CompactChanges(index uint64)
 
 	JsonStats() []byte
 	DeleteExpiredKeys(cutoff time.Time)
@@ -71,8 +82,13 @@ type store struct {
 	CurrentVersion int
 	ttlKeyHeap     *ttlKeyHeap  // need to recovery manually
 	worldLock      sync.RWMutex // stop the world lock
+	sortedMu       sync.Mutex   // guards the sorted children of the nodes
 	clock          clockwork.Clock
 	readonlySet    types.Set
+	// changes maps the path of the nodes changed after changesFrom to the
+	// index they were last changed at, for the delta snapshots.
+	changes     map[string]uint64
+	changesFrom uint64
 }
 
 // The given namespaces will be created as initial directories in the returned store.
@@ -82,6 +98,19 @@ func New(namespaces ...string) Store {
 	return s
 }
 
+// RecoverPrefix returns a store with the given namespaces recovered like
+// Recovery from the given state, keeping only the nodes under prefix and
+// their parents. The other nodes of a binary snapshot are skipped as it is
+// decoded, rather than recovered.
+func RecoverPrefix(state []byte, prefix string, namespaces ...string) (Store, error) {
+	s := newStore(namespaces...)
+	s.clock = clockwork.NewRealClock()
+	if err := s.recovery(bytes.NewReader(state), prefixFilter(prefix)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
 func newStore(namespaces ...string) *store {
 	s := new(store)
 	s.CurrentVersion = defaultVersion
@@ -143,6 +172,37 @@ func (s *store) Get(nodePath string, recursive, sorted bool) (*Event, error) {
 	return e, nil
 }
 
+// GetPage returns a get event for the node at nodePath.
+// If the node is a directory, at most limit of its children are listed,
+// sorted by key, starting from the first child whose key is not less than
+// startKey. A limit of 0 lists all of them.
+// The key of the first child left out is returned in the Next field of
+// the event, and can be given as startKey to get the following page.
+// If recursive is true, the whole subtree of every listed child is listed.
+func (s *store) GetPage(nodePath string, recursive bool, startKey string, limit uint64) (*Event, error) {
+	s.worldLock.RLock()
+	defer s.worldLock.RUnlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+
+	n, err := s.internalGet(nodePath)
+
+	if err != nil {
+		s.Stats.Inc(GetFail)
+		reportReadFailure(GetPage)
+		return nil, err
+	}
+
+	e := newEvent(Get, nodePath, n.ModifiedIndex, n.CreatedIndex)
+	e.EtcdIndex = s.CurrentIndex
+	e.Next = e.Node.loadInternalNodePage(n, recursive, startKey, limit, s.clock)
+
+	s.Stats.Inc(GetSuccess)
+	reportReadSuccess(GetPage)
+
+	return e, nil
+}
+
 // Create creates the node at nodePath. Create will help to create intermediate directories with no ttl.
 // If the node has already existed, create will fail.
 // If any node on the path is a file, create will fail.
@@ -391,6 +451,13 @@ func (s *store) CompareAndDelete(nodePath string, prevValue string, prevIndex ui
 }
 
 func (s *store) Watch(key string, recursive, stream bool, sinceIndex uint64) (Watcher, error) {
+	return s.WatchFiltered(key, recursive, stream, sinceIndex, WatchFilter{})
+}
+
+// WatchFiltered is like Watch, except that the watcher is only notified of
+// the events which pass the given filter, with the parts removed by the
+// filter left out.
+func (s *store) WatchFiltered(key string, recursive, stream bool, sinceIndex uint64, filter WatchFilter) (Watcher, error) {
 	s.worldLock.RLock()
 	defer s.worldLock.RUnlock()
 
@@ -399,10 +466,13 @@ func (s *store) Watch(key string, recursive, stream bool, sinceIndex uint64) (Wa
 		sinceIndex = s.CurrentIndex + 1
 	}
 	// WatchHub does not know about the current index, so we need to pass it in
-	w, err := s.WatcherHub.watch(key, recursive, stream, sinceIndex, s.CurrentIndex)
+	w, err := s.WatcherHub.watch(key, recursive, stream, sinceIndex, s.CurrentIndex, filter)
 	if err != nil {
 		return nil, err
 	}
+	// the store notifies the watchers while holding the world lock, so
+	// every event up to the index read under the lock is already sent.
+	w.currentIndex = s.Index
 
 	return w, nil
 }
@@ -489,6 +559,56 @@ func (s *store) Update(nodePath string, newValue string, expireTime time.Time) (
 	return e, nil
 }
 
+// Refresh updates the ttl of the node at nodePath, keeping its value and
+// ModifiedIndex unchanged.
+// The store index does not move forward, and no watcher is notified.
+func (s *store) Refresh(nodePath string, expireTime time.Time) (*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	nodePath = path.Clean(path.Join("/", nodePath))
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		return nil, etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+
+	n, err := s.internalGet(nodePath)
+
+	if err != nil { // if the node does not exist, return error
+		s.Stats.Inc(RefreshFail)
+		reportWriteFailure(Refresh)
+		return nil, err
+	}
+
+	// Assume expire times that are way in the past are
+	// This can occur when the time is serialized to JS
+	if expireTime.Before(minExpireTime) {
+		expireTime = Permanent
+	}
+
+	e := newEvent(Refresh, nodePath, n.ModifiedIndex, n.CreatedIndex)
+	e.EtcdIndex = s.CurrentIndex
+	e.PrevNode = n.Repr(false, false, s.clock)
+	eNode := e.Node
+
+	if n.IsDir() {
+		eNode.Dir = true
+	} else {
+		// copy the value for safety
+		valueCopy := n.Value
+		eNode.Value = &valueCopy
+	}
+
+	n.UpdateTTL(expireTime)
+
+	eNode.Expiration, eNode.TTL = n.expirationAndTTL(s.clock)
+
+	s.Stats.Inc(RefreshSuccess)
+	reportWriteSuccess(Refresh)
+
+	return e, nil
+}
+
 func (s *store) internalCreate(nodePath string, dir bool, value string, unique, replace bool,
 	expireTime time.Time, action string) (*Event, error) {
 
@@ -646,7 +766,9 @@ func (s *store) checkDir(parent *node, dirName string) (*node, *etcdErr.Error) {
 
 	n := newDir(s, path.Join(parent.Path, dirName), s.CurrentIndex+1, parent, Permanent)
 
+	s.changed(n.Path)
 	parent.Children[dirName] = n
+	parent.sorted = nil
 
 	return n, nil
 }
@@ -656,14 +778,12 @@ func (s *store) checkDir(parent *node, dirName string) (*node, *etcdErr.Error) {
 // It will not save the parent field of the node. Or there will
 // be cyclic dependencies issue for the json package.
 func (s *store) Save() ([]byte, error) {
-	b, err := json.Marshal(s.Clone())
-	if err != nil {
-		return nil, err
-	}
-
-	return b, nil
+	return s.Clone().SaveNoCopy()
 }
 
+// SaveNoCopy saves the store in JSON, like Save, without cloning it first.
+// The JSON snapshots can be recovered by all versions, unlike the binary
+// ones written by SaveTo.
 func (s *store) SaveNoCopy() ([]byte, error) {
 	b, err := json.Marshal(s)
 	if err != nil {
@@ -682,25 +802,51 @@ func (s *store) Clone() Store {
 	clonedStore.WatcherHub = s.WatcherHub.clone()
 	clonedStore.Stats = s.Stats.clone()
 	clonedStore.CurrentVersion = s.CurrentVersion
+	clonedStore.changes = make(map[string]uint64, len(s.changes))
+	for p, index := range s.changes {
+		clonedStore.changes[p] = index
+	}
+	clonedStore.changesFrom = s.changesFrom
 
 	s.worldLock.Unlock()
 	return clonedStore
 }
 
-// Recovery recovers the store system from a static state
+// Recovery recovers the store system from a static state, which is either a
+// binary snapshot followed by the deltas based on it, or the JSON encoding of
+// the store.
 // It needs to recover the parent field of the nodes.
 // It needs to delete the expired nodes since the saved time and also
 // needs to create monitoring go routines.
 func (s *store) Recovery(state []byte) error {
+	return s.RecoveryFrom(bytes.NewReader(state))
+}
+
+// RecoveryFrom recovers the store like Recovery, from the state read from r.
+// A binary snapshot is decoded as it is read.
+func (s *store) RecoveryFrom(r io.Reader) error {
+	return s.recovery(r, nil)
+}
+
+// recovery recovers the store from the state read from r, keeping only the
+// nodes for which keep returns true, or all of them if it is nil.
+func (s *store) recovery(r io.Reader, keep func(nodePath string) bool) error {
 	s.worldLock.Lock()
 	defer s.worldLock.Unlock()
-	err := json.Unmarshal(state, s)
-
+	br := bufio.NewReader(r)
+	var err error
+	if b, _ := br.Peek(len(snapshotMagic)); string(b) == snapshotMagic {
+		err = s.recoverBinary(br, keep)
+	} else if err = json.NewDecoder(br).Decode(s); err == nil && keep != nil {
+		pruneNode(s.Root, keep)
+	}
 	if err != nil {
 		return err
 	}
 
+	s.Root.store, s.Root.Parent = s, nil
 	s.ttlKeyHeap = newTtlKeyHeap()
+	s.changes, s.changesFrom = nil, s.CurrentIndex
 
 	s.Root.recoverAndclean()
 	return nil
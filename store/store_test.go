@@ -15,6 +15,7 @@
 package store
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -148,6 +149,90 @@ func TestStoreGetSorted(t *testing.T) {
 	}
 }
 
+// Ensure that the store can list a directory page by page, in key order.
+func TestStoreGetPage(t *testing.T) {
+	s := newStore()
+	s.Create("/foo", true, "", false, Permanent)
+	s.Create("/foo/d", false, "0", false, Permanent)
+	s.Create("/foo/b", true, "", false, Permanent)
+	s.Create("/foo/b/x", false, "0", false, Permanent)
+	s.Create("/foo/_hidden", false, "0", false, Permanent)
+	s.Create("/foo/a", false, "0", false, Permanent)
+	s.Create("/foo/c", false, "0", false, Permanent)
+	var eidx uint64 = 7
+
+	tests := []struct {
+		recursive bool
+		startKey  string
+		limit     uint64
+
+		wkeys []string
+		wnext string
+	}{
+		{false, "", 0, []string{"/foo/a", "/foo/b", "/foo/c", "/foo/d"}, ""},
+		{false, "", 2, []string{"/foo/a", "/foo/b"}, "/foo/c"},
+		{false, "/foo/c", 2, []string{"/foo/c", "/foo/d"}, ""},
+		{false, "/foo/bb", 1, []string{"/foo/c"}, "/foo/d"},
+		{false, "/foo/e", 1, []string{}, ""},
+		{true, "/foo/b", 1, []string{"/foo/b"}, "/foo/c"},
+	}
+	for i, tt := range tests {
+		e, err := s.GetPage("/foo", tt.recursive, tt.startKey, tt.limit)
+		assert.Nil(t, err, "")
+		assert.Equal(t, e.EtcdIndex, eidx, "")
+		assert.Equal(t, e.Action, "get", "")
+		assert.True(t, e.Node.Dir, "")
+		keys := make([]string, len(e.Node.Nodes))
+		for j, n := range e.Node.Nodes {
+			keys[j] = n.Key
+		}
+		assert.Equal(t, keys, tt.wkeys, fmt.Sprintf("#%d", i))
+		assert.Equal(t, e.Next, tt.wnext, fmt.Sprintf("#%d", i))
+	}
+
+	// the children of a listed directory are only listed if recursive
+	e, _ := s.GetPage("/foo", false, "/foo/b", 1)
+	assert.Nil(t, e.Node.Nodes[0].Nodes, "")
+	e, _ = s.GetPage("/foo", true, "/foo/b", 1)
+	assert.Equal(t, len(e.Node.Nodes[0].Nodes), 1, "")
+	assert.Equal(t, e.Node.Nodes[0].Nodes[0].Key, "/foo/b/x", "")
+
+	// a file is returned as is
+	e, err := s.GetPage("/foo/a", false, "", 1)
+	assert.Nil(t, err, "")
+	assert.Equal(t, *e.Node.Value, "0", "")
+	assert.Equal(t, e.Next, "", "")
+
+	_, err = s.GetPage("/bar", false, "", 1)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+}
+
+// Ensure that the pages of a directory follow the children added and removed
+// after it was listed.
+func TestStoreGetPageAfterChange(t *testing.T) {
+	s := newStore()
+	s.Create("/foo/b", false, "0", false, Permanent)
+	s.Create("/foo/d", false, "0", false, Permanent)
+	pageKeys := func() []string {
+		e, err := s.GetPage("/foo", false, "", 0)
+		assert.Nil(t, err, "")
+		keys := make([]string, len(e.Node.Nodes))
+		for i, n := range e.Node.Nodes {
+			keys[i] = n.Key
+		}
+		return keys
+	}
+	assert.Equal(t, pageKeys(), []string{"/foo/b", "/foo/d"}, "")
+
+	s.Create("/foo/a", false, "0", false, Permanent)
+	s.Create("/foo/c/x", false, "0", false, Permanent)
+	assert.Equal(t, pageKeys(), []string{"/foo/a", "/foo/b", "/foo/c", "/foo/d"}, "")
+
+	s.Delete("/foo/b", false, false)
+	s.Delete("/foo/c", true, true)
+	assert.Equal(t, pageKeys(), []string{"/foo/a", "/foo/d"}, "")
+}
+
 func TestSet(t *testing.T) {
 	s := newStore()
 
@@ -380,6 +465,52 @@ func TestStoreUpdateDirTTL(t *testing.T) {
 	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
 }
 
+// Ensure that the store can refresh the TTL of a key without changing its value and index.
+func TestStoreRefreshTTL(t *testing.T) {
+	s := newStore()
+	fc := newFakeClock()
+	s.clock = fc
+
+	var eidx uint64 = 1
+	s.Create("/foo", false, "bar", false, fc.Now().Add(500*time.Millisecond))
+	w, _ := s.Watch("/foo", false, false, 0)
+
+	fc.Advance(400 * time.Millisecond)
+	e, err := s.Refresh("/foo", fc.Now().Add(500*time.Millisecond))
+	assert.Nil(t, err, "")
+	assert.Equal(t, e.Action, "refresh", "")
+	assert.Equal(t, e.EtcdIndex, eidx, "")
+	assert.Equal(t, e.Node.ModifiedIndex, eidx, "")
+	assert.Equal(t, *e.Node.Value, "bar", "")
+	assert.Equal(t, e.Node.TTL, int64(1), "")
+	assert.Equal(t, s.Index(), eidx, "")
+	assert.Nil(t, nbselect(w.EventChan()), "")
+
+	// the key would have expired without the refresh
+	fc.Advance(400 * time.Millisecond)
+	s.DeleteExpiredKeys(fc.Now())
+	e, _ = s.Get("/foo", false, false)
+	assert.Equal(t, *e.Node.Value, "bar", "")
+	assert.Nil(t, nbselect(w.EventChan()), "")
+
+	fc.Advance(200 * time.Millisecond)
+	s.DeleteExpiredKeys(fc.Now())
+	e = nbselect(w.EventChan())
+	assert.NotNil(t, e, "")
+	assert.Equal(t, e.Action, "expire", "")
+}
+
+// Ensure that the store cannot refresh a key that does not exist.
+func TestStoreRefreshFailsIfNotExist(t *testing.T) {
+	s := newStore()
+	var eidx uint64 = 0
+	e, _err := s.Refresh("/foo", time.Now().Add(time.Second))
+	err := _err.(*etcdErr.Error)
+	assert.Nil(t, e, "")
+	assert.Equal(t, err.ErrorCode, etcdErr.EcodeKeyNotFound, "")
+	assert.Equal(t, s.Index(), eidx, "")
+}
+
 // Ensure that the store can delete a value.
 func TestStoreDeleteValue(t *testing.T) {
 	s := newStore()
@@ -625,6 +756,143 @@ func TestStoreWatchCreate(t *testing.T) {
 	assert.Nil(t, e, "")
 }
 
+// Ensure that the store applies all the ops of a txn at the same index.
+func TestStoreTxn(t *testing.T) {
+	s := newStore()
+	s.Create("/foo", false, "bar", false, Permanent)
+	s.Create("/dir/old", false, "x", false, Permanent)
+	var eidx uint64 = 3
+	exist := true
+	cmps := []TxnCompare{
+		{Key: "/foo", PrevValue: "bar"},
+		{Key: "/dir/old", PrevExist: &exist},
+	}
+	ops := []TxnOp{
+		{Action: Set, Key: "/foo", Value: "baz"},
+		{Action: Set, Key: "/dir/new", Value: "y"},
+		{Action: Delete, Key: "/dir/old"},
+	}
+	evs, err := s.Txn(cmps, ops)
+	assert.Nil(t, err, "")
+	assert.Equal(t, len(evs), 3, "")
+	for i, e := range evs {
+		assert.Equal(t, e.EtcdIndex, eidx, "")
+		assert.Equal(t, e.Index(), eidx, "")
+		assert.Equal(t, e.Node.Key, ops[i].Key, "")
+		assert.Equal(t, e.Action, ops[i].Action, "")
+	}
+	assert.Equal(t, *evs[0].PrevNode.Value, "bar", "")
+	assert.Equal(t, *evs[2].PrevNode.Value, "x", "")
+	assert.Equal(t, s.Index(), eidx, "")
+
+	e, _ := s.Get("/foo", false, false)
+	assert.Equal(t, *e.Node.Value, "baz", "")
+	e, _ = s.Get("/dir/new", false, false)
+	assert.Equal(t, *e.Node.Value, "y", "")
+	_, err = s.Get("/dir/old", false, false)
+	assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeKeyNotFound, "")
+}
+
+// Ensure that the store applies nothing if any txn compare fails.
+func TestStoreTxnFailsIfCompareNotMatch(t *testing.T) {
+	notExist := false
+	tests := []struct {
+		cmp   TxnCompare
+		wcode int
+	}{
+		{TxnCompare{Key: "/foo", PrevValue: "wrong_value"}, etcdErr.EcodeTestFailed},
+		{TxnCompare{Key: "/foo", PrevIndex: 100}, etcdErr.EcodeTestFailed},
+		{TxnCompare{Key: "/foo", PrevExist: &notExist}, etcdErr.EcodeNodeExist},
+		{TxnCompare{Key: "/nokey", PrevValue: "bar"}, etcdErr.EcodeKeyNotFound},
+		{TxnCompare{Key: "/dir", PrevValue: "bar"}, etcdErr.EcodeNotFile},
+	}
+	for i, tt := range tests {
+		s := newStore()
+		s.Create("/foo", false, "bar", false, Permanent)
+		s.Create("/dir", true, "", false, Permanent)
+		var eidx uint64 = 2
+
+		ops := []TxnOp{{Action: Set, Key: "/foo", Value: "baz"}, {Action: Set, Key: "/other", Value: "baz"}}
+		evs, err := s.Txn([]TxnCompare{tt.cmp}, ops)
+		if evs != nil {
+			t.Errorf("#%d: events = %v, want nil", i, evs)
+		}
+		if err == nil || err.(*etcdErr.Error).ErrorCode != tt.wcode {
+			t.Errorf("#%d: err = %v, want code %d", i, err, tt.wcode)
+		}
+		if s.Index() != eidx {
+			t.Errorf("#%d: index = %d, want %d", i, s.Index(), eidx)
+		}
+		e, _ := s.Get("/foo", false, false)
+		assert.Equal(t, *e.Node.Value, "bar", "")
+		_, err = s.Get("/other", false, false)
+		assert.NotNil(t, err, "")
+	}
+}
+
+// Ensure that the store applies nothing if any txn op would fail.
+func TestStoreTxnFailsIfOpInvalid(t *testing.T) {
+	set := TxnOp{Action: Set, Key: "/other", Value: "baz"}
+	tests := []struct {
+		ops   []TxnOp
+		wcode int
+	}{
+		{[]TxnOp{set, {Action: Delete, Key: "/nokey"}}, etcdErr.EcodeKeyNotFound},
+		{[]TxnOp{set, {Action: Delete, Key: "/dir"}}, etcdErr.EcodeNotFile},
+		{[]TxnOp{set, {Action: Delete, Key: "/dir", Dir: true}}, etcdErr.EcodeDirNotEmpty},
+		{[]TxnOp{set, {Action: Set, Key: "/dir", Value: "bar"}}, etcdErr.EcodeNotFile},
+		{[]TxnOp{set, {Action: Set, Key: "/dir/foo/bar", Value: "bar"}}, etcdErr.EcodeNotDir},
+		{[]TxnOp{set, {Action: Set, Key: "/", Value: "bar"}}, etcdErr.EcodeRootROnly},
+		{[]TxnOp{set, {Action: Update, Key: "/dir/foo", Value: "bar"}}, etcdErr.EcodeInvalidField},
+		// overlapping keys
+		{[]TxnOp{set, set}, etcdErr.EcodeInvalidField},
+		{[]TxnOp{{Action: Delete, Key: "/dir/foo"}, {Action: Delete, Key: "/dir", Recursive: true}}, etcdErr.EcodeInvalidField},
+		{nil, etcdErr.EcodeInvalidField},
+	}
+	for i, tt := range tests {
+		s := newStore()
+		s.Create("/dir/foo", false, "bar", false, Permanent)
+		var eidx uint64 = 1
+
+		_, err := s.Txn(nil, tt.ops)
+		if err == nil || err.(*etcdErr.Error).ErrorCode != tt.wcode {
+			t.Errorf("#%d: err = %v, want code %d", i, err, tt.wcode)
+		}
+		if s.Index() != eidx {
+			t.Errorf("#%d: index = %d, want %d", i, s.Index(), eidx)
+		}
+		e, _ := s.Get("/dir/foo", false, false)
+		assert.Equal(t, *e.Node.Value, "bar", "")
+		_, err = s.Get("/other", false, false)
+		assert.NotNil(t, err, "")
+	}
+}
+
+// Ensure that the watchers are notified with one event per txn op.
+func TestStoreWatchTxn(t *testing.T) {
+	s := newStore()
+	var eidx uint64 = 1
+	w, _ := s.Watch("/dir", true, true, 0)
+	ops := []TxnOp{
+		{Action: Set, Key: "/dir/a", Value: "1"},
+		{Action: Set, Key: "/dir/b", Value: "2"},
+	}
+	s.Txn(nil, ops)
+	for _, op := range ops {
+		e := nbselect(w.EventChan())
+		assert.NotNil(t, e, "")
+		assert.Equal(t, e.EtcdIndex, eidx, "")
+		assert.Equal(t, e.Node.Key, op.Key, "")
+	}
+
+	// the history keeps all the events of the txn at the same index
+	w, _ = s.Watch("/dir/b", false, false, eidx)
+	e := nbselect(w.EventChan())
+	assert.NotNil(t, e, "")
+	assert.Equal(t, e.Node.Key, "/dir/b", "")
+	assert.Equal(t, e.Index(), eidx, "")
+}
+
 // Ensure that the store can watch for recursive key creation.
 func TestStoreWatchRecursiveCreate(t *testing.T) {
 	s := newStore()
@@ -786,6 +1054,100 @@ func TestStoreWatchStream(t *testing.T) {
 	assert.Nil(t, e, "")
 }
 
+// Ensure that the store sends a stream watcher all the events since its index,
+// then keeps notifying it.
+func TestStoreWatchStreamFromIndex(t *testing.T) {
+	s := newStore()
+	s.Create("/foo/a", false, "bar", false, Permanent)
+	s.Create("/foo/b", false, "bar", false, Permanent)
+	s.Create("/foo/c", false, "bar", false, Permanent)
+	w, _ := s.Watch("/foo", true, true, 2)
+	assert.Equal(t, w.CurrentIndex(), uint64(3), "")
+	s.Create("/foo/d", false, "bar", false, Permanent)
+	for _, key := range []string{"/foo/b", "/foo/c", "/foo/d"} {
+		e := nbselect(w.EventChan())
+		assert.Equal(t, e.Node.Key, key, "")
+	}
+	e := nbselect(w.EventChan())
+	assert.Nil(t, e, "")
+	assert.Equal(t, w.CurrentIndex(), uint64(4), "")
+}
+
+// Ensure that the store only notifies filtered watchers of the events with the given actions.
+func TestStoreWatchFilterActions(t *testing.T) {
+	s := newStore()
+	w, _ := s.WatchFiltered("/foo", true, true, 0, WatchFilter{Actions: []string{"delete", "expire"}})
+	s.Create("/foo/bar", false, "baz", false, Permanent)
+	s.Set("/foo/bar", false, "qux", Permanent)
+	assert.Nil(t, nbselect(w.EventChan()), "")
+	s.Delete("/foo/bar", false, false)
+	e := nbselect(w.EventChan())
+	assert.Equal(t, e.Action, "delete", "")
+	assert.Equal(t, e.Node.Key, "/foo/bar", "")
+	assert.Equal(t, e.EtcdIndex, uint64(3), "")
+	assert.Nil(t, nbselect(w.EventChan()), "")
+}
+
+// Ensure that the store only notifies filtered watchers of the events on keys matching the glob.
+func TestStoreWatchFilterKeyGlob(t *testing.T) {
+	s := newStore()
+	w, _ := s.WatchFiltered("/foo", true, false, 0, WatchFilter{KeyGlob: "*/health"})
+	s.Create("/foo/a/status", false, "baz", false, Permanent)
+	s.Create("/foo/health", false, "baz", false, Permanent)
+	assert.Nil(t, nbselect(w.EventChan()), "")
+	s.Create("/foo/a/health", false, "baz", false, Permanent)
+	e := nbselect(w.EventChan())
+	assert.Equal(t, e.Action, "create", "")
+	assert.Equal(t, e.Node.Key, "/foo/a/health", "")
+	assert.Equal(t, e.EtcdIndex, uint64(3), "")
+
+	// the event history is filtered too
+	w, _ = s.WatchFiltered("/foo", true, false, 1, WatchFilter{KeyGlob: "a/*"})
+	e = nbselect(w.EventChan())
+	assert.Equal(t, e.Node.Key, "/foo/a/status", "")
+	w, _ = s.WatchFiltered("/foo", true, false, 2, WatchFilter{KeyGlob: "a/*"})
+	e = nbselect(w.EventChan())
+	assert.Equal(t, e.Node.Key, "/foo/a/health", "")
+}
+
+// Ensure that the store removes the previous node and values from the events of filtered watchers.
+func TestStoreWatchFilterOmit(t *testing.T) {
+	s := newStore()
+	s.Create("/foo", false, "bar", false, Permanent)
+	w, _ := s.WatchFiltered("/foo", false, false, 0, WatchFilter{NoPrevNode: true})
+	ww, _ := s.WatchFiltered("/foo", false, false, 0, WatchFilter{NoValue: true})
+	wall, _ := s.Watch("/foo", false, false, 0)
+	s.Update("/foo", "baz", Permanent)
+
+	e := nbselect(w.EventChan())
+	assert.Nil(t, e.PrevNode, "")
+	assert.Equal(t, *e.Node.Value, "baz", "")
+
+	e = nbselect(ww.EventChan())
+	assert.Nil(t, e.Node.Value, "")
+	assert.Nil(t, e.PrevNode.Value, "")
+	assert.Equal(t, e.PrevNode.Key, "/foo", "")
+
+	// the other watchers are not affected
+	e = nbselect(wall.EventChan())
+	assert.Equal(t, *e.Node.Value, "baz", "")
+	assert.Equal(t, *e.PrevNode.Value, "bar", "")
+}
+
+// Ensure that the store refuses watch filters which can never match.
+func TestStoreWatchFilterInvalid(t *testing.T) {
+	s := newStore()
+	tests := []WatchFilter{
+		{Actions: []string{"get"}},
+		{KeyGlob: "[a-"},
+	}
+	for i, f := range tests {
+		w, err := s.WatchFiltered("/foo", true, false, 0, f)
+		assert.Nil(t, w, fmt.Sprintf("#%d", i))
+		assert.Equal(t, err.(*etcdErr.Error).ErrorCode, etcdErr.EcodeInvalidField, fmt.Sprintf("#%d", i))
+	}
+}
+
 // Ensure that the store can recover from a previously saved state.
 func TestStoreRecover(t *testing.T) {
 	s := newStore()
@@ -0,0 +1,241 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	etcdErr "github.com/coreos/etcd/error"
+)
+
+// TxnCompare is a condition on a single key that must hold for a
+// transaction to be applied.
+// If PrevExist is set, the existence of the key is checked.
+// If PrevValue or PrevIndex is set, the key must be an existing file and
+// they are compared the same way CompareAndSwap does.
+type TxnCompare struct {
+	Key       string `json:"key"`
+	PrevValue string `json:"prevValue,omitempty"`
+	PrevIndex uint64 `json:"prevIndex,omitempty"`
+	PrevExist *bool  `json:"prevExist,omitempty"`
+}
+
+// TxnOp is a single write of a transaction. Action must be either Set or
+// Delete. Value, Dir and ExpireTime have the same meaning as in Set;
+// Dir and Recursive have the same meaning as in Delete.
+type TxnOp struct {
+	Action     string    `json:"action"`
+	Key        string    `json:"key"`
+	Value      string    `json:"value,omitempty"`
+	Dir        bool      `json:"dir,omitempty"`
+	Recursive  bool      `json:"recursive,omitempty"`
+	ExpireTime time.Time `json:"expireTime"`
+}
+
+// Txn checks all the given compares and, only if every one of them holds,
+// applies all the given ops atomically.
+// All ops are applied at the same index, and one event per op is returned
+// and delivered to the watchers in the order of the ops.
+// The keys of the ops must not overlap, that is, no key can be equal to or
+// under the key of another op in the same transaction.
+func (s *store) Txn(compares []TxnCompare, ops []TxnOp) ([]*Event, error) {
+	s.worldLock.Lock()
+	defer s.worldLock.Unlock()
+
+	events, err := s.internalTxn(compares, ops)
+	if err != nil {
+		s.Stats.Inc(TxnFail)
+		reportWriteFailure(Txn)
+		return nil, err
+	}
+
+	for _, e := range events {
+		s.WatcherHub.notify(e)
+	}
+
+	s.Stats.Inc(TxnSuccess)
+	reportWriteSuccess(Txn)
+
+	return events, nil
+}
+
+func (s *store) internalTxn(compares []TxnCompare, ops []TxnOp) ([]*Event, *etcdErr.Error) {
+	if len(ops) == 0 {
+		return nil, etcdErr.NewError(etcdErr.EcodeInvalidField, "txn: no operation given", s.CurrentIndex)
+	}
+
+	for _, c := range compares {
+		if err := s.checkTxnCompare(c); err != nil {
+			return nil, err
+		}
+	}
+
+	// validate all the ops before touching the tree, so that a failed
+	// transaction leaves no partial result behind.
+	keys := make([]string, len(ops))
+	for i, op := range ops {
+		keys[i] = path.Clean(path.Join("/", op.Key))
+		if err := s.checkTxnOp(keys[i], op); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkTxnKeys(keys); err != nil {
+		err.Index = s.CurrentIndex
+		return nil, err
+	}
+
+	currIndex, nextIndex := s.CurrentIndex, s.CurrentIndex+1
+
+	events := make([]*Event, len(ops))
+	for i, op := range ops {
+		switch op.Action {
+		case Set:
+			e, err := s.internalCreate(keys[i], op.Dir, op.Value, false, true, op.ExpireTime, Set)
+			// internalCreate moves the current index forward; rewind it so
+			// that every op of the transaction is applied at nextIndex.
+			s.CurrentIndex = currIndex
+			if err != nil {
+				return nil, err.(*etcdErr.Error)
+			}
+			events[i] = e
+		case Delete:
+			n, err := s.internalGet(keys[i])
+			if err != nil {
+				return nil, err
+			}
+
+			e := newEvent(Delete, keys[i], nextIndex, n.CreatedIndex)
+			e.PrevNode = n.Repr(false, false, s.clock)
+			if n.IsDir() {
+				e.Node.Dir = true
+			}
+
+			callback := func(path string) { // notify function
+				// notify the watchers with deleted set true
+				s.WatcherHub.notifyWatchers(e, path, true)
+			}
+
+			if err := n.Remove(op.Dir || op.Recursive, op.Recursive, callback); err != nil {
+				return nil, err
+			}
+			events[i] = e
+		}
+	}
+
+	s.CurrentIndex = nextIndex
+	for _, e := range events {
+		e.EtcdIndex = nextIndex
+	}
+
+	return events, nil
+}
+
+// checkTxnCompare returns an error if the given compare does not hold.
+func (s *store) checkTxnCompare(c TxnCompare) *etcdErr.Error {
+	nodePath := path.Clean(path.Join("/", c.Key))
+
+	n, err := s.internalGet(nodePath)
+	if err != nil && err.ErrorCode != etcdErr.EcodeKeyNotFound {
+		return err
+	}
+
+	if c.PrevExist != nil {
+		if *c.PrevExist && n == nil {
+			return err
+		}
+		if !*c.PrevExist && n != nil {
+			return etcdErr.NewError(etcdErr.EcodeNodeExist, nodePath, s.CurrentIndex)
+		}
+	}
+
+	if c.PrevValue == "" && c.PrevIndex == 0 {
+		return nil
+	}
+
+	if n == nil {
+		return err
+	}
+
+	if n.IsDir() { // can only compare file
+		return etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, s.CurrentIndex)
+	}
+
+	if ok, which := n.Compare(c.PrevValue, c.PrevIndex); !ok {
+		cause := nodePath + " " + getCompareFailCause(n, which, c.PrevValue, c.PrevIndex)
+		return etcdErr.NewError(etcdErr.EcodeTestFailed, cause, s.CurrentIndex)
+	}
+
+	return nil
+}
+
+// checkTxnOp returns the error the given op would fail with if it was
+// applied to the current tree.
+func (s *store) checkTxnOp(nodePath string, op TxnOp) *etcdErr.Error {
+	// we do not allow the user to change "/"
+	if s.readonlySet.Contains(nodePath) {
+		return etcdErr.NewError(etcdErr.EcodeRootROnly, "/", s.CurrentIndex)
+	}
+
+	// internalGet fails with EcodeNotDir if any parent of the node is a file
+	n, err := s.internalGet(nodePath)
+
+	switch op.Action {
+	case Set:
+		if err != nil {
+			if err.ErrorCode == etcdErr.EcodeKeyNotFound {
+				return nil
+			}
+			return err
+		}
+		if n.IsDir() {
+			return etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, s.CurrentIndex)
+		}
+	case Delete:
+		if err != nil {
+			return err
+		}
+		if n.IsDir() {
+			if !op.Dir && !op.Recursive {
+				return etcdErr.NewError(etcdErr.EcodeNotFile, nodePath, s.CurrentIndex)
+			}
+			if len(n.Children) != 0 && !op.Recursive {
+				return etcdErr.NewError(etcdErr.EcodeDirNotEmpty, nodePath, s.CurrentIndex)
+			}
+		}
+	default:
+		cause := fmt.Sprintf("txn: unsupported action %q on %s", op.Action, nodePath)
+		return etcdErr.NewError(etcdErr.EcodeInvalidField, cause, s.CurrentIndex)
+	}
+	return nil
+}
+
+// checkTxnKeys returns an error if any key is equal to or under another key.
+func checkTxnKeys(keys []string) *etcdErr.Error {
+	for i := range keys {
+		for j := range keys {
+			if i == j {
+				continue
+			}
+			if keys[i] == keys[j] || strings.HasPrefix(keys[i], keys[j]+"/") {
+				cause := fmt.Sprintf("txn: overlapping keys %s and %s", keys[j], keys[i])
+				return etcdErr.NewError(etcdErr.EcodeInvalidField, cause, 0)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"path"
+
+	etcdErr "github.com/coreos/etcd/error"
+)
+
+// WatchFilter limits the events a watcher is notified of, and the parts
+// of them which are sent. The zero value does not filter anything.
+type WatchFilter struct {
+	// Actions are the actions of the events to notify.
+	// If empty, events of any action are notified.
+	Actions []string
+	// KeyGlob is a pattern, in the syntax of path.Match, which the key of
+	// an event must match relative to the watched key. For example,
+	// "*/health" matches "/services/a/health" when watching "/services".
+	// If empty, events on any key are notified.
+	KeyGlob string
+	// NoPrevNode removes the previous node from the notified events.
+	NoPrevNode bool
+	// NoValue removes the values of the nodes from the notified events.
+	NoValue bool
+}
+
+// watchActions are the actions a watcher can be notified of.
+var watchActions = map[string]bool{
+	Create:           true,
+	Set:              true,
+	Update:           true,
+	Delete:           true,
+	CompareAndSwap:   true,
+	CompareAndDelete: true,
+	Expire:           true,
+}
+
+// validate returns an error if the filter can never match any event.
+func (f *WatchFilter) validate() *etcdErr.Error {
+	for _, a := range f.Actions {
+		if !watchActions[a] {
+			return etcdErr.NewError(etcdErr.EcodeInvalidField, fmt.Sprintf("unknown watch action %q", a), 0)
+		}
+	}
+	if _, err := path.Match(f.KeyGlob, ""); err != nil {
+		return etcdErr.NewError(etcdErr.EcodeInvalidField, fmt.Sprintf("bad key glob %q", f.KeyGlob), 0)
+	}
+	return nil
+}
+
+// match returns true if the given event passes the filter of a watcher
+// watching at key.
+// Events notified because an ancestor of the watched key was deleted are
+// matched regardless of their key, as they remove the matching keys too.
+func (f *WatchFilter) match(e *Event, key string, deleted bool) bool {
+	if len(f.Actions) != 0 {
+		found := false
+		for _, a := range f.Actions {
+			if a == e.Action {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.KeyGlob != "" && !deleted {
+		// the pattern is validated when the watcher is created
+		ok, _ := path.Match(path.Join(key, f.KeyGlob), e.Node.Key)
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// apply returns the given event with the parts removed by the filter.
+// The given event is shared with the event history and the other watchers,
+// so it is copied before being modified.
+func (f *WatchFilter) apply(e *Event) *Event {
+	if !f.NoPrevNode && !f.NoValue {
+		return e
+	}
+
+	ne := &Event{
+		Action:    e.Action,
+		Node:      e.Node,
+		PrevNode:  e.PrevNode,
+		EtcdIndex: e.EtcdIndex,
+	}
+	if f.NoPrevNode {
+		ne.PrevNode = nil
+	}
+	if f.NoValue {
+		ne.Node = removeValues(ne.Node.Clone())
+		ne.PrevNode = removeValues(ne.PrevNode.Clone())
+	}
+	return ne
+}
+
+// removeValues removes the values of the given node and of its children.
+func removeValues(n *NodeExtern) *NodeExtern {
+	if n == nil {
+		return nil
+	}
+	n.Value = nil
+	for _, child := range n.Nodes {
+		removeValues(child)
+	}
+	return n
+}
@@ -17,6 +17,9 @@ package store
 type Watcher interface {
 	EventChan() chan *Event
 	StartIndex() uint64 // The EtcdIndex at which the Watcher was created
+	// CurrentIndex returns the current EtcdIndex of the store. All the events
+	// up to it which the Watcher is interested in are already sent to EventChan.
+	CurrentIndex() uint64
 	Remove()
 }
 
@@ -24,11 +27,15 @@ type watcher struct {
 	eventChan  chan *Event
 	stream     bool
 	recursive  bool
+	key        string
+	filter     WatchFilter
 	sinceIndex uint64
 	startIndex uint64
 	hub        *watcherHub
 	removed    bool
 	remove     func()
+	// currentIndex returns the current index of the store
+	currentIndex func() uint64
 }
 
 func (w *watcher) EventChan() chan *Event {
@@ -39,6 +46,13 @@ func (w *watcher) StartIndex() uint64 {
 	return w.startIndex
 }
 
+func (w *watcher) CurrentIndex() uint64 {
+	if w.currentIndex == nil {
+		return w.startIndex
+	}
+	return w.currentIndex()
+}
+
 // notify function notifies the watcher. If the watcher interests in the given path,
 // the function will return true.
 func (w *watcher) notify(e *Event, originalPath bool, deleted bool) bool {
@@ -58,6 +72,12 @@ func (w *watcher) notify(e *Event, originalPath bool, deleted bool) bool {
 	// For example a watcher is watching at "/foo/bar". And we deletes "/foo". The watcher
 	// should get notified even if "/foo" is not the path it is watching.
 	if (w.recursive || originalPath || deleted) && e.Index() >= w.sinceIndex {
+		// the watcher is not interested in the events left out by its filter
+		if !w.filter.match(e, w.key, deleted) {
+			return false
+		}
+		e = w.filter.apply(e)
+
 		// We cannot block here if the eventChan capacity is full, otherwise
 		// etcd will hang. eventChan capacity is full when the rate of
 		// notifications are higher than our send rate.
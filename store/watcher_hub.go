@@ -56,9 +56,23 @@ func newWatchHub(capacity int) *watcherHub {
 // If recursive is true, the first change after index under key will be sent to the event channel of the watcher.
 // If recursive is false, the first change after index at key will be sent to the event channel of the watcher.
 // If index is zero, watch will start from the current index + 1.
-func (wh *watcherHub) watch(key string, recursive, stream bool, index, storeIndex uint64) (Watcher, *etcdErr.Error) {
+// Only the events which pass the given filter are sent.
+func (wh *watcherHub) watch(key string, recursive, stream bool, index, storeIndex uint64, filter WatchFilter) (*watcher, *etcdErr.Error) {
 	reportWatchRequest()
-	event, err := wh.EventHistory.scan(key, recursive, index)
+	if err := filter.validate(); err != nil {
+		err.Index = storeIndex
+		return nil, err
+	}
+
+	// a streaming watcher is sent all the known events after index, the
+	// others only the first one
+	n := 1
+	if stream {
+		n = 0
+	}
+	events, err := wh.EventHistory.scanN(key, recursive, index, func(e *Event) bool {
+		return filter.match(e, key, false)
+	}, n)
 
 	if err != nil {
 		err.Index = storeIndex
@@ -66,8 +80,10 @@ func (wh *watcherHub) watch(key string, recursive, stream bool, index, storeInde
 	}
 
 	w := &watcher{
-		eventChan:  make(chan *Event, 100), // use a buffered channel
+		eventChan:  make(chan *Event, 100+len(events)), // use a buffered channel
 		recursive:  recursive,
+		key:        key,
+		filter:     filter,
 		stream:     stream,
 		sinceIndex: index,
 		startIndex: storeIndex,
@@ -77,11 +93,16 @@ func (wh *watcherHub) watch(key string, recursive, stream bool, index, storeInde
 	wh.mutex.Lock()
 	defer wh.mutex.Unlock()
 	// If the event exists in the known history, append the EtcdIndex and return immediately
-	if event != nil {
+	if len(events) != 0 && !stream {
+		event := events[0]
 		event.EtcdIndex = storeIndex
-		w.eventChan <- event
+		w.eventChan <- filter.apply(event)
 		return w, nil
 	}
+	for _, event := range events {
+		event.EtcdIndex = storeIndex
+		w.eventChan <- filter.apply(event)
+	}
 
 	l, ok := wh.watchers[key]
 
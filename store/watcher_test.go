@@ -21,7 +21,7 @@ import (
 func TestWatcher(t *testing.T) {
 	s := newStore()
 	wh := s.WatcherHub
-	w, err := wh.watch("/foo", true, false, 1, 1)
+	w, err := wh.watch("/foo", true, false, 1, 1, WatchFilter{})
 	if err != nil {
 		t.Fatalf("%v", err)
 	}
@@ -44,7 +44,7 @@ func TestWatcher(t *testing.T) {
 		t.Fatal("recv != send")
 	}
 
-	w, _ = wh.watch("/foo", false, false, 2, 1)
+	w, _ = wh.watch("/foo", false, false, 2, 1, WatchFilter{})
 	c = w.EventChan()
 
 	e = newEvent(Create, "/foo/bar", 2, 2)
@@ -69,7 +69,7 @@ func TestWatcher(t *testing.T) {
 	}
 
 	// ensure we are doing exact matching rather than prefix matching
-	w, _ = wh.watch("/fo", true, false, 1, 1)
+	w, _ = wh.watch("/fo", true, false, 1, 1, WatchFilter{})
 	c = w.EventChan()
 
 	select {
@@ -0,0 +1,351 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/pbutil"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// entry is the decoded content of a log entry.
+type entry struct {
+	Term  uint64 `json:"term"`
+	Index uint64 `json:"index"`
+	// Type is norm, conf or conf2.
+	Type string `json:"type"`
+	// Method is the method of a v2 request, v3.range, v3.put,
+	// v3.delete_range or v3.txn for a v3 request, noop for an empty entry,
+	// and the comma separated types of the changes of a conf change.
+	Method string `json:"method,omitempty"`
+	// Keys are the keys the request is on.
+	Keys []string `json:"keys,omitempty"`
+
+	V2           *etcdserverpb.Request             `json:"v2,omitempty"`
+	V3           *etcdserverpb.InternalRaftRequest `json:"v3,omitempty"`
+	ConfChange   *raftpb.ConfChange                `json:"confChange,omitempty"`
+	ConfChangeV2 *raftpb.ConfChangeV2              `json:"confChangeV2,omitempty"`
+	// Err is set if the entry cannot be decoded.
+	Err string `json:"error,omitempty"`
+}
+
+func decodeEntry(e raftpb.Entry) *entry {
+	ent := &entry{Term: e.Term, Index: e.Index}
+	switch e.Type {
+	case raftpb.EntryNormal:
+		ent.Type = "norm"
+		decodeNormal(ent, e.Data)
+	case raftpb.EntryConfChange:
+		ent.Type = "conf"
+		var cc raftpb.ConfChange
+		if err := cc.Unmarshal(e.Data); err != nil {
+			ent.Err = err.Error()
+			break
+		}
+		ent.ConfChange = &cc
+		ent.Method = cc.Type.String()
+	case raftpb.EntryConfChangeV2:
+		ent.Type = "conf2"
+		var cc raftpb.ConfChangeV2
+		if err := cc.Unmarshal(e.Data); err != nil {
+			ent.Err = err.Error()
+			break
+		}
+		ent.ConfChangeV2 = &cc
+		ms := make([]string, len(cc.Changes))
+		for i, c := range cc.Changes {
+			ms[i] = c.Type.String()
+		}
+		ent.Method = strings.Join(ms, ",")
+	default:
+		ent.Type = fmt.Sprintf("unknown(%d)", e.Type)
+	}
+	return ent
+}
+
+func decodeNormal(ent *entry, data []byte) {
+	// raft appends an empty entry when a leader is elected.
+	if len(data) == 0 {
+		ent.Method = "noop"
+		return
+	}
+	var raftReq etcdserverpb.InternalRaftRequest
+	if !pbutil.MaybeUnmarshal(&raftReq, data) { // backward compatible
+		var r etcdserverpb.Request
+		if err := r.Unmarshal(data); err != nil {
+			ent.Err = err.Error()
+			return
+		}
+		raftReq = etcdserverpb.InternalRaftRequest{V2: &r}
+	}
+
+	switch {
+	case raftReq.V2 != nil:
+		r := raftReq.V2
+		ent.V2 = r
+		ent.Method = r.Method
+		if r.Method == "" {
+			ent.Method = "noop"
+		}
+		if r.Path != "" {
+			ent.Keys = []string{r.Path}
+		}
+		return
+	case raftReq.Range != nil:
+		ent.Method = "v3.range"
+		ent.Keys = []string{string(raftReq.Range.Key)}
+	case raftReq.Put != nil:
+		ent.Method = "v3.put"
+		ent.Keys = []string{string(raftReq.Put.Key)}
+	case raftReq.DeleteRange != nil:
+		ent.Method = "v3.delete_range"
+		ent.Keys = []string{string(raftReq.DeleteRange.Key)}
+	case raftReq.Txn != nil:
+		ent.Method = "v3.txn"
+		for _, c := range raftReq.Txn.Compare {
+			ent.Keys = append(ent.Keys, string(c.Key))
+		}
+		for _, u := range append(raftReq.Txn.Success, raftReq.Txn.Failure...) {
+			if k, ok := unionKey(u); ok {
+				ent.Keys = append(ent.Keys, k)
+			}
+		}
+	default:
+		ent.Err = "empty internal raft request"
+		return
+	}
+	ent.V3 = &raftReq
+}
+
+func unionKey(u *etcdserverpb.RequestUnion) (string, bool) {
+	switch {
+	case u.RequestRange != nil:
+		return string(u.RequestRange.Key), true
+	case u.RequestPut != nil:
+		return string(u.RequestPut.Key), true
+	case u.RequestDeleteRange != nil:
+		return string(u.RequestDeleteRange.Key), true
+	}
+	return "", false
+}
+
+// entryFilter selects the entries to dump. A zero filter selects all.
+type entryFilter struct {
+	types     []string
+	methods   []string
+	keyPrefix string
+	// endIndex is the index of the last entry to dump, or 0 for no limit.
+	endIndex uint64
+}
+
+func newEntryFilter(types, methods, keyPrefix string, endIndex uint64) *entryFilter {
+	return &entryFilter{
+		types:     splitList(types),
+		methods:   splitList(methods),
+		keyPrefix: keyPrefix,
+		endIndex:  endIndex,
+	}
+}
+
+func (f *entryFilter) match(ent *entry) bool {
+	if f.endIndex != 0 && ent.Index > f.endIndex {
+		return false
+	}
+	if len(f.types) != 0 && !containsFold(f.types, ent.Type) {
+		return false
+	}
+	if len(f.methods) != 0 {
+		found := false
+		for _, m := range strings.Split(ent.Method, ",") {
+			if containsFold(f.methods, m) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.keyPrefix != "" {
+		found := false
+		for _, k := range ent.Keys {
+			if strings.HasPrefix(k, f.keyPrefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+func containsFold(l []string, s string) bool {
+	for _, v := range l {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe returns the decoded content of the entry in a single line.
+func (ent *entry) describe() string {
+	msg := fmt.Sprintf("%4d\t%10d\t%s", ent.Term, ent.Index, ent.Type)
+	switch {
+	case ent.Err != "":
+		return fmt.Sprintf("%s\t???", msg)
+	case ent.V2 != nil && ent.Method != "noop":
+		return fmt.Sprintf("%s\t%s", msg, describeV2(ent.V2))
+	case ent.V3 != nil:
+		return fmt.Sprintf("%s\t%s", msg, describeV3(ent.V3))
+	case ent.ConfChange != nil:
+		return fmt.Sprintf("%s\tmethod=%s id=%s", msg, ent.Method, types.ID(ent.ConfChange.NodeID))
+	case ent.ConfChangeV2 != nil:
+		cs := make([]string, len(ent.ConfChangeV2.Changes))
+		for i, c := range ent.ConfChangeV2.Changes {
+			cs[i] = fmt.Sprintf("%s:%s", c.Type, types.ID(c.NodeID))
+		}
+		return fmt.Sprintf("%s\ttransition=%s changes=%s", msg, ent.ConfChangeV2.Transition, strings.Join(cs, ","))
+	}
+	return fmt.Sprintf("%s\t%s", msg, ent.Method)
+}
+
+func describeV2(r *etcdserverpb.Request) string {
+	switch r.Method {
+	case "SYNC":
+		return fmt.Sprintf("method=SYNC time=%q", time.Unix(0, r.Time))
+	case "QGET", "GET":
+		return fmt.Sprintf("method=%s path=%s recursive=%t sorted=%t quorum=%t", r.Method, excerpt(r.Path, 64, 64), r.Recursive, r.Sorted, r.Quorum)
+	}
+
+	msg := fmt.Sprintf("method=%s path=%s", r.Method, excerpt(r.Path, 64, 64))
+	if r.Method != "DELETE" {
+		msg = fmt.Sprintf("%s val=%s", msg, excerpt(r.Val, 128, 0))
+	}
+	if r.Dir {
+		msg += " dir=true"
+	}
+	if r.Recursive {
+		msg += " recursive=true"
+	}
+	if r.PrevValue != "" {
+		msg = fmt.Sprintf("%s prevValue=%s", msg, excerpt(r.PrevValue, 64, 0))
+	}
+	if r.PrevIndex != 0 {
+		msg = fmt.Sprintf("%s prevIndex=%d", msg, r.PrevIndex)
+	}
+	if r.PrevExist != nil {
+		msg = fmt.Sprintf("%s prevExist=%t", msg, *r.PrevExist)
+	}
+	if r.Refresh != nil {
+		msg = fmt.Sprintf("%s refresh=%t", msg, *r.Refresh)
+	}
+	if r.Expiration != 0 {
+		msg = fmt.Sprintf("%s expiration=%q", msg, time.Unix(0, r.Expiration))
+	}
+	return msg
+}
+
+func describeV3(r *etcdserverpb.InternalRaftRequest) string {
+	switch {
+	case r.Range != nil:
+		return "v3.range " + describeRange(r.Range)
+	case r.Put != nil:
+		return "v3.put " + describePut(r.Put)
+	case r.DeleteRange != nil:
+		return "v3.delete_range " + describeDeleteRange(r.DeleteRange)
+	case r.Txn != nil:
+		cs := make([]string, len(r.Txn.Compare))
+		for i, c := range r.Txn.Compare {
+			cs[i] = fmt.Sprintf("%s(%s) %s %s", c.Target, excerpt(string(c.Key), 64, 64), c.Result, compareValue(c))
+		}
+		return fmt.Sprintf("v3.txn compare=[%s] success=[%s] failure=[%s]",
+			strings.Join(cs, ", "), describeUnions(r.Txn.Success), describeUnions(r.Txn.Failure))
+	}
+	return "???"
+}
+
+func describeRange(r *etcdserverpb.RangeRequest) string {
+	msg := fmt.Sprintf("key=%s", excerpt(string(r.Key), 64, 64))
+	if len(r.RangeEnd) != 0 {
+		msg = fmt.Sprintf("%s range_end=%s", msg, excerpt(string(r.RangeEnd), 64, 64))
+	}
+	if r.Limit != 0 {
+		msg = fmt.Sprintf("%s limit=%d", msg, r.Limit)
+	}
+	if r.Revision != 0 {
+		msg = fmt.Sprintf("%s revision=%d", msg, r.Revision)
+	}
+	return msg
+}
+
+func describePut(r *etcdserverpb.PutRequest) string {
+	return fmt.Sprintf("key=%s val=%s", excerpt(string(r.Key), 64, 64), excerpt(string(r.Value), 128, 0))
+}
+
+func describeDeleteRange(r *etcdserverpb.DeleteRangeRequest) string {
+	msg := fmt.Sprintf("key=%s", excerpt(string(r.Key), 64, 64))
+	if len(r.RangeEnd) != 0 {
+		msg = fmt.Sprintf("%s range_end=%s", msg, excerpt(string(r.RangeEnd), 64, 64))
+	}
+	return msg
+}
+
+func describeUnions(us []*etcdserverpb.RequestUnion) string {
+	ds := make([]string, 0, len(us))
+	for _, u := range us {
+		switch {
+		case u.RequestRange != nil:
+			ds = append(ds, "range "+describeRange(u.RequestRange))
+		case u.RequestPut != nil:
+			ds = append(ds, "put "+describePut(u.RequestPut))
+		case u.RequestDeleteRange != nil:
+			ds = append(ds, "delete_range "+describeDeleteRange(u.RequestDeleteRange))
+		}
+	}
+	return strings.Join(ds, ", ")
+}
+
+func compareValue(c *etcdserverpb.Compare) string {
+	switch c.Target {
+	case etcdserverpb.Compare_VERSION:
+		return fmt.Sprint(c.Version)
+	case etcdserverpb.Compare_CREATE:
+		return fmt.Sprint(c.CreateRevision)
+	case etcdserverpb.Compare_MOD:
+		return fmt.Sprint(c.ModRevision)
+	}
+	return excerpt(string(c.Value), 64, 0)
+}
@@ -15,25 +15,41 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"path"
-	"time"
 
 	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/pkg/pbutil"
 	"github.com/coreos/etcd/pkg/types"
 	"github.com/coreos/etcd/raft/raftpb"
 	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/store"
 	"github.com/coreos/etcd/wal"
 	"github.com/coreos/etcd/wal/walpb"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		verifyMain(os.Args[2:])
+		return
+	}
+
 	from := flag.String("data-dir", "", "")
 	snapfile := flag.String("start-snap", "", "The base name of snapshot file to start dumping")
 	index := flag.Uint64("start-index", 0, "The index to start dumping")
+	endIndex := flag.Uint64("end-index", 0, "The index to stop dumping at, included (0 for no limit)")
+	entryType := flag.String("entry-type", "", "Comma separated types of the entries to dump: norm, conf or conf2")
+	method := flag.String("method", "", "Comma separated methods of the entries to dump, e.g. PUT,DELETE,v3.put,noop,ConfChangeAddNode")
+	keyPrefix := flag.String("key-prefix", "", "Only dump the entries with a request on a key with the given prefix")
+	snapTree := flag.Bool("snap-tree", false, "Print the v2 store tree of the snapshot instead of the log entries")
+	asJSON := flag.Bool("json", false, "Print the entries, or the nodes of the tree, as one JSON object per line")
+	kpSpec := flag.String("encryption-key-provider", "", "The key provider decrypting an encrypted data directory, e.g. file:/path/to/key")
 	flag.Parse()
 	if *from == "" {
 		log.Fatal("Must provide -data-dir flag.")
@@ -41,6 +57,16 @@ func main() {
 	if *snapfile != "" && *index != 0 {
 		log.Fatal("start-snap and start-index flags cannot be used together.")
 	}
+	if *snapTree && *index != 0 {
+		log.Fatal("snap-tree and start-index flags cannot be used together.")
+	}
+	kp := keyProvider(*kpSpec)
+
+	// keep the standard output for the JSON objects.
+	info := io.Writer(os.Stdout)
+	if *asJSON {
+		info = os.Stderr
+	}
 
 	var (
 		walsnap  walpb.Snapshot
@@ -51,31 +77,39 @@ func main() {
 	isIndex := *index != 0
 
 	if isIndex {
-		fmt.Printf("Start dumping log entries from index %d.\n", *index)
+		fmt.Fprintf(info, "Start dumping log entries from index %d.\n", *index)
 		walsnap.Index = *index
 	} else {
 		if *snapfile == "" {
-			ss := snap.New(snapDir(*from))
-			snapshot, err = ss.Load()
+			ss := snap.NewWithKeyProvider(snapDir(*from), kp)
+			snapshot, err = ss.LoadChain(store.IsDeltaSnapshot)
 		} else {
-			snapshot, err = snap.Read(path.Join(snapDir(*from), *snapfile))
+			snapshot, err = snap.ReadWithKeyProvider(path.Join(snapDir(*from), *snapfile), kp)
 		}
 
 		switch err {
 		case nil:
 			walsnap.Index, walsnap.Term = snapshot.Metadata.Index, snapshot.Metadata.Term
 			nodes := genIDSlice(snapshot.Metadata.ConfState.Nodes)
-			fmt.Printf("Snapshot:\nterm=%d index=%d nodes=%s\n",
+			fmt.Fprintf(info, "Snapshot:\nterm=%d index=%d nodes=%s\n",
 				walsnap.Term, walsnap.Index, nodes)
 		case snap.ErrNoSnapshot:
-			fmt.Printf("Snapshot:\nempty\n")
+			fmt.Fprintf(info, "Snapshot:\nempty\n")
 		default:
 			log.Fatalf("Failed loading snapshot: %v", err)
 		}
-		fmt.Println("Start dupmping log entries from snapshot.")
+
+		if *snapTree {
+			if snapshot == nil {
+				log.Fatal("No snapshot to print the tree of.")
+			}
+			printSnapshotTree(snapshot.Data, *asJSON)
+			return
+		}
+		fmt.Fprintln(info, "Start dumping log entries from snapshot.")
 	}
 
-	w, err := wal.Open(walDir(*from), walsnap)
+	w, err := wal.OpenWithOptions(walDir(*from), walsnap, wal.Options{KeyProvider: kp})
 	if err != nil {
 		log.Fatalf("Failed opening WAL: %v", err)
 	}
@@ -86,43 +120,89 @@ func main() {
 	}
 	id, cid := parseWALMetadata(wmetadata)
 	vid := types.ID(state.Vote)
-	fmt.Printf("WAL metadata:\nnodeID=%s clusterID=%s term=%d commitIndex=%d vote=%s\n",
+	fmt.Fprintf(info, "WAL metadata:\nnodeID=%s clusterID=%s term=%d commitIndex=%d vote=%s\n",
 		id, cid, state.Term, state.Commit, vid)
 
-	fmt.Printf("WAL entries:\n")
-	fmt.Printf("lastIndex=%d\n", ents[len(ents)-1].Index)
-	fmt.Printf("%4s\t%10s\ttype\tdata\n", "term", "index")
+	fmt.Fprintf(info, "WAL entries:\n")
+	if len(ents) != 0 {
+		fmt.Fprintf(info, "lastIndex=%d\n", ents[len(ents)-1].Index)
+	}
+	if !*asJSON {
+		fmt.Printf("%4s\t%10s\ttype\tdata\n", "term", "index")
+	}
+	f := newEntryFilter(*entryType, *method, *keyPrefix, *endIndex)
+	enc := json.NewEncoder(os.Stdout)
 	for _, e := range ents {
-		msg := fmt.Sprintf("%4d\t%10d", e.Term, e.Index)
-		switch e.Type {
-		case raftpb.EntryNormal:
-			msg = fmt.Sprintf("%s\tnorm", msg)
-			var r etcdserverpb.Request
-			if err := r.Unmarshal(e.Data); err != nil {
-				msg = fmt.Sprintf("%s\t???", msg)
-				break
+		ent := decodeEntry(e)
+		if !f.match(ent) {
+			continue
+		}
+		if *asJSON {
+			if err := enc.Encode(ent); err != nil {
+				log.Fatalf("Failed encoding entry %d: %v", e.Index, err)
 			}
-			switch r.Method {
-			case "":
-				msg = fmt.Sprintf("%s\tnoop", msg)
-			case "SYNC":
-				msg = fmt.Sprintf("%s\tmethod=SYNC time=%q", msg, time.Unix(0, r.Time))
-			case "QGET", "DELETE":
-				msg = fmt.Sprintf("%s\tmethod=%s path=%s", msg, r.Method, excerpt(r.Path, 64, 64))
+			continue
+		}
+		fmt.Println(ent.describe())
+	}
+}
+
+// printSnapshotTree prints the nodes of the v2 store saved in the given
+// snapshot data, sorted by key.
+func printSnapshotTree(data []byte, asJSON bool) {
+	st := store.New()
+	if err := st.Recovery(data); err != nil {
+		log.Fatalf("Failed recovering store from snapshot: %v", err)
+	}
+	ev, err := st.Get("/", true, true)
+	if err != nil {
+		log.Fatalf("Failed reading store: %v", err)
+	}
+
+	if !asJSON {
+		fmt.Printf("%10s\t%10s\tkey\tvalue\n", "created", "modified")
+	}
+	enc := json.NewEncoder(os.Stdout)
+	var walk func(n *store.NodeExtern)
+	walk = func(n *store.NodeExtern) {
+		for _, c := range n.Nodes {
+			switch {
+			case asJSON:
+				// the children are printed on their own lines.
+				cn := *c
+				cn.Nodes = nil
+				if err := enc.Encode(&cn); err != nil {
+					log.Fatalf("Failed encoding node %s: %v", c.Key, err)
+				}
 			default:
-				msg = fmt.Sprintf("%s\tmethod=%s path=%s val=%s", msg, r.Method, excerpt(r.Path, 64, 64), excerpt(r.Val, 128, 0))
-			}
-		case raftpb.EntryConfChange:
-			msg = fmt.Sprintf("%s\tconf", msg)
-			var r raftpb.ConfChange
-			if err := r.Unmarshal(e.Data); err != nil {
-				msg = fmt.Sprintf("%s\t???", msg)
-			} else {
-				msg = fmt.Sprintf("%s\tmethod=%s id=%s", msg, r.Type, types.ID(r.NodeID))
+				msg := fmt.Sprintf("%10d\t%10d\t%s", c.CreatedIndex, c.ModifiedIndex, excerpt(c.Key, 64, 64))
+				if c.Dir {
+					msg += "\tdir"
+				} else if c.Value != nil {
+					msg = fmt.Sprintf("%s\t%s", msg, excerpt(*c.Value, 128, 0))
+				}
+				if c.Expiration != nil {
+					msg = fmt.Sprintf("%s\texpiration=%q", msg, *c.Expiration)
+				}
+				fmt.Println(msg)
 			}
+			walk(c)
 		}
-		fmt.Println(msg)
 	}
+	walk(ev.Node)
+}
+
+// keyProvider returns the encryption.KeyProvider of the given spec, or nil
+// if it is empty.
+func keyProvider(spec string) encryption.KeyProvider {
+	if spec == "" {
+		return nil
+	}
+	kp, err := encryption.NewKeyProvider(spec)
+	if err != nil {
+		log.Fatalf("Failed loading key provider: %v", err)
+	}
+	return kp
 }
 
 func walDir(dataDir string) string { return path.Join(dataDir, "member", "wal") }
@@ -0,0 +1,211 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/Godeps/_workspace/src/github.com/boltdb/bolt"
+	"github.com/coreos/etcd/pkg/fileutil"
+	"github.com/coreos/etcd/pkg/types"
+	"github.com/coreos/etcd/snap"
+	"github.com/coreos/etcd/wal"
+)
+
+// verifyMain checks the WAL files, the snapshot files and the v3 database
+// files of a data directory, without modifying them, and exits with
+// status 1 if any problem is found.
+func verifyMain(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	from := fs.String("data-dir", "", "")
+	repairDir := fs.String("repair-dir", "", "The directory to write a copy of the WAL truncated before a torn write at its tail into")
+	forceRepair := fs.Bool("force-repair", false, "Truncate the copy of the WAL before its first problem, wherever it is. The member must then be removed and re-added")
+	kpSpec := fs.String("encryption-key-provider", "", "The key provider decrypting an encrypted data directory, e.g. file:/path/to/key")
+	fs.Parse(args)
+	if *from == "" {
+		fmt.Fprintln(os.Stderr, "Must provide -data-dir flag.")
+		os.Exit(2)
+	}
+	kp := keyProvider(*kpSpec)
+
+	ok := true
+	r, err := wal.Verify(walDir(*from), kp)
+	if r != nil {
+		printVerifyResult(r)
+	}
+	if err != nil {
+		fmt.Printf("WAL problem: %v\n", err)
+		ok = false
+	}
+
+	snapi, err := verifySnapshots(snapDir(*from), r)
+	if err != nil {
+		fmt.Printf("Snapshot problem: %v\n", err)
+		ok = false
+	}
+
+	for _, p := range dbFiles(*from) {
+		if err := verifyDB(p); err != nil {
+			fmt.Printf("Database problem: %s: %v\n", p, err)
+			ok = false
+		}
+	}
+
+	if *repairDir != "" {
+		rr, err := wal.RepairTo(walDir(*from), *repairDir, kp, *forceRepair)
+		if err == wal.ErrNotTornTail {
+			fmt.Printf("Refused repairing WAL: %v. Use -force-repair to truncate it anyway.\n", err)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Printf("Failed repairing WAL: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote a repaired copy of the WAL into %s.\n", *repairDir)
+		if *forceRepair && !ok {
+			fmt.Printf("Warning: entries the member acknowledged may be lost. Do not restart it with the repaired WAL: remove it from the cluster and add it back with a fresh data directory.\n")
+		}
+		if snapi > rr.LastIndex && !hasWALSnapshot(rr, snapi) {
+			fmt.Printf("Warning: the newest snapshot at index %d is ahead of the repaired WAL, which ends at index %d.\n", snapi, rr.LastIndex)
+		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
+
+func printVerifyResult(r *wal.VerifyResult) {
+	fmt.Printf("WAL files:\n")
+	for _, name := range r.Files {
+		fmt.Println(name)
+	}
+	if r.Metadata != nil {
+		id, cid := parseWALMetadata(r.Metadata)
+		fmt.Printf("WAL metadata:\nnodeID=%s clusterID=%s term=%d commitIndex=%d vote=%s\n",
+			id, cid, r.State.Term, r.State.Commit, types.ID(r.State.Vote))
+	}
+	fmt.Printf("WAL snapshot records:\n")
+	for _, s := range r.Snapshots {
+		fmt.Printf("term=%d index=%d\n", s.Term, s.Index)
+	}
+	fmt.Printf("WAL entries:\ncount=%d lastIndex=%d\n", r.Entries, r.LastIndex)
+	if r.Sealed {
+		fmt.Printf("Some WAL files are encrypted: only the crc of their records was checked.\n")
+	}
+}
+
+// verifySnapshots reads every snapshot file in dir, and checks that the
+// newest one matches a snapshot record of the WAL described by r, if any.
+// It returns the index of the newest snapshot.
+func verifySnapshots(dir string, r *wal.VerifyResult) (uint64, error) {
+	names, err := fileutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var snaps []string
+	for _, name := range names {
+		if strings.HasSuffix(name, ".snap") {
+			snaps = append(snaps, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(snaps)))
+
+	fmt.Printf("Snapshot files:\n")
+	var index uint64
+	for i, name := range snaps {
+		s, err := snap.Read(path.Join(dir, name))
+		if err != nil {
+			return index, fmt.Errorf("%s: %v", name, err)
+		}
+		fmt.Printf("%s term=%d index=%d\n", name, s.Metadata.Term, s.Metadata.Index)
+		if i != 0 {
+			continue
+		}
+		index = s.Metadata.Index
+		if r == nil {
+			continue
+		}
+		if !hasWALSnapshot(r, index) {
+			return index, fmt.Errorf("%s: no WAL snapshot record at index %d", name, index)
+		}
+		for _, ws := range r.Snapshots {
+			if ws.Index == index && ws.Term != s.Metadata.Term {
+				return index, fmt.Errorf("%s: term %d does not match the WAL snapshot record term %d", name, s.Metadata.Term, ws.Term)
+			}
+		}
+	}
+	return index, nil
+}
+
+func hasWALSnapshot(r *wal.VerifyResult, index uint64) bool {
+	for _, s := range r.Snapshots {
+		if s.Index == index {
+			return true
+		}
+	}
+	return false
+}
+
+// dbFiles returns the v3 database files of the given data directory: the
+// database itself and the ones received along snapshots.
+func dbFiles(dataDir string) []string {
+	var ps []string
+	if p := path.Join(dataDir, "member", "v3demo"); isFile(p) {
+		ps = append(ps, p)
+	}
+	names, _ := fileutil.ReadDir(snapDir(dataDir))
+	for _, name := range names {
+		if strings.HasSuffix(name, ".snap.db") {
+			ps = append(ps, path.Join(snapDir(dataDir), name))
+		}
+	}
+	return ps
+}
+
+// verifyDB runs the consistency checks of bolt on the database at p,
+// opened read-only.
+func verifyDB(p string) error {
+	db, err := bolt.Open(p, 0400, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		var first error
+		// drain the channel to let the check finish.
+		for err := range tx.Check() {
+			if first == nil {
+				first = err
+			}
+		}
+		return first
+	})
+}
+
+func isFile(p string) bool {
+	fi, err := os.Stat(p)
+	return err == nil && fi.Mode().IsRegular()
+}
@@ -22,6 +22,7 @@ import (
 	"sync"
 
 	"github.com/coreos/etcd/pkg/crc"
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/pkg/pbutil"
 	"github.com/coreos/etcd/raft/raftpb"
 	"github.com/coreos/etcd/wal/walpb"
@@ -33,6 +34,19 @@ type decoder struct {
 
 	c   io.Closer
 	crc hash.Hash32
+
+	// off is the offset of the next record in the decoded stream,
+	// and lastOff the offset of the last decoded one.
+	off, lastOff int64
+	// prevCRC is the crc of the records before the last decoded one, which
+	// its own crc is chained to.
+	prevCRC uint32
+
+	// kp, if not nil, unwraps the data keys of the encrypted files, and
+	// dk is the data key of the file being decoded, if it is encrypted.
+	// Without kp, the records of the encrypted files are left sealed.
+	kp encryption.KeyProvider
+	dk *encryption.DataKey
 }
 
 func newDecoder(rc io.ReadCloser) *decoder {
@@ -64,12 +78,44 @@ func (d *decoder) decode(rec *walpb.Record) error {
 	if err := rec.Unmarshal(data); err != nil {
 		return err
 	}
-	// skip crc checking if the record type is crcType
-	if rec.Type == crcType {
+	d.lastOff = d.off
+	d.off += 8 + l
+	switch rec.Type {
+	case crcType:
+		// skip crc checking if the record type is crcType. It starts a
+		// new file, which is not encrypted unless it says so.
+		d.dk = nil
 		return nil
+	case encryptionType:
+		if d.kp == nil {
+			return nil
+		}
+		h, _, err := encryption.UnmarshalHeader(rec.Data)
+		if err != nil {
+			return err
+		}
+		d.dk, err = h.DataKey(d.kp)
+		return err
 	}
+	d.prevCRC = d.crc.Sum32()
 	d.crc.Write(rec.Data)
-	return rec.Validate(d.crc.Sum32())
+	if err := rec.Validate(d.crc.Sum32()); err != nil {
+		return err
+	}
+	if d.dk != nil {
+		data, err := d.dk.Open(rec.Data, recordAD(rec.Type))
+		if err != nil {
+			return err
+		}
+		rec.Data = data
+	}
+	return nil
+}
+
+// recordAD returns the additional data authenticated along the data of a
+// sealed record, so that the records cannot be swapped between types.
+func recordAD(t int64) []byte {
+	return []byte{byte(t)}
 }
 
 func (d *decoder) updateCRC(prevCrc uint32) {
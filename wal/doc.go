@@ -64,5 +64,15 @@ snapshot to the end of the WAL are read first:
 This will give you the metadata, the last raft.State and the slice of
 raft.Entry items in the log.
 
+Instead of holding all the entries in memory, a WAL can be read out into a
+Storage, which implements raft.Storage by reading the entries lazily out of
+the WAL files:
+
+	metadata, s, err := w.ReadStorage(snapshot, cacheSize)
+
+A Storage keeps the position of each entry in the WAL files, and caches the
+most recently used entries. The entries saved to the WAL afterwards are added
+to the Storage. NewStorage returns the Storage of a newly created WAL.
+
 */
 package wal
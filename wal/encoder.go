@@ -22,6 +22,7 @@ import (
 	"sync"
 
 	"github.com/coreos/etcd/pkg/crc"
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/wal/walpb"
 )
 
@@ -32,6 +33,13 @@ type encoder struct {
 	crc       hash.Hash32
 	buf       []byte
 	uint64buf []byte
+
+	// off is the offset in the underlying file at which
+	// the next record is written.
+	off int64
+	// dk, if not nil, seals the data of the records but the crc and
+	// encryption ones.
+	dk *encryption.DataKey
 }
 
 func newEncoder(w io.Writer, prevCrc uint32) *encoder {
@@ -48,8 +56,18 @@ func (e *encoder) encode(rec *walpb.Record) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	e.crc.Write(rec.Data)
-	rec.Crc = e.crc.Sum32()
+	switch {
+	case rec.Type == encryptionType:
+		// the header is authenticated by the unwrapping of its key, and
+		// is kept out of the crc chain so that rotating the key-encryption
+		// key only rewrites it.
+	case rec.Type != crcType && e.dk != nil:
+		rec.Data = e.dk.Seal(rec.Data, recordAD(rec.Type))
+		fallthrough
+	default:
+		e.crc.Write(rec.Data)
+		rec.Crc = e.crc.Sum32()
+	}
 	var (
 		data []byte
 		err  error
@@ -71,8 +89,11 @@ func (e *encoder) encode(rec *walpb.Record) error {
 	if err := writeInt64(e.bw, int64(len(data)), e.uint64buf); err != nil {
 		return err
 	}
-	_, err = e.bw.Write(data)
-	return err
+	if _, err = e.bw.Write(data); err != nil {
+		return err
+	}
+	e.off += 8 + int64(len(data))
+	return nil
 }
 
 func (e *encoder) flush() error {
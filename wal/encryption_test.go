@@ -0,0 +1,184 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/pkg/encryption"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+func newTestKeyProvider(t *testing.T, dir, name string) encryption.KeyProvider {
+	key, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.Join(dir, name)
+	if err := ioutil.WriteFile(p, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	kp, err := encryption.NewFileKeyProvider(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+func TestEncryptedWAL(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kp := newTestKeyProvider(t, dir, "key")
+	p := path.Join(dir, "wal")
+
+	w, err := CreateWithOptions(p, []byte("metadata"), Options{KeyProvider: kp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wents []raftpb.Entry
+	for i := 1; i <= 6; i++ {
+		e := raftpb.Entry{Index: uint64(i), Term: 1, Data: []byte("secretdata")}
+		if err = w.Save(raftpb.HardState{Term: 1, Commit: uint64(i)}, []raftpb.Entry{e}); err != nil {
+			t.Fatal(err)
+		}
+		wents = append(wents, e)
+		if i == 3 {
+			if err = w.cut(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	w.Close()
+
+	for _, name := range []string{walName(0, 0), walName(1, 4)} {
+		b, err := ioutil.ReadFile(path.Join(p, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(b, []byte("secretdata")) || bytes.Contains(b, []byte("metadata")) {
+			t.Errorf("%s holds plaintext", name)
+		}
+	}
+
+	// the records cannot be read without the key provider.
+	w, err = Open(p, walpb.Snapshot{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = w.ReadAll(); err != ErrEncrypted {
+		t.Errorf("err = %v, want %v", err, ErrEncrypted)
+	}
+	w.Close()
+
+	w, err = OpenWithOptions(p, walpb.Snapshot{}, Options{KeyProvider: kp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, state, ents, err := w.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(md) != "metadata" || state.Commit != 6 {
+		t.Errorf("metadata = %q, commit = %d, want %q, 6", md, state.Commit, "metadata")
+	}
+	if !reflect.DeepEqual(ents, wents) {
+		t.Errorf("ents = %+v, want %+v", ents, wents)
+	}
+	// the records appended to the last file are sealed with its data key.
+	e := raftpb.Entry{Index: 7, Term: 1, Data: []byte("secretdata")}
+	if err = w.Save(raftpb.HardState{Term: 1, Commit: 7}, []raftpb.Entry{e}); err != nil {
+		t.Fatal(err)
+	}
+	wents = append(wents, e)
+	w.Close()
+
+	w, err = OpenWithOptions(p, walpb.Snapshot{}, Options{KeyProvider: kp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, s, err := w.ReadStorage(raftpb.Snapshot{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents, err = s.Entries(1, 8, ^uint64(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ents, wents) {
+		t.Errorf("storage ents = %+v, want %+v", ents, wents)
+	}
+	w.Close()
+
+	r, err := Verify(p, nil)
+	if err != nil || !r.Sealed || r.Entries != 7 {
+		t.Errorf("verify without key: sealed = %t, entries = %d, err = %v, want true, 7, nil", r.Sealed, r.Entries, err)
+	}
+	r, err = Verify(p, kp)
+	if err != nil || r.Sealed || r.LastIndex != 7 {
+		t.Errorf("verify with key: sealed = %t, last index = %d, err = %v, want false, 7, nil", r.Sealed, r.LastIndex, err)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	from, to := newTestKeyProvider(t, dir, "from"), newTestKeyProvider(t, dir, "to")
+	p := path.Join(dir, "wal")
+
+	w, err := CreateWithOptions(p, []byte("metadata"), Options{KeyProvider: from})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		if err = w.Save(raftpb.HardState{}, []raftpb.Entry{{Index: uint64(i)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.cut(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	if err = RotateKey(p, from, to); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Verify(p, from); err == nil {
+		t.Errorf("verify with the old key succeeded")
+	}
+	w, err = OpenWithOptions(p, walpb.Snapshot{}, Options{KeyProvider: to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	_, _, ents, err := w.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 4 {
+		t.Errorf("len(ents) = %d, want 4", len(ents))
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/coreos/etcd/pkg/fileutil"
+)
+
+func TestFilePipeline(t *testing.T) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	fp := newFilePipeline(p, 4096)
+	f1, err := fp.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	f2, err := fp.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+	if f1.Name() == f2.Name() {
+		t.Errorf("got the same file %s twice", f1.Name())
+	}
+	// the files are handed out empty and ready to be renamed
+	for _, f := range []*os.File{f1, f2} {
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != 0 {
+			t.Errorf("size of %s = %d, want 0", f.Name(), fi.Size())
+		}
+		if err := os.Rename(f.Name(), f.Name()+".wal"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := fp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the file held by the pipeline is removed on close
+	names, err := fileutil.ReadDir(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Errorf("names = %v, want the 2 renamed files", names)
+	}
+}
+
+func TestFilePipelineFailure(t *testing.T) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	fp := newFilePipeline(path.Join(p, "missing"), 4096)
+	defer fp.Close()
+	if _, err := fp.Open(); err == nil {
+		t.Errorf("err = nil, want the error creating the file")
+	}
+	// the pipeline stays stopped after the failure
+	if _, err := fp.Open(); err == nil {
+		t.Errorf("err = nil, want error")
+	}
+}
@@ -23,6 +23,14 @@ var (
 		Name:      "fsync_durations_microseconds",
 		Help:      "The latency distributions of fsync called by wal.",
 	})
+	cutDurations = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "etcd",
+		Subsystem: "wal",
+		Name:      "cut_durations_microseconds",
+		Help:      "Bucketed histogram of the latency of cutting a new wal segment.",
+		// 100us -> 3.2second
+		Buckets: prometheus.ExponentialBuckets(100, 2, 16),
+	})
 	lastIndexSaved = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: "etcd",
 		Subsystem: "wal",
@@ -33,5 +41,6 @@ var (
 
 func init() {
 	prometheus.MustRegister(syncDurations)
+	prometheus.MustRegister(cutDurations)
 	prometheus.MustRegister(lastIndexSaved)
 }
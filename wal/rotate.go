@@ -0,0 +1,97 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/coreos/etcd/pkg/encryption"
+	"github.com/coreos/etcd/pkg/fileutil"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+// RotateKey rewraps the data keys of the encrypted WAL files in dirpath,
+// unwrapped by from, with the key-encryption key of to. As the encryption
+// records are out of the crc chain, only they are rewritten. The files
+// which are not encrypted are left untouched. The WAL must not be open.
+func RotateKey(dirpath string, from, to encryption.KeyProvider) error {
+	names, err := fileutil.ReadDir(dirpath)
+	if err != nil {
+		return err
+	}
+	for _, name := range checkWalNames(names) {
+		if err := rotateFileKey(path.Join(dirpath, name), from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rotateFileKey(p string, from, to encryption.KeyProvider) error {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	d := newDecoder(ioutil.NopCloser(bytes.NewReader(b)))
+	rec := &walpb.Record{}
+	// the encryption record follows the crc one.
+	if err := d.decode(rec); err != nil {
+		return err
+	}
+	start := d.off
+	if err := d.decode(rec); err != nil || rec.Type != encryptionType {
+		return nil
+	}
+	end := d.off
+
+	h, _, err := encryption.UnmarshalHeader(rec.Data)
+	if err != nil {
+		return err
+	}
+	if err := h.Rewrap(from, to); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.Write(b[:start])
+	e := newEncoder(&buf, 0)
+	if err := e.encode(&walpb.Record{Type: encryptionType, Data: h.Marshal()}); err != nil {
+		return err
+	}
+	if err := e.flush(); err != nil {
+		return err
+	}
+	buf.Write(b[end:])
+
+	tmp := p + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := buf.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
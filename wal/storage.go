@@ -0,0 +1,406 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/coreos/etcd/pkg/crc"
+	"github.com/coreos/etcd/pkg/encryption"
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+var ErrEntryMismatch = errors.New("wal: entry mismatch")
+
+// Storage implements the raft.Storage interface on top of a WAL. Instead of
+// holding the entries in memory, it keeps the term and the position in the
+// wal files of each entry, and reads the entries out of the files when they
+// are requested. The most recently used entries are kept in a cache.
+//
+// The WAL feeds the Storage with the entries as it saves them, so the
+// entries are available through the Storage once they are saved.
+// The Storage keeps the files holding its entries open, so the files can
+// be purged once released by the WAL. Closing the WAL closes the Storage.
+type Storage struct {
+	mu sync.Mutex
+
+	hardState raftpb.HardState
+	snapshot  raftpb.Snapshot
+	// ents[i] is the position of the entry at index i+offset. ents[0] is
+	// a dummy entry holding the term of the last compacted entry.
+	offset uint64
+	ents   []entryPos
+	// files are the wal files holding the entries, by sequence, and keys
+	// the data keys of the encrypted ones.
+	files map[uint64]*os.File
+	keys  map[uint64]*encryption.DataKey
+	cache *entryCache
+}
+
+// entryPos is the position of an entry in the wal files.
+type entryPos struct {
+	term uint64
+	seq  uint64 // sequence of the wal file
+	off  int64  // offset of the record in the wal file
+	crc  uint32 // crc of the records before it in the wal file
+}
+
+// NewStorage returns an empty Storage of the entries saved to the given
+// WAL, which is created by Create. At most cacheSize entries are cached.
+func NewStorage(w *WAL, cacheSize int) *Storage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := newStorage(cacheSize)
+	w.st = s
+	return s
+}
+
+func newStorage(cacheSize int) *Storage {
+	return &Storage{
+		ents:  make([]entryPos, 1),
+		files: make(map[uint64]*os.File),
+		keys:  make(map[uint64]*encryption.DataKey),
+		cache: newEntryCache(cacheSize),
+	}
+}
+
+// ReadStorage reads out the records of the WAL like ReadAll, but instead of
+// returning the entries, it returns a Storage which reads them lazily out of
+// the wal files. The given snapshot MUST be the one the WAL was opened at,
+// or an empty snapshot if the WAL was opened at the empty walpb.Snapshot.
+// At most cacheSize entries are cached.
+// After ReadStorage, the WAL will be ready for appending new records, and the
+// Storage will be fed with them.
+func (w *WAL) ReadStorage(snap raftpb.Snapshot, cacheSize int) (metadata []byte, s *Storage, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s = newStorage(cacheSize)
+	s.ApplySnapshot(snap)
+	metadata, s.hardState, err = w.readAll(func(e raftpb.Entry, off int64) error {
+		seg := w.segmentAt(off)
+		return s.saved(e, seg.seq, path.Join(w.dir, seg.name), off-seg.start, w.decoder.prevCRC, w.decoder.dk)
+	})
+	if err != nil {
+		s.Close()
+		return nil, nil, err
+	}
+	w.st = s
+	return metadata, s, nil
+}
+
+// InitialState implements the raft.Storage interface.
+func (s *Storage) InitialState() (raftpb.HardState, raftpb.ConfState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hardState, s.snapshot.Metadata.ConfState, nil
+}
+
+// Entries implements the raft.Storage interface.
+func (s *Storage) Entries(lo, hi, maxSize uint64) ([]raftpb.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lo <= s.offset {
+		return nil, raft.ErrCompacted
+	}
+	if hi > s.lastIndex()+1 {
+		plog.Panicf("entries's hi(%d) is out of bound lastindex(%d)", hi, s.lastIndex())
+	}
+	// only contains dummy entries.
+	if len(s.ents) == 1 {
+		return nil, raft.ErrUnavailable
+	}
+
+	var ents []raftpb.Entry
+	var size uint64
+	for i := lo; i < hi; i++ {
+		e, err := s.entry(i)
+		if err != nil {
+			return nil, err
+		}
+		size += uint64(e.Size())
+		if len(ents) > 0 && size > maxSize {
+			break
+		}
+		ents = append(ents, e)
+	}
+	return ents, nil
+}
+
+// Term implements the raft.Storage interface.
+func (s *Storage) Term(i uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i < s.offset {
+		return 0, raft.ErrCompacted
+	}
+	if i > s.lastIndex() {
+		return 0, raft.ErrUnavailable
+	}
+	return s.ents[i-s.offset].term, nil
+}
+
+// LastIndex implements the raft.Storage interface.
+func (s *Storage) LastIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastIndex(), nil
+}
+
+func (s *Storage) lastIndex() uint64 {
+	return s.offset + uint64(len(s.ents)) - 1
+}
+
+// FirstIndex implements the raft.Storage interface.
+func (s *Storage) FirstIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offset + 1, nil
+}
+
+// Snapshot implements the raft.Storage interface.
+func (s *Storage) Snapshot() (raftpb.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot, nil
+}
+
+// ApplySnapshot overwrites the contents of the Storage with those of the
+// given snapshot. The entries saved after it are appended to the Storage.
+func (s *Storage) ApplySnapshot(snap raftpb.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshot = snap
+	s.offset = snap.Metadata.Index
+	s.ents = []entryPos{{term: snap.Metadata.Term}}
+	s.cache = newEntryCache(s.cache.size)
+	return s.closeFilesBefore(^uint64(0))
+}
+
+// CreateSnapshot creates a snapshot which can be retrieved with the Snapshot()
+// method, like raft.MemoryStorage.CreateSnapshot.
+func (s *Storage) CreateSnapshot(i uint64, cs *raftpb.ConfState, data []byte) (raftpb.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i <= s.snapshot.Metadata.Index {
+		return raftpb.Snapshot{}, raft.ErrSnapOutOfDate
+	}
+	if i > s.lastIndex() {
+		plog.Panicf("snapshot %d is out of bound lastindex(%d)", i, s.lastIndex())
+	}
+
+	s.snapshot.Metadata.Index = i
+	s.snapshot.Metadata.Term = s.ents[i-s.offset].term
+	if cs != nil {
+		s.snapshot.Metadata.ConfState = *cs
+	}
+	s.snapshot.Data = data
+	return s.snapshot, nil
+}
+
+// Compact discards all log entries prior to compactIndex, and closes the
+// wal files which only hold discarded entries.
+func (s *Storage) Compact(compactIndex uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if compactIndex <= s.offset {
+		return raft.ErrCompacted
+	}
+	if compactIndex > s.lastIndex() {
+		plog.Panicf("compact %d is out of bound lastindex(%d)", compactIndex, s.lastIndex())
+	}
+
+	i := compactIndex - s.offset
+	ents := make([]entryPos, 1, 1+uint64(len(s.ents))-i)
+	ents[0].term = s.ents[i].term
+	ents = append(ents, s.ents[i+1:]...)
+	s.ents = ents
+	s.offset = compactIndex
+
+	if len(s.ents) == 1 {
+		return s.closeFilesBefore(^uint64(0))
+	}
+	return s.closeFilesBefore(s.ents[1].seq)
+}
+
+// Append caches the given entries, which are already saved to the WAL.
+func (s *Storage) Append(ents []raftpb.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range ents {
+		if e.Index > s.offset && e.Index <= s.lastIndex() && s.ents[e.Index-s.offset].term == e.Term {
+			s.cache.add(e)
+		}
+	}
+	return nil
+}
+
+// Close closes the wal files opened by the Storage.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeFilesBefore(^uint64(0))
+}
+
+// saved appends the given entry, which is saved at the given offset of
+// the wal file with the given sequence and path, to the Storage. prevCRC is
+// the crc of the records before it in the file, and dk the data key of the
+// file, if it is encrypted.
+// The entries after it, if any, are overwritten.
+func (s *Storage) saved(e raftpb.Entry, seq uint64, fpath string, off int64, prevCRC uint32, dk *encryption.DataKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.Index <= s.offset {
+		return nil
+	}
+	last := s.lastIndex()
+	if e.Index > last+1 {
+		plog.Panicf("missing log entry [last: %d, append at: %d]", last, e.Index)
+	}
+	for i := e.Index; i <= last; i++ {
+		s.cache.remove(i)
+	}
+	if _, ok := s.files[seq]; !ok {
+		f, err := os.Open(fpath)
+		if err != nil {
+			return err
+		}
+		s.files[seq] = f
+		if dk != nil {
+			s.keys[seq] = dk
+		}
+	}
+	s.ents = append(s.ents[:e.Index-s.offset], entryPos{term: e.Term, seq: seq, off: off, crc: prevCRC})
+	return nil
+}
+
+// entry returns the entry at index i, out of the cache if possible.
+func (s *Storage) entry(i uint64) (raftpb.Entry, error) {
+	if e, ok := s.cache.get(i); ok {
+		return e, nil
+	}
+	p := s.ents[i-s.offset]
+	f, ok := s.files[p.seq]
+	if !ok {
+		return raftpb.Entry{}, fmt.Errorf("wal: file of entry %d is not open", i)
+	}
+	var lb [8]byte
+	if _, err := f.ReadAt(lb[:], p.off); err != nil {
+		return raftpb.Entry{}, err
+	}
+	data := make([]byte, binary.LittleEndian.Uint64(lb[:]))
+	if _, err := f.ReadAt(data, p.off+8); err != nil {
+		return raftpb.Entry{}, err
+	}
+	var rec walpb.Record
+	if err := rec.Unmarshal(data); err != nil {
+		return raftpb.Entry{}, err
+	}
+	if rec.Type != entryType {
+		return raftpb.Entry{}, ErrEntryMismatch
+	}
+	// the record was checked when saved or read out of the file, but may
+	// have been damaged on disk since.
+	h := crc.New(p.crc, crcTable)
+	h.Write(rec.Data)
+	if err := rec.Validate(h.Sum32()); err != nil {
+		return raftpb.Entry{}, err
+	}
+	if dk, ok := s.keys[p.seq]; ok {
+		d, err := dk.Open(rec.Data, recordAD(rec.Type))
+		if err != nil {
+			return raftpb.Entry{}, err
+		}
+		rec.Data = d
+	}
+	e := mustUnmarshalEntry(rec.Data)
+	if e.Index != i || e.Term != p.term {
+		return raftpb.Entry{}, ErrEntryMismatch
+	}
+	s.cache.add(e)
+	return e, nil
+}
+
+// closeFilesBefore closes the files whose sequence is smaller than seq.
+func (s *Storage) closeFilesBefore(seq uint64) error {
+	var err error
+	for fseq, f := range s.files {
+		if fseq < seq {
+			if cerr := f.Close(); cerr != nil {
+				err = cerr
+			}
+			delete(s.files, fseq)
+			delete(s.keys, fseq)
+		}
+	}
+	return err
+}
+
+// entryCache is a LRU cache of entries, keyed by index.
+type entryCache struct {
+	size int
+	ll   *list.List
+	ents map[uint64]*list.Element
+}
+
+func newEntryCache(size int) *entryCache {
+	return &entryCache{
+		size: size,
+		ll:   list.New(),
+		ents: make(map[uint64]*list.Element),
+	}
+}
+
+func (c *entryCache) get(i uint64) (raftpb.Entry, bool) {
+	el, ok := c.ents[i]
+	if !ok {
+		return raftpb.Entry{}, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(raftpb.Entry), true
+}
+
+func (c *entryCache) add(e raftpb.Entry) {
+	if c.size <= 0 {
+		return
+	}
+	if el, ok := c.ents[e.Index]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.ents[e.Index] = c.ll.PushFront(e)
+	if c.ll.Len() > c.size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.ents, el.Value.(raftpb.Entry).Index)
+	}
+}
+
+func (c *entryCache) remove(i uint64) {
+	if el, ok := c.ents[i]; ok {
+		c.ll.Remove(el)
+		delete(c.ents, i)
+	}
+}
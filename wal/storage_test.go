@@ -0,0 +1,309 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+func TestStorageEntries(t *testing.T) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	w, err := Create(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	// do not cache, so that entries are read out of the files
+	s := NewStorage(w, 0)
+
+	ents := []raftpb.Entry{
+		{Index: 1, Term: 1, Data: []byte("a")},
+		{Index: 2, Term: 1, Data: []byte("b")},
+		{Index: 3, Term: 1, Data: []byte("c")},
+	}
+	if err = w.Save(raftpb.HardState{Term: 1, Commit: 1}, ents[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.cut(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Save(raftpb.HardState{}, ents[2:]); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		lo, hi, maxsize uint64
+
+		werr     error
+		wentries []raftpb.Entry
+	}{
+		{0, 2, math.MaxUint64, raft.ErrCompacted, nil},
+		{1, 4, math.MaxUint64, nil, ents},
+		{2, 4, math.MaxUint64, nil, ents[1:]},
+		// even if maxsize is zero, the first entry should be returned
+		{1, 4, 0, nil, ents[:1]},
+		{1, 4, uint64(ents[0].Size() + ents[1].Size()), nil, ents[:2]},
+	}
+	for i, tt := range tests {
+		entries, err := s.Entries(tt.lo, tt.hi, tt.maxsize)
+		if err != tt.werr {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+		if !reflect.DeepEqual(entries, tt.wentries) {
+			t.Errorf("#%d: entries = %v, want %v", i, entries, tt.wentries)
+		}
+	}
+	if term, err := s.Term(3); err != nil || term != 1 {
+		t.Errorf("term = %d, %v, want 1, nil", term, err)
+	}
+
+	// overwrite the entries from index 2
+	nents := []raftpb.Entry{{Index: 2, Term: 2, Data: []byte("d")}}
+	if err = w.Save(raftpb.HardState{}, nents); err != nil {
+		t.Fatal(err)
+	}
+	if li, _ := s.LastIndex(); li != 2 {
+		t.Errorf("lastIndex = %d, want %d", li, 2)
+	}
+	wents := append(ents[:1:1], nents...)
+	if entries, err := s.Entries(1, 3, math.MaxUint64); err != nil || !reflect.DeepEqual(entries, wents) {
+		t.Errorf("entries = %v, %v, want %v, nil", entries, err, wents)
+	}
+}
+
+func TestStorageEntryCorrupted(t *testing.T) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	w, err := Create(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	s := NewStorage(w, 0)
+	ents := []raftpb.Entry{
+		{Index: 1, Term: 1, Data: []byte("a")},
+		{Index: 2, Term: 1, Data: []byte("b")},
+	}
+	if err = w.Save(raftpb.HardState{Term: 1, Commit: 1}, ents); err != nil {
+		t.Fatal(err)
+	}
+
+	// damage the last byte of the record of entry 2, which is in its data.
+	f, err := os.OpenFile(path.Join(p, walName(0, 0)), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pos := s.ents[2]
+	var lb [8]byte
+	if _, err = f.ReadAt(lb[:], pos.off); err != nil {
+		t.Fatal(err)
+	}
+	off := pos.off + 8 + int64(binary.LittleEndian.Uint64(lb[:])) - 1
+	b := make([]byte, 1)
+	if _, err = f.ReadAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err = f.WriteAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.Entries(1, 2, math.MaxUint64); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	if _, err = s.Entries(2, 3, math.MaxUint64); err != walpb.ErrCRCMismatch {
+		t.Errorf("err = %v, want %v", err, walpb.ErrCRCMismatch)
+	}
+}
+
+func TestReadStorage(t *testing.T) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	w, err := Create(p, []byte("metadata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ents []raftpb.Entry
+	for i := 1; i <= 10; i++ {
+		ents = append(ents, raftpb.Entry{Index: uint64(i), Term: 1, Data: []byte{byte(i)}})
+	}
+	if err = w.Save(raftpb.HardState{}, ents[:5]); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.SaveSnapshot(walpb.Snapshot{Index: 3, Term: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.cut(); err != nil {
+		t.Fatal(err)
+	}
+	state := raftpb.HardState{Term: 1, Commit: 7}
+	if err = w.Save(state, ents[5:]); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	snap := raftpb.Snapshot{Metadata: raftpb.SnapshotMetadata{Index: 3, Term: 1}}
+	if w, err = Open(p, walpb.Snapshot{Index: 3, Term: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	metadata, s, err := w.ReadStorage(snap, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(metadata) != "metadata" {
+		t.Errorf("metadata = %s, want %s", metadata, "metadata")
+	}
+	if st, _, _ := s.InitialState(); !reflect.DeepEqual(st, state) {
+		t.Errorf("state = %+v, want %+v", st, state)
+	}
+	if fi, _ := s.FirstIndex(); fi != 4 {
+		t.Errorf("firstIndex = %d, want %d", fi, 4)
+	}
+	if entries, err := s.Entries(4, 11, math.MaxUint64); err != nil || !reflect.DeepEqual(entries, ents[3:]) {
+		t.Errorf("entries = %v, %v, want %v, nil", entries, err, ents[3:])
+	}
+
+	// the entries saved after reading out the WAL are appended
+	e := raftpb.Entry{Index: 11, Term: 1, Data: []byte("new")}
+	if err = w.Save(raftpb.HardState{}, []raftpb.Entry{e}); err != nil {
+		t.Fatal(err)
+	}
+	s.Append([]raftpb.Entry{e})
+	s.cache = newEntryCache(0)
+	if entries, err := s.Entries(11, 12, math.MaxUint64); err != nil || !reflect.DeepEqual(entries, []raftpb.Entry{e}) {
+		t.Errorf("entries = %v, %v, want %v, nil", entries, err, []raftpb.Entry{e})
+	}
+}
+
+func TestStorageCompact(t *testing.T) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	w, err := Create(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	s := NewStorage(w, 0)
+	// one entry in each file
+	for i := 1; i <= 3; i++ {
+		if err = w.Save(raftpb.HardState{}, []raftpb.Entry{{Index: uint64(i), Term: uint64(i)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.cut(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cs := &raftpb.ConfState{Nodes: []uint64{1}}
+	snap, err := s.CreateSnapshot(2, cs, []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wmeta := raftpb.SnapshotMetadata{Index: 2, Term: 2, ConfState: *cs}
+	if !reflect.DeepEqual(snap.Metadata, wmeta) {
+		t.Errorf("snapshot metadata = %+v, want %+v", snap.Metadata, wmeta)
+	}
+	if _, err = s.CreateSnapshot(1, nil, nil); err != raft.ErrSnapOutOfDate {
+		t.Errorf("err = %v, want %v", err, raft.ErrSnapOutOfDate)
+	}
+
+	if err = s.Compact(2); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Compact(1); err != raft.ErrCompacted {
+		t.Errorf("err = %v, want %v", err, raft.ErrCompacted)
+	}
+	if term, err := s.Term(2); err != nil || term != 2 {
+		t.Errorf("term = %d, %v, want 2, nil", term, err)
+	}
+	if _, err = s.Entries(2, 3, math.MaxUint64); err != raft.ErrCompacted {
+		t.Errorf("err = %v, want %v", err, raft.ErrCompacted)
+	}
+	// only the file of the remaining entry is kept open
+	if len(s.files) != 1 {
+		t.Errorf("len(files) = %d, want %d", len(s.files), 1)
+	}
+
+	// the entries can be read even if their file is purged
+	if err = os.Remove(path.Join(p, walName(2, 3))); err != nil {
+		t.Fatal(err)
+	}
+	went := []raftpb.Entry{{Index: 3, Term: 3}}
+	if entries, err := s.Entries(3, 4, math.MaxUint64); err != nil || !reflect.DeepEqual(entries, went) {
+		t.Errorf("entries = %v, %v, want %v, nil", entries, err, went)
+	}
+
+	if err = s.ApplySnapshot(raftpb.Snapshot{Metadata: raftpb.SnapshotMetadata{Index: 5, Term: 5}}); err != nil {
+		t.Fatal(err)
+	}
+	if li, _ := s.LastIndex(); li != 5 {
+		t.Errorf("lastIndex = %d, want %d", li, 5)
+	}
+	if len(s.files) != 0 {
+		t.Errorf("len(files) = %d, want %d", len(s.files), 0)
+	}
+}
+
+func TestEntryCache(t *testing.T) {
+	c := newEntryCache(2)
+	c.add(raftpb.Entry{Index: 1})
+	c.add(raftpb.Entry{Index: 2})
+	// index 1 is the most recently used
+	if _, ok := c.get(1); !ok {
+		t.Errorf("entry 1 is not cached")
+	}
+	c.add(raftpb.Entry{Index: 3})
+	if _, ok := c.get(2); ok {
+		t.Errorf("entry 2 is cached, want evicted")
+	}
+	for _, i := range []uint64{1, 3} {
+		if _, ok := c.get(i); !ok {
+			t.Errorf("entry %d is not cached", i)
+		}
+	}
+	c.remove(3)
+	if _, ok := c.get(3); ok {
+		t.Errorf("entry 3 is cached, want removed")
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/coreos/etcd/pkg/fileutil"
+)
+
+// SyncPolicy is how the records saved to a WAL are made durable.
+type SyncPolicy int
+
+const (
+	// SyncFsync syncs the WAL file with fsync before Save returns.
+	SyncFsync SyncPolicy = iota
+	// SyncFdatasync syncs the WAL file with fdatasync before Save returns,
+	// which does not flush the file metadata that is not needed to read
+	// the data back, such as the modification time. It is the same as
+	// SyncFsync on the platforms other than linux.
+	SyncFdatasync
+	// SyncNone only writes the records into the WAL file before Save
+	// returns, and leaves flushing them to the operating system. The
+	// file is still synced when a segment is cut and when the WAL is
+	// closed. The records saved since the last sync are lost if the
+	// machine crashes.
+	SyncNone
+)
+
+var syncPolicyNames = map[SyncPolicy]string{
+	SyncFsync:     "fsync",
+	SyncFdatasync: "fdatasync",
+	SyncNone:      "none",
+}
+
+func (p SyncPolicy) String() string {
+	if s, ok := syncPolicyNames[p]; ok {
+		return s
+	}
+	return fmt.Sprintf("SyncPolicy(%d)", int(p))
+}
+
+// ParseSyncPolicy returns the SyncPolicy named by s.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	for p, name := range syncPolicyNames {
+		if name == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("wal: unknown sync policy %q", s)
+}
+
+// syncTo makes sure that the first n batches written into the WAL are
+// durable according to the sync policy of the WAL.
+// The batches are synced in groups: the caller which gets to sync next
+// syncs all the batches written so far, so the concurrent callers waiting
+// behind it return without syncing again.
+func (w *WAL) syncTo(n uint64) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	if w.synced >= n {
+		return nil
+	}
+
+	w.mu.Lock()
+	if err := w.encoder.flush(); err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	f, written := w.f, w.written
+	w.mu.Unlock()
+
+	// the file is synced out of mu so that the following batches can be
+	// written meanwhile. It cannot be closed by a cut, which holds syncMu.
+	if err := syncFile(f, w.policy); err != nil {
+		return err
+	}
+	w.synced = written
+	return nil
+}
+
+func syncFile(f *os.File, p SyncPolicy) error {
+	var err error
+	start := time.Now()
+	switch p {
+	case SyncNone:
+		return nil
+	case SyncFdatasync:
+		err = fileutil.Fdatasync(f)
+	default:
+		err = f.Sync()
+	}
+	syncDurations.Observe(float64(time.Since(start).Nanoseconds() / int64(time.Microsecond)))
+	return err
+}
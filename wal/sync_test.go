@@ -0,0 +1,116 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+func TestParseSyncPolicy(t *testing.T) {
+	for _, p := range []SyncPolicy{SyncFsync, SyncFdatasync, SyncNone} {
+		g, err := ParseSyncPolicy(p.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g != p {
+			t.Errorf("policy = %v, want %v", g, p)
+		}
+	}
+	if _, err := ParseSyncPolicy("unknown"); err == nil {
+		t.Errorf("err = nil, want error")
+	}
+}
+
+// TestSaveSyncPolicy tests that the records saved are written into the
+// WAL files before Save returns, whatever the sync policy.
+func TestSaveSyncPolicy(t *testing.T) {
+	for i, policy := range []SyncPolicy{SyncFsync, SyncFdatasync, SyncNone} {
+		p, err := ioutil.TempDir(os.TempDir(), "waltest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := CreateWithOptions(p, []byte("metadata"), Options{SyncPolicy: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := raftpb.HardState{Term: 1, Vote: 1, Commit: 2}
+		ents := []raftpb.Entry{{Index: 1, Term: 1, Data: []byte{1}}, {Index: 2, Term: 1, Data: []byte{2}}}
+		if err = w.Save(st, ents); err != nil {
+			t.Fatal(err)
+		}
+
+		rw, err := OpenForRead(p, walpb.Snapshot{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, gst, gents, err := rw.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(gst, st) {
+			t.Errorf("#%d: state = %+v, want %+v", i, gst, st)
+		}
+		if !reflect.DeepEqual(gents, ents) {
+			t.Errorf("#%d: ents = %+v, want %+v", i, gents, ents)
+		}
+		rw.Close()
+		w.Close()
+		os.RemoveAll(p)
+	}
+}
+
+// TestConcurrentSave tests that the concurrent calls to Save all return
+// once their records are synced.
+func TestConcurrentSave(t *testing.T) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+	w, err := Create(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(term uint64) {
+			defer wg.Done()
+			for j := uint64(1); j <= 10; j++ {
+				if err := w.Save(raftpb.HardState{Term: term, Commit: j}, nil); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(uint64(i + 1))
+	}
+	wg.Wait()
+
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	// the initial snapshot and the 80 states
+	if w.synced != 81 {
+		t.Errorf("synced = %d, want 81", w.synced)
+	}
+}
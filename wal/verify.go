@@ -0,0 +1,329 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/coreos/etcd/pkg/encryption"
+	"github.com/coreos/etcd/pkg/fileutil"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+// VerifyError is the first problem found in the WAL files by Verify.
+type VerifyError struct {
+	// File is the name of the WAL file holding the problem.
+	File string
+	// Offset is the offset in the file of the record with the problem.
+	Offset int64
+	Err    error
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("wal: %s at offset %d: %v", e.File, e.Offset, e.Err)
+}
+
+// VerifyResult describes the WAL files checked by Verify.
+type VerifyResult struct {
+	// Files are the names of the WAL files checked, up to the one holding
+	// the problem found, if any.
+	Files    []string
+	Metadata []byte
+	// State is the last HardState saved.
+	State raftpb.HardState
+	// Snapshots are the snapshot records saved.
+	Snapshots []walpb.Snapshot
+	// Entries is the number of entry records, and LastIndex the index of
+	// the last one.
+	Entries   int
+	LastIndex uint64
+	// Sealed is true if encrypted files were found without a key provider
+	// to decrypt them. Only the crc of their records was checked, and
+	// their entries are counted but not described.
+	Sealed bool
+}
+
+// Verify reads out all the records of the WAL files in dirpath, without
+// locking them, and checks that
+//   - the sequence numbers of the files are continuous, and their indexes
+//     match the entries they follow.
+//   - the crc of the records are valid and chained across the files.
+//   - the entries have no index gap and no term going backward.
+//   - the metadata records are the same.
+//
+// The records of the encrypted files are decrypted with kp, if not nil.
+// It returns the first problem found as a *VerifyError, along with the
+// description of the records read before it.
+func Verify(dirpath string, kp encryption.KeyProvider) (*VerifyResult, error) {
+	names, err := fileutil.ReadDir(dirpath)
+	if err != nil {
+		return nil, err
+	}
+	names = checkWalNames(names)
+	if len(names) == 0 {
+		return nil, ErrFileNotFound
+	}
+
+	v := &verifier{dir: dirpath, kp: kp, r: &VerifyResult{}}
+	for i, name := range names {
+		seq, index, err := parseWalName(name)
+		if err != nil {
+			return v.r, err
+		}
+		v.r.Files = append(v.r.Files, name)
+		if i == 0 {
+			if index > 0 {
+				v.enti, v.lastEnt = index-1, index-1
+			}
+		} else {
+			switch {
+			case seq != v.seq+1:
+				return v.r, &VerifyError{File: name, Err: fmt.Errorf("unexpected sequence %d after %d", seq, v.seq)}
+			// the entries of the sealed files are unknown.
+			case !v.r.Sealed && index != v.enti+1 && index != v.lastEnt+1:
+				return v.r, &VerifyError{File: name, Err: fmt.Errorf("unexpected index %d after entry %d", index, v.lastEnt)}
+			}
+		}
+		v.seq = seq
+		if err := v.verifyFile(name, i == 0); err != nil {
+			return v.r, err
+		}
+	}
+	return v.r, nil
+}
+
+type verifier struct {
+	dir string
+	kp  encryption.KeyProvider
+	r   *VerifyResult
+
+	seq uint64 // sequence of the last file checked
+	// enti is the index of the last entry, or of the last snapshot if it
+	// is ahead, as kept by a WAL being written. lastEnt is the index of the
+	// last entry, as kept by a WAL reopened for writing.
+	enti    uint64
+	lastEnt uint64
+	term    uint64 // term of the last entry
+	crc     uint32 // crc at the end of the last file checked
+}
+
+func (v *verifier) verifyFile(name string, first bool) error {
+	f, err := os.Open(path.Join(v.dir, name))
+	if err != nil {
+		return err
+	}
+	d := newDecoder(f)
+	d.kp = v.kp
+	defer d.close()
+
+	// sealed is true if the records of the file are encrypted, but cannot
+	// be decrypted.
+	sealed := false
+	rec := &walpb.Record{}
+	for n := 0; ; n++ {
+		off := d.off
+		fail := func(err error) error {
+			return &VerifyError{File: name, Offset: off, Err: err}
+		}
+
+		err := d.decode(rec)
+		switch {
+		case err == io.EOF && n == 0:
+			return fail(fmt.Errorf("empty file"))
+		case err == io.EOF:
+			v.crc = d.lastCRC()
+			return nil
+		case err != nil:
+			return fail(err)
+		}
+
+		if n == 0 && rec.Type != crcType {
+			return fail(fmt.Errorf("unexpected record type %d at the head of the file", rec.Type))
+		}
+		switch rec.Type {
+		case crcType:
+			// the crc of the records of the previous files cannot be
+			// checked for the first file.
+			if n == 0 && !first && rec.Crc != v.crc {
+				return fail(ErrCRCMismatch)
+			}
+			if n != 0 && rec.Validate(d.lastCRC()) != nil {
+				return fail(ErrCRCMismatch)
+			}
+			d.updateCRC(rec.Crc)
+		case encryptionType:
+			if n != 1 {
+				return fail(fmt.Errorf("unexpected encryption header"))
+			}
+			if _, _, err := encryption.UnmarshalHeader(rec.Data); err != nil {
+				return fail(err)
+			}
+			if v.kp == nil {
+				sealed = true
+				v.r.Sealed = true
+			}
+		case entryType, stateType, snapshotType, metadataType:
+			if sealed {
+				if rec.Type == entryType {
+					v.r.Entries++
+				}
+				break
+			}
+			if err := v.checkRecord(rec); err != nil {
+				return fail(err)
+			}
+		default:
+			return fail(fmt.Errorf("unexpected block type %d", rec.Type))
+		}
+	}
+}
+
+// checkRecord checks the given record of entry, state, snapshot or metadata
+// type, and updates the result with it.
+func (v *verifier) checkRecord(rec *walpb.Record) error {
+	switch rec.Type {
+	case metadataType:
+		if v.r.Metadata != nil && !bytes.Equal(v.r.Metadata, rec.Data) {
+			return ErrMetadataConflict
+		}
+		v.r.Metadata = rec.Data
+	case stateType:
+		var st raftpb.HardState
+		if err := st.Unmarshal(rec.Data); err != nil {
+			return err
+		}
+		v.r.State = st
+	case snapshotType:
+		var snap walpb.Snapshot
+		if err := snap.Unmarshal(rec.Data); err != nil {
+			return err
+		}
+		v.r.Snapshots = append(v.r.Snapshots, snap)
+		if v.enti < snap.Index {
+			v.enti = snap.Index
+		}
+	case entryType:
+		var e raftpb.Entry
+		if err := e.Unmarshal(rec.Data); err != nil {
+			return err
+		}
+		if !v.r.Sealed && e.Index > v.enti+1 {
+			return fmt.Errorf("missing entries between index %d and %d", v.enti, e.Index)
+		}
+		if e.Term < v.term {
+			return fmt.Errorf("entry %d has term %d lower than the previous term %d", e.Index, e.Term, v.term)
+		}
+		v.enti, v.lastEnt, v.term = e.Index, e.Index, e.Term
+		v.r.Entries++
+		v.r.LastIndex = e.Index
+	}
+	return nil
+}
+
+// RepairTo writes into dst a copy of the WAL files in dirpath up to the
+// first problem found by Verify: the files before the problem are copied
+// whole, the file holding it is truncated before the damaged record, and
+// the following files are left out. dirpath is left untouched. kp decrypts
+// the encrypted files, as for Verify.
+//
+// Like Repair, it only truncates a torn write at the tail of the last file,
+// which loses no entry the member may have acknowledged. It refuses any
+// other damage with ErrNotTornTail, unless force is true. The entries of a
+// WAL repaired by force are lost although they might have been committed,
+// so the member must be removed from the cluster and re-added with a fresh
+// data directory instead of being restarted with the repaired WAL.
+// It returns the result of Verify, and any error but the VerifyError.
+func RepairTo(dirpath, dst string, kp encryption.KeyProvider, force bool) (*VerifyResult, error) {
+	r, err := Verify(dirpath, kp)
+	verr, ok := err.(*VerifyError)
+	if err != nil && !ok {
+		return r, err
+	}
+	if verr != nil && !force {
+		torn, err := isTornTail(dirpath, verr)
+		if err != nil {
+			return r, err
+		}
+		if !torn {
+			return r, ErrNotTornTail
+		}
+	}
+	if Exist(dst) {
+		return r, os.ErrExist
+	}
+	if err := os.MkdirAll(dst, privateDirMode); err != nil {
+		return r, err
+	}
+
+	for _, name := range r.Files {
+		size := int64(-1)
+		if verr != nil && name == verr.File {
+			if verr.Offset == 0 {
+				break
+			}
+			size = verr.Offset
+		}
+		if err := copyFile(path.Join(dirpath, name), path.Join(dst, name), size); err != nil {
+			return r, err
+		}
+		if size >= 0 {
+			plog.Noticef("truncated %s at offset %d in the repaired copy", name, size)
+		}
+	}
+	return r, nil
+}
+
+// isTornTail returns true if the problem found by Verify is a record cut
+// short by the end of the last WAL file in dirpath, as left by a torn write.
+func isTornTail(dirpath string, verr *VerifyError) (bool, error) {
+	if verr.Err != io.ErrUnexpectedEOF {
+		return false, nil
+	}
+	names, err := fileutil.ReadDir(dirpath)
+	if err != nil {
+		return false, err
+	}
+	names = checkWalNames(names)
+	return len(names) > 0 && names[len(names)-1] == verr.File, nil
+}
+
+// copyFile copies the first size bytes of src into dst, or all of src if
+// size is negative.
+func copyFile(src, dst string, size int64) error {
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	df, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+
+	var r io.Reader = sf
+	if size >= 0 {
+		r = io.LimitReader(sf, size)
+	}
+	if _, err := io.Copy(df, r); err != nil {
+		return err
+	}
+	return df.Sync()
+}
@@ -0,0 +1,231 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/wal/walpb"
+)
+
+// createVerifyWAL creates in a temporary directory a WAL of three files,
+// holding three entries each.
+func createVerifyWAL(t *testing.T) string {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := Create(p, []byte("metadata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 1; i <= 9; i++ {
+		es := []raftpb.Entry{{Index: uint64(i), Term: 1}}
+		if err = w.Save(raftpb.HardState{Term: 1, Commit: uint64(i)}, es); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 0 && i != 9 {
+			if err = w.cut(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return p
+}
+
+// recordOffsets returns the offsets of the records of the given WAL file.
+func recordOffsets(t *testing.T, name string) []int64 {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDecoder(f)
+	defer d.close()
+	var offs []int64
+	for {
+		off := d.off
+		rec := &walpb.Record{}
+		if err := d.decode(rec); err != nil {
+			return offs
+		}
+		if rec.Type == crcType {
+			d.updateCRC(rec.Crc)
+		}
+		offs = append(offs, off)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p := createVerifyWAL(t)
+	defer os.RemoveAll(p)
+
+	r, err := Verify(p, nil)
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if len(r.Files) != 3 {
+		t.Errorf("len(files) = %d, want 3", len(r.Files))
+	}
+	if r.Entries != 9 || r.LastIndex != 9 {
+		t.Errorf("entries = %d, last index = %d, want 9, 9", r.Entries, r.LastIndex)
+	}
+	if string(r.Metadata) != "metadata" {
+		t.Errorf("metadata = %q, want %q", r.Metadata, "metadata")
+	}
+	if r.State.Commit != 9 {
+		t.Errorf("commit = %d, want 9", r.State.Commit)
+	}
+}
+
+func TestVerifyCorrupted(t *testing.T) {
+	p := createVerifyWAL(t)
+	defer os.RemoveAll(p)
+
+	name := walName(1, 4)
+	offs := recordOffsets(t, path.Join(p, name))
+	// corrupt the data of entry 4, the fourth record of the second file.
+	f, err := os.OpenFile(path.Join(p, name), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt([]byte{0xff}, offs[3]+10); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r, err := Verify(p, nil)
+	verr, ok := err.(*VerifyError)
+	if !ok {
+		t.Fatalf("err = %v, want *VerifyError", err)
+	}
+	if verr.File != name || verr.Offset != offs[3] {
+		t.Errorf("problem at %s:%d, want %s:%d", verr.File, verr.Offset, name, offs[3])
+	}
+	if len(r.Files) != 2 {
+		t.Errorf("len(files) = %d, want 2", len(r.Files))
+	}
+}
+
+func TestVerifyMissingFile(t *testing.T) {
+	p := createVerifyWAL(t)
+	defer os.RemoveAll(p)
+
+	if err := os.Remove(path.Join(p, walName(1, 4))); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Verify(p, nil)
+	verr, ok := err.(*VerifyError)
+	if !ok {
+		t.Fatalf("err = %v, want *VerifyError", err)
+	}
+	if verr.File != walName(2, 7) || !strings.Contains(verr.Err.Error(), "sequence") {
+		t.Errorf("err = %v, want a sequence error in %s", err, walName(2, 7))
+	}
+}
+
+func TestRepairToTornTail(t *testing.T) {
+	p := createVerifyWAL(t)
+	defer os.RemoveAll(p)
+
+	// cut the last record of the last file short: the state saved along
+	// entry 9.
+	name := walName(2, 7)
+	offs := recordOffsets(t, path.Join(p, name))
+	if err := os.Truncate(path.Join(p, name), offs[len(offs)-1]+10); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := path.Join(p, "repaired")
+	if _, err := RepairTo(p, dst, nil, false); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if _, err := RepairTo(p, dst, nil, false); err != os.ErrExist {
+		t.Errorf("err = %v, want %v", err, os.ErrExist)
+	}
+	if _, err := Verify(dst, nil); err != nil {
+		t.Fatalf("verify repaired: err = %v, want nil", err)
+	}
+
+	w, err := Open(dst, walpb.Snapshot{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	_, st, ents, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	// the records before the torn one are kept.
+	if len(ents) != 9 || st.Commit != 8 {
+		t.Errorf("len(ents) = %d, commit = %d, want 9, 8", len(ents), st.Commit)
+	}
+}
+
+func TestRepairToForce(t *testing.T) {
+	p := createVerifyWAL(t)
+	defer os.RemoveAll(p)
+
+	name := walName(1, 4)
+	offs := recordOffsets(t, path.Join(p, name))
+	f, err := os.OpenFile(path.Join(p, name), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt([]byte{0xff}, offs[3]+10); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// damage before the last file is not a torn write.
+	dst := path.Join(p, "repaired")
+	if _, err = RepairTo(p, dst, nil, false); err != ErrNotTornTail {
+		t.Fatalf("err = %v, want %v", err, ErrNotTornTail)
+	}
+	if Exist(dst) {
+		t.Fatalf("refused repair wrote %s", dst)
+	}
+
+	if _, err = RepairTo(p, dst, nil, true); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if _, err = Verify(dst, nil); err != nil {
+		t.Fatalf("verify repaired: err = %v, want nil", err)
+	}
+
+	w, err := Open(dst, walpb.Snapshot{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	_, _, ents, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	// the second file starts with a crc, metadata and state record; the
+	// fourth one is entry 4.
+	if len(ents) != 3 {
+		t.Errorf("len(ents) = %d, want 3", len(ents))
+	}
+	// the damaged file is kept, truncated, to be appended to.
+	if _, err = os.Stat(path.Join(dst, name)); err != nil {
+		t.Error(err)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/coreos/etcd/pkg/encryption"
 	"github.com/coreos/etcd/pkg/fileutil"
 	"github.com/coreos/etcd/pkg/pbutil"
 	"github.com/coreos/etcd/raft"
@@ -40,6 +41,9 @@ const (
 	stateType
 	crcType
 	snapshotType
+	// encryptionType records hold the encryption.Header of the data key
+	// the records of their file are sealed with.
+	encryptionType
 
 	// the owner can make/remove files inside the directory
 	privateDirMode = 0700
@@ -57,6 +61,8 @@ var (
 	ErrCRCMismatch      = errors.New("wal: crc mismatch")
 	ErrSnapshotMismatch = errors.New("wal: snapshot mismatch")
 	ErrSnapshotNotFound = errors.New("wal: snapshot not found")
+	ErrEncrypted        = errors.New("wal: encrypted records found, but no key provider given")
+	ErrNotTornTail      = errors.New("wal: damage is not a torn write at the tail of the last file")
 	crcTable            = crc32.MakeTable(crc32.Castagnoli)
 )
 
@@ -72,19 +78,55 @@ type WAL struct {
 
 	start   walpb.Snapshot // snapshot to start reading
 	decoder *decoder       // decoder to decode records
+	segs    []segment      // the files decoded by the decoder
 
 	mu      sync.Mutex
 	f       *os.File // underlay file opened for appending, sync
 	seq     uint64   // sequence of the wal file currently used for writes
 	enti    uint64   // index of the last entry saved to the wal
 	encoder *encoder // encoder to encode records
+	fp      *filePipeline
+	written uint64 // number of batches written into the wal
+
+	policy SyncPolicy
+	kp     encryption.KeyProvider
+	// syncMu serializes the syncs and the cuts of the wal. It is taken
+	// before mu.
+	syncMu sync.Mutex
+	synced uint64 // number of batches known to be synced
 
 	locks []fileutil.Lock // the file locks the WAL is holding (the name is increasing)
+
+	st *Storage // storage indexing the saved entries, if any
+}
+
+// segment is a wal file read by the decoder of a WAL.
+type segment struct {
+	seq   uint64
+	name  string
+	start int64 // offset of the file in the decoded stream
+}
+
+// Options are the options of a WAL.
+type Options struct {
+	// SyncPolicy makes the records appended durable. The zero value is
+	// SyncFsync.
+	SyncPolicy SyncPolicy
+	// KeyProvider, if not nil, encrypts the records of the files created,
+	// and decrypts the records of the encrypted files read. The records of
+	// each file are sealed with their own data key, except the crc records.
+	KeyProvider encryption.KeyProvider
 }
 
 // Create creates a WAL ready for appending records. The given metadata is
 // recorded at the head of each WAL file, and can be retrieved with ReadAll.
+// The records are synced with fsync, and not encrypted.
 func Create(dirpath string, metadata []byte) (*WAL, error) {
+	return CreateWithOptions(dirpath, metadata, Options{})
+}
+
+// CreateWithOptions creates a WAL like Create, with the given Options.
+func CreateWithOptions(dirpath string, metadata []byte, opts Options) (*WAL, error) {
 	if Exist(dirpath) {
 		return nil, os.ErrExist
 	}
@@ -113,17 +155,23 @@ func Create(dirpath string, metadata []byte) (*WAL, error) {
 		seq:      0,
 		f:        f,
 		encoder:  newEncoder(f, 0),
+		policy:   opts.SyncPolicy,
+		kp:       opts.KeyProvider,
 	}
 	w.locks = append(w.locks, l)
 	if err := w.saveCrc(0); err != nil {
 		return nil, err
 	}
+	if err := w.saveEncryptionHeader(); err != nil {
+		return nil, err
+	}
 	if err := w.encoder.encode(&walpb.Record{Type: metadataType, Data: metadata}); err != nil {
 		return nil, err
 	}
 	if err = w.SaveSnapshot(walpb.Snapshot{}); err != nil {
 		return nil, err
 	}
+	w.fp = newFilePipeline(w.dir, segmentSizeBytes)
 	return w, nil
 }
 
@@ -133,17 +181,29 @@ func Create(dirpath string, metadata []byte) (*WAL, error) {
 // The returned WAL is ready to read and the first record will be the one after
 // the given snap. The WAL cannot be appended to before reading out all of its
 // previous records.
+// The records appended are synced with fsync, and not encrypted.
 func Open(dirpath string, snap walpb.Snapshot) (*WAL, error) {
-	return openAtIndex(dirpath, snap, true)
+	return openAtIndex(dirpath, snap, true, Options{})
+}
+
+// OpenWithOptions opens the WAL like Open, with the given Options.
+func OpenWithOptions(dirpath string, snap walpb.Snapshot, opts Options) (*WAL, error) {
+	return openAtIndex(dirpath, snap, true, opts)
 }
 
 // OpenForRead only opens the wal files for read.
 // Write on a read only wal panics.
 func OpenForRead(dirpath string, snap walpb.Snapshot) (*WAL, error) {
-	return openAtIndex(dirpath, snap, false)
+	return openAtIndex(dirpath, snap, false, Options{})
+}
+
+// OpenForReadWithOptions opens the wal files for read like OpenForRead,
+// decrypting them with the KeyProvider of the given Options.
+func OpenForReadWithOptions(dirpath string, snap walpb.Snapshot, opts Options) (*WAL, error) {
+	return openAtIndex(dirpath, snap, false, opts)
 }
 
-func openAtIndex(dirpath string, snap walpb.Snapshot, write bool) (*WAL, error) {
+func openAtIndex(dirpath string, snap walpb.Snapshot, write bool, opts Options) (*WAL, error) {
 	names, err := fileutil.ReadDir(dirpath)
 	if err != nil {
 		return nil, err
@@ -161,11 +221,23 @@ func openAtIndex(dirpath string, snap walpb.Snapshot, write bool) (*WAL, error)
 	// open the wal files for reading
 	rcs := make([]io.ReadCloser, 0)
 	ls := make([]fileutil.Lock, 0)
+	segs := make([]segment, 0)
+	var start int64
 	for _, name := range names[nameIndex:] {
 		f, err := os.Open(path.Join(dirpath, name))
 		if err != nil {
 			return nil, err
 		}
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		seq, _, err := parseWalName(name)
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, segment{seq: seq, name: name, start: start})
+		start += fi.Size()
 		l, err := fileutil.NewLock(f.Name())
 		if err != nil {
 			return nil, err
@@ -186,8 +258,12 @@ func openAtIndex(dirpath string, snap walpb.Snapshot, write bool) (*WAL, error)
 		dir:     dirpath,
 		start:   snap,
 		decoder: newDecoder(rc),
+		segs:    segs,
 		locks:   ls,
+		policy:  opts.SyncPolicy,
+		kp:      opts.KeyProvider,
 	}
+	w.decoder.kp = opts.KeyProvider
 
 	if write {
 		// open the lastest wal file for appending
@@ -231,6 +307,23 @@ func (w *WAL) ReadAll() (metadata []byte, state raftpb.HardState, ents []raftpb.
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	start := w.start.Index
+	metadata, state, err = w.readAll(func(e raftpb.Entry, off int64) error {
+		ents = append(ents[:e.Index-start-1], e)
+		return nil
+	})
+	if err != nil && err != ErrSnapshotNotFound {
+		return nil, state, nil, err
+	}
+	return metadata, state, ents, err
+}
+
+// readAll reads out the records of the WAL, passing the entries after the
+// start snapshot to the given function along with their offset in the
+// decoded stream, and returns the metadata and the state of the WAL.
+// Unless the error is ErrSnapshotNotFound, the returned metadata and state
+// are empty on error.
+func (w *WAL) readAll(entryf func(e raftpb.Entry, off int64) error) (metadata []byte, state raftpb.HardState, err error) {
 	rec := &walpb.Record{}
 	decoder := w.decoder
 
@@ -240,7 +333,10 @@ func (w *WAL) ReadAll() (metadata []byte, state raftpb.HardState, ents []raftpb.
 		case entryType:
 			e := mustUnmarshalEntry(rec.Data)
 			if e.Index > w.start.Index {
-				ents = append(ents[:e.Index-w.start.Index-1], e)
+				if err = entryf(e, decoder.lastOff); err != nil {
+					state.Reset()
+					return nil, state, err
+				}
 			}
 			w.enti = e.Index
 		case stateType:
@@ -248,7 +344,7 @@ func (w *WAL) ReadAll() (metadata []byte, state raftpb.HardState, ents []raftpb.
 		case metadataType:
 			if metadata != nil && !reflect.DeepEqual(metadata, rec.Data) {
 				state.Reset()
-				return nil, state, nil, ErrMetadataConflict
+				return nil, state, ErrMetadataConflict
 			}
 			metadata = rec.Data
 		case crcType:
@@ -257,7 +353,7 @@ func (w *WAL) ReadAll() (metadata []byte, state raftpb.HardState, ents []raftpb.
 			// do no need to match 0 crc, since the decoder is a new one at this case.
 			if crc != 0 && rec.Validate(crc) != nil {
 				state.Reset()
-				return nil, state, nil, ErrCRCMismatch
+				return nil, state, ErrCRCMismatch
 			}
 			decoder.updateCRC(rec.Crc)
 		case snapshotType:
@@ -266,13 +362,19 @@ func (w *WAL) ReadAll() (metadata []byte, state raftpb.HardState, ents []raftpb.
 			if snap.Index == w.start.Index {
 				if snap.Term != w.start.Term {
 					state.Reset()
-					return nil, state, nil, ErrSnapshotMismatch
+					return nil, state, ErrSnapshotMismatch
 				}
 				match = true
 			}
+		case encryptionType:
+			// the decoder decrypts the following records of the file.
+			if decoder.dk == nil {
+				state.Reset()
+				return nil, state, ErrEncrypted
+			}
 		default:
 			state.Reset()
-			return nil, state, nil, fmt.Errorf("unexpected block type %d", rec.Type)
+			return nil, state, fmt.Errorf("unexpected block type %d", rec.Type)
 		}
 	}
 
@@ -283,13 +385,13 @@ func (w *WAL) ReadAll() (metadata []byte, state raftpb.HardState, ents []raftpb.
 		// ErrunexpectedEOF might be returned.
 		if err != io.EOF && err != io.ErrUnexpectedEOF {
 			state.Reset()
-			return nil, state, nil, err
+			return nil, state, err
 		}
 	default:
 		// We must read all of the entries if WAL is opened in write mode.
 		if err != io.EOF {
 			state.Reset()
-			return nil, state, nil, err
+			return nil, state, err
 		}
 	}
 
@@ -305,19 +407,45 @@ func (w *WAL) ReadAll() (metadata []byte, state raftpb.HardState, ents []raftpb.
 	w.metadata = metadata
 
 	if w.f != nil {
+		fi, serr := w.f.Stat()
+		if serr != nil {
+			state.Reset()
+			return nil, state, serr
+		}
 		// create encoder (chain crc with the decoder), enable appending
 		w.encoder = newEncoder(w.f, w.decoder.lastCRC())
+		w.encoder.off = fi.Size()
+		// keep sealing the records of the last file with its data key.
+		w.encoder.dk = w.decoder.dk
 		w.decoder = nil
+		w.fp = newFilePipeline(w.dir, segmentSizeBytes)
 		lastIndexSaved.Set(float64(w.enti))
 	}
 
-	return metadata, state, ents, err
+	return metadata, state, err
+}
+
+// segmentAt returns the segment holding the given offset of the
+// decoded stream.
+func (w *WAL) segmentAt(off int64) segment {
+	for i := len(w.segs) - 1; i > 0; i-- {
+		if w.segs[i].start <= off {
+			return w.segs[i]
+		}
+	}
+	return w.segs[0]
 }
 
 // cut closes current file written and creates a new one ready to append.
-// cut first creates a temp wal file and writes necessary headers into it.
+// cut first takes a temp wal file pre-created by the file pipeline and
+// writes necessary headers into it.
 // Then cut atomtically rename temp wal file to a wal file.
 func (w *WAL) cut() error {
+	start := time.Now()
+	defer func() {
+		cutDurations.Observe(float64(time.Since(start).Nanoseconds() / int64(time.Microsecond)))
+	}()
+
 	// close old wal file
 	if err := w.sync(); err != nil {
 		return err
@@ -327,13 +455,13 @@ func (w *WAL) cut() error {
 	}
 
 	fpath := path.Join(w.dir, walName(w.seq+1, w.enti+1))
-	ftpath := fpath + ".tmp"
 
-	// create a temp wal file with name sequence + 1, or tuncate the existing one
-	ft, err := os.OpenFile(ftpath, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0600)
+	// the temp wal file is already created and preallocated
+	ft, err := w.fp.Open()
 	if err != nil {
 		return err
 	}
+	ftpath := ft.Name()
 
 	// update writer and save the previous crc
 	w.f = ft
@@ -342,6 +470,9 @@ func (w *WAL) cut() error {
 	if err := w.saveCrc(prevCrc); err != nil {
 		return err
 	}
+	if err := w.saveEncryptionHeader(); err != nil {
+		return err
+	}
 	if err := w.encoder.encode(&walpb.Record{Type: metadataType, Data: w.metadata}); err != nil {
 		return err
 	}
@@ -361,20 +492,18 @@ func (w *WAL) cut() error {
 		return err
 	}
 
-	// open the wal file and update writer again
+	// open the wal file and update writer again. The space of the file
+	// is kept allocated through the rename.
 	f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0600)
 	if err != nil {
 		return err
 	}
-	err = fileutil.Preallocate(f, segmentSizeBytes)
-	if err != nil {
-		plog.Errorf("failed to allocate space when creating new wal file (%v)", err)
-		return err
-	}
 
 	w.f = f
 	prevCrc = w.encoder.crc.Sum32()
+	off, dk := w.encoder.off, w.encoder.dk
 	w.encoder = newEncoder(w.f, prevCrc)
+	w.encoder.off, w.encoder.dk = off, dk
 
 	// lock the new wal file
 	l, err := fileutil.NewLock(f.Name())
@@ -395,16 +524,19 @@ func (w *WAL) cut() error {
 	return nil
 }
 
+// sync flushes the records written and syncs the current file. The file
+// is synced even if the sync policy is SyncNone.
 func (w *WAL) sync() error {
 	if w.encoder != nil {
 		if err := w.encoder.flush(); err != nil {
 			return err
 		}
 	}
-	start := time.Now()
-	err := w.f.Sync()
-	syncDurations.Observe(float64(time.Since(start).Nanoseconds() / int64(time.Microsecond)))
-	return err
+	p := w.policy
+	if p == SyncNone {
+		p = SyncFsync
+	}
+	return syncFile(w.f, p)
 }
 
 // ReleaseLockTo releases the locks, which has smaller index than the given index
@@ -449,10 +581,55 @@ func (w *WAL) ReleaseLockTo(index uint64) error {
 	return nil
 }
 
+// OpenSegments opens for reading the wal files holding the records which
+// follow the given index, as OpenForRead selects them, while the WAL keeps
+// being written. It returns the files with the size of the last one when
+// they were opened: its records are complete up to that size, and the ones
+// written after it must not be read. The caller must close the files.
+func (w *WAL) OpenSegments(index uint64) ([]*os.File, int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.encoder.flush(); err != nil {
+		return nil, 0, err
+	}
+	fi, err := w.f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	names, err := fileutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	names = checkWalNames(names)
+	nameIndex, ok := searchIndex(names, index)
+	if !ok || !isValidSeq(names[nameIndex:]) {
+		return nil, 0, ErrFileNotFound
+	}
+	fs := make([]*os.File, 0, len(names)-nameIndex)
+	for _, name := range names[nameIndex:] {
+		f, err := os.Open(path.Join(w.dir, name))
+		if err != nil {
+			for _, f := range fs {
+				f.Close()
+			}
+			return nil, 0, err
+		}
+		fs = append(fs, f)
+	}
+	return fs, fi.Size(), nil
+}
+
 func (w *WAL) Close() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.fp != nil {
+		w.fp.Close()
+		w.fp = nil
+	}
 	if w.f != nil {
 		if err := w.sync(); err != nil {
 			return err
@@ -461,6 +638,11 @@ func (w *WAL) Close() error {
 			return err
 		}
 	}
+	if w.st != nil {
+		if err := w.st.Close(); err != nil {
+			return err
+		}
+	}
 	for _, l := range w.locks {
 		err := l.Unlock()
 		if err != nil {
@@ -478,9 +660,15 @@ func (w *WAL) saveEntry(e *raftpb.Entry) error {
 	// TODO: add MustMarshalTo to reduce one allocation.
 	b := pbutil.MustMarshal(e)
 	rec := &walpb.Record{Type: entryType, Data: b}
+	off, prevCRC := w.encoder.off, w.encoder.crc.Sum32()
 	if err := w.encoder.encode(rec); err != nil {
 		return err
 	}
+	if w.st != nil {
+		if err := w.st.saved(*e, w.seq, w.f.Name(), off, prevCRC, w.encoder.dk); err != nil {
+			return err
+		}
+	}
 	w.enti = e.Index
 	lastIndexSaved.Set(float64(w.enti))
 	return nil
@@ -496,43 +684,79 @@ func (w *WAL) saveState(s *raftpb.HardState) error {
 	return w.encoder.encode(rec)
 }
 
+// Save saves the given state and entries into the WAL, and returns once
+// they are durable according to the sync policy of the WAL.
+// Concurrent calls to Save share the syncs of the WAL.
 func (w *WAL) Save(st raftpb.HardState, ents []raftpb.Entry) error {
 	w.mu.Lock()
-	defer w.mu.Unlock()
-
 	// short cut, do not call sync
 	if raft.IsEmptyHardState(st) && len(ents) == 0 {
+		w.mu.Unlock()
 		return nil
 	}
+	n, full, err := w.save(st, ents)
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if full {
+		// TODO: add a test for this code path when refactoring the tests
+		return w.cutIfFull()
+	}
+	return w.syncTo(n)
+}
 
+// save writes the given state and entries into the WAL. It returns the
+// number of the batch written, and whether the current file is full.
+func (w *WAL) save(st raftpb.HardState, ents []raftpb.Entry) (uint64, bool, error) {
 	// TODO(xiangli): no more reference operator
 	for i := range ents {
 		if err := w.saveEntry(&ents[i]); err != nil {
-			return err
+			return 0, false, err
 		}
 	}
 	if err := w.saveState(&st); err != nil {
-		return err
+		return 0, false, err
 	}
+	w.written++
+
+	fstat, err := w.f.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	return w.written, fstat.Size() >= segmentSizeBytes, nil
+}
+
+// cutIfFull cuts the current file if it is full, or only syncs it if
+// a concurrent Save has cut it meanwhile.
+func (w *WAL) cutIfFull() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
 	fstat, err := w.f.Stat()
 	if err != nil {
 		return err
 	}
 	if fstat.Size() < segmentSizeBytes {
-		return w.sync()
+		err = w.sync()
+	} else {
+		err = w.cut()
+	}
+	if err != nil {
+		return err
 	}
-	// TODO: add a test for this code path when refactoring the tests
-	return w.cut()
+	w.synced = w.written
+	return nil
 }
 
 func (w *WAL) SaveSnapshot(e walpb.Snapshot) error {
 	w.mu.Lock()
-	defer w.mu.Unlock()
-
 	b := pbutil.MustMarshal(&e)
 	rec := &walpb.Record{Type: snapshotType, Data: b}
 	if err := w.encoder.encode(rec); err != nil {
+		w.mu.Unlock()
 		return err
 	}
 	// update enti only when snapshot is ahead of last index
@@ -540,9 +764,30 @@ func (w *WAL) SaveSnapshot(e walpb.Snapshot) error {
 		w.enti = e.Index
 	}
 	lastIndexSaved.Set(float64(w.enti))
-	return w.sync()
+	w.written++
+	n := w.written
+	w.mu.Unlock()
+	return w.syncTo(n)
 }
 
 func (w *WAL) saveCrc(prevCrc uint32) error {
 	return w.encoder.encode(&walpb.Record{Type: crcType, Crc: prevCrc})
 }
+
+// saveEncryptionHeader generates the data key of the current file, if the
+// WAL is encrypted, and saves its header. The following records of the file
+// are sealed with it.
+func (w *WAL) saveEncryptionHeader() error {
+	if w.kp == nil {
+		return nil
+	}
+	dk, h, err := encryption.NewDataKey(w.kp)
+	if err != nil {
+		return err
+	}
+	if err := w.encoder.encode(&walpb.Record{Type: encryptionType, Data: h.Marshal()}); err != nil {
+		return err
+	}
+	w.encoder.dk = dk
+	return nil
+}
@@ -17,6 +17,8 @@ package wal
 import (
 	"io/ioutil"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/coreos/etcd/raft/raftpb"
@@ -34,6 +36,14 @@ func BenchmarkWrite1000EntryBatch100(b *testing.B)     { benchmarkWriteEntry(b,
 func BenchmarkWrite1000EntryBatch500(b *testing.B)     { benchmarkWriteEntry(b, 1000, 500) }
 func BenchmarkWrite1000EntryBatch1000(b *testing.B)    { benchmarkWriteEntry(b, 1000, 1000) }
 
+func BenchmarkSave100EntryFsync(b *testing.B)     { benchmarkSave(b, 100, SyncFsync, 1) }
+func BenchmarkSave100EntryFdatasync(b *testing.B) { benchmarkSave(b, 100, SyncFdatasync, 1) }
+func BenchmarkSave100EntryNoSync(b *testing.B)    { benchmarkSave(b, 100, SyncNone, 1) }
+
+func BenchmarkSave100EntryFsyncConcurrent8(b *testing.B)     { benchmarkSave(b, 100, SyncFsync, 8) }
+func BenchmarkSave100EntryFdatasyncConcurrent8(b *testing.B) { benchmarkSave(b, 100, SyncFdatasync, 8) }
+func BenchmarkSave100EntryNoSyncConcurrent8(b *testing.B)    { benchmarkSave(b, 100, SyncNone, 8) }
+
 func benchmarkWriteEntry(b *testing.B, size int, batch int) {
 	p, err := ioutil.TempDir(os.TempDir(), "waltest")
 	if err != nil {
@@ -66,3 +76,43 @@ func benchmarkWriteEntry(b *testing.B, size int, batch int) {
 		}
 	}
 }
+
+// benchmarkSave saves entries of the given size with the given sync
+// policy from the given number of concurrent savers, whose syncs are
+// grouped by the WAL.
+func benchmarkSave(b *testing.B, size int, policy SyncPolicy, savers int) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	w, err := CreateWithOptions(p, []byte("somedata"), Options{SyncPolicy: policy})
+	if err != nil {
+		b.Fatalf("err = %v, want nil", err)
+	}
+	defer w.Close()
+	data := make([]byte, size)
+	for i := 0; i < len(data); i++ {
+		data[i] = byte(i)
+	}
+	ents := []raftpb.Entry{{Data: data}}
+
+	b.ResetTimer()
+	b.SetBytes(int64(ents[0].Size()))
+	left := int64(b.N)
+	var wg sync.WaitGroup
+	for i := 0; i < savers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.AddInt64(&left, -1) >= 0 {
+				if err := w.Save(raftpb.HardState{}, ents); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
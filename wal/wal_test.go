@@ -449,6 +449,75 @@ func TestOpenForRead(t *testing.T) {
 	}
 }
 
+func TestOpenSegments(t *testing.T) {
+	p, err := ioutil.TempDir(os.TempDir(), "waltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	w, err := Create(path.Join(p, "wal"), []byte("metadata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 0; i < 10; i++ {
+		if err = w.SaveSnapshot(walpb.Snapshot{Index: uint64(i)}); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Save(raftpb.HardState{}, []raftpb.Entry{{Index: uint64(i)}}); err != nil {
+			t.Fatal(err)
+		}
+		if i != 9 {
+			if err = w.cut(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	fs, size, err := w.OpenSegments(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 6 {
+		t.Fatalf("len(files) = %d, want %d", len(fs), 6)
+	}
+	// the entry saved after the files are opened is not copied.
+	if err = w.Save(raftpb.HardState{}, []raftpb.Entry{{Index: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	bdir := path.Join(p, "backup")
+	if err = os.Mkdir(bdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range fs {
+		b, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == len(fs)-1 {
+			b = b[:size]
+		}
+		if err = ioutil.WriteFile(path.Join(bdir, path.Base(f.Name())), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := OpenForRead(bdir, walpb.Snapshot{Index: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	_, _, ents, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if len(ents) != 5 || ents[0].Index != 5 || ents[4].Index != 9 {
+		t.Errorf("ents = %+v, want the entries 5 to 9", ents)
+	}
+}
+
 func TestSaveEmpty(t *testing.T) {
 	var buf bytes.Buffer
 	var est raftpb.HardState